@@ -0,0 +1,253 @@
+// Package admin provides an HTTP endpoint for monitoring a running database:
+// health, buffer pool statistics, active transactions, lock waits, the WAL position,
+// latency distributions, table access counters and pprof.
+// It is meant to be served on a separate, non-public address next to the database server.
+package admin
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/pprof"
+	"sort"
+	"time"
+
+	"github.com/Johniel/gorelly/buffer"
+	"github.com/Johniel/gorelly/catalog"
+	"github.com/Johniel/gorelly/histogram"
+	"github.com/Johniel/gorelly/transaction"
+)
+
+// Sources are the components the endpoint reports on. Any of them may be nil; the
+// corresponding path then responds with 404 Not Found.
+type Sources struct {
+	Buffers      *buffer.BufferPoolManager       // Served at /buffer
+	Transactions *transaction.TransactionManager // Served at /transactions
+	Locks        *transaction.LockManager        // Served at /locks
+	Log          *transaction.LogManager         // Served at /wal
+	Catalog      *catalog.CatalogManager         // Served at /tables
+}
+
+// BufferStatus is the response of /buffer.
+type BufferStatus struct {
+	Pool buffer.PoolStats `json:"pool"`
+	IO   buffer.IOStats   `json:"io"`
+}
+
+// TransactionStatus is an element of the response of /transactions.
+type TransactionStatus struct {
+	ID        uint64    `json:"id"`
+	State     string    `json:"state"`
+	Isolation string    `json:"isolation"`
+	StartTime time.Time `json:"start_time"`
+}
+
+// LockWaitStatus is an element of the response of /locks.
+type LockWaitStatus struct {
+	TxnID      uint64   `json:"txn_id"`
+	Mode       string   `json:"mode"`
+	Target     string   `json:"target"`
+	WaitingFor []uint64 `json:"waiting_for"`
+}
+
+// WALStatus is the response of /wal.
+type WALStatus struct {
+	LastLSN uint64 `json:"last_lsn"`
+}
+
+// TableStatus is an element of the response of /tables: the access counters of a table.
+type TableStatus struct {
+	Name          string  `json:"name"`
+	SeqScans      uint64  `json:"seq_scans"`
+	IndexScans    uint64  `json:"index_scans"`
+	RowsRead      uint64  `json:"rows_read"`
+	RowsWritten   uint64  `json:"rows_written"`
+	CacheHitRatio float64 `json:"cache_hit_ratio"`
+}
+
+// LatencySummary summarizes a latency distribution. Durations are in nanoseconds;
+// percentiles are upper bounds accurate to within about 6%.
+type LatencySummary struct {
+	Count  uint64 `json:"count"`
+	MeanNs int64  `json:"mean_ns"`
+	P50Ns  int64  `json:"p50_ns"`
+	P90Ns  int64  `json:"p90_ns"`
+	P99Ns  int64  `json:"p99_ns"`
+	P999Ns int64  `json:"p999_ns"`
+	MaxNs  int64  `json:"max_ns"`
+}
+
+// LatencyStatus is the response of /latency. Distributions of missing sources are omitted.
+type LatencyStatus struct {
+	PageFetchHit  *LatencySummary `json:"page_fetch_hit,omitempty"`  // From Buffers
+	PageFetchMiss *LatencySummary `json:"page_fetch_miss,omitempty"` // From Buffers
+	LockWait      *LatencySummary `json:"lock_wait,omitempty"`       // From Locks
+	Commit        *LatencySummary `json:"commit,omitempty"`          // From Transactions
+}
+
+// NewHandler returns the handler of the endpoint. Besides the JSON paths above, it serves
+// /health, which always responds 200 OK while the process is up, and the profiles of
+// net/http/pprof under /debug/pprof/.
+func NewHandler(src Sources) http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /health", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, map[string]string{"status": "ok"})
+	})
+	if src.Buffers != nil {
+		mux.HandleFunc("GET /buffer", func(w http.ResponseWriter, r *http.Request) {
+			writeJSON(w, BufferStatus{Pool: src.Buffers.PoolStats(), IO: src.Buffers.IOStats()})
+		})
+	}
+	if src.Transactions != nil {
+		mux.HandleFunc("GET /transactions", func(w http.ResponseWriter, r *http.Request) {
+			txns := make([]TransactionStatus, 0)
+			for _, txn := range src.Transactions.ActiveTransactions() {
+				txns = append(txns, TransactionStatus{
+					ID:        uint64(txn.ID),
+					State:     stateName(txn.State),
+					Isolation: isolationName(txn.Isolation),
+					StartTime: txn.StartTime,
+				})
+			}
+			writeJSON(w, txns)
+		})
+	}
+	if src.Locks != nil {
+		mux.HandleFunc("GET /locks", func(w http.ResponseWriter, r *http.Request) {
+			waits := make([]LockWaitStatus, 0)
+			for _, wait := range src.Locks.Waits() {
+				status := LockWaitStatus{
+					TxnID:      uint64(wait.TxnID),
+					Mode:       modeName(wait.Mode),
+					Target:     targetName(wait.Target),
+					WaitingFor: make([]uint64, 0, len(wait.WaitingFor)),
+				}
+				for _, txnID := range wait.WaitingFor {
+					status.WaitingFor = append(status.WaitingFor, uint64(txnID))
+				}
+				waits = append(waits, status)
+			}
+			writeJSON(w, waits)
+		})
+	}
+	if src.Log != nil {
+		mux.HandleFunc("GET /wal", func(w http.ResponseWriter, r *http.Request) {
+			writeJSON(w, WALStatus{LastLSN: src.Log.LastLSN()})
+		})
+	}
+	if src.Catalog != nil {
+		mux.HandleFunc("GET /tables", func(w http.ResponseWriter, r *http.Request) {
+			snapshots := src.Catalog.AccessSnapshots()
+			names := make([]string, 0, len(snapshots))
+			for name := range snapshots {
+				names = append(names, name)
+			}
+			sort.Strings(names)
+			tables := make([]TableStatus, 0, len(names))
+			for _, name := range names {
+				s := snapshots[name]
+				tables = append(tables, TableStatus{
+					Name:          name,
+					SeqScans:      s.SeqScans,
+					IndexScans:    s.IndexScans,
+					RowsRead:      s.RowsRead,
+					RowsWritten:   s.RowsWritten(),
+					CacheHitRatio: s.CacheHitRatio(),
+				})
+			}
+			writeJSON(w, tables)
+		})
+	}
+	if src.Buffers != nil || src.Locks != nil || src.Transactions != nil {
+		mux.HandleFunc("GET /latency", func(w http.ResponseWriter, r *http.Request) {
+			var status LatencyStatus
+			if src.Buffers != nil {
+				fetch := src.Buffers.FetchLatency()
+				status.PageFetchHit = summarize(fetch.Hit)
+				status.PageFetchMiss = summarize(fetch.Miss)
+			}
+			if src.Locks != nil {
+				status.LockWait = summarize(src.Locks.WaitLatency())
+			}
+			if src.Transactions != nil {
+				status.Commit = summarize(src.Transactions.CommitLatency())
+			}
+			writeJSON(w, status)
+		})
+	}
+	mux.HandleFunc("/debug/pprof/", pprof.Index)
+	mux.HandleFunc("/debug/pprof/cmdline", pprof.Cmdline)
+	mux.HandleFunc("/debug/pprof/profile", pprof.Profile)
+	mux.HandleFunc("/debug/pprof/symbol", pprof.Symbol)
+	mux.HandleFunc("/debug/pprof/trace", pprof.Trace)
+	return mux
+}
+
+func writeJSON(w http.ResponseWriter, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
+
+func summarize(s histogram.Snapshot) *LatencySummary {
+	return &LatencySummary{
+		Count:  s.Count,
+		MeanNs: int64(s.Mean()),
+		P50Ns:  int64(s.Quantile(0.5)),
+		P90Ns:  int64(s.Quantile(0.9)),
+		P99Ns:  int64(s.Quantile(0.99)),
+		P999Ns: int64(s.Quantile(0.999)),
+		MaxNs:  int64(s.Max),
+	}
+}
+
+func stateName(state transaction.TransactionState) string {
+	switch state {
+	case transaction.TransactionStateActive:
+		return "active"
+	case transaction.TransactionStateCommitted:
+		return "committed"
+	case transaction.TransactionStateFailed:
+		return "failed"
+	case transaction.TransactionStateAborted:
+		return "aborted"
+	case transaction.TransactionStateTerminated:
+		return "terminated"
+	default:
+		return fmt.Sprintf("unknown(%d)", state)
+	}
+}
+
+func isolationName(level transaction.IsolationLevel) string {
+	switch level {
+	case transaction.IsolationReadCommitted:
+		return "read committed"
+	case transaction.IsolationRepeatableRead:
+		return "repeatable read"
+	default:
+		return fmt.Sprintf("unknown(%d)", level)
+	}
+}
+
+func modeName(mode transaction.LockMode) string {
+	if mode == transaction.LockModeExclusive {
+		return "exclusive"
+	}
+	return "shared"
+}
+
+// targetName describes a locked resource. Keys are shown in hex, since they are encoded.
+func targetName(target any) string {
+	switch t := target.(type) {
+	case transaction.RID:
+		return fmt.Sprintf("page %d slot %d", t.PageID, t.SlotID)
+	case transaction.KeyLock:
+		if t.Supremum {
+			return fmt.Sprintf("tree %d supremum", t.TreeMetaPageID)
+		}
+		return fmt.Sprintf("tree %d key %x", t.TreeMetaPageID, t.Key)
+	default:
+		return fmt.Sprint(target)
+	}
+}
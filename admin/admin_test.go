@@ -0,0 +1,178 @@
+package admin
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/Johniel/gorelly/buffer"
+	"github.com/Johniel/gorelly/disk"
+	"github.com/Johniel/gorelly/transaction"
+)
+
+func TestHandler(t *testing.T) {
+	tmpfile, err := os.CreateTemp("", "test_admin_*.db")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(tmpfile.Name())
+
+	dm, err := disk.NewDiskManager(tmpfile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer dm.Close()
+
+	logfile, err := os.CreateTemp("", "test_admin_*.log")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(logfile.Name())
+	logfile.Close()
+
+	lm, err := transaction.NewLogManager(logfile.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer lm.Close()
+
+	bufmgr := buffer.NewBufferPoolManager(dm, buffer.NewBufferPool(10))
+	if _, err := bufmgr.CreateBuffer(); err != nil {
+		t.Fatal(err)
+	}
+	lockManager := transaction.NewLockManager()
+	tm := transaction.NewTransactionManagerWithManagers(lm, lockManager, nil)
+
+	// txn2 waits for the lock txn1 holds
+	txn1 := tm.Begin()
+	txn2 := tm.Begin()
+	key := transaction.KeyLock{TreeMetaPageID: 3, Key: "\x01"}
+	if err := lockManager.LockKeyExclusive(txn1, key); err != nil {
+		t.Fatal(err)
+	}
+	locked := make(chan error)
+	go func() { locked <- lockManager.LockKeyShared(txn2, key) }()
+	for len(lockManager.Waits()) == 0 {
+		time.Sleep(time.Millisecond)
+	}
+
+	server := httptest.NewServer(NewHandler(Sources{
+		Buffers:      bufmgr,
+		Transactions: tm,
+		Locks:        lockManager,
+		Log:          lm,
+	}))
+	defer server.Close()
+
+	get := func(t *testing.T, path string, v any) {
+		t.Helper()
+		resp, err := http.Get(server.URL + path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			t.Fatalf("GET %s: status %d", path, resp.StatusCode)
+		}
+		if v != nil {
+			if err := json.NewDecoder(resp.Body).Decode(v); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+
+	t.Run("Health", func(t *testing.T) {
+		var health map[string]string
+		get(t, "/health", &health)
+		if health["status"] != "ok" {
+			t.Errorf("expected status ok, got %v", health)
+		}
+	})
+
+	t.Run("Buffer", func(t *testing.T) {
+		var status BufferStatus
+		get(t, "/buffer", &status)
+		if status.Pool.Frames != 10 || status.Pool.Cached != 1 || status.Pool.Dirty != 1 {
+			t.Errorf("unexpected pool stats %+v", status.Pool)
+		}
+	})
+
+	t.Run("Transactions", func(t *testing.T) {
+		var txns []TransactionStatus
+		get(t, "/transactions", &txns)
+		if len(txns) != 2 || txns[0].ID != uint64(txn1.ID) || txns[1].ID != uint64(txn2.ID) || txns[0].State != "active" {
+			t.Errorf("unexpected transactions %+v", txns)
+		}
+	})
+
+	t.Run("Locks", func(t *testing.T) {
+		var waits []LockWaitStatus
+		get(t, "/locks", &waits)
+		expected := []LockWaitStatus{{
+			TxnID:      uint64(txn2.ID),
+			Mode:       "shared",
+			Target:     "tree 3 key 01",
+			WaitingFor: []uint64{uint64(txn1.ID)},
+		}}
+		if !reflect.DeepEqual(waits, expected) {
+			t.Errorf("expected %+v, got %+v", expected, waits)
+		}
+	})
+
+	t.Run("WAL", func(t *testing.T) {
+		var status WALStatus
+		get(t, "/wal", &status)
+		if status.LastLSN != 2 { // Two Begin records
+			t.Errorf("expected last LSN 2, got %d", status.LastLSN)
+		}
+	})
+
+	t.Run("Pprof", func(t *testing.T) {
+		get(t, "/debug/pprof/goroutine?debug=1", nil)
+	})
+
+	if err := tm.Commit(txn1); err != nil {
+		t.Fatal(err)
+	}
+	if err := <-locked; err != nil {
+		t.Fatal(err)
+	}
+	if err := tm.Commit(txn2); err != nil {
+		t.Fatal(err)
+	}
+	var waits []LockWaitStatus
+	get(t, "/locks", &waits)
+	if len(waits) != 0 {
+		t.Errorf("expected no lock waits, got %+v", waits)
+	}
+
+	// Both lock requests, the waiting one included, and both commits are in the distributions
+	var latency LatencyStatus
+	get(t, "/latency", &latency)
+	if latency.LockWait == nil || latency.LockWait.Count != 2 || latency.LockWait.MaxNs < int64(time.Millisecond) {
+		t.Errorf("expected 2 lock acquisitions, one waiting for at least 1ms, got %+v", latency.LockWait)
+	}
+	if latency.Commit == nil || latency.Commit.Count != 2 {
+		t.Errorf("expected 2 commits, got %+v", latency.Commit)
+	}
+	if latency.PageFetchHit == nil || latency.PageFetchMiss == nil || latency.PageFetchMiss.Count != 0 {
+		t.Errorf("expected page fetch distributions without misses, got %+v and %+v", latency.PageFetchHit, latency.PageFetchMiss)
+	}
+}
+
+func TestHandlerMissingSource(t *testing.T) {
+	server := httptest.NewServer(NewHandler(Sources{}))
+	defer server.Close()
+	resp, err := http.Get(server.URL + "/buffer")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusNotFound {
+		t.Errorf("expected 404 without a buffer pool, got %d", resp.StatusCode)
+	}
+}
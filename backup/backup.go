@@ -0,0 +1,228 @@
+// Package backup copies a running database into a self-describing backup directory and
+// verifies such backups without restoring them.
+//
+// A backup directory holds a copy of the heap file, a copy of the write-ahead log and a
+// manifest describing both. The heap copy is taken while page writes are frozen and every
+// change in it is logged in the WAL copy, so restoring the backup is opening both copies
+// and recovering from the log, which redoes the committed changes and undoes the others.
+package backup
+
+import (
+	"bufio"
+	"encoding/json"
+	"hash/crc32"
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/Johniel/gorelly/buffer"
+	"github.com/Johniel/gorelly/disk"
+	"github.com/Johniel/gorelly/errcode"
+	"github.com/Johniel/gorelly/transaction"
+)
+
+var (
+	// ErrCorrupted is returned by Verify when the copies of a backup do not match its manifest.
+	ErrCorrupted = errcode.New(errcode.Corruption, "backup does not match its manifest")
+	// ErrUnsupportedFormat is returned by Verify for a manifest of an unknown format version.
+	ErrUnsupportedFormat = errcode.New(errcode.InvalidParameter, "unsupported backup format version")
+)
+
+// FormatVersion is the version of the backup layout and manifest written by Backup.
+const FormatVersion = 1
+
+// Names of the files in a backup directory.
+const (
+	HeapFileName     = "heap.rly"
+	LogFileName      = "wal.log"
+	ManifestFileName = "manifest.json"
+)
+
+// Manifest describes a backup. It is written last, so a backup without one is incomplete.
+type Manifest struct {
+	FormatVersion int       `json:"format_version"`
+	CreatedAt     time.Time `json:"created_at"`
+	// Number of bytes a page takes in the heap copy (more than disk.PageSize if it is encrypted)
+	PageSize      int64    `json:"page_size"`
+	NumPages      uint64   `json:"num_pages"`
+	PageChecksums []uint32 `json:"page_checksums"` // CRC-32C of every page of the heap copy
+	// Records of the WAL copy; CheckpointLSN is that of the last checkpoint, which recovery of
+	// the restored database starts from
+	WAL     transaction.LogRange `json:"wal"`
+	WALSize int64                `json:"wal_size"` // Size of the WAL copy in bytes
+}
+
+var checksumTable = crc32.MakeTable(crc32.Castagnoli)
+
+// Backup copies the heap file of dm and the log of lm into dir, which is created and must not
+// exist yet, and returns the manifest it writes there. bufmgr must be the buffer pool manager
+// of dm. Dirty pages are flushed first; page writes and log appends wait until the copies are
+// taken, reads go on.
+func Backup(dir string, bufmgr *buffer.BufferPoolManager, dm *disk.DiskManager, lm *transaction.LogManager) (*Manifest, error) {
+	if err := os.Mkdir(dir, 0755); err != nil {
+		return nil, err
+	}
+	if err := bufmgr.FreezeWrites(); err != nil {
+		return nil, err
+	}
+	defer bufmgr.ThawWrites()
+
+	manifest := &Manifest{
+		FormatVersion: FormatVersion,
+		CreatedAt:     time.Now().UTC(),
+		PageSize:      dm.RawPageSize(),
+		NumPages:      uint64(dm.NumPages()),
+	}
+	// The heap copy may hold changes logged up to now, so the log is copied after it
+	err := writeFile(filepath.Join(dir, HeapFileName), func(w io.Writer) error {
+		raw := make([]byte, manifest.PageSize)
+		for pageID := disk.PageID(0); uint64(pageID) < manifest.NumPages; pageID++ {
+			if err := dm.ReadRawPage(pageID, raw); err != nil {
+				return err
+			}
+			manifest.PageChecksums = append(manifest.PageChecksums, crc32.Checksum(raw, checksumTable))
+			if _, err := w.Write(raw); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	err = writeFile(filepath.Join(dir, LogFileName), func(w io.Writer) error {
+		counter := &countingWriter{w: w}
+		var err error
+		manifest.WAL, err = lm.CopyLog(counter)
+		manifest.WALSize = counter.n
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	err = writeFile(filepath.Join(dir, ManifestFileName), func(w io.Writer) error {
+		encoder := json.NewEncoder(w)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(manifest)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return manifest, syncDir(dir)
+}
+
+// Verify checks a backup directory without restoring it: the heap copy must have the size and
+// page checksums recorded in the manifest, and the WAL copy must be a continuous sequence of
+// intact records with the recorded range. It returns the manifest of the backup, and
+// ErrCorrupted, with the manifest, if the copies do not match it.
+func Verify(dir string) (*Manifest, error) {
+	data, err := os.ReadFile(filepath.Join(dir, ManifestFileName))
+	if err != nil {
+		return nil, err
+	}
+	manifest := &Manifest{}
+	if err := json.Unmarshal(data, manifest); err != nil {
+		return nil, ErrCorrupted
+	}
+	if manifest.FormatVersion != FormatVersion {
+		return manifest, ErrUnsupportedFormat
+	}
+	if manifest.PageSize < disk.PageSize || uint64(len(manifest.PageChecksums)) != manifest.NumPages {
+		return manifest, ErrCorrupted
+	}
+
+	heapFile, err := os.Open(filepath.Join(dir, HeapFileName))
+	if err != nil {
+		return manifest, err
+	}
+	defer heapFile.Close()
+	stat, err := heapFile.Stat()
+	if err != nil {
+		return manifest, err
+	}
+	if stat.Size() != int64(manifest.NumPages)*manifest.PageSize {
+		return manifest, ErrCorrupted
+	}
+	reader := bufio.NewReader(heapFile)
+	raw := make([]byte, manifest.PageSize)
+	for _, checksum := range manifest.PageChecksums {
+		if _, err := io.ReadFull(reader, raw); err != nil {
+			return manifest, err
+		}
+		if crc32.Checksum(raw, checksumTable) != checksum {
+			return manifest, ErrCorrupted
+		}
+	}
+
+	logFile, err := os.Open(filepath.Join(dir, LogFileName))
+	if err != nil {
+		return manifest, err
+	}
+	defer logFile.Close()
+	counter := &countingReader{r: bufio.NewReader(logFile)}
+	lr, err := transaction.ScanLog(counter)
+	if err == transaction.ErrLogCorrupted {
+		return manifest, ErrCorrupted
+	}
+	if err != nil {
+		return manifest, err
+	}
+	if lr != manifest.WAL || counter.n != manifest.WALSize {
+		return manifest, ErrCorrupted
+	}
+	return manifest, nil
+}
+
+// writeFile creates the file at path, writes it with write and syncs it.
+func writeFile(path string, write func(w io.Writer) error) error {
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_EXCL, 0644)
+	if err != nil {
+		return err
+	}
+	w := bufio.NewWriter(file)
+	if err := write(w); err != nil {
+		file.Close()
+		return err
+	}
+	if err := w.Flush(); err != nil {
+		file.Close()
+		return err
+	}
+	if err := file.Sync(); err != nil {
+		file.Close()
+		return err
+	}
+	return file.Close()
+}
+
+func syncDir(dir string) error {
+	file, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	return file.Sync()
+}
+
+type countingWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (cw *countingWriter) Write(p []byte) (int, error) {
+	n, err := cw.w.Write(p)
+	cw.n += int64(n)
+	return n, err
+}
+
+type countingReader struct {
+	r io.Reader
+	n int64
+}
+
+func (cr *countingReader) Read(p []byte) (int, error) {
+	n, err := cr.r.Read(p)
+	cr.n += int64(n)
+	return n, err
+}
@@ -0,0 +1,176 @@
+package backup
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/Johniel/gorelly/btree"
+	"github.com/Johniel/gorelly/buffer"
+	"github.com/Johniel/gorelly/disk"
+	"github.com/Johniel/gorelly/table"
+	"github.com/Johniel/gorelly/transaction"
+)
+
+func TestBackupAndVerify(t *testing.T) {
+	tmpdir := t.TempDir()
+	dm, err := disk.OpenDiskManager(filepath.Join(tmpdir, "test.rly"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer dm.Close()
+	lm, err := transaction.OpenLogManager(filepath.Join(tmpdir, "test.log"), transaction.SyncPolicy{Mode: transaction.SyncModeNone})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer lm.Close()
+
+	bufmgr := buffer.NewBufferPoolManager(dm, buffer.NewBufferPool(8))
+	bufmgr.SetWAL(lm)
+	users := &table.Table{MetaPageID: disk.InvalidPageID, NumKeyElems: 1}
+	if err := users.Create(bufmgr); err != nil {
+		t.Fatal(err)
+	}
+	if err := bufmgr.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	tm := transaction.NewTransactionManagerWithManagers(lm, nil, nil)
+	insert := func(from int, to int) *transaction.Transaction {
+		txn := tm.Begin()
+		tbl := *users
+		tbl.Log = transaction.NewPageLog(lm, txn)
+		for i := from; i < to; i++ {
+			if err := tbl.Insert(bufmgr, [][]byte{[]byte(fmt.Sprintf("user%04d", i)), []byte("payload")}); err != nil {
+				t.Fatal(err)
+			}
+		}
+		return txn
+	}
+	if err := tm.Commit(insert(0, 200)); err != nil {
+		t.Fatal(err)
+	}
+	if err := lm.Checkpoint(bufmgr); err != nil {
+		t.Fatal(err)
+	}
+	if err := tm.Commit(insert(200, 300)); err != nil {
+		t.Fatal(err)
+	}
+	// Changes of a transaction in progress are in the heap copy, and undone on restore
+	insert(300, 350)
+
+	dir := filepath.Join(tmpdir, "backup")
+	manifest, err := Backup(dir, bufmgr, dm, lm)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if manifest.NumPages != uint64(dm.NumPages()) || manifest.PageSize != disk.PageSize {
+		t.Errorf("expected %d pages of %d bytes, got %d of %d", dm.NumPages(), disk.PageSize, manifest.NumPages, manifest.PageSize)
+	}
+	if manifest.WAL.FirstLSN != 1 || manifest.WAL.LastLSN != lm.LastLSN() || manifest.WAL.CheckpointLSN == 0 {
+		t.Errorf("expected the WAL range up to %d with a checkpoint, got %+v", lm.LastLSN(), manifest.WAL)
+	}
+	if _, err := Backup(dir, bufmgr, dm, lm); !os.IsExist(err) {
+		t.Errorf("expected an existing backup not to be overwritten, got %v", err)
+	}
+	verified, err := Verify(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if verified.WAL != manifest.WAL || len(verified.PageChecksums) != len(manifest.PageChecksums) {
+		t.Errorf("expected Verify to return the manifest, got %+v", verified.WAL)
+	}
+
+	t.Run("Restore", func(t *testing.T) {
+		restoreDir := t.TempDir()
+		for _, name := range []string{HeapFileName, LogFileName} {
+			data, err := os.ReadFile(filepath.Join(dir, name))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := os.WriteFile(filepath.Join(restoreDir, name), data, 0644); err != nil {
+				t.Fatal(err)
+			}
+		}
+		dm, err := disk.OpenDiskManager(filepath.Join(restoreDir, HeapFileName))
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer dm.Close()
+		lm, err := transaction.NewLogManager(filepath.Join(restoreDir, LogFileName))
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer lm.Close()
+		bufmgr := buffer.NewBufferPoolManager(dm, buffer.NewBufferPool(8))
+		if err := transaction.NewRecoveryManager(lm, bufmgr).Recover(); err != nil {
+			t.Fatal(err)
+		}
+		iter, err := btree.NewBTree(users.MetaPageID).Search(bufmgr, btree.NewSearchModeStart())
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer iter.Close()
+		n := 0
+		for {
+			_, _, ok, err := iter.Next(bufmgr)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !ok {
+				break
+			}
+			n++
+		}
+		if n != 300 {
+			t.Errorf("expected the 300 committed rows, got %d", n)
+		}
+	})
+
+	// corrupt copies the backup, applies change to one of its files and verifies the copy
+	corrupt := func(name string, change func(data []byte) []byte) error {
+		t.Helper()
+		copyDir := t.TempDir()
+		for _, file := range []string{HeapFileName, LogFileName, ManifestFileName} {
+			data, err := os.ReadFile(filepath.Join(dir, file))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if file == name {
+				data = change(data)
+			}
+			if err := os.WriteFile(filepath.Join(copyDir, file), data, 0644); err != nil {
+				t.Fatal(err)
+			}
+		}
+		_, err := Verify(copyDir)
+		return err
+	}
+	if err := corrupt(HeapFileName, func(data []byte) []byte {
+		data[disk.PageSize+100] ^= 1
+		return data
+	}); err != ErrCorrupted {
+		t.Errorf("expected ErrCorrupted for a flipped heap bit, got %v", err)
+	}
+	if err := corrupt(HeapFileName, func(data []byte) []byte { return data[:len(data)-disk.PageSize] }); err != ErrCorrupted {
+		t.Errorf("expected ErrCorrupted for a missing page, got %v", err)
+	}
+	if err := corrupt(LogFileName, func(data []byte) []byte { return data[:len(data)-1] }); err != ErrCorrupted {
+		t.Errorf("expected ErrCorrupted for a cut WAL, got %v", err)
+	}
+	if err := corrupt(LogFileName, func(data []byte) []byte { return data[:len(data)/2] }); err != ErrCorrupted {
+		t.Errorf("expected ErrCorrupted for missing WAL records, got %v", err)
+	}
+	if err := corrupt(ManifestFileName, func(data []byte) []byte {
+		var m map[string]any
+		if err := json.Unmarshal(data, &m); err != nil {
+			t.Fatal(err)
+		}
+		m["format_version"] = FormatVersion + 1
+		data, _ = json.Marshal(m)
+		return data
+	}); err != ErrUnsupportedFormat {
+		t.Errorf("expected ErrUnsupportedFormat, got %v", err)
+	}
+}
@@ -0,0 +1,135 @@
+package btree
+
+import (
+	"bytes"
+
+	"github.com/Johniel/gorelly/buffer"
+)
+
+// InsertBatch inserts the pairs keys[i], values[i] and returns the error of each pair, in the
+// order of keys (nil for the inserted ones), or nil if every pair was inserted. The errors are
+// those of Insert, e.g. ErrDuplicateKey.
+//
+// Consecutive pairs in ascending key order that belong to the same leaf are inserted into it
+// with a single traversal of the tree, so sorted keys are inserted with a fraction of the page
+// fetches of one Insert per pair. A pair that needs a split or overflow pages is inserted by
+// Insert.
+func (bt *BTree) InsertBatch(bufmgr *buffer.BufferPoolManager, keys [][]byte, values [][]byte) []error {
+	var errs []error
+	setErr := func(i int, err error) {
+		if errs == nil {
+			errs = make([]error, len(keys))
+		}
+		errs[i] = err
+	}
+	limits := bt.Limits()
+	for i := 0; i < len(keys); {
+		if err := limits.check(keys[i], values[i]); err != nil {
+			setErr(i, err)
+			i++
+			continue
+		}
+		var n int
+		err := retryLocked(bt.Log, func() (err error) {
+			defer bt.acquireWriter(bufmgr, "InsertBatch")()
+			n, err = bt.insertRun(bufmgr, limits, keys[i:], values[i:], func(j int, err error) { setErr(i+j, err) })
+			return err
+		})
+		switch {
+		case err != nil && n == 0:
+			setErr(i, err)
+			n = 1
+		case err != nil:
+			// The pairs were inserted but their leaf could not be logged
+			for j := i; j < i+n; j++ {
+				if errs == nil || errs[j] == nil {
+					setErr(j, err)
+				}
+			}
+		case n == 0:
+			// The first pair does not fit in its leaf, or its value in any leaf
+			if err := retryLocked(bt.Log, func() error {
+				defer bt.acquireWriter(bufmgr, "InsertBatch")()
+				_, err := bt.insert(bufmgr, keys[i], values[i], false)
+				return err
+			}); err != nil {
+				setErr(i, err)
+			}
+			n = 1
+		}
+		i += n
+	}
+	return errs
+}
+
+// insertRun inserts the leading pairs that belong to the leaf of keys[0] into it, as long as they
+// are in ascending key order and fit without overflow pages, and returns their number. The errors of single pairs are
+// passed to setErr. It returns an error if the leaf cannot be fetched, with no pairs, or if it
+// cannot be logged once the pairs are inserted.
+func (bt *BTree) insertRun(bufmgr *buffer.BufferPoolManager, limits Limits, keys [][]byte, values [][]byte, setErr func(int, error)) (int, error) {
+	// Descend to the leaf, keeping the smallest separator above keys[0]: the keys of the leaf
+	// are below it
+	nodeBuffer, err := bt.FetchRootPage(bufmgr)
+	if err != nil {
+		return 0, err
+	}
+	var hi []byte
+	for {
+		node := NewNode(nodeBuffer.Page[:])
+		if node.IsLeaf() {
+			break
+		}
+		branchNode := node.AsBranch()
+		childIdx := branchNode.SearchChildIdx(keys[0])
+		if childIdx < branchNode.NumPairs() {
+			hi = branchNode.PairAt(childIdx).Key
+		}
+		childPageID := branchNode.ChildAt(childIdx)
+		nodeBuffer.Unpin()
+		if nodeBuffer, err = bufmgr.FetchBuffer(childPageID); err != nil {
+			return 0, err
+		}
+	}
+	defer nodeBuffer.Unpin()
+
+	nodeBuffer.WriteLatch()
+	if err := lockPage(bt.Log, nodeBuffer.PageID); err != nil {
+		nodeBuffer.WriteUnlatch(false)
+		return 0, err
+	}
+	var before []byte
+	if bt.Log != nil {
+		before = pageImage(nodeBuffer)
+	}
+	leafNode := NewNode(nodeBuffer.Page[:]).AsLeaf()
+	n := 0
+	modified := false
+	for ; n < len(keys); n++ {
+		key, value := keys[n], values[n]
+		if 0 < n && bytes.Compare(key, keys[n-1]) < 0 || hi != nil && 0 <= bytes.Compare(key, hi) {
+			break
+		}
+		if err := limits.check(key, value); err != nil {
+			setErr(n, err)
+			continue
+		}
+		if limits.needsOverflow(key, value) {
+			break
+		}
+		slotID, err := leafNode.SearchSlotID(key)
+		if err == nil {
+			setErr(n, ErrDuplicateKey)
+			continue
+		}
+		if !leafNode.Insert(slotID, key, value) {
+			break
+		}
+		modified = true
+	}
+	if modified {
+		nodeBuffer.MarkDirty()
+		err = logPage(bt.Log, nodeBuffer, before)
+	}
+	nodeBuffer.WriteUnlatch(modified)
+	return n, err
+}
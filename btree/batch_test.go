@@ -0,0 +1,118 @@
+package btree
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"testing"
+
+	"github.com/Johniel/gorelly/buffer"
+	"github.com/Johniel/gorelly/disk"
+)
+
+func TestBTreeInsertBatch(t *testing.T) {
+	tmpfile, err := os.CreateTemp("", "test_btree_insert_batch_*.db")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(tmpfile.Name())
+
+	dm, err := disk.NewDiskManager(tmpfile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer dm.Close()
+
+	pool := buffer.NewBufferPool(10)
+	bufmgr := buffer.NewBufferPoolManager(dm, pool)
+
+	bt, err := CreateBTree(bufmgr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Every other key first, then the rest, so the second batch falls between existing keys of
+	// many leaves
+	all := sortedPairs(5000, 8)
+	var evens, odds pairSlice
+	for i := range all.keys {
+		ps := &evens
+		if i%2 == 1 {
+			ps = &odds
+		}
+		ps.keys = append(ps.keys, all.keys[i])
+		ps.values = append(ps.values, all.values[i])
+	}
+	if errs := bt.InsertBatch(bufmgr, evens.keys, evens.values); errs != nil {
+		t.Fatalf("expected no errors, got %v", errs)
+	}
+	if errs := bt.InsertBatch(bufmgr, odds.keys, odds.values); errs != nil {
+		t.Fatalf("expected no errors, got %v", errs)
+	}
+	rootPageID, err := bt.rootPageID(bufmgr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkSubtree(t, bufmgr, rootPageID, nil, nil)
+	iter, err := bt.Search(bufmgr, NewSearchModeStart())
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := range all.keys {
+		key, value, ok, err := iter.Next(bufmgr)
+		if err != nil || !ok || !bytes.Equal(key, all.keys[i]) || !bytes.Equal(value, all.values[i]) {
+			t.Fatalf("expected pair %d, got %x, %v, %v", i, key, ok, err)
+		}
+	}
+	iter.Close()
+	if stats, err := bt.Stats(bufmgr); err != nil || stats.KeyCount != 5000 {
+		t.Errorf("expected 5000 keys, got %+v, %v", stats, err)
+	}
+
+	t.Run("Errors", func(t *testing.T) {
+		// Unsorted keys, a duplicate within the batch, an existing key and a key over the limit
+		keys := [][]byte{[]byte("m"), []byte("c"), []byte("c"), all.keys[10], bytes.Repeat([]byte{'x'}, bt.Limits().MaxKeySize+1), []byte("a")}
+		values := [][]byte{[]byte("1"), []byte("2"), []byte("3"), []byte("4"), []byte("5"), []byte("6")}
+		errs := bt.InsertBatch(bufmgr, keys, values)
+		if len(errs) != len(keys) {
+			t.Fatalf("expected %d errors, got %v", len(keys), errs)
+		}
+		for i, expected := range []error{nil, nil, ErrDuplicateKey, ErrDuplicateKey, ErrKeyTooLarge, nil} {
+			if !errors.Is(errs[i], expected) {
+				t.Errorf("pair %d: expected %v, got %v", i, expected, errs[i])
+			}
+		}
+		for _, key := range [][]byte{[]byte("a"), []byte("c"), []byte("m")} {
+			if _, err := bt.Get(bufmgr, key); err != nil {
+				t.Errorf("expected %q to be inserted, got %v", key, err)
+			}
+		}
+		if value, err := bt.Get(bufmgr, []byte("c")); err != nil || string(value) != "2" {
+			t.Errorf("expected the first pair of a duplicate key to win, got %q, %v", value, err)
+		}
+	})
+}
+
+func BenchmarkInsertBatch(b *testing.B) {
+	const numPairs = 100000
+	tmpfile, err := os.CreateTemp("", "bench_btree_insert_batch_*.db")
+	if err != nil {
+		b.Fatal(err)
+	}
+	b.Cleanup(func() { os.Remove(tmpfile.Name()) })
+	dm, err := disk.NewDiskManager(tmpfile)
+	if err != nil {
+		b.Fatal(err)
+	}
+	b.Cleanup(func() { dm.Close() })
+	bufmgr := buffer.NewBufferPoolManager(dm, buffer.NewBufferPool(64))
+	pairs := sortedPairs(numPairs, 8)
+	for b.Loop() {
+		bt, err := CreateBTree(bufmgr)
+		if err != nil {
+			b.Fatal(err)
+		}
+		if errs := bt.InsertBatch(bufmgr, pairs.keys, pairs.values); errs != nil {
+			b.Fatal(errs)
+		}
+	}
+}
@@ -3,23 +3,37 @@
 package btree
 
 import (
+	"bytes"
 	"errors"
 
+	"github.com/Johniel/gorelly/btree/internal"
+	"github.com/Johniel/gorelly/btree/leaf"
 	"github.com/Johniel/gorelly/buffer"
 	"github.com/Johniel/gorelly/disk"
+	"github.com/Johniel/gorelly/errcode"
 )
 
 var (
 	// ErrDuplicateKey is returned when attempting to insert a key that already exists.
-	ErrDuplicateKey = errors.New("duplicate key")
+	ErrDuplicateKey = errcode.New(errcode.ConstraintViolation, "duplicate key")
 	// ErrKeyNotFound is returned when attempting to update or delete a key that doesn't exist.
-	ErrKeyNotFound = errors.New("key not found")
+	ErrKeyNotFound = errcode.New(errcode.NotFound, "key not found")
 )
 
+// errLeafFull is returned by updateInternal when the new value does not fit in the leaf.
+var errLeafFull = errors.New("leaf full")
+
 // SearchMode specifies how to search in a B+ tree.
+// If End is set, the iterator stops at End instead of running to the end of the tree.
 type SearchMode struct {
-	IsStart bool   // If true, start from the beginning; if false, search for Key
-	Key     []byte // The key to search for (only used if IsStart is false)
+	IsStart      bool   // If true, start from the beginning; if false, search for Key
+	Key          []byte // The key to search for (only used if IsStart is false)
+	ExcludeKey   bool   // If true, start after Key rather than at it
+	End          []byte // Optional upper bound of the keys returned (nil means unbounded)
+	EndInclusive bool   // Whether a key equal to End is returned
+	// If true, the iterator moves in descending key order: it starts at the last key not greater
+	// than Key (the last key if IsStart, before Key if ExcludeKey) and End is a lower bound.
+	Reverse bool
 }
 
 func NewSearchModeStart() SearchMode {
@@ -30,63 +44,136 @@ func NewSearchModeKey(key []byte) SearchMode {
 	return SearchMode{IsStart: false, Key: key}
 }
 
+// NewSearchModeRange returns a search mode for the keys between start and end.
+// A nil start begins at the first key and a nil end runs to the last key;
+// the inclusive flags control whether keys equal to the bounds are returned.
+func NewSearchModeRange(start []byte, startInclusive bool, end []byte, endInclusive bool) SearchMode {
+	return SearchMode{
+		IsStart:      start == nil,
+		Key:          start,
+		ExcludeKey:   start != nil && !startInclusive,
+		End:          end,
+		EndInclusive: endInclusive,
+	}
+}
+
 // BTree represents a B+ tree index.
 // It stores key-value pairs in a balanced tree structure optimized for disk access.
 type BTree struct {
 	MetaPageID disk.PageID // Page ID of the meta page containing the root page ID
+	Log        PageLog     // Optional log of the pages modified by Insert, Update and Delete
 }
 
 func CreateBTree(bufmgr *buffer.BufferPoolManager) (*BTree, error) {
+	return CreateBTreeWithLog(bufmgr, nil)
+}
+
+// CreateBTreeWithLog creates a tree whose changes, its creation included, are logged to log.
+// The allocation and initialization of the meta and root pages are logged as a single record if
+// log is a CreateLog, and as the images of the new pages otherwise, so that a crash before the
+// pages are written back leaves no meta page pointing at garbage.
+func CreateBTreeWithLog(bufmgr *buffer.BufferPoolManager, log PageLog) (*BTree, error) {
 	metaBuffer, err := bufmgr.CreateBuffer()
 	if err != nil {
 		return nil, err
 	}
-	meta := NewMeta(metaBuffer.Page[:])
-
+	defer metaBuffer.Unpin()
 	rootBuffer, err := bufmgr.CreateBuffer()
 	if err != nil {
+		bufmgr.FreeBuffer(metaBuffer.PageID)
 		return nil, err
 	}
-	rootNode := NewNode(rootBuffer.Page[:])
-	rootNode.InitializeAsLeaf()
-	leafNode := rootNode.AsLeaf()
-	leafNode.Initialize()
+	defer rootBuffer.Unpin()
+	InitializeTree(metaBuffer.Page[:], rootBuffer.Page[:], rootBuffer.PageID)
+
+	if err := logCreate(log, metaBuffer, rootBuffer); err != nil {
+		freePages(bufmgr, log, []disk.PageID{rootBuffer.PageID, metaBuffer.PageID})
+		return nil, err
+	}
+	return &BTree{MetaPageID: metaBuffer.PageID, Log: log}, nil
+}
 
-	meta.SetRootPageID(rootBuffer.PageID)
-	return &BTree{MetaPageID: metaBuffer.PageID}, nil
+// InitializeTree initializes the meta page and the root page of an empty tree.
+func InitializeTree(metaPage []byte, rootPage []byte, rootPageID disk.PageID) {
+	clear(metaPage)
+	clear(rootPage)
+	rootNode := NewNode(rootPage)
+	rootNode.InitializeAsLeaf()
+	rootNode.AsLeaf().Initialize()
+	NewMeta(metaPage).SetRootPageID(rootPageID)
 }
 
 func NewBTree(metaPageId disk.PageID) *BTree {
 	return &BTree{MetaPageID: metaPageId}
 }
 
+// FetchRootPage fetches the root node of the tree. The returned buffer is pinned; the caller
+// must Unpin it when done.
 func (bt *BTree) FetchRootPage(bufmgr *buffer.BufferPoolManager) (*buffer.Buffer, error) {
-	metaBuffer, err := bufmgr.FetchBuffer(bt.MetaPageID)
+	rootPageId, err := bt.rootPageID(bufmgr)
 	if err != nil {
 		return nil, err
 	}
-	meta := NewMeta(metaBuffer.Page[:])
-	rootPageId := meta.RootPageID()
 	return bufmgr.FetchBuffer(rootPageId)
 }
 
+// rootPageID reads the page ID of the root node from the meta page.
+func (bt *BTree) rootPageID(bufmgr *buffer.BufferPoolManager) (disk.PageID, error) {
+	metaBuffer, err := bufmgr.FetchBuffer(bt.MetaPageID)
+	if err != nil {
+		return disk.InvalidPageID, err
+	}
+	defer metaBuffer.Unpin()
+	return NewMeta(metaBuffer.Page[:]).RootPageID(), nil
+}
+
 func (bt *BTree) Search(bufmgr *buffer.BufferPoolManager, searchMode SearchMode) (*Iter, error) {
-	rootPage, err := bt.FetchRootPage(bufmgr)
+	rootPageId, err := bt.rootPageID(bufmgr)
+	if err != nil {
+		return nil, err
+	}
+	rootPage, cached, err := bufmgr.FetchBufferCached(rootPageId)
 	if err != nil {
 		return nil, err
 	}
-	return bt.searchInternal(bufmgr, rootPage, searchMode)
+	var counts PageCounts
+	counts.add(cached)
+	return bt.searchInternal(bufmgr, rootPage, searchMode, counts)
 }
 
-func (bt *BTree) searchInternal(bufmgr *buffer.BufferPoolManager, nodeBuffer *buffer.Buffer, searchMode SearchMode) (*Iter, error) {
+// Get returns the value stored under exactly key. Unlike Search, which positions an iterator at
+// the nearest key, it returns ErrKeyNotFound if the key does not exist.
+func (bt *BTree) Get(bufmgr *buffer.BufferPoolManager, key []byte) ([]byte, error) {
+	iter, err := bt.Search(bufmgr, NewSearchModeKey(key))
+	if err != nil {
+		return nil, err
+	}
+	defer iter.Close()
+	foundKey, value, ok := iter.Get()
+	if iter.Err() != nil {
+		return nil, iter.Err()
+	}
+	if !ok || !bytes.Equal(foundKey, key) {
+		return nil, ErrKeyNotFound
+	}
+	return value, nil
+}
+
+// searchInternal searches the subtree rooted at nodeBuffer, taking over the caller's pin on it:
+// the pin of the leaf is passed on to the returned iterator, the others are released.
+// counts holds the pages fetched so far by the search.
+func (bt *BTree) searchInternal(bufmgr *buffer.BufferPoolManager, nodeBuffer *buffer.Buffer, searchMode SearchMode, counts PageCounts) (*Iter, error) {
 	node := NewNode(nodeBuffer.Page[:])
 
 	if node.IsLeaf() {
 		leafNode := node.AsLeaf()
 		slotID := 0
 		var err error
+		if searchMode.Reverse {
+			return bt.reverseIter(bufmgr, nodeBuffer, leafNode, searchMode, counts)
+		}
 		if !searchMode.IsStart {
-			slotID, err = leafNode.SearchSlotID(searchMode.Key)
+			slotID, err = searchLeaf(nodeBuffer, leafNode, searchMode.Key)
 			if err != nil {
 				// Not found, use insertion point
 			}
@@ -94,51 +181,164 @@ func (bt *BTree) searchInternal(bufmgr *buffer.BufferPoolManager, nodeBuffer *bu
 		isRightMost := leafNode.NumPairs() == slotID
 
 		iter := &Iter{
-			buffer: nodeBuffer,
-			slotID: slotID,
+			tree:         bt,
+			bufmgr:       bufmgr,
+			buffer:       nodeBuffer,
+			pageID:       nodeBuffer.PageID,
+			counts:       counts,
+			slotID:       slotID,
+			end:          searchMode.End,
+			endInclusive: searchMode.EndInclusive,
 		}
 		if isRightMost {
 			if err := iter.Advance(bufmgr); err != nil {
+				iter.Close()
 				return nil, err
 			}
 		}
+		if searchMode.ExcludeKey && !searchMode.IsStart {
+			if key, ok := iter.GetKey(); ok && bytes.Equal(key, searchMode.Key) {
+				if err := iter.Advance(bufmgr); err != nil {
+					iter.Close()
+					return nil, err
+				}
+			}
+		}
 		return iter, nil
 	} else if node.IsBranch() {
 		internalNode := node.AsBranch()
 		var childPageId disk.PageID
-		if searchMode.IsStart {
+		if searchMode.IsStart && searchMode.Reverse {
+			childPageId = internalNode.ChildAt(internalNode.NumPairs())
+		} else if searchMode.IsStart {
 			childPageId = internalNode.ChildAt(0)
 		} else {
 			childPageId = internalNode.SearchChild(searchMode.Key)
 		}
-		childNodePage, err := bufmgr.FetchBuffer(childPageId)
+		nodeBuffer.Unpin()
+		childNodePage, cached, err := bufmgr.FetchBufferCached(childPageId)
 		if err != nil {
 			return nil, err
 		}
-		return bt.searchInternal(bufmgr, childNodePage, searchMode)
+		counts.add(cached)
+		return bt.searchInternal(bufmgr, childNodePage, searchMode, counts)
 	}
 	panic("unknown node type")
 }
 
+// searchLeaf looks up key in leafNode, the leaf held by buf, like SearchSlotID. The key prefixes
+// of the leaf are kept with the buffer, so that repeated lookups in a cached leaf compare full
+// keys only when their prefixes are equal.
+func searchLeaf(buf *buffer.Buffer, leafNode *leaf.Leaf, key []byte) (int, error) {
+	prefixes, ok := buf.Derive(func() any { return leafNode.KeyPrefixes() }).(leaf.KeyPrefixes)
+	if !ok || len(prefixes) != leafNode.NumPairs() {
+		return leafNode.SearchSlotID(key)
+	}
+	return leafNode.SearchSlotIDWithPrefixes(key, prefixes)
+}
+
+// reverseIter positions a reverse iterator on leafNode for searchMode, taking over the pin on nodeBuffer.
+// If no key of the leaf qualifies, the iterator moves on to the previous leaf.
+func (bt *BTree) reverseIter(bufmgr *buffer.BufferPoolManager, nodeBuffer *buffer.Buffer, leafNode *leaf.Leaf, searchMode SearchMode, counts PageCounts) (*Iter, error) {
+	slotID := leafNode.NumPairs() - 1
+	if !searchMode.IsStart {
+		var err error
+		slotID, err = searchLeaf(nodeBuffer, leafNode, searchMode.Key)
+		if err != nil || searchMode.ExcludeKey {
+			// Not found, the key before the insertion point is the last one not greater than Key
+			slotID--
+		}
+	}
+	iter := &Iter{
+		tree:         bt,
+		bufmgr:       bufmgr,
+		buffer:       nodeBuffer,
+		pageID:       nodeBuffer.PageID,
+		counts:       counts,
+		slotID:       slotID,
+		end:          searchMode.End,
+		endInclusive: searchMode.EndInclusive,
+		reverse:      true,
+	}
+	if slotID < 0 {
+		iter.slotID = 0
+		if err := iter.Advance(bufmgr); err != nil {
+			iter.Close()
+			return nil, err
+		}
+	}
+	return iter, nil
+}
+
+// Insert adds a key-value pair to the tree. It returns ErrDuplicateKey if the key exists,
+// and ErrKeyTooLarge or ErrPairTooLarge if the pair exceeds the tree's Limits. A value too large
+// for the leaf is stored in overflow pages.
 func (bt *BTree) Insert(bufmgr *buffer.BufferPoolManager, key []byte, value []byte) error {
+	return retryLocked(bt.Log, func() error {
+		defer bt.acquireWriter(bufmgr, "Insert")()
+		_, err := bt.insert(bufmgr, key, value, false)
+		return err
+	})
+}
+
+// Upsert stores value under key in a single traversal of the tree: it inserts the pair if the
+// key does not exist and replaces the value otherwise, and reports whether it inserted.
+// It returns ErrKeyTooLarge or ErrPairTooLarge if the pair exceeds the tree's Limits. The
+// overflow pages of a replaced value are freed.
+func (bt *BTree) Upsert(bufmgr *buffer.BufferPoolManager, key []byte, value []byte) (bool, error) {
+	var inserted bool
+	err := retryLocked(bt.Log, func() (err error) {
+		defer bt.acquireWriter(bufmgr, "Upsert")()
+		inserted, err = bt.insert(bufmgr, key, value, true)
+		return err
+	})
+	return inserted, err
+}
+
+// insert inserts a pair, or replaces the value of an existing key if upsert is set, and reports
+// whether the pair was inserted.
+func (bt *BTree) insert(bufmgr *buffer.BufferPoolManager, key []byte, value []byte, upsert bool) (bool, error) {
+	limits := bt.Limits()
+	if err := limits.check(key, value); err != nil {
+		return false, err
+	}
+	pair, err := storedPair(bufmgr, bt.Log, limits, key, value)
+	if err != nil {
+		return false, err
+	}
+	op := &insertOp{pair: pair, upsert: upsert, inserted: true}
+	// Deferred before the latches are taken, so that it runs once they are released
+	defer op.freeOverflow(bufmgr, bt.Log)
+	err = bt.store(bufmgr, op)
+	return op.inserted, err
+}
+
+// store stores the pair of op through the latched path from the root down to its leaf, splitting
+// the nodes that do not have room for it.
+func (bt *BTree) store(bufmgr *buffer.BufferPoolManager, op *insertOp) error {
 	metaBuffer, err := bufmgr.FetchBuffer(bt.MetaPageID)
 	if err != nil {
 		return err
 	}
+	path := newWritePath(bufmgr, bt.Log)
+	defer path.releaseAll()
+	defer path.releaseReservation()
+	path.latch(metaBuffer, false)
 	meta := NewMeta(metaBuffer.Page[:])
 	rootPageId := meta.RootPageID()
 	rootBuffer, err := bufmgr.FetchBuffer(rootPageId)
 	if err != nil {
 		return err
 	}
+	path.latch(rootBuffer, NewNode(rootBuffer.Page[:]).IsInsertSafe())
 
-	split, err := bt.insertInternal(bufmgr, rootBuffer, key, value)
+	split, err := bt.insertInternal(bufmgr, path, rootBuffer, op)
 	if err != nil {
 		return err
 	}
 
 	if split != nil {
-		newRootBuffer, err := bufmgr.CreateBuffer()
+		newRootBuffer, err := path.createBuffer(bufmgr)
 		if err != nil {
 			return err
 		}
@@ -146,10 +346,40 @@ func (bt *BTree) Insert(bufmgr *buffer.BufferPoolManager, key []byte, value []by
 		node.InitializeAsBranch()
 		internalNode := node.AsBranch()
 		internalNode.Initialize(split.Key, split.ChildPageId, rootPageId)
+		internalNode.SetChildCount(0, split.ChildCount)
+		internalNode.SetChildCount(1, NewNode(rootBuffer.Page[:]).entryCount())
 		meta.SetRootPageID(newRootBuffer.PageID)
-		metaBuffer.IsDirty = true
+		path.markModified(metaBuffer)
+		// The path keeps the page pinned until it is logged or freed
+		newRootBuffer.Unpin()
+	}
+	path.releaseAll()
+	if path.reverted {
+		op.stored, op.replaced = false, nil
+	}
+	return path.err
+}
+
+// insertOp is the pair stored by an insert or an update.
+type insertOp struct {
+	pair     *leaf.Pair // Pair stored in the leaf, pointing to overflow pages if the value is too large
+	upsert   bool       // Whether the value of an existing key is replaced instead of ErrDuplicateKey
+	update   bool       // Whether a missing key is ErrKeyNotFound instead of being inserted
+	inserted bool       // Cleared when the value of an existing key was replaced
+	stored   bool       // Whether pair was stored in the leaf
+	replaced []byte     // Overflow pointer of the replaced value, if it was stored in overflow pages
+}
+
+// freeOverflow frees the overflow pages no pair refers to once the operation is over: those of
+// the replaced value, and those of the new one if it was not stored. A chain that cannot be read
+// is left allocated.
+func (op *insertOp) freeOverflow(bufmgr *buffer.BufferPoolManager, log PageLog) {
+	if op.replaced != nil {
+		freeOverflow(bufmgr, log, op.replaced)
+	}
+	if op.pair.Overflow && !op.stored {
+		freeOverflow(bufmgr, log, op.pair.Value)
 	}
-	return nil
 }
 
 // Split represents information propagated to the parent node when a node splits.
@@ -157,44 +387,76 @@ func (bt *BTree) Insert(bufmgr *buffer.BufferPoolManager, key []byte, value []by
 type Split struct {
 	Key         []byte      // Promoted key (minimum key of the new node)
 	ChildPageId disk.PageID // Page ID of the newly created child node
+	ChildCount  uint64      // Entry count of the newly created child node
 }
 
-func (bt *BTree) insertInternal(bufmgr *buffer.BufferPoolManager, nodeBuf *buffer.Buffer, key []byte, value []byte) (*Split, error) {
+// insertInternal inserts into the subtree rooted at nodeBuf, which the caller has latched in path.
+func (bt *BTree) insertInternal(bufmgr *buffer.BufferPoolManager, path *writePath, nodeBuf *buffer.Buffer, op *insertOp) (*Split, error) {
 	node := NewNode(nodeBuf.Page[:])
+	pair := op.pair
 
 	if node.IsLeaf() {
 		leafNode := node.AsLeaf()
-		slotID, err := leafNode.SearchSlotID(key)
-		if err == nil {
+		slotID, err := leafNode.SearchSlotID(pair.Key)
+		found := err == nil
+		if found && !op.upsert {
 			return nil, ErrDuplicateKey
 		}
+		if !found && op.update {
+			return nil, ErrKeyNotFound
+		}
+		op.inserted = !found
+		var replaced []byte
+		if found {
+			if old := leafNode.PairAt(slotID); old.Overflow {
+				replaced = old.Value
+			}
+		}
 
-		if leafNode.Insert(slotID, key, value) {
-			nodeBuf.IsDirty = true
+		if found && leafNode.UpdatePair(slotID, pair.Value, pair.Overflow) || !found && leafNode.InsertPair(slotID, pair) {
+			op.stored, op.replaced = true, replaced
+			path.markModified(nodeBuf)
 			return nil, nil
 		}
 
-		// Need to split
+		// Need to split. Every latched node may split, the node above them (or the meta page)
+		// gets the new child, and the previous leaf is relinked: reserve a buffer for each
+		// new page and for the previous leaf before modifying anything.
+		if err := path.reserve(bufmgr, len(path.latched)+1); err != nil {
+			return nil, err
+		}
+		if found {
+			// The new value does not fit next to the other pairs: the old pair makes way for it
+			leafNode.Delete(slotID)
+			op.replaced = replaced
+			path.markModified(nodeBuf)
+			if leafNode.InsertPair(slotID, pair) {
+				op.stored = true
+				return nil, nil
+			}
+		}
 		prevLeafPageId := leafNode.PrevPageID()
 		var prevLeafBuffer *buffer.Buffer
 		if prevLeafPageId.Valid() {
 			var err error
-			prevLeafBuffer, err = bufmgr.FetchBuffer(prevLeafPageId)
+			prevLeafBuffer, err = path.fetchBuffer(bufmgr, prevLeafPageId)
 			if err != nil {
 				return nil, err
 			}
+			path.latch(prevLeafBuffer, false)
 		}
 
-		newLeafBuffer, err := bufmgr.CreateBuffer()
+		newLeafBuffer, err := path.createBuffer(bufmgr)
 		if err != nil {
 			return nil, err
 		}
+		defer newLeafBuffer.Unpin()
 
 		if prevLeafBuffer != nil {
 			prevNode := NewNode(prevLeafBuffer.Page[:])
 			prevLeaf := prevNode.AsLeaf()
 			prevLeaf.SetNextPageID(newLeafBuffer.PageID)
-			prevLeafBuffer.IsDirty = true
+			path.markModified(prevLeafBuffer)
 		}
 		leafNode.SetPrevPageID(newLeafBuffer.PageID)
 
@@ -202,45 +464,61 @@ func (bt *BTree) insertInternal(bufmgr *buffer.BufferPoolManager, nodeBuf *buffe
 		newLeafNode.InitializeAsLeaf()
 		newLeaf := newLeafNode.AsLeaf()
 		newLeaf.Initialize()
-		splitKey := leafNode.SplitInsert(newLeaf, key, value)
+		splitKey := leafNode.SplitInsertPair(newLeaf, pair)
+		op.stored = true
 		newLeaf.SetNextPageID(nodeBuf.PageID)
 		if prevLeafPageId.Valid() {
 			newLeaf.SetPrevPageID(prevLeafPageId)
 		}
-		nodeBuf.IsDirty = true
-		return &Split{Key: splitKey, ChildPageId: newLeafBuffer.PageID}, nil
+		path.markModified(nodeBuf)
+		return &Split{Key: splitKey, ChildPageId: newLeafBuffer.PageID, ChildCount: uint64(newLeaf.NumPairs())}, nil
 	} else if node.IsBranch() {
 		internalNode := node.AsBranch()
-		childIdx := internalNode.SearchChildIdx(key)
+		childIdx := internalNode.SearchChildIdx(pair.Key)
 		childPageId := internalNode.ChildAt(childIdx)
 		childNodeBuffer, err := bufmgr.FetchBuffer(childPageId)
 		if err != nil {
 			return nil, err
 		}
+		path.latch(childNodeBuffer, NewNode(childNodeBuffer.Page[:]).IsInsertSafe())
 
-		split, err := bt.insertInternal(bufmgr, childNodeBuffer, key, value)
+		split, err := bt.insertInternal(bufmgr, path, childNodeBuffer, op)
 		if err != nil {
 			return nil, err
 		}
 
 		if split != nil {
+			// The entry counts of both halves of the split child are refreshed here,
+			// where the parent is modified anyway
+			childCount := NewNode(childNodeBuffer.Page[:]).entryCount()
 			if internalNode.Insert(childIdx, split.Key, split.ChildPageId) {
-				nodeBuf.IsDirty = true
+				internalNode.SetChildCount(childIdx, split.ChildCount)
+				internalNode.SetChildCount(childIdx+1, childCount)
+				path.markModified(nodeBuf)
 				return nil, nil
 			}
 
 			// Need to split internal node
-			newInternalBuffer, err := bufmgr.CreateBuffer()
+			newInternalBuffer, err := path.createBuffer(bufmgr)
 			if err != nil {
 				return nil, err
 			}
+			defer newInternalBuffer.Unpin()
 			newInternalNodeWrapper := NewNode(newInternalBuffer.Page[:])
 			newInternalNodeWrapper.InitializeAsBranch()
 			newInternalNode := newInternalNodeWrapper.AsBranch()
 			splitKey := internalNode.SplitInsert(newInternalNode, split.Key, split.ChildPageId)
-			nodeBuf.IsDirty = true
-			newInternalBuffer.IsDirty = true
-			return &Split{Key: splitKey, ChildPageId: newInternalBuffer.PageID}, nil
+			for _, n := range []*internal.InternalNode{internalNode, newInternalNode} {
+				if i, ok := n.ChildIdx(split.ChildPageId); ok {
+					n.SetChildCount(i, split.ChildCount)
+				}
+				if i, ok := n.ChildIdx(childPageId); ok {
+					n.SetChildCount(i, childCount)
+				}
+			}
+			path.markModified(nodeBuf)
+			newInternalBuffer.MarkDirty()
+			return &Split{Key: splitKey, ChildPageId: newInternalBuffer.PageID, ChildCount: newInternalNode.EntryCount()}, nil
 		}
 		return nil, nil
 	}
@@ -249,68 +527,178 @@ func (bt *BTree) insertInternal(bufmgr *buffer.BufferPoolManager, nodeBuf *buffe
 
 // Update updates the value for an existing key in the B+ tree.
 // It returns ErrKeyNotFound if the key doesn't exist.
+// It returns ErrKeyTooLarge or ErrPairTooLarge if the pair exceeds the tree's Limits.
+// A value that no longer fits in its leaf is stored by splitting the leaf, as Upsert does.
+// The overflow pages of the old value are freed.
 func (bt *BTree) Update(bufmgr *buffer.BufferPoolManager, key []byte, newValue []byte) error {
-	metaBuffer, err := bufmgr.FetchBuffer(bt.MetaPageID)
+	return retryLocked(bt.Log, func() error {
+		defer bt.acquireWriter(bufmgr, "Update")()
+		return bt.update(bufmgr, key, newValue)
+	})
+}
+
+func (bt *BTree) update(bufmgr *buffer.BufferPoolManager, key []byte, newValue []byte) error {
+	limits := bt.Limits()
+	if err := limits.check(key, newValue); err != nil {
+		return err
+	}
+	pair, err := storedPair(bufmgr, bt.Log, limits, key, newValue)
 	if err != nil {
 		return err
 	}
-	meta := NewMeta(metaBuffer.Page[:])
-	rootPageId := meta.RootPageID()
-	rootBuffer, err := bufmgr.FetchBuffer(rootPageId)
+	op := &insertOp{pair: pair, upsert: true, update: true}
+	defer op.freeOverflow(bufmgr, bt.Log)
+	rootBuffer, err := bt.FetchRootPage(bufmgr)
 	if err != nil {
 		return err
 	}
 
-	return bt.updateInternal(bufmgr, rootBuffer, key, newValue)
+	if err := bt.updateInternal(bufmgr, rootBuffer, op); err != errLeafFull {
+		return err
+	}
+	// The leaf has no room for the new value: take the insert path, which splits it
+	return bt.store(bufmgr, op)
 }
 
+// Delete removes a key from the B+ tree.
 // It returns ErrKeyNotFound if the key doesn't exist.
+// A leaf that becomes empty is unlinked from its siblings and its parent and its page is freed,
+// unless it is the only leaf of the tree. Internal nodes left without children are removed the
+// same way, and a root left with a single child is replaced by that child.
 func (bt *BTree) Delete(bufmgr *buffer.BufferPoolManager, key []byte) error {
+	return retryLocked(bt.Log, func() error {
+		defer bt.acquireWriter(bufmgr, "Delete")()
+		return bt.deleteKey(bufmgr, key)
+	})
+}
+
+func (bt *BTree) deleteKey(bufmgr *buffer.BufferPoolManager, key []byte) error {
 	metaBuffer, err := bufmgr.FetchBuffer(bt.MetaPageID)
 	if err != nil {
 		return err
 	}
+	path := newWritePath(bufmgr, bt.Log)
+	defer path.releaseAll()
+	defer path.releaseReservation()
+	path.latch(metaBuffer, false)
 	meta := NewMeta(metaBuffer.Page[:])
 	rootPageId := meta.RootPageID()
 	rootBuffer, err := bufmgr.FetchBuffer(rootPageId)
 	if err != nil {
 		return err
 	}
+	rootNode := NewNode(rootBuffer.Page[:])
+	path.latch(rootBuffer, rootNode.IsLeaf() || 1 < rootNode.AsBranch().NumPairs())
 
-	return bt.deleteInternal(bufmgr, rootBuffer, key)
+	var freed []disk.PageID
+	if _, err := bt.deleteInternal(bufmgr, path, rootBuffer, key, &freed); err != nil {
+		return err
+	}
+
+	if rootNode.IsBranch() && rootNode.AsBranch().NumPairs() == 0 {
+		meta.SetRootPageID(rootNode.AsBranch().ChildAt(0))
+		path.markModified(metaBuffer)
+		freed = append(freed, rootPageId)
+	}
+
+	path.releaseAll()
+	if !path.reverted {
+		freePages(bufmgr, bt.Log, freed)
+	}
+	return path.err
 }
 
-func (bt *BTree) deleteInternal(bufmgr *buffer.BufferPoolManager, nodeBuf *buffer.Buffer, key []byte) error {
+// deleteInternal deletes from the subtree rooted at nodeBuf, which the caller has latched in path.
+// It returns true if the node was emptied and has to be removed from its parent;
+// its page is then appended to freed, to be freed once the latches are released, like the
+// overflow pages of the deleted value.
+func (bt *BTree) deleteInternal(bufmgr *buffer.BufferPoolManager, path *writePath, nodeBuf *buffer.Buffer, key []byte, freed *[]disk.PageID) (bool, error) {
 	node := NewNode(nodeBuf.Page[:])
 
 	if node.IsLeaf() {
 		leafNode := node.AsLeaf()
 		slotID, err := leafNode.SearchSlotID(key)
 		if err != nil {
-			return ErrKeyNotFound
+			return false, ErrKeyNotFound
+		}
+		var chainPageIDs []disk.PageID
+		if pair := leafNode.PairAt(slotID); pair.Overflow {
+			if chainPageIDs, err = overflowPageIDs(bufmgr, pair.Value); err != nil {
+				return false, err
+			}
+		}
+		prevPageId := leafNode.PrevPageID()
+		nextPageId := leafNode.NextPageID()
+		unlink := leafNode.NumPairs() == 1 && (prevPageId.Valid() || nextPageId.Valid())
+		if unlink {
+			// Reserve buffers for both siblings before modifying anything
+			if err := path.reserve(bufmgr, 2); err != nil {
+				return false, err
+			}
+		}
+		if !leafNode.Delete(slotID) {
+			return false, ErrKeyNotFound
+		}
+		path.markModified(nodeBuf)
+		*freed = append(*freed, chainPageIDs...)
+		if !unlink {
+			return false, nil
 		}
 
-		if leafNode.Delete(slotID) {
-			nodeBuf.IsDirty = true
-			return nil
+		// Unlink the empty leaf from the leaf chain
+		if prevPageId.Valid() {
+			prevBuffer, err := path.fetchBuffer(bufmgr, prevPageId)
+			if err != nil {
+				return false, err
+			}
+			path.latch(prevBuffer, false)
+			NewNode(prevBuffer.Page[:]).AsLeaf().SetNextPageID(nextPageId)
+			path.markModified(prevBuffer)
 		}
-		return ErrKeyNotFound
+		if nextPageId.Valid() {
+			nextBuffer, err := path.fetchBuffer(bufmgr, nextPageId)
+			if err != nil {
+				return false, err
+			}
+			path.latch(nextBuffer, false)
+			NewNode(nextBuffer.Page[:]).AsLeaf().SetPrevPageID(prevPageId)
+			path.markModified(nextBuffer)
+		}
+		*freed = append(*freed, nodeBuf.PageID)
+		return true, nil
 	} else if node.IsBranch() {
 		internalNode := node.AsBranch()
 		childIdx := internalNode.SearchChildIdx(key)
 		childPageId := internalNode.ChildAt(childIdx)
 		childNodeBuffer, err := bufmgr.FetchBuffer(childPageId)
 		if err != nil {
-			return err
+			return false, err
 		}
+		path.latch(childNodeBuffer, NewNode(childNodeBuffer.Page[:]).isDeleteSafe())
 
-		return bt.deleteInternal(bufmgr, childNodeBuffer, key)
+		removed, err := bt.deleteInternal(bufmgr, path, childNodeBuffer, key, freed)
+		if err != nil || !removed {
+			return false, err
+		}
+
+		if 0 < internalNode.NumPairs() {
+			internalNode.RemoveChild(childIdx)
+			path.markModified(nodeBuf)
+			return false, nil
+		}
+		// The removed child was the only one
+		*freed = append(*freed, nodeBuf.PageID)
+		return true, nil
 	}
 	panic("unknown node type")
 }
 
-func (bt *BTree) updateInternal(bufmgr *buffer.BufferPoolManager, nodeBuf *buffer.Buffer, key []byte, newValue []byte) error {
+// updateInternal stores the pair of op in place of the one with its key in the subtree rooted at
+// nodeBuf and releases the caller's pin on it.
+func (bt *BTree) updateInternal(bufmgr *buffer.BufferPoolManager, nodeBuf *buffer.Buffer, op *insertOp) error {
+	defer nodeBuf.Unpin()
 	node := NewNode(nodeBuf.Page[:])
+	key := op.pair.Key
 
 	if node.IsLeaf() {
 		leafNode := node.AsLeaf()
@@ -319,11 +707,29 @@ func (bt *BTree) updateInternal(bufmgr *buffer.BufferPoolManager, nodeBuf *buffe
 			return ErrKeyNotFound
 		}
 
-		if leafNode.Update(slotID, newValue) {
-			nodeBuf.IsDirty = true
-			return nil
+		nodeBuf.WriteLatch()
+		if err := lockPage(bt.Log, nodeBuf.PageID); err != nil {
+			nodeBuf.WriteUnlatch(false)
+			return err
+		}
+		var before []byte
+		if bt.Log != nil {
+			before = pageImage(nodeBuf)
+		}
+		old := leafNode.PairAt(slotID)
+		updated := leafNode.UpdatePair(slotID, op.pair.Value, op.pair.Overflow)
+		if !updated {
+			nodeBuf.WriteUnlatch(false)
+			return errLeafFull
 		}
-		return ErrKeyNotFound
+		op.stored = true
+		if old.Overflow {
+			op.replaced = old.Value
+		}
+		nodeBuf.MarkDirty()
+		err = logPage(bt.Log, nodeBuf, before)
+		nodeBuf.WriteUnlatch(true)
+		return err
 	} else if node.IsBranch() {
 		internalNode := node.AsBranch()
 		childIdx := internalNode.SearchChildIdx(key)
@@ -333,30 +739,83 @@ func (bt *BTree) updateInternal(bufmgr *buffer.BufferPoolManager, nodeBuf *buffe
 			return err
 		}
 
-		return bt.updateInternal(bufmgr, childNodeBuffer, key, newValue)
+		return bt.updateInternal(bufmgr, childNodeBuffer, op)
 	}
 	panic("unknown node type")
 }
 
 // Iter is an iterator for traversing key-value pairs in a B+ tree.
 // It supports sequential iteration across leaf nodes.
+//
+// The iterator keeps the leaf it is positioned on pinned. The pin is released when the
+// iterator reaches the end; an iterator abandoned before that must be closed with Close.
 type Iter struct {
-	buffer *buffer.Buffer // Current leaf page buffer
-	slotID int            // Current slot index in the leaf
+	tree         *BTree                    // Tree the iterator belongs to
+	bufmgr       *buffer.BufferPoolManager // Buffer pool the values stored in overflow pages are read through
+	buffer       *buffer.Buffer            // Current leaf page buffer, pinned until the iterator is done
+	pageID       disk.PageID               // Page ID of the current leaf
+	counts       PageCounts                // Pages fetched by the iterator and the search that created it
+	slotID       int                       // Current slot index in the leaf
+	end          []byte                    // Bound of the search mode, upper or lower if reverse (nil means unbounded)
+	endInclusive bool
+	reverse      bool  // Whether the iterator moves in descending key order
+	done         bool  // Whether the iterator has reached the end and released its leaf
+	err          error // Error of the last Get
+}
+
+// pastEnd reports whether key lies beyond the bound of the iterator,
+// and if so, stops the iterator.
+func (it *Iter) pastEnd(key []byte) bool {
+	if it.end != nil {
+		c := bytes.Compare(key, it.end)
+		if it.reverse {
+			c = -c
+		}
+		if 0 < c || (c == 0 && !it.endInclusive) {
+			it.Close()
+		}
+	}
+	return it.done
+}
+
+// Close moves the iterator to the end position and releases the pin on its leaf.
+// It may be called more than once, and need not be called once the iterator is at the end.
+func (it *Iter) Close() {
+	if !it.done {
+		it.done = true
+		it.buffer.Unpin()
+	}
 }
 
 // Get returns the current key-value pair at the iterator's position.
 // It returns the key, value, and a boolean indicating whether a pair was found.
 // If the iterator is at the end or not positioned on a valid leaf node, it returns (nil, nil, false).
 // The returned key and value are copies, so modifications to them will not affect the stored data.
+// A value stored in overflow pages is read from them; if that fails, Get returns (nil, nil, false)
+// and Err returns the error.
 func (it *Iter) Get() ([]byte, []byte, bool) {
+	it.err = nil
+	if it.done {
+		return nil, nil, false
+	}
 	node := NewNode(it.buffer.Page[:])
 	if !node.IsLeaf() {
 		return nil, nil, false
 	}
 	leafNode := node.AsLeaf()
-	if it.slotID < leafNode.NumPairs() {
+	if 0 <= it.slotID && it.slotID < leafNode.NumPairs() {
 		pair := leafNode.PairAt(it.slotID)
+		if it.pastEnd(pair.Key) {
+			return nil, nil, false
+		}
+		if pair.Overflow {
+			value, err := readOverflow(it.bufmgr, pair.Value)
+			if err != nil {
+				it.err = err
+				return nil, nil, false
+			}
+			pair.Value = value
+		}
 		key := make([]byte, len(pair.Key))
 		value := make([]byte, len(pair.Value))
 		copy(key, pair.Key)
@@ -366,13 +825,48 @@ func (it *Iter) Get() ([]byte, []byte, bool) {
 	return nil, nil, false
 }
 
+// Err returns the error that made the last Get report no pair, or nil.
+func (it *Iter) Err() error {
+	return it.err
+}
+
+// GetKey is like Get but returns only a copy of the key, without reading the value.
+func (it *Iter) GetKey() ([]byte, bool) {
+	if it.done {
+		return nil, false
+	}
+	node := NewNode(it.buffer.Page[:])
+	if !node.IsLeaf() {
+		return nil, false
+	}
+	leafNode := node.AsLeaf()
+	if 0 <= it.slotID && it.slotID < leafNode.NumPairs() {
+		pair := leafNode.PairAt(it.slotID)
+		if it.pastEnd(pair.Key) {
+			return nil, false
+		}
+		key := make([]byte, len(pair.Key))
+		copy(key, pair.Key)
+		return key, true
+	}
+	return nil, false
+}
+
 // Advance moves the iterator to the next position.
 // If the current slot is not the last in the leaf node, it increments the slot index.
 // If the current slot is the last in the leaf node, it moves to the next leaf page
 // by following the NextPageID link and resets the slot index to 0.
-// If there is no next page, the iterator remains at the end position.
+// A reverse iterator moves the other way, following the PrevPageID link.
+// If there is no next page or the iterator has passed the end of its search mode,
+// the iterator remains at the end position and its leaf is released.
 // Returns an error if fetching the next page fails.
 func (it *Iter) Advance(bufmgr *buffer.BufferPoolManager) error {
+	if it.done {
+		return nil
+	}
+	if it.reverse {
+		return it.retreat(bufmgr)
+	}
 	it.slotID++
 	node := NewNode(it.buffer.Page[:])
 	if !node.IsLeaf() {
@@ -383,26 +877,142 @@ func (it *Iter) Advance(bufmgr *buffer.BufferPoolManager) error {
 		return nil
 	}
 	nextPageId := leafNode.NextPageID()
-	if nextPageId.Valid() {
-		nextBuffer, err := bufmgr.FetchBuffer(nextPageId)
-		if err != nil {
-			return err
-		}
-		it.buffer = nextBuffer
-		it.slotID = 0
+	if !nextPageId.Valid() {
+		it.Close()
+		return nil
 	}
+	nextBuffer, cached, err := bufmgr.FetchBufferCached(nextPageId)
+	if err != nil {
+		return err
+	}
+	it.counts.add(cached)
+	it.buffer.Unpin()
+	it.buffer = nextBuffer
+	it.pageID = nextPageId
+	it.slotID = 0
+	return nil
+}
+
+// retreat moves a reverse iterator to the previous position.
+func (it *Iter) retreat(bufmgr *buffer.BufferPoolManager) error {
+	it.slotID--
+	if 0 <= it.slotID {
+		return nil
+	}
+	node := NewNode(it.buffer.Page[:])
+	if !node.IsLeaf() {
+		return nil
+	}
+	prevPageId := node.AsLeaf().PrevPageID()
+	if !prevPageId.Valid() {
+		it.Close()
+		return nil
+	}
+	prevBuffer, cached, err := bufmgr.FetchBufferCached(prevPageId)
+	if err != nil {
+		return err
+	}
+	it.counts.add(cached)
+	it.buffer.Unpin()
+	it.buffer = prevBuffer
+	it.pageID = prevPageId
+	it.slotID = NewNode(prevBuffer.Page[:]).AsLeaf().NumPairs() - 1
 	return nil
 }
 
+// Seek moves the iterator forward to the first pair whose key is not less than key.
+// It never moves backwards: if the current key is already not less than key, it does nothing.
+// The target is looked up in the current leaf if it lies there and from the root otherwise,
+// so seeking far ahead costs one tree descent instead of a walk over the pairs in between.
+// Reverse iterators do not support Seek.
+func (it *Iter) Seek(bufmgr *buffer.BufferPoolManager, key []byte) error {
+	current, ok := it.GetKey()
+	if !ok || 0 <= bytes.Compare(current, key) {
+		return nil
+	}
+	leafNode := NewNode(it.buffer.Page[:]).AsLeaf()
+	last := leafNode.PairAt(leafNode.NumPairs() - 1).Key
+	if 0 <= bytes.Compare(last, key) {
+		it.slotID, _ = searchLeaf(it.buffer, leafNode, key)
+		return nil
+	}
+	next, err := it.tree.Search(bufmgr, SearchMode{Key: key, End: it.end, EndInclusive: it.endInclusive})
+	if err != nil {
+		return err
+	}
+	it.Close()
+	counts := it.counts
+	*it = *next
+	it.counts.Fetched += counts.Fetched
+	it.counts.Read += counts.Read
+	return nil
+}
+
+// PageCounts counts the node pages fetched by an iterator, including those fetched by the search
+// that created it, and how many of them were not cached and had to be read from disk.
+type PageCounts struct {
+	Fetched uint64
+	Read    uint64
+}
+
+func (c *PageCounts) add(cached bool) {
+	c.Fetched++
+	if !cached {
+		c.Read++
+	}
+}
+
+// PageCounts returns the node pages fetched by the iterator so far.
+func (it *Iter) PageCounts() PageCounts {
+	return it.counts
+}
+
+// PageID returns the page ID of the leaf the iterator is currently positioned on.
+// At the end position it returns the last leaf the iterator was positioned on.
+func (it *Iter) PageID() disk.PageID {
+	return it.pageID
+}
+
+// SkipPage moves the iterator to the first pair of the next leaf page without reading
+// the remaining pairs of the current leaf (for a reverse iterator, to the last pair of the previous one).
+// If there is no next page, the iterator is moved to the end position.
+func (it *Iter) SkipPage(bufmgr *buffer.BufferPoolManager) error {
+	if it.done {
+		return nil
+	}
+	node := NewNode(it.buffer.Page[:])
+	if !node.IsLeaf() {
+		return nil
+	}
+	it.slotID = node.AsLeaf().NumPairs() - 1
+	if it.reverse {
+		it.slotID = 0
+	}
+	return it.Advance(bufmgr)
+}
+
 // Next returns the current key-value pair and advances the iterator to the next position.
 // It is equivalent to calling Get() followed by Advance(), but more efficient.
 // Returns the key, value, a boolean indicating if a pair was found, and an error.
-// If an error occurs during advancement, it returns (nil, nil, false, error).
+// If an error occurs while reading the pair or during advancement, it returns (nil, nil, false, error).
 // This method is typically used in a loop to iterate through all key-value pairs.
 func (it *Iter) Next(bufmgr *buffer.BufferPoolManager) ([]byte, []byte, bool, error) {
 	key, value, ok := it.Get()
+	if it.err != nil {
+		return nil, nil, false, it.err
+	}
 	if err := it.Advance(bufmgr); err != nil {
 		return nil, nil, false, err
 	}
 	return key, value, ok, nil
 }
+
+// NextKey is the key-only counterpart of Next: it returns the current key and advances the iterator.
+// Scans that need only key columns use it to avoid copying the values.
+func (it *Iter) NextKey(bufmgr *buffer.BufferPoolManager) ([]byte, bool, error) {
+	key, ok := it.GetKey()
+	if err := it.Advance(bufmgr); err != nil {
+		return nil, false, err
+	}
+	return key, ok, nil
+}
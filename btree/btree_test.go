@@ -1,7 +1,10 @@
 package btree
 
 import (
+	"bytes"
 	"encoding/binary"
+	"fmt"
+	"math/rand"
 	"os"
 	"reflect"
 	"testing"
@@ -60,6 +63,7 @@ func TestBTree(t *testing.T) {
 		t.Fatal(err)
 	}
 	_, value, ok := iter.Get()
+	iter.Close()
 	if !ok {
 		t.Fatal("expected to find value")
 	}
@@ -72,6 +76,7 @@ func TestBTree(t *testing.T) {
 		t.Fatal(err)
 	}
 	_, value, ok = iter.Get()
+	iter.Close()
 	if !ok {
 		t.Fatal("expected to find value")
 	}
@@ -118,6 +123,7 @@ func TestBTreeSearchIter(t *testing.T) {
 			t.Fatal(err)
 		}
 		key, _, ok := iter.Get()
+		iter.Close()
 		if !ok {
 			t.Fatalf("expected to find value for search key %d", i*2+1)
 		}
@@ -129,6 +135,334 @@ func TestBTreeSearchIter(t *testing.T) {
 	}
 }
 
+func TestBTreeGet(t *testing.T) {
+	tmpfile, err := os.CreateTemp("", "test_btree_get_*.db")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(tmpfile.Name())
+
+	dm, err := disk.NewDiskManager(tmpfile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer dm.Close()
+
+	pool := buffer.NewBufferPool(10)
+	bufmgr := buffer.NewBufferPoolManager(dm, pool)
+
+	bt, err := CreateBTree(bufmgr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	key := func(i uint64) []byte {
+		k := make([]byte, 8)
+		binary.BigEndian.PutUint64(k, i)
+		return k
+	}
+	for i := uint64(0); i < 16; i++ {
+		value := bytes.Repeat([]byte{byte(i)}, 512)
+		if err := bt.Insert(bufmgr, key(i*2), value); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for i := uint64(0); i < 33; i++ {
+		value, err := bt.Get(bufmgr, key(i))
+		if i%2 == 0 && i < 32 {
+			if err != nil || !bytes.Equal(value, bytes.Repeat([]byte{byte(i / 2)}, 512)) {
+				t.Errorf("key %d: unexpected value %x, %v", i, value, err)
+			}
+		} else if err != ErrKeyNotFound {
+			// Search would return the next key instead
+			t.Errorf("key %d: expected ErrKeyNotFound, got %x, %v", i, value, err)
+		}
+	}
+}
+
+func TestBTreeSearchRange(t *testing.T) {
+	tmpfile, err := os.CreateTemp("", "test_btree_range_*.db")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(tmpfile.Name())
+
+	dm, err := disk.NewDiskManager(tmpfile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer dm.Close()
+
+	pool := buffer.NewBufferPool(10)
+	bufmgr := buffer.NewBufferPoolManager(dm, pool)
+
+	bt, err := CreateBTree(bufmgr)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Even keys 0..30 with large values, so that the range spans several leaves
+	encode := func(i uint64) []byte {
+		key := make([]byte, 8)
+		binary.BigEndian.PutUint64(key, i)
+		return key
+	}
+	for i := uint64(0); i < 16; i++ {
+		if err := bt.Insert(bufmgr, encode(i*2), make([]byte, 1024)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tests := []struct {
+		name     string
+		mode     SearchMode
+		expected []uint64
+	}{
+		{"Inclusive", NewSearchModeRange(encode(4), true, encode(10), true), []uint64{4, 6, 8, 10}},
+		{"Exclusive", NewSearchModeRange(encode(4), false, encode(10), false), []uint64{6, 8}},
+		{"BoundsBetweenKeys", NewSearchModeRange(encode(5), false, encode(11), false), []uint64{6, 8, 10}},
+		{"NoStart", NewSearchModeRange(nil, false, encode(4), true), []uint64{0, 2, 4}},
+		{"NoEnd", NewSearchModeRange(encode(27), true, nil, false), []uint64{28, 30}},
+		{"Empty", NewSearchModeRange(encode(6), false, encode(8), false), nil},
+		{"PastLastKey", NewSearchModeRange(encode(30), false, nil, false), nil},
+		{"Reverse", SearchMode{IsStart: true, Reverse: true}, []uint64{30, 28, 26, 24, 22, 20, 18, 16, 14, 12, 10, 8, 6, 4, 2, 0}},
+		{"ReverseInclusive", SearchMode{Key: encode(9), End: encode(4), EndInclusive: true, Reverse: true}, []uint64{8, 6, 4}},
+		{"ReverseExclusive", SearchMode{Key: encode(10), ExcludeKey: true, End: encode(4), Reverse: true}, []uint64{8, 6}},
+		{"ReversePastFirstKey", SearchMode{Key: encode(0), ExcludeKey: true, Reverse: true}, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			iter, err := bt.Search(bufmgr, tt.mode)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var got []uint64
+			for {
+				key, _, ok, err := iter.Next(bufmgr)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if !ok {
+					break
+				}
+				got = append(got, binary.BigEndian.Uint64(key))
+			}
+			if !reflect.DeepEqual(got, tt.expected) {
+				t.Errorf("expected %v, got %v", tt.expected, got)
+			}
+			// A finished iterator stays finished
+			if _, _, ok, _ := iter.Next(bufmgr); ok {
+				t.Error("expected no more keys")
+			}
+		})
+	}
+}
+
+func TestIterSeek(t *testing.T) {
+	tmpfile, err := os.CreateTemp("", "test_btree_seek_*.db")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(tmpfile.Name())
+
+	dm, err := disk.NewDiskManager(tmpfile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer dm.Close()
+
+	pool := buffer.NewBufferPool(10)
+	bufmgr := buffer.NewBufferPoolManager(dm, pool)
+
+	bt, err := CreateBTree(bufmgr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	encode := func(i uint64) []byte {
+		key := make([]byte, 8)
+		binary.BigEndian.PutUint64(key, i)
+		return key
+	}
+	// Large values put a few keys on each leaf
+	for i := uint64(0); i < 32; i += 2 {
+		if err := bt.Insert(bufmgr, encode(i), make([]byte, 1024)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	iter, err := bt.Search(bufmgr, NewSearchModeStart())
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, step := range []struct {
+		target   uint64
+		expected uint64
+	}{
+		{1, 2},   // Within the first leaf
+		{2, 2},   // Already there
+		{0, 2},   // Never backwards
+		{23, 24}, // On a later leaf
+		{30, 30},
+	} {
+		if err := iter.Seek(bufmgr, encode(step.target)); err != nil {
+			t.Fatal(err)
+		}
+		key, ok := iter.GetKey()
+		if !ok || binary.BigEndian.Uint64(key) != step.expected {
+			t.Errorf("seek to %d: expected %d, got %x", step.target, step.expected, key)
+		}
+	}
+	if err := iter.Seek(bufmgr, encode(31)); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := iter.GetKey(); ok {
+		t.Error("expected the iterator at the end after seeking past the last key")
+	}
+}
+
+func TestBTreeReleasesPins(t *testing.T) {
+	tmpfile, err := os.CreateTemp("", "test_btree_pins_*.db")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(tmpfile.Name())
+
+	dm, err := disk.NewDiskManager(tmpfile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer dm.Close()
+
+	pool := buffer.NewBufferPool(10)
+	bufmgr := buffer.NewBufferPoolManager(dm, pool)
+
+	bt, err := CreateBTree(bufmgr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	encode := func(i uint64) []byte {
+		key := make([]byte, 8)
+		binary.BigEndian.PutUint64(key, i)
+		return key
+	}
+	// Far more leaves than frames: leaked pins would exhaust the pool
+	for i := uint64(0); i < 200; i++ {
+		if err := bt.Insert(bufmgr, encode(i), make([]byte, 512)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for i := uint64(0); i+2 < 200; i += 3 {
+		if err := bt.Update(bufmgr, encode(i), make([]byte, 512)); err != nil {
+			t.Fatal(err)
+		}
+		if _, found, err := bt.OptimisticGet(bufmgr, encode(i+1)); err != nil || !found {
+			t.Fatalf("key %d: found %v, err %v", i+1, found, err)
+		}
+		if err := bt.Delete(bufmgr, encode(i+2)); err != nil {
+			t.Fatal(err)
+		}
+		iter, err := bt.Search(bufmgr, NewSearchModeKey(encode(i)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		iter.Close()
+	}
+	iter, err := bt.Search(bufmgr, NewSearchModeStart())
+	if err != nil {
+		t.Fatal(err)
+	}
+	for {
+		_, _, ok, err := iter.Next(bufmgr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !ok {
+			break
+		}
+	}
+	if _, err := bt.EstimateRange(bufmgr, encode(10), encode(100)); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := bt.Stats(bufmgr); err != nil {
+		t.Fatal(err)
+	}
+	if pinned := bufmgr.PoolStats().Pinned; pinned != 0 {
+		t.Errorf("expected no pinned frames, got %d", pinned)
+	}
+}
+
+func TestBTreeInjectedEvictions(t *testing.T) {
+	tmpfile, err := os.CreateTemp("", "test_btree_evictions_*.db")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(tmpfile.Name())
+
+	dm, err := disk.NewDiskManager(tmpfile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer dm.Close()
+
+	bufmgr := buffer.NewBufferPoolManager(dm, buffer.NewBufferPool(16))
+	bt, err := CreateBTree(bufmgr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	encode := func(i uint64) []byte {
+		key := make([]byte, 8)
+		binary.BigEndian.PutUint64(key, i)
+		return key
+	}
+	// Every page not in use leaves the cache before each fetch, in the middle of splits
+	// and while iterators move from leaf to leaf
+	bufmgr.SetEvictionInjector(func(bufmgr *buffer.BufferPoolManager, _ uint64, _ disk.PageID) {
+		if _, err := bufmgr.EvictAll(); err != nil {
+			t.Error(err)
+		}
+	})
+	for i := uint64(0); i < 1000; i++ {
+		if err := bt.Insert(bufmgr, encode(i*7%1000), encode(i*7%1000)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for i := uint64(0); i < 1000; i += 2 {
+		if err := bt.Delete(bufmgr, encode(i)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	iter, err := bt.Search(bufmgr, NewSearchModeStart())
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := uint64(1)
+	for {
+		k, v, ok, err := iter.Next(bufmgr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !ok {
+			break
+		}
+		if !bytes.Equal(k, encode(want)) || !bytes.Equal(v, k) {
+			t.Fatalf("expected key %d, got %x = %x", want, k, v)
+		}
+		want += 2
+	}
+	if want != 1001 {
+		t.Errorf("expected the odd keys up to 999, stopped before %d", want)
+	}
+	if value, found, err := bt.OptimisticGet(bufmgr, encode(151)); err != nil || !found || !bytes.Equal(value, encode(151)) {
+		t.Errorf("expected key 151, got %x, %v, %v", value, found, err)
+	}
+	if stats := bufmgr.IOStats(); stats.PagesRead == 0 || stats.PagesWritten == 0 {
+		t.Errorf("expected pages to be evicted and read back, got %+v", stats)
+	}
+	if pinned := bufmgr.PoolStats().Pinned; pinned != 0 {
+		t.Errorf("expected no pinned frames, got %d", pinned)
+	}
+}
+
 func TestBTreeSplit(t *testing.T) {
 	tmpfile, err := os.CreateTemp("", "test_btree_split_*.db")
 	if err != nil {
@@ -178,6 +512,7 @@ func TestBTreeSplit(t *testing.T) {
 			t.Fatal(err)
 		}
 		k, v, ok := iter.Get()
+		iter.Close()
 		if !ok {
 			t.Fatal("expected to find value")
 		}
@@ -189,3 +524,449 @@ func TestBTreeSplit(t *testing.T) {
 		}
 	}
 }
+
+func TestBTreeUpsert(t *testing.T) {
+	tmpfile, err := os.CreateTemp("", "test_btree_upsert_*.db")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(tmpfile.Name())
+
+	dm, err := disk.NewDiskManager(tmpfile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer dm.Close()
+
+	pool := buffer.NewBufferPool(10)
+	bufmgr := buffer.NewBufferPoolManager(dm, pool)
+
+	bt, err := CreateBTree(bufmgr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	key := func(i uint64) []byte {
+		k := make([]byte, 8)
+		binary.BigEndian.PutUint64(k, i)
+		return k
+	}
+	upsert := func(i uint64, value []byte, expectInserted bool) {
+		t.Helper()
+		inserted, err := bt.Upsert(bufmgr, key(i), value)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if inserted != expectInserted {
+			t.Errorf("key %d: expected inserted to be %v", i, expectInserted)
+		}
+		if got, err := bt.Get(bufmgr, key(i)); err != nil || !bytes.Equal(got, value) {
+			t.Errorf("key %d: expected %d bytes, got %d, %v", i, len(value), len(got), err)
+		}
+	}
+
+	for i := uint64(0); i < 8; i++ {
+		upsert(i, []byte("small"), true)
+	}
+	upsert(3, []byte("replaced"), false)
+
+	// Values that no longer fit in their leaf split it
+	large := bytes.Repeat([]byte{0xab}, 1500)
+	for i := uint64(0); i < 8; i++ {
+		upsert(i, large, false)
+	}
+	stats, err := bt.Stats(bufmgr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stats.KeyCount != 8 || stats.LeafPages < 3 {
+		t.Errorf("expected 8 keys in several leaves, got %+v", stats)
+	}
+
+	// A value too large for any leaf is stored in overflow pages, freed once it is replaced
+	upsert(0, bytes.Repeat([]byte{0xcd}, 3*disk.PageSize), false)
+	if stats, err := bt.Stats(bufmgr); err != nil || stats.OverflowPages != 4 {
+		t.Errorf("expected 4 overflow pages, got %+v, %v", stats, err)
+	}
+	upsert(0, large, false)
+	if stats, err := bt.Stats(bufmgr); err != nil || stats.OverflowPages != 0 {
+		t.Errorf("expected no overflow pages, got %+v, %v", stats, err)
+	}
+}
+
+func TestBTreeUpdateGrowing(t *testing.T) {
+	tmpfile, err := os.CreateTemp("", "test_btree_update_*.db")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(tmpfile.Name())
+
+	dm, err := disk.NewDiskManager(tmpfile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer dm.Close()
+
+	pool := buffer.NewBufferPool(10)
+	bufmgr := buffer.NewBufferPoolManager(dm, pool)
+
+	bt, err := CreateBTree(bufmgr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	key := func(i int) []byte {
+		return []byte(fmt.Sprintf("k%05d", i))
+	}
+	rng := rand.New(rand.NewSource(0))
+	values := make(map[int][]byte)
+	for i := 0; i < 50; i++ {
+		values[i] = []byte("small")
+		if err := bt.Insert(bufmgr, key(i), values[i]); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// Values grow and shrink inside full leaves, which then have to split
+	for n := 0; n < 1000; n++ {
+		i := rng.Intn(len(values))
+		values[i] = bytes.Repeat([]byte{byte(n)}, rng.Intn(1200))
+		if err := bt.Update(bufmgr, key(i), values[i]); err != nil {
+			t.Fatalf("update %d of %s: %v", n, key(i), err)
+		}
+	}
+	for i, value := range values {
+		if got, err := bt.Get(bufmgr, key(i)); err != nil || !bytes.Equal(got, value) {
+			t.Errorf("%s: expected %d bytes, got %d, %v", key(i), len(value), len(got), err)
+		}
+	}
+
+	if err := bt.Update(bufmgr, key(len(values)), []byte("missing")); err != ErrKeyNotFound {
+		t.Errorf("expected ErrKeyNotFound, got %v", err)
+	}
+	if err := bt.Update(bufmgr, bytes.Repeat([]byte("k"), bt.Limits().MaxKeySize+1), nil); err != ErrKeyTooLarge {
+		t.Errorf("expected ErrKeyTooLarge, got %v", err)
+	}
+}
+
+func TestBTreeStats(t *testing.T) {
+	tmpfile, err := os.CreateTemp("", "test_btree_stats_*.db")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(tmpfile.Name())
+
+	dm, err := disk.NewDiskManager(tmpfile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer dm.Close()
+
+	pool := buffer.NewBufferPool(10)
+	bufmgr := buffer.NewBufferPoolManager(dm, pool)
+
+	bt, err := CreateBTree(bufmgr)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	stats, err := bt.Stats(bufmgr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stats.Height != 1 || stats.KeyCount != 0 || stats.LeafPages != 1 || stats.BranchPages != 0 {
+		t.Errorf("unexpected stats for an empty tree: %+v", stats)
+	}
+
+	const numKeys = 2000
+	for i := 0; i < numKeys; i++ {
+		key := make([]byte, 8)
+		binary.BigEndian.PutUint64(key, uint64(i))
+		if err := bt.Insert(bufmgr, key, make([]byte, 32)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	stats, err = bt.Stats(bufmgr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stats.KeyCount != numKeys {
+		t.Errorf("expected %d keys, got %d", numKeys, stats.KeyCount)
+	}
+	if stats.Height < 2 || len(stats.PagesPerLevel) != stats.Height {
+		t.Errorf("expected a multi-level tree, got %+v", stats)
+	}
+	if stats.PagesPerLevel[0] != 1 {
+		t.Errorf("expected a single root page, got %d", stats.PagesPerLevel[0])
+	}
+	if stats.PagesPerLevel[stats.Height-1] != stats.LeafPages {
+		t.Errorf("expected the last level to hold the %d leaves, got %d", stats.LeafPages, stats.PagesPerLevel[stats.Height-1])
+	}
+	total := 0
+	for _, n := range stats.PagesPerLevel {
+		total += n
+	}
+	if total != stats.LeafPages+stats.BranchPages {
+		t.Errorf("expected %d pages in total, got %d", stats.LeafPages+stats.BranchPages, total)
+	}
+	if stats.AvgFillFactor <= 0.3 || 1 < stats.AvgFillFactor {
+		t.Errorf("unexpected average fill factor %f", stats.AvgFillFactor)
+	}
+}
+
+// leafChain walks the leaves from the leftmost one and returns the keys of each leaf.
+// It fails the test if a prev pointer does not match the chain.
+func leafChain(t *testing.T, bufmgr *buffer.BufferPoolManager, bt *BTree) ([]disk.PageID, [][][]byte) {
+	t.Helper()
+	iter, err := bt.Search(bufmgr, NewSearchModeStart())
+	if err != nil {
+		t.Fatal(err)
+	}
+	firstPageID := iter.PageID()
+	iter.Close()
+	var pageIDs []disk.PageID
+	var keys [][][]byte
+	prev := disk.InvalidPageID
+	for pageID := firstPageID; pageID.Valid(); {
+		buf, err := bufmgr.FetchBuffer(pageID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		leafNode := NewNode(buf.Page[:]).AsLeaf()
+		if leafNode.PrevPageID() != prev {
+			t.Fatalf("leaf %d: expected prev %d, got %d", pageID, prev, leafNode.PrevPageID())
+		}
+		var leafKeys [][]byte
+		for i := 0; i < leafNode.NumPairs(); i++ {
+			leafKeys = append(leafKeys, leafNode.PairAt(i).Key)
+		}
+		pageIDs = append(pageIDs, pageID)
+		keys = append(keys, leafKeys)
+		prev = pageID
+		pageID = leafNode.NextPageID()
+		buf.Unpin()
+	}
+	return pageIDs, keys
+}
+
+func TestBTreeDeleteEmptyLeaf(t *testing.T) {
+	setup := func(t *testing.T) (*disk.DiskManager, *buffer.BufferPoolManager, *BTree) {
+		tmpfile, err := os.CreateTemp("", "test_btree_delete_*.db")
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { os.Remove(tmpfile.Name()) })
+
+		dm, err := disk.NewDiskManager(tmpfile)
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { dm.Close() })
+
+		pool := buffer.NewBufferPool(10)
+		bufmgr := buffer.NewBufferPoolManager(dm, pool)
+		bt, err := CreateBTree(bufmgr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for i := 0; i < 12; i++ {
+			key := make([]byte, 8)
+			binary.BigEndian.PutUint64(key, uint64(i))
+			if err := bt.Insert(bufmgr, key, make([]byte, 1000)); err != nil {
+				t.Fatal(err)
+			}
+		}
+		return dm, bufmgr, bt
+	}
+
+	countKeys := func(t *testing.T, bufmgr *buffer.BufferPoolManager, bt *BTree) int {
+		t.Helper()
+		iter, err := bt.Search(bufmgr, NewSearchModeStart())
+		if err != nil {
+			t.Fatal(err)
+		}
+		n := 0
+		for {
+			_, _, ok, err := iter.Next(bufmgr)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !ok {
+				return n
+			}
+			n++
+		}
+	}
+
+	for _, tt := range []struct {
+		name string
+		leaf func(numLeaves int) int
+	}{
+		{"First", func(int) int { return 0 }},
+		{"Middle", func(numLeaves int) int { return numLeaves / 2 }},
+		{"Last", func(numLeaves int) int { return numLeaves - 1 }},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			dm, bufmgr, bt := setup(t)
+			pageIDs, keys := leafChain(t, bufmgr, bt)
+			if len(pageIDs) < 3 {
+				t.Fatalf("expected at least 3 leaves, got %d", len(pageIDs))
+			}
+			target := tt.leaf(len(pageIDs))
+			for _, key := range keys[target] {
+				if err := bt.Delete(bufmgr, key); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			remaining, _ := leafChain(t, bufmgr, bt)
+			if len(remaining) != len(pageIDs)-1 {
+				t.Fatalf("expected %d leaves, got %d", len(pageIDs)-1, len(remaining))
+			}
+			for _, pageID := range remaining {
+				if pageID == pageIDs[target] {
+					t.Errorf("expected leaf %d to be unlinked", pageID)
+				}
+			}
+			if dm.NumFreePages() != 1 {
+				t.Errorf("expected 1 free page, got %d", dm.NumFreePages())
+			}
+			if n := countKeys(t, bufmgr, bt); n != 12-len(keys[target]) {
+				t.Errorf("expected %d keys, got %d", 12-len(keys[target]), n)
+			}
+			stats, err := bt.Stats(bufmgr)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if stats.LeafPages != len(remaining) {
+				t.Errorf("expected the parent to reference %d leaves, got %d", len(remaining), stats.LeafPages)
+			}
+			// The deleted keys can be inserted again
+			for _, key := range keys[target] {
+				if err := bt.Insert(bufmgr, key, make([]byte, 1000)); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if n := countKeys(t, bufmgr, bt); n != 12 {
+				t.Errorf("expected 12 keys after reinsertion, got %d", n)
+			}
+		})
+	}
+
+	t.Run("All", func(t *testing.T) {
+		dm, bufmgr, bt := setup(t)
+		numPages := dm.NumPages()
+		for i := 11; 0 <= i; i-- {
+			key := make([]byte, 8)
+			binary.BigEndian.PutUint64(key, uint64(i))
+			if err := bt.Delete(bufmgr, key); err != nil {
+				t.Fatal(err)
+			}
+		}
+		stats, err := bt.Stats(bufmgr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if stats.Height != 1 || stats.LeafPages != 1 || stats.KeyCount != 0 {
+			t.Errorf("expected a single empty leaf, got %+v", stats)
+		}
+		if n := countKeys(t, bufmgr, bt); n != 0 {
+			t.Errorf("expected no keys, got %d", n)
+		}
+
+		// Freed pages are reused before the heap file grows
+		for i := 0; i < 12; i++ {
+			key := make([]byte, 8)
+			binary.BigEndian.PutUint64(key, uint64(i))
+			if err := bt.Insert(bufmgr, key, make([]byte, 1000)); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if dm.NumPages() != numPages {
+			t.Errorf("expected the heap file to stay at %d pages, got %d", numPages, dm.NumPages())
+		}
+		if n := countKeys(t, bufmgr, bt); n != 12 {
+			t.Errorf("expected 12 keys, got %d", n)
+		}
+	})
+}
+
+func TestBTreeBufferExhaustion(t *testing.T) {
+	tmpfile, err := os.CreateTemp("", "test_btree_exhaustion_*.db")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(tmpfile.Name())
+
+	dm, err := disk.NewDiskManager(tmpfile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer dm.Close()
+
+	pool := buffer.NewBufferPool(10)
+	bufmgr := buffer.NewBufferPoolManager(dm, pool)
+
+	bt, err := CreateBTree(bufmgr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyOf := func(i int) []byte {
+		key := make([]byte, 8)
+		binary.BigEndian.PutUint64(key, uint64(i))
+		return key
+	}
+	for i := 0; i < 12; i++ {
+		if err := bt.Insert(bufmgr, keyOf(i), make([]byte, 1000)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// Leave only 3 buffers to the tree: enough to descend, not enough to split
+	reservation, err := bufmgr.Reserve(7)
+	if err != nil {
+		t.Fatal(err)
+	}
+	inserted := 12
+	for ; ; inserted++ {
+		err := bt.Insert(bufmgr, keyOf(inserted), make([]byte, 1000))
+		if err == buffer.ErrNoFreeBuffer {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		if 100 <= inserted {
+			t.Fatal("expected a split to run out of buffers")
+		}
+	}
+	reservation.Release()
+
+	// The failed insert did not modify the tree
+	pageIDs, keys := leafChain(t, bufmgr, bt)
+	n := 0
+	for _, leafKeys := range keys {
+		n += len(leafKeys)
+	}
+	if n != inserted {
+		t.Errorf("expected %d keys, got %d", inserted, n)
+	}
+	stats, err := bt.Stats(bufmgr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stats.LeafPages != len(pageIDs) || stats.KeyCount != inserted {
+		t.Errorf("expected %d leaves with %d keys, got %+v", len(pageIDs), inserted, stats)
+	}
+	if _, found, err := bt.OptimisticGet(bufmgr, keyOf(inserted)); err != nil || found {
+		t.Errorf("expected the failed key to be absent (%v)", err)
+	}
+
+	// With the buffers back, the same insert succeeds
+	if err := bt.Insert(bufmgr, keyOf(inserted), make([]byte, 1000)); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i <= inserted; i++ {
+		if _, found, err := bt.OptimisticGet(bufmgr, keyOf(i)); err != nil || !found {
+			t.Errorf("expected key %d to be found (%v)", i, err)
+		}
+	}
+}
@@ -0,0 +1,241 @@
+package btree
+
+import (
+	"bytes"
+
+	"github.com/Johniel/gorelly/buffer"
+	"github.com/Johniel/gorelly/disk"
+	"github.com/Johniel/gorelly/errcode"
+)
+
+var (
+	// ErrUnsortedInput is returned when the pairs given to BulkLoad are not in ascending key order.
+	ErrUnsortedInput = errcode.New(errcode.InvalidParameter, "bulk load input is not sorted by key")
+	// ErrTreeNotEmpty is returned when loading pairs into a tree that already has some.
+	ErrTreeNotEmpty = errcode.New(errcode.InvalidParameter, "tree is not empty")
+)
+
+// bulkFillFactor is the fraction of a node BulkLoad fills before starting the next one. The rest
+// is left for later inserts, which would otherwise split every node they reach.
+const bulkFillFactor = 0.9
+
+// PairIterator returns key-value pairs until ok is false. Iter and MergeIter are PairIterators,
+// so the pairs of a tree can be loaded into another one.
+type PairIterator interface {
+	Next(bufmgr *buffer.BufferPoolManager) (key []byte, value []byte, ok bool, err error)
+}
+
+// BulkLoad creates a tree holding the pairs of pairs, which must be in strictly ascending key
+// order. Instead of inserting them one by one, which splits the same nodes over and over, it
+// fills the leaves from left to right and builds the internal nodes above them level by level.
+// It returns ErrUnsortedInput or ErrDuplicateKey if the keys are not ascending, and ErrKeyTooLarge
+// or ErrPairTooLarge if a pair exceeds the Limits of the tree; the pages written so far are not
+// freed then.
+//
+// The pages are not logged: like a tree created by CreateBTree, the new tree is only reachable
+// once its meta page ID is stored somewhere.
+func BulkLoad(bufmgr *buffer.BufferPoolManager, pairs PairIterator) (*BTree, error) {
+	metaBuffer, err := bufmgr.CreateBuffer()
+	if err != nil {
+		return nil, err
+	}
+	defer metaBuffer.Unpin()
+	bt := &BTree{MetaPageID: metaBuffer.PageID}
+	rootPageID, err := bt.build(bufmgr, pairs)
+	if err != nil {
+		return nil, err
+	}
+	NewMeta(metaBuffer.Page[:]).SetRootPageID(rootPageID)
+	metaBuffer.MarkDirty()
+	return bt, nil
+}
+
+// Load is BulkLoad into an existing tree, which must be empty (ErrTreeNotEmpty otherwise), e.g.
+// one at a fixed meta page ID. The empty root leaf is replaced by the root of the loaded pairs.
+// As with BulkLoad, the pages are not logged, even if the tree has a Log.
+func (bt *BTree) Load(bufmgr *buffer.BufferPoolManager, pairs PairIterator) error {
+	defer bt.acquireWriter(bufmgr, "Load")()
+	metaBuffer, err := bufmgr.FetchBuffer(bt.MetaPageID)
+	if err != nil {
+		return err
+	}
+	defer metaBuffer.Unpin()
+	meta := NewMeta(metaBuffer.Page[:])
+	oldRootPageID := meta.RootPageID()
+	oldRootBuffer, err := bufmgr.FetchBuffer(oldRootPageID)
+	if err != nil {
+		return err
+	}
+	oldRoot := NewNode(oldRootBuffer.Page[:])
+	empty := oldRoot.IsLeaf() && oldRoot.AsLeaf().NumPairs() == 0
+	oldRootBuffer.Unpin()
+	if !empty {
+		return ErrTreeNotEmpty
+	}
+
+	rootPageID, err := bt.build(bufmgr, pairs)
+	if err != nil {
+		return err
+	}
+	metaBuffer.WriteLatch()
+	meta.SetRootPageID(rootPageID)
+	metaBuffer.MarkDirty()
+	metaBuffer.WriteUnlatch(true)
+	bufmgr.FreeBuffer(oldRootPageID)
+	return nil
+}
+
+// bulkChild is a node built by BulkLoad, to be added to its parent.
+type bulkChild struct {
+	minKey []byte // Smallest key of the subtree, the separator of the child in its parent
+	pageID disk.PageID
+	count  uint64 // Number of entries of the subtree
+}
+
+// build writes the leaves of the pairs and the internal nodes above them, and returns the page
+// ID of the root.
+func (bt *BTree) build(bufmgr *buffer.BufferPoolManager, pairs PairIterator) (disk.PageID, error) {
+	level, err := bt.buildLeaves(bufmgr, pairs)
+	if err != nil {
+		return disk.InvalidPageID, err
+	}
+	for 1 < len(level) {
+		level, err = buildBranches(bufmgr, level)
+		if err != nil {
+			return disk.InvalidPageID, err
+		}
+	}
+	return level[0].pageID, nil
+}
+
+// buildLeaves writes the pairs into linked leaves, and the values too large for them into
+// overflow pages, and returns the leaves. Without pairs, it returns a single empty leaf.
+func (bt *BTree) buildLeaves(bufmgr *buffer.BufferPoolManager, pairs PairIterator) ([]bulkChild, error) {
+	limits := bt.Limits()
+	var leaves []bulkChild
+	var leafBuffer *buffer.Buffer
+	defer func() {
+		if leafBuffer != nil {
+			leafBuffer.Unpin()
+		}
+	}()
+	// startLeaf finishes the current leaf, if any, and starts the next one, linked to it
+	startLeaf := func(minKey []byte) error {
+		newBuffer, err := bufmgr.CreateBuffer()
+		if err != nil {
+			return err
+		}
+		newNode := NewNode(newBuffer.Page[:])
+		newNode.InitializeAsLeaf()
+		newNode.AsLeaf().Initialize()
+		if leafBuffer != nil {
+			NewNode(leafBuffer.Page[:]).AsLeaf().SetNextPageID(newBuffer.PageID)
+			newNode.AsLeaf().SetPrevPageID(leafBuffer.PageID)
+			bt.finishLeaf(leafBuffer, &leaves[len(leaves)-1])
+			leafBuffer.Unpin()
+		}
+		leafBuffer = newBuffer
+		leaves = append(leaves, bulkChild{minKey: append([]byte(nil), minKey...), pageID: newBuffer.PageID})
+		return nil
+	}
+
+	var prevKey []byte
+	for {
+		key, value, ok, err := pairs.Next(bufmgr)
+		if err != nil {
+			return nil, err
+		}
+		if !ok {
+			break
+		}
+		if err := limits.check(key, value); err != nil {
+			return nil, err
+		}
+		if leafBuffer != nil {
+			switch cmp := bytes.Compare(prevKey, key); {
+			case cmp == 0:
+				return nil, ErrDuplicateKey
+			case 0 < cmp:
+				return nil, ErrUnsortedInput
+			}
+		}
+		prevKey = append(prevKey[:0], key...)
+		pair, err := storedPair(bufmgr, nil, limits, key, value)
+		if err != nil {
+			return nil, err
+		}
+
+		if leafBuffer == nil || bulkFillFactor <= NewNode(leafBuffer.Page[:]).AsLeaf().FillFactor() {
+			if err := startLeaf(key); err != nil {
+				return nil, err
+			}
+		}
+		leafNode := NewNode(leafBuffer.Page[:]).AsLeaf()
+		if !leafNode.InsertPair(leafNode.NumPairs(), pair) {
+			if err := startLeaf(key); err != nil {
+				return nil, err
+			}
+			leafNode = NewNode(leafBuffer.Page[:]).AsLeaf()
+			if !leafNode.InsertPair(0, pair) {
+				panic("new leaf must have space")
+			}
+		}
+	}
+	if leafBuffer == nil {
+		if err := startLeaf(nil); err != nil {
+			return nil, err
+		}
+	}
+	bt.finishLeaf(leafBuffer, &leaves[len(leaves)-1])
+	return leaves, nil
+}
+
+// finishLeaf records the entry count of a filled leaf.
+func (bt *BTree) finishLeaf(leafBuffer *buffer.Buffer, child *bulkChild) {
+	child.count = uint64(NewNode(leafBuffer.Page[:]).AsLeaf().NumPairs())
+	leafBuffer.MarkDirty()
+}
+
+// buildBranches writes the internal nodes above the nodes of a level, at least two, and returns
+// them. A node with the children c0..cn holds the pairs (minKey(c1), c0) .. (minKey(cn), cn-1)
+// and cn as its right child.
+func buildBranches(bufmgr *buffer.BufferPoolManager, children []bulkChild) ([]bulkChild, error) {
+	var branches []bulkChild
+	for start := 0; start < len(children); {
+		branchBuffer, err := bufmgr.CreateBuffer()
+		if err != nil {
+			return nil, err
+		}
+		node := NewNode(branchBuffer.Page[:])
+		node.InitializeAsBranch()
+		branchNode := node.AsBranch()
+		branchNode.Initialize(children[start+1].minKey, children[start].pageID, children[start+1].pageID)
+		branchNode.SetChildCount(0, children[start].count)
+		branchNode.SetChildCount(1, children[start+1].count)
+		end := start + 2
+		for ; end < len(children); end++ {
+			// Every node needs two children: a single child left over is added beyond the fill factor
+			if len(children)-end != 1 && bulkFillFactor <= branchNode.FillFactor() {
+				break
+			}
+			// The right child becomes a pair, and the next child the right child
+			last := branchNode.NumPairs()
+			if !branchNode.Insert(last, children[end].minKey, children[end-1].pageID) {
+				break
+			}
+			branchNode.SetRightChild(children[end].pageID)
+			branchNode.SetChildCount(last, children[end-1].count)
+			branchNode.SetChildCount(last+1, children[end].count)
+		}
+		if len(children)-end == 1 {
+			// The last child did not fit: hand over the right child to the node of the last one
+			branchNode.RemoveChild(branchNode.NumPairs())
+			end--
+		}
+		branchBuffer.MarkDirty()
+		branches = append(branches, bulkChild{minKey: children[start].minKey, pageID: branchBuffer.PageID, count: branchNode.EntryCount()})
+		branchBuffer.Unpin()
+		start = end
+	}
+	return branches, nil
+}
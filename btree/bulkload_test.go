@@ -0,0 +1,243 @@
+package btree
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math/rand"
+	"os"
+	"testing"
+
+	"github.com/Johniel/gorelly/buffer"
+	"github.com/Johniel/gorelly/disk"
+)
+
+// pairSlice is a PairIterator over pairs held in memory.
+type pairSlice struct {
+	keys   [][]byte
+	values [][]byte
+}
+
+func (ps *pairSlice) Next(bufmgr *buffer.BufferPoolManager) ([]byte, []byte, bool, error) {
+	if len(ps.keys) == 0 {
+		return nil, nil, false, nil
+	}
+	key, value := ps.keys[0], ps.values[0]
+	ps.keys, ps.values = ps.keys[1:], ps.values[1:]
+	return key, value, true, nil
+}
+
+// sortedPairs returns n pairs in ascending key order, with keys of keySize bytes.
+func sortedPairs(n int, keySize int) *pairSlice {
+	ps := &pairSlice{}
+	for i := 0; i < n; i++ {
+		key := make([]byte, keySize)
+		binary.BigEndian.PutUint64(key, uint64(i))
+		ps.keys = append(ps.keys, key)
+		ps.values = append(ps.values, []byte(fmt.Sprintf("value%d", i)))
+	}
+	return ps
+}
+
+// checkSubtree checks that the keys of the subtree of pageID are in [lo, hi) (nil is unbounded)
+// and returns the depth of its leaves, failing the test if they are not all at the same depth.
+func checkSubtree(t *testing.T, bufmgr *buffer.BufferPoolManager, pageID disk.PageID, lo []byte, hi []byte) int {
+	t.Helper()
+	buf, err := bufmgr.FetchBuffer(pageID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer buf.Unpin()
+	node := NewNode(buf.Page[:])
+	if node.IsLeaf() {
+		leafNode := node.AsLeaf()
+		for i := 0; i < leafNode.NumPairs(); i++ {
+			key := leafNode.PairAt(i).Key
+			if (lo != nil && bytes.Compare(key, lo) < 0) || (hi != nil && 0 <= bytes.Compare(key, hi)) {
+				t.Fatalf("leaf %d: key %x out of [%x, %x)", pageID, key, lo, hi)
+			}
+		}
+		return 1
+	}
+	branchNode := node.AsBranch()
+	if branchNode.NumPairs() == 0 {
+		t.Fatalf("branch %d has a single child", pageID)
+	}
+	depth := 0
+	for i := 0; i <= branchNode.NumPairs(); i++ {
+		childLo, childHi := lo, hi
+		if 0 < i {
+			childLo = branchNode.PairAt(i - 1).Key
+		}
+		if i < branchNode.NumPairs() {
+			childHi = branchNode.PairAt(i).Key
+		}
+		d := checkSubtree(t, bufmgr, branchNode.ChildAt(i), childLo, childHi)
+		if depth != 0 && d != depth {
+			t.Fatalf("branch %d: leaves at depths %d and %d", pageID, depth, d)
+		}
+		depth = d
+	}
+	return depth + 1
+}
+
+func TestBulkLoad(t *testing.T) {
+	tmpfile, err := os.CreateTemp("", "test_btree_bulkload_*.db")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(tmpfile.Name())
+
+	dm, err := disk.NewDiskManager(tmpfile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer dm.Close()
+
+	pool := buffer.NewBufferPool(10)
+	bufmgr := buffer.NewBufferPoolManager(dm, pool)
+
+	// Large keys make internal nodes of three children, which leaves a single child over when
+	// the number of leaves is not a multiple of three
+	for _, tc := range []struct {
+		numPairs int
+		keySize  int
+	}{{0, 8}, {1, 8}, {20000, 8}, {40, 1500}, {41, 1500}, {42, 1500}, {300, 1500}} {
+		t.Run(fmt.Sprintf("%dx%d", tc.numPairs, tc.keySize), func(t *testing.T) {
+			expected := sortedPairs(tc.numPairs, tc.keySize)
+			bt, err := BulkLoad(bufmgr, sortedPairs(tc.numPairs, tc.keySize))
+			if err != nil {
+				t.Fatal(err)
+			}
+			rootPageID, err := bt.rootPageID(bufmgr)
+			if err != nil {
+				t.Fatal(err)
+			}
+			checkSubtree(t, bufmgr, rootPageID, nil, nil)
+			stats, err := bt.Stats(bufmgr)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if stats.KeyCount != tc.numPairs {
+				t.Errorf("expected %d keys, got %d", tc.numPairs, stats.KeyCount)
+			}
+			if count, err := bt.EstimateRange(bufmgr, nil, nil); err != nil || count != uint64(tc.numPairs) {
+				t.Errorf("expected an entry count of %d, got %d, %v", tc.numPairs, count, err)
+			}
+
+			// The leaves are linked in key order both ways
+			_, leafKeys := leafChain(t, bufmgr, bt)
+			var keys [][]byte
+			for _, k := range leafKeys {
+				keys = append(keys, k...)
+			}
+			if len(keys) != tc.numPairs {
+				t.Fatalf("expected %d keys in the leaves, got %d", tc.numPairs, len(keys))
+			}
+			iter, err := bt.Search(bufmgr, SearchMode{IsStart: true, Reverse: true})
+			if err != nil {
+				t.Fatal(err)
+			}
+			for i := tc.numPairs - 1; 0 <= i; i-- {
+				key, value, ok, err := iter.Next(bufmgr)
+				if err != nil || !ok || !bytes.Equal(key, expected.keys[i]) || !bytes.Equal(value, expected.values[i]) {
+					t.Fatalf("expected pair %d in reverse order, got %x, %v, %v", i, key, ok, err)
+				}
+			}
+			iter.Close()
+
+			// The tree can be searched and modified as usual
+			for i := 0; i < tc.numPairs; i += 7 {
+				value, found, err := bt.OptimisticGet(bufmgr, expected.keys[i])
+				if err != nil || !found || !bytes.Equal(value, expected.values[i]) {
+					t.Fatalf("expected %q for key %d, got %q, %v, %v", expected.values[i], i, value, found, err)
+				}
+			}
+			extra := bytes.Repeat([]byte{0xff}, tc.keySize)
+			if err := bt.Insert(bufmgr, extra, []byte("extra")); err != nil {
+				t.Fatal(err)
+			}
+			for i := 0; i < tc.numPairs; i += 2 {
+				if err := bt.Delete(bufmgr, expected.keys[i]); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if stats, err := bt.Stats(bufmgr); err != nil || stats.KeyCount != tc.numPairs/2+1 {
+				t.Errorf("expected %d keys after the changes, got %+v, %v", tc.numPairs/2+1, stats, err)
+			}
+		})
+	}
+
+	t.Run("Unsorted", func(t *testing.T) {
+		pairs := sortedPairs(100, 8)
+		pairs.keys[50], pairs.keys[51] = pairs.keys[51], pairs.keys[50]
+		if _, err := BulkLoad(bufmgr, pairs); !errors.Is(err, ErrUnsortedInput) {
+			t.Errorf("expected ErrUnsortedInput, got %v", err)
+		}
+		pairs = sortedPairs(100, 8)
+		pairs.keys[51] = pairs.keys[50]
+		if _, err := BulkLoad(bufmgr, pairs); !errors.Is(err, ErrDuplicateKey) {
+			t.Errorf("expected ErrDuplicateKey, got %v", err)
+		}
+	})
+
+	t.Run("Load", func(t *testing.T) {
+		bt, err := CreateBTree(bufmgr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := bt.Load(bufmgr, sortedPairs(1000, 8)); err != nil {
+			t.Fatal(err)
+		}
+		if stats, err := bt.Stats(bufmgr); err != nil || stats.KeyCount != 1000 {
+			t.Errorf("expected 1000 keys, got %+v, %v", stats, err)
+		}
+		if err := bt.Load(bufmgr, sortedPairs(10, 8)); !errors.Is(err, ErrTreeNotEmpty) {
+			t.Errorf("expected ErrTreeNotEmpty, got %v", err)
+		}
+	})
+}
+
+func BenchmarkBuild(b *testing.B) {
+	const numPairs = 100000
+	setup := func(b *testing.B) *buffer.BufferPoolManager {
+		tmpfile, err := os.CreateTemp("", "bench_btree_build_*.db")
+		if err != nil {
+			b.Fatal(err)
+		}
+		b.Cleanup(func() { os.Remove(tmpfile.Name()) })
+		dm, err := disk.NewDiskManager(tmpfile)
+		if err != nil {
+			b.Fatal(err)
+		}
+		b.Cleanup(func() { dm.Close() })
+		return buffer.NewBufferPoolManager(dm, buffer.NewBufferPool(64))
+	}
+
+	b.Run("Insert", func(b *testing.B) {
+		bufmgr := setup(b)
+		perm := rand.New(rand.NewSource(1)).Perm(numPairs)
+		pairs := sortedPairs(numPairs, 8)
+		for b.Loop() {
+			bt, err := CreateBTree(bufmgr)
+			if err != nil {
+				b.Fatal(err)
+			}
+			for _, i := range perm {
+				if err := bt.Insert(bufmgr, pairs.keys[i], pairs.values[i]); err != nil {
+					b.Fatal(err)
+				}
+			}
+		}
+	})
+	b.Run("BulkLoad", func(b *testing.B) {
+		bufmgr := setup(b)
+		pairs := sortedPairs(numPairs, 8)
+		for b.Loop() {
+			if _, err := BulkLoad(bufmgr, &pairSlice{keys: pairs.keys, values: pairs.values}); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+}
@@ -0,0 +1,57 @@
+package btree
+
+import (
+	"github.com/Johniel/gorelly/buffer"
+	"github.com/Johniel/gorelly/disk"
+)
+
+// Drop frees every page of the tree, including its meta page and its overflow pages, so that the buffer pool manager
+// reuses them for new pages (e.g. for DROP TABLE), and returns the number of pages freed.
+// The tree must not be used anymore, by this or any other session, once Drop is called.
+// Freeing is not logged: a dropped tree cannot be restored by rolling back a transaction. The
+// freed pages are passed to the tree's Log, so that a transaction that created the tree does not
+// free them again when it is rolled back, and a log that defers frees frees them.
+func (bt *BTree) Drop(bufmgr *buffer.BufferPoolManager) (int, error) {
+	rootPageID, err := bt.rootPageID(bufmgr)
+	if err != nil {
+		return 0, err
+	}
+	pageIDs := []disk.PageID{bt.MetaPageID}
+	level := []disk.PageID{rootPageID}
+	for 0 < len(level) {
+		pageIDs = append(pageIDs, level...)
+		var nextLevel []disk.PageID
+		for _, pageID := range level {
+			nodeBuffer, err := bufmgr.FetchBuffer(pageID)
+			if err != nil {
+				return 0, err
+			}
+			node := NewNode(nodeBuffer.Page[:])
+			var pointers [][]byte
+			if node.IsBranch() {
+				branchNode := node.AsBranch()
+				for i := 0; i <= branchNode.NumPairs(); i++ {
+					nextLevel = append(nextLevel, branchNode.ChildAt(i))
+				}
+			} else if node.IsLeaf() {
+				leafNode := node.AsLeaf()
+				for i := 0; i < leafNode.NumPairs(); i++ {
+					if pair := leafNode.PairAt(i); pair.Overflow {
+						pointers = append(pointers, pair.Value)
+					}
+				}
+			}
+			nodeBuffer.Unpin()
+			for _, pointer := range pointers {
+				chain, err := overflowPageIDs(bufmgr, pointer)
+				if err != nil {
+					return 0, err
+				}
+				pageIDs = append(pageIDs, chain...)
+			}
+		}
+		level = nextLevel
+	}
+	freePages(bufmgr, bt.Log, pageIDs)
+	return len(pageIDs), nil
+}
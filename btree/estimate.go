@@ -0,0 +1,179 @@
+package btree
+
+import (
+	"bytes"
+
+	"github.com/Johniel/gorelly/buffer"
+	"github.com/Johniel/gorelly/disk"
+)
+
+// EstimateRange returns the approximate number of keys k with lo <= k < hi; a nil lo or hi
+// leaves that side of the range open. It reads at most two pages per level: the leaves holding
+// the bounds are counted exactly, and the subtrees in between contribute the entry counts their
+// parents keep.
+//
+// The entry counts of a node's children are refreshed when one of them splits, so they lag
+// behind inserts and deletes that did not split a node; RefreshEntryCounts makes them exact.
+// Nodes written before entry counts were kept count as empty until then.
+func (bt *BTree) EstimateRange(bufmgr *buffer.BufferPoolManager, lo []byte, hi []byte) (uint64, error) {
+	if lo != nil && hi != nil && bytes.Compare(hi, lo) <= 0 {
+		return 0, nil
+	}
+	rootPageID, err := bt.rootPageID(bufmgr)
+	if err != nil {
+		return 0, err
+	}
+	return bt.estimateRange(bufmgr, rootPageID, lo, hi)
+}
+
+func (bt *BTree) estimateRange(bufmgr *buffer.BufferPoolManager, pageID disk.PageID, lo []byte, hi []byte) (uint64, error) {
+	nodeBuffer, err := bufmgr.FetchBuffer(pageID)
+	if err != nil {
+		return 0, err
+	}
+	defer nodeBuffer.Unpin()
+	node := NewNode(nodeBuffer.Page[:])
+	if node.IsLeaf() {
+		leafNode := node.AsLeaf()
+		var count uint64
+		for i := 0; i < leafNode.NumPairs(); i++ {
+			key := leafNode.PairAt(i).Key
+			if (lo == nil || 0 <= bytes.Compare(key, lo)) && (hi == nil || bytes.Compare(key, hi) < 0) {
+				count++
+			}
+		}
+		return count, nil
+	}
+
+	internalNode := node.AsBranch()
+	loIdx, hiIdx := 0, internalNode.NumPairs()
+	if lo != nil {
+		loIdx = internalNode.SearchChildIdx(lo)
+	}
+	if hi != nil {
+		hiIdx = internalNode.SearchChildIdx(hi)
+	}
+	loChild, hiChild := internalNode.ChildAt(loIdx), internalNode.ChildAt(hiIdx)
+	if loIdx == hiIdx {
+		return bt.estimateRange(bufmgr, loChild, lo, hi)
+	}
+	var count uint64
+	for i := loIdx + 1; i < hiIdx; i++ {
+		count += internalNode.ChildCount(i)
+	}
+	// Only the bound matters on each side; the rest of the child is within the range
+	loCount, err := bt.estimateRange(bufmgr, loChild, lo, nil)
+	if err != nil {
+		return 0, err
+	}
+	hiCount, err := bt.estimateRange(bufmgr, hiChild, nil, hi)
+	if err != nil {
+		return 0, err
+	}
+	return count + loCount + hiCount, nil
+}
+
+// RefreshEntryCounts walks the whole tree and makes the entry counts kept by the internal
+// nodes exact, e.g. after a bulk of inserts and deletes or after upgrading a tree written before
+// entry counts were kept. Like Stats it reads every page; writers must not run concurrently.
+// It returns the number of keys in the tree.
+func (bt *BTree) RefreshEntryCounts(bufmgr *buffer.BufferPoolManager) (uint64, error) {
+	defer bt.acquireWriter(bufmgr, "RefreshEntryCounts")()
+	rootPageID, err := bt.rootPageID(bufmgr)
+	if err != nil {
+		return 0, err
+	}
+	return bt.refreshEntryCounts(bufmgr, rootPageID)
+}
+
+func (bt *BTree) refreshEntryCounts(bufmgr *buffer.BufferPoolManager, pageID disk.PageID) (uint64, error) {
+	nodeBuffer, err := bufmgr.FetchBuffer(pageID)
+	if err != nil {
+		return 0, err
+	}
+	defer nodeBuffer.Unpin()
+	node := NewNode(nodeBuffer.Page[:])
+	if node.IsLeaf() {
+		return uint64(node.AsLeaf().NumPairs()), nil
+	}
+	internalNode := node.AsBranch()
+	children := make([]disk.PageID, internalNode.NumPairs()+1)
+	for i := range children {
+		children[i] = internalNode.ChildAt(i)
+	}
+	counts := make([]uint64, len(children))
+	var total uint64
+	for i, child := range children {
+		count, err := bt.refreshEntryCounts(bufmgr, child)
+		if err != nil {
+			return 0, err
+		}
+		counts[i] = count
+		total += count
+	}
+
+	nodeBuffer.WriteLatch()
+	internalNode = NewNode(nodeBuffer.Page[:]).AsBranch()
+	for i, count := range counts {
+		internalNode.SetChildCount(i, count)
+	}
+	nodeBuffer.MarkDirty()
+	nodeBuffer.WriteUnlatch(true)
+	return total, nil
+}
+
+// SplitKeys returns at most n-1 keys in ascending order that split the tree into n ranges of
+// about the same number of pages, e.g. to scan it with n workers: (-inf, keys[0]),
+// [keys[0], keys[1]), ..., [keys[len(keys)-1], +inf). The keys are separators of the first
+// level of internal nodes with at least n children, or of the level above the leaves, so they
+// need not be keys of the tree; a tree whose root is a leaf is not split.
+func (bt *BTree) SplitKeys(bufmgr *buffer.BufferPoolManager, n int) ([][]byte, error) {
+	rootPageID, err := bt.rootPageID(bufmgr)
+	if err != nil {
+		return nil, err
+	}
+	// The children of a level in key order, with the separator between each two of them
+	children := []disk.PageID{rootPageID}
+	var keys [][]byte
+	for len(children) < n {
+		var nextChildren []disk.PageID
+		var nextKeys [][]byte
+		for i, pageID := range children {
+			nodeBuffer, err := bufmgr.FetchBuffer(pageID)
+			if err != nil {
+				return nil, err
+			}
+			node := NewNode(nodeBuffer.Page[:])
+			if node.IsLeaf() {
+				nodeBuffer.Unpin()
+				return evenlySpaced(keys, n), nil
+			}
+			if 0 < i {
+				nextKeys = append(nextKeys, keys[i-1])
+			}
+			internalNode := node.AsBranch()
+			for j := 0; j <= internalNode.NumPairs(); j++ {
+				if j < internalNode.NumPairs() {
+					nextKeys = append(nextKeys, bytes.Clone(internalNode.PairAt(j).Key))
+				}
+				nextChildren = append(nextChildren, internalNode.ChildAt(j))
+			}
+			nodeBuffer.Unpin()
+		}
+		children, keys = nextChildren, nextKeys
+	}
+	return evenlySpaced(keys, n), nil
+}
+
+// evenlySpaced returns n-1 of the separators of len(keys)+1 ranges that split them into n
+// groups of about the same number of ranges, or all of them if there are fewer.
+func evenlySpaced(keys [][]byte, n int) [][]byte {
+	if len(keys) < n {
+		return keys
+	}
+	picked := make([][]byte, 0, n-1)
+	for i := 1; i < n; i++ {
+		picked = append(picked, keys[i*(len(keys)+1)/n-1])
+	}
+	return picked
+}
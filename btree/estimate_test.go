@@ -0,0 +1,146 @@
+package btree
+
+import (
+	"bytes"
+	"encoding/binary"
+	"math/rand"
+	"os"
+	"testing"
+
+	"github.com/Johniel/gorelly/buffer"
+	"github.com/Johniel/gorelly/disk"
+)
+
+func TestBTreeEstimateRange(t *testing.T) {
+	tmpfile, err := os.CreateTemp("", "test_btree_estimate_*.db")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(tmpfile.Name())
+
+	dm, err := disk.NewDiskManager(tmpfile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer dm.Close()
+
+	pool := buffer.NewBufferPool(10)
+	bufmgr := buffer.NewBufferPoolManager(dm, pool)
+
+	bt, err := CreateBTree(bufmgr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	key := func(i int) []byte {
+		k := make([]byte, 8)
+		binary.BigEndian.PutUint64(k, uint64(i))
+		return k
+	}
+	const numKeys = 5000
+	value := make([]byte, 64)
+	for _, i := range rand.New(rand.NewSource(1)).Perm(numKeys) {
+		if err := bt.Insert(bufmgr, key(i), value); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	ranges := []struct {
+		lo, hi   []byte
+		expected uint64
+	}{
+		{nil, nil, numKeys},
+		{key(1000), key(2000), 1000},
+		{key(10), key(11), 1},
+		{nil, key(2500), 2500},
+		{key(4000), nil, 1000},
+		{key(2000), key(1000), 0},
+	}
+
+	t.Run("Approximate", func(t *testing.T) {
+		// Counts are only refreshed on splits, so they may lag; they must still be close
+		for _, r := range ranges {
+			count, err := bt.EstimateRange(bufmgr, r.lo, r.hi)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if count < r.expected/2 || r.expected*2 < count {
+				t.Errorf("range %x-%x: expected about %d, got %d", r.lo, r.hi, r.expected, count)
+			}
+		}
+	})
+
+	t.Run("ReadsOnlyBoundaryPaths", func(t *testing.T) {
+		stats, err := bt.Stats(bufmgr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if stats.Height < 3 {
+			t.Fatalf("expected a tree of at least 3 levels, got %d", stats.Height)
+		}
+		before := bufmgr.IOStats()
+		if _, err := bt.EstimateRange(bufmgr, key(100), key(4900)); err != nil {
+			t.Fatal(err)
+		}
+		if read := bufmgr.IOStats().Sub(before).PagesRead; 2*uint64(stats.Height)+1 < read {
+			t.Errorf("expected at most %d pages read, got %d", 2*stats.Height+1, read)
+		}
+	})
+
+	t.Run("SplitKeys", func(t *testing.T) {
+		for _, n := range []int{1, 4, 1000} {
+			keys, err := bt.SplitKeys(bufmgr, n)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if n <= 4 && len(keys) != n-1 {
+				t.Fatalf("n=%d: expected %d keys, got %d", n, n-1, len(keys))
+			}
+			if n-1 < len(keys) {
+				t.Fatalf("n=%d: expected at most %d keys, got %d", n, n-1, len(keys))
+			}
+			for i := 1; i < len(keys); i++ {
+				if bytes.Compare(keys[i-1], keys[i]) >= 0 {
+					t.Fatalf("n=%d: keys not ascending: %x, %x", n, keys[i-1], keys[i])
+				}
+			}
+			if n != 4 {
+				continue
+			}
+			bounds := append(append([][]byte{nil}, keys...), nil)
+			expected := uint64(numKeys / n)
+			for i := 0; i < n; i++ {
+				count, err := bt.EstimateRange(bufmgr, bounds[i], bounds[i+1])
+				if err != nil {
+					t.Fatal(err)
+				}
+				if count < expected/2 || expected*2 < count {
+					t.Errorf("range %d: expected about %d keys, got %d", i, expected, count)
+				}
+			}
+		}
+	})
+
+	t.Run("Refreshed", func(t *testing.T) {
+		for i := 0; i < numKeys; i += 2 {
+			if err := bt.Delete(bufmgr, key(i)); err != nil {
+				t.Fatal(err)
+			}
+		}
+		total, err := bt.RefreshEntryCounts(bufmgr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if total != numKeys/2 {
+			t.Errorf("expected %d keys, got %d", numKeys/2, total)
+		}
+		for _, r := range ranges {
+			count, err := bt.EstimateRange(bufmgr, r.lo, r.hi)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if count != r.expected/2 {
+				t.Errorf("range %x-%x: expected %d, got %d", r.lo, r.hi, r.expected/2, count)
+			}
+		}
+	})
+}
@@ -4,6 +4,8 @@
 package internal
 
 import (
+	"encoding/binary"
+	"math"
 	"unsafe"
 
 	"github.com/Johniel/gorelly/bsearch"
@@ -26,7 +28,10 @@ type InternalHeader struct {
 //
 // Body structure:
 //   - body is a Slotted page structure that manages variable-length Pair records
-//   - Each Pair contains a key and a child page ID (encoded as bytes)
+//   - Each Pair contains a key and a child value: the child page ID (8 bytes) followed by the
+//     approximate number of entries in the child's subtree (8 bytes). Pairs written before entry
+//     counts were kept have only the page ID, and count as 0 entries.
+//   - The entry count of the right child is kept in the slotted header's Aux word
 //   - Pairs are stored in sorted order by key
 //   - The body manages the storage layout: pointer array at the beginning,
 //     free space in the middle, and data records at the end (stored backwards)
@@ -89,6 +94,60 @@ func (n *InternalNode) ChildAt(childIdx int) disk.PageID {
 	return disk.PageIDFromBytes(n.PairAt(childIdx).Value)
 }
 
+// ChildCount returns the approximate number of entries in the subtree of the child at childIdx.
+// Counts are refreshed when the child splits (see SetChildCount), not on every insert and delete.
+func (n *InternalNode) ChildCount(childIdx int) uint64 {
+	if childIdx == n.NumPairs() {
+		return uint64(n.body.Aux())
+	}
+	value := n.PairAt(childIdx).Value
+	if len(value) < ChildValueSize {
+		return 0
+	}
+	return binary.LittleEndian.Uint64(value[8:])
+}
+
+// SetChildCount sets the entry count of the child at childIdx. It returns false if the count
+// could not be stored because a pair written without a count cannot grow in place.
+func (n *InternalNode) SetChildCount(childIdx int, count uint64) bool {
+	if childIdx == n.NumPairs() {
+		n.body.SetAux(uint32(min(count, math.MaxUint32)))
+		return true
+	}
+	pair := n.PairAt(childIdx)
+	if len(pair.Value) < ChildValueSize {
+		pair.Value = childValue(disk.PageIDFromBytes(pair.Value), count)
+		pairBytes := pair.ToBytes()
+		if !n.body.Resize(childIdx, len(pairBytes)) {
+			return false
+		}
+		copy(n.body.Data(childIdx), pairBytes)
+		return true
+	}
+	data := n.body.Data(childIdx)
+	binary.LittleEndian.PutUint64(data[len(data)-8:], count)
+	return true
+}
+
+// ChildIdx returns the index of the child with the given page ID.
+func (n *InternalNode) ChildIdx(pageID disk.PageID) (int, bool) {
+	for i := 0; i <= n.NumPairs(); i++ {
+		if n.ChildAt(i) == pageID {
+			return i, true
+		}
+	}
+	return 0, false
+}
+
+// EntryCount returns the approximate number of entries in the subtree of the node.
+func (n *InternalNode) EntryCount() uint64 {
+	var count uint64
+	for i := 0; i <= n.NumPairs(); i++ {
+		count += n.ChildCount(i)
+	}
+	return count
+}
+
 func (n *InternalNode) PairAt(slotID int) *Pair {
 	data := n.body.Data(slotID)
 	return PairFromBytes(data)
@@ -98,27 +157,44 @@ func (n *InternalNode) MaxPairSize() int {
 	return n.body.Capacity()/2 - 4 // slotted.PointerSize
 }
 
+// IsInsertSafe reports whether any pair of up to MaxPairSize bytes fits without a split.
+func (n *InternalNode) IsInsertSafe() bool {
+	return n.MaxPairSize()+slotted.PointerSize <= n.body.FreeSpace()
+}
+
+// FillFactor returns the fraction of the body that is in use (0 to 1).
+func (n *InternalNode) FillFactor() float64 {
+	return float64(n.body.Capacity()-n.body.FreeSpace()) / float64(n.body.Capacity())
+}
+
 func (n *InternalNode) Initialize(key []byte, leftChild disk.PageID, rightChild disk.PageID) {
 	n.body.Initialize()
 	n.Insert(0, key, leftChild)
 	n.header.RightChild = rightChild
 }
 
+// SetRightChild sets the rightmost child, e.g. while appending children in key order.
+func (n *InternalNode) SetRightChild(pageID disk.PageID) {
+	n.header.RightChild = pageID
+}
+
 func (n *InternalNode) FillRightChild() []byte {
 	lastId := n.NumPairs() - 1
 	pair := n.PairAt(lastId)
 	rightChild := disk.PageIDFromBytes(pair.Value)
+	count := n.ChildCount(lastId)
 	keyVec := make([]byte, len(pair.Key))
 	copy(keyVec, pair.Key)
 	n.body.Remove(lastId)
 	n.header.RightChild = rightChild
+	n.SetChildCount(n.NumPairs(), count)
 	return keyVec
 }
 
 func (n *InternalNode) Insert(slotID int, key []byte, pageId disk.PageID) bool {
 	pair := &Pair{
 		Key:   key,
-		Value: pageId.ToBytes(),
+		Value: childValue(pageId, 0),
 	}
 	pairBytes := pair.ToBytes()
 	if len(pairBytes) > n.MaxPairSize() {
@@ -131,6 +207,21 @@ func (n *InternalNode) Insert(slotID int, key []byte, pageId disk.PageID) bool {
 	return true
 }
 
+// RemoveChild removes the child at childIdx together with one of the keys next to it.
+// The key range of the removed child is merged into a neighbouring child, so the child
+// must not hold any key.
+func (n *InternalNode) RemoveChild(childIdx int) {
+	if childIdx < n.NumPairs() {
+		n.body.Remove(childIdx)
+		return
+	}
+	lastId := n.NumPairs() - 1
+	n.header.RightChild = disk.PageIDFromBytes(n.PairAt(lastId).Value)
+	count := n.ChildCount(lastId)
+	n.body.Remove(lastId)
+	n.SetChildCount(n.NumPairs(), count)
+}
+
 func (n *InternalNode) IsHalfFull() bool {
 	return 2*n.body.FreeSpace() < n.body.Capacity()
 }
@@ -170,6 +261,16 @@ func (n *InternalNode) Transfer(dest *InternalNode) {
 	n.body.Remove(0)
 }
 
+// ChildValueSize is the size of the value of a pair: the child page ID and its entry count.
+const ChildValueSize = 16
+
+func childValue(pageId disk.PageID, count uint64) []byte {
+	value := make([]byte, ChildValueSize)
+	copy(value, pageId.ToBytes())
+	binary.LittleEndian.PutUint64(value[8:], count)
+	return value
+}
+
 func compareBytes(a, b []byte) int {
 	minLen := len(a)
 	if len(b) < minLen {
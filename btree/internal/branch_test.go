@@ -9,7 +9,7 @@ import (
 )
 
 func TestInternalNodeInsertSearch(t *testing.T) {
-	data := make([]byte, 100)
+	data := make([]byte, 124) // Room for three 32-byte pairs
 	node := NewInternalNode(data)
 
 	key5 := make([]byte, 8)
@@ -51,7 +51,7 @@ func TestInternalNodeInsertSearch(t *testing.T) {
 }
 
 func TestInternalNodeSplit(t *testing.T) {
-	data := make([]byte, 100)
+	data := make([]byte, 124) // Room for three 32-byte pairs
 	node := NewInternalNode(data)
 
 	key5 := make([]byte, 8)
@@ -70,7 +70,7 @@ func TestInternalNodeSplit(t *testing.T) {
 		t.Fatal("failed to insert key11")
 	}
 
-	data2 := make([]byte, 100)
+	data2 := make([]byte, 124) // Room for three 32-byte pairs
 	node2 := NewInternalNode(data2)
 	key10 := make([]byte, 8)
 	binary.BigEndian.PutUint64(key10, 10)
@@ -116,3 +116,47 @@ func makeUint64Key(val uint64) []byte {
 	binary.BigEndian.PutUint64(key, val)
 	return key
 }
+
+func TestInternalNodeChildCount(t *testing.T) {
+	data := make([]byte, 124)
+	node := NewInternalNode(data)
+	node.Initialize(makeUint64Key(5), disk.PageID(1), disk.PageID(2))
+	if !node.Insert(1, makeUint64Key(8), disk.PageID(3)) {
+		t.Fatal("failed to insert key8")
+	}
+	for i, count := range []uint64{10, 20, 30} {
+		if !node.SetChildCount(i, count) {
+			t.Fatalf("failed to set the count of child %d", i)
+		}
+	}
+	if node.EntryCount() != 60 {
+		t.Errorf("expected 60 entries, got %d", node.EntryCount())
+	}
+	if idx, ok := node.ChildIdx(disk.PageID(3)); !ok || node.ChildCount(idx) != 20 {
+		t.Errorf("expected child 3 to have 20 entries, got %d (found %v)", node.ChildCount(idx), ok)
+	}
+
+	// The count moves along when a pair becomes the right child
+	node.RemoveChild(2)
+	if node.ChildAt(1) != disk.PageID(3) || node.ChildCount(1) != 20 {
+		t.Errorf("expected right child 3 with 20 entries, got %d with %d", node.ChildAt(1), node.ChildCount(1))
+	}
+
+	t.Run("LegacyPair", func(t *testing.T) {
+		data := make([]byte, 124)
+		node := NewInternalNode(data)
+		node.Initialize(makeUint64Key(5), disk.PageID(1), disk.PageID(2))
+		legacy := (&Pair{Key: makeUint64Key(5), Value: disk.PageID(1).ToBytes()}).ToBytes()
+		node.body.Remove(0)
+		if !node.body.Insert(0, len(legacy)) {
+			t.Fatal("failed to insert a legacy pair")
+		}
+		copy(node.body.Data(0), legacy)
+		if node.ChildAt(0) != disk.PageID(1) || node.ChildCount(0) != 0 {
+			t.Errorf("expected child 1 without a count, got %d with %d", node.ChildAt(0), node.ChildCount(0))
+		}
+		if !node.SetChildCount(0, 7) || node.ChildAt(0) != disk.PageID(1) || node.ChildCount(0) != 7 {
+			t.Errorf("expected the legacy pair to be upgraded, got %d with %d", node.ChildAt(0), node.ChildCount(0))
+		}
+	})
+}
@@ -0,0 +1,261 @@
+package btree
+
+import (
+	"github.com/Johniel/gorelly/buffer"
+	"github.com/Johniel/gorelly/disk"
+	"github.com/Johniel/gorelly/ownercheck"
+)
+
+// writers tracks the writer of each tree when the gorellycheck build tag is set (see ownercheck).
+var writers ownercheck.Registry
+
+// writerKey identifies a tree: its meta page in the buffer pool it is accessed through.
+type writerKey struct {
+	bufmgr     *buffer.BufferPoolManager
+	metaPageID disk.PageID
+}
+
+// acquireWriter marks op as the writer of the tree and returns the function that ends it.
+// With the gorellycheck build tag, it panics if another writer is modifying the tree.
+func (bt *BTree) acquireWriter(bufmgr *buffer.BufferPoolManager, op string) func() {
+	return writers.Acquire(writerKey{bufmgr, bt.MetaPageID}, "B+ tree", op)
+}
+
+// writePath holds the write latches an inserting writer takes on its way down the tree,
+// together with the pins on the latched buffers.
+// If the tree has a PageLog, the modified pages are logged when their latches are released.
+// A node is latched before any of its children is modified (latch crabbing), and once a
+// node is safe (an insert into it cannot split it) the latches of its ancestors are released,
+// because they cannot be modified by this insert.
+//
+// Before a structural change starts modifying pages, the writer reserves the buffers it may need
+// (see reserve), so that running out of buffers fails the operation before anything is modified.
+//
+// If the PageLog is a PageLocker, the modified pages are locked before any of them is logged;
+// if one cannot be locked, the pages get their images from before the operation back, the pages
+// it allocated are freed and reverted is set.
+type writePath struct {
+	bufmgr      *buffer.BufferPoolManager
+	latched     []*buffer.Buffer
+	modified    map[*buffer.Buffer]bool
+	reservation *buffer.Reservation
+	log         PageLog
+	before      map[*buffer.Buffer][]byte // Images of the latched pages when they were latched, if logged
+	created     []*buffer.Buffer          // Pages allocated by the operation, pinned until logged
+	err         error                     // First error of the log
+	reverted    bool                      // Whether the changes were reverted because a page could not be locked
+}
+
+func newWritePath(bufmgr *buffer.BufferPoolManager, log PageLog) *writePath {
+	return &writePath{
+		bufmgr:   bufmgr,
+		modified: make(map[*buffer.Buffer]bool),
+		log:      log,
+		before:   make(map[*buffer.Buffer][]byte),
+	}
+}
+
+// latch write-latches buf and takes over the caller's pin on it.
+// If safe, the latches held on its ancestors are released first.
+func (wp *writePath) latch(buf *buffer.Buffer, safe bool) {
+	buf.WriteLatch()
+	if safe {
+		wp.releaseAll()
+	}
+	if wp.log != nil {
+		wp.before[buf] = pageImage(buf)
+	}
+	wp.latched = append(wp.latched, buf)
+}
+
+func (wp *writePath) markModified(buf *buffer.Buffer) {
+	buf.MarkDirty()
+	wp.modified[buf] = true
+}
+
+// reserve sets aside n buffers for the rest of the operation.
+func (wp *writePath) reserve(bufmgr *buffer.BufferPoolManager, n int) error {
+	reservation, err := bufmgr.Reserve(n)
+	if err != nil {
+		return err
+	}
+	wp.reservation = reservation
+	return nil
+}
+
+// fetchBuffer fetches a page, using the reserved buffers if there are any.
+func (wp *writePath) fetchBuffer(bufmgr *buffer.BufferPoolManager, pageID disk.PageID) (*buffer.Buffer, error) {
+	if wp.reservation != nil {
+		return wp.reservation.FetchBuffer(pageID)
+	}
+	return bufmgr.FetchBuffer(pageID)
+}
+
+// createBuffer allocates a page, using the reserved buffers if there are any.
+func (wp *writePath) createBuffer(bufmgr *buffer.BufferPoolManager) (*buffer.Buffer, error) {
+	var buf *buffer.Buffer
+	var err error
+	if wp.reservation != nil {
+		buf, err = wp.reservation.CreateBuffer()
+	} else {
+		buf, err = bufmgr.CreateBuffer()
+	}
+	if err == nil && wp.log != nil {
+		// Keep the page cached until it is logged
+		buf.Pin()
+		wp.created = append(wp.created, buf)
+	}
+	return buf, err
+}
+
+// releaseReservation returns the unused reserved buffers to the pool.
+func (wp *writePath) releaseReservation() {
+	if wp.reservation != nil {
+		wp.reservation.Release()
+		wp.reservation = nil
+	}
+}
+
+// releaseAll logs the pages modified so far and releases the latches and pins held on them.
+// A failure to log is kept in wp.err.
+func (wp *writePath) releaseAll() {
+	if wp.err == nil && 0 < len(wp.modified) {
+		wp.err = wp.lockModified()
+	}
+	for _, buf := range wp.created {
+		wp.logPage(buf, nil)
+		buf.Unpin()
+	}
+	wp.created = nil
+	for i := len(wp.latched) - 1; 0 <= i; i-- {
+		buf := wp.latched[i]
+		if wp.modified[buf] {
+			wp.logPage(buf, wp.before[buf])
+		}
+		buf.WriteUnlatch(wp.modified[buf])
+		buf.Unpin()
+		delete(wp.modified, buf)
+		delete(wp.before, buf)
+	}
+	wp.latched = wp.latched[:0]
+}
+
+// lockModified locks the modified pages through the log. If one is locked by another
+// transaction, it reverts the changes of the operation and returns the error.
+func (wp *writePath) lockModified() error {
+	for buf := range wp.modified {
+		err := lockPage(wp.log, buf.PageID)
+		if err == nil {
+			continue
+		}
+		for buf := range wp.modified {
+			copy(buf.Page[:], wp.before[buf])
+		}
+		// Nothing refers to the allocated pages anymore, and they were never logged
+		for _, buf := range wp.created {
+			buf.Unpin()
+			wp.bufmgr.FreeBuffer(buf.PageID)
+		}
+		wp.created = nil
+		wp.reverted = true
+		return err
+	}
+	return nil
+}
+
+func (wp *writePath) logPage(buf *buffer.Buffer, before []byte) {
+	if wp.err == nil {
+		wp.err = logPage(wp.log, buf, before)
+	}
+}
+
+// OptimisticGet looks up the value of key without taking any latch (OLFIT-style).
+// Each node's version is read before and validated after reading it, and the parent is
+// re-validated after the child's version is read; if a concurrent writer changed any of them
+// the lookup restarts from the meta page. It returns false if the key does not exist.
+//
+// Writers (Insert, Update, Delete) latch the nodes they modify, so OptimisticGet may run
+// concurrently with a single writer. Writers must still be serialized with each other;
+// with the gorellycheck build tag, concurrent writers on the same tree panic (see ownercheck).
+func (bt *BTree) OptimisticGet(bufmgr *buffer.BufferPoolManager, key []byte) ([]byte, bool, error) {
+	for {
+		value, found, valid, err := bt.tryOptimisticGet(bufmgr, key)
+		if err != nil {
+			return nil, false, err
+		}
+		if valid {
+			return value, found, nil
+		}
+	}
+}
+
+// tryOptimisticGet makes one optimistic lookup attempt. valid is false if a concurrent
+// change was detected and the attempt has to be retried.
+func (bt *BTree) tryOptimisticGet(bufmgr *buffer.BufferPoolManager, key []byte) (value []byte, found bool, valid bool, err error) {
+	// A page read while a writer is changing it may be inconsistent and make the node
+	// accessors panic; such an attempt is simply retried like a failed validation.
+	defer func() {
+		if r := recover(); r != nil {
+			value, found, valid, err = nil, false, false, nil
+		}
+	}()
+
+	parent, err := bufmgr.FetchBuffer(bt.MetaPageID)
+	if err != nil {
+		return nil, false, false, err
+	}
+	held := parent
+	defer func() { held.Unpin() }()
+	parentVersion := parent.ReadVersion()
+	pageID := NewMeta(parent.Page[:]).RootPageID()
+	for {
+		nodeBuffer, err := bufmgr.FetchBuffer(pageID)
+		if err != nil {
+			return nil, false, false, err
+		}
+		// Only the child is kept pinned: validating the parent's version also detects
+		// that its frame was reused for another page
+		held.Unpin()
+		held = nodeBuffer
+		nodeVersion := nodeBuffer.ReadVersion()
+		if nodeBuffer.PageID != pageID || !parent.Validate(parentVersion) {
+			return nil, false, false, nil
+		}
+
+		node := NewNode(nodeBuffer.Page[:])
+		if node.IsLeaf() {
+			leafNode := node.AsLeaf()
+			slotID, searchErr := searchLeaf(nodeBuffer, leafNode, key)
+			var readErr error
+			if searchErr == nil {
+				pair := leafNode.PairAt(slotID)
+				value = make([]byte, len(pair.Value))
+				copy(value, pair.Value)
+				if pair.Overflow {
+					// The pages are freed only after the leaf stops referring to them: if the
+					// leaf is still valid, so was the chain
+					value, readErr = readOverflow(bufmgr, pair.Value)
+				}
+				found = true
+			}
+			if !nodeBuffer.Validate(nodeVersion) {
+				return nil, false, false, nil
+			}
+			if readErr != nil {
+				return nil, false, false, readErr
+			}
+			return value, found, true, nil
+		} else if node.IsBranch() {
+			pageID = node.AsBranch().SearchChild(key)
+		} else {
+			return nil, false, false, nil
+		}
+		if !nodeBuffer.Validate(nodeVersion) {
+			return nil, false, false, nil
+		}
+		parent, parentVersion = nodeBuffer, nodeVersion
+		if pageID == disk.InvalidPageID {
+			return nil, false, false, nil
+		}
+	}
+}
@@ -0,0 +1,299 @@
+package btree
+
+import (
+	"encoding/binary"
+	"errors"
+	"os"
+	"reflect"
+	"sync"
+	"testing"
+
+	"github.com/Johniel/gorelly/buffer"
+	"github.com/Johniel/gorelly/disk"
+	"github.com/Johniel/gorelly/ownercheck"
+)
+
+func TestOptimisticGet(t *testing.T) {
+	tmpfile, err := os.CreateTemp("", "test_optimistic_get_*.db")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(tmpfile.Name())
+
+	dm, err := disk.NewDiskManager(tmpfile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer dm.Close()
+
+	pool := buffer.NewBufferPool(10)
+	bufmgr := buffer.NewBufferPoolManager(dm, pool)
+
+	bt, err := CreateBTree(bufmgr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	key := func(i int) []byte {
+		k := make([]byte, 8)
+		binary.BigEndian.PutUint64(k, uint64(i))
+		return k
+	}
+	for i := 0; i < 100; i += 2 {
+		if err := bt.Insert(bufmgr, key(i), key(i*10)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	value, ok, err := bt.OptimisticGet(bufmgr, key(42))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !ok || !reflect.DeepEqual(value, key(420)) {
+		t.Errorf("expected %v, got %v (found=%v)", key(420), value, ok)
+	}
+	if _, ok, err := bt.OptimisticGet(bufmgr, key(43)); err != nil || ok {
+		t.Errorf("expected key 43 to be missing, got found=%v err=%v", ok, err)
+	}
+
+	if err := bt.Update(bufmgr, key(42), []byte("new")); err != nil {
+		t.Fatal(err)
+	}
+	if err := bt.Delete(bufmgr, key(44)); err != nil {
+		t.Fatal(err)
+	}
+	if value, ok, _ := bt.OptimisticGet(bufmgr, key(42)); !ok || string(value) != "new" {
+		t.Errorf("expected the updated value, got %q", value)
+	}
+	if _, ok, _ := bt.OptimisticGet(bufmgr, key(44)); ok {
+		t.Errorf("expected key 44 to be deleted")
+	}
+}
+
+func TestOptimisticGetConcurrentWriter(t *testing.T) {
+	if raceEnabled {
+		t.Skip("optimistic reads race with the writer by design")
+	}
+	tmpfile, err := os.CreateTemp("", "test_optimistic_get_concurrent_*.db")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(tmpfile.Name())
+
+	dm, err := disk.NewDiskManager(tmpfile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer dm.Close()
+
+	// Large enough that no page is evicted during the test
+	pool := buffer.NewBufferPool(256)
+	bufmgr := buffer.NewBufferPoolManager(dm, pool)
+
+	bt, err := CreateBTree(bufmgr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	key := func(i int) []byte {
+		k := make([]byte, 8)
+		binary.BigEndian.PutUint64(k, uint64(i))
+		return k
+	}
+	// Even keys exist before the readers start; the writer adds the odd ones, splitting pages
+	const numKeys = 4000
+	for i := 0; i < numKeys; i += 2 {
+		if err := bt.Insert(bufmgr, key(i), make([]byte, 64)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	done := make(chan struct{})
+	var wg sync.WaitGroup
+	for r := 0; r < 4; r++ {
+		wg.Add(1)
+		go func(r int) {
+			defer wg.Done()
+			for i := r * 2; ; i = (i + 8) % numKeys {
+				select {
+				case <-done:
+					return
+				default:
+				}
+				_, ok, err := bt.OptimisticGet(bufmgr, key(i))
+				if err != nil {
+					t.Error(err)
+					return
+				}
+				if !ok {
+					t.Errorf("key %d disappeared during a concurrent insert", i)
+					return
+				}
+			}
+		}(r)
+	}
+
+	for i := 1; i < numKeys; i += 2 {
+		if err := bt.Insert(bufmgr, key(i), make([]byte, 64)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	close(done)
+	wg.Wait()
+
+	for i := 0; i < numKeys; i++ {
+		if _, ok, err := bt.OptimisticGet(bufmgr, key(i)); err != nil || !ok {
+			t.Fatalf("expected key %d to exist, got found=%v err=%v", i, ok, err)
+		}
+	}
+}
+
+func TestConcurrentWritersDetected(t *testing.T) {
+	tmpfile, err := os.CreateTemp("", "test_concurrent_writers_*.db")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(tmpfile.Name())
+
+	dm, err := disk.NewDiskManager(tmpfile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer dm.Close()
+
+	pool := buffer.NewBufferPool(10)
+	bufmgr := buffer.NewBufferPoolManager(dm, pool)
+
+	bt, err := CreateBTree(bufmgr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	other, err := CreateBTree(bufmgr)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// A writer is in progress on bt, e.g. on another goroutine
+	done := bt.acquireWriter(bufmgr, "Delete")
+	defer done()
+
+	// Writers on other trees are not affected
+	if err := other.Insert(bufmgr, []byte("a"), []byte("1")); err != nil {
+		t.Fatal(err)
+	}
+
+	defer func() {
+		r := recover()
+		if ownercheck.Enabled && r == nil {
+			t.Error("expected the concurrent Insert to panic")
+		}
+		if !ownercheck.Enabled && r != nil {
+			t.Errorf("expected no check without the gorellycheck tag, got %v", r)
+		}
+	}()
+	if err := bt.Insert(bufmgr, []byte("a"), []byte("1")); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// lockingLog is a PageLocker that refuses to lock the pages in refused. WaitPage grants a
+// refused page, as if the transaction holding it ended, if grant is set, and fails otherwise.
+type lockingLog struct {
+	refused map[disk.PageID]bool
+	grant   bool
+	waited  []disk.PageID
+}
+
+func (l *lockingLog) LogPage(pageID disk.PageID, before []byte, after []byte) (uint64, error) {
+	return 0, nil
+}
+
+func (l *lockingLog) LogFree(pageID disk.PageID) {}
+
+func (l *lockingLog) LockPage(pageID disk.PageID) error {
+	if l.refused[pageID] {
+		return errPageRefused
+	}
+	return nil
+}
+
+func (l *lockingLog) WaitPage(pageID disk.PageID) error {
+	l.waited = append(l.waited, pageID)
+	if !l.grant {
+		return errPageRefused
+	}
+	delete(l.refused, pageID)
+	return nil
+}
+
+func (l *lockingLog) DefersFree() bool {
+	return false
+}
+
+var errPageRefused = errors.New("page refused")
+
+func TestWritePathLockConflict(t *testing.T) {
+	tmpfile, err := os.CreateTemp("", "test_write_path_lock_conflict_*.db")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(tmpfile.Name())
+
+	dm, err := disk.NewDiskManager(tmpfile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer dm.Close()
+
+	pool := buffer.NewBufferPool(10)
+	bufmgr := buffer.NewBufferPoolManager(dm, pool)
+
+	bt, err := CreateBTree(bufmgr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The meta page only changes when the root splits
+	log := &lockingLog{refused: map[disk.PageID]bool{bt.MetaPageID: true}}
+	bt.Log = log
+	pair := func(i int) []byte {
+		return append([]byte{byte(i)}, make([]byte, 1000)...)
+	}
+	var stored [][]byte
+	for i := 0; ; i++ {
+		allocated := int(dm.NumPages()) - dm.NumFreePages()
+		err := bt.Insert(bufmgr, pair(i), pair(i))
+		if err == nil {
+			stored = append(stored, pair(i))
+			continue
+		}
+		if err != errPageRefused {
+			t.Fatal(err)
+		}
+		// The split was reverted: the new pages are freed and the tree is unchanged
+		if n := int(dm.NumPages()) - dm.NumFreePages(); n != allocated {
+			t.Errorf("allocated pages = %d, want %d", n, allocated)
+		}
+		if pinned := bufmgr.PoolStats().Pinned; pinned != 0 {
+			t.Errorf("pinned pages = %d, want 0", pinned)
+		}
+		if _, found, err := bt.OptimisticGet(bufmgr, pair(i)); err != nil || found {
+			t.Errorf("reverted key: found = %v, err = %v", found, err)
+		}
+		for _, key := range stored {
+			if value, found, err := bt.OptimisticGet(bufmgr, key); err != nil || !found || !reflect.DeepEqual(value, key) {
+				t.Errorf("key %d: found = %v, err = %v", key[0], found, err)
+			}
+		}
+		break
+	}
+
+	// Once the page is granted, the insert starts over and splits the root
+	log.grant = true
+	if err := bt.Insert(bufmgr, pair(len(stored)), pair(len(stored))); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(log.waited, []disk.PageID{bt.MetaPageID, bt.MetaPageID}) {
+		t.Errorf("expected to wait for the meta page twice, waited for %v", log.waited)
+	}
+	if _, found, err := bt.OptimisticGet(bufmgr, pair(len(stored))); err != nil || !found {
+		t.Errorf("retried key: found = %v, err = %v", found, err)
+	}
+}
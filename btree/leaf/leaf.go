@@ -1,6 +1,7 @@
 package leaf
 
 import (
+	"encoding/binary"
 	"unsafe"
 
 	"github.com/Johniel/gorelly/bsearch"
@@ -75,11 +76,17 @@ func (l *Leaf) NumPairs() int {
 
 func (l *Leaf) SearchSlotID(key []byte) (int, error) {
 	return bsearch.BinarySearchBy(l.NumPairs(), func(slotID int) int {
-		pair := l.PairAt(slotID)
-		return compareBytes(pair.Key, key)
+		return compareBytes(l.keyAt(slotID), key)
 	})
 }
 
+// keyAt returns the key of a pair without copying it out of the page.
+func (l *Leaf) keyAt(slotID int) []byte {
+	data := l.body.Data(slotID)
+	keyLen := binary.LittleEndian.Uint32(data[0:4])
+	return data[4 : 4+keyLen]
+}
+
 func (l *Leaf) PairAt(slotID int) *Pair {
 	data := l.body.Data(slotID)
 	return PairFromBytes(data)
@@ -89,6 +96,16 @@ func (l *Leaf) MaxPairSize() int {
 	return l.body.Capacity()/2 - 4 // slotted.PointerSize
 }
 
+// IsInsertSafe reports whether any pair of up to MaxPairSize bytes fits without a split.
+func (l *Leaf) IsInsertSafe() bool {
+	return l.MaxPairSize()+slotted.PointerSize <= l.body.FreeSpace()
+}
+
+// FillFactor returns the fraction of the body that is in use (0 to 1).
+func (l *Leaf) FillFactor() float64 {
+	return float64(l.body.Capacity()-l.body.FreeSpace()) / float64(l.body.Capacity())
+}
+
 func (l *Leaf) Initialize() {
 	l.header.PrevPageID = disk.InvalidPageID
 	l.header.NextPageID = disk.InvalidPageID
@@ -104,7 +121,11 @@ func (l *Leaf) SetNextPageID(nextPageID disk.PageID) {
 }
 
 func (l *Leaf) Insert(slotID int, key []byte, value []byte) bool {
-	pair := &Pair{Key: key, Value: value}
+	return l.InsertPair(slotID, &Pair{Key: key, Value: value})
+}
+
+// InsertPair is Insert for a pair whose value may be stored out of the leaf.
+func (l *Leaf) InsertPair(slotID int, pair *Pair) bool {
 	pairBytes := pair.ToBytes()
 	if len(pairBytes) > l.MaxPairSize() {
 		return false
@@ -119,11 +140,16 @@ func (l *Leaf) Insert(slotID int, key []byte, value []byte) bool {
 // Update updates the value for an existing key in the leaf node.
 // It returns true if the update was successful, false if the key was not found or if there's not enough space.
 func (l *Leaf) Update(slotID int, newValue []byte) bool {
+	return l.UpdatePair(slotID, newValue, false)
+}
+
+// UpdatePair is Update with a value that is stored out of the leaf if overflow is set.
+func (l *Leaf) UpdatePair(slotID int, newValue []byte, overflow bool) bool {
 	if slotID >= l.NumPairs() {
 		return false
 	}
 	oldPair := l.PairAt(slotID)
-	newPair := &Pair{Key: oldPair.Key, Value: newValue}
+	newPair := &Pair{Key: oldPair.Key, Value: newValue, Overflow: overflow}
 	newPairBytes := newPair.ToBytes()
 	if len(newPairBytes) > l.MaxPairSize() {
 		return false
@@ -158,11 +184,17 @@ func (l *Leaf) IsHalfFull() bool {
 }
 
 func (l *Leaf) SplitInsert(newLeaf *Leaf, newKey []byte, newValue []byte) []byte {
+	return l.SplitInsertPair(newLeaf, &Pair{Key: newKey, Value: newValue})
+}
+
+// SplitInsertPair is SplitInsert for a pair whose value may be stored out of the leaf.
+func (l *Leaf) SplitInsertPair(newLeaf *Leaf, pair *Pair) []byte {
+	newKey := pair.Key
 	newLeaf.Initialize()
 	for {
 		if newLeaf.IsHalfFull() {
 			index, _ := l.SearchSlotID(newKey)
-			if !l.Insert(index, newKey, newValue) {
+			if !l.InsertPair(index, pair) {
 				panic("old leaf must have space")
 			}
 			break
@@ -170,7 +202,7 @@ func (l *Leaf) SplitInsert(newLeaf *Leaf, newKey []byte, newValue []byte) []byte
 		if compareBytes(l.PairAt(0).Key, newKey) < 0 {
 			l.Transfer(newLeaf)
 		} else {
-			if !newLeaf.Insert(newLeaf.NumPairs(), newKey, newValue) {
+			if !newLeaf.InsertPair(newLeaf.NumPairs(), pair) {
 				panic("new leaf must have space")
 			}
 			for !newLeaf.IsHalfFull() {
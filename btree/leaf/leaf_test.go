@@ -128,3 +128,28 @@ func TestLeafSplitInsert(t *testing.T) {
 		t.Error("newLeafPage should contain 'deadbeef'/'world' pair after split")
 	}
 }
+
+func TestLeafSearchSlotIDWithPrefixes(t *testing.T) {
+	leafPage := NewLeaf(make([]byte, 4000))
+	leafPage.Initialize()
+	// Keys sharing long prefixes, shorter than a prefix and with trailing zeros
+	keys := []string{"", "a", "a\x00", "a\x00\x00", "abc", "composite:0001:x", "composite:0001:y", "composite:0002", "composite:00021", "z"}
+	for _, key := range keys {
+		id, _ := leafPage.SearchSlotID([]byte(key))
+		if !leafPage.Insert(id, []byte(key), []byte("v")) {
+			t.Fatalf("failed to insert %q", key)
+		}
+	}
+	prefixes := leafPage.KeyPrefixes()
+	if len(prefixes) != len(keys) {
+		t.Fatalf("expected %d prefixes, got %d", len(keys), len(prefixes))
+	}
+	probes := append([]string{"\x00", "a\x00\x00\x00", "ab", "composite:0001:", "composite:0001:z", "composite:0003", "zz"}, keys...)
+	for _, key := range probes {
+		wantID, wantErr := leafPage.SearchSlotID([]byte(key))
+		id, err := leafPage.SearchSlotIDWithPrefixes([]byte(key), prefixes)
+		if id != wantID || (err == nil) != (wantErr == nil) {
+			t.Errorf("%q: expected %d, %v, got %d, %v", key, wantID, wantErr, id, err)
+		}
+	}
+}
@@ -5,14 +5,21 @@ import (
 	"encoding/binary"
 )
 
+// overflowFlag is set in the stored value length of a pair whose Overflow is set.
+const overflowFlag = 1 << 31
+
 // Pair represents a key-value pair stored in a leaf node.
 type Pair struct {
 	Key   []byte // The key
 	Value []byte // The value associated with the key
+	// Whether Value only refers to the actual value, which is stored out of the leaf (see the
+	// overflow pages of package btree)
+	Overflow bool
 }
 
 func (p *Pair) ToBytes() []byte {
 	// Format: [key_len:4][key:key_len][value_len:4][value:value_len]
+	// The highest bit of value_len is the Overflow flag.
 	buf := make([]byte, 0, 8+len(p.Key)+len(p.Value))
 
 	keyLenBytes := make([]byte, 4)
@@ -21,7 +28,11 @@ func (p *Pair) ToBytes() []byte {
 	buf = append(buf, p.Key...)
 
 	valueLenBytes := make([]byte, 4)
-	binary.LittleEndian.PutUint32(valueLenBytes, uint32(len(p.Value)))
+	valueLen := uint32(len(p.Value))
+	if p.Overflow {
+		valueLen |= overflowFlag
+	}
+	binary.LittleEndian.PutUint32(valueLenBytes, valueLen)
 	buf = append(buf, valueLenBytes...)
 	buf = append(buf, p.Value...)
 
@@ -41,11 +52,13 @@ func PairFromBytes(data []byte) *Pair {
 	copy(key, data[4:4+keyLen])
 
 	valueLen := binary.LittleEndian.Uint32(data[4+keyLen : 8+keyLen])
+	overflow := valueLen&overflowFlag != 0
+	valueLen &^= overflowFlag
 	if len(data) < int(8+keyLen+valueLen) {
 		panic("pair data too short for value")
 	}
 	value := make([]byte, valueLen)
 	copy(value, data[8+keyLen:8+keyLen+valueLen])
 
-	return &Pair{Key: key, Value: value}
+	return &Pair{Key: key, Value: value, Overflow: overflow}
 }
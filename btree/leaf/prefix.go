@@ -0,0 +1,48 @@
+package leaf
+
+import (
+	"encoding/binary"
+
+	"github.com/Johniel/gorelly/bsearch"
+)
+
+// KeyPrefixes holds the first 8 bytes of each key of a leaf, as big-endian integers padded
+// with zeros. Comparing two prefixes gives the order of their keys unless the prefixes are
+// equal, so a search compares full keys only among the pairs sharing the prefix of the key
+// searched for. It is computed from the page by KeyPrefixes and stays valid until the leaf
+// is modified.
+type KeyPrefixes []uint64
+
+// keyPrefix returns the prefix of key as stored in KeyPrefixes.
+func keyPrefix(key []byte) uint64 {
+	if 8 <= len(key) {
+		return binary.BigEndian.Uint64(key)
+	}
+	var padded [8]byte
+	copy(padded[:], key)
+	return binary.BigEndian.Uint64(padded[:])
+}
+
+// KeyPrefixes returns the prefixes of the keys of the leaf, in slot order.
+func (l *Leaf) KeyPrefixes() KeyPrefixes {
+	prefixes := make(KeyPrefixes, l.NumPairs())
+	for slotID := range prefixes {
+		prefixes[slotID] = keyPrefix(l.keyAt(slotID))
+	}
+	return prefixes
+}
+
+// SearchSlotIDWithPrefixes is SearchSlotID using prefixes, which must have been computed by
+// KeyPrefixes since the leaf was last modified.
+func (l *Leaf) SearchSlotIDWithPrefixes(key []byte, prefixes KeyPrefixes) (int, error) {
+	prefix := keyPrefix(key)
+	return bsearch.BinarySearchBy(len(prefixes), func(slotID int) int {
+		if prefixes[slotID] < prefix {
+			return -1
+		}
+		if prefixes[slotID] > prefix {
+			return 1
+		}
+		return compareBytes(l.keyAt(slotID), key)
+	})
+}
@@ -0,0 +1,60 @@
+package btree
+
+import (
+	"math"
+
+	"github.com/Johniel/gorelly/btree/internal"
+	"github.com/Johniel/gorelly/disk"
+	"github.com/Johniel/gorelly/errcode"
+)
+
+var (
+	// ErrKeyTooLarge is returned when inserting a key larger than Limits.MaxKeySize.
+	ErrKeyTooLarge = errcode.New(errcode.LimitExceeded, "key exceeds the maximum key size")
+	// ErrPairTooLarge is returned when a value exceeds Limits.MaxOverflowSize.
+	ErrPairTooLarge = errcode.New(errcode.LimitExceeded, "key and value exceed the maximum pair size")
+)
+
+// Limits describes the largest keys and values a B+ tree can store. Every pair stored in a leaf
+// has to fit in half a leaf page so that a split always succeeds: the values of larger pairs are
+// stored in overflow pages, and the leaf holds a pointer to them instead.
+type Limits struct {
+	MaxKeySize      int // Largest key; keys are also stored in internal nodes as separators
+	MaxPairSize     int // Largest len(key)+len(value) stored in a leaf
+	MaxOverflowSize int // Largest value stored in overflow pages
+}
+
+// Limits returns the size limits of the tree.
+func (bt *BTree) Limits() Limits {
+	page := make([]byte, disk.PageSize)
+	const pairOverhead = 8 // Key and value lengths
+	leafMax := NewNode(page).AsLeaf().MaxPairSize() - pairOverhead
+	branchMax := NewNode(page).AsBranch().MaxPairSize() - pairOverhead - internal.ChildValueSize
+	return Limits{
+		MaxKeySize:      min(leafMax, branchMax),
+		MaxPairSize:     leafMax,
+		MaxOverflowSize: math.MaxInt32,
+	}
+}
+
+// MaxValueSize returns the largest value stored in the leaf with a key of keySize bytes. Larger
+// values are stored in overflow pages.
+func (l Limits) MaxValueSize(keySize int) int {
+	return max(l.MaxPairSize-keySize, 0)
+}
+
+// check returns ErrKeyTooLarge or ErrPairTooLarge if the pair exceeds the limits.
+func (l Limits) check(key []byte, value []byte) error {
+	if l.MaxKeySize < len(key) {
+		return ErrKeyTooLarge
+	}
+	if l.needsOverflow(key, value) && (l.MaxPairSize < len(key)+overflowPointerSize || l.MaxOverflowSize < len(value)) {
+		return ErrPairTooLarge
+	}
+	return nil
+}
+
+// needsOverflow reports whether the value of the pair has to be stored in overflow pages.
+func (l Limits) needsOverflow(key []byte, value []byte) bool {
+	return l.MaxPairSize < len(key)+len(value)
+}
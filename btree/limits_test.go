@@ -0,0 +1,89 @@
+package btree
+
+import (
+	"bytes"
+	"os"
+	"testing"
+
+	"github.com/Johniel/gorelly/buffer"
+	"github.com/Johniel/gorelly/disk"
+)
+
+func TestBTreeLimits(t *testing.T) {
+	tmpfile, err := os.CreateTemp("", "test_btree_limits_*.db")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(tmpfile.Name())
+
+	dm, err := disk.NewDiskManager(tmpfile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer dm.Close()
+
+	pool := buffer.NewBufferPool(10)
+	bufmgr := buffer.NewBufferPoolManager(dm, pool)
+
+	bt, err := CreateBTree(bufmgr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	limits := bt.Limits()
+	if limits.MaxKeySize <= 0 || limits.MaxPairSize < limits.MaxKeySize {
+		t.Fatalf("unexpected limits %+v", limits)
+	}
+
+	t.Run("KeyTooLarge", func(t *testing.T) {
+		key := bytes.Repeat([]byte("k"), limits.MaxKeySize+1)
+		if err := bt.Insert(bufmgr, key, nil); err != ErrKeyTooLarge {
+			t.Errorf("expected ErrKeyTooLarge, got %v", err)
+		}
+	})
+
+	t.Run("PairTooLarge", func(t *testing.T) {
+		key := []byte("key")
+		small := limits
+		small.MaxOverflowSize = 3 * disk.PageSize
+		if err := small.check(key, make([]byte, small.MaxOverflowSize+1)); err != ErrPairTooLarge {
+			t.Errorf("expected ErrPairTooLarge, got %v", err)
+		}
+		if err := small.check(key, make([]byte, small.MaxOverflowSize)); err != nil {
+			t.Errorf("expected a value at the limit to pass, got %v", err)
+		}
+	})
+
+	t.Run("Overflow", func(t *testing.T) {
+		// A value just too large for the leaf is stored in overflow pages
+		key := []byte("key")
+		value := bytes.Repeat([]byte("v"), limits.MaxValueSize(len(key))+1)
+		if err := bt.Insert(bufmgr, key, value); err != nil {
+			t.Fatal(err)
+		}
+		if got, err := bt.Get(bufmgr, key); err != nil || !bytes.Equal(got, value) {
+			t.Errorf("expected %d bytes, got %d, %v", len(value), len(got), err)
+		}
+		if err := bt.Delete(bufmgr, key); err != nil {
+			t.Fatal(err)
+		}
+	})
+
+	t.Run("AtLimits", func(t *testing.T) {
+		// Pairs of the maximum size must still split cleanly
+		for i := 0; i < 5; i++ {
+			key := bytes.Repeat([]byte{byte('a' + i)}, limits.MaxKeySize)
+			value := make([]byte, limits.MaxValueSize(len(key)))
+			if err := bt.Insert(bufmgr, key, value); err != nil {
+				t.Fatalf("insert %d at the limits failed: %v", i, err)
+			}
+		}
+		key := bytes.Repeat([]byte{'c'}, limits.MaxKeySize)
+		value, found, err := bt.OptimisticGet(bufmgr, key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !found || len(value) != limits.MaxValueSize(len(key)) {
+			t.Errorf("expected the pair at the limits to be stored, got found=%v len=%d", found, len(value))
+		}
+	})
+}
@@ -20,8 +20,9 @@ func EncodedSize(len int) int {
 
 // Encode encodes a byte sequence into a memcmp-comparable format.
 // The encoded data can be compared byte-by-byte while preserving the original ordering.
+// An empty src is encoded as a single zero-length chunk, so it is still decoded as one element.
 func Encode(src []byte, dst *[]byte) {
-	for len(src) > 0 {
+	for {
 		copyLen := min(EscapeLength-1, len(src))
 		*dst = append(*dst, src[0:copyLen]...)
 		src = src[copyLen:]
@@ -27,3 +27,25 @@ func TestEncodeDecode(t *testing.T) {
 		t.Errorf("dec2: expected %v, got %v", org2, dec2)
 	}
 }
+
+func TestEncodeDecodeEmpty(t *testing.T) {
+	var enc []byte
+	Encode([]byte{}, &enc)
+	Encode([]byte("x"), &enc)
+	Encode(nil, &enc)
+	if len(enc) != 3*EncodedSize(0) {
+		t.Fatalf("expected %d bytes, got %d", 3*EncodedSize(0), len(enc))
+	}
+
+	rest := enc
+	for _, expected := range [][]byte{{}, []byte("x"), {}} {
+		var dec []byte
+		Decode(&rest, &dec)
+		if len(dec) != len(expected) || string(dec) != string(expected) {
+			t.Errorf("expected %v, got %v", expected, dec)
+		}
+	}
+	if len(rest) != 0 {
+		t.Errorf("expected all input to be consumed, %d bytes left", len(rest))
+	}
+}
@@ -18,6 +18,8 @@ var (
 	// Note: The on-disk format uses "BRANCH  " for backward compatibility,
 	// but the code uses "InternalNode" terminology.
 	NodeTypeBranch = [8]byte{'B', 'R', 'A', 'N', 'C', 'H', ' ', ' '}
+	// NodeTypeOverflow identifies an overflow page, holding part of a value too large for a leaf.
+	NodeTypeOverflow = [8]byte{'O', 'V', 'E', 'R', 'F', 'L', 'O', 'W'}
 )
 
 // NodeHeader contains the type information for a B+ tree node.
@@ -52,18 +54,52 @@ func (n *Node) InitializeAsBranch() {
 	n.header.NodeType = NodeTypeBranch
 }
 
+func (n *Node) InitializeAsOverflow() {
+	n.header.NodeType = NodeTypeOverflow
+}
+
 func (n *Node) IsLeaf() bool {
 	return n.header.NodeType == NodeTypeLeaf
 }
 
+// IsInsertSafe reports whether an insert into the node can never split it.
+func (n *Node) IsInsertSafe() bool {
+	if n.IsLeaf() {
+		return n.AsLeaf().IsInsertSafe()
+	}
+	return n.AsBranch().IsInsertSafe()
+}
+
+// isDeleteSafe reports whether a delete below a non-root node can never remove it from its
+// parent: a leaf keeps at least one pair and an internal node at least one child.
+func (n *Node) isDeleteSafe() bool {
+	if n.IsLeaf() {
+		return 1 < n.AsLeaf().NumPairs()
+	}
+	return 0 < n.AsBranch().NumPairs()
+}
+
 func (n *Node) IsBranch() bool {
 	return n.header.NodeType == NodeTypeBranch
 }
 
+func (n *Node) IsOverflow() bool {
+	return n.header.NodeType == NodeTypeOverflow
+}
+
 func (n *Node) Body() []byte {
 	return n.body
 }
 
+// entryCount returns the number of pairs of a leaf, or the approximate number of entries in the
+// subtree of an internal node.
+func (n *Node) entryCount() uint64 {
+	if n.IsLeaf() {
+		return uint64(n.AsLeaf().NumPairs())
+	}
+	return n.AsBranch().EntryCount()
+}
+
 func (n *Node) AsLeaf() *leaf.Leaf {
 	return leaf.NewLeaf(n.body)
 }
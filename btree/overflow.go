@@ -0,0 +1,174 @@
+package btree
+
+import (
+	"encoding/binary"
+
+	"github.com/Johniel/gorelly/btree/leaf"
+	"github.com/Johniel/gorelly/buffer"
+	"github.com/Johniel/gorelly/disk"
+	"github.com/Johniel/gorelly/errcode"
+)
+
+// ErrCorruptOverflow is returned when the overflow pages of a value do not hold it.
+var ErrCorruptOverflow = errcode.New(errcode.Corruption, "corrupt overflow page chain")
+
+// A value too large to be stored next to its key in a leaf (see Limits) is split into chunks
+// stored in a chain of overflow pages, and the leaf holds a pair whose Overflow is set and whose
+// value is an overflow pointer to the chain instead:
+//
+//	overflow page:    [node header:8][next page ID:8][chunk length:4][chunk]
+//	overflow pointer: [value length:4][first page ID:8]
+//
+// The last page of a chain has no next page.
+const (
+	overflowPointerSize = 12
+	overflowHeaderSize  = NodeHeaderSize + 12
+	overflowChunkSize   = disk.PageSize - overflowHeaderSize
+)
+
+// storedPair returns the pair stored in the leaf for key and value: the pair itself if it fits
+// in the leaf, and otherwise a pair pointing to a new chain of overflow pages holding the value.
+// The pages of the chain are logged to log as allocated.
+func storedPair(bufmgr *buffer.BufferPoolManager, log PageLog, limits Limits, key []byte, value []byte) (*leaf.Pair, error) {
+	if !limits.needsOverflow(key, value) {
+		return &leaf.Pair{Key: key, Value: value}, nil
+	}
+	pointer, err := writeOverflow(bufmgr, log, value)
+	if err != nil {
+		return nil, err
+	}
+	return &leaf.Pair{Key: key, Value: pointer, Overflow: true}, nil
+}
+
+// writeOverflow writes value into a new chain of overflow pages and returns the pointer to it.
+// The chain is written from its end, so that every page is written once, knowing the next one.
+func writeOverflow(bufmgr *buffer.BufferPoolManager, log PageLog, value []byte) ([]byte, error) {
+	next := disk.InvalidPageID
+	for end := len(value); 0 < end; {
+		start := (end - 1) / overflowChunkSize * overflowChunkSize
+		buf, err := bufmgr.CreateBuffer()
+		if err != nil {
+			if next.Valid() {
+				freeOverflowFrom(bufmgr, log, next)
+			}
+			return nil, err
+		}
+		page := buf.Page[:]
+		NewNode(page).InitializeAsOverflow()
+		binary.LittleEndian.PutUint64(page[NodeHeaderSize:], uint64(next))
+		binary.LittleEndian.PutUint32(page[NodeHeaderSize+8:], uint32(end-start))
+		copy(page[overflowHeaderSize:], value[start:end])
+		buf.MarkDirty()
+		err = logPage(log, buf, nil)
+		buf.Unpin()
+		next = buf.PageID
+		if err != nil {
+			freeOverflowFrom(bufmgr, log, next)
+			return nil, err
+		}
+		end = start
+	}
+	pointer := make([]byte, overflowPointerSize)
+	binary.LittleEndian.PutUint32(pointer, uint32(len(value)))
+	binary.LittleEndian.PutUint64(pointer[4:], uint64(next))
+	return pointer, nil
+}
+
+// readOverflow reads the value the overflow pointer refers to.
+func readOverflow(bufmgr *buffer.BufferPoolManager, pointer []byte) ([]byte, error) {
+	if len(pointer) != overflowPointerSize {
+		return nil, ErrCorruptOverflow
+	}
+	length := int(binary.LittleEndian.Uint32(pointer))
+	pageID := disk.PageID(binary.LittleEndian.Uint64(pointer[4:]))
+	value := make([]byte, 0, length)
+	for len(value) < length {
+		next, err := readOverflowPage(bufmgr, pageID, &value)
+		if err != nil {
+			return nil, err
+		}
+		if length < len(value) || (len(value) < length) != next.Valid() {
+			return nil, ErrCorruptOverflow
+		}
+		pageID = next
+	}
+	return value, nil
+}
+
+// readOverflowPage fetches an overflow page, appends its chunk to value unless value is nil, and
+// returns the next page of the chain.
+func readOverflowPage(bufmgr *buffer.BufferPoolManager, pageID disk.PageID, value *[]byte) (disk.PageID, error) {
+	buf, err := bufmgr.FetchBuffer(pageID)
+	if err != nil {
+		return disk.InvalidPageID, err
+	}
+	defer buf.Unpin()
+	page := buf.Page[:]
+	n := int(binary.LittleEndian.Uint32(page[NodeHeaderSize+8:]))
+	if !NewNode(page).IsOverflow() || n == 0 || overflowChunkSize < n {
+		return disk.InvalidPageID, ErrCorruptOverflow
+	}
+	if value != nil {
+		*value = append(*value, page[overflowHeaderSize:overflowHeaderSize+n]...)
+	}
+	return disk.PageID(binary.LittleEndian.Uint64(page[NodeHeaderSize:])), nil
+}
+
+// overflowPageIDs returns the pages of the chain the overflow pointer refers to.
+func overflowPageIDs(bufmgr *buffer.BufferPoolManager, pointer []byte) ([]disk.PageID, error) {
+	if len(pointer) != overflowPointerSize {
+		return nil, ErrCorruptOverflow
+	}
+	var pageIDs []disk.PageID
+	for pageID := disk.PageID(binary.LittleEndian.Uint64(pointer[4:])); pageID.Valid(); {
+		next, err := readOverflowPage(bufmgr, pageID, nil)
+		if err != nil {
+			return nil, err
+		}
+		pageIDs = append(pageIDs, pageID)
+		pageID = next
+	}
+	return pageIDs, nil
+}
+
+// freeOverflow frees the chain of overflow pages the pointer refers to, once no pair of the tree
+// refers to it anymore, and passes the freed pages to log.
+func freeOverflow(bufmgr *buffer.BufferPoolManager, log PageLog, pointer []byte) error {
+	pageIDs, err := overflowPageIDs(bufmgr, pointer)
+	if err != nil {
+		return err
+	}
+	freePages(bufmgr, log, pageIDs)
+	return nil
+}
+
+// freeOverflowFrom frees the chain starting at pageID, written by a writeOverflow that failed.
+func freeOverflowFrom(bufmgr *buffer.BufferPoolManager, log PageLog, pageID disk.PageID) {
+	pointer := make([]byte, overflowPointerSize)
+	binary.LittleEndian.PutUint64(pointer[4:], uint64(pageID))
+	freeOverflow(bufmgr, log, pointer)
+}
+
+// freePages frees pages no longer referenced by the tree and passes them to log, which may defer
+// freeing them (see PageLocker).
+func freePages(bufmgr *buffer.BufferPoolManager, log PageLog, pageIDs []disk.PageID) {
+	deferred := defersFree(log)
+	for _, pageID := range pageIDs {
+		if !deferred {
+			bufmgr.FreeBuffer(pageID)
+		}
+		if log != nil {
+			log.LogFree(pageID)
+		}
+	}
+}
+
+// overflowPages returns the number of pages of the chain the overflow pointer refers to, computed
+// from the length of the value.
+func overflowPages(pointer []byte) int {
+	if len(pointer) != overflowPointerSize {
+		return 0
+	}
+	length := int(binary.LittleEndian.Uint32(pointer))
+	return (length + overflowChunkSize - 1) / overflowChunkSize
+}
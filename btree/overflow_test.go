@@ -0,0 +1,174 @@
+package btree
+
+import (
+	"bytes"
+	"encoding/binary"
+	"os"
+	"testing"
+
+	"github.com/Johniel/gorelly/buffer"
+	"github.com/Johniel/gorelly/disk"
+)
+
+func TestBTreeOverflow(t *testing.T) {
+	tmpfile, err := os.CreateTemp("", "test_btree_overflow_*.db")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(tmpfile.Name())
+
+	dm, err := disk.NewDiskManager(tmpfile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer dm.Close()
+
+	pool := buffer.NewBufferPool(10)
+	bufmgr := buffer.NewBufferPoolManager(dm, pool)
+
+	// Inline values, values just too large for a leaf, and values spanning several pages
+	sizes := []int{10, 3000, 9000, 40000}
+	const n = 40
+	pairs := &pairSlice{}
+	for i := 0; i < n; i++ {
+		key := make([]byte, 8)
+		binary.BigEndian.PutUint64(key, uint64(i))
+		pairs.keys = append(pairs.keys, key)
+		pairs.values = append(pairs.values, bytes.Repeat([]byte{byte(i)}, sizes[i%len(sizes)]))
+	}
+	limits := NewBTree(disk.InvalidPageID).Limits()
+	overflowPagesOf := func(values [][]byte) int {
+		pages := 0
+		for _, value := range values {
+			if limits.MaxValueSize(8) < len(value) {
+				pages += (len(value) + overflowChunkSize - 1) / overflowChunkSize
+			}
+		}
+		return pages
+	}
+	checkContents := func(t *testing.T, bt *BTree, values [][]byte) {
+		t.Helper()
+		iter, err := bt.Search(bufmgr, NewSearchModeStart())
+		if err != nil {
+			t.Fatal(err)
+		}
+		for i := 0; ; i++ {
+			key, value, ok, err := iter.Next(bufmgr)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !ok {
+				if i != len(values) {
+					t.Errorf("expected %d pairs, got %d", len(values), i)
+				}
+				break
+			}
+			if !bytes.Equal(key, pairs.keys[i]) || !bytes.Equal(value, values[i]) {
+				t.Fatalf("pair %d: expected %d bytes under %x, got %d bytes under %x", i, len(values[i]), pairs.keys[i], len(value), key)
+			}
+		}
+		iter, err = bt.Search(bufmgr, SearchMode{IsStart: true, Reverse: true})
+		if err != nil {
+			t.Fatal(err)
+		}
+		for i := len(values) - 1; 0 <= i; i-- {
+			_, value, ok, err := iter.Next(bufmgr)
+			if err != nil || !ok || !bytes.Equal(value, values[i]) {
+				t.Fatalf("pair %d in reverse: got %d bytes, %v, %v", i, len(value), ok, err)
+			}
+		}
+		for i := range values {
+			value, found, err := bt.OptimisticGet(bufmgr, pairs.keys[i])
+			if err != nil || !found || !bytes.Equal(value, values[i]) {
+				t.Fatalf("pair %d: OptimisticGet got %d bytes, %v, %v", i, len(value), found, err)
+			}
+		}
+		stats, err := bt.Stats(bufmgr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if stats.OverflowPages != overflowPagesOf(values) {
+			t.Errorf("expected %d overflow pages, got %d", overflowPagesOf(values), stats.OverflowPages)
+		}
+	}
+
+	t.Run("InsertUpdateDelete", func(t *testing.T) {
+		bt, err := CreateBTree(bufmgr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for i := range pairs.keys {
+			if err := bt.Insert(bufmgr, pairs.keys[i], pairs.values[i]); err != nil {
+				t.Fatal(err)
+			}
+		}
+		checkContents(t, bt, pairs.values)
+
+		// A failed insert leaves no overflow pages behind
+		freePages := dm.NumFreePages()
+		if err := bt.Insert(bufmgr, pairs.keys[3], pairs.values[3]); err != ErrDuplicateKey {
+			t.Fatalf("expected ErrDuplicateKey, got %v", err)
+		}
+		if dm.NumFreePages() != freePages+overflowPagesOf(pairs.values[3:4]) {
+			t.Errorf("expected the pages of the rejected value to be freed")
+		}
+
+		// Values move in and out of overflow pages, whose old pages are freed
+		values := append([][]byte(nil), pairs.values...)
+		for i := range values {
+			values[i] = bytes.Repeat([]byte{byte(i + 1)}, sizes[(i+1)%len(sizes)])
+			if err := bt.Update(bufmgr, pairs.keys[i], values[i]); err != nil {
+				t.Fatal(err)
+			}
+		}
+		checkContents(t, bt, values)
+		for i := 0; i < len(values); i += 2 {
+			values[i] = bytes.Repeat([]byte{byte(i + 2)}, sizes[(i+2)%len(sizes)])
+			if _, err := bt.Upsert(bufmgr, pairs.keys[i], values[i]); err != nil {
+				t.Fatal(err)
+			}
+		}
+		checkContents(t, bt, values)
+
+		freePages = dm.NumFreePages()
+		for _, key := range pairs.keys[n/2:] {
+			if err := bt.Delete(bufmgr, key); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if dm.NumFreePages() < freePages+overflowPagesOf(values[n/2:]) {
+			t.Errorf("expected the overflow pages of the deleted values to be freed")
+		}
+		checkContents(t, bt, values[:n/2])
+	})
+
+	t.Run("InsertBatch", func(t *testing.T) {
+		bt, err := CreateBTree(bufmgr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if errs := bt.InsertBatch(bufmgr, pairs.keys, pairs.values); errs != nil {
+			t.Fatalf("expected no errors, got %v", errs)
+		}
+		checkContents(t, bt, pairs.values)
+	})
+
+	t.Run("BulkLoadAndDrop", func(t *testing.T) {
+		bt, err := BulkLoad(bufmgr, &pairSlice{keys: pairs.keys, values: pairs.values})
+		if err != nil {
+			t.Fatal(err)
+		}
+		checkContents(t, bt, pairs.values)
+		stats, err := bt.Stats(bufmgr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		freed, err := bt.Drop(bufmgr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if want := 1 + stats.LeafPages + stats.BranchPages + stats.OverflowPages; freed != want {
+			t.Errorf("expected %d pages to be freed, got %d", want, freed)
+		}
+	})
+}
@@ -0,0 +1,136 @@
+package btree
+
+import (
+	"errors"
+
+	"github.com/Johniel/gorelly/buffer"
+	"github.com/Johniel/gorelly/disk"
+)
+
+// PageLog receives the changes Insert, Update and Delete make to the pages of a tree, e.g. to
+// append them to the write-ahead log of a transaction (see transaction.PageLog).
+type PageLog interface {
+	// LogPage is called with the image of a modified page before and after the change, while
+	// the page is still latched, and returns the LSN of the log record. before is nil for a page
+	// allocated by the change.
+	LogPage(pageID disk.PageID, before []byte, after []byte) (uint64, error)
+	// LogFree is called with every page Delete frees, and with the overflow pages of the values
+	// Update and Upsert replace, once it is freed. The content of freed pages is not logged.
+	LogFree(pageID disk.PageID)
+}
+
+// CreateLog is implemented by PageLogs that log the creation of a tree (see CreateBTreeWithLog)
+// as a single record instead of the images of its new pages.
+type CreateLog interface {
+	// LogCreate is called once the meta and root pages of a new tree are initialized (see
+	// InitializeTree), and returns the LSN of the log record.
+	LogCreate(metaPageID disk.PageID, rootPageID disk.PageID) (uint64, error)
+}
+
+// PageLocker is implemented by PageLogs that lock the pages of a transaction before logging their
+// changes, so that two live transactions never change the same page (see transaction.PageLog).
+type PageLocker interface {
+	// LockPage is called with every page an operation changed, while it is still latched and
+	// before any change of the operation is logged. It must not wait. If it fails, the operation
+	// is reverted, its latches are released and WaitPage is called with the page.
+	LockPage(pageID disk.PageID) error
+	// WaitPage waits until the page LockPage failed to lock can be locked, with no latch of the
+	// tree held, so that the operation can start over. If it fails, e.g. because waiting would
+	// deadlock, the operation fails with its error.
+	WaitPage(pageID disk.PageID) error
+	// DefersFree reports whether LogFree takes over freeing the pages passed to it, instead of
+	// the tree freeing them before calling it.
+	DefersFree() bool
+}
+
+// pageLockError is the failure of LockPage to lock a page.
+type pageLockError struct {
+	pageID disk.PageID
+	err    error
+}
+
+func (e *pageLockError) Error() string {
+	return e.err.Error()
+}
+
+func (e *pageLockError) Unwrap() error {
+	return e.err
+}
+
+// lockPage locks a page through log, if it is a PageLocker.
+func lockPage(log PageLog, pageID disk.PageID) error {
+	if locker, ok := log.(PageLocker); ok {
+		if err := locker.LockPage(pageID); err != nil {
+			return &pageLockError{pageID: pageID, err: err}
+		}
+	}
+	return nil
+}
+
+// waitPage waits for a page through log, if it is a PageLocker.
+func waitPage(log PageLog, pageID disk.PageID) error {
+	if locker, ok := log.(PageLocker); ok {
+		return locker.WaitPage(pageID)
+	}
+	return nil
+}
+
+// retryLocked runs op until it does not fail to lock a page, waiting for the page through log
+// after each failure. op must leave the tree unchanged and release its latches when it fails
+// to lock a page, as writePath does.
+func retryLocked(log PageLog, op func() error) error {
+	for {
+		err := op()
+		var lockErr *pageLockError
+		if !errors.As(err, &lockErr) {
+			return err
+		}
+		if err := waitPage(log, lockErr.pageID); err != nil {
+			return err
+		}
+	}
+}
+
+// defersFree reports whether log frees the pages passed to LogFree itself.
+func defersFree(log PageLog) bool {
+	locker, ok := log.(PageLocker)
+	return ok && locker.DefersFree()
+}
+
+// logCreate logs the creation of the tree whose meta and root pages are in metaBuf and rootBuf,
+// if log is set.
+func logCreate(log PageLog, metaBuf *buffer.Buffer, rootBuf *buffer.Buffer) error {
+	cl, ok := log.(CreateLog)
+	if !ok {
+		if err := logPage(log, metaBuf, nil); err != nil {
+			return err
+		}
+		return logPage(log, rootBuf, nil)
+	}
+	lsn, err := cl.LogCreate(metaBuf.PageID, rootBuf.PageID)
+	if err != nil {
+		return err
+	}
+	metaBuf.SetLSN(lsn)
+	rootBuf.SetLSN(lsn)
+	return nil
+}
+
+// pageImage returns a copy of the page of buf.
+func pageImage(buf *buffer.Buffer) []byte {
+	return append([]byte(nil), buf.Page[:]...)
+}
+
+// logPage logs the change of the page of buf from before to its current content, if log is set,
+// and records the LSN of the log record in buf so that the log is made durable before the page.
+func logPage(log PageLog, buf *buffer.Buffer, before []byte) error {
+	if log == nil {
+		return nil
+	}
+	lsn, err := log.LogPage(buf.PageID, before, buf.Page[:])
+	if err != nil {
+		return err
+	}
+	buf.SetLSN(lsn)
+	return nil
+}
@@ -0,0 +1,7 @@
+//go:build race
+
+package btree
+
+// raceEnabled is true when the race detector is on. Optimistic reads deliberately race
+// with writers and rely on version validation, which the race detector reports.
+const raceEnabled = true
@@ -0,0 +1,67 @@
+package btree
+
+import (
+	"github.com/Johniel/gorelly/buffer"
+	"github.com/Johniel/gorelly/disk"
+)
+
+// Stats describes the shape of a B+ tree.
+type Stats struct {
+	Height        int     // Number of levels (1 for a tree that is a single leaf)
+	PagesPerLevel []int   // Number of pages on each level, from the root (index 0) to the leaves
+	LeafPages     int     // Number of leaf pages
+	BranchPages   int     // Number of internal (branch) pages
+	AvgFillFactor float64 // Average fraction of the page bodies in use over all pages
+	KeyCount      int     // Exact number of keys stored in the leaves
+	OverflowPages int     // Number of overflow pages holding the values too large for the leaves
+}
+
+// Stats walks the whole tree level by level and reports its shape.
+// It reads every node page once, so it is as expensive as a full scan; the overflow pages are
+// counted from the lengths of their values without reading them.
+func (bt *BTree) Stats(bufmgr *buffer.BufferPoolManager) (*Stats, error) {
+	rootPageID, err := bt.rootPageID(bufmgr)
+	if err != nil {
+		return nil, err
+	}
+
+	stats := &Stats{}
+	var fillSum float64
+	level := []disk.PageID{rootPageID}
+	for 0 < len(level) {
+		stats.Height++
+		stats.PagesPerLevel = append(stats.PagesPerLevel, len(level))
+		var nextLevel []disk.PageID
+		for _, pageID := range level {
+			nodeBuffer, err := bufmgr.FetchBuffer(pageID)
+			if err != nil {
+				return nil, err
+			}
+			node := NewNode(nodeBuffer.Page[:])
+			if node.IsLeaf() {
+				leafNode := node.AsLeaf()
+				stats.LeafPages++
+				stats.KeyCount += leafNode.NumPairs()
+				fillSum += leafNode.FillFactor()
+				for i := 0; i < leafNode.NumPairs(); i++ {
+					if pair := leafNode.PairAt(i); pair.Overflow {
+						stats.OverflowPages += overflowPages(pair.Value)
+					}
+				}
+			} else if node.IsBranch() {
+				branchNode := node.AsBranch()
+				stats.BranchPages++
+				fillSum += branchNode.FillFactor()
+				for i := 0; i <= branchNode.NumPairs(); i++ {
+					nextLevel = append(nextLevel, branchNode.ChildAt(i))
+				}
+			} else {
+				panic("unknown node type")
+			}
+			nodeBuffer.Unpin()
+		}
+		level = nextLevel
+	}
+	stats.AvgFillFactor = fillSum / float64(stats.LeafPages+stats.BranchPages)
+	return stats, nil
+}
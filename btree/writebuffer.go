@@ -0,0 +1,322 @@
+package btree
+
+import (
+	"bytes"
+	"errors"
+	"sort"
+	"sync"
+
+	"github.com/Johniel/gorelly/buffer"
+	"github.com/Johniel/gorelly/disk"
+)
+
+// DefaultWriteBufferSize is the number of buffered key and value bytes at which
+// a WriteBuffer flushes itself into its tree.
+const DefaultWriteBufferSize = 4 << 20
+
+// WriteBufferLog makes buffered pairs durable until they are flushed into the tree.
+type WriteBufferLog interface {
+	// LogInsert is called with every pair before it is buffered.
+	LogInsert(key []byte, value []byte) error
+	// LogFlush is called once the buffered pairs are durable in the tree;
+	// the pairs logged before it no longer need to be restored.
+	LogFlush() error
+}
+
+// WriteBuffer is an in-memory sorted buffer of inserts in front of a B+ tree, for tables
+// that ingest faster than the tree can be updated page by page. Inserts land in the buffer
+// and are merged into the tree in key order once Size bytes are buffered or Flush is called;
+// Search merges the buffer and the tree. Only inserts are buffered: pairs must be updated
+// or deleted in the tree after a Flush. Keys already in the tree are only detected when the
+// buffer is flushed.
+//
+// Buffered pairs are lost on a crash unless Log is set; after a restart, Restore refills the
+// buffer with the pairs logged since the last flush. A crash after the pairs reached the tree
+// but before LogFlush restores pairs that are in the tree already; a flush takes them as
+// flushed, and Search returns them once.
+type WriteBuffer struct {
+	Tree *BTree
+	Log  WriteBufferLog // Optional log of the buffered pairs
+	Size int            // Buffered bytes that trigger a flush (0 uses DefaultWriteBufferSize)
+
+	pairs []bufferedPair // Buffered pairs in key order
+	bytes int            // Buffered key and value bytes
+	mu    sync.Mutex
+}
+
+type bufferedPair struct {
+	key   []byte
+	value []byte
+}
+
+// NewWriteBuffer returns an empty write buffer in front of bt.
+func NewWriteBuffer(bt *BTree, log WriteBufferLog) *WriteBuffer {
+	return &WriteBuffer{Tree: bt, Log: log}
+}
+
+// Insert buffers a pair, flushing the buffer if it is full. It returns ErrDuplicateKey if the
+// key is buffered, the errors of BTree.Insert for oversized pairs and those of Flush.
+func (wb *WriteBuffer) Insert(bufmgr *buffer.BufferPoolManager, key []byte, value []byte) error {
+	if err := wb.Tree.Limits().check(key, value); err != nil {
+		return err
+	}
+	wb.mu.Lock()
+	defer wb.mu.Unlock()
+
+	i, found := wb.find(key)
+	if found {
+		return ErrDuplicateKey
+	}
+	if wb.Log != nil {
+		if err := wb.Log.LogInsert(key, value); err != nil {
+			return err
+		}
+	}
+	wb.insertAt(i, key, value)
+
+	if wb.limit() <= wb.bytes {
+		return wb.flush(bufmgr)
+	}
+	return nil
+}
+
+// Restore buffers a pair read back from the log without logging it again.
+// Pairs already in the buffer are ignored, so restoring twice is harmless.
+func (wb *WriteBuffer) Restore(key []byte, value []byte) {
+	wb.mu.Lock()
+	defer wb.mu.Unlock()
+	if i, found := wb.find(key); !found {
+		wb.insertAt(i, key, value)
+	}
+}
+
+// Flush merges the buffered pairs into the tree with InsertBatch, which descends the tree once
+// per leaf instead of once per pair, writes the pages it changed to disk and then marks the
+// pairs as flushed in the log. A buffered pair whose key the tree already has with the same
+// value, e.g. one restored after a crash that came before LogFlush, counts as flushed. A pair
+// whose key the tree has with another value is dropped, and Flush returns ErrDuplicateKey once
+// the other pairs are flushed. On any other error the pairs that did not reach the tree stay
+// buffered and nothing is marked as flushed.
+func (wb *WriteBuffer) Flush(bufmgr *buffer.BufferPoolManager) error {
+	wb.mu.Lock()
+	defer wb.mu.Unlock()
+	return wb.flush(bufmgr)
+}
+
+func (wb *WriteBuffer) flush(bufmgr *buffer.BufferPoolManager) error {
+	if len(wb.pairs) == 0 {
+		return nil
+	}
+	keys := make([][]byte, len(wb.pairs))
+	values := make([][]byte, len(wb.pairs))
+	for i, pair := range wb.pairs {
+		keys[i] = pair.key
+		values[i] = pair.value
+	}
+	changes := &flushLog{log: wb.Tree.Log, pages: make(map[disk.PageID]bool)}
+	bt := &BTree{MetaPageID: wb.Tree.MetaPageID, Log: changes}
+	errs := bt.InsertBatch(bufmgr, keys, values)
+
+	var kept []bufferedPair
+	var keptErr error
+	duplicate := false
+	for i, err := range errs {
+		if err == nil {
+			continue
+		}
+		if errors.Is(err, ErrDuplicateKey) {
+			value, getErr := wb.Tree.Get(bufmgr, keys[i])
+			if getErr == nil && bytes.Equal(value, values[i]) {
+				continue
+			}
+			if getErr == nil {
+				duplicate = true
+				continue
+			}
+			err = getErr
+		}
+		kept = append(kept, wb.pairs[i])
+		keptErr = errors.Join(keptErr, err)
+	}
+	wb.pairs = kept
+	wb.bytes = 0
+	for _, pair := range wb.pairs {
+		wb.bytes += len(pair.key) + len(pair.value)
+	}
+
+	if err := bufmgr.FlushPages(changes.pageIDs()); err != nil {
+		return err
+	}
+	if keptErr != nil {
+		// The kept pairs are only in the log, so the logged pairs must still be restored
+		return keptErr
+	}
+	if wb.Log != nil {
+		if err := wb.Log.LogFlush(); err != nil {
+			return err
+		}
+	}
+	if duplicate {
+		return ErrDuplicateKey
+	}
+	return nil
+}
+
+// flushLog passes the changes a flush makes to the tree on to the tree's Log and records the
+// pages they touch, so that only those are written back.
+type flushLog struct {
+	log   PageLog // The tree's log (nil if its changes are not logged)
+	pages map[disk.PageID]bool
+}
+
+func (fl *flushLog) LogPage(pageID disk.PageID, before []byte, after []byte) (uint64, error) {
+	fl.pages[pageID] = true
+	if fl.log == nil {
+		return 0, nil
+	}
+	return fl.log.LogPage(pageID, before, after)
+}
+
+func (fl *flushLog) LogFree(pageID disk.PageID) {
+	delete(fl.pages, pageID)
+	if fl.log != nil {
+		fl.log.LogFree(pageID)
+	}
+}
+
+// LockPage, WaitPage and DefersFree make the flush log a PageLocker that passes on to the tree's log.
+func (fl *flushLog) LockPage(pageID disk.PageID) error {
+	return lockPage(fl.log, pageID)
+}
+
+func (fl *flushLog) WaitPage(pageID disk.PageID) error {
+	return waitPage(fl.log, pageID)
+}
+
+func (fl *flushLog) DefersFree() bool {
+	return defersFree(fl.log)
+}
+
+func (fl *flushLog) pageIDs() []disk.PageID {
+	pageIDs := make([]disk.PageID, 0, len(fl.pages))
+	for pageID := range fl.pages {
+		pageIDs = append(pageIDs, pageID)
+	}
+	return pageIDs
+}
+
+// Len returns the number of buffered pairs.
+func (wb *WriteBuffer) Len() int {
+	wb.mu.Lock()
+	defer wb.mu.Unlock()
+	return len(wb.pairs)
+}
+
+// Search returns an iterator over the pairs of both the buffer and the tree selected by
+// searchMode, in key order (descending if searchMode.Reverse). The iterator sees the pairs
+// buffered when Search is called.
+func (wb *WriteBuffer) Search(bufmgr *buffer.BufferPoolManager, searchMode SearchMode) (*MergeIter, error) {
+	// after returns the position of the first buffered key after bound, or at it if inclusive
+	after := func(bound []byte, inclusive bool) int {
+		return sort.Search(len(wb.pairs), func(i int) bool {
+			c := bytes.Compare(wb.pairs[i].key, bound)
+			return 0 < c || (c == 0 && inclusive)
+		})
+	}
+	wb.mu.Lock()
+	start, end := 0, len(wb.pairs)
+	switch {
+	case searchMode.Reverse:
+		if !searchMode.IsStart {
+			end = after(searchMode.Key, searchMode.ExcludeKey)
+		}
+		if searchMode.End != nil {
+			start = after(searchMode.End, searchMode.EndInclusive)
+		}
+	default:
+		if !searchMode.IsStart {
+			start = after(searchMode.Key, !searchMode.ExcludeKey)
+		}
+		if searchMode.End != nil {
+			end = after(searchMode.End, !searchMode.EndInclusive)
+		}
+	}
+	buffered := append([]bufferedPair(nil), wb.pairs[start:max(start, end)]...)
+	wb.mu.Unlock()
+
+	treeIter, err := wb.Tree.Search(bufmgr, searchMode)
+	if err != nil {
+		return nil, err
+	}
+	return &MergeIter{tree: treeIter, buffered: buffered, reverse: searchMode.Reverse}, nil
+}
+
+// find returns the position of key in the buffer and whether it is there.
+func (wb *WriteBuffer) find(key []byte) (int, bool) {
+	i := sort.Search(len(wb.pairs), func(i int) bool {
+		return 0 <= bytes.Compare(wb.pairs[i].key, key)
+	})
+	return i, i < len(wb.pairs) && bytes.Equal(wb.pairs[i].key, key)
+}
+
+func (wb *WriteBuffer) insertAt(i int, key []byte, value []byte) {
+	pair := bufferedPair{key: append([]byte(nil), key...), value: append([]byte(nil), value...)}
+	wb.pairs = append(wb.pairs, bufferedPair{})
+	copy(wb.pairs[i+1:], wb.pairs[i:])
+	wb.pairs[i] = pair
+	wb.bytes += len(key) + len(value)
+}
+
+func (wb *WriteBuffer) limit() int {
+	if wb.Size <= 0 {
+		return DefaultWriteBufferSize
+	}
+	return wb.Size
+}
+
+// MergeIter iterates over the pairs of a WriteBuffer and its tree in key order, or in
+// descending key order for a Reverse search.
+type MergeIter struct {
+	tree     *Iter
+	buffered []bufferedPair // Remaining buffered pairs, in ascending key order
+	reverse  bool
+}
+
+// Next returns the next remaining pair and advances past it, like Iter.Next. A buffered pair
+// whose key is also in the tree was flushed before its flush was logged; only the pair of the
+// tree is returned.
+func (mi *MergeIter) Next(bufmgr *buffer.BufferPoolManager) ([]byte, []byte, bool, error) {
+	for len(mi.buffered) != 0 {
+		next := 0
+		if mi.reverse {
+			next = len(mi.buffered) - 1
+		}
+		pair := mi.buffered[next]
+		treeKey, treeOk := mi.tree.GetKey()
+		c := 0
+		if treeOk {
+			c = bytes.Compare(pair.key, treeKey)
+			if mi.reverse {
+				c = -c
+			}
+		}
+		if treeOk && 0 < c {
+			break
+		}
+		if mi.reverse {
+			mi.buffered = mi.buffered[:next]
+		} else {
+			mi.buffered = mi.buffered[1:]
+		}
+		if treeOk && c == 0 {
+			break
+		}
+		return append([]byte(nil), pair.key...), append([]byte(nil), pair.value...), true, nil
+	}
+	return mi.tree.Next(bufmgr)
+}
+
+// Close releases the tree iterator, like Iter.Close.
+func (mi *MergeIter) Close() {
+	mi.tree.Close()
+}
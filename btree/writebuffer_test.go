@@ -0,0 +1,176 @@
+package btree
+
+import (
+	"encoding/binary"
+	"os"
+	"reflect"
+	"testing"
+
+	"github.com/Johniel/gorelly/buffer"
+	"github.com/Johniel/gorelly/disk"
+)
+
+type recordingLog struct {
+	inserts int
+	flushes int
+}
+
+func (l *recordingLog) LogInsert(key []byte, value []byte) error {
+	l.inserts++
+	return nil
+}
+
+func (l *recordingLog) LogFlush() error {
+	l.flushes++
+	return nil
+}
+
+func TestWriteBuffer(t *testing.T) {
+	tmpfile, err := os.CreateTemp("", "test_write_buffer_*.db")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(tmpfile.Name())
+
+	dm, err := disk.NewDiskManager(tmpfile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer dm.Close()
+
+	pool := buffer.NewBufferPool(10)
+	bufmgr := buffer.NewBufferPoolManager(dm, pool)
+
+	bt, err := CreateBTree(bufmgr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	encode := func(i uint64) []byte {
+		key := make([]byte, 8)
+		binary.BigEndian.PutUint64(key, i)
+		return key
+	}
+	scan := func(t *testing.T, wb *WriteBuffer, mode SearchMode) []uint64 {
+		t.Helper()
+		iter, err := wb.Search(bufmgr, mode)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var keys []uint64
+		for {
+			key, value, ok, err := iter.Next(bufmgr)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !ok {
+				return keys
+			}
+			if !reflect.DeepEqual(key, value) {
+				t.Errorf("key %x: unexpected value %x", key, value)
+			}
+			keys = append(keys, binary.BigEndian.Uint64(key))
+		}
+	}
+
+	// Even keys are in the tree, odd keys in the buffer
+	for i := uint64(0); i < 10; i += 2 {
+		if err := bt.Insert(bufmgr, encode(i), encode(i)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	log := &recordingLog{}
+	wb := NewWriteBuffer(bt, log)
+	for _, i := range []uint64{7, 1, 5, 9, 3} {
+		if err := wb.Insert(bufmgr, encode(i), encode(i)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	t.Run("Duplicates", func(t *testing.T) {
+		if err := wb.Insert(bufmgr, encode(3), nil); err != ErrDuplicateKey {
+			t.Errorf("expected ErrDuplicateKey for a buffered key, got %v", err)
+		}
+		// A key in the tree is only detected by the flush
+		if err := wb.Insert(bufmgr, encode(4), nil); err != nil {
+			t.Fatal(err)
+		}
+		if log.inserts != 6 {
+			t.Errorf("expected 6 logged inserts, got %d", log.inserts)
+		}
+	})
+
+	t.Run("Search", func(t *testing.T) {
+		if got := scan(t, wb, NewSearchModeStart()); !reflect.DeepEqual(got, []uint64{0, 1, 2, 3, 4, 5, 6, 7, 8, 9}) {
+			t.Errorf("unexpected full scan %v", got)
+		}
+		if got := scan(t, wb, NewSearchModeRange(encode(3), false, encode(7), true)); !reflect.DeepEqual(got, []uint64{4, 5, 6, 7}) {
+			t.Errorf("unexpected range scan %v", got)
+		}
+		reverse := NewSearchModeRange(encode(7), true, encode(3), false)
+		reverse.Reverse = true
+		if got := scan(t, wb, reverse); !reflect.DeepEqual(got, []uint64{7, 6, 5, 4}) {
+			t.Errorf("unexpected reverse range scan %v", got)
+		}
+		if got := scan(t, wb, SearchMode{IsStart: true, Reverse: true}); !reflect.DeepEqual(got, []uint64{9, 8, 7, 6, 5, 4, 3, 2, 1, 0}) {
+			t.Errorf("unexpected reverse scan %v", got)
+		}
+	})
+
+	t.Run("Flush", func(t *testing.T) {
+		if err := bufmgr.Flush(); err != nil {
+			t.Fatal(err)
+		}
+		// The pages of another tree, which the flush leaves alone
+		if _, err := CreateBTree(bufmgr); err != nil {
+			t.Fatal(err)
+		}
+		// The pair of key 4 conflicts with the one in the tree and is dropped
+		if err := wb.Flush(bufmgr); err != ErrDuplicateKey {
+			t.Fatalf("expected ErrDuplicateKey, got %v", err)
+		}
+		if dirty := bufmgr.PoolStats().Dirty; dirty != 2 {
+			t.Errorf("expected only the pages of the tree to be written back, got %d dirty pages", dirty)
+		}
+		if wb.Len() != 0 || log.flushes != 1 {
+			t.Errorf("expected an empty buffer and 1 logged flush, got %d pairs and %d flushes", wb.Len(), log.flushes)
+		}
+		iter, err := bt.Search(bufmgr, NewSearchModeKey(encode(5)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if key, _, ok := iter.Get(); !ok || !reflect.DeepEqual(key, encode(5)) {
+			t.Errorf("expected the flushed key in the tree, got %x", key)
+		}
+		if got := scan(t, wb, NewSearchModeStart()); len(got) != 10 {
+			t.Errorf("expected 10 keys after the flush, got %v", got)
+		}
+	})
+
+	t.Run("RestoreFlushed", func(t *testing.T) {
+		// A crash after the pairs reached the tree but before LogFlush restores them again
+		for _, i := range []uint64{1, 3} {
+			wb.Restore(encode(i), encode(i))
+		}
+		if got := scan(t, wb, NewSearchModeStart()); !reflect.DeepEqual(got, []uint64{0, 1, 2, 3, 4, 5, 6, 7, 8, 9}) {
+			t.Errorf("expected restored keys once, got %v", got)
+		}
+		if err := wb.Flush(bufmgr); err != nil {
+			t.Fatal(err)
+		}
+		if wb.Len() != 0 || log.flushes != 2 {
+			t.Errorf("expected the restored pairs to count as flushed, got %d pairs and %d flushes", wb.Len(), log.flushes)
+		}
+	})
+
+	t.Run("FlushWhenFull", func(t *testing.T) {
+		wb.Size = 3 * 16 // Three 8-byte keys with 8-byte values
+		for i := uint64(10); i < 13; i++ {
+			if err := wb.Insert(bufmgr, encode(i), encode(i)); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if wb.Len() != 0 || log.flushes != 3 {
+			t.Errorf("expected the full buffer to flush itself, got %d pairs and %d flushes", wb.Len(), log.flushes)
+		}
+	})
+}
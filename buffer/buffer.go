@@ -3,16 +3,20 @@
 package buffer
 
 import (
-	"errors"
 	"io"
+	"runtime"
 	"sync"
+	"sync/atomic"
+	"time"
 
 	"github.com/Johniel/gorelly/disk"
+	"github.com/Johniel/gorelly/errcode"
+	"github.com/Johniel/gorelly/histogram"
 )
 
 var (
 	// ErrNoFreeBuffer is returned when no free buffer is available in the buffer pool.
-	ErrNoFreeBuffer = errors.New("no free buffer available in buffer pool")
+	ErrNoFreeBuffer = errcode.New(errcode.ResourceExhausted, "no free buffer available in buffer pool")
 )
 
 // BufferId identifies a buffer slot in the buffer pool.
@@ -24,12 +28,26 @@ type Page = [disk.PageSize]byte
 // Buffer represents a cached page in memory.
 // It contains the page data and metadata about its state.
 type Buffer struct {
-	PageID  disk.PageID // The page ID this buffer represents
-	Page    *Page       // The actual page data
-	IsDirty bool        // Whether the page has been modified and needs to be written back
+	PageID  disk.PageID    // The page ID this buffer represents
+	Page    *Page          // The actual page data
+	IsDirty bool           // Whether the page has been modified and needs to be written back
+	version uint64         // Optimistic read version; odd while a writer holds the latch
+	dirtied *atomic.Uint64 // Counter of the owning manager incremented when the page becomes dirty
+	pins    atomic.Int32   // Number of users of the page; a pinned buffer is never evicted
+	lsn     atomic.Uint64  // LSN of the last logged change to the page (see SetLSN)
+	changes atomic.Uint64  // Number of MarkDirty calls, which invalidate the derived value
+	derived atomic.Pointer[derivedValue]
 	mu      sync.RWMutex
 }
 
+// derivedValue is a value computed from the page by Derive, with the state of the buffer it was
+// computed for.
+type derivedValue struct {
+	version uint64
+	changes uint64
+	value   any
+}
+
 func NewBuffer() *Buffer {
 	return &Buffer{
 		PageID:  disk.InvalidPageID,
@@ -38,18 +56,134 @@ func NewBuffer() *Buffer {
 	}
 }
 
+// MarkDirty marks the page as modified. A clean page becoming dirty is counted
+// in the pages dirtied statistic of the buffer pool manager.
+func (b *Buffer) MarkDirty() {
+	if !b.IsDirty && b.dirtied != nil {
+		b.dirtied.Add(1)
+	}
+	b.IsDirty = true
+	b.changes.Add(1)
+}
+
+// Derive returns a value computed from the page by compute, e.g. a search structure over its
+// records, reusing the value computed by the previous call if the page has not changed since.
+// The value is dropped when the page is modified (MarkDirty is called or a write latch is
+// released as modified) and when the frame is reused for another page. While a writer holds
+// the latch, compute is called every time and its value is not kept.
+func (b *Buffer) Derive(compute func() any) any {
+	version := atomic.LoadUint64(&b.version)
+	if version%2 == 1 {
+		return compute()
+	}
+	changes := b.changes.Load()
+	if d := b.derived.Load(); d != nil && d.version == version && d.changes == changes {
+		return d.value
+	}
+	value := compute()
+	// Keep the value only if the page did not change while it was computed
+	if atomic.LoadUint64(&b.version) == version && b.changes.Load() == changes {
+		b.derived.Store(&derivedValue{version: version, changes: changes, value: value})
+	}
+	return value
+}
+
+// Pin adds a user of the buffer, e.g. an iterator keeping its page after the call that
+// fetched it. Buffers returned by FetchBuffer and CreateBuffer are already pinned once.
+func (b *Buffer) Pin() {
+	b.pins.Add(1)
+}
+
+// Unpin releases one pin taken by Pin, FetchBuffer or CreateBuffer. Once the last pin is
+// released the page may be evicted, and the buffer must not be accessed anymore.
+func (b *Buffer) Unpin() {
+	if b.pins.Add(-1) < 0 {
+		panic("buffer: unpin of a buffer that is not pinned")
+	}
+}
+
+// PinCount returns the number of pins held on the buffer.
+func (b *Buffer) PinCount() int {
+	return int(b.pins.Load())
+}
+
+// SetLSN records that the page contains a change logged at lsn. Before the page is written
+// back, the log is made durable up to the greatest LSN set (see BufferPoolManager.SetWAL).
+func (b *Buffer) SetLSN(lsn uint64) {
+	for {
+		current := b.lsn.Load()
+		if lsn <= current || b.lsn.CompareAndSwap(current, lsn) {
+			return
+		}
+	}
+}
+
+// LSN returns the LSN of the last logged change to the page, or 0 if none was logged
+// since the page was loaded.
+func (b *Buffer) LSN() uint64 {
+	return b.lsn.Load()
+}
+
+// WriteLatch acquires the exclusive write latch of the buffer.
+// While it is held the version is odd, so optimistic readers wait or retry.
+func (b *Buffer) WriteLatch() {
+	b.mu.Lock()
+	atomic.AddUint64(&b.version, 1)
+}
+
+// WriteUnlatch releases the write latch. If the page was not modified the previous
+// version is restored, so optimistic readers that saw it before the latch stay valid.
+func (b *Buffer) WriteUnlatch(modified bool) {
+	if modified {
+		atomic.AddUint64(&b.version, 1)
+	} else {
+		atomic.AddUint64(&b.version, ^uint64(0))
+	}
+	b.mu.Unlock()
+}
+
+// ReadVersion waits until no writer holds the latch and returns the version
+// an optimistic reader validates against after reading the page.
+func (b *Buffer) ReadVersion() uint64 {
+	for {
+		version := atomic.LoadUint64(&b.version)
+		if version%2 == 0 {
+			return version
+		}
+		runtime.Gosched()
+	}
+}
+
+// isWriteLatched reports whether a writer holds the latch of the buffer.
+func (b *Buffer) isWriteLatched() bool {
+	return atomic.LoadUint64(&b.version)%2 == 1
+}
+
+// Validate reports whether the page is unchanged since ReadVersion returned version.
+func (b *Buffer) Validate(version uint64) bool {
+	return atomic.LoadUint64(&b.version) == version
+}
+
 // Frame wraps a Buffer with usage tracking for the buffer pool replacement algorithm.
 type Frame struct {
 	UsageCount uint64  // Number of times this buffer has been accessed
 	Buffer     *Buffer // The actual buffer
+	reserved   bool    // Whether the frame is set aside by a Reservation
 	mu         sync.RWMutex
 }
 
+// evictable reports whether the frame may be reused for another page. The caller must hold frame.mu.
+func (frame *Frame) evictable() bool {
+	return !frame.reserved && frame.Buffer.PinCount() == 0 && !frame.Buffer.isWriteLatched()
+}
+
 // BufferPool manages a fixed-size pool of page buffers.
-// It implements a clock replacement algorithm to evict pages when the pool is full.
+// It evicts pages with the clock algorithm when the pool is full, unless it was created with
+// another Replacer.
 type BufferPool struct {
 	buffers      []*Frame
 	nextVictimId BufferId // Next buffer to consider for eviction (clock hand)
+	replacer     Replacer // Replacement policy; nil selects the clock algorithm
 	mu           sync.Mutex
 }
 
@@ -66,14 +200,40 @@ func NewBufferPool(poolSize int) *BufferPool {
 	}
 }
 
+// NewBufferPoolWithReplacer is like NewBufferPool but picks the frames to evict with replacer
+// instead of the clock algorithm.
+func NewBufferPoolWithReplacer(poolSize int, replacer Replacer) *BufferPool {
+	bp := NewBufferPool(poolSize)
+	bp.replacer = replacer
+	return bp
+}
+
 func (bp *BufferPool) Size() int {
 	return len(bp.buffers)
 }
 
+// Evict picks a frame to reuse with the clock algorithm. Frames set aside by a Reservation and
+// frames whose buffer is pinned or write-latched are never picked; if there are no other frames,
+// it returns false.
 func (bp *BufferPool) Evict() (BufferId, bool) {
 	bp.mu.Lock()
 	defer bp.mu.Unlock()
 
+	if bp.replacer != nil {
+		var candidates []BufferId
+		for i, frame := range bp.buffers {
+			frame.mu.RLock()
+			if frame.evictable() {
+				candidates = append(candidates, BufferId(i))
+			}
+			frame.mu.RUnlock()
+		}
+		if len(candidates) == 0 {
+			return 0, false
+		}
+		return bp.replacer.Victim(candidates), true
+	}
+
 	poolSize := bp.Size()
 	consecutivePinned := 0
 
@@ -82,23 +242,18 @@ func (bp *BufferPool) Evict() (BufferId, bool) {
 		frame := bp.buffers[nextVictimId]
 
 		frame.mu.Lock()
-		if frame.UsageCount == 0 {
-			frame.mu.Unlock()
-			return nextVictimId, true
-		}
-
-		// Check if buffer is still referenced elsewhere
-		// In Go, we can't easily check reference count, so we use a simpler approach
-		// If usage count is high, we decrement it
-		if frame.UsageCount > 0 {
-			frame.UsageCount--
-			consecutivePinned = 0
-		} else {
+		if !frame.evictable() {
 			consecutivePinned++
 			if consecutivePinned >= poolSize {
 				frame.mu.Unlock()
 				return 0, false
 			}
+		} else if frame.UsageCount == 0 {
+			frame.mu.Unlock()
+			return nextVictimId, true
+		} else {
+			frame.UsageCount--
+			consecutivePinned = 0
 		}
 		frame.mu.Unlock()
 
@@ -106,17 +261,62 @@ func (bp *BufferPool) Evict() (BufferId, bool) {
 	}
 }
 
+// accessed notifies the replacer that the page held by a frame was accessed.
+func (bp *BufferPool) accessed(bufferId BufferId) {
+	if bp.replacer != nil {
+		bp.replacer.RecordAccess(bufferId)
+	}
+}
+
+// forget notifies the replacer that a frame no longer holds the page it was accessed for,
+// so that the frame is among the first to be reused.
+func (bp *BufferPool) forget(bufferId BufferId) {
+	if bp.replacer != nil {
+		bp.replacer.Remove(bufferId)
+	}
+}
+
 // BufferPoolManager coordinates between disk I/O and the buffer pool.
 // It maintains a page table mapping page IDs to buffer slots and handles
 // page fetching, creation, and eviction.
 type BufferPoolManager struct {
-	disk      *disk.DiskManager
-	pool      *BufferPool
-	pageTable map[disk.PageID]BufferId // Maps page IDs to buffer slots
-	mu        sync.RWMutex
+	disk         disk.PageStore
+	pool         *BufferPool
+	pageTable    map[disk.PageID]BufferId // Maps page IDs to buffer slots
+	pagesRead    atomic.Uint64
+	pagesDirtied atomic.Uint64
+	pagesWritten atomic.Uint64
+	fetchHit     histogram.Histogram       // Latency of fetches of cached pages
+	fetchMiss    histogram.Histogram       // Latency of fetches that read the page from disk
+	wal          WAL                       // Optional: log made durable before pages are written back
+	injection    atomic.Pointer[injection] // Optional eviction injector of tests (see SetEvictionInjector)
+	mu           sync.RWMutex
 }
 
-func NewBufferPoolManager(dm *disk.DiskManager, pool *BufferPool) *BufferPoolManager {
+// WAL is the write-ahead log of the pages of a buffer pool.
+type WAL interface {
+	// FlushTo makes the log durable up to and including lsn.
+	FlushTo(lsn uint64) error
+}
+
+// IOStats is a snapshot of the I/O counters of a buffer pool manager.
+// The counters only grow; the cost of an operation is the difference of two snapshots.
+type IOStats struct {
+	PagesRead    uint64 // Pages read from disk on a buffer pool miss
+	PagesDirtied uint64 // Times a clean or new page became dirty
+	PagesWritten uint64 // Dirty pages written back to disk
+}
+
+// Sub returns the counters accumulated since the earlier snapshot.
+func (s IOStats) Sub(earlier IOStats) IOStats {
+	return IOStats{
+		PagesRead:    s.PagesRead - earlier.PagesRead,
+		PagesDirtied: s.PagesDirtied - earlier.PagesDirtied,
+		PagesWritten: s.PagesWritten - earlier.PagesWritten,
+	}
+}
+
+func NewBufferPoolManager(dm disk.PageStore, pool *BufferPool) *BufferPoolManager {
 	return &BufferPoolManager{
 		disk:      dm,
 		pool:      pool,
@@ -124,9 +324,90 @@ func NewBufferPoolManager(dm *disk.DiskManager, pool *BufferPool) *BufferPoolMan
 	}
 }
 
+// SetWAL enforces the write-ahead rule: a dirty page whose changes were logged (see Buffer.SetLSN)
+// is written back only after wal is durable up to the page's LSN. A nil wal disables the check.
+func (bpm *BufferPoolManager) SetWAL(wal WAL) {
+	bpm.mu.Lock()
+	defer bpm.mu.Unlock()
+	bpm.wal = wal
+}
+
+// flushLog makes the log durable up to the LSN of a page about to be written back.
+// The caller must hold bpm.mu.
+func (bpm *BufferPoolManager) flushLog(buf *Buffer) error {
+	if lsn := buf.LSN(); bpm.wal != nil && lsn != 0 {
+		return bpm.wal.FlushTo(lsn)
+	}
+	return nil
+}
+
+// IOStats returns the current values of the I/O counters.
+func (bpm *BufferPoolManager) IOStats() IOStats {
+	return IOStats{
+		PagesRead:    bpm.pagesRead.Load(),
+		PagesDirtied: bpm.pagesDirtied.Load(),
+		PagesWritten: bpm.pagesWritten.Load(),
+	}
+}
+
+// FetchLatency holds the latency distributions of page fetches, split by whether the page was cached.
+type FetchLatency struct {
+	Hit  histogram.Snapshot
+	Miss histogram.Snapshot
+}
+
+// FetchLatency returns the latency distributions of the page fetches so far.
+func (bpm *BufferPoolManager) FetchLatency() FetchLatency {
+	return FetchLatency{Hit: bpm.fetchHit.Snapshot(), Miss: bpm.fetchMiss.Snapshot()}
+}
+
+// PoolStats describes the current contents of the buffer pool.
+type PoolStats struct {
+	Frames   int // Size of the pool
+	Cached   int // Frames holding a page
+	Dirty    int // Frames holding a page not yet written back
+	Reserved int // Frames set aside by a Reservation
+	Pinned   int // Frames whose buffer is pinned
+}
+
+// PoolStats returns the current contents of the buffer pool, e.g. for monitoring.
+func (bpm *BufferPoolManager) PoolStats() PoolStats {
+	bpm.mu.RLock()
+	defer bpm.mu.RUnlock()
+	stats := PoolStats{Frames: bpm.pool.Size()}
+	for _, frame := range bpm.pool.buffers {
+		frame.mu.RLock()
+		if frame.Buffer.PageID != disk.InvalidPageID {
+			stats.Cached++
+			if frame.Buffer.IsDirty {
+				stats.Dirty++
+			}
+		}
+		if frame.reserved {
+			stats.Reserved++
+		}
+		if 0 < frame.Buffer.PinCount() {
+			stats.Pinned++
+		}
+		frame.mu.RUnlock()
+	}
+	return stats
+}
+
 // FetchBuffer retrieves a page from the buffer pool or loads it from disk if not already in memory.
-// It returns a Buffer containing the page data and metadata.
+// It returns a Buffer containing the page data and metadata, pinned for the caller, who must
+// Unpin it when done. It returns ErrNoFreeBuffer if the page is not cached and every frame is
+// pinned, write-latched or reserved.
 func (bpm *BufferPoolManager) FetchBuffer(pageID disk.PageID) (*Buffer, error) {
+	buf, _, err := bpm.FetchBufferCached(pageID)
+	return buf, err
+}
+
+// FetchBufferCached is like FetchBuffer but also reports whether the page was cached,
+// i.e. whether the fetch was served without reading from disk.
+func (bpm *BufferPoolManager) FetchBufferCached(pageID disk.PageID) (*Buffer, bool, error) {
+	bpm.inject(pageID)
+	start := time.Now()
 	bpm.mu.Lock()
 	defer bpm.mu.Unlock()
 
@@ -134,46 +415,81 @@ func (bpm *BufferPoolManager) FetchBuffer(pageID disk.PageID) (*Buffer, error) {
 		frame := bpm.pool.buffers[bufferId]
 		frame.mu.Lock()
 		frame.UsageCount++
+		frame.Buffer.Pin()
 		frame.mu.Unlock()
-		return frame.Buffer, nil
+		bpm.pool.accessed(bufferId)
+		bpm.fetchHit.Since(start)
+		return frame.Buffer, true, nil
 	}
-
 	bufferId, ok := bpm.pool.Evict()
 	if !ok {
-		return nil, ErrNoFreeBuffer
+		return nil, false, ErrNoFreeBuffer
 	}
+	defer bpm.fetchMiss.Since(start)
+	buf, err := bpm.loadPage(bufferId, pageID)
+	return buf, false, err
+}
 
+// loadPage reads a page into a frame picked for eviction or set aside by a reservation.
+// The caller must hold bpm.mu.
+func (bpm *BufferPoolManager) loadPage(bufferId BufferId, pageID disk.PageID) (*Buffer, error) {
 	frame := bpm.pool.buffers[bufferId]
 	frame.mu.Lock()
 	defer frame.mu.Unlock()
 
-	evictPageID := frame.Buffer.PageID
-	if frame.Buffer.IsDirty {
-		if err := bpm.disk.WritePageData(evictPageID, frame.Buffer.Page[:]); err != nil {
-			return nil, err
-		}
+	if err := bpm.writeBack(frame); err != nil {
+		return nil, err
 	}
 
+	// Bump the version so optimistic readers of the evicted page notice the reuse
+	atomic.AddUint64(&frame.Buffer.version, 2)
 	frame.Buffer.PageID = pageID
 	frame.Buffer.IsDirty = false
+	frame.Buffer.dirtied = &bpm.pagesDirtied
+	frame.Buffer.lsn.Store(0)
 	if err := bpm.disk.ReadPageData(pageID, frame.Buffer.Page[:]); err != nil {
 		if err != io.EOF {
 			return nil, err
 		}
 		// If EOF, page doesn't exist yet, initialize with zeros
 		*frame.Buffer.Page = Page{}
+	} else {
+		bpm.pagesRead.Add(1)
 	}
 	frame.UsageCount = 1
+	frame.reserved = false
+	frame.Buffer.Pin()
+	bpm.pool.forget(bufferId)
+	bpm.pool.accessed(bufferId)
 
-	delete(bpm.pageTable, evictPageID)
 	bpm.pageTable[pageID] = bufferId
-
 	return frame.Buffer, nil
 }
 
+// writeBack writes the page of a frame back to disk if it is dirty and drops it from the
+// page table. The caller must hold bpm.mu and frame.mu.
+func (bpm *BufferPoolManager) writeBack(frame *Frame) error {
+	evictPageID := frame.Buffer.PageID
+	if frame.Buffer.IsDirty {
+		if err := bpm.flushLog(frame.Buffer); err != nil {
+			return err
+		}
+		if err := bpm.disk.WritePageData(evictPageID, frame.Buffer.Page[:]); err != nil {
+			return err
+		}
+		bpm.pagesWritten.Add(1)
+		frame.Buffer.IsDirty = false
+	}
+	if bufferId, ok := bpm.pageTable[evictPageID]; ok && bpm.pool.buffers[bufferId] == frame {
+		delete(bpm.pageTable, evictPageID)
+	}
+	return nil
+}
+
 // CreateBuffer allocates a new page and returns a Buffer containing it.
-// The returned buffer is marked as dirty.
+// The returned buffer is marked as dirty and pinned like one returned by FetchBuffer.
 func (bpm *BufferPoolManager) CreateBuffer() (*Buffer, error) {
+	bpm.inject(disk.InvalidPageID)
 	bpm.mu.Lock()
 	defer bpm.mu.Unlock()
 
@@ -181,30 +497,289 @@ func (bpm *BufferPoolManager) CreateBuffer() (*Buffer, error) {
 	if !ok {
 		return nil, ErrNoFreeBuffer
 	}
+	return bpm.newPage(bufferId)
+}
 
+// newPage allocates a page in a frame picked for eviction or set aside by a reservation.
+// The caller must hold bpm.mu.
+func (bpm *BufferPoolManager) newPage(bufferId BufferId) (*Buffer, error) {
 	frame := bpm.pool.buffers[bufferId]
 	frame.mu.Lock()
 	defer frame.mu.Unlock()
 
-	evictPageID := frame.Buffer.PageID
-	if frame.Buffer.IsDirty {
-		if err := bpm.disk.WritePageData(evictPageID, frame.Buffer.Page[:]); err != nil {
-			return nil, err
-		}
+	if err := bpm.writeBack(frame); err != nil {
+		return nil, err
 	}
 
 	pageID := bpm.disk.AllocatePage()
-	*frame.Buffer = *NewBuffer()
+	atomic.AddUint64(&frame.Buffer.version, 2)
+	frame.Buffer.Page = &Page{}
 	frame.Buffer.PageID = pageID
-	frame.Buffer.IsDirty = true
+	frame.Buffer.IsDirty = false
+	frame.Buffer.dirtied = &bpm.pagesDirtied
+	frame.Buffer.lsn.Store(0)
+	frame.Buffer.MarkDirty()
 	frame.UsageCount = 1
+	frame.reserved = false
+	frame.Buffer.Pin()
+	bpm.pool.forget(bufferId)
+	bpm.pool.accessed(bufferId)
 
-	delete(bpm.pageTable, evictPageID)
 	bpm.pageTable[pageID] = bufferId
-
 	return frame.Buffer, nil
 }
 
+// Reservation is a set of buffer frames set aside for one operation, e.g. a B+ tree split,
+// so that it cannot run out of buffers once it has started modifying pages.
+// Reserved frames are never evicted by other operations. Each frame can serve one
+// CreateBuffer or FetchBuffer call of the reservation; unused frames must be released.
+type Reservation struct {
+	bpm    *BufferPoolManager
+	frames []BufferId
+}
+
+// Reserve sets aside n frames, writing back the dirty pages they hold.
+// It returns ErrNoFreeBuffer without reserving anything if fewer than n frames are neither
+// reserved, pinned nor write-latched.
+func (bpm *BufferPoolManager) Reserve(n int) (*Reservation, error) {
+	bpm.mu.Lock()
+	defer bpm.mu.Unlock()
+
+	available := 0
+	for _, frame := range bpm.pool.buffers {
+		frame.mu.RLock()
+		if frame.evictable() {
+			available++
+		}
+		frame.mu.RUnlock()
+	}
+	if available < n {
+		return nil, ErrNoFreeBuffer
+	}
+
+	r := &Reservation{bpm: bpm}
+	for i := 0; i < n; i++ {
+		bufferId, ok := bpm.pool.Evict()
+		if !ok {
+			r.release()
+			return nil, ErrNoFreeBuffer
+		}
+		frame := bpm.pool.buffers[bufferId]
+		frame.mu.Lock()
+		err := bpm.writeBack(frame)
+		if err == nil {
+			atomic.AddUint64(&frame.Buffer.version, 2)
+			frame.Buffer.PageID = disk.InvalidPageID
+			frame.UsageCount = 0
+			frame.reserved = true
+			bpm.pool.forget(bufferId)
+		}
+		frame.mu.Unlock()
+		if err != nil {
+			r.release()
+			return nil, err
+		}
+		r.frames = append(r.frames, bufferId)
+	}
+	return r, nil
+}
+
+// Remaining returns the number of unused frames of the reservation.
+func (r *Reservation) Remaining() int {
+	return len(r.frames)
+}
+
+// CreateBuffer is like BufferPoolManager.CreateBuffer but uses a reserved frame.
+func (r *Reservation) CreateBuffer() (*Buffer, error) {
+	r.bpm.inject(disk.InvalidPageID)
+	r.bpm.mu.Lock()
+	defer r.bpm.mu.Unlock()
+
+	bufferId, ok := r.take()
+	if !ok {
+		return nil, ErrNoFreeBuffer
+	}
+	return r.bpm.newPage(bufferId)
+}
+
+// FetchBuffer is like BufferPoolManager.FetchBuffer but uses a reserved frame if the page is
+// not cached.
+func (r *Reservation) FetchBuffer(pageID disk.PageID) (*Buffer, error) {
+	r.bpm.inject(pageID)
+	start := time.Now()
+	r.bpm.mu.Lock()
+	defer r.bpm.mu.Unlock()
+
+	if bufferId, ok := r.bpm.pageTable[pageID]; ok {
+		frame := r.bpm.pool.buffers[bufferId]
+		frame.mu.Lock()
+		frame.UsageCount++
+		frame.Buffer.Pin()
+		frame.mu.Unlock()
+		r.bpm.pool.accessed(bufferId)
+		r.bpm.fetchHit.Since(start)
+		return frame.Buffer, nil
+	}
+	bufferId, ok := r.take()
+	if !ok {
+		return nil, ErrNoFreeBuffer
+	}
+	defer r.bpm.fetchMiss.Since(start)
+	return r.bpm.loadPage(bufferId, pageID)
+}
+
+// Release returns the unused frames to the pool. It may be called more than once.
+func (r *Reservation) Release() {
+	r.bpm.mu.Lock()
+	defer r.bpm.mu.Unlock()
+	r.release()
+}
+
+func (r *Reservation) take() (BufferId, bool) {
+	if len(r.frames) == 0 {
+		return 0, false
+	}
+	bufferId := r.frames[len(r.frames)-1]
+	r.frames = r.frames[:len(r.frames)-1]
+	return bufferId, true
+}
+
+func (r *Reservation) release() {
+	for _, bufferId := range r.frames {
+		frame := r.bpm.pool.buffers[bufferId]
+		frame.mu.Lock()
+		frame.reserved = false
+		frame.mu.Unlock()
+	}
+	r.frames = nil
+}
+
+// Ring is a bounded set of frames for maintenance I/O such as recovery. Pages the ring
+// reads are loaded into its own frames, which it reuses in turn, so a burst of maintenance
+// reads does not evict the pages cached for queries. Ring frames stay reserved until
+// Release and are never evicted by other operations.
+type Ring struct {
+	bpm    *BufferPoolManager
+	frames []BufferId
+	next   int // Ring frame to reuse next
+}
+
+// NewRing sets aside n frames for a ring. Like Reserve, it returns ErrNoFreeBuffer if fewer
+// than n frames are neither reserved, pinned nor write-latched.
+func (bpm *BufferPoolManager) NewRing(n int) (*Ring, error) {
+	r, err := bpm.Reserve(n)
+	if err != nil {
+		return nil, err
+	}
+	return &Ring{bpm: bpm, frames: r.frames}, nil
+}
+
+// FetchBuffer is like BufferPoolManager.FetchBuffer but loads pages that are not cached into
+// the next frame of the ring, writing back the page it held if it is dirty. It returns
+// ErrNoFreeBuffer if the page held by that frame is still pinned.
+func (r *Ring) FetchBuffer(pageID disk.PageID) (*Buffer, error) {
+	r.bpm.inject(pageID)
+	start := time.Now()
+	r.bpm.mu.Lock()
+	defer r.bpm.mu.Unlock()
+
+	if bufferId, ok := r.bpm.pageTable[pageID]; ok {
+		frame := r.bpm.pool.buffers[bufferId]
+		frame.mu.Lock()
+		frame.UsageCount++
+		frame.Buffer.Pin()
+		frame.mu.Unlock()
+		r.bpm.pool.accessed(bufferId)
+		r.bpm.fetchHit.Since(start)
+		return frame.Buffer, nil
+	}
+	if len(r.frames) == 0 {
+		return nil, ErrNoFreeBuffer
+	}
+	bufferId := r.frames[r.next]
+	if 0 < r.bpm.pool.buffers[bufferId].Buffer.PinCount() {
+		return nil, ErrNoFreeBuffer
+	}
+	r.next = (r.next + 1) % len(r.frames)
+	defer r.bpm.fetchMiss.Since(start)
+	buf, err := r.bpm.loadPage(bufferId, pageID)
+	frame := r.bpm.pool.buffers[bufferId]
+	frame.mu.Lock()
+	frame.reserved = true
+	frame.mu.Unlock()
+	return buf, err
+}
+
+// Release returns the frames of the ring to the pool. The pages they hold stay cached but
+// are the first to be evicted. It may be called more than once.
+func (r *Ring) Release() {
+	r.bpm.mu.Lock()
+	defer r.bpm.mu.Unlock()
+	for _, bufferId := range r.frames {
+		frame := r.bpm.pool.buffers[bufferId]
+		frame.mu.Lock()
+		frame.reserved = false
+		frame.UsageCount = 0
+		frame.mu.Unlock()
+		r.bpm.pool.forget(bufferId)
+	}
+	r.frames = nil
+}
+
+// FreeBuffer drops a page that is no longer referenced from the buffer pool without writing
+// it back and returns it to the disk manager's free list, so that CreateBuffer can reuse it.
+// The caller must ensure that nothing accesses the page anymore.
+func (bpm *BufferPoolManager) FreeBuffer(pageID disk.PageID) {
+	bpm.mu.Lock()
+	defer bpm.mu.Unlock()
+
+	if bufferId, ok := bpm.pageTable[pageID]; ok {
+		frame := bpm.pool.buffers[bufferId]
+		frame.mu.Lock()
+		// Bump the version so optimistic readers of the freed page notice the reuse
+		atomic.AddUint64(&frame.Buffer.version, 2)
+		frame.Buffer.PageID = disk.InvalidPageID
+		frame.Buffer.IsDirty = false
+		frame.UsageCount = 0
+		frame.mu.Unlock()
+		bpm.pool.forget(bufferId)
+		delete(bpm.pageTable, pageID)
+	}
+	bpm.disk.FreePage(pageID)
+}
+
+// ClaimPage marks a page as allocated in the page store, if it supports it (see disk.PageClaimer),
+// so that pages whose allocation Recover redoes are not allocated again.
+func (bpm *BufferPoolManager) ClaimPage(pageID disk.PageID) error {
+	if claimer, ok := bpm.disk.(disk.PageClaimer); ok {
+		return claimer.ClaimPage(pageID)
+	}
+	return nil
+}
+
+// DirtyPages returns the pages not yet written back, with the LSNs of their last logged changes
+// (0 for pages without logged changes).
+func (bpm *BufferPoolManager) DirtyPages() map[disk.PageID]uint64 {
+	bpm.mu.RLock()
+	defer bpm.mu.RUnlock()
+	dirty := make(map[disk.PageID]uint64)
+	for pageID, bufferId := range bpm.pageTable {
+		frame := bpm.pool.buffers[bufferId]
+		frame.mu.RLock()
+		if frame.Buffer.IsDirty {
+			dirty[pageID] = frame.Buffer.LSN()
+		}
+		frame.mu.RUnlock()
+	}
+	return dirty
+}
+
+// NewSequentialWriter returns a writer that streams new pages to the disk manager of the pool
+// without caching them (see disk.SequentialWriter).
+func (bpm *BufferPoolManager) NewSequentialWriter(stagingPages int) *disk.SequentialWriter {
+	return disk.NewSequentialWriter(bpm.disk, stagingPages)
+}
+
 func (bpm *BufferPoolManager) Flush() error {
 	bpm.mu.RLock()
 	defer bpm.mu.RUnlock()
@@ -213,10 +788,15 @@ func (bpm *BufferPoolManager) Flush() error {
 		frame := bpm.pool.buffers[bufferId]
 		frame.mu.RLock()
 		if frame.Buffer.IsDirty {
+			if err := bpm.flushLog(frame.Buffer); err != nil {
+				frame.mu.RUnlock()
+				return err
+			}
 			if err := bpm.disk.WritePageData(pageID, frame.Buffer.Page[:]); err != nil {
 				frame.mu.RUnlock()
 				return err
 			}
+			bpm.pagesWritten.Add(1)
 			frame.Buffer.IsDirty = false
 		}
 		frame.mu.RUnlock()
@@ -224,3 +804,53 @@ func (bpm *BufferPoolManager) Flush() error {
 
 	return bpm.disk.Sync()
 }
+
+// FlushPages writes back those of the pages that are cached and dirty, and syncs the heap file,
+// e.g. so that the undo of a rolled back transaction is durable before the rollback is logged.
+func (bpm *BufferPoolManager) FlushPages(pageIDs []disk.PageID) error {
+	bpm.mu.RLock()
+	defer bpm.mu.RUnlock()
+
+	written := false
+	for _, pageID := range pageIDs {
+		bufferId, ok := bpm.pageTable[pageID]
+		if !ok {
+			continue
+		}
+		frame := bpm.pool.buffers[bufferId]
+		frame.mu.RLock()
+		if frame.Buffer.IsDirty {
+			if err := bpm.flushLog(frame.Buffer); err != nil {
+				frame.mu.RUnlock()
+				return err
+			}
+			if err := bpm.disk.WritePageData(pageID, frame.Buffer.Page[:]); err != nil {
+				frame.mu.RUnlock()
+				return err
+			}
+			bpm.pagesWritten.Add(1)
+			frame.Buffer.IsDirty = false
+			written = true
+		}
+		frame.mu.RUnlock()
+	}
+	if !written {
+		return nil
+	}
+	return bpm.disk.Sync()
+}
+
+// FreezeWrites flushes all dirty pages and then blocks writes to the heap file until ThawWrites is called.
+// Operations that need to write back an evicted dirty page block for the duration of the freeze,
+// and so does any other buffer pool call waiting on them.
+func (bpm *BufferPoolManager) FreezeWrites() error {
+	if err := bpm.Flush(); err != nil {
+		return err
+	}
+	return bpm.disk.Freeze()
+}
+
+// ThawWrites lifts a freeze started by FreezeWrites.
+func (bpm *BufferPoolManager) ThawWrites() {
+	bpm.disk.Thaw()
+}
@@ -4,6 +4,7 @@ import (
 	"os"
 	"reflect"
 	"testing"
+	"time"
 
 	"github.com/Johniel/gorelly/disk"
 )
@@ -36,9 +37,14 @@ func TestBufferPoolManager(t *testing.T) {
 		copy(buffer.Page[:], hello)
 		buffer.IsDirty = true
 		page1Id = buffer.PageID
-		// Note: In Go, we can't easily test that second page creation fails
-		// because the buffer pool evicts pages automatically. This is different
-		// from Rust's behavior where we can check reference counts.
+		// The only frame is pinned, so a second page cannot be created
+		if _, err := bufmgr.CreateBuffer(); err != ErrNoFreeBuffer {
+			t.Errorf("expected ErrNoFreeBuffer while pinned, got %v", err)
+		}
+		if stats := bufmgr.PoolStats(); stats.Pinned != 1 {
+			t.Errorf("expected 1 pinned frame, got %d", stats.Pinned)
+		}
+		buffer.Unpin()
 	}
 
 	{
@@ -49,6 +55,7 @@ func TestBufferPoolManager(t *testing.T) {
 		if !reflect.DeepEqual(hello, buffer.Page[:]) {
 			t.Errorf("page1: expected %v, got %v", hello, buffer.Page[:])
 		}
+		buffer.Unpin()
 	}
 
 	world := make([]byte, disk.PageSize)
@@ -63,6 +70,7 @@ func TestBufferPoolManager(t *testing.T) {
 		copy(buffer.Page[:], world)
 		buffer.IsDirty = true
 		page2Id = buffer.PageID
+		buffer.Unpin()
 	}
 
 	{
@@ -73,6 +81,7 @@ func TestBufferPoolManager(t *testing.T) {
 		if !reflect.DeepEqual(hello, buffer.Page[:]) {
 			t.Errorf("page1 after eviction: expected %v, got %v", hello, buffer.Page[:])
 		}
+		buffer.Unpin()
 	}
 
 	{
@@ -85,3 +94,608 @@ func TestBufferPoolManager(t *testing.T) {
 		}
 	}
 }
+
+func TestBufferDerive(t *testing.T) {
+	tmpfile, err := os.CreateTemp("", "test_buffer_derive_*.db")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(tmpfile.Name())
+	dm, err := disk.NewDiskManager(tmpfile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer dm.Close()
+	bufmgr := NewBufferPoolManager(dm, NewBufferPool(1))
+
+	computed := 0
+	derive := func(buf *Buffer) any {
+		return buf.Derive(func() any {
+			computed++
+			return buf.Page[0]
+		})
+	}
+	buf, err := bufmgr.CreateBuffer()
+	if err != nil {
+		t.Fatal(err)
+	}
+	pageID := buf.PageID
+	buf.Page[0] = 1
+	buf.MarkDirty()
+	if v := derive(buf); v != byte(1) || derive(buf) != byte(1) || computed != 1 {
+		t.Errorf("expected the value to be computed once, got %v after %d computations", v, computed)
+	}
+	buf.Page[0] = 2
+	buf.MarkDirty()
+	if v := derive(buf); v != byte(2) || computed != 2 {
+		t.Errorf("expected MarkDirty to drop the value, got %v after %d computations", v, computed)
+	}
+
+	// Modified under the write latch
+	buf.WriteLatch()
+	buf.Page[0] = 3
+	if v := derive(buf); v != byte(3) || computed != 3 {
+		t.Errorf("expected the value to be computed while latched, got %v", v)
+	}
+	buf.WriteUnlatch(true)
+	if v := derive(buf); v != byte(3) || computed != 4 {
+		t.Errorf("expected the value computed while latched not to be kept, got %v after %d computations", v, computed)
+	}
+	buf.WriteLatch()
+	buf.WriteUnlatch(false)
+	if derive(buf); computed != 4 {
+		t.Errorf("expected an unmodified page to keep its value, got %d computations", computed)
+	}
+	buf.Unpin()
+
+	// The frame is reused for another page
+	other, err := bufmgr.CreateBuffer()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v := derive(other); v != byte(0) {
+		t.Errorf("expected the value of the new page, got %v", v)
+	}
+	other.Unpin()
+	buf, err = bufmgr.FetchBuffer(pageID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer buf.Unpin()
+	if v := derive(buf); v != byte(3) || computed != 6 {
+		t.Errorf("expected the value to be recomputed after the reload, got %v after %d computations", v, computed)
+	}
+}
+
+func TestEvictionInjector(t *testing.T) {
+	tmpfile, err := os.CreateTemp("", "test_buffer_inject_*.db")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(tmpfile.Name())
+	dm, err := disk.NewDiskManager(tmpfile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer dm.Close()
+	bufmgr := NewBufferPoolManager(dm, NewBufferPool(4))
+
+	var pageIDs []disk.PageID
+	for i := 0; i < 3; i++ {
+		buf, err := bufmgr.CreateBuffer()
+		if err != nil {
+			t.Fatal(err)
+		}
+		buf.Page[0] = byte('a' + i)
+		pageIDs = append(pageIDs, buf.PageID)
+		buf.Unpin()
+	}
+	pinned, err := bufmgr.FetchBuffer(pageIDs[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if evicted, err := bufmgr.EvictPage(pageIDs[0]); err != nil || evicted {
+		t.Errorf("expected a pinned page not to be evicted, got %v, %v", evicted, err)
+	}
+	pinned.Unpin()
+
+	// The fetches of the scripted steps are counted from the installation of the injector
+	bufmgr.SetEvictionInjector(EvictBefore(2, pageIDs[1]))
+	for i, pageID := range []disk.PageID{pageIDs[2], pageIDs[1]} {
+		buf, cached, err := bufmgr.FetchBufferCached(pageID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if cached != (i == 0) || buf.Page[0] != byte('a'+2-i) {
+			t.Errorf("fetch %d: expected cached %v and the written back page, got %v, %q", i+1, i == 0, cached, buf.Page[0])
+		}
+		buf.Unpin()
+	}
+	if stats := bufmgr.IOStats(); stats.PagesWritten != 1 {
+		t.Errorf("expected the dirty page to be written back once, got %d", stats.PagesWritten)
+	}
+
+	// Every page but the one being fetched leaves the cache
+	bufmgr.SetEvictionInjector(EvictOnFetch(pageIDs[0]))
+	buf, err := bufmgr.FetchBuffer(pageIDs[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	buf.Unpin()
+	if stats := bufmgr.PoolStats(); stats.Cached != 1 {
+		t.Errorf("expected a single cached page, got %d", stats.Cached)
+	}
+	bufmgr.SetEvictionInjector(nil)
+	if n, err := bufmgr.EvictAll(); err != nil || n != 1 {
+		t.Errorf("expected the last page to be evicted, got %d, %v", n, err)
+	}
+}
+
+func TestBufferPoolManagerFreezeWrites(t *testing.T) {
+	tmpfile, err := os.CreateTemp("", "test_buffer_freeze_*.db")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(tmpfile.Name())
+
+	dm, err := disk.NewDiskManager(tmpfile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer dm.Close()
+
+	pool := NewBufferPool(1)
+	bufmgr := NewBufferPoolManager(dm, pool)
+
+	buffer, err := bufmgr.CreateBuffer()
+	if err != nil {
+		t.Fatal(err)
+	}
+	copy(buffer.Page[:], []byte("hello"))
+	pageID := buffer.PageID
+	buffer.Unpin()
+
+	if err := bufmgr.FreezeWrites(); err != nil {
+		t.Fatal(err)
+	}
+
+	// The dirty page must have been flushed before the freeze
+	onDisk := make([]byte, disk.PageSize)
+	if err := dm.ReadPageData(pageID, onDisk); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(onDisk[:5], []byte("hello")) {
+		t.Errorf("expected flushed page, got %v", onDisk[:5])
+	}
+
+	// Creating another page evicts the only frame, which must wait for the thaw
+	buffer.IsDirty = true
+	done := make(chan error)
+	go func() {
+		_, err := bufmgr.CreateBuffer()
+		done <- err
+	}()
+	select {
+	case <-done:
+		t.Fatal("eviction wrote to disk while frozen")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	bufmgr.ThawWrites()
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestBufferPoolManagerIOStats(t *testing.T) {
+	tmpfile, err := os.CreateTemp("", "test_buffer_io_stats_*.db")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(tmpfile.Name())
+
+	dm, err := disk.NewDiskManager(tmpfile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer dm.Close()
+
+	bufmgr := NewBufferPoolManager(dm, NewBufferPool(1))
+
+	buf1, err := bufmgr.CreateBuffer()
+	if err != nil {
+		t.Fatal(err)
+	}
+	page1ID := buf1.PageID
+	buf1.MarkDirty() // Already dirty: not counted again
+	buf1.Unpin()
+
+	// Creating a second page evicts and writes back the first one
+	buf2, err := bufmgr.CreateBuffer()
+	if err != nil {
+		t.Fatal(err)
+	}
+	buf2.Unpin()
+	before := bufmgr.IOStats()
+	if expected := (IOStats{PagesDirtied: 2, PagesWritten: 1}); before != expected {
+		t.Errorf("expected %+v, got %+v", expected, before)
+	}
+
+	buf1, err = bufmgr.FetchBuffer(page1ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	buf1.MarkDirty()
+	buf1.Unpin()
+	if err := bufmgr.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	delta := bufmgr.IOStats().Sub(before)
+	if expected := (IOStats{PagesRead: 1, PagesDirtied: 1, PagesWritten: 2}); delta != expected {
+		t.Errorf("expected %+v, got %+v", expected, delta)
+	}
+
+	// The fetch above missed; fetching the cached page again hits
+	if _, err := bufmgr.FetchBuffer(page1ID); err != nil {
+		t.Fatal(err)
+	}
+	latency := bufmgr.FetchLatency()
+	if latency.Hit.Count != 1 || latency.Miss.Count != 1 {
+		t.Errorf("expected 1 hit and 1 miss, got %d and %d", latency.Hit.Count, latency.Miss.Count)
+	}
+}
+
+func TestBufferPoolManagerSequentialWriter(t *testing.T) {
+	tmpfile, err := os.CreateTemp("", "test_buffer_seqwriter_*.db")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(tmpfile.Name())
+
+	dm, err := disk.NewDiskManager(tmpfile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer dm.Close()
+
+	pool := NewBufferPool(2)
+	bufmgr := NewBufferPoolManager(dm, pool)
+	hot, err := bufmgr.CreateBuffer()
+	if err != nil {
+		t.Fatal(err)
+	}
+	copy(hot.Page[:], "hot")
+	hot.Unpin()
+
+	sw := bufmgr.NewSequentialWriter(4)
+	var pageIDs []disk.PageID
+	for i := 0; i < 10; i++ {
+		pageID, err := sw.WritePage([]byte{byte(i)})
+		if err != nil {
+			t.Fatal(err)
+		}
+		pageIDs = append(pageIDs, pageID)
+	}
+	if err := sw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if stats := bufmgr.IOStats(); stats.PagesRead != 0 || stats.PagesWritten != 0 {
+		t.Errorf("expected the writer to bypass the pool, got %+v", stats)
+	}
+
+	// The hot page was not evicted
+	buf, err := bufmgr.FetchBuffer(hot.PageID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(buf.Page[:3]) != "hot" || bufmgr.IOStats().PagesRead != 0 {
+		t.Error("expected the hot page to stay cached")
+	}
+	buf.Unpin()
+
+	buf, err = bufmgr.FetchBuffer(pageIDs[7])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if buf.Page[0] != 7 {
+		t.Errorf("expected page content 7, got %d", buf.Page[0])
+	}
+}
+
+func TestBufferPoolManagerTablespace(t *testing.T) {
+	ts, err := disk.OpenTablespaceWithFilePages(t.TempDir(), 4)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ts.Close()
+
+	// Pages are evicted to and read back from several heap files
+	pool := NewBufferPool(2)
+	bufmgr := NewBufferPoolManager(ts, pool)
+	var pageIDs []disk.PageID
+	for i := 0; i < 10; i++ {
+		buf, err := bufmgr.CreateBuffer()
+		if err != nil {
+			t.Fatal(err)
+		}
+		buf.Page[0] = byte(i)
+		buf.MarkDirty()
+		pageIDs = append(pageIDs, buf.PageID)
+		buf.Unpin()
+	}
+	if err := bufmgr.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	if ts.NumFiles() != 3 {
+		t.Errorf("expected 3 heap files, got %d", ts.NumFiles())
+	}
+	for i, pageID := range pageIDs {
+		buf, err := bufmgr.FetchBuffer(pageID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if buf.Page[0] != byte(i) {
+			t.Errorf("page %d: expected content %d, got %d", pageID, i, buf.Page[0])
+		}
+		buf.Unpin()
+	}
+}
+
+func TestBufferPoolManagerReserve(t *testing.T) {
+	tmpfile, err := os.CreateTemp("", "test_buffer_reserve_*.db")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(tmpfile.Name())
+
+	dm, err := disk.NewDiskManager(tmpfile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer dm.Close()
+
+	pool := NewBufferPool(4)
+	bufmgr := NewBufferPoolManager(dm, pool)
+
+	dirty, err := bufmgr.CreateBuffer()
+	if err != nil {
+		t.Fatal(err)
+	}
+	copy(dirty.Page[:], "dirty")
+	dirtyPageID := dirty.PageID
+	dirty.Unpin()
+	latched, err := bufmgr.CreateBuffer()
+	if err != nil {
+		t.Fatal(err)
+	}
+	latched.WriteLatch()
+	latched.Unpin()
+
+	if _, err := bufmgr.Reserve(4); err != ErrNoFreeBuffer {
+		t.Errorf("expected ErrNoFreeBuffer with a latched buffer, got %v", err)
+	}
+	reservation, err := bufmgr.Reserve(3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Only the latched buffer is left, and it cannot be evicted
+	if _, err := bufmgr.CreateBuffer(); err != ErrNoFreeBuffer {
+		t.Errorf("expected ErrNoFreeBuffer, got %v", err)
+	}
+
+	// The reserved frames serve the reservation; the dirty page was written back
+	buf, err := reservation.FetchBuffer(dirtyPageID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(buf.Page[:5]) != "dirty" {
+		t.Errorf("expected the dirty page to be written back, got %q", buf.Page[:5])
+	}
+	buf.Unpin()
+	created, err := reservation.CreateBuffer()
+	if err != nil {
+		t.Fatal(err)
+	}
+	created.Unpin()
+	if reservation.Remaining() != 1 {
+		t.Errorf("expected 1 remaining frame, got %d", reservation.Remaining())
+	}
+	reservation.Release()
+	latched.WriteUnlatch(false)
+
+	if _, err := bufmgr.Reserve(4); err != nil {
+		t.Errorf("expected every frame to be reservable again, got %v", err)
+	}
+}
+
+func TestBufferPoolManagerRing(t *testing.T) {
+	tmpfile, err := os.CreateTemp("", "test_buffer_ring_*.db")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(tmpfile.Name())
+
+	dm, err := disk.NewDiskManager(tmpfile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer dm.Close()
+
+	pool := NewBufferPool(4)
+	bufmgr := NewBufferPoolManager(dm, pool)
+
+	// Six pages on disk, of which the first two are cached for queries
+	var pageIDs []disk.PageID
+	for i := 0; i < 6; i++ {
+		buf, err := bufmgr.CreateBuffer()
+		if err != nil {
+			t.Fatal(err)
+		}
+		pageIDs = append(pageIDs, buf.PageID)
+		buf.Unpin()
+	}
+	if err := bufmgr.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	for _, pageID := range pageIDs[:2] {
+		buf, err := bufmgr.FetchBuffer(pageID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		buf.Unpin()
+	}
+
+	if _, err := bufmgr.NewRing(5); err != ErrNoFreeBuffer {
+		t.Errorf("expected ErrNoFreeBuffer for a ring larger than the pool, got %v", err)
+	}
+	ring, err := bufmgr.NewRing(2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Modify every page not cached for queries through the ring, twice over
+	for round := 0; round < 2; round++ {
+		for i, pageID := range pageIDs[2:] {
+			buf, err := ring.FetchBuffer(pageID)
+			if err != nil {
+				t.Fatal(err)
+			}
+			buf.Page[0] = byte(i + 1)
+			buf.MarkDirty()
+			buf.Unpin()
+		}
+	}
+	if stats := bufmgr.PoolStats(); stats.Reserved != 2 {
+		t.Errorf("expected the ring to keep 2 frames reserved, got %d", stats.Reserved)
+	}
+
+	// The pages cached for queries survived the ring's reads
+	before := bufmgr.IOStats()
+	for _, pageID := range pageIDs[:2] {
+		buf, err := bufmgr.FetchBuffer(pageID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		buf.Unpin()
+	}
+	if read := bufmgr.IOStats().Sub(before).PagesRead; read != 0 {
+		t.Errorf("expected the query pages to stay cached, but %d pages were read", read)
+	}
+
+	// Pages pushed out of the ring were written back
+	ring.Release()
+	ring.Release()
+	if err := bufmgr.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	for i, pageID := range pageIDs[2:] {
+		page := make([]byte, disk.PageSize)
+		if err := dm.ReadPageData(pageID, page); err != nil {
+			t.Fatal(err)
+		}
+		if page[0] != byte(i+1) {
+			t.Errorf("page %d: expected %d, got %d", pageID, i+1, page[0])
+		}
+	}
+	if stats := bufmgr.PoolStats(); stats.Reserved != 0 {
+		t.Errorf("expected no reserved frames after Release, got %d", stats.Reserved)
+	}
+}
+
+type recordingWAL struct {
+	flushed []uint64
+}
+
+func (w *recordingWAL) FlushTo(lsn uint64) error {
+	w.flushed = append(w.flushed, lsn)
+	return nil
+}
+
+func TestBufferPoolManagerFlushPages(t *testing.T) {
+	tmpfile, err := os.CreateTemp("", "test_buffer_flush_pages_*.db")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(tmpfile.Name())
+
+	dm, err := disk.NewDiskManager(tmpfile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer dm.Close()
+
+	wal := &recordingWAL{}
+	bufmgr := NewBufferPoolManager(dm, NewBufferPool(4))
+	bufmgr.SetWAL(wal)
+	var pageIDs []disk.PageID
+	for i := 0; i < 3; i++ {
+		buf, err := bufmgr.CreateBuffer()
+		if err != nil {
+			t.Fatal(err)
+		}
+		buf.Page[0] = byte(i + 1)
+		buf.SetLSN(uint64(i + 1))
+		buf.Unpin()
+		pageIDs = append(pageIDs, buf.PageID)
+	}
+
+	// Only the given pages are written back, after the log; uncached pages are skipped
+	if err := bufmgr.FlushPages([]disk.PageID{pageIDs[0], pageIDs[2], 100}); err != nil {
+		t.Fatal(err)
+	}
+	dirty := bufmgr.DirtyPages()
+	if _, ok := dirty[pageIDs[1]]; len(dirty) != 1 || !ok {
+		t.Errorf("expected only page %d to stay dirty, got %v", pageIDs[1], dirty)
+	}
+	if len(wal.flushed) != 2 || wal.flushed[0] != 1 || wal.flushed[1] != 3 {
+		t.Errorf("expected the log to be flushed to LSNs 1 and 3, got %v", wal.flushed)
+	}
+	page := make([]byte, disk.PageSize)
+	if err := dm.ReadPageData(pageIDs[2], page); err != nil {
+		t.Fatal(err)
+	}
+	if page[0] != 3 {
+		t.Errorf("expected page %d to be written back, got %d", pageIDs[2], page[0])
+	}
+	if stats := bufmgr.IOStats(); stats.PagesWritten != 2 {
+		t.Errorf("expected 2 pages written, got %d", stats.PagesWritten)
+	}
+}
+
+func TestWriteAheadRule(t *testing.T) {
+	tmpfile, err := os.CreateTemp("", "test_write_ahead_rule_*.db")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(tmpfile.Name())
+
+	dm, err := disk.NewDiskManager(tmpfile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer dm.Close()
+
+	wal := &recordingWAL{}
+	bufmgr := NewBufferPoolManager(dm, NewBufferPool(1))
+	bufmgr.SetWAL(wal)
+
+	logged, err := bufmgr.CreateBuffer()
+	if err != nil {
+		t.Fatal(err)
+	}
+	logged.SetLSN(7)
+	logged.SetLSN(5) // LSNs never go back
+	logged.Unpin()
+
+	// Evicting the logged page flushes the log first; pages without logged changes do not
+	unlogged, err := bufmgr.CreateBuffer()
+	if err != nil {
+		t.Fatal(err)
+	}
+	unlogged.Unpin()
+	if err := bufmgr.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	if len(wal.flushed) != 1 || wal.flushed[0] != 7 {
+		t.Errorf("expected the log to be flushed to LSN 7 once, got %v", wal.flushed)
+	}
+}
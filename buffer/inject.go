@@ -0,0 +1,130 @@
+package buffer
+
+import (
+	"fmt"
+	"sync/atomic"
+
+	"github.com/Johniel/gorelly/disk"
+)
+
+// EvictionInjector forces pages out of the buffer pool at chosen points of an operation, so that
+// tests reproduce bugs that only appear when a page leaves the cache mid-operation (an iterator
+// moving to an evicted leaf, a split whose sibling was written back, recovery re-reading a page)
+// deterministically, instead of relying on a pool small enough to evict them by chance.
+//
+// It is called before every page the buffer pool manager fetches or creates, including through
+// a Reservation or a Ring, with the number of the call (counting from 1 since the injector was
+// set) and the ID of the page (disk.InvalidPageID for a new page). It evicts pages with
+// EvictPage or EvictAll, which it may call as no lock of the manager is held.
+// Injectors are meant for tests; they slow down every fetch.
+type EvictionInjector func(bpm *BufferPoolManager, call uint64, pageID disk.PageID)
+
+// EvictBefore returns an injector evicting pages right before the n-th fetch or creation of a page.
+// Without pageIDs, it evicts every page that can be evicted (see EvictAll).
+// Like the other injectors below, it panics if a dirty page cannot be written back.
+func EvictBefore(n uint64, pageIDs ...disk.PageID) EvictionInjector {
+	return func(bpm *BufferPoolManager, call uint64, _ disk.PageID) {
+		if call == n {
+			mustEvict(bpm, pageIDs)
+		}
+	}
+}
+
+// EvictOnFetch returns an injector evicting pages every time target is about to be fetched,
+// e.g. the leaf an iterator moves to next. Without pageIDs, it evicts every page that can be.
+func EvictOnFetch(target disk.PageID, pageIDs ...disk.PageID) EvictionInjector {
+	return func(bpm *BufferPoolManager, _ uint64, pageID disk.PageID) {
+		if pageID == target {
+			mustEvict(bpm, pageIDs)
+		}
+	}
+}
+
+// mustEvict evicts the pages, or every page that can be evicted if pageIDs is empty.
+func mustEvict(bpm *BufferPoolManager, pageIDs []disk.PageID) {
+	var err error
+	if len(pageIDs) == 0 {
+		_, err = bpm.EvictAll()
+	}
+	for _, pageID := range pageIDs {
+		if _, err = bpm.EvictPage(pageID); err != nil {
+			break
+		}
+	}
+	if err != nil {
+		panic(fmt.Sprintf("buffer: injected eviction failed: %v", err))
+	}
+}
+
+// injection holds the eviction injector of a manager and the number of times it was called.
+type injection struct {
+	injector EvictionInjector
+	calls    atomic.Uint64
+}
+
+// SetEvictionInjector installs an eviction injector; nil removes it.
+func (bpm *BufferPoolManager) SetEvictionInjector(injector EvictionInjector) {
+	if injector == nil {
+		bpm.injection.Store(nil)
+		return
+	}
+	bpm.injection.Store(&injection{injector: injector})
+}
+
+// inject calls the eviction injector, if any, before pageID is fetched or created.
+// The caller must not hold bpm.mu.
+func (bpm *BufferPoolManager) inject(pageID disk.PageID) {
+	if inj := bpm.injection.Load(); inj != nil {
+		inj.injector(bpm, inj.calls.Add(1), pageID)
+	}
+}
+
+// EvictPage writes a cached page back if it is dirty and drops it from the buffer pool, as if
+// its frame had been picked for eviction. It reports false if the page is not cached or cannot
+// be evicted because it is pinned, write-latched or in a reserved frame.
+func (bpm *BufferPoolManager) EvictPage(pageID disk.PageID) (bool, error) {
+	bpm.mu.Lock()
+	defer bpm.mu.Unlock()
+	bufferId, ok := bpm.pageTable[pageID]
+	if !ok {
+		return false, nil
+	}
+	return bpm.evictFrame(bufferId)
+}
+
+// EvictAll evicts every cached page that can be evicted (see EvictPage) and returns their number.
+func (bpm *BufferPoolManager) EvictAll() (int, error) {
+	bpm.mu.Lock()
+	defer bpm.mu.Unlock()
+	n := 0
+	for _, bufferId := range bpm.pageTable {
+		evicted, err := bpm.evictFrame(bufferId)
+		if err != nil {
+			return n, err
+		}
+		if evicted {
+			n++
+		}
+	}
+	return n, nil
+}
+
+// evictFrame writes back and drops the page of a frame if it can be evicted.
+// The caller must hold bpm.mu.
+func (bpm *BufferPoolManager) evictFrame(bufferId BufferId) (bool, error) {
+	frame := bpm.pool.buffers[bufferId]
+	frame.mu.Lock()
+	defer frame.mu.Unlock()
+	if !frame.evictable() {
+		return false, nil
+	}
+	if err := bpm.writeBack(frame); err != nil {
+		return false, err
+	}
+	// Bump the version so optimistic readers of the evicted page notice it is gone
+	atomic.AddUint64(&frame.Buffer.version, 2)
+	frame.Buffer.PageID = disk.InvalidPageID
+	frame.UsageCount = 0
+	bpm.pool.forget(bufferId)
+	return true, nil
+}
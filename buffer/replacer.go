@@ -0,0 +1,79 @@
+package buffer
+
+import (
+	"sync"
+)
+
+// Replacer is a replacement policy of a BufferPool: it decides which frame to reuse when a page
+// has to be loaded into a full pool (see NewBufferPoolWithReplacer).
+type Replacer interface {
+	// RecordAccess notes that the page held by the frame was fetched or created.
+	RecordAccess(id BufferId)
+	// Remove forgets the accesses of a frame whose page was dropped or handed back,
+	// making the frame one of the first to be reused.
+	Remove(id BufferId)
+	// Victim picks the frame to reuse among candidates, which is never empty.
+	// Candidates are neither pinned, write-latched nor reserved.
+	Victim(candidates []BufferId) BufferId
+}
+
+// LRUKReplacer is the LRU-K replacement policy. It evicts the frame whose K-th most recent
+// access is the oldest, and frames accessed fewer than K times before any other.
+// A page read once by a scan is therefore evicted before a page that is used repeatedly,
+// even if the scan read it more recently, which keeps hot index pages cached during scans.
+type LRUKReplacer struct {
+	k       int
+	now     uint64                // Logical clock, advanced by every access
+	history map[BufferId][]uint64 // Times of the last (up to) K accesses of each frame, oldest first
+	mu      sync.Mutex
+}
+
+// NewLRUKReplacer returns an LRU-K replacer. K = 2 is the usual choice; K = 1 is plain LRU.
+func NewLRUKReplacer(k int) *LRUKReplacer {
+	if k < 1 {
+		k = 1
+	}
+	return &LRUKReplacer{k: k, history: make(map[BufferId][]uint64)}
+}
+
+func (r *LRUKReplacer) RecordAccess(id BufferId) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.now++
+	h := append(r.history[id], r.now)
+	if r.k < len(h) {
+		h = h[len(h)-r.k:]
+	}
+	r.history[id] = h
+}
+
+func (r *LRUKReplacer) Remove(id BufferId) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	delete(r.history, id)
+}
+
+func (r *LRUKReplacer) Victim(candidates []BufferId) BufferId {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	victim := candidates[0]
+	for _, id := range candidates[1:] {
+		if r.before(id, victim) {
+			victim = id
+		}
+	}
+	return victim
+}
+
+// before reports whether frame a is to be evicted before frame b. Frames without accesses come
+// first, then frames with fewer than K accesses, each group ordered by its oldest kept access.
+func (r *LRUKReplacer) before(a BufferId, b BufferId) bool {
+	ha, hb := r.history[a], r.history[b]
+	if len(ha) == 0 || len(hb) == 0 {
+		return len(ha) == 0 && len(hb) != 0
+	}
+	if fullA, fullB := len(ha) == r.k, len(hb) == r.k; fullA != fullB {
+		return fullB
+	}
+	return ha[0] < hb[0]
+}
@@ -0,0 +1,75 @@
+package buffer
+
+import (
+	"os"
+	"testing"
+
+	"github.com/Johniel/gorelly/disk"
+)
+
+func TestLRUKReplacerVictim(t *testing.T) {
+	r := NewLRUKReplacer(2)
+	for _, id := range []BufferId{0, 1, 0, 2, 1, 2, 3} {
+		r.RecordAccess(id)
+	}
+	// 3 was accessed only once, so it goes first despite being the most recent
+	if victim := r.Victim([]BufferId{0, 1, 2, 3}); victim != 3 {
+		t.Errorf("expected frame 3, got %d", victim)
+	}
+	// Among frames accessed twice, 0 has the oldest second most recent access
+	if victim := r.Victim([]BufferId{0, 1, 2}); victim != 0 {
+		t.Errorf("expected frame 0, got %d", victim)
+	}
+	// A frame without accesses goes before any other
+	r.Remove(2)
+	if victim := r.Victim([]BufferId{0, 1, 2, 3}); victim != 2 {
+		t.Errorf("expected frame 2, got %d", victim)
+	}
+}
+
+func TestBufferPoolLRUK(t *testing.T) {
+	tmpfile, err := os.CreateTemp("", "test_buffer_lruk_*.db")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(tmpfile.Name())
+
+	dm, err := disk.NewDiskManager(tmpfile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer dm.Close()
+
+	bufmgr := NewBufferPoolManager(dm, NewBufferPoolWithReplacer(3, NewLRUKReplacer(2)))
+
+	var pageIDs []disk.PageID
+	for i := 0; i < 10; i++ {
+		buf, err := bufmgr.CreateBuffer()
+		if err != nil {
+			t.Fatal(err)
+		}
+		pageIDs = append(pageIDs, buf.PageID)
+		buf.Unpin()
+	}
+	fetch := func(pageID disk.PageID) {
+		buf, err := bufmgr.FetchBuffer(pageID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		buf.Unpin()
+	}
+
+	// The hot page is used repeatedly, then a scan reads every other page once
+	hot := pageIDs[0]
+	fetch(hot)
+	fetch(hot)
+	for _, pageID := range pageIDs[1:] {
+		fetch(pageID)
+	}
+
+	before := bufmgr.IOStats()
+	fetch(hot)
+	if read := bufmgr.IOStats().Sub(before).PagesRead; read != 0 {
+		t.Errorf("expected the hot page to survive the scan, but it was read again")
+	}
+}
@@ -0,0 +1,198 @@
+package catalog
+
+import (
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// WorkloadQuery describes the executions of one query shape against a table.
+type WorkloadQuery struct {
+	Table           string   // Table the query reads
+	EqualityColumns []string // Columns compared with = in the predicate
+	RangeColumns    []string // Columns compared with <, <=, > or >= in the predicate
+	Projected       []string // Columns the query returns
+	Count           uint64   // Number of executions
+	RowsScanned     uint64   // Rows read over all executions
+	RowsReturned    uint64   // Rows returned over all executions
+}
+
+// Workload records query shapes and how much they read, as the input of AdviseIndexes.
+// Executions of the same shape are merged. A Workload is safe for concurrent use.
+type Workload struct {
+	mu      sync.Mutex
+	queries map[string]*WorkloadQuery
+}
+
+func NewWorkload() *Workload {
+	return &Workload{queries: make(map[string]*WorkloadQuery)}
+}
+
+// Record adds executions of a query shape to the workload.
+func (w *Workload) Record(q WorkloadQuery) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	key := strings.Join([]string{
+		q.Table,
+		strings.Join(q.EqualityColumns, ","),
+		strings.Join(q.RangeColumns, ","),
+		strings.Join(q.Projected, ","),
+	}, "|")
+	recorded, ok := w.queries[key]
+	if !ok {
+		recorded = &WorkloadQuery{
+			Table:           q.Table,
+			EqualityColumns: slices.Clone(q.EqualityColumns),
+			RangeColumns:    slices.Clone(q.RangeColumns),
+			Projected:       slices.Clone(q.Projected),
+		}
+		w.queries[key] = recorded
+	}
+	recorded.Count += q.Count
+	recorded.RowsScanned += q.RowsScanned
+	recorded.RowsReturned += q.RowsReturned
+}
+
+// Queries returns the recorded query shapes.
+func (w *Workload) Queries() []WorkloadQuery {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	queries := make([]WorkloadQuery, 0, len(w.queries))
+	for _, q := range w.queries {
+		queries = append(queries, *q)
+	}
+	return queries
+}
+
+// IndexSuggestion is a missing index proposed by AdviseIndexes.
+type IndexSuggestion struct {
+	Table   string   // Table to index
+	Columns []string // Key columns in order: equality columns, then at most one range column
+	Include []string // Projected columns to store in the index so it covers the queries
+	Benefit uint64   // Estimated rows no longer read: rows scanned minus rows returned
+	Queries int      // Number of recorded query shapes the index serves
+}
+
+// AdviseIndexes suggests indexes for the query shapes of the workload that no existing index
+// (including the primary key) can serve, ordered by estimated benefit.
+// An index serves a query when its leading columns are the query's equality columns,
+// or its first column is the query's range column if there are no equality columns.
+func (cm *CatalogManager) AdviseIndexes(w *Workload) ([]IndexSuggestion, error) {
+	for _, q := range w.Queries() {
+		if err := cm.loadSchema(q.Table); err != nil {
+			return nil, err
+		}
+	}
+	cm.mu.RLock()
+	defer cm.mu.RUnlock()
+
+	suggestions := make(map[string]*IndexSuggestion)
+	for _, q := range w.Queries() {
+		schema, ok := cm.schemaCache[canonicalTableName(q.Table)]
+		if !ok {
+			return nil, ErrTableNotFound
+		}
+		for _, names := range [][]string{q.EqualityColumns, q.RangeColumns, q.Projected} {
+			for _, name := range names {
+				if schema.columnIndex(name) < 0 {
+					return nil, ErrColumnNotFound
+				}
+			}
+		}
+		if len(q.EqualityColumns) == 0 && len(q.RangeColumns) == 0 {
+			continue
+		}
+		if q.RowsScanned <= q.RowsReturned || schema.served(q) {
+			continue
+		}
+
+		columns := slices.Clone(q.EqualityColumns)
+		if 0 < len(q.RangeColumns) {
+			columns = append(columns, q.RangeColumns[0])
+		}
+		key := q.Table + "|" + strings.Join(columns, ",")
+		suggestion, ok := suggestions[key]
+		if !ok {
+			suggestion = &IndexSuggestion{Table: q.Table, Columns: columns}
+			suggestions[key] = suggestion
+		}
+		for _, name := range q.Projected {
+			if !slices.Contains(columns, name) && !slices.Contains(suggestion.Include, name) && !schema.isPrimaryKey(name) {
+				suggestion.Include = append(suggestion.Include, name)
+			}
+		}
+		suggestion.Benefit += q.RowsScanned - q.RowsReturned
+		suggestion.Queries++
+	}
+
+	result := make([]IndexSuggestion, 0, len(suggestions))
+	for _, suggestion := range suggestions {
+		result = append(result, *suggestion)
+	}
+	sort.Slice(result, func(i, j int) bool {
+		if result[i].Benefit != result[j].Benefit {
+			return result[i].Benefit > result[j].Benefit
+		}
+		if result[i].Table != result[j].Table {
+			return result[i].Table < result[j].Table
+		}
+		return strings.Join(result[i].Columns, ",") < strings.Join(result[j].Columns, ",")
+	})
+	return result, nil
+}
+
+func (ts *TableSchema) columnIndex(name string) int {
+	for i, col := range ts.Columns {
+		if col.Name == name && !col.Dropped {
+			return i
+		}
+	}
+	return -1
+}
+
+func (ts *TableSchema) isPrimaryKey(name string) bool {
+	i := ts.columnIndex(name)
+	return 0 <= i && ts.Columns[i].IsPrimaryKey
+}
+
+// served reports whether the primary key or an index of the table can serve the query.
+func (ts *TableSchema) served(q WorkloadQuery) bool {
+	var primaryKey []string
+	for _, col := range ts.Columns {
+		if col.IsPrimaryKey {
+			primaryKey = append(primaryKey, col.Name)
+		}
+	}
+	candidates := [][]string{primaryKey}
+	for _, index := range ts.Indexes {
+		var columns []string
+		for _, i := range index.ColumnIndices {
+			if 0 <= i && i < len(ts.Columns) {
+				columns = append(columns, ts.Columns[i].Name)
+			}
+		}
+		candidates = append(candidates, columns)
+	}
+
+	for _, columns := range candidates {
+		if 0 < len(q.EqualityColumns) {
+			if len(q.EqualityColumns) <= len(columns) && sameColumns(columns[:len(q.EqualityColumns)], q.EqualityColumns) {
+				return true
+			}
+		} else if 0 < len(columns) && slices.Contains(q.RangeColumns, columns[0]) {
+			return true
+		}
+	}
+	return false
+}
+
+func sameColumns(a []string, b []string) bool {
+	a = slices.Clone(a)
+	b = slices.Clone(b)
+	slices.Sort(a)
+	slices.Sort(b)
+	return slices.Equal(a, b)
+}
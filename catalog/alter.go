@@ -0,0 +1,95 @@
+package catalog
+
+import (
+	"fmt"
+
+	"github.com/Johniel/gorelly/errcode"
+)
+
+var (
+	// ErrColumnExists is returned when adding a column whose name is already used by the table.
+	ErrColumnExists = errcode.New(errcode.AlreadyExists, "column already exists")
+	// ErrInvalidColumn is returned when adding a column that the rows already stored cannot have.
+	ErrInvalidColumn = errcode.New(errcode.InvalidParameter, "invalid column for the table")
+	// ErrColumnInUse is returned when dropping a column of the primary key or of an index.
+	ErrColumnInUse = errcode.New(errcode.ObjectNotInPrerequisiteState, "column is part of the primary key or an index")
+)
+
+// AddColumn appends a column to a table. The rows already stored are not rewritten: scans pad
+// them with the column's Default (see TableSchema.Defaults), so the column must have a default
+// or be nullable, and it cannot be part of the primary key. An encrypted column cannot have a
+// default, which would be read as a value to decrypt. The default must satisfy the column's
+// Check, which the rows already stored thus satisfy too. The rows of a versioned table end with
+// its system columns, so no column can be added to it.
+//
+// Like DropIndex, it holds the table's schema lock exclusively and does not log the catalog
+// change. It returns the new schema of the table.
+func (cm *CatalogManager) AddColumn(tableName string, col ColumnDef) (*TableSchema, error) {
+	tableName = canonicalTableName(tableName)
+	unlock := cm.schemaLocks.lockExclusive(tableName)
+	defer unlock()
+	cm.mu.Lock()
+	defer cm.mu.Unlock()
+
+	schema, err := cm.cachedSchema(tableName)
+	if err != nil {
+		return nil, err
+	}
+	if schema.Versioned || col.Name == "" || col.IsPrimaryKey || col.Dropped || (col.Default == nil && !col.Nullable) {
+		return nil, ErrInvalidColumn
+	}
+	if err := checkColumnConstraints([]ColumnDef{col}); err != nil {
+		return nil, err
+	}
+	if 0 <= schema.columnIndex(col.Name) {
+		return nil, ErrColumnExists
+	}
+	if err := cm.insertColumnRecord(nil, schema.TableID, len(schema.Columns), col); err != nil {
+		return nil, fmt.Errorf("failed to insert column record: %w", err)
+	}
+	altered := schema.clone()
+	altered.Columns = append(altered.Columns, col)
+	cm.schemaCache[tableName] = altered
+	return altered.clone(), nil
+}
+
+// DropColumn drops a column of a table. The column keeps its position, so that the rows already
+// stored still decode, and is marked as dropped: scans return NULL for it (see
+// TableSchema.DroppedColumns), BindRow takes no value for it and its name may be reused by
+// AddColumn. Columns of the primary key or of an index cannot be dropped.
+//
+// Like AddColumn, it holds the table's schema lock exclusively and does not log the catalog
+// change. It returns the new schema of the table.
+func (cm *CatalogManager) DropColumn(tableName string, columnName string) (*TableSchema, error) {
+	tableName = canonicalTableName(tableName)
+	unlock := cm.schemaLocks.lockExclusive(tableName)
+	defer unlock()
+	cm.mu.Lock()
+	defer cm.mu.Unlock()
+
+	schema, err := cm.cachedSchema(tableName)
+	if err != nil {
+		return nil, err
+	}
+	pos := schema.columnIndex(columnName)
+	if pos < 0 {
+		return nil, ErrColumnNotFound
+	}
+	if schema.Columns[pos].IsPrimaryKey {
+		return nil, ErrColumnInUse
+	}
+	for _, index := range schema.Indexes {
+		for _, i := range index.ColumnIndices {
+			if i == pos {
+				return nil, ErrColumnInUse
+			}
+		}
+	}
+	altered := schema.clone()
+	altered.Columns[pos].Dropped = true
+	if err := cm.columnsCatalog.Update(cm.bufmgr, columnRecord(schema.TableID, pos, altered.Columns[pos])); err != nil {
+		return nil, fmt.Errorf("failed to update column record: %w", err)
+	}
+	cm.schemaCache[tableName] = altered
+	return altered.clone(), nil
+}
@@ -0,0 +1,123 @@
+package catalog
+
+import (
+	"os"
+	"reflect"
+	"testing"
+
+	"github.com/Johniel/gorelly/buffer"
+	"github.com/Johniel/gorelly/disk"
+)
+
+func TestAddAndDropColumn(t *testing.T) {
+	tmpfile, err := os.CreateTemp("", "test_alter_columns_*.db")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(tmpfile.Name())
+
+	dm, err := disk.NewDiskManager(tmpfile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	bufmgr := buffer.NewBufferPoolManager(dm, buffer.NewBufferPool(10))
+	cm, err := NewCatalogManager(bufmgr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	users, err := cm.CreateTable("users", []ColumnDef{
+		{Name: "id", Type: ColumnTypeInt, IsPrimaryKey: true},
+		{Name: "name", Type: ColumnTypeVarchar},
+		{Name: "age", Type: ColumnTypeInt},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	index := IndexDef{IndexID: 100, IndexName: "users_age", TableID: users.TableID, MetaPageID: disk.InvalidPageID, ColumnIndices: []int{2}}
+	if err := cm.indexesCatalog.Insert(bufmgr, indexRecord(index)); err != nil {
+		t.Fatal(err)
+	}
+	cm.schemaCache["users"].Indexes = []IndexDef{index}
+
+	for _, col := range []ColumnDef{
+		{Name: "", Type: ColumnTypeVarchar, Nullable: true},
+		{Name: "tenant", Type: ColumnTypeInt, IsPrimaryKey: true, Default: []byte{0}},
+		{Name: "plan", Type: ColumnTypeVarchar},
+		{Name: "ssn", Type: ColumnTypeVarchar, Default: []byte("none"), Encryption: &ColumnEncryption{KeyID: 1}},
+	} {
+		if _, err := cm.AddColumn("users", col); err != ErrInvalidColumn {
+			t.Errorf("%q: expected ErrInvalidColumn, got %v", col.Name, err)
+		}
+	}
+	if _, err := cm.AddColumn("users", ColumnDef{Name: "name", Type: ColumnTypeVarchar, Nullable: true}); err != ErrColumnExists {
+		t.Errorf("expected ErrColumnExists, got %v", err)
+	}
+	if _, err := cm.AddColumn("missing", ColumnDef{Name: "plan", Type: ColumnTypeVarchar, Nullable: true}); err != ErrTableNotFound {
+		t.Errorf("expected ErrTableNotFound, got %v", err)
+	}
+	if _, err := cm.AddColumn("users", ColumnDef{Name: "plan", Type: ColumnTypeVarchar, Default: []byte("free")}); err != nil {
+		t.Fatal(err)
+	}
+
+	for name, want := range map[string]error{"id": ErrColumnInUse, "age": ErrColumnInUse, "email": ErrColumnNotFound} {
+		if _, err := cm.DropColumn("users", name); err != want {
+			t.Errorf("%s: expected %v, got %v", name, want, err)
+		}
+	}
+	schema, err := cm.DropColumn("users", "name")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := cm.DropColumn("users", "name"); err != ErrColumnNotFound {
+		t.Errorf("expected a dropped column not to be found, got %v", err)
+	}
+	// The name of a dropped column can be reused
+	schema, err = cm.AddColumn("users", ColumnDef{Name: "name", Type: ColumnTypeVarchar, Nullable: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(schema.DroppedColumns(), []int{1}) {
+		t.Errorf("expected column 1 to be dropped, got %v", schema.DroppedColumns())
+	}
+	if !reflect.DeepEqual(schema.Defaults(), [][]byte{nil, nil, nil, []byte("free"), nil}) {
+		t.Errorf("unexpected defaults %q", schema.Defaults())
+	}
+	if i, _, err := schema.BindColumn("name", "alice"); err != nil || i != 4 {
+		t.Errorf("expected the new column 4, got %d, %v", i, err)
+	}
+	row, err := schema.BindRow(1, 30, "pro", "alice")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if row[1] != nil || string(row[3]) != "pro" || string(row[4]) != "alice" {
+		t.Errorf("unexpected row %q", row)
+	}
+	if _, err := schema.BindRow(1, "bob", 30, "pro", "alice"); err != ErrColumnCount {
+		t.Errorf("expected ErrColumnCount, got %v", err)
+	}
+
+	// The columns survive a restart
+	if err := bufmgr.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	if err := dm.Close(); err != nil {
+		t.Fatal(err)
+	}
+	dm, err = disk.OpenDiskManager(tmpfile.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer dm.Close()
+	bufmgr = buffer.NewBufferPoolManager(dm, buffer.NewBufferPool(10))
+	cm, err = OpenCatalogManager(bufmgr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	reopened, err := cm.GetTableSchema("users")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(reopened.Columns, schema.Columns) {
+		t.Errorf("expected columns %+v, got %+v", schema.Columns, reopened.Columns)
+	}
+}
@@ -0,0 +1,127 @@
+package catalog
+
+import (
+	"encoding/binary"
+	"errors"
+	"time"
+
+	"github.com/Johniel/gorelly/btree"
+	"github.com/Johniel/gorelly/tuple"
+)
+
+// TableStatistics describes the contents of a table as of its last ANALYZE (see
+// stats.Analyze), for estimating the selectivity of conditions.
+type TableStatistics struct {
+	RowCount   uint64
+	AnalyzedAt time.Time
+	Columns    []ColumnStatistics // In column order
+}
+
+// ColumnStatistics describes the values of a column. Min and Max are the smallest and the
+// largest non-NULL values in their order-preserving encodings, or nil if the column has no
+// non-NULL values or was not sampled (e.g. encrypted columns).
+type ColumnStatistics struct {
+	NullCount     uint64
+	DistinctCount uint64 // Estimated number of distinct non-NULL values
+	Min           []byte
+	Max           []byte
+}
+
+// TableStatistics returns the statistics last saved for a table by SetTableStatistics, or nil
+// if the table was never analyzed.
+func (cm *CatalogManager) TableStatistics(tableName string) (*TableStatistics, error) {
+	tableName = canonicalTableName(tableName)
+	cm.mu.Lock()
+	defer cm.mu.Unlock()
+
+	schema, err := cm.cachedSchema(tableName)
+	if err != nil {
+		return nil, err
+	}
+	tup, err := cm.analyzeCatalog.Get(cm.bufmgr, [][]byte{tableIDKey(schema.TableID)})
+	if err == btree.ErrKeyNotFound {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return decodeTableStatistics(tup)
+}
+
+// SetTableStatistics saves the statistics of a table in analyze_catalog, replacing the ones
+// saved before.
+func (cm *CatalogManager) SetTableStatistics(tableName string, stats *TableStatistics) error {
+	tableName = canonicalTableName(tableName)
+	cm.mu.Lock()
+	defer cm.mu.Unlock()
+
+	schema, err := cm.cachedSchema(tableName)
+	if err != nil {
+		return err
+	}
+	key := [][]byte{tableIDKey(schema.TableID)}
+	_, err = cm.analyzeCatalog.Get(cm.bufmgr, key)
+	if err != nil && err != btree.ErrKeyNotFound {
+		return err
+	}
+	tup := tableStatisticsTuple(schema.TableID, stats)
+	if err == nil {
+		return cm.analyzeCatalog.Update(cm.bufmgr, tup)
+	}
+	return cm.analyzeCatalog.Insert(cm.bufmgr, tup)
+}
+
+func tableIDKey(tableID uint32) []byte {
+	tableIDBytes := make([]byte, 4)
+	binary.BigEndian.PutUint32(tableIDBytes, tableID)
+	return tableIDBytes
+}
+
+func tableStatisticsTuple(tableID uint32, stats *TableStatistics) [][]byte {
+	rowCountBytes := make([]byte, 8)
+	binary.BigEndian.PutUint64(rowCountBytes, stats.RowCount)
+	analyzedAtBytes := make([]byte, 8)
+	binary.BigEndian.PutUint64(analyzedAtBytes, uint64(stats.AnalyzedAt.UnixMicro()))
+	tup := [][]byte{
+		tableIDKey(tableID), // table_id (PK)
+		rowCountBytes,       // row_count
+		analyzedAtBytes,     // analyzed_at
+	}
+	for _, col := range stats.Columns {
+		nullCountBytes := make([]byte, 8)
+		binary.BigEndian.PutUint64(nullCountBytes, col.NullCount)
+		distinctCountBytes := make([]byte, 8)
+		binary.BigEndian.PutUint64(distinctCountBytes, col.DistinctCount)
+		colBytes := make([]byte, 0)
+		tuple.Encode([][]byte{nullCountBytes, distinctCountBytes, col.Min, col.Max}, &colBytes)
+		tup = append(tup, colBytes) // column statistics
+	}
+	return tup
+}
+
+func decodeTableStatistics(tup [][]byte) (*TableStatistics, error) {
+	malformed := errors.New("malformed analyze_catalog record")
+	if len(tup) < 3 || len(tup[1]) != 8 || len(tup[2]) != 8 {
+		return nil, malformed
+	}
+	stats := &TableStatistics{
+		RowCount:   binary.BigEndian.Uint64(tup[1]),
+		AnalyzedAt: time.UnixMicro(int64(binary.BigEndian.Uint64(tup[2]))),
+	}
+	for _, colBytes := range tup[3:] {
+		var elems [][]byte
+		tuple.Decode(colBytes, &elems)
+		if len(elems) != 4 || len(elems[0]) != 8 || len(elems[1]) != 8 {
+			return nil, malformed
+		}
+		col := ColumnStatistics{
+			NullCount:     binary.BigEndian.Uint64(elems[0]),
+			DistinctCount: binary.BigEndian.Uint64(elems[1]),
+		}
+		if len(elems[2]) != 0 {
+			col.Min, col.Max = elems[2], elems[3]
+		}
+		stats.Columns = append(stats.Columns, col)
+	}
+	return stats, nil
+}
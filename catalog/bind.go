@@ -0,0 +1,187 @@
+package catalog
+
+import (
+	"math"
+	"time"
+
+	"github.com/Johniel/gorelly/errcode"
+	"github.com/Johniel/gorelly/types"
+)
+
+var (
+	// ErrTypeMismatch is returned when binding a Go value that does not match the column type.
+	ErrTypeMismatch = errcode.New(errcode.DataException, "value does not match the column type")
+	// ErrValueTooLong is returned when binding a VARCHAR or BLOB value longer than the column size.
+	ErrValueTooLong = errcode.New(errcode.DataException, "value too long for the column")
+	// ErrColumnNotFound is returned when a column name is not part of the table.
+	ErrColumnNotFound = errcode.New(errcode.NotFound, "column not found")
+	// ErrColumnCount is returned when binding a row or key with the wrong number of values.
+	ErrColumnCount = errcode.New(errcode.InvalidParameter, "wrong number of values for the columns")
+)
+
+// Bind encodes a Go value as a value of the column type, using the order-preserving encodings
+// of the types package, so that the result can be compared byte-wise with stored values
+// and used in keys and predicates:
+//
+//   - INT: any Go integer type (uint64 values must fit in int64)
+//   - VARCHAR: string
+//   - BLOB: []byte
+//   - TIMESTAMP: time.Time
+//   - INTERVAL: time.Duration
+//   - FLOAT: float64 or float32
+//   - BOOL: bool
+//
+// It also accepts a types.Value of the kind of the column type.
+func (ct ColumnType) Bind(v any) ([]byte, error) {
+	if tv, ok := v.(types.Value); ok {
+		if tv.IsNull() || tv.Kind() != ct.Kind() {
+			return nil, ErrTypeMismatch
+		}
+		return tv.Encode(), nil
+	}
+	switch ct {
+	case ColumnTypeInt:
+		n, ok := toInt64(v)
+		if !ok {
+			return nil, ErrTypeMismatch
+		}
+		return types.EncodeInt(n), nil
+	case ColumnTypeVarchar:
+		s, ok := v.(string)
+		if !ok {
+			return nil, ErrTypeMismatch
+		}
+		return []byte(s), nil
+	case ColumnTypeBlob:
+		b, ok := v.([]byte)
+		if !ok {
+			return nil, ErrTypeMismatch
+		}
+		return b, nil
+	case ColumnTypeTimestamp:
+		t, ok := v.(time.Time)
+		if !ok {
+			return nil, ErrTypeMismatch
+		}
+		return types.EncodeTimestamp(t), nil
+	case ColumnTypeInterval:
+		d, ok := v.(time.Duration)
+		if !ok {
+			return nil, ErrTypeMismatch
+		}
+		return types.EncodeInterval(d), nil
+	case ColumnTypeFloat:
+		switch f := v.(type) {
+		case float64:
+			return types.EncodeFloat(f), nil
+		case float32:
+			return types.EncodeFloat(float64(f)), nil
+		default:
+			return nil, ErrTypeMismatch
+		}
+	case ColumnTypeBool:
+		t, ok := v.(bool)
+		if !ok {
+			return nil, ErrTypeMismatch
+		}
+		return types.EncodeBool(t), nil
+	default:
+		return nil, ErrTypeMismatch
+	}
+}
+
+func toInt64(v any) (int64, bool) {
+	switch n := v.(type) {
+	case int:
+		return int64(n), true
+	case int8:
+		return int64(n), true
+	case int16:
+		return int64(n), true
+	case int32:
+		return int64(n), true
+	case int64:
+		return n, true
+	case uint8:
+		return int64(n), true
+	case uint16:
+		return int64(n), true
+	case uint32:
+		return int64(n), true
+	case uint:
+		return int64(n), uint64(n) <= math.MaxInt64
+	case uint64:
+		return int64(n), n <= math.MaxInt64
+	default:
+		return 0, false
+	}
+}
+
+// Bind encodes a Go value for the column, checking the column size of VARCHAR and BLOB columns.
+// types.Null binds to NULL (nil) if the column is nullable.
+func (cd ColumnDef) Bind(v any) ([]byte, error) {
+	if tv, ok := v.(types.Value); ok && tv.IsNull() && cd.Nullable {
+		return nil, nil
+	}
+	b, err := cd.Type.Bind(v)
+	if err != nil {
+		return nil, err
+	}
+	if (cd.Type == ColumnTypeVarchar || cd.Type == ColumnTypeBlob) && 0 < cd.Size && cd.Size < len(b) {
+		return nil, ErrValueTooLong
+	}
+	return b, nil
+}
+
+// BindRow encodes one Go value per column into a tuple ready to be inserted into the table.
+// Dropped columns take no value: the tuple stores NULL in them.
+func (ts *TableSchema) BindRow(values ...any) ([][]byte, error) {
+	if len(values) != len(ts.Columns)-len(ts.DroppedColumns()) {
+		return nil, ErrColumnCount
+	}
+	tup := make([][]byte, len(ts.Columns))
+	for i, col := range ts.Columns {
+		if col.Dropped {
+			continue
+		}
+		b, err := col.Bind(values[0])
+		if err != nil {
+			return nil, err
+		}
+		tup[i] = b
+		values = values[1:]
+	}
+	return tup, nil
+}
+
+// BindKey encodes the values of the leading primary key columns into a key
+// for search modes and range scans. It accepts a prefix of the primary key.
+func (ts *TableSchema) BindKey(values ...any) ([][]byte, error) {
+	if ts.NumKeyElems < len(values) {
+		return nil, ErrColumnCount
+	}
+	tup := make([][]byte, len(values))
+	for i, v := range values {
+		b, err := ts.Columns[i].Bind(v)
+		if err != nil {
+			return nil, err
+		}
+		tup[i] = b
+	}
+	return tup, nil
+}
+
+// BindColumn encodes a Go value for the named column and returns the column index with it,
+// e.g. to build a predicate with query.Compare.
+func (ts *TableSchema) BindColumn(name string, v any) (int, []byte, error) {
+	for i, col := range ts.Columns {
+		if col.Name == name && !col.Dropped {
+			b, err := col.Bind(v)
+			if err != nil {
+				return 0, nil, err
+			}
+			return i, b, nil
+		}
+	}
+	return 0, nil, ErrColumnNotFound
+}
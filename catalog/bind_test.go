@@ -0,0 +1,152 @@
+package catalog
+
+import (
+	"bytes"
+	"math"
+	"testing"
+	"time"
+
+	"github.com/Johniel/gorelly/types"
+)
+
+func TestBind(t *testing.T) {
+	schema := &TableSchema{
+		NumKeyElems: 1,
+		Columns: []ColumnDef{
+			{Name: "id", Type: ColumnTypeInt, IsPrimaryKey: true},
+			{Name: "name", Type: ColumnTypeVarchar, Size: 5},
+			{Name: "avatar", Type: ColumnTypeBlob},
+			{Name: "created_at", Type: ColumnTypeTimestamp},
+			{Name: "ttl", Type: ColumnTypeInterval},
+		},
+	}
+
+	t.Run("IntOrder", func(t *testing.T) {
+		values := []any{int64(math.MinInt64), -10, int8(-1), 0, uint8(9), int32(10), uint64(math.MaxInt64)}
+		var prev []byte
+		for i, v := range values {
+			b, err := ColumnTypeInt.Bind(v)
+			if err != nil {
+				t.Fatalf("%v: %v", v, err)
+			}
+			if 0 < i && bytes.Compare(prev, b) >= 0 {
+				t.Errorf("expected %v to sort after %v", v, values[i-1])
+			}
+			prev = b
+		}
+		if _, err := ColumnTypeInt.Bind(uint64(math.MaxInt64) + 1); err != ErrTypeMismatch {
+			t.Errorf("expected ErrTypeMismatch for an overflowing uint64, got %v", err)
+		}
+	})
+
+	t.Run("FloatAndBool", func(t *testing.T) {
+		values := []any{math.Inf(-1), -2.5, float32(-1), 0.0, 0.125, 3.0}
+		var prev []byte
+		for i, v := range values {
+			b, err := ColumnTypeFloat.Bind(v)
+			if err != nil {
+				t.Fatalf("%v: %v", v, err)
+			}
+			if 0 < i && bytes.Compare(prev, b) >= 0 {
+				t.Errorf("expected %v to sort after %v", v, values[i-1])
+			}
+			prev = b
+		}
+		if _, err := ColumnTypeFloat.Bind(3); err != ErrTypeMismatch {
+			t.Errorf("expected ErrTypeMismatch for an int FLOAT, got %v", err)
+		}
+		f, _ := ColumnTypeBool.Bind(false)
+		tr, _ := ColumnTypeBool.Bind(true)
+		if bytes.Compare(f, tr) >= 0 {
+			t.Error("expected false to sort before true")
+		}
+	})
+
+	t.Run("Value", func(t *testing.T) {
+		b, err := ColumnTypeInt.Bind(types.Int64(30))
+		if err != nil || !bytes.Equal(b, types.EncodeInt(30)) {
+			t.Errorf("unexpected binding %x (%v)", b, err)
+		}
+		if _, err := ColumnTypeInt.Bind(types.Varchar("30")); err != ErrTypeMismatch {
+			t.Errorf("expected ErrTypeMismatch for a VARCHAR value, got %v", err)
+		}
+		nullable := ColumnDef{Name: "nickname", Type: ColumnTypeVarchar, Nullable: true}
+		if b, err := nullable.Bind(types.Null); err != nil || b != nil {
+			t.Errorf("expected NULL, got %x (%v)", b, err)
+		}
+		if _, err := schema.Columns[1].Bind(types.Null); err != ErrTypeMismatch {
+			t.Errorf("expected ErrTypeMismatch for NULL in a column that is not nullable, got %v", err)
+		}
+	})
+
+	t.Run("Row", func(t *testing.T) {
+		created := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+		row, err := schema.BindRow(42, "alice", []byte{1, 2}, created, time.Hour)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(row) != 5 || string(row[1]) != "alice" || !bytes.Equal(row[2], []byte{1, 2}) {
+			t.Errorf("unexpected row %x", row)
+		}
+		if _, err := schema.BindRow(42, "alice"); err != ErrColumnCount {
+			t.Errorf("expected ErrColumnCount, got %v", err)
+		}
+		if _, err := schema.BindRow("42", "alice", []byte{}, created, time.Hour); err != ErrTypeMismatch {
+			t.Errorf("expected ErrTypeMismatch for a string INT, got %v", err)
+		}
+		if _, err := schema.BindRow(42, "alice!", []byte{}, created, time.Hour); err != ErrValueTooLong {
+			t.Errorf("expected ErrValueTooLong, got %v", err)
+		}
+	})
+
+	t.Run("Key", func(t *testing.T) {
+		key, err := schema.BindKey(7)
+		if err != nil {
+			t.Fatal(err)
+		}
+		id, _ := ColumnTypeInt.Bind(7)
+		if len(key) != 1 || !bytes.Equal(key[0], id) {
+			t.Errorf("unexpected key %x", key)
+		}
+		if _, err := schema.BindKey(7, "bob"); err != ErrColumnCount {
+			t.Errorf("expected ErrColumnCount for more than the primary key, got %v", err)
+		}
+	})
+
+	t.Run("Column", func(t *testing.T) {
+		index, value, err := schema.BindColumn("ttl", time.Minute)
+		if err != nil || index != 4 || len(value) != 8 {
+			t.Errorf("unexpected binding %d %x (%v)", index, value, err)
+		}
+		if _, _, err := schema.BindColumn("missing", 1); err != ErrColumnNotFound {
+			t.Errorf("expected ErrColumnNotFound, got %v", err)
+		}
+	})
+}
+
+func TestRowSchema(t *testing.T) {
+	schema := &TableSchema{
+		NumKeyElems: 1,
+		Columns: []ColumnDef{
+			{Name: "id", Type: ColumnTypeInt, IsPrimaryKey: true},
+			{Name: "age", Type: ColumnTypeInt, Dropped: true},
+			{Name: "score", Type: ColumnTypeFloat},
+			{Name: "age", Type: ColumnTypeVarchar, Nullable: true},
+		},
+	}
+	rowSchema := schema.RowSchema()
+	tup, err := schema.BindRow(7, 2.5, "thirty")
+	if err != nil {
+		t.Fatal(err)
+	}
+	row, err := rowSchema.Decode(tup)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if row.Get("id").Int64() != 7 || row.Get("score").Float64() != 2.5 || row.Get("age").Varchar() != "thirty" {
+		t.Errorf("unexpected row %v", row.Values())
+	}
+	if rowSchema.Columns[1].Kind != types.KindInt64 || rowSchema.Columns[2].Kind != types.KindFloat64 {
+		t.Errorf("unexpected columns %v", rowSchema.Columns)
+	}
+}
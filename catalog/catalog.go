@@ -7,15 +7,24 @@ import (
 	"github.com/Johniel/gorelly/btree"
 	"github.com/Johniel/gorelly/buffer"
 	"github.com/Johniel/gorelly/disk"
+	"github.com/Johniel/gorelly/errcode"
 	"github.com/Johniel/gorelly/table"
 	"github.com/Johniel/gorelly/tuple"
+	"github.com/Johniel/gorelly/types"
 	"sync"
 )
 
 var (
-	ErrTableNotFound         = errors.New("table not found")
-	ErrTableExists           = errors.New("table already exists")
-	ErrCatalogNotInitialized = errors.New("catalog tables not initialized")
+	ErrTableNotFound         = errcode.New(errcode.NotFound, "table not found")
+	ErrTableExists           = errcode.New(errcode.AlreadyExists, "table already exists")
+	ErrCatalogNotInitialized = errcode.New(errcode.ObjectNotInPrerequisiteState, "catalog tables not initialized")
+	ErrSchemaNotFound        = errcode.New(errcode.NotFound, "schema not found")
+	ErrSchemaExists          = errcode.New(errcode.AlreadyExists, "schema already exists")
+	ErrIndexNotFound         = errcode.New(errcode.NotFound, "index not found")
+	ErrIndexExists           = errcode.New(errcode.AlreadyExists, "index already exists")
+	ErrInvalidName           = errcode.New(errcode.InvalidParameter, "invalid schema or table name")
+	ErrIndexInUse            = errcode.New(errcode.ObjectNotInPrerequisiteState, "index is used by a foreign key")
+	ErrTableReferenced       = errcode.New(errcode.ObjectNotInPrerequisiteState, "table is referenced by a foreign key")
 )
 
 type ColumnType int
@@ -24,6 +33,10 @@ const (
 	ColumnTypeInt ColumnType = iota
 	ColumnTypeVarchar
 	ColumnTypeBlob
+	ColumnTypeTimestamp
+	ColumnTypeInterval
+	ColumnTypeFloat
+	ColumnTypeBool
 )
 
 func (ct ColumnType) String() string {
@@ -34,26 +47,147 @@ func (ct ColumnType) String() string {
 		return "VARCHAR"
 	case ColumnTypeBlob:
 		return "BLOB"
+	case ColumnTypeTimestamp:
+		return "TIMESTAMP"
+	case ColumnTypeInterval:
+		return "INTERVAL"
+	case ColumnTypeFloat:
+		return "FLOAT"
+	case ColumnTypeBool:
+		return "BOOL"
 	default:
 		return "UNKNOWN"
 	}
 }
 
+// Kind returns the kind of the values of the column type, or types.KindNull if it is unknown.
+func (ct ColumnType) Kind() types.Kind {
+	switch ct {
+	case ColumnTypeInt:
+		return types.KindInt64
+	case ColumnTypeVarchar:
+		return types.KindVarchar
+	case ColumnTypeBlob:
+		return types.KindBlob
+	case ColumnTypeTimestamp:
+		return types.KindTimestamp
+	case ColumnTypeInterval:
+		return types.KindInterval
+	case ColumnTypeFloat:
+		return types.KindFloat64
+	case ColumnTypeBool:
+		return types.KindBool
+	default:
+		return types.KindNull
+	}
+}
+
 type ColumnDef struct {
 	Name         string
 	Type         ColumnType
 	Size         int
 	Nullable     bool
 	IsPrimaryKey bool
+	// Value of the column in rows inserted without it (see table.Table.Defaults) and in rows
+	// written before it was added to the table (nil is NULL)
+	Default []byte
+	// Optional CHECK constraint: conditions every non-NULL value of the column must satisfy
+	Check []CheckCond
+	// If set, the values of the column are encrypted (see ColumnEncryption)
+	Encryption *ColumnEncryption
+	// Set once the column is dropped (see DropColumn). A dropped column keeps its position so
+	// that stored rows still decode, but its values read as NULL and new rows store NULL in it.
+	Dropped bool
 }
 
 type TableSchema struct {
-	TableID     uint32
-	TableName   string
-	MetaPageID  disk.PageID
-	NumKeyElems int // Number of primary key elements
-	Columns     []ColumnDef
-	Indexes     []IndexDef
+	TableID      uint32
+	TableName    string // Qualified with its schema unless the table is in DefaultSchema
+	MetaPageID   disk.PageID
+	NumKeyElems  int          // Number of primary key elements
+	Format       tuple.Format // Row format of the table's values
+	Columns      []ColumnDef
+	Indexes      []IndexDef
+	ForeignKeys  []ForeignKeyDef // Foreign keys of the table
+	ReferencedBy []ForeignKeyDef // Foreign keys referencing the table, including its own
+	// Set for a table created by CreateVersionedTable, whose rows end with the xmin and xmax
+	// system columns after Columns (see XminColumn) and whose replaced row versions are kept in
+	// the tree of VersionsMetaPageID (see table.VersionStore)
+	Versioned          bool
+	VersionsMetaPageID disk.PageID
+}
+
+// XminColumn returns the position of the system column of a versioned table holding the ID of
+// the transaction that wrote a row, right after Columns.
+func (ts *TableSchema) XminColumn() int {
+	return len(ts.Columns)
+}
+
+// XmaxColumn returns the position of the system column of a versioned table holding the ID of
+// the transaction that deleted a row, empty while the row is not deleted.
+func (ts *TableSchema) XmaxColumn() int {
+	return len(ts.Columns) + 1
+}
+
+// VersionStore returns the store of the replaced row versions of a versioned table, or nil for
+// another table.
+func (ts *TableSchema) VersionStore() *table.VersionStore {
+	if !ts.Versioned {
+		return nil
+	}
+	return &table.VersionStore{
+		MetaPageID:  ts.VersionsMetaPageID,
+		NumKeyElems: ts.NumKeyElems,
+		XminColumn:  ts.XminColumn(),
+		XmaxColumn:  ts.XmaxColumn(),
+	}
+}
+
+// Defaults returns the default value of every column of the table, in column order.
+// Scans use it to fill in the trailing columns of rows written before those columns were added.
+// Dropped columns have no default, and neither do the system columns of a versioned table,
+// which follow the others.
+func (ts *TableSchema) Defaults() [][]byte {
+	defaults := make([][]byte, len(ts.Columns))
+	for i, col := range ts.Columns {
+		if !col.Dropped {
+			defaults[i] = col.Default
+		}
+	}
+	if ts.Versioned {
+		defaults = append(defaults, nil, nil)
+	}
+	return defaults
+}
+
+// DroppedColumns returns the indices of the dropped columns of the table. Scans return NULL
+// for them whatever the rows store.
+func (ts *TableSchema) DroppedColumns() []int {
+	var dropped []int
+	for i, col := range ts.Columns {
+		if col.Dropped {
+			dropped = append(dropped, i)
+		}
+	}
+	return dropped
+}
+
+// RowSchema returns the types.Schema of the rows of the table, to read them as typed values
+// that compare numerically, e.g. in filters and sorts. Encrypted columns must be decrypted
+// first (see DecryptRow). Dropped columns keep their position but lose their name, so that
+// Row.Get does not find them; the system columns of a versioned table have no name either.
+func (ts *TableSchema) RowSchema() *types.Schema {
+	columns := make([]types.Column, len(ts.Columns))
+	for i, col := range ts.Columns {
+		columns[i] = types.Column{Name: col.Name, Kind: col.Type.Kind()}
+		if col.Dropped {
+			columns[i].Name = ""
+		}
+	}
+	if ts.Versioned {
+		columns = append(columns, types.Column{Kind: types.KindBlob}, types.Column{Kind: types.KindBlob})
+	}
+	return types.NewSchema(columns...)
 }
 
 type IndexDef struct {
@@ -68,25 +202,35 @@ type IndexDef struct {
 type CatalogManager struct {
 	bufmgr *buffer.BufferPoolManager
 
-	tablesCatalog  *table.Table
-	columnsCatalog *table.Table
-	indexesCatalog *table.Table
+	tablesCatalog      *table.Table
+	columnsCatalog     *table.Table
+	indexesCatalog     *table.Table
+	rolesCatalog       *table.Table
+	grantsCatalog      *table.Table
+	rotationCatalog    *table.Table
+	statsCatalog       *table.Table
+	schemasCatalog     *table.Table
+	vacuumCatalog      *table.Table
+	analyzeCatalog     *table.Table
+	foreignKeysCatalog *table.Table
 
 	nextTableID uint32
 	nextIndexID uint32
+	nextRoleID  uint32
 
 	schemaCache map[string]*TableSchema
+	schemas     map[string]bool // Names of the schemas
+	ddlMu       sync.Mutex      // Serializes DDL transactions (see BeginDDL)
+	schemaLocks schemaLocks     // Per-table locks serializing DDL with the sessions using the table
+	policies    map[string]*SecurityPolicy
+	accessStats map[string]*table.AccessStats // Access counters of the tables, by table name
 	mu          sync.RWMutex
 }
 
+// NewCatalogManager creates the catalog tables of a new database. They must be the first pages
+// allocated in it, so that OpenCatalogManager finds them after a restart.
 func NewCatalogManager(bufmgr *buffer.BufferPoolManager) (*CatalogManager, error) {
-	cm := &CatalogManager{
-		bufmgr:      bufmgr,
-		schemaCache: make(map[string]*TableSchema),
-		nextTableID: 1,
-		nextIndexID: 1,
-	}
-
+	cm := newCatalogManager(bufmgr)
 	if err := cm.initializeCatalogTables(); err != nil {
 		return nil, err
 	}
@@ -94,6 +238,19 @@ func NewCatalogManager(bufmgr *buffer.BufferPoolManager) (*CatalogManager, error
 	return cm, nil
 }
 
+func newCatalogManager(bufmgr *buffer.BufferPoolManager) *CatalogManager {
+	return &CatalogManager{
+		bufmgr:      bufmgr,
+		schemaCache: make(map[string]*TableSchema),
+		schemas:     map[string]bool{DefaultSchema: true},
+		policies:    make(map[string]*SecurityPolicy),
+		accessStats: make(map[string]*table.AccessStats),
+		nextTableID: 1,
+		nextIndexID: 1,
+		nextRoleID:  1,
+	}
+}
+
 func (cm *CatalogManager) initializeCatalogTables() error {
 	tablesCatalog := &table.SimpleTable{
 		MetaPageID:  disk.PageID(0),
@@ -109,7 +266,7 @@ func (cm *CatalogManager) initializeCatalogTables() error {
 	}
 
 	// Try to create columns_catalog
-	// Schema: [table_id (PK), column_index (PK), column_name, column_type, column_size, nullable, is_primary_key]
+	// Schema: [table_id (PK), column_index (PK), column_name, column_type, column_size, nullable, is_primary_key, has_default, column_default, encryption, dropped, check]
 	columnsCatalog := &table.SimpleTable{
 		MetaPageID:  disk.PageID(1),
 		NumKeyElems: 2, // table_id + column_index is the composite primary key
@@ -137,10 +294,144 @@ func (cm *CatalogManager) initializeCatalogTables() error {
 		MetaPageID:  indexesCatalog.MetaPageID,
 		NumKeyElems: 1,
 	}
+
+	// Try to create roles_catalog
+	// Schema: [role_id (PK), role_name, superuser, password (optional, see SetPassword)]
+	rolesCatalog := &table.SimpleTable{
+		MetaPageID:  disk.PageID(3),
+		NumKeyElems: 1, // role_id is the primary key
+	}
+	if err := rolesCatalog.Create(cm.bufmgr); err != nil {
+		// Table might already exist, use existing
+		rolesCatalog.MetaPageID = disk.PageID(3)
+	}
+	cm.rolesCatalog = &table.Table{
+		MetaPageID:  rolesCatalog.MetaPageID,
+		NumKeyElems: 1,
+	}
+
+	// Try to create grants_catalog
+	// Schema: [role_id (PK), table_id (PK), privileges]
+	grantsCatalog := &table.SimpleTable{
+		MetaPageID:  disk.PageID(4),
+		NumKeyElems: 2, // role_id + table_id is the composite primary key
+	}
+	if err := grantsCatalog.Create(cm.bufmgr); err != nil {
+		// Table might already exist, use existing
+		grantsCatalog.MetaPageID = disk.PageID(4)
+	}
+	cm.grantsCatalog = &table.Table{
+		MetaPageID:  grantsCatalog.MetaPageID,
+		NumKeyElems: 2,
+	}
+
+	// Try to create key_rotation_catalog
+	// Schema: [zone (PK), key_id, next_page_id]
+	rotationCatalog := &table.SimpleTable{
+		MetaPageID:  disk.PageID(5),
+		NumKeyElems: 1, // zone is the primary key
+	}
+	if err := rotationCatalog.Create(cm.bufmgr); err != nil {
+		// Table might already exist, use existing
+		rotationCatalog.MetaPageID = disk.PageID(5)
+	}
+	cm.rotationCatalog = &table.Table{
+		MetaPageID:  rotationCatalog.MetaPageID,
+		NumKeyElems: 1,
+	}
+
+	// Try to create table_stats_catalog
+	// Schema: [table_id (PK), seq_scans, index_scans, rows_read, inserts, updates, deletes, pages_fetched, pages_read]
+	statsCatalog := &table.SimpleTable{
+		MetaPageID:  disk.PageID(6),
+		NumKeyElems: 1, // table_id is the primary key
+	}
+	if err := statsCatalog.Create(cm.bufmgr); err != nil {
+		// Table might already exist, use existing
+		statsCatalog.MetaPageID = disk.PageID(6)
+	}
+	cm.statsCatalog = &table.Table{
+		MetaPageID:  statsCatalog.MetaPageID,
+		NumKeyElems: 1,
+	}
+
+	// Try to create schemas_catalog
+	// Schema: [schema_name (PK)]
+	schemasCatalog := &table.SimpleTable{
+		MetaPageID:  disk.PageID(7),
+		NumKeyElems: 1, // schema_name is the primary key
+	}
+	if err := schemasCatalog.Create(cm.bufmgr); err != nil {
+		// Table might already exist, use existing
+		schemasCatalog.MetaPageID = disk.PageID(7)
+	}
+	cm.schemasCatalog = &table.Table{
+		MetaPageID:  schemasCatalog.MetaPageID,
+		NumKeyElems: 1,
+	}
+
+	// Try to create vacuum_progress_catalog
+	// Schema: [target_name (PK), progress]
+	vacuumCatalog := &table.SimpleTable{
+		MetaPageID:  disk.PageID(8),
+		NumKeyElems: 1, // target_name is the primary key
+	}
+	if err := vacuumCatalog.Create(cm.bufmgr); err != nil {
+		// Table might already exist, use existing
+		vacuumCatalog.MetaPageID = disk.PageID(8)
+	}
+	cm.vacuumCatalog = &table.Table{
+		MetaPageID:  vacuumCatalog.MetaPageID,
+		NumKeyElems: 1,
+	}
+
+	// Try to create analyze_catalog
+	// Schema: [table_id (PK), row_count, analyzed_at, column statistics...]
+	analyzeCatalog := &table.SimpleTable{
+		MetaPageID:  disk.PageID(9),
+		NumKeyElems: 1, // table_id is the primary key
+	}
+	if err := analyzeCatalog.Create(cm.bufmgr); err != nil {
+		// Table might already exist, use existing
+		analyzeCatalog.MetaPageID = disk.PageID(9)
+	}
+	cm.analyzeCatalog = &table.Table{
+		MetaPageID:  analyzeCatalog.MetaPageID,
+		NumKeyElems: 1,
+	}
+
+	// Try to create foreign_keys_catalog
+	// Schema: [foreign_key_id (PK), foreign_key_name, table_id, ref_table_id, column_indices, index_id, on_delete]
+	foreignKeysCatalog := &table.SimpleTable{
+		MetaPageID:  disk.PageID(10),
+		NumKeyElems: 1, // foreign_key_id is the primary key
+	}
+	if err := foreignKeysCatalog.Create(cm.bufmgr); err != nil {
+		// Table might already exist, use existing
+		foreignKeysCatalog.MetaPageID = disk.PageID(10)
+	}
+	cm.foreignKeysCatalog = &table.Table{
+		MetaPageID:  foreignKeysCatalog.MetaPageID,
+		NumKeyElems: 1,
+	}
 	return nil
 }
 
 func (cm *CatalogManager) CreateTable(tableName string, columns []ColumnDef) (*TableSchema, error) {
+	return cm.CreateTableWithFormat(tableName, columns, tuple.FormatV1)
+}
+
+// CreateTableWithFormat creates a table whose rows are stored in the given row format.
+// The format is recorded in the catalog; readers and writers of the table must use schema.Format.
+// A name of the form "schema.table" creates the table in a schema created by CreateSchema.
+//
+// The table's schema lock is held exclusively while it is created, and the schema is published
+// only once every catalog record is written. If writing them fails, the records already written
+// are removed again, so other sessions never observe a half-created table.
+func (cm *CatalogManager) CreateTableWithFormat(tableName string, columns []ColumnDef, format tuple.Format) (*TableSchema, error) {
+	tableName = canonicalTableName(tableName)
+	unlock := cm.schemaLocks.lockExclusive(tableName)
+	defer unlock()
 	cm.mu.Lock()
 	defer cm.mu.Unlock()
 
@@ -148,6 +439,55 @@ func (cm *CatalogManager) CreateTable(tableName string, columns []ColumnDef) (*T
 	if _, exists := cm.schemaCache[tableName]; exists {
 		return nil, ErrTableExists
 	}
+	schema, err := cm.createTable(tableName, columns, format, false, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	// Cache the schema
+	cm.schemaCache[tableName] = schema
+
+	return schema.clone(), nil
+}
+
+// CreateVersionedTable creates a table as CreateTable does, whose rows are versioned for
+// multi-version concurrency control: they end with two system columns holding the IDs of the
+// transactions that wrote and deleted them (see TableSchema.XminColumn), and the versions that
+// updates replace are kept in a version store (see TableSchema.VersionStore), so that readers
+// at an older snapshot still find them (see query.FilterVisible). The tables opened for it
+// stamp the system columns with their Writer (see table.Table.Writer).
+func (cm *CatalogManager) CreateVersionedTable(tableName string, columns []ColumnDef) (*TableSchema, error) {
+	tableName = canonicalTableName(tableName)
+	unlock := cm.schemaLocks.lockExclusive(tableName)
+	defer unlock()
+	cm.mu.Lock()
+	defer cm.mu.Unlock()
+
+	if _, exists := cm.schemaCache[tableName]; exists {
+		return nil, ErrTableExists
+	}
+	schema, err := cm.createTable(tableName, columns, tuple.FormatV1, true, nil)
+	if err != nil {
+		return nil, err
+	}
+	cm.schemaCache[tableName] = schema
+	return schema.clone(), nil
+}
+
+// createTable creates the tree of a table, and the tree of its version store if it is
+// versioned, and writes its catalog records through log, with the table's schema lock held
+// exclusively and cm.mu held. If writing the records fails, the records already written are
+// removed again and the trees are dropped. The schema is not cached.
+func (cm *CatalogManager) createTable(tableName string, columns []ColumnDef, format tuple.Format, versioned bool, log btree.PageLog) (*TableSchema, error) {
+	if err := cm.checkTableName(tableName); err != nil {
+		return nil, err
+	}
+	if err := checkEncryption(columns); err != nil {
+		return nil, err
+	}
+	if err := checkColumnConstraints(columns); err != nil {
+		return nil, err
+	}
 
 	// Check catalog table
 	if cm.tableExistsInCatalog(tableName) {
@@ -164,39 +504,214 @@ func (cm *CatalogManager) CreateTable(tableName string, columns []ColumnDef) (*T
 		}
 	}
 
-	bt, err := btree.CreateBTree(cm.bufmgr)
+	bt, err := btree.CreateBTreeWithLog(cm.bufmgr, log)
 	if err != nil {
 		return nil, fmt.Errorf("failed to create B+ tree: %w", err)
 	}
+	trees := []*btree.BTree{bt}
 
 	schema := &TableSchema{
 		TableID:     tableID,
 		TableName:   tableName,
 		MetaPageID:  bt.MetaPageID,
 		NumKeyElems: numKeyElems,
+		Format:      format,
 		Columns:     columns,
 		Indexes:     []IndexDef{},
+		Versioned:   versioned,
 	}
-
-	// Insert into tables_catalog
-	if err := cm.insertTableRecord(tableID, tableName, bt.MetaPageID, numKeyElems); err != nil {
-		return nil, fmt.Errorf("failed to insert table record: %w", err)
+	if versioned {
+		versions, err := btree.CreateBTreeWithLog(cm.bufmgr, log)
+		if err != nil {
+			err = fmt.Errorf("failed to create B+ tree: %w", err)
+			if _, dropErr := bt.Drop(cm.bufmgr); dropErr != nil {
+				err = errors.Join(err, dropErr)
+			}
+			return nil, err
+		}
+		schema.VersionsMetaPageID = versions.MetaPageID
+		trees = append(trees, versions)
 	}
 
-	// Insert into columns_catalog
+	// Insert into tables_catalog, then into columns_catalog
+	err = withLog(cm.tablesCatalog, log).Insert(cm.bufmgr, tableRecord(schema))
+	if err != nil {
+		err = fmt.Errorf("failed to insert table record: %w", err)
+	}
 	for i, col := range columns {
-		if err := cm.insertColumnRecord(tableID, i, col); err != nil {
-			return nil, fmt.Errorf("failed to insert column record: %w", err)
+		if err != nil {
+			break
+		}
+		if err = cm.insertColumnRecord(log, tableID, i, col); err != nil {
+			err = fmt.Errorf("failed to insert column record: %w", err)
+			if undoErr := cm.deleteTableRecords(log, tableID, i); undoErr != nil {
+				err = errors.Join(err, undoErr)
+			}
+		}
+	}
+	if err != nil {
+		for _, created := range trees {
+			if _, dropErr := created.Drop(cm.bufmgr); dropErr != nil {
+				err = errors.Join(err, dropErr)
+			}
+		}
+		return nil, err
+	}
+	return schema, nil
+}
+
+// DropTable removes a table and its indexes from the catalog and frees the pages of its B+ tree
+// and indexes,
+// which are reused for new pages. The table's schema lock is held exclusively, so the table is
+// dropped once the sessions using it released it; it must not be used anymore afterwards.
+// The pages are freed without logging, so a dropped table cannot be restored by a rollback.
+// The grants and persisted access and ANALYZE statistics of the table are kept; table IDs are
+// never reused. The foreign keys of the table are dropped with it, and the schema locks of the
+// tables they reference are held as well; a table referenced by another table cannot be dropped
+// (ErrTableReferenced).
+func (cm *CatalogManager) DropTable(tableName string) error {
+	tableName = canonicalTableName(tableName)
+	unlock, err := cm.lockRelated(tableName)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+	cm.mu.Lock()
+	defer cm.mu.Unlock()
+
+	schema, err := cm.cachedSchema(tableName)
+	if err != nil {
+		return err
+	}
+	for _, fk := range schema.ReferencedBy {
+		if fk.TableID != schema.TableID {
+			return ErrTableReferenced
 		}
 	}
+	for _, fk := range schema.ForeignKeys {
+		if err := cm.deleteForeignKey(tableName, fk); err != nil {
+			return err
+		}
+	}
+	for _, index := range schema.Indexes {
+		if err := cm.deleteIndexRecord(index.IndexID); err != nil {
+			return fmt.Errorf("failed to delete index record: %w", err)
+		}
+	}
+	if err := cm.deleteTableRecords(nil, schema.TableID, len(schema.Columns)); err != nil {
+		return fmt.Errorf("failed to delete table records: %w", err)
+	}
+	delete(cm.schemaCache, tableName)
+	delete(cm.policies, tableName)
+	delete(cm.accessStats, tableName)
 
-	// Cache the schema
-	cm.schemaCache[tableName] = schema
+	metaPageIDs := []disk.PageID{schema.MetaPageID}
+	if schema.Versioned {
+		metaPageIDs = append(metaPageIDs, schema.VersionsMetaPageID)
+	}
+	for _, index := range schema.Indexes {
+		if index.MetaPageID.Valid() {
+			metaPageIDs = append(metaPageIDs, index.MetaPageID)
+		}
+	}
+	for _, metaPageID := range metaPageIDs {
+		if _, err := btree.NewBTree(metaPageID).Drop(cm.bufmgr); err != nil {
+			return err
+		}
+	}
+	return nil
+}
 
-	return schema, nil
+// DropIndex removes an index of a table from the catalog and frees the pages of its B+ tree.
+// Like DropTable, it holds the table's schema lock exclusively and does not log the freed pages.
+// It returns ErrIndexInUse if a foreign key of the table finds its references through the index.
+func (cm *CatalogManager) DropIndex(tableName string, indexName string) error {
+	tableName = canonicalTableName(tableName)
+	unlock := cm.schemaLocks.lockExclusive(tableName)
+	defer unlock()
+	cm.mu.Lock()
+	defer cm.mu.Unlock()
+	return cm.dropIndex(tableName, indexName)
+}
+
+// dropIndex is DropIndex with the table's schema lock held exclusively and cm.mu held.
+func (cm *CatalogManager) dropIndex(tableName string, indexName string) error {
+	schema, err := cm.cachedSchema(tableName)
+	if err != nil {
+		return err
+	}
+	pos := -1
+	for i, index := range schema.Indexes {
+		if index.IndexName == indexName {
+			pos = i
+		}
+	}
+	if pos < 0 {
+		return ErrIndexNotFound
+	}
+	index := schema.Indexes[pos]
+	for _, fk := range schema.ForeignKeys {
+		if fk.IndexID == index.IndexID {
+			return ErrIndexInUse
+		}
+	}
+	if err := cm.deleteIndexRecord(index.IndexID); err != nil {
+		return fmt.Errorf("failed to delete index record: %w", err)
+	}
+	dropped := schema.clone()
+	dropped.Indexes = append(dropped.Indexes[:pos], dropped.Indexes[pos+1:]...)
+	cm.schemaCache[tableName] = dropped
+
+	if index.MetaPageID.Valid() {
+		if _, err := btree.NewBTree(index.MetaPageID).Drop(cm.bufmgr); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// deleteIndexRecord removes the indexes_catalog record of an index, if it has one.
+func (cm *CatalogManager) deleteIndexRecord(indexID uint32) error {
+	indexIDBytes := make([]byte, 4)
+	binary.BigEndian.PutUint32(indexIDBytes, indexID)
+	err := cm.indexesCatalog.Delete(cm.bufmgr, [][]byte{indexIDBytes})
+	if errors.Is(err, btree.ErrKeyNotFound) {
+		return nil
+	}
+	return err
+}
+
+// deleteTableRecords removes the tables_catalog record of a table and its first numColumns
+// columns_catalog records.
+func (cm *CatalogManager) deleteTableRecords(log btree.PageLog, tableID uint32, numColumns int) error {
+	tableIDBytes := make([]byte, 4)
+	binary.BigEndian.PutUint32(tableIDBytes, tableID)
+	for i := numColumns - 1; 0 <= i; i-- {
+		columnIndexBytes := make([]byte, 4)
+		binary.BigEndian.PutUint32(columnIndexBytes, uint32(i))
+		if err := withLog(cm.columnsCatalog, log).Delete(cm.bufmgr, [][]byte{tableIDBytes, columnIndexBytes}); err != nil {
+			return err
+		}
+	}
+	return withLog(cm.tablesCatalog, log).Delete(cm.bufmgr, [][]byte{tableIDBytes})
+}
+
+func (cm *CatalogManager) insertColumnRecord(log btree.PageLog, tableID uint32, columnIndex int, col ColumnDef) error {
+	return withLog(cm.columnsCatalog, log).Insert(cm.bufmgr, columnRecord(tableID, columnIndex, col))
+}
+
+// withLog returns a catalog table whose changes are written through log (nil does not log them).
+func withLog(t *table.Table, log btree.PageLog) *table.Table {
+	if log == nil {
+		return t
+	}
+	logged := *t
+	logged.Log = log
+	return &logged
 }
 
-func (cm *CatalogManager) insertColumnRecord(tableID uint32, columnIndex int, col ColumnDef) error {
+// columnRecord returns the columns_catalog record of a column.
+func columnRecord(tableID uint32, columnIndex int, col ColumnDef) [][]byte {
 	tableIDBytes := make([]byte, 4)
 	binary.BigEndian.PutUint32(tableIDBytes, tableID)
 
@@ -223,7 +738,30 @@ func (cm *CatalogManager) insertColumnRecord(tableID uint32, columnIndex int, co
 		isPrimaryKeyBytes[0] = 0
 	}
 
-	tup := [][]byte{
+	hasDefaultBytes := make([]byte, 1)
+	if col.Default != nil {
+		hasDefaultBytes[0] = 1
+	}
+
+	// 0: plain, 1: randomized, 2: deterministic
+	encryptionBytes := make([]byte, 13)
+	if enc := col.Encryption; enc != nil {
+		encryptionBytes[0] = 1
+		if enc.Deterministic {
+			encryptionBytes[0] = 2
+		}
+		binary.BigEndian.PutUint32(encryptionBytes[1:], enc.KeyID)
+		binary.BigEndian.PutUint64(encryptionBytes[5:], uint64(enc.Decrypt))
+	}
+
+	droppedBytes := make([]byte, 1)
+	if col.Dropped {
+		droppedBytes[0] = 1
+	}
+
+	checkBytes := encodeCheck(col.Check)
+
+	return [][]byte{
 		tableIDBytes,      // PK part 1
 		columnIndexBytes,  // PK part 2
 		[]byte(col.Name),  // column_name
@@ -231,69 +769,68 @@ func (cm *CatalogManager) insertColumnRecord(tableID uint32, columnIndex int, co
 		columnSizeBytes,   // column_size
 		nullableBytes,     // nullable
 		isPrimaryKeyBytes, // is_primary_key
+		hasDefaultBytes,   // has_default
+		col.Default,       // column_default
+		encryptionBytes,   // encryption, key_id, decrypt_privileges
+		droppedBytes,      // dropped
+		checkBytes,        // check
 	}
-
-	return cm.columnsCatalog.Insert(cm.bufmgr, tup)
 }
 
-func (cm *CatalogManager) insertTableRecord(
-	tableID uint32,
-	tableName string,
-	metaPageID disk.PageID,
-	numKeyElems int,
-) error {
+// tableRecord returns the tables_catalog record of a table.
+func tableRecord(schema *TableSchema) [][]byte {
 	tableIDBytes := make([]byte, 4)
-	binary.BigEndian.PutUint32(tableIDBytes, tableID)
+	binary.BigEndian.PutUint32(tableIDBytes, schema.TableID)
 
 	metaPageIDBytes := make([]byte, 8)
-	binary.BigEndian.PutUint64(metaPageIDBytes, uint64(metaPageID))
+	binary.BigEndian.PutUint64(metaPageIDBytes, uint64(schema.MetaPageID))
 
 	numKeyElemsBytes := make([]byte, 4)
-	binary.BigEndian.PutUint32(numKeyElemsBytes, uint32(numKeyElems))
+	binary.BigEndian.PutUint32(numKeyElemsBytes, uint32(schema.NumKeyElems))
 
-	tup := [][]byte{
-		tableIDBytes,      // PK
-		[]byte(tableName), // table_name
-		metaPageIDBytes,   // meta_page_id
-		numKeyElemsBytes,  // num_key_elems
+	// Empty unless the table is versioned
+	var versionsMetaPageIDBytes []byte
+	if schema.Versioned {
+		versionsMetaPageIDBytes = make([]byte, 8)
+		binary.BigEndian.PutUint64(versionsMetaPageIDBytes, uint64(schema.VersionsMetaPageID))
 	}
 
-	return cm.tablesCatalog.Insert(cm.bufmgr, tup)
+	return [][]byte{
+		tableIDBytes,             // PK
+		[]byte(schema.TableName), // table_name
+		metaPageIDBytes,          // meta_page_id
+		numKeyElemsBytes,         // num_key_elems
+		{byte(schema.Format)},    // row_format
+		versionsMetaPageIDBytes,  // versions_meta_page_id
+	}
 }
 
 func (cm *CatalogManager) tableExistsInCatalog(tableName string) bool {
-	_, _, _, err := cm.findTableInCatalog(tableName)
+	_, err := cm.findTableRecord(tableName)
 	return err == nil
 }
 
-func (cm *CatalogManager) findTableInCatalog(tableName string) (uint32, disk.PageID, int, error) {
-	bt := btree.NewBTree(cm.tablesCatalog.MetaPageID)
-	iter, err := bt.Search(cm.bufmgr, btree.NewSearchModeStart())
-	if err != nil {
-		return 0, disk.InvalidPageID, 0, err
+// SetSecurityPolicy registers the row security and column masking policy of a table.
+// A nil policy removes it.
+func (cm *CatalogManager) SetSecurityPolicy(tableName string, policy *SecurityPolicy) error {
+	tableName = canonicalTableName(tableName)
+	cm.mu.Lock()
+	defer cm.mu.Unlock()
+	if _, err := cm.cachedSchema(tableName); err != nil {
+		return err
 	}
-
-	for {
-		keyBytes, valueBytes, ok, err := iter.Next(cm.bufmgr)
-		if err != nil {
-			return 0, disk.InvalidPageID, 0, err
-		}
-		if !ok {
-			break
-		}
-
-		var keyElems [][]byte
-		tuple.Decode(keyBytes, &keyElems)
-		var valueElems [][]byte
-		tuple.Decode(valueBytes, &valueElems)
-
-		if 0 < len(valueElems) && string(valueElems[0]) == tableName {
-			tableID := binary.BigEndian.Uint32(keyElems[0])
-			metaPageID := disk.PageID(binary.BigEndian.Uint32(keyElems[1]))
-			numKeyElements := int(binary.BigEndian.Uint32(keyElems[2]))
-			return tableID, metaPageID, numKeyElements, nil
-		}
+	if policy == nil {
+		delete(cm.policies, tableName)
+	} else {
+		cm.policies[tableName] = policy
 	}
+	return nil
+}
 
-	return 0, disk.InvalidPageID, 0, ErrTableNotFound
+// SecurityPolicy returns the policy of a table, or nil if it has none.
+func (cm *CatalogManager) SecurityPolicy(tableName string) *SecurityPolicy {
+	tableName = canonicalTableName(tableName)
+	cm.mu.RLock()
+	defer cm.mu.RUnlock()
+	return cm.policies[tableName]
 }
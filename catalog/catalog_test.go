@@ -1 +1,671 @@
 package catalog
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/Johniel/gorelly/btree"
+	"github.com/Johniel/gorelly/buffer"
+	"github.com/Johniel/gorelly/disk"
+	"github.com/Johniel/gorelly/table"
+	"github.com/Johniel/gorelly/tuple"
+)
+
+func TestSecurityPolicy(t *testing.T) {
+	tmpfile, err := os.CreateTemp("", "test_security_policy_*.db")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(tmpfile.Name())
+
+	dm, err := disk.NewDiskManager(tmpfile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer dm.Close()
+
+	pool := buffer.NewBufferPool(10)
+	bufmgr := buffer.NewBufferPoolManager(dm, pool)
+
+	cm, err := NewCatalogManager(bufmgr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := cm.CreateTable("users", []ColumnDef{
+		{Name: "id", Type: ColumnTypeInt, IsPrimaryKey: true},
+		{Name: "email", Type: ColumnTypeVarchar},
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	policy := &SecurityPolicy{
+		ColumnMasks: []ColumnMask{{ColumnIndex: 1, Mask: func([]byte) []byte { return nil }, Unmasked: 1}},
+	}
+	if err := cm.SetSecurityPolicy("missing", policy); err != ErrTableNotFound {
+		t.Errorf("expected ErrTableNotFound, got %v", err)
+	}
+	if err := cm.SetSecurityPolicy("users", policy); err != nil {
+		t.Fatal(err)
+	}
+	if cm.SecurityPolicy("users") != policy {
+		t.Errorf("expected the registered policy")
+	}
+
+	tup := [][]byte{[]byte("1"), []byte("a@example.com")}
+	masked, ok := cm.SecurityPolicy("users").Apply(tup, 0)
+	if !ok || masked[1] != nil || string(tup[1]) != "a@example.com" {
+		t.Errorf("expected a masked copy, got %q (source %q)", masked, tup)
+	}
+
+	if err := cm.SetSecurityPolicy("users", nil); err != nil {
+		t.Fatal(err)
+	}
+	if cm.SecurityPolicy("users") != nil {
+		t.Errorf("expected the policy to be removed")
+	}
+}
+
+func TestRolesAndGrants(t *testing.T) {
+	tmpfile, err := os.CreateTemp("", "test_roles_and_grants_*.db")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(tmpfile.Name())
+
+	dm, err := disk.NewDiskManager(tmpfile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer dm.Close()
+
+	pool := buffer.NewBufferPool(10)
+	bufmgr := buffer.NewBufferPoolManager(dm, pool)
+
+	cm, err := NewCatalogManager(bufmgr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	schema, err := cm.CreateTable("orders", []ColumnDef{
+		{Name: "id", Type: ColumnTypeInt, IsPrimaryKey: true},
+		{Name: "item", Type: ColumnTypeVarchar},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := cm.CreateRole("admin", true); err != nil {
+		t.Fatal(err)
+	}
+	if err := cm.CreateRole("clerk", false); err != nil {
+		t.Fatal(err)
+	}
+	if err := cm.CreateRole("clerk", false); err != ErrRoleExists {
+		t.Errorf("expected ErrRoleExists, got %v", err)
+	}
+
+	t.Run("Grants", func(t *testing.T) {
+		if err := cm.CheckPrivileges("clerk", "orders", PrivilegeSelect); err != ErrPermissionDenied {
+			t.Errorf("expected ErrPermissionDenied, got %v", err)
+		}
+		if err := cm.Grant("clerk", "orders", PrivilegeSelect|PrivilegeInsert); err != nil {
+			t.Fatal(err)
+		}
+		if err := cm.Grant("clerk", "orders", PrivilegeUpdate); err != nil {
+			t.Fatal(err)
+		}
+		if err := cm.Revoke("clerk", "orders", PrivilegeInsert); err != nil {
+			t.Fatal(err)
+		}
+		privs, err := cm.TablePrivileges("clerk", "orders")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if privs != PrivilegeSelect|PrivilegeUpdate {
+			t.Errorf("expected SELECT|UPDATE, got %b", privs)
+		}
+		if err := cm.CheckPrivileges("admin", "orders", PrivilegeDelete); err != nil {
+			t.Errorf("expected superuser to pass, got %v", err)
+		}
+		if _, err := cm.TablePrivileges("nobody", "orders"); err != ErrRoleNotFound {
+			t.Errorf("expected ErrRoleNotFound, got %v", err)
+		}
+		if err := cm.Grant("clerk", "missing", PrivilegeSelect); err != ErrTableNotFound {
+			t.Errorf("expected ErrTableNotFound, got %v", err)
+		}
+	})
+
+	t.Run("Authenticate", func(t *testing.T) {
+		if err := cm.Authenticate("clerk", ""); err != ErrAuthenticationFailed {
+			t.Errorf("expected ErrAuthenticationFailed without a password, got %v", err)
+		}
+		if err := cm.SetPassword("clerk", "secret"); err != nil {
+			t.Fatal(err)
+		}
+		if err := cm.Authenticate("clerk", "secret"); err != nil {
+			t.Errorf("expected the password to authenticate, got %v", err)
+		}
+		if err := cm.Authenticate("clerk", "guess"); err != ErrAuthenticationFailed {
+			t.Errorf("expected ErrAuthenticationFailed for a wrong password, got %v", err)
+		}
+		if err := cm.Authenticate("nobody", "secret"); err != ErrAuthenticationFailed {
+			t.Errorf("expected ErrAuthenticationFailed for an unknown role, got %v", err)
+		}
+		if err := cm.SetPassword("nobody", "secret"); err != ErrRoleNotFound {
+			t.Errorf("expected ErrRoleNotFound, got %v", err)
+		}
+		// The role keeps its privileges
+		if err := cm.CheckPrivileges("clerk", "orders", PrivilegeSelect); err != nil {
+			t.Error(err)
+		}
+	})
+
+	t.Run("TableAccess", func(t *testing.T) {
+		orders := &table.Table{
+			MetaPageID:  schema.MetaPageID,
+			NumKeyElems: schema.NumKeyElems,
+			Access:      cm.TableAccess("clerk", "orders"),
+		}
+		if err := orders.Insert(bufmgr, [][]byte{[]byte("1"), []byte("pen")}); err != ErrPermissionDenied {
+			t.Errorf("expected ErrPermissionDenied, got %v", err)
+		}
+		if err := cm.Grant("clerk", "orders", PrivilegeInsert); err != nil {
+			t.Fatal(err)
+		}
+		if err := orders.Insert(bufmgr, [][]byte{[]byte("1"), []byte("pen")}); err != nil {
+			t.Fatal(err)
+		}
+		if err := orders.Update(bufmgr, [][]byte{[]byte("1"), []byte("ink")}); err != nil {
+			t.Fatal(err)
+		}
+		if err := orders.Delete(bufmgr, [][]byte{[]byte("1")}); err != ErrPermissionDenied {
+			t.Errorf("expected ErrPermissionDenied, got %v", err)
+		}
+		if err := cm.Revoke("clerk", "orders", AllPrivileges); err != nil {
+			t.Fatal(err)
+		}
+		if privs, err := cm.TablePrivileges("clerk", "orders"); err != nil || privs != 0 {
+			t.Errorf("expected no privileges, got %b (%v)", privs, err)
+		}
+	})
+}
+
+func TestReencryptZone(t *testing.T) {
+	tmpfile, err := os.CreateTemp("", "test_reencrypt_zone_*.db")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(tmpfile.Name())
+
+	keyring := disk.NewKeyring()
+	if err := keyring.AddKey("", 1, bytes.Repeat([]byte{1}, 16)); err != nil {
+		t.Fatal(err)
+	}
+	dm, err := disk.NewEncryptedDiskManager(tmpfile, keyring)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer dm.Close()
+
+	pool := buffer.NewBufferPool(10)
+	bufmgr := buffer.NewBufferPoolManager(dm, pool)
+
+	cm, err := NewCatalogManager(bufmgr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := bufmgr.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	numPages := dm.NumPages()
+
+	if err := keyring.AddKey("", 2, bytes.Repeat([]byte{2}, 16)); err != nil {
+		t.Fatal(err)
+	}
+	if err := keyring.SetActiveKey(2); err != nil {
+		t.Fatal(err)
+	}
+
+	done, err := cm.ReencryptZone(dm, keyring, "", 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if done {
+		t.Fatal("expected the rotation to be unfinished after 2 pages")
+	}
+	rotation, found, err := cm.KeyRotation("")
+	if err != nil || !found {
+		t.Fatalf("expected recorded progress, got %v (%v)", found, err)
+	}
+	if rotation.KeyID != 2 || rotation.NextPageID != 2 {
+		t.Errorf("expected key 2 at page 2, got %+v", rotation)
+	}
+
+	// Resume from the recorded progress
+	done, err = cm.ReencryptZone(dm, keyring, "", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !done {
+		t.Fatal("expected the rotation to be finished")
+	}
+	if err := bufmgr.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	for pageID := disk.PageID(0); pageID < dm.NumPages(); pageID++ {
+		if keyID, err := dm.PageKeyID(pageID); err != nil || keyID != 2 {
+			t.Errorf("page %d: expected key 2, got %d (%v)", pageID, keyID, err)
+		}
+	}
+	if err := keyring.RemoveKey(1); err != nil {
+		t.Fatal(err)
+	}
+	rotation, _, err = cm.KeyRotation("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rotation.NextPageID < numPages {
+		t.Errorf("expected the progress to cover the %d pages, got %+v", numPages, rotation)
+	}
+}
+
+func TestAdviseIndexes(t *testing.T) {
+	tmpfile, err := os.CreateTemp("", "test_advise_indexes_*.db")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(tmpfile.Name())
+
+	dm, err := disk.NewDiskManager(tmpfile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer dm.Close()
+
+	pool := buffer.NewBufferPool(10)
+	bufmgr := buffer.NewBufferPoolManager(dm, pool)
+
+	cm, err := NewCatalogManager(bufmgr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := cm.CreateTable("orders", []ColumnDef{
+		{Name: "id", Type: ColumnTypeInt, IsPrimaryKey: true},
+		{Name: "customer", Type: ColumnTypeInt},
+		{Name: "status", Type: ColumnTypeVarchar},
+		{Name: "created_at", Type: ColumnTypeTimestamp},
+		{Name: "total", Type: ColumnTypeInt},
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	w := NewWorkload()
+	// Served by the primary key
+	w.Record(WorkloadQuery{Table: "orders", EqualityColumns: []string{"id"}, Count: 100, RowsScanned: 100, RowsReturned: 100})
+	w.Record(WorkloadQuery{Table: "orders", RangeColumns: []string{"id"}, Count: 1, RowsScanned: 1000, RowsReturned: 10})
+	// Two shapes sharing the same index
+	w.Record(WorkloadQuery{
+		Table:           "orders",
+		EqualityColumns: []string{"customer", "status"},
+		RangeColumns:    []string{"created_at"},
+		Projected:       []string{"id", "total"},
+		Count:           10, RowsScanned: 10000, RowsReturned: 50,
+	})
+	w.Record(WorkloadQuery{
+		Table:           "orders",
+		EqualityColumns: []string{"customer", "status"},
+		RangeColumns:    []string{"created_at"},
+		Projected:       []string{"status"},
+		Count:           5, RowsScanned: 5000, RowsReturned: 50,
+	})
+	w.Record(WorkloadQuery{Table: "orders", EqualityColumns: []string{"total"}, Count: 1, RowsScanned: 1000, RowsReturned: 1})
+
+	suggestions, err := cm.AdviseIndexes(w)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(suggestions) != 2 {
+		t.Fatalf("expected 2 suggestions, got %+v", suggestions)
+	}
+	first := suggestions[0]
+	if first.Table != "orders" || strings.Join(first.Columns, ",") != "customer,status,created_at" {
+		t.Errorf("unexpected columns %v", first.Columns)
+	}
+	if strings.Join(first.Include, ",") != "total" {
+		t.Errorf("expected total as the only include column, got %v", first.Include)
+	}
+	if first.Benefit != 9950+4950 || first.Queries != 2 {
+		t.Errorf("unexpected benefit %d over %d queries", first.Benefit, first.Queries)
+	}
+	if strings.Join(suggestions[1].Columns, ",") != "total" || suggestions[1].Benefit != 999 {
+		t.Errorf("unexpected second suggestion %+v", suggestions[1])
+	}
+
+	t.Run("ExistingIndex", func(t *testing.T) {
+		cm.schemaCache["orders"].Indexes = append(cm.schemaCache["orders"].Indexes, IndexDef{
+			IndexName:     "orders_status_customer",
+			ColumnIndices: []int{2, 1},
+		})
+		defer func() { cm.schemaCache["orders"].Indexes = nil }()
+
+		suggestions, err := cm.AdviseIndexes(w)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(suggestions) != 1 || suggestions[0].Columns[0] != "total" {
+			t.Errorf("expected only the total index, got %+v", suggestions)
+		}
+	})
+
+	t.Run("UnknownColumn", func(t *testing.T) {
+		w := NewWorkload()
+		w.Record(WorkloadQuery{Table: "orders", EqualityColumns: []string{"missing"}})
+		if _, err := cm.AdviseIndexes(w); err != ErrColumnNotFound {
+			t.Errorf("expected ErrColumnNotFound, got %v", err)
+		}
+	})
+}
+
+func TestDropTable(t *testing.T) {
+	tmpfile, err := os.CreateTemp("", "test_drop_table_*.db")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(tmpfile.Name())
+
+	dm, err := disk.NewDiskManager(tmpfile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer dm.Close()
+
+	pool := buffer.NewBufferPool(10)
+	bufmgr := buffer.NewBufferPoolManager(dm, pool)
+
+	cm, err := NewCatalogManager(bufmgr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	columns := []ColumnDef{
+		{Name: "id", Type: ColumnTypeInt, IsPrimaryKey: true},
+		{Name: "payload", Type: ColumnTypeBlob},
+	}
+	schema, err := cm.CreateTable("events", columns)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tbl := &table.Table{MetaPageID: schema.MetaPageID, NumKeyElems: schema.NumKeyElems}
+	for i := 0; i < 500; i++ {
+		row, err := schema.BindRow(i, bytes.Repeat([]byte{'x'}, 64))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := tbl.Insert(bufmgr, row); err != nil {
+			t.Fatal(err)
+		}
+	}
+	stats, err := btree.NewBTree(schema.MetaPageID).Stats(bufmgr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	numPages := dm.NumPages()
+
+	if err := cm.DropTable("missing"); err != ErrTableNotFound {
+		t.Errorf("expected ErrTableNotFound, got %v", err)
+	}
+	if err := cm.DropTable("public.events"); err != nil {
+		t.Fatal(err)
+	}
+	// The meta page is freed with the pages of the tree
+	if got, expected := dm.NumFreePages(), stats.LeafPages+stats.BranchPages+1; got != expected {
+		t.Errorf("expected %d free pages, got %d", expected, got)
+	}
+	if _, _, err := cm.AcquireSchema("events"); err != ErrTableNotFound {
+		t.Errorf("expected the dropped table to be gone, got %v", err)
+	}
+
+	// A new table reuses the freed pages
+	if _, err := cm.CreateTable("events", columns); err != nil {
+		t.Fatal(err)
+	}
+	if dm.NumPages() != numPages {
+		t.Errorf("expected the new table to reuse freed pages, the heap grew from %d to %d pages", numPages, dm.NumPages())
+	}
+}
+
+func TestDropIndex(t *testing.T) {
+	tmpfile, err := os.CreateTemp("", "test_drop_index_*.db")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(tmpfile.Name())
+
+	dm, err := disk.NewDiskManager(tmpfile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer dm.Close()
+
+	pool := buffer.NewBufferPool(10)
+	bufmgr := buffer.NewBufferPoolManager(dm, pool)
+
+	cm, err := NewCatalogManager(bufmgr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	schema, err := cm.CreateTable("users", []ColumnDef{
+		{Name: "id", Type: ColumnTypeInt, IsPrimaryKey: true},
+		{Name: "email", Type: ColumnTypeVarchar},
+		{Name: "name", Type: ColumnTypeVarchar},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Unique indexes on email and name, recorded in indexes_catalog
+	var indexes []IndexDef
+	for i, name := range []string{"users_email", "users_name"} {
+		ui := &table.UniqueIndex{Skey: []int{i + 1}}
+		if err := ui.Create(bufmgr); err != nil {
+			t.Fatal(err)
+		}
+		index := IndexDef{IndexID: uint32(100 + i), IndexName: name, TableID: schema.TableID, MetaPageID: ui.MetaPageID, IsUnique: true, ColumnIndices: []int{i + 1}}
+		if err := cm.indexesCatalog.Insert(bufmgr, indexRecord(index)); err != nil {
+			t.Fatal(err)
+		}
+		indexes = append(indexes, index)
+	}
+	cm.schemaCache["users"].Indexes = indexes
+
+	if err := cm.DropIndex("users", "missing"); err != ErrIndexNotFound {
+		t.Errorf("expected ErrIndexNotFound, got %v", err)
+	}
+	if err := cm.DropIndex("missing", "users_email"); err != ErrTableNotFound {
+		t.Errorf("expected ErrTableNotFound, got %v", err)
+	}
+	if err := cm.DropIndex("users", "users_email"); err != nil {
+		t.Fatal(err)
+	}
+	// The meta and root pages of the index are freed
+	if dm.NumFreePages() != 2 {
+		t.Errorf("expected 2 free pages, got %d", dm.NumFreePages())
+	}
+	tbl, err := cm.OpenTable("users")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(tbl.UniqueIndices) != 1 || tbl.UniqueIndices[0].MetaPageID != indexes[1].MetaPageID {
+		t.Errorf("expected only users_name to remain, got %+v", tbl.UniqueIndices)
+	}
+	// The catalog records are gone as well
+	reopened, err := OpenCatalogManager(bufmgr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	loaded, err := reopened.GetTableSchema("users")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(loaded.Indexes) != 1 || loaded.Indexes[0].IndexName != "users_name" {
+		t.Errorf("expected only users_name to remain, got %+v", loaded.Indexes)
+	}
+
+	// Dropping the table drops its remaining indexes
+	if err := cm.DropTable("users"); err != nil {
+		t.Fatal(err)
+	}
+	if dm.NumFreePages() != 6 {
+		t.Errorf("expected 6 free pages, got %d", dm.NumFreePages())
+	}
+	err = cm.scanCatalog(cm.indexesCatalog, nil, func(tup [][]byte) (bool, error) {
+		t.Errorf("expected no index records, got %q", tup[1])
+		return true, nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestCreateIndex(t *testing.T) {
+	tmpfile, err := os.CreateTemp("", "test_create_index_*.db")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(tmpfile.Name())
+
+	dm, err := disk.NewDiskManager(tmpfile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer dm.Close()
+
+	pool := buffer.NewBufferPool(10)
+	bufmgr := buffer.NewBufferPoolManager(dm, pool)
+
+	cm, err := NewCatalogManager(bufmgr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := cm.CreateTable("users", []ColumnDef{
+		{Name: "id", Type: ColumnTypeVarchar, IsPrimaryKey: true},
+		{Name: "email", Type: ColumnTypeVarchar},
+		{Name: "city", Type: ColumnTypeVarchar},
+	}); err != nil {
+		t.Fatal(err)
+	}
+	users, err := cm.OpenTable("users")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, row := range [][]string{{"1", "alice@example.com", "Paris"}, {"2", "bob@example.com", "Tokyo"}, {"3", "carol@example.com", "Paris"}} {
+		if err := users.Insert(bufmgr, [][]byte{[]byte(row[0]), []byte(row[1]), []byte(row[2])}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// Rows stored before the column was added are indexed with its default
+	if _, err := cm.AddColumn("users", ColumnDef{Name: "plan", Type: ColumnTypeVarchar, Default: []byte("free")}); err != nil {
+		t.Fatal(err)
+	}
+
+	// entries returns the keys of an index, decoded, with the primary keys they map to
+	entries := func(index IndexDef) []string {
+		t.Helper()
+		iter, err := btree.NewBTree(index.MetaPageID).Search(bufmgr, btree.NewSearchModeStart())
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer iter.Close()
+		var result []string
+		for {
+			key, pkey, ok, err := iter.Next(bufmgr)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !ok {
+				return result
+			}
+			var skey, pk [][]byte
+			tuple.Decode(key, &skey)
+			tuple.Decode(pkey, &pk)
+			result = append(result, fmt.Sprintf("%s=%s", bytes.Join(skey, []byte(",")), pk[0]))
+		}
+	}
+
+	email, err := cm.CreateIndex("users", []string{"email"}, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if email.IndexName != "users_email_key" || !reflect.DeepEqual(email.ColumnIndices, []int{1}) {
+		t.Errorf("unexpected index %+v", email)
+	}
+	if got := entries(email); !reflect.DeepEqual(got, []string{"alice@example.com=1", "bob@example.com=2", "carol@example.com=3"}) {
+		t.Errorf("unexpected entries %q", got)
+	}
+	city, err := cm.CreateIndex("public.users", []string{"city"}, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if city.IndexName != "users_city_idx" || city.IsUnique {
+		t.Errorf("unexpected index %+v", city)
+	}
+	if got := entries(city); !reflect.DeepEqual(got, []string{"Paris,1=1", "Paris,3=3", "Tokyo,2=2"}) {
+		t.Errorf("unexpected entries %q", got)
+	}
+
+	free := dm.NumFreePages()
+	if _, err := cm.CreateIndex("users", []string{"plan"}, true); err != btree.ErrDuplicateKey {
+		t.Errorf("expected ErrDuplicateKey, got %v", err)
+	}
+	if dm.NumFreePages() <= free {
+		t.Errorf("expected the pages of the failed index to be freed, got %d free pages", dm.NumFreePages())
+	}
+	if _, err := cm.CreateIndex("users", []string{"email"}, true); err != ErrIndexExists {
+		t.Errorf("expected ErrIndexExists, got %v", err)
+	}
+	if _, err := cm.CreateIndex("users", []string{"missing"}, false); err != ErrColumnNotFound {
+		t.Errorf("expected ErrColumnNotFound, got %v", err)
+	}
+	if _, err := cm.CreateIndex("missing", []string{"email"}, false); err != ErrTableNotFound {
+		t.Errorf("expected ErrTableNotFound, got %v", err)
+	}
+	schema, err := cm.GetTableSchema("users")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(schema.Indexes) != 2 || schema.Indexes[0].IndexID == schema.Indexes[1].IndexID {
+		t.Errorf("expected the two indexes with distinct IDs, got %+v", schema.Indexes)
+	}
+
+	// Tables opened afterwards maintain the indexes
+	users, err = cm.OpenTable("users")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := users.Insert(bufmgr, [][]byte{[]byte("4"), []byte("alice@example.com"), []byte("Oslo"), []byte("pro")}); !errors.Is(err, btree.ErrDuplicateKey) {
+		t.Errorf("expected ErrDuplicateKey, got %v", err)
+	}
+	if err := users.Insert(bufmgr, [][]byte{[]byte("4"), []byte("dave@example.com"), []byte("Paris"), []byte("pro")}); err != nil {
+		t.Fatal(err)
+	}
+	if err := users.Delete(bufmgr, [][]byte{[]byte("1"), []byte("alice@example.com"), []byte("Paris"), []byte("free")}); err != nil {
+		t.Fatal(err)
+	}
+	if got := entries(city); !reflect.DeepEqual(got, []string{"Paris,3=3", "Paris,4=4", "Tokyo,2=2"}) {
+		t.Errorf("unexpected entries %q", got)
+	}
+
+	// The indexes are recorded in indexes_catalog
+	reopened, err := OpenCatalogManager(bufmgr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	loaded, err := reopened.GetTableSchema("users")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(loaded.Indexes, schema.Indexes) {
+		t.Errorf("expected %+v, got %+v", schema.Indexes, loaded.Indexes)
+	}
+}
@@ -0,0 +1,158 @@
+package catalog
+
+import (
+	"bytes"
+
+	"github.com/Johniel/gorelly/errcode"
+	"github.com/Johniel/gorelly/table"
+	"github.com/Johniel/gorelly/tuple"
+)
+
+// ErrInvalidCheck is returned when creating a column whose CHECK constraint cannot be evaluated,
+// or whose default does not satisfy it.
+var ErrInvalidCheck = errcode.New(errcode.InvalidParameter, "invalid check constraint")
+
+// CheckOp is the comparison operator of a CheckCond.
+type CheckOp uint8
+
+const (
+	CheckEq CheckOp = iota
+	CheckNe
+	CheckLt
+	CheckLe
+	CheckGt
+	CheckGe
+)
+
+// String returns the SQL symbol of the operator, e.g. "<=".
+func (op CheckOp) String() string {
+	switch op {
+	case CheckEq:
+		return "="
+	case CheckNe:
+		return "<>"
+	case CheckLt:
+		return "<"
+	case CheckLe:
+		return "<="
+	case CheckGt:
+		return ">"
+	case CheckGe:
+		return ">="
+	default:
+		return "?"
+	}
+}
+
+// CheckCond is a condition of the CHECK constraint of a column: the value of the column compared
+// with Value, e.g. CHECK (age >= 0). Value is bound like the values of the column (see
+// ColumnDef.Bind), so that the byte-wise comparison matches the order of the values.
+type CheckCond struct {
+	Op    CheckOp
+	Value []byte
+}
+
+// holds reports whether a non-NULL value of the column satisfies the condition.
+func (c CheckCond) holds(value []byte) bool {
+	cmp := bytes.Compare(value, c.Value)
+	switch c.Op {
+	case CheckEq:
+		return cmp == 0
+	case CheckNe:
+		return cmp != 0
+	case CheckLt:
+		return cmp < 0
+	case CheckLe:
+		return cmp <= 0
+	case CheckGt:
+		return 0 < cmp
+	case CheckGe:
+		return 0 <= cmp
+	default:
+		return false
+	}
+}
+
+// satisfies reports whether a value satisfies all of the conditions of the column's Check.
+// NULL satisfies every check, as in SQL; NOT NULL is Nullable's job.
+func (cd ColumnDef) satisfies(value []byte) bool {
+	if len(value) == 0 {
+		return true
+	}
+	for _, cond := range cd.Check {
+		if !cond.holds(value) {
+			return false
+		}
+	}
+	return true
+}
+
+// checkColumnConstraints returns ErrInvalidCheck if the check of a column has an unknown
+// operator, compares encrypted values or is not satisfied by the column's default, and
+// ErrInvalidColumn if the default of an encrypted column would be read as a value to decrypt.
+func checkColumnConstraints(columns []ColumnDef) error {
+	for _, col := range columns {
+		if col.Encryption != nil && col.Default != nil {
+			return ErrInvalidColumn
+		}
+		if len(col.Check) == 0 {
+			continue
+		}
+		if col.Encryption != nil || !col.satisfies(col.Default) {
+			return ErrInvalidCheck
+		}
+		for _, cond := range col.Check {
+			if CheckGe < cond.Op {
+				return ErrInvalidCheck
+			}
+		}
+	}
+	return nil
+}
+
+// checks returns the CHECK constraints of the columns of the table, named
+// "<table>_<column>_check". Dropped columns have none.
+func (ts *TableSchema) checks() []*table.Check {
+	_, tableName := SplitTableName(ts.TableName)
+	var checks []*table.Check
+	for i, col := range ts.Columns {
+		if len(col.Check) == 0 || col.Dropped {
+			continue
+		}
+		checks = append(checks, &table.Check{
+			Name: tableName + "_" + col.Name + "_check",
+			Holds: func(tup [][]byte) bool {
+				return len(tup) <= i || col.satisfies(tup[i])
+			},
+		})
+	}
+	return checks
+}
+
+// encodeCheck encodes the conditions of a check for the columns_catalog record of the column.
+func encodeCheck(conds []CheckCond) []byte {
+	elems := make([][]byte, 0, 2*len(conds))
+	for _, cond := range conds {
+		elems = append(elems, []byte{byte(cond.Op)}, cond.Value)
+	}
+	b := make([]byte, 0)
+	tuple.Encode(elems, &b)
+	return b
+}
+
+// decodeCheck decodes conditions encoded by encodeCheck.
+func decodeCheck(b []byte) ([]CheckCond, error) {
+	var elems [][]byte
+	tuple.Decode(b, &elems)
+	if len(elems)%2 != 0 {
+		return nil, errMalformedRecord
+	}
+	var conds []CheckCond
+	for i := 0; i < len(elems); i += 2 {
+		if len(elems[i]) != 1 {
+			return nil, errMalformedRecord
+		}
+		conds = append(conds, CheckCond{Op: CheckOp(elems[i][0]), Value: elems[i+1]})
+	}
+	return conds, nil
+}
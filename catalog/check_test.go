@@ -0,0 +1,133 @@
+package catalog
+
+import (
+	"errors"
+	"os"
+	"reflect"
+	"testing"
+
+	"github.com/Johniel/gorelly/buffer"
+	"github.com/Johniel/gorelly/disk"
+	"github.com/Johniel/gorelly/errcode"
+	"github.com/Johniel/gorelly/table"
+	"github.com/Johniel/gorelly/types"
+)
+
+func TestColumnDefaultsAndChecks(t *testing.T) {
+	tmpfile, err := os.CreateTemp("", "test_column_checks_*.db")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(tmpfile.Name())
+
+	dm, err := disk.NewDiskManager(tmpfile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer dm.Close()
+	bufmgr := buffer.NewBufferPoolManager(dm, buffer.NewBufferPool(10))
+	cm, err := NewCatalogManager(bufmgr)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ageCheck := []CheckCond{{Op: CheckGe, Value: types.EncodeInt(0)}, {Op: CheckLe, Value: types.EncodeInt(150)}}
+	for _, col := range []ColumnDef{
+		{Name: "age", Type: ColumnTypeInt, Default: types.EncodeInt(-1), Check: ageCheck},
+		{Name: "age", Type: ColumnTypeInt, Check: []CheckCond{{Op: CheckGe + 1, Value: types.EncodeInt(0)}}},
+		{Name: "ssn", Type: ColumnTypeVarchar, Check: []CheckCond{{Op: CheckNe, Value: []byte("000")}}, Encryption: &ColumnEncryption{KeyID: 1, Deterministic: true}},
+	} {
+		columns := []ColumnDef{{Name: "id", Type: ColumnTypeInt, IsPrimaryKey: true}, col}
+		if _, err := cm.CreateTable("invalid", columns); err != ErrInvalidCheck {
+			t.Errorf("%+v: expected ErrInvalidCheck, got %v", col, err)
+		}
+	}
+
+	_, err = cm.CreateTable("people", []ColumnDef{
+		{Name: "id", Type: ColumnTypeInt, IsPrimaryKey: true},
+		{Name: "age", Type: ColumnTypeInt, Nullable: true, Check: ageCheck},
+		{Name: "status", Type: ColumnTypeVarchar, Default: []byte("active"), Check: []CheckCond{{Op: CheckNe, Value: []byte("banned")}}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	people, err := cm.OpenTable("people")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Trailing columns are filled with their defaults
+	if err := people.Insert(bufmgr, [][]byte{types.EncodeInt(1), types.EncodeInt(36)}); err != nil {
+		t.Fatal(err)
+	}
+	got, err := people.Get(bufmgr, [][]byte{types.EncodeInt(1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := [][]byte{types.EncodeInt(1), types.EncodeInt(36), []byte("active")}; !reflect.DeepEqual(got, want) {
+		t.Errorf("expected %q, got %q", want, got)
+	}
+	// NULL satisfies the check
+	if err := people.Insert(bufmgr, [][]byte{types.EncodeInt(2)}); err != nil {
+		t.Fatal(err)
+	}
+
+	schema, err := cm.GetTableSchema("people")
+	if err != nil {
+		t.Fatal(err)
+	}
+	row, err := schema.BindRow(3, 200, "active")
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = people.Insert(bufmgr, row)
+	var violation *table.CheckViolationError
+	if !errors.As(err, &violation) || violation.Check != "people_age_check" || errcode.Of(err) != errcode.ConstraintViolation {
+		t.Fatalf("expected a violation of people_age_check, got %v", err)
+	}
+	row, err = schema.BindRow(1, 36, "banned")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := people.Update(bufmgr, row); !errors.As(err, &violation) || violation.Check != "people_status_check" {
+		t.Errorf("expected a violation of people_status_check, got %v", err)
+	}
+
+	// Columns added later get the same treatment, and their default must pass their check
+	if _, err := cm.AddColumn("people", ColumnDef{Name: "score", Type: ColumnTypeInt, Default: types.EncodeInt(-5), Check: ageCheck}); err != ErrInvalidCheck {
+		t.Errorf("expected ErrInvalidCheck, got %v", err)
+	}
+	if _, err := cm.AddColumn("people", ColumnDef{Name: "score", Type: ColumnTypeInt, Default: types.EncodeInt(0), Check: ageCheck}); err != nil {
+		t.Fatal(err)
+	}
+
+	// The constraints are kept across restarts
+	reopened, err := OpenCatalogManager(bufmgr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	reopenedSchema, err := reopened.GetTableSchema("people")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(reopenedSchema.Columns[1].Check, ageCheck) || !reflect.DeepEqual(reopenedSchema.Columns[3].Check, ageCheck) {
+		t.Errorf("expected the age and score checks, got %+v", reopenedSchema.Columns)
+	}
+	people, err = reopened.OpenTable("people")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := people.Insert(bufmgr, [][]byte{types.EncodeInt(4), types.EncodeInt(20), []byte("active"), types.EncodeInt(151)}); !errors.As(err, &violation) || violation.Check != "people_score_check" {
+		t.Errorf("expected a violation of people_score_check, got %v", err)
+	}
+	if err := people.Insert(bufmgr, [][]byte{types.EncodeInt(4), types.EncodeInt(20)}); err != nil {
+		t.Fatal(err)
+	}
+	got, err = people.Get(bufmgr, [][]byte{types.EncodeInt(4)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := [][]byte{types.EncodeInt(4), types.EncodeInt(20), []byte("active"), types.EncodeInt(0)}; !reflect.DeepEqual(got, want) {
+		t.Errorf("expected %q, got %q", want, got)
+	}
+}
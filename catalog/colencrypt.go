@@ -0,0 +1,213 @@
+package catalog
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"sync"
+
+	"github.com/Johniel/gorelly/disk"
+	"github.com/Johniel/gorelly/errcode"
+)
+
+var (
+	// ErrColumnNotDeterministic is returned when looking up an encrypted column by equality,
+	// or keying a table by it, while its values are not encrypted deterministically.
+	ErrColumnNotDeterministic = errcode.New(errcode.InvalidParameter, "column is not deterministically encrypted")
+	// ErrColumnAuthentication is returned when an encrypted column value fails authentication
+	// (it was modified, or moved from another column).
+	ErrColumnAuthentication = errcode.New(errcode.Corruption, "encrypted column value failed authentication")
+)
+
+const columnNonceSize = 12
+
+// ColumnEncryption declares a column whose values are encrypted, in addition to any encryption
+// of the pages they are stored in, so that they stay secret from sessions that may read the
+// rest of the row. Values are encrypted before the row is stored (see TableSchema.EncryptRow)
+// and decrypted when the rows are read (see TableSchema.DecryptRow and query.DecryptColumns).
+//
+// Encrypted values do not keep the order of the plain ones, so only equality predicates work
+// on them, with the value encrypted by TableSchema.EncryptValue. That needs deterministic
+// encryption, which is also required for key columns and columns with an index; it reveals
+// which rows have equal values, while randomized encryption reveals nothing but their lengths.
+// NULL values are not encrypted.
+type ColumnEncryption struct {
+	KeyID         uint32     // Key of the ColumnKeyring new values are encrypted with
+	Deterministic bool       // Whether equal values are encrypted to equal values
+	Decrypt       Privileges // Privileges required to see the values decrypted (0 for every session)
+}
+
+// ColumnKeyring holds the keys encrypted columns are encrypted with, by key ID.
+// Encrypted values record the ID of their key, so values written with a key that is no longer
+// the column's stay readable as long as the key is in the keyring.
+type ColumnKeyring struct {
+	keys map[uint32]*columnKey
+	mu   sync.RWMutex
+}
+
+type columnKey struct {
+	aead     cipher.AEAD
+	nonceKey []byte // HMAC key deriving the nonces of deterministic encryption
+}
+
+func NewColumnKeyring() *ColumnKeyring {
+	return &ColumnKeyring{keys: make(map[uint32]*columnKey)}
+}
+
+// AddKey adds an AES key (16, 24 or 32 bytes). The keys used for the values and for the nonces
+// of deterministic encryption are both derived from it.
+func (kr *ColumnKeyring) AddKey(keyID uint32, key []byte) error {
+	if keyID == 0 {
+		return disk.ErrInvalidKeyID
+	}
+	derive := func(label string) []byte {
+		mac := hmac.New(sha256.New, key)
+		mac.Write([]byte(label))
+		return mac.Sum(nil)
+	}
+	// Only the size of the key itself is checked
+	if _, err := aes.NewCipher(key); err != nil {
+		return err
+	}
+	block, err := aes.NewCipher(derive("column value")[:len(key)])
+	if err != nil {
+		return err
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return err
+	}
+
+	kr.mu.Lock()
+	defer kr.mu.Unlock()
+	if _, ok := kr.keys[keyID]; ok {
+		return disk.ErrKeyExists
+	}
+	kr.keys[keyID] = &columnKey{aead: aead, nonceKey: derive("column nonce")}
+	return nil
+}
+
+func (kr *ColumnKeyring) key(keyID uint32) (*columnKey, error) {
+	kr.mu.RLock()
+	defer kr.mu.RUnlock()
+	key, ok := kr.keys[keyID]
+	if !ok {
+		return nil, disk.ErrKeyNotFound
+	}
+	return key, nil
+}
+
+// columnData returns the associated data binding an encrypted value to its column.
+func columnData(tableID uint32, columnIndex int) []byte {
+	data := binary.BigEndian.AppendUint32(nil, tableID)
+	return binary.BigEndian.AppendUint32(data, uint32(columnIndex))
+}
+
+// encrypt encrypts a value of a column as [key ID (4B)][nonce (12B)][ciphertext].
+// The nonce of deterministic encryption is derived from the column and the value.
+func (kr *ColumnKeyring) encrypt(enc *ColumnEncryption, data []byte, value []byte) ([]byte, error) {
+	key, err := kr.key(enc.KeyID)
+	if err != nil {
+		return nil, err
+	}
+	out := binary.BigEndian.AppendUint32(make([]byte, 0, 4+columnNonceSize+len(value)+key.aead.Overhead()), enc.KeyID)
+	if enc.Deterministic {
+		mac := hmac.New(sha256.New, key.nonceKey)
+		mac.Write(data)
+		mac.Write(value)
+		out = append(out, mac.Sum(nil)[:columnNonceSize]...)
+	} else {
+		out = out[:4+columnNonceSize]
+		if _, err := rand.Read(out[4:]); err != nil {
+			return nil, err
+		}
+	}
+	return key.aead.Seal(out, out[4:], value, data), nil
+}
+
+func (kr *ColumnKeyring) decrypt(data []byte, value []byte) ([]byte, error) {
+	if len(value) < 4+columnNonceSize {
+		return nil, ErrColumnAuthentication
+	}
+	key, err := kr.key(binary.BigEndian.Uint32(value))
+	if err != nil {
+		return nil, err
+	}
+	plain, err := key.aead.Open(nil, value[4:4+columnNonceSize], value[4+columnNonceSize:], data)
+	if err != nil {
+		return nil, ErrColumnAuthentication
+	}
+	return plain, nil
+}
+
+// checkEncryption checks that the encrypted columns of a new table can serve as its key.
+func checkEncryption(columns []ColumnDef) error {
+	for _, col := range columns {
+		if col.IsPrimaryKey && col.Encryption != nil && !col.Encryption.Deterministic {
+			return ErrColumnNotDeterministic
+		}
+	}
+	return nil
+}
+
+// EncryptRow returns row, e.g. from BindRow, with the values of its encrypted columns encrypted,
+// ready to be inserted into the table. The input row is not modified.
+func (ts *TableSchema) EncryptRow(keyring *ColumnKeyring, row [][]byte) ([][]byte, error) {
+	result := make([][]byte, len(row))
+	copy(result, row)
+	for i, col := range ts.Columns {
+		if col.Encryption == nil || len(row) <= i || row[i] == nil {
+			continue
+		}
+		encrypted, err := keyring.encrypt(col.Encryption, columnData(ts.TableID, i), row[i])
+		if err != nil {
+			return nil, err
+		}
+		result[i] = encrypted
+	}
+	return result, nil
+}
+
+// EncryptValue encrypts a value of a column, e.g. from BindColumn, as it is stored, to look up
+// the rows with that value with an equality predicate or a key. The value of a column that is
+// not encrypted is returned as is.
+func (ts *TableSchema) EncryptValue(keyring *ColumnKeyring, columnIndex int, value []byte) ([]byte, error) {
+	enc := ts.Columns[columnIndex].Encryption
+	if enc == nil || value == nil {
+		return value, nil
+	}
+	if !enc.Deterministic {
+		return nil, ErrColumnNotDeterministic
+	}
+	return keyring.encrypt(enc, columnData(ts.TableID, columnIndex), value)
+}
+
+// DecryptRow returns a row read from the table with the values of the encrypted columns
+// decrypted for a session holding privs; the columns it may not decrypt keep their encrypted
+// values. The input row is not modified.
+func (ts *TableSchema) DecryptRow(keyring *ColumnKeyring, row [][]byte, privs Privileges) ([][]byte, error) {
+	result := row
+	copied := false
+	for i, col := range ts.Columns {
+		if col.Encryption == nil || len(row) <= i || row[i] == nil {
+			continue
+		}
+		if col.Encryption.Decrypt != 0 && !privs.Has(col.Encryption.Decrypt) {
+			continue
+		}
+		plain, err := keyring.decrypt(columnData(ts.TableID, i), row[i])
+		if err != nil {
+			return nil, err
+		}
+		if !copied {
+			result = make([][]byte, len(row))
+			copy(result, row)
+			copied = true
+		}
+		result[i] = plain
+	}
+	return result, nil
+}
@@ -0,0 +1,136 @@
+package catalog
+
+import (
+	"bytes"
+	"os"
+	"testing"
+
+	"github.com/Johniel/gorelly/buffer"
+	"github.com/Johniel/gorelly/disk"
+)
+
+func TestColumnEncryption(t *testing.T) {
+	tmpfile, err := os.CreateTemp("", "test_column_encryption_*.db")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(tmpfile.Name())
+
+	dm, err := disk.NewDiskManager(tmpfile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer dm.Close()
+
+	pool := buffer.NewBufferPool(10)
+	bufmgr := buffer.NewBufferPoolManager(dm, pool)
+
+	cm, err := NewCatalogManager(bufmgr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyring := NewColumnKeyring()
+	if err := keyring.AddKey(1, bytes.Repeat([]byte{7}, 32)); err != nil {
+		t.Fatal(err)
+	}
+	if err := keyring.AddKey(1, bytes.Repeat([]byte{8}, 32)); err != disk.ErrKeyExists {
+		t.Errorf("expected ErrKeyExists, got %v", err)
+	}
+
+	const privilegeSSN = PrivilegeSelect << 8
+	columns := []ColumnDef{
+		{Name: "id", Type: ColumnTypeInt, IsPrimaryKey: true},
+		{Name: "email", Type: ColumnTypeVarchar, Encryption: &ColumnEncryption{KeyID: 1, Deterministic: true}},
+		{Name: "ssn", Type: ColumnTypeVarchar, Nullable: true, Encryption: &ColumnEncryption{KeyID: 1, Decrypt: privilegeSSN}},
+	}
+	randomizedKey := []ColumnDef{{Name: "ssn", Type: ColumnTypeVarchar, IsPrimaryKey: true, Encryption: &ColumnEncryption{KeyID: 1}}}
+	if _, err := cm.CreateTable("by_ssn", randomizedKey); err != ErrColumnNotDeterministic {
+		t.Errorf("expected ErrColumnNotDeterministic for a randomized key column, got %v", err)
+	}
+	schema, err := cm.CreateTable("people", columns)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	row, err := schema.BindRow(1, "alice@example.com", "123-45-6789")
+	if err != nil {
+		t.Fatal(err)
+	}
+	stored, err := schema.EncryptRow(keyring, row)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(stored[0], row[0]) {
+		t.Errorf("expected the plain column to be stored as is")
+	}
+	for i := 1; i < len(row); i++ {
+		if bytes.Contains(stored[i], row[i]) {
+			t.Errorf("column %d: expected the value to be encrypted", i)
+		}
+	}
+	again, err := schema.EncryptRow(keyring, row)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(again[1], stored[1]) {
+		t.Errorf("expected deterministic encryption to repeat the encrypted value")
+	}
+	if bytes.Equal(again[2], stored[2]) {
+		t.Errorf("expected randomized encryption to differ between rows")
+	}
+	nullSSN, err := schema.EncryptRow(keyring, [][]byte{row[0], row[1], nil})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if nullSSN[2] != nil {
+		t.Errorf("expected NULL to stay NULL")
+	}
+
+	// Equality lookups encrypt the value like the stored one
+	_, email, err := schema.BindColumn("email", "alice@example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	lookup, err := schema.EncryptValue(keyring, 1, email)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(lookup, stored[1]) {
+		t.Errorf("expected the lookup value to match the stored value")
+	}
+	if _, err := schema.EncryptValue(keyring, 2, row[2]); err != ErrColumnNotDeterministic {
+		t.Errorf("expected ErrColumnNotDeterministic, got %v", err)
+	}
+
+	// Only sessions holding the column's privileges see it decrypted
+	decrypted, err := schema.DecryptRow(keyring, stored, privilegeSSN)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := range row {
+		if !bytes.Equal(decrypted[i], row[i]) {
+			t.Errorf("column %d: expected %q, got %q", i, row[i], decrypted[i])
+		}
+	}
+	decrypted, err = schema.DecryptRow(keyring, stored, PrivilegeSelect)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(decrypted[1], row[1]) || !bytes.Equal(decrypted[2], stored[2]) {
+		t.Errorf("expected only the email to be decrypted, got %q", decrypted)
+	}
+
+	// Values moved to another column or modified fail authentication
+	moved := [][]byte{stored[0], stored[2], stored[1]}
+	if _, err := schema.DecryptRow(keyring, moved, privilegeSSN); err != ErrColumnAuthentication {
+		t.Errorf("expected ErrColumnAuthentication for moved values, got %v", err)
+	}
+	tampered := [][]byte{stored[0], bytes.Clone(stored[1]), stored[2]}
+	tampered[1][len(tampered[1])-1] ^= 1
+	if _, err := schema.DecryptRow(keyring, tampered, privilegeSSN); err != ErrColumnAuthentication {
+		t.Errorf("expected ErrColumnAuthentication for a modified value, got %v", err)
+	}
+	if _, err := schema.DecryptRow(NewColumnKeyring(), stored, privilegeSSN); err != disk.ErrKeyNotFound {
+		t.Errorf("expected ErrKeyNotFound without the key, got %v", err)
+	}
+}
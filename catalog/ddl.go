@@ -0,0 +1,286 @@
+package catalog
+
+import (
+	"encoding/binary"
+	"errors"
+	"sort"
+
+	"github.com/Johniel/gorelly/btree"
+	"github.com/Johniel/gorelly/table"
+	"github.com/Johniel/gorelly/tuple"
+)
+
+// DDL creates and renames tables and indexes as part of a transaction, e.g. to swap a table
+// rebuilt under another name for the one in use (blue/green deployment):
+//
+//	ddl := cm.BeginDDL(transaction.NewPageLog(lm, txn))
+//	ddl.RenameTable("users", "users_old")
+//	ddl.RenameTable("staging.users", "users")
+//	// commit txn, then
+//	ddl.Publish()
+//
+// The catalog records are changed through the transaction's page log and the trees of new tables
+// and indexes are created through it, so they are undone when the transaction aborts or a crash
+// interrupts it, and recovered with the transaction once it committed. The schema locks of the
+// names the DDL touches are held exclusively until Publish makes the new names visible after the
+// transaction committed, or Discard drops them after it was rolled back, so no session observes a
+// half-done swap.
+// DDL transactions run one at a time: BeginDDL waits for the previous one to end.
+type DDL struct {
+	cm      *CatalogManager
+	log     btree.PageLog
+	tables  map[string]*TableSchema // Schemas changed by the DDL, by name; nil for names it freed
+	renames [][2]string             // Tables renamed by the DDL, as (old, new) names in order
+	unlocks map[string]func()       // Releases the schema locks held by the DDL
+	done    bool
+}
+
+// BeginDDL starts a DDL whose catalog changes are written through log, the page log of the
+// transaction making them (nil does not log them).
+func (cm *CatalogManager) BeginDDL(log btree.PageLog) *DDL {
+	cm.ddlMu.Lock()
+	return &DDL{
+		cm:      cm,
+		log:     log,
+		tables:  make(map[string]*TableSchema),
+		unlocks: make(map[string]func()),
+	}
+}
+
+// lock takes the schema locks of the names exclusively, in ascending order, unless the DDL
+// holds them already.
+func (d *DDL) lock(names ...string) {
+	sort.Strings(names)
+	for _, name := range names {
+		if _, ok := d.unlocks[name]; !ok {
+			d.unlocks[name] = d.cm.schemaLocks.lockExclusive(name)
+		}
+	}
+}
+
+// lookup returns the schema of a table as changed by the DDL so far, or nil if there is no such
+// table. d.cm.mu must be held exclusively.
+func (d *DDL) lookup(tableName string) (*TableSchema, error) {
+	if schema, ok := d.tables[tableName]; ok {
+		return schema, nil
+	}
+	schema, err := d.cm.cachedSchema(tableName)
+	if err == ErrTableNotFound {
+		return nil, nil
+	}
+	return schema, err
+}
+
+// catalogTable returns a catalog table whose changes are written through the DDL's log.
+func (d *DDL) catalogTable(t *table.Table) *table.Table {
+	return withLog(t, d.log)
+}
+
+// CreateTable creates a table as CatalogManager.CreateTable does, logging the creation of its
+// tree and its catalog records.
+func (d *DDL) CreateTable(tableName string, columns []ColumnDef) (*TableSchema, error) {
+	return d.createTable(tableName, columns, false)
+}
+
+// CreateVersionedTable creates a table as CatalogManager.CreateVersionedTable does, logging the
+// creation of its trees and its catalog records.
+func (d *DDL) CreateVersionedTable(tableName string, columns []ColumnDef) (*TableSchema, error) {
+	return d.createTable(tableName, columns, true)
+}
+
+func (d *DDL) createTable(tableName string, columns []ColumnDef, versioned bool) (*TableSchema, error) {
+	tableName = canonicalTableName(tableName)
+	d.lock(tableName)
+	d.cm.mu.Lock()
+	defer d.cm.mu.Unlock()
+
+	existing, err := d.lookup(tableName)
+	if err != nil {
+		return nil, err
+	}
+	if existing != nil {
+		return nil, ErrTableExists
+	}
+	schema, err := d.cm.createTable(tableName, columns, tuple.FormatV1, versioned, d.log)
+	if err != nil {
+		return nil, err
+	}
+	d.tables[tableName] = schema
+	return schema.clone(), nil
+}
+
+// CreateIndex creates an index as CatalogManager.CreateIndex does, logging the creation of its
+// tree, its entries and its catalog record. The table may have been created by the DDL.
+func (d *DDL) CreateIndex(tableName string, columns []string, unique bool) (IndexDef, error) {
+	tableName = canonicalTableName(tableName)
+	d.lock(tableName)
+	d.cm.mu.Lock()
+	defer d.cm.mu.Unlock()
+
+	schema, err := d.lookup(tableName)
+	if err != nil {
+		return IndexDef{}, err
+	}
+	if schema == nil {
+		return IndexDef{}, ErrTableNotFound
+	}
+	index, err := d.cm.addIndex(schema, columns, unique, d.log)
+	if err != nil {
+		return IndexDef{}, err
+	}
+	created := schema.clone()
+	created.Indexes = append(created.Indexes, index)
+	d.tables[tableName] = created
+	index.ColumnIndices = append([]int(nil), index.ColumnIndices...)
+	return index, nil
+}
+
+// OpenTable opens a table as CatalogManager.OpenTable does, but with the changes of the DDL so
+// far, e.g. to fill a table it created before Publish.
+func (d *DDL) OpenTable(tableName string) (*table.Table, error) {
+	tableName = canonicalTableName(tableName)
+	d.cm.mu.Lock()
+	schema, err := d.lookup(tableName)
+	d.cm.mu.Unlock()
+	if err != nil {
+		return nil, err
+	}
+	if schema == nil {
+		return nil, ErrTableNotFound
+	}
+	return d.cm.openTable(schema, &openedTables{
+		tables:  make(map[uint32]*table.Table),
+		indexes: make(map[uint32]*table.UniqueIndex),
+	})
+}
+
+// RenameTable renames a table. The new name may be in another schema, which must exist.
+func (d *DDL) RenameTable(oldName string, newName string) error {
+	oldName, newName = canonicalTableName(oldName), canonicalTableName(newName)
+	d.lock(oldName, newName)
+	d.cm.mu.Lock()
+	defer d.cm.mu.Unlock()
+
+	schema, err := d.lookup(oldName)
+	if err != nil {
+		return err
+	}
+	if schema == nil {
+		return ErrTableNotFound
+	}
+	existing, err := d.lookup(newName)
+	if err != nil {
+		return err
+	}
+	if existing != nil {
+		return ErrTableExists
+	}
+	if err := d.cm.checkTableName(newName); err != nil {
+		return err
+	}
+	renamed := schema.clone()
+	renamed.TableName = newName
+	if err := d.catalogTable(d.cm.tablesCatalog).Update(d.cm.bufmgr, tableRecord(renamed)); err != nil {
+		return err
+	}
+	d.tables[oldName] = nil
+	d.tables[newName] = renamed
+	d.renames = append(d.renames, [2]string{oldName, newName})
+	return nil
+}
+
+// RenameIndex renames an index of a table.
+func (d *DDL) RenameIndex(tableName string, oldName string, newName string) error {
+	tableName = canonicalTableName(tableName)
+	d.lock(tableName)
+	d.cm.mu.Lock()
+	defer d.cm.mu.Unlock()
+
+	schema, err := d.lookup(tableName)
+	if err != nil {
+		return err
+	}
+	if schema == nil {
+		return ErrTableNotFound
+	}
+	pos := -1
+	for i, index := range schema.Indexes {
+		if index.IndexName == newName {
+			return ErrIndexExists
+		}
+		if index.IndexName == oldName {
+			pos = i
+		}
+	}
+	if pos < 0 {
+		return ErrIndexNotFound
+	}
+	renamed := schema.clone()
+	renamed.Indexes[pos].IndexName = newName
+
+	// Schema: [index_id (PK), index_name, ...]
+	indexIDBytes := make([]byte, 4)
+	binary.BigEndian.PutUint32(indexIDBytes, renamed.Indexes[pos].IndexID)
+	indexes := d.catalogTable(d.cm.indexesCatalog)
+	record, err := indexes.Get(d.cm.bufmgr, [][]byte{indexIDBytes})
+	if err != nil && !errors.Is(err, btree.ErrKeyNotFound) {
+		return err
+	}
+	if err == nil {
+		record[1] = []byte(newName)
+		if err := indexes.Update(d.cm.bufmgr, record); err != nil {
+			return err
+		}
+	}
+	d.tables[tableName] = renamed
+	return nil
+}
+
+// Publish makes the changes of the DDL visible to other sessions and ends it. Call it once the
+// transaction the changes were logged in committed.
+func (d *DDL) Publish() {
+	if d.done {
+		return
+	}
+	d.cm.mu.Lock()
+	for name, schema := range d.tables {
+		if schema == nil {
+			delete(d.cm.schemaCache, name)
+		} else {
+			d.cm.schemaCache[name] = schema
+		}
+	}
+	for _, rename := range d.renames {
+		renameKey(d.cm.policies, rename[0], rename[1])
+		renameKey(d.cm.accessStats, rename[0], rename[1])
+	}
+	d.cm.mu.Unlock()
+	d.end()
+}
+
+// Discard ends the DDL without publishing its changes. Call it once the transaction the changes
+// were logged in was rolled back, which restores the catalog records.
+func (d *DDL) Discard() {
+	if d.done {
+		return
+	}
+	d.end()
+}
+
+func (d *DDL) end() {
+	d.done = true
+	for _, unlock := range d.unlocks {
+		unlock()
+	}
+	d.cm.ddlMu.Unlock()
+}
+
+// renameKey moves the value of a renamed table to its new name.
+func renameKey[V any](m map[string]V, oldName string, newName string) {
+	if v, ok := m[oldName]; ok {
+		m[newName] = v
+		delete(m, oldName)
+	} else {
+		delete(m, newName)
+	}
+}
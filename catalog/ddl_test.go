@@ -0,0 +1,341 @@
+package catalog
+
+import (
+	"encoding/binary"
+	"os"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/Johniel/gorelly/buffer"
+	"github.com/Johniel/gorelly/disk"
+	"github.com/Johniel/gorelly/transaction"
+)
+
+func TestSchemas(t *testing.T) {
+	tmpfile, err := os.CreateTemp("", "test_schemas_*.db")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(tmpfile.Name())
+
+	dm, err := disk.NewDiskManager(tmpfile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer dm.Close()
+
+	pool := buffer.NewBufferPool(10)
+	bufmgr := buffer.NewBufferPoolManager(dm, pool)
+
+	cm, err := NewCatalogManager(bufmgr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	columns := []ColumnDef{{Name: "id", Type: ColumnTypeInt, IsPrimaryKey: true}}
+	if _, err := cm.CreateTable("staging.users", columns); err != ErrSchemaNotFound {
+		t.Errorf("expected ErrSchemaNotFound, got %v", err)
+	}
+	if err := cm.CreateSchema("staging"); err != nil {
+		t.Fatal(err)
+	}
+	if err := cm.CreateSchema("staging"); err != ErrSchemaExists {
+		t.Errorf("expected ErrSchemaExists, got %v", err)
+	}
+	if err := cm.CreateSchema("a.b"); err != ErrInvalidName {
+		t.Errorf("expected ErrInvalidName, got %v", err)
+	}
+	if got := cm.Schemas(); !reflect.DeepEqual(got, []string{DefaultSchema, "staging"}) {
+		t.Errorf("expected the default and staging schemas, got %v", got)
+	}
+
+	// The same name in different schemas names different tables
+	users, err := cm.CreateTable("users", columns)
+	if err != nil {
+		t.Fatal(err)
+	}
+	staged, err := cm.CreateTable("staging.users", columns)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if users.TableID == staged.TableID {
+		t.Fatalf("expected two tables")
+	}
+	// Tables of the default schema can be named with or without it
+	if _, err := cm.CreateTable("public.users", columns); err != ErrTableExists {
+		t.Errorf("expected ErrTableExists, got %v", err)
+	}
+	schema, release, err := cm.AcquireSchema("public.users")
+	if err != nil {
+		t.Fatal(err)
+	}
+	release()
+	if schema.TableID != users.TableID || schema.TableName != "users" {
+		t.Errorf("expected public.users to be users, got %+v", schema)
+	}
+}
+
+func TestDDLRename(t *testing.T) {
+	tmpfile, err := os.CreateTemp("", "test_ddl_rename_*.db")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(tmpfile.Name())
+
+	logFile, err := os.CreateTemp("", "test_ddl_rename_*.log")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(logFile.Name())
+	logFile.Close()
+
+	dm, err := disk.NewDiskManager(tmpfile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer dm.Close()
+
+	lm, err := transaction.OpenLogManager(logFile.Name(), transaction.SyncPolicy{Mode: transaction.SyncModeNone})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer lm.Close()
+
+	bufmgr := buffer.NewBufferPoolManager(dm, buffer.NewBufferPool(32))
+	bufmgr.SetWAL(lm)
+	tm := transaction.NewTransactionManagerWithManagers(lm, nil, transaction.NewRecoveryManager(lm, bufmgr))
+
+	cm, err := NewCatalogManager(bufmgr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := cm.CreateSchema("staging"); err != nil {
+		t.Fatal(err)
+	}
+	columns := []ColumnDef{{Name: "id", Type: ColumnTypeInt, IsPrimaryKey: true}}
+	live, err := cm.CreateTable("users", columns)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rebuilt, err := cm.CreateTable("staging.users", columns)
+	if err != nil {
+		t.Fatal(err)
+	}
+	policy := &SecurityPolicy{}
+	if err := cm.SetSecurityPolicy("users", policy); err != nil {
+		t.Fatal(err)
+	}
+	// Indexes are registered in the schema only, so the test adds one directly
+	cm.schemaCache["users"].Indexes = []IndexDef{{IndexID: 1, IndexName: "users_email", TableID: live.TableID}}
+
+	tableIDOf := func(name string) uint32 {
+		t.Helper()
+		schema, release, err := cm.AcquireSchema(name)
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		release()
+		return schema.TableID
+	}
+	recordName := func(tableID uint32) string {
+		t.Helper()
+		tableIDBytes := make([]byte, 4)
+		binary.BigEndian.PutUint32(tableIDBytes, tableID)
+		record, err := cm.tablesCatalog.Get(bufmgr, [][]byte{tableIDBytes})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return string(record[1])
+	}
+
+	// A rename rolled back leaves the catalog as it was
+	txn := tm.Begin()
+	ddl := cm.BeginDDL(transaction.NewPageLog(lm, txn))
+	if err := ddl.RenameTable("users", "users_old"); err != nil {
+		t.Fatal(err)
+	}
+	if err := ddl.RenameTable("users_old", "staging.users"); err != ErrTableExists {
+		t.Errorf("expected ErrTableExists, got %v", err)
+	}
+	if err := ddl.RenameTable("users_old", "missing.users"); err != ErrSchemaNotFound {
+		t.Errorf("expected ErrSchemaNotFound, got %v", err)
+	}
+	if err := tm.Abort(txn); err != nil {
+		t.Fatal(err)
+	}
+	ddl.Discard()
+	if got := recordName(live.TableID); got != "users" {
+		t.Errorf("expected the rolled back rename to restore the record, got %q", got)
+	}
+	if tableIDOf("users") != live.TableID {
+		t.Errorf("expected users to be unchanged")
+	}
+
+	// Blue/green swap: the rebuilt table takes the name of the live one
+	txn = tm.Begin()
+	ddl = cm.BeginDDL(transaction.NewPageLog(lm, txn))
+	if err := ddl.RenameTable("users", "users_old"); err != nil {
+		t.Fatal(err)
+	}
+	if err := ddl.RenameTable("staging.users", "users"); err != nil {
+		t.Fatal(err)
+	}
+	if err := ddl.RenameIndex("users_old", "users_email", "users_old_email"); err != nil {
+		t.Fatal(err)
+	}
+	if err := ddl.RenameIndex("users_old", "missing", "other"); err != ErrIndexNotFound {
+		t.Errorf("expected ErrIndexNotFound, got %v", err)
+	}
+
+	// Sessions wait for the swap to be published instead of observing it half done
+	acquired := make(chan uint32)
+	go func() {
+		schema, release, err := cm.AcquireSchema("users")
+		if err != nil {
+			t.Error(err)
+			close(acquired)
+			return
+		}
+		release()
+		acquired <- schema.TableID
+	}()
+	select {
+	case <-acquired:
+		t.Fatal("expected the session to wait for the DDL")
+	case <-time.After(50 * time.Millisecond):
+	}
+	if err := tm.Commit(txn); err != nil {
+		t.Fatal(err)
+	}
+	ddl.Publish()
+	if got := <-acquired; got != rebuilt.TableID {
+		t.Errorf("expected the session to see the rebuilt table %d, got %d", rebuilt.TableID, got)
+	}
+
+	if tableIDOf("users_old") != live.TableID {
+		t.Errorf("expected users_old to be the former live table")
+	}
+	if _, _, err := cm.AcquireSchema("staging.users"); err != ErrTableNotFound {
+		t.Errorf("expected staging.users to be gone, got %v", err)
+	}
+	if got := recordName(rebuilt.TableID); got != "users" {
+		t.Errorf("expected the record of the rebuilt table to be renamed, got %q", got)
+	}
+	if got := recordName(live.TableID); got != "users_old" {
+		t.Errorf("expected the record of the former live table to be renamed, got %q", got)
+	}
+	if cm.SecurityPolicy("users_old") != policy || cm.SecurityPolicy("users") != nil {
+		t.Errorf("expected the policy to follow the renamed table")
+	}
+	schema, release, err := cm.AcquireSchema("users_old")
+	if err != nil {
+		t.Fatal(err)
+	}
+	release()
+	if schema.Indexes[0].IndexName != "users_old_email" {
+		t.Errorf("expected the index to be renamed, got %q", schema.Indexes[0].IndexName)
+	}
+}
+
+func TestDDLCreate(t *testing.T) {
+	tmpfile, err := os.CreateTemp("", "test_ddl_create_*.db")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(tmpfile.Name())
+
+	logFile, err := os.CreateTemp("", "test_ddl_create_*.log")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(logFile.Name())
+	logFile.Close()
+
+	dm, err := disk.NewDiskManager(tmpfile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer dm.Close()
+
+	lm, err := transaction.OpenLogManager(logFile.Name(), transaction.SyncPolicy{Mode: transaction.SyncModeNone})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer lm.Close()
+
+	bufmgr := buffer.NewBufferPoolManager(dm, buffer.NewBufferPool(32))
+	bufmgr.SetWAL(lm)
+	tm := transaction.NewTransactionManagerWithManagers(lm, nil, transaction.NewRecoveryManager(lm, bufmgr))
+
+	cm, err := NewCatalogManager(bufmgr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	columns := []ColumnDef{
+		{Name: "id", Type: ColumnTypeInt, IsPrimaryKey: true},
+		{Name: "email", Type: ColumnTypeVarchar},
+	}
+	if err := bufmgr.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	usedPages := int(dm.NumPages()) - dm.NumFreePages()
+
+	// A creation rolled back frees the trees and leaves no trace in the catalog
+	txn := tm.Begin()
+	ddl := cm.BeginDDL(transaction.NewPageLog(lm, txn))
+	if _, err := ddl.CreateTable("users", columns); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ddl.CreateTable("users", columns); err != ErrTableExists {
+		t.Errorf("expected ErrTableExists, got %v", err)
+	}
+	if _, err := ddl.CreateIndex("users", []string{"email"}, true); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ddl.CreateIndex("missing", []string{"email"}, false); err != ErrTableNotFound {
+		t.Errorf("expected ErrTableNotFound, got %v", err)
+	}
+	if err := tm.Abort(txn); err != nil {
+		t.Fatal(err)
+	}
+	ddl.Discard()
+	if _, _, err := cm.AcquireSchema("users"); err != ErrTableNotFound {
+		t.Errorf("expected users not to exist, got %v", err)
+	}
+	if err := bufmgr.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	if got := int(dm.NumPages()) - dm.NumFreePages(); got != usedPages {
+		t.Errorf("expected %d pages in use after the rollback, got %d", usedPages, got)
+	}
+
+	// A committed creation is visible once published
+	txn = tm.Begin()
+	ddl = cm.BeginDDL(transaction.NewPageLog(lm, txn))
+	created, err := ddl.CreateTable("users", columns)
+	if err != nil {
+		t.Fatal(err)
+	}
+	index, err := ddl.CreateIndex("users", []string{"email"}, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := tm.Commit(txn); err != nil {
+		t.Fatal(err)
+	}
+	ddl.Publish()
+	schema, release, err := cm.AcquireSchema("users")
+	if err != nil {
+		t.Fatal(err)
+	}
+	release()
+	if schema.TableID != created.TableID {
+		t.Errorf("expected table %d, got %d", created.TableID, schema.TableID)
+	}
+	if len(schema.Indexes) != 1 || schema.Indexes[0].IndexID != index.IndexID || !schema.Indexes[0].IsUnique {
+		t.Errorf("expected the unique index to be published, got %+v", schema.Indexes)
+	}
+	if _, err := cm.CreateTable("users", columns); err != ErrTableExists {
+		t.Errorf("expected ErrTableExists, got %v", err)
+	}
+}
@@ -0,0 +1,102 @@
+package catalog
+
+import (
+	"encoding/binary"
+
+	"github.com/Johniel/gorelly/btree"
+	"github.com/Johniel/gorelly/disk"
+	"github.com/Johniel/gorelly/tuple"
+)
+
+// KeyRotation is the progress of re-encrypting a key zone, as recorded in key_rotation_catalog.
+type KeyRotation struct {
+	Zone       string      // Key zone being re-encrypted
+	KeyID      uint32      // Key the zone is being re-encrypted with
+	NextPageID disk.PageID // First page not yet visited
+}
+
+// KeyRotation returns the recorded re-encryption progress of a zone.
+// It returns false if the zone has never been re-encrypted.
+func (cm *CatalogManager) KeyRotation(zone string) (KeyRotation, bool, error) {
+	cm.mu.RLock()
+	defer cm.mu.RUnlock()
+	return cm.findKeyRotation(zone)
+}
+
+// ReencryptZone re-encrypts the pages of a key zone with the zone's active key, visiting at most
+// maxPages pages (0 means all) starting where the previous call stopped. Progress is recorded in
+// key_rotation_catalog after every call, so an interrupted rotation resumes instead of starting over;
+// activating another key for the zone restarts it from the first page.
+// It reports whether every page has been visited. Pages allocated after the rotation started
+// are written with the active key, so once it is done the old keys can be removed from the keyring.
+func (cm *CatalogManager) ReencryptZone(dm *disk.DiskManager, keyring *disk.Keyring, zone string, maxPages int) (bool, error) {
+	cm.mu.Lock()
+	defer cm.mu.Unlock()
+
+	activeKey, err := keyring.ActiveKey(zone)
+	if err != nil {
+		return false, err
+	}
+	rotation, found, err := cm.findKeyRotation(zone)
+	if err != nil {
+		return false, err
+	}
+	if !found || rotation.KeyID != activeKey {
+		rotation = KeyRotation{Zone: zone, KeyID: activeKey, NextPageID: 0}
+	}
+
+	numPages := dm.NumPages()
+	for visited := 0; rotation.NextPageID < numPages && (maxPages == 0 || visited < maxPages); visited++ {
+		if keyring.Zone(rotation.NextPageID) == zone {
+			if _, err := dm.ReencryptPage(rotation.NextPageID); err != nil {
+				return false, err
+			}
+		}
+		rotation.NextPageID++
+	}
+
+	tup := rotationTuple(rotation)
+	if found {
+		err = cm.rotationCatalog.Update(cm.bufmgr, tup)
+	} else {
+		err = cm.rotationCatalog.Insert(cm.bufmgr, tup)
+	}
+	if err != nil {
+		return false, err
+	}
+	return numPages <= rotation.NextPageID, nil
+}
+
+func (cm *CatalogManager) findKeyRotation(zone string) (KeyRotation, bool, error) {
+	keyBytes := make([]byte, 0)
+	tuple.Encode([][]byte{[]byte(zone)}, &keyBytes)
+	bt := btree.NewBTree(cm.rotationCatalog.MetaPageID)
+	iter, err := bt.Search(cm.bufmgr, btree.NewSearchModeKey(keyBytes))
+	if err != nil {
+		return KeyRotation{}, false, err
+	}
+	defer iter.Close()
+	foundKey, valueBytes, ok := iter.Get()
+	if !ok || string(foundKey) != string(keyBytes) {
+		return KeyRotation{}, false, nil
+	}
+	var valueElems [][]byte
+	tuple.Decode(valueBytes, &valueElems)
+	return KeyRotation{
+		Zone:       zone,
+		KeyID:      binary.BigEndian.Uint32(valueElems[0]),
+		NextPageID: disk.PageID(binary.BigEndian.Uint64(valueElems[1])),
+	}, true, nil
+}
+
+func rotationTuple(rotation KeyRotation) [][]byte {
+	keyIDBytes := make([]byte, 4)
+	binary.BigEndian.PutUint32(keyIDBytes, rotation.KeyID)
+	nextPageIDBytes := make([]byte, 8)
+	binary.BigEndian.PutUint64(nextPageIDBytes, uint64(rotation.NextPageID))
+	return [][]byte{
+		[]byte(rotation.Zone), // PK
+		keyIDBytes,            // key_id
+		nextPageIDBytes,       // next_page_id
+	}
+}
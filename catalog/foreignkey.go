@@ -0,0 +1,474 @@
+package catalog
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"slices"
+	"sort"
+	"strings"
+
+	"github.com/Johniel/gorelly/btree"
+	"github.com/Johniel/gorelly/errcode"
+	"github.com/Johniel/gorelly/table"
+	"github.com/Johniel/gorelly/tuple"
+)
+
+var (
+	// ErrForeignKeyNotFound is returned when dropping a foreign key the table does not have.
+	ErrForeignKeyNotFound = errcode.New(errcode.NotFound, "foreign key not found")
+	// ErrForeignKeyExists is returned when adding a foreign key whose name the table already uses.
+	ErrForeignKeyExists = errcode.New(errcode.AlreadyExists, "foreign key already exists")
+	// ErrInvalidForeignKey is returned when the columns of a foreign key do not match the primary
+	// key of the referenced table in number and types, or are encrypted, or its action is unknown.
+	ErrInvalidForeignKey = errcode.New(errcode.InvalidParameter, "invalid foreign key")
+)
+
+// ForeignKeyDef is a foreign key of a table: the values of its columns in a row, unless one is
+// NULL, must be the primary key of a row of the referenced table.
+type ForeignKeyDef struct {
+	ForeignKeyID  uint32
+	Name          string
+	TableID       uint32 // Referencing table
+	ColumnIndices []int  // Referencing columns, in the order of the referenced primary key
+	RefTableID    uint32 // Referenced table
+	IndexID       uint32 // Index of the referencing table whose columns start with ColumnIndices
+	OnDelete      table.ForeignKeyAction
+	OnUpdate      table.ForeignKeyAction
+}
+
+// AddForeignKey makes the named columns of a table reference the primary key of refTableName,
+// which may be the table itself, and returns the foreign key. The columns must match the primary
+// key columns in number, order and types, and must not be encrypted (ErrInvalidForeignKey).
+// Deleting a referenced row fails or deletes the rows referencing it, according to onDelete, and
+// changing its primary key fails or changes the rows referencing it, according to onUpdate.
+//
+// The foreign key is named after the table and its columns, e.g. "orders_user_id_fkey";
+// ErrForeignKeyExists is returned if the table has one with that name. Deletes find the rows
+// referencing a row through an index of the table whose columns start with the foreign key
+// columns; unless the table has one, an index is created as by CreateIndex. If a row already
+// references a missing row, a table.ForeignKeyViolationError is returned and nothing is created.
+//
+// Like CreateIndex, it does not log its changes. It holds the schema locks of both tables and of
+// the tables they are linked to by foreign keys exclusively, since the tables returned by
+// OpenTable afterwards check the foreign key on their changes.
+func (cm *CatalogManager) AddForeignKey(tableName string, columns []string, refTableName string, onDelete table.ForeignKeyAction, onUpdate table.ForeignKeyAction) (ForeignKeyDef, error) {
+	tableName, refTableName = canonicalTableName(tableName), canonicalTableName(refTableName)
+	unlock, err := cm.lockRelated(tableName, refTableName)
+	if err != nil {
+		return ForeignKeyDef{}, err
+	}
+	defer unlock()
+	cm.mu.Lock()
+	defer cm.mu.Unlock()
+
+	schema, err := cm.cachedSchema(tableName)
+	if err != nil {
+		return ForeignKeyDef{}, err
+	}
+	refSchema, err := cm.cachedSchema(refTableName)
+	if err != nil {
+		return ForeignKeyDef{}, err
+	}
+	if len(columns) != refSchema.NumKeyElems || table.ForeignKeyCascade < onDelete || table.ForeignKeyCascade < onUpdate {
+		return ForeignKeyDef{}, ErrInvalidForeignKey
+	}
+	fk := ForeignKeyDef{
+		Name:       foreignKeyName(tableName, columns),
+		TableID:    schema.TableID,
+		RefTableID: refSchema.TableID,
+		OnDelete:   onDelete,
+		OnUpdate:   onUpdate,
+	}
+	for i, name := range columns {
+		pos := schema.columnIndex(name)
+		if pos < 0 {
+			return ForeignKeyDef{}, ErrColumnNotFound
+		}
+		col, refCol := schema.Columns[pos], refSchema.Columns[i]
+		if col.Type != refCol.Type || col.Encryption != nil || refCol.Encryption != nil {
+			return ForeignKeyDef{}, ErrInvalidForeignKey
+		}
+		fk.ColumnIndices = append(fk.ColumnIndices, pos)
+	}
+	for _, existing := range schema.ForeignKeys {
+		if existing.Name == fk.Name {
+			return ForeignKeyDef{}, ErrForeignKeyExists
+		}
+	}
+
+	var created *IndexDef
+	if index, ok := schema.indexStartingWith(fk.ColumnIndices); ok {
+		fk.IndexID = index.IndexID
+	} else {
+		index, err := cm.createIndex(tableName, columns, false)
+		if err != nil {
+			return ForeignKeyDef{}, err
+		}
+		created = &index
+		fk.IndexID = index.IndexID
+		if schema, err = cm.cachedSchema(tableName); err != nil {
+			return ForeignKeyDef{}, err
+		}
+		if refTableName == tableName {
+			refSchema = schema
+		}
+	}
+	fk.ForeignKeyID = cm.nextIndexID
+	err = cm.checkReferencedRows(schema, refSchema, fk)
+	if err == nil {
+		if err = cm.foreignKeysCatalog.Insert(cm.bufmgr, foreignKeyRecord(fk)); err != nil {
+			err = fmt.Errorf("failed to insert foreign key record: %w", err)
+		}
+	}
+	if err != nil {
+		if created != nil {
+			if dropErr := cm.dropIndex(tableName, created.IndexName); dropErr != nil {
+				return ForeignKeyDef{}, errors.Join(err, dropErr)
+			}
+		}
+		return ForeignKeyDef{}, err
+	}
+	cm.nextIndexID++
+
+	altered := schema.clone()
+	altered.ForeignKeys = append(altered.ForeignKeys, fk)
+	if refTableName == tableName {
+		altered.ReferencedBy = append(altered.ReferencedBy, fk)
+	} else {
+		referenced := refSchema.clone()
+		referenced.ReferencedBy = append(referenced.ReferencedBy, fk)
+		cm.schemaCache[refTableName] = referenced
+	}
+	cm.schemaCache[tableName] = altered
+	return cloneForeignKeys([]ForeignKeyDef{fk})[0], nil
+}
+
+// DropForeignKey removes a foreign key of a table. The index its references were found through
+// is kept. Like AddForeignKey, it holds the schema locks of the linked tables exclusively.
+func (cm *CatalogManager) DropForeignKey(tableName string, name string) error {
+	tableName = canonicalTableName(tableName)
+	unlock, err := cm.lockRelated(tableName)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+	cm.mu.Lock()
+	defer cm.mu.Unlock()
+
+	schema, err := cm.cachedSchema(tableName)
+	if err != nil {
+		return err
+	}
+	for _, fk := range schema.ForeignKeys {
+		if fk.Name == name {
+			return cm.deleteForeignKey(tableName, fk)
+		}
+	}
+	return ErrForeignKeyNotFound
+}
+
+// deleteForeignKey removes the foreign_keys_catalog record of a foreign key of a table, and the
+// foreign key from the cached schemas of the table and of the referenced table. The schema locks
+// of both and cm.mu must be held.
+func (cm *CatalogManager) deleteForeignKey(tableName string, fk ForeignKeyDef) error {
+	if err := cm.foreignKeysCatalog.Delete(cm.bufmgr, foreignKeyRecord(fk)[:1]); err != nil {
+		return fmt.Errorf("failed to delete foreign key record: %w", err)
+	}
+	without := func(fks []ForeignKeyDef) []ForeignKeyDef {
+		return slices.DeleteFunc(fks, func(other ForeignKeyDef) bool { return other.ForeignKeyID == fk.ForeignKeyID })
+	}
+	names := []string{tableName}
+	if fk.RefTableID != fk.TableID {
+		refTableName, err := cm.tableNameByID(fk.RefTableID)
+		if err != nil {
+			return err
+		}
+		names = append(names, refTableName)
+	}
+	for _, name := range names {
+		schema, err := cm.cachedSchema(name)
+		if err != nil {
+			return err
+		}
+		altered := schema.clone()
+		altered.ForeignKeys = without(altered.ForeignKeys)
+		altered.ReferencedBy = without(altered.ReferencedBy)
+		cm.schemaCache[name] = altered
+	}
+	return nil
+}
+
+// checkReferencedRows returns a table.ForeignKeyViolationError if a row of the table of schema
+// references a missing row of the table of refSchema through fk. Rows written before trailing
+// columns were added are checked with the defaults of those columns.
+func (cm *CatalogManager) checkReferencedRows(schema *TableSchema, refSchema *TableSchema, fk ForeignKeyDef) error {
+	iter, err := btree.NewBTree(schema.MetaPageID).Search(cm.bufmgr, btree.NewSearchModeStart())
+	if err != nil {
+		return err
+	}
+	defer iter.Close()
+	refTree := btree.NewBTree(refSchema.MetaPageID)
+	defaults := schema.Defaults()
+rowLoop:
+	for {
+		pkeyBytes, valueBytes, ok, err := iter.Next(cm.bufmgr)
+		if err != nil {
+			return err
+		}
+		if !ok {
+			return nil
+		}
+		row := make([][]byte, 0, len(defaults))
+		tuple.Decode(pkeyBytes, &row)
+		schema.Format.DecodeValue(valueBytes, &row)
+		for i := len(row); i < len(defaults); i++ {
+			row = append(row, defaults[i])
+		}
+		key := make([][]byte, len(fk.ColumnIndices))
+		for i, pos := range fk.ColumnIndices {
+			if len(row[pos]) == 0 {
+				continue rowLoop
+			}
+			key[i] = row[pos]
+		}
+		keyBytes := make([]byte, 0)
+		tuple.Encode(key, &keyBytes)
+		if _, err := refTree.Get(cm.bufmgr, keyBytes); err == btree.ErrKeyNotFound {
+			return &table.ForeignKeyViolationError{ForeignKey: fk.Name, Key: key}
+		} else if err != nil {
+			return err
+		}
+	}
+}
+
+// foreignKeyName returns the name AddForeignKey gives to a foreign key.
+func foreignKeyName(tableName string, columns []string) string {
+	_, name := SplitTableName(tableName)
+	return name + "_" + strings.Join(columns, "_") + "_fkey"
+}
+
+// indexStartingWith returns an index of the table whose columns start with the given ones.
+func (ts *TableSchema) indexStartingWith(columnIndices []int) (IndexDef, bool) {
+	for _, index := range ts.Indexes {
+		if index.MetaPageID.Valid() && len(columnIndices) <= len(index.ColumnIndices) && slices.Equal(index.ColumnIndices[:len(columnIndices)], columnIndices) {
+			return index, true
+		}
+	}
+	return IndexDef{}, false
+}
+
+// lockRelated takes the schema locks of the named tables and of the tables their foreign keys
+// link them to exclusively, in ascending order, and returns the function releasing them. Deletes
+// from a referenced table change the tables referencing it, so DDL on either excludes the
+// sessions using both.
+func (cm *CatalogManager) lockRelated(tableNames ...string) (func(), error) {
+	for {
+		names, err := cm.relatedTables(tableNames, false)
+		if err != nil {
+			return nil, err
+		}
+		unlocks := make([]func(), len(names))
+		for i, name := range names {
+			unlocks[i] = cm.schemaLocks.lockExclusive(name)
+		}
+		unlock := func() {
+			for i := len(unlocks) - 1; 0 <= i; i-- {
+				unlocks[i]()
+			}
+		}
+		// The links may have changed before the locks were taken
+		locked, err := cm.relatedTables(tableNames, true)
+		if err == nil && slices.Equal(names, locked) {
+			return unlock, nil
+		}
+		unlock()
+		if err != nil {
+			return nil, err
+		}
+	}
+}
+
+// relatedTables returns the sorted names of the given tables and of the tables their foreign keys
+// link them to. With locked, the schema locks of the tables are held by the caller.
+func (cm *CatalogManager) relatedTables(tableNames []string, locked bool) ([]string, error) {
+	related := make(map[string]bool)
+	for _, tableName := range tableNames {
+		related[tableName] = true
+		var schema *TableSchema
+		var err error
+		if locked {
+			schema, err = cm.lockedSchema(tableName)
+		} else {
+			schema, err = cm.GetTableSchema(tableName)
+		}
+		if err != nil {
+			return nil, err
+		}
+		cm.mu.RLock()
+		for _, fk := range append(schema.ForeignKeys, schema.ReferencedBy...) {
+			for _, tableID := range []uint32{fk.TableID, fk.RefTableID} {
+				var name string
+				if name, err = cm.tableNameByID(tableID); err != nil {
+					break
+				}
+				related[name] = true
+			}
+		}
+		cm.mu.RUnlock()
+		if err != nil {
+			return nil, err
+		}
+	}
+	names := make([]string, 0, len(related))
+	for name := range related {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
+// tableNameByID returns the name of the table with the given ID, or ErrTableNotFound.
+// cm.mu must be held.
+func (cm *CatalogManager) tableNameByID(tableID uint32) (string, error) {
+	name := ""
+	err := cm.scanCatalog(cm.tablesCatalog, [][]byte{tableIDKey(tableID)}, func(tup [][]byte) (bool, error) {
+		if len(tup) < 2 {
+			return false, errMalformedRecord
+		}
+		name = string(tup[1])
+		return false, nil
+	})
+	if err == nil && name == "" {
+		err = ErrTableNotFound
+	}
+	return name, err
+}
+
+// openedTables holds the handles OpenTable creates for a table and the tables linked to it by
+// foreign keys, by table ID, and their indexes, by index ID.
+type openedTables struct {
+	tables  map[uint32]*table.Table
+	indexes map[uint32]*table.UniqueIndex
+}
+
+// openTable returns the handle on the table of schema, creating it and the handles on the tables
+// linked to it by foreign keys unless opened has them.
+func (cm *CatalogManager) openTable(schema *TableSchema, opened *openedTables) (*table.Table, error) {
+	if t, ok := opened.tables[schema.TableID]; ok {
+		return t, nil
+	}
+	t := &table.Table{
+		MetaPageID:  schema.MetaPageID,
+		NumKeyElems: schema.NumKeyElems,
+		Format:      schema.Format,
+		Defaults:    schema.Defaults(),
+		Checks:      schema.checks(),
+		Versions:    schema.VersionStore(),
+	}
+	for _, col := range schema.Columns {
+		t.ColumnDefs = append(t.ColumnDefs, EncodeColumnDef(col))
+	}
+	opened.tables[schema.TableID] = t
+	for _, index := range schema.Indexes {
+		if index.MetaPageID.Valid() {
+			uniqueIndex := schema.uniqueIndex(index)
+			opened.indexes[index.IndexID] = uniqueIndex
+			t.UniqueIndices = append(t.UniqueIndices, uniqueIndex)
+		}
+	}
+	for _, fk := range schema.ForeignKeys {
+		parent, err := cm.openTableByID(fk.RefTableID, opened)
+		if err != nil {
+			return nil, err
+		}
+		t.ForeignKeys = append(t.ForeignKeys, &table.ForeignKey{Name: fk.Name, Columns: fk.ColumnIndices, Parent: parent})
+	}
+	for _, fk := range schema.ReferencedBy {
+		child, err := cm.openTableByID(fk.TableID, opened)
+		if err != nil {
+			return nil, err
+		}
+		t.References = append(t.References, &table.Reference{Name: fk.Name, Child: child, Index: opened.indexes[fk.IndexID], OnDelete: fk.OnDelete, OnUpdate: fk.OnUpdate})
+	}
+	return t, nil
+}
+
+// openTableByID is openTable for the table with the given ID.
+func (cm *CatalogManager) openTableByID(tableID uint32, opened *openedTables) (*table.Table, error) {
+	if t, ok := opened.tables[tableID]; ok {
+		return t, nil
+	}
+	cm.mu.RLock()
+	name, err := cm.tableNameByID(tableID)
+	cm.mu.RUnlock()
+	if err != nil {
+		return nil, err
+	}
+	schema, err := cm.GetTableSchema(name)
+	if err != nil {
+		return nil, err
+	}
+	return cm.openTable(schema, opened)
+}
+
+// foreignKeyRecord returns the foreign_keys_catalog record of a foreign key.
+func foreignKeyRecord(fk ForeignKeyDef) [][]byte {
+	foreignKeyIDBytes := make([]byte, 4)
+	binary.BigEndian.PutUint32(foreignKeyIDBytes, fk.ForeignKeyID)
+
+	indexIDBytes := make([]byte, 4)
+	binary.BigEndian.PutUint32(indexIDBytes, fk.IndexID)
+
+	columnIndicesBytes := make([]byte, 4*len(fk.ColumnIndices))
+	for i, columnIndex := range fk.ColumnIndices {
+		binary.BigEndian.PutUint32(columnIndicesBytes[4*i:], uint32(columnIndex))
+	}
+
+	return [][]byte{
+		foreignKeyIDBytes,         // PK
+		[]byte(fk.Name),           // foreign_key_name
+		tableIDKey(fk.TableID),    // table_id
+		tableIDKey(fk.RefTableID), // ref_table_id
+		columnIndicesBytes,        // column_indices
+		indexIDBytes,              // index_id
+		{byte(fk.OnDelete)},       // on_delete
+		{byte(fk.OnUpdate)},       // on_update
+	}
+}
+
+// decodeForeignKeyRecord decodes a foreign_keys_catalog record written by foreignKeyRecord.
+// Records written before on_update was added restrict key changes.
+func decodeForeignKeyRecord(tup [][]byte) (ForeignKeyDef, error) {
+	if len(tup) < 7 || len(tup[0]) != 4 || len(tup[2]) != 4 || len(tup[3]) != 4 || len(tup[4])%4 != 0 || len(tup[5]) != 4 || len(tup[6]) != 1 {
+		return ForeignKeyDef{}, errMalformedRecord
+	}
+	fk := ForeignKeyDef{
+		ForeignKeyID: binary.BigEndian.Uint32(tup[0]),
+		Name:         string(tup[1]),
+		TableID:      binary.BigEndian.Uint32(tup[2]),
+		RefTableID:   binary.BigEndian.Uint32(tup[3]),
+		IndexID:      binary.BigEndian.Uint32(tup[5]),
+		OnDelete:     table.ForeignKeyAction(tup[6][0]),
+	}
+	if 8 <= len(tup) && len(tup[7]) == 1 {
+		fk.OnUpdate = table.ForeignKeyAction(tup[7][0])
+	}
+	for i := 0; i < len(tup[4]); i += 4 {
+		fk.ColumnIndices = append(fk.ColumnIndices, int(binary.BigEndian.Uint32(tup[4][i:])))
+	}
+	return fk, nil
+}
+
+// cloneForeignKeys returns a deep copy of foreign keys.
+func cloneForeignKeys(fks []ForeignKeyDef) []ForeignKeyDef {
+	if fks == nil {
+		return nil
+	}
+	c := make([]ForeignKeyDef, len(fks))
+	for i, fk := range fks {
+		c[i] = fk
+		c[i].ColumnIndices = append([]int(nil), fk.ColumnIndices...)
+	}
+	return c
+}
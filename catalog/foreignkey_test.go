@@ -0,0 +1,269 @@
+package catalog
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"testing"
+
+	"github.com/Johniel/gorelly/btree"
+	"github.com/Johniel/gorelly/buffer"
+	"github.com/Johniel/gorelly/disk"
+	"github.com/Johniel/gorelly/table"
+	"github.com/Johniel/gorelly/types"
+)
+
+func TestForeignKeys(t *testing.T) {
+	tmpfile, err := os.CreateTemp("", "test_foreign_keys_*.db")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(tmpfile.Name())
+
+	dm, err := disk.NewDiskManager(tmpfile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer dm.Close()
+	bufmgr := buffer.NewBufferPoolManager(dm, buffer.NewBufferPool(10))
+	cm, err := NewCatalogManager(bufmgr)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tc := range []struct {
+		name    string
+		columns []ColumnDef
+	}{
+		{"users", []ColumnDef{
+			{Name: "id", Type: ColumnTypeInt, IsPrimaryKey: true},
+			{Name: "name", Type: ColumnTypeVarchar},
+		}},
+		{"orders", []ColumnDef{
+			{Name: "id", Type: ColumnTypeInt, IsPrimaryKey: true},
+			{Name: "user_id", Type: ColumnTypeInt, Nullable: true},
+		}},
+		{"payments", []ColumnDef{
+			{Name: "id", Type: ColumnTypeInt, IsPrimaryKey: true},
+			{Name: "order_id", Type: ColumnTypeInt},
+		}},
+	} {
+		if _, err := cm.CreateTable(tc.name, tc.columns); err != nil {
+			t.Fatal(err)
+		}
+	}
+	open := func(name string) (*table.Table, *TableSchema) {
+		t.Helper()
+		tbl, err := cm.OpenTable(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		schema, err := cm.GetTableSchema(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return tbl, schema
+	}
+	insert := func(name string, values ...any) error {
+		t.Helper()
+		tbl, schema := open(name)
+		row, err := schema.BindRow(values...)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return tbl.Insert(bufmgr, row)
+	}
+	exists := func(name string, id int) bool {
+		t.Helper()
+		tbl, schema := open(name)
+		key, err := schema.BindKey(id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, err = tbl.Get(bufmgr, key)
+		if err != nil && !errors.Is(err, btree.ErrKeyNotFound) {
+			t.Fatal(err)
+		}
+		return err == nil
+	}
+	deleteRow := func(name string, id int) error {
+		t.Helper()
+		tbl, schema := open(name)
+		key, err := schema.BindKey(id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return tbl.Delete(bufmgr, key)
+	}
+
+	if err := insert("users", 1, "alice"); err != nil {
+		t.Fatal(err)
+	}
+	if err := insert("orders", 10, 1); err != nil {
+		t.Fatal(err)
+	}
+	if err := insert("orders", 11, 2); err != nil {
+		t.Fatal(err)
+	}
+
+	// Existing rows are checked: order 11 references the missing user 2
+	var violation *table.ForeignKeyViolationError
+	_, err = cm.AddForeignKey("orders", []string{"user_id"}, "users", table.ForeignKeyCascade, table.ForeignKeyRestrict)
+	if !errors.As(err, &violation) || violation.ForeignKey != "orders_user_id_fkey" || violation.Referenced {
+		t.Fatalf("expected a violation of orders_user_id_fkey, got %v", err)
+	}
+	if _, schema := open("orders"); len(schema.ForeignKeys) != 0 || len(schema.Indexes) != 0 {
+		t.Fatalf("expected no foreign key and no index, got %+v", schema)
+	}
+	if err := deleteRow("orders", 11); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tc := range []struct {
+		columns []string
+		ref     string
+	}{
+		{[]string{"user_id", "id"}, "users"},
+		{[]string{"id"}, "missing"},
+		{[]string{"missing"}, "users"},
+	} {
+		if _, err := cm.AddForeignKey("orders", tc.columns, tc.ref, table.ForeignKeyRestrict, table.ForeignKeyRestrict); err == nil {
+			t.Errorf("expected %v referencing %s to fail", tc.columns, tc.ref)
+		}
+	}
+	fk, err := cm.AddForeignKey("orders", []string{"user_id"}, "users", table.ForeignKeyCascade, table.ForeignKeyCascade)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := cm.AddForeignKey("payments", []string{"order_id"}, "orders", table.ForeignKeyRestrict, table.ForeignKeyRestrict); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := cm.AddForeignKey("orders", []string{"user_id"}, "users", table.ForeignKeyRestrict, table.ForeignKeyRestrict); err != ErrForeignKeyExists {
+		t.Errorf("expected ErrForeignKeyExists, got %v", err)
+	}
+	if _, schema := open("orders"); len(schema.Indexes) != 1 || schema.Indexes[0].IndexID != fk.IndexID || len(schema.ReferencedBy) != 1 {
+		t.Errorf("expected the index of the foreign key and a reference, got %+v", schema)
+	}
+	if _, schema := open("users"); len(schema.ReferencedBy) != 1 || schema.ReferencedBy[0].Name != "orders_user_id_fkey" {
+		t.Errorf("expected users to be referenced by orders, got %+v", schema.ReferencedBy)
+	}
+
+	// Inserts and updates reference existing rows or NULL
+	err = insert("orders", 12, 3)
+	if !errors.As(err, &violation) || violation.ForeignKey != "orders_user_id_fkey" || !errors.Is(err, table.ErrForeignKeyViolation) {
+		t.Errorf("expected a violation of orders_user_id_fkey, got %v", err)
+	}
+	if err := insert("orders", 12, types.Null); err != nil {
+		t.Fatal(err)
+	}
+	orders, ordersSchema := open("orders")
+	row, err := ordersSchema.BindRow(12, 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := orders.Update(bufmgr, row); !errors.Is(err, table.ErrForeignKeyViolation) {
+		t.Errorf("expected a violation updating order 12, got %v", err)
+	}
+	if err := insert("payments", 100, 10); err != nil {
+		t.Fatal(err)
+	}
+
+	// Restricted: the payment of order 10 blocks the cascade from user 1
+	err = deleteRow("users", 1)
+	if !errors.As(err, &violation) || violation.ForeignKey != "payments_order_id_fkey" || !violation.Referenced {
+		t.Fatalf("expected a violation of payments_order_id_fkey, got %v", err)
+	}
+	if !exists("users", 1) || !exists("orders", 10) {
+		t.Error("expected the restricted delete to change nothing")
+	}
+	// A primary key referenced through a restricting foreign key cannot change either
+	key, err := ordersSchema.BindKey(10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	row, err = ordersSchema.BindRow(11, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := orders.UpdateKey(bufmgr, key, row); !errors.Is(err, table.ErrForeignKeyViolation) {
+		t.Errorf("expected a violation changing the key of order 10, got %v", err)
+	}
+	// Through a cascading one, the rows referencing it follow: order 10 moves to user 2
+	users, usersSchema := open("users")
+	userKey, err := usersSchema.BindKey(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	row, err = usersSchema.BindRow(2, "alice")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := users.UpdateKey(bufmgr, userKey, row); err != nil {
+		t.Fatal(err)
+	}
+	expected, err := ordersSchema.BindRow(10, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if tup, err := orders.Get(bufmgr, key); err != nil || !bytes.Equal(tup[1], expected[1]) {
+		t.Errorf("expected order 10 to reference user 2, got %q, %v", tup, err)
+	}
+
+	// Cascaded: deleting user 2 deletes order 10 and its index entry
+	if err := deleteRow("payments", 100); err != nil {
+		t.Fatal(err)
+	}
+	if err := deleteRow("users", 2); err != nil {
+		t.Fatal(err)
+	}
+	if exists("users", 2) || exists("orders", 10) || !exists("orders", 12) {
+		t.Error("expected order 10 to be deleted with user 2")
+	}
+	orders, _ = open("orders")
+	if found, err := orders.VerifyIndexes(bufmgr); err != nil || len(found) != 0 {
+		t.Errorf("expected consistent indexes, got %v, %v", found, err)
+	}
+
+	if err := cm.DropTable("users"); err != ErrTableReferenced {
+		t.Errorf("expected ErrTableReferenced, got %v", err)
+	}
+	if err := cm.DropIndex("orders", ordersSchema.Indexes[0].IndexName); err != ErrIndexInUse {
+		t.Errorf("expected ErrIndexInUse, got %v", err)
+	}
+
+	// The foreign keys are kept across restarts
+	reopened, err := OpenCatalogManager(bufmgr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	schema, err := reopened.GetTableSchema("orders")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(schema.ForeignKeys) != 1 || schema.ForeignKeys[0].ForeignKeyID != fk.ForeignKeyID || schema.ForeignKeys[0].OnDelete != table.ForeignKeyCascade || schema.ForeignKeys[0].OnUpdate != table.ForeignKeyCascade {
+		t.Errorf("expected %+v, got %+v", fk, schema.ForeignKeys)
+	}
+	if _, err := reopened.CreateTable("next", []ColumnDef{{Name: "id", Type: ColumnTypeInt, IsPrimaryKey: true}}); err != nil {
+		t.Fatal(err)
+	}
+	if next, err := reopened.GetTableSchema("next"); err != nil || next.TableID <= fk.ForeignKeyID {
+		t.Errorf("expected a table ID after %d, got %+v, %v", fk.ForeignKeyID, next, err)
+	}
+
+	// Dropping the referencing tables, or their foreign keys, lets users be dropped
+	if err := cm.DropForeignKey("payments", "missing"); err != ErrForeignKeyNotFound {
+		t.Errorf("expected ErrForeignKeyNotFound, got %v", err)
+	}
+	if err := cm.DropForeignKey("payments", "payments_order_id_fkey"); err != nil {
+		t.Fatal(err)
+	}
+	if err := cm.DropTable("orders"); err != nil {
+		t.Fatal(err)
+	}
+	if _, schema := open("users"); len(schema.ReferencedBy) != 0 {
+		t.Errorf("expected users not to be referenced anymore, got %+v", schema.ReferencedBy)
+	}
+	if err := cm.DropTable("users"); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,152 @@
+package catalog
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/Johniel/gorelly/btree"
+	"github.com/Johniel/gorelly/table"
+	"github.com/Johniel/gorelly/tuple"
+)
+
+// CreateIndex builds an index on the named columns of a table from the rows it already holds,
+// records it in indexes_catalog and returns it. The tables returned by OpenTable afterwards
+// maintain the index on every Insert, Update and Delete.
+//
+// The index is named after the table and its columns, e.g. "users_email_key" for a unique index
+// and "users_city_idx" otherwise; ErrIndexExists is returned if the table has an index with that
+// name. If a unique index finds two rows with the same values, it is not created and
+// btree.ErrDuplicateKey is returned.
+//
+// Entries map the values of the columns to the primary key of their row. The entries of an index
+// that is not unique are keyed by the values of the columns followed by the primary key, so that
+// rows with the same values get distinct entries.
+//
+// Like DropIndex, it holds the table's schema lock exclusively, which blocks the sessions using
+// the table while the index is built, and does not log the new pages; DDL.CreateIndex does.
+func (cm *CatalogManager) CreateIndex(tableName string, columns []string, unique bool) (IndexDef, error) {
+	tableName = canonicalTableName(tableName)
+	unlock := cm.schemaLocks.lockExclusive(tableName)
+	defer unlock()
+	cm.mu.Lock()
+	defer cm.mu.Unlock()
+	return cm.createIndex(tableName, columns, unique)
+}
+
+// createIndex is CreateIndex with the table's schema lock held exclusively and cm.mu held.
+func (cm *CatalogManager) createIndex(tableName string, columns []string, unique bool) (IndexDef, error) {
+	schema, err := cm.cachedSchema(tableName)
+	if err != nil {
+		return IndexDef{}, err
+	}
+	index, err := cm.addIndex(schema, columns, unique, nil)
+	if err != nil {
+		return IndexDef{}, err
+	}
+	created := schema.clone()
+	created.Indexes = append(created.Indexes, index)
+	cm.schemaCache[tableName] = created
+	index.ColumnIndices = append([]int(nil), index.ColumnIndices...)
+	return index, nil
+}
+
+// addIndex creates and builds an index of the table and writes its catalog record, logging the
+// changes to log. If building or recording it fails, its tree is dropped again. The schema is
+// left unchanged.
+func (cm *CatalogManager) addIndex(schema *TableSchema, columns []string, unique bool, log btree.PageLog) (IndexDef, error) {
+	if len(columns) == 0 {
+		return IndexDef{}, ErrColumnCount
+	}
+	index := IndexDef{
+		IndexID:   cm.nextIndexID,
+		IndexName: indexName(schema.TableName, columns, unique),
+		TableID:   schema.TableID,
+		IsUnique:  unique,
+	}
+	for _, name := range columns {
+		i := schema.columnIndex(name)
+		if i < 0 {
+			return IndexDef{}, ErrColumnNotFound
+		}
+		index.ColumnIndices = append(index.ColumnIndices, i)
+	}
+	for _, existing := range schema.Indexes {
+		if existing.IndexName == index.IndexName {
+			return IndexDef{}, ErrIndexExists
+		}
+	}
+
+	ui := schema.uniqueIndex(index)
+	if err := ui.CreateWithLog(cm.bufmgr, log); err != nil {
+		return IndexDef{}, fmt.Errorf("failed to create B+ tree: %w", err)
+	}
+	index.MetaPageID = ui.MetaPageID
+	err := cm.buildIndex(schema, ui, log)
+	if err == nil {
+		if err = withLog(cm.indexesCatalog, log).Insert(cm.bufmgr, indexRecord(index)); err != nil {
+			err = fmt.Errorf("failed to insert index record: %w", err)
+		}
+	}
+	if err != nil {
+		bt := btree.NewBTree(ui.MetaPageID)
+		bt.Log = log
+		if _, dropErr := bt.Drop(cm.bufmgr); dropErr != nil {
+			return IndexDef{}, errors.Join(err, dropErr)
+		}
+		return IndexDef{}, err
+	}
+	cm.nextIndexID++
+	return index, nil
+}
+
+// indexName returns the name CreateIndex gives to an index.
+func indexName(tableName string, columns []string, unique bool) string {
+	_, name := SplitTableName(tableName)
+	suffix := "idx"
+	if unique {
+		suffix = "key"
+	}
+	return name + "_" + strings.Join(columns, "_") + "_" + suffix
+}
+
+// uniqueIndex returns the table.UniqueIndex maintaining an index of the table. The secondary key
+// of an index that is not unique ends with the primary key columns (see CreateIndex).
+func (ts *TableSchema) uniqueIndex(index IndexDef) *table.UniqueIndex {
+	skey := append([]int(nil), index.ColumnIndices...)
+	if !index.IsUnique {
+		for i := 0; i < ts.NumKeyElems; i++ {
+			skey = append(skey, i)
+		}
+	}
+	return &table.UniqueIndex{MetaPageID: index.MetaPageID, Skey: skey, Name: index.IndexName}
+}
+
+// buildIndex inserts an entry into ui for every row of the table. Rows written before trailing
+// columns were added are indexed with the defaults of those columns.
+func (cm *CatalogManager) buildIndex(schema *TableSchema, ui *table.UniqueIndex, log btree.PageLog) error {
+	iter, err := btree.NewBTree(schema.MetaPageID).Search(cm.bufmgr, btree.NewSearchModeStart())
+	if err != nil {
+		return err
+	}
+	defer iter.Close()
+	defaults := schema.Defaults()
+	for {
+		pkeyBytes, valueBytes, ok, err := iter.Next(cm.bufmgr)
+		if err != nil {
+			return err
+		}
+		if !ok {
+			return nil
+		}
+		row := make([][]byte, 0, len(defaults))
+		tuple.Decode(pkeyBytes, &row)
+		schema.Format.DecodeValue(valueBytes, &row)
+		for i := len(row); i < len(defaults); i++ {
+			row = append(row, defaults[i])
+		}
+		if err := ui.InsertWithLog(cm.bufmgr, log, pkeyBytes, row); err != nil {
+			return err
+		}
+	}
+}
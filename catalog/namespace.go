@@ -0,0 +1,71 @@
+package catalog
+
+import (
+	"sort"
+	"strings"
+)
+
+// DefaultSchema is the schema of the tables whose names are not qualified with a schema.
+const DefaultSchema = "public"
+
+// SplitTableName splits a table name of the form "schema.table" into the schema and the name of
+// the table within it. Unqualified names are in DefaultSchema.
+func SplitTableName(name string) (schemaName string, tableName string) {
+	if schemaName, tableName, ok := strings.Cut(name, "."); ok {
+		return schemaName, tableName
+	}
+	return DefaultSchema, name
+}
+
+// canonicalTableName returns the name a table is registered under. Tables of DefaultSchema are
+// registered under their unqualified names, so that "users" and "public.users" name the same table.
+func canonicalTableName(name string) string {
+	schemaName, tableName := SplitTableName(name)
+	if schemaName == DefaultSchema {
+		return tableName
+	}
+	return name
+}
+
+// checkTableName checks that a new table name is well formed and that its schema exists.
+// cm.mu must be held.
+func (cm *CatalogManager) checkTableName(name string) error {
+	schemaName, tableName := SplitTableName(name)
+	if tableName == "" || strings.Contains(tableName, ".") {
+		return ErrInvalidName
+	}
+	if !cm.schemas[schemaName] {
+		return ErrSchemaNotFound
+	}
+	return nil
+}
+
+// CreateSchema creates a schema, a namespace for tables named "schema.table".
+// DefaultSchema always exists.
+func (cm *CatalogManager) CreateSchema(schemaName string) error {
+	if schemaName == "" || strings.Contains(schemaName, ".") {
+		return ErrInvalidName
+	}
+	cm.mu.Lock()
+	defer cm.mu.Unlock()
+	if cm.schemas[schemaName] {
+		return ErrSchemaExists
+	}
+	if err := cm.schemasCatalog.Insert(cm.bufmgr, [][]byte{[]byte(schemaName)}); err != nil {
+		return err
+	}
+	cm.schemas[schemaName] = true
+	return nil
+}
+
+// Schemas returns the names of the schemas, in ascending order.
+func (cm *CatalogManager) Schemas() []string {
+	cm.mu.RLock()
+	defer cm.mu.RUnlock()
+	names := make([]string, 0, len(cm.schemas))
+	for name := range cm.schemas {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
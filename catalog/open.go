@@ -0,0 +1,393 @@
+package catalog
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"sort"
+
+	"github.com/Johniel/gorelly/btree"
+	"github.com/Johniel/gorelly/buffer"
+	"github.com/Johniel/gorelly/disk"
+	"github.com/Johniel/gorelly/table"
+	"github.com/Johniel/gorelly/tuple"
+)
+
+// errMalformedRecord is returned when a catalog record cannot be decoded.
+var errMalformedRecord = errors.New("malformed catalog record")
+
+// OpenCatalogManager opens the catalog of a database created by NewCatalogManager, e.g. after a
+// restart. NewCatalogManager creates the catalog tables before any other page is allocated, one
+// meta page and one root page each, so their meta pages are at fixed page IDs.
+// The schemas of the tables are loaded from the catalog when they are first used.
+func OpenCatalogManager(bufmgr *buffer.BufferPoolManager) (*CatalogManager, error) {
+	cm := newCatalogManager(bufmgr)
+	catalogs := []struct {
+		table       **table.Table
+		numKeyElems int
+	}{
+		{&cm.tablesCatalog, 1},
+		{&cm.columnsCatalog, 2},
+		{&cm.indexesCatalog, 1},
+		{&cm.rolesCatalog, 1},
+		{&cm.grantsCatalog, 2},
+		{&cm.rotationCatalog, 1},
+		{&cm.statsCatalog, 1},
+		{&cm.schemasCatalog, 1},
+		{&cm.vacuumCatalog, 1},
+		{&cm.analyzeCatalog, 1},
+		{&cm.foreignKeysCatalog, 1},
+	}
+	for i, c := range catalogs {
+		*c.table = &table.Table{MetaPageID: disk.PageID(2 * i), NumKeyElems: c.numKeyElems}
+	}
+
+	// IDs continue after the largest ones in use
+	nextID := func(t *table.Table) (uint32, error) {
+		var maxID uint32
+		err := cm.scanCatalog(t, nil, func(tup [][]byte) (bool, error) {
+			maxID = max(maxID, binary.BigEndian.Uint32(tup[0]))
+			return true, nil
+		})
+		return maxID + 1, err
+	}
+	nextTableID, err := nextID(cm.tablesCatalog)
+	if err != nil {
+		return nil, err
+	}
+	// Tables, indexes and foreign keys are numbered from the same sequence
+	nextIndexID, err := nextID(cm.indexesCatalog)
+	if err != nil {
+		return nil, err
+	}
+	nextForeignKeyID, err := nextID(cm.foreignKeysCatalog)
+	if err != nil {
+		return nil, err
+	}
+	cm.nextIndexID = max(nextTableID, nextIndexID, nextForeignKeyID)
+	if cm.nextRoleID, err = nextID(cm.rolesCatalog); err != nil {
+		return nil, err
+	}
+	err = cm.scanCatalog(cm.schemasCatalog, nil, func(tup [][]byte) (bool, error) {
+		cm.schemas[string(tup[0])] = true
+		return true, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return cm, nil
+}
+
+// GetTableSchema returns a copy of the schema of a table. Like AcquireSchema, it waits for DDL
+// creating or renaming the table to finish.
+func (cm *CatalogManager) GetTableSchema(tableName string) (*TableSchema, error) {
+	schema, release, err := cm.AcquireSchema(tableName)
+	if err != nil {
+		return nil, err
+	}
+	release()
+	return schema, nil
+}
+
+// OpenTable returns a handle on a table: a table.Table on its primary B+ tree, with its row
+// format and indexes, which its changes maintain. Indexes that are not unique are maintained as
+// unique indexes keyed by their columns and the primary key (see CreateIndex). The optional
+// fields of the handle (Log, Counters, Access, ...) are left for the caller to set.
+//
+// The foreign keys of the table and those referencing it (see AddForeignKey) are set as its
+// ForeignKeys and References, with handles on the tables they link it to, opened the same way.
+// The defaults and CHECK constraints of its columns are set as its Defaults and Checks, so that
+// inserts may omit trailing columns.
+func (cm *CatalogManager) OpenTable(tableName string) (*table.Table, error) {
+	schema, err := cm.GetTableSchema(tableName)
+	if err != nil {
+		return nil, err
+	}
+	return cm.openTable(schema, &openedTables{
+		tables:  make(map[uint32]*table.Table),
+		indexes: make(map[uint32]*table.UniqueIndex),
+	})
+}
+
+// ListTables returns the names of the tables in the catalog, in ascending order.
+func (cm *CatalogManager) ListTables() ([]string, error) {
+	cm.mu.RLock()
+	defer cm.mu.RUnlock()
+	var names []string
+	err := cm.scanCatalog(cm.tablesCatalog, nil, func(tup [][]byte) (bool, error) {
+		if len(tup) < 2 {
+			return false, errMalformedRecord
+		}
+		names = append(names, string(tup[1]))
+		return true, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
+// loadSchema makes sure that the schema of a table is in schemaCache if the table exists,
+// loading it from the catalog on first use. The table's schema lock is taken shared while the
+// schema is loaded, so that records changed by DDL in progress are not cached. Neither cm.mu
+// nor the schema lock may be held.
+func (cm *CatalogManager) loadSchema(tableName string) error {
+	tableName = canonicalTableName(tableName)
+	cm.mu.RLock()
+	_, exists := cm.schemaCache[tableName]
+	cm.mu.RUnlock()
+	if exists {
+		return nil
+	}
+	release := cm.schemaLocks.lockShared(tableName)
+	defer release()
+	if _, err := cm.lockedSchema(tableName); err != nil && err != ErrTableNotFound {
+		return err
+	}
+	return nil
+}
+
+// lockedSchema is cachedSchema for callers that do not hold cm.mu.
+func (cm *CatalogManager) lockedSchema(tableName string) (*TableSchema, error) {
+	cm.mu.Lock()
+	defer cm.mu.Unlock()
+	return cm.cachedSchema(tableName)
+}
+
+// cachedSchema returns the schema of a table from schemaCache, loading it from the catalog on
+// first use. It returns ErrTableNotFound if there is no such table. cm.mu must be held exclusively.
+func (cm *CatalogManager) cachedSchema(tableName string) (*TableSchema, error) {
+	if schema, exists := cm.schemaCache[tableName]; exists {
+		return schema, nil
+	}
+	schema, err := cm.loadTableSchema(tableName)
+	if err != nil {
+		return nil, err
+	}
+	cm.schemaCache[tableName] = schema
+	return schema, nil
+}
+
+// loadTableSchema reads the schema of a table from tables_catalog, columns_catalog,
+// indexes_catalog and foreign_keys_catalog.
+func (cm *CatalogManager) loadTableSchema(tableName string) (*TableSchema, error) {
+	record, err := cm.findTableRecord(tableName)
+	if err != nil {
+		return nil, err
+	}
+	if len(record) < 5 || len(record[0]) != 4 || len(record[2]) != 8 || len(record[3]) != 4 || len(record[4]) != 1 {
+		return nil, errMalformedRecord
+	}
+	schema := &TableSchema{
+		TableID:     binary.BigEndian.Uint32(record[0]),
+		TableName:   tableName,
+		MetaPageID:  disk.PageID(binary.BigEndian.Uint64(record[2])),
+		NumKeyElems: int(binary.BigEndian.Uint32(record[3])),
+		Format:      tuple.Format(record[4][0]),
+		Indexes:     []IndexDef{},
+	}
+	// Tables created before versioned tables existed have no versions_meta_page_id
+	if 5 < len(record) && len(record[5]) != 0 {
+		if len(record[5]) != 8 {
+			return nil, errMalformedRecord
+		}
+		schema.Versioned = true
+		schema.VersionsMetaPageID = disk.PageID(binary.BigEndian.Uint64(record[5]))
+	}
+
+	err = cm.scanCatalog(cm.columnsCatalog, [][]byte{record[0]}, func(tup [][]byte) (bool, error) {
+		col, err := decodeColumnRecord(tup)
+		if err != nil {
+			return false, err
+		}
+		schema.Columns = append(schema.Columns, col)
+		return true, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	err = cm.scanCatalog(cm.indexesCatalog, nil, func(tup [][]byte) (bool, error) {
+		if len(tup) < 6 || len(tup[0]) != 4 || len(tup[2]) != 4 {
+			return false, errMalformedRecord
+		}
+		if binary.BigEndian.Uint32(tup[2]) != schema.TableID {
+			return true, nil
+		}
+		if len(tup[3]) != 8 || len(tup[4]) != 1 || len(tup[5])%4 != 0 {
+			return false, errMalformedRecord
+		}
+		index := IndexDef{
+			IndexID:    binary.BigEndian.Uint32(tup[0]),
+			IndexName:  string(tup[1]),
+			TableID:    schema.TableID,
+			MetaPageID: disk.PageID(binary.BigEndian.Uint64(tup[3])),
+			IsUnique:   tup[4][0] == 1,
+		}
+		for i := 0; i < len(tup[5]); i += 4 {
+			index.ColumnIndices = append(index.ColumnIndices, int(binary.BigEndian.Uint32(tup[5][i:])))
+		}
+		schema.Indexes = append(schema.Indexes, index)
+		return true, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	err = cm.scanCatalog(cm.foreignKeysCatalog, nil, func(tup [][]byte) (bool, error) {
+		fk, err := decodeForeignKeyRecord(tup)
+		if err != nil {
+			return false, err
+		}
+		if fk.TableID == schema.TableID {
+			schema.ForeignKeys = append(schema.ForeignKeys, fk)
+		}
+		if fk.RefTableID == schema.TableID {
+			schema.ReferencedBy = append(schema.ReferencedBy, fk)
+		}
+		return true, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return schema, nil
+}
+
+// EncodeColumnDef encodes a column definition as its columns_catalog record, for keeping it
+// outside the catalog, e.g. in a table snapshot (table.Table.ColumnDefs).
+func EncodeColumnDef(col ColumnDef) []byte {
+	encoded := make([]byte, 0)
+	tuple.Encode(columnRecord(0, 0, col), &encoded)
+	return encoded
+}
+
+// DecodeColumnDef decodes a column definition encoded by EncodeColumnDef.
+func DecodeColumnDef(encoded []byte) (ColumnDef, error) {
+	var tup [][]byte
+	tuple.Decode(encoded, &tup)
+	return decodeColumnRecord(tup)
+}
+
+// decodeColumnRecord decodes a columns_catalog record written by insertColumnRecord.
+func decodeColumnRecord(tup [][]byte) (ColumnDef, error) {
+	if len(tup) < 9 || len(tup[3]) != 4 || len(tup[4]) != 4 || len(tup[5]) != 1 || len(tup[6]) != 1 || len(tup[7]) != 1 {
+		return ColumnDef{}, errMalformedRecord
+	}
+	col := ColumnDef{
+		Name:         string(tup[2]),
+		Type:         ColumnType(binary.BigEndian.Uint32(tup[3])),
+		Size:         int(binary.BigEndian.Uint32(tup[4])),
+		Nullable:     tup[5][0] == 1,
+		IsPrimaryKey: tup[6][0] == 1,
+	}
+	if tup[7][0] == 1 {
+		col.Default = append([]byte{}, tup[8]...)
+	}
+	// Records written before column encryption or DropColumn have no encryption or dropped field
+	if 10 <= len(tup) {
+		enc := tup[9]
+		if len(enc) != 13 {
+			return ColumnDef{}, errMalformedRecord
+		}
+		if enc[0] != 0 {
+			col.Encryption = &ColumnEncryption{
+				KeyID:         binary.BigEndian.Uint32(enc[1:]),
+				Deterministic: enc[0] == 2,
+				Decrypt:       Privileges(binary.BigEndian.Uint64(enc[5:])),
+			}
+		}
+	}
+	if 11 <= len(tup) {
+		if len(tup[10]) != 1 {
+			return ColumnDef{}, errMalformedRecord
+		}
+		col.Dropped = tup[10][0] == 1
+	}
+	// Records written before CHECK constraints have no check field
+	if 12 <= len(tup) {
+		check, err := decodeCheck(tup[11])
+		if err != nil {
+			return ColumnDef{}, err
+		}
+		col.Check = check
+	}
+	return col, nil
+}
+
+// indexRecord returns the indexes_catalog record of an index.
+func indexRecord(index IndexDef) [][]byte {
+	indexIDBytes := make([]byte, 4)
+	binary.BigEndian.PutUint32(indexIDBytes, index.IndexID)
+
+	tableIDBytes := make([]byte, 4)
+	binary.BigEndian.PutUint32(tableIDBytes, index.TableID)
+
+	metaPageIDBytes := make([]byte, 8)
+	binary.BigEndian.PutUint64(metaPageIDBytes, uint64(index.MetaPageID))
+
+	isUniqueBytes := []byte{0}
+	if index.IsUnique {
+		isUniqueBytes[0] = 1
+	}
+
+	columnIndicesBytes := make([]byte, 4*len(index.ColumnIndices))
+	for i, columnIndex := range index.ColumnIndices {
+		binary.BigEndian.PutUint32(columnIndicesBytes[4*i:], uint32(columnIndex))
+	}
+
+	return [][]byte{
+		indexIDBytes,            // PK
+		[]byte(index.IndexName), // index_name
+		tableIDBytes,            // table_id
+		metaPageIDBytes,         // meta_page_id
+		isUniqueBytes,           // is_unique
+		columnIndicesBytes,      // column_indices
+	}
+}
+
+// findTableRecord returns the tables_catalog record of a table, or ErrTableNotFound.
+func (cm *CatalogManager) findTableRecord(tableName string) ([][]byte, error) {
+	var record [][]byte
+	err := cm.scanCatalog(cm.tablesCatalog, nil, func(tup [][]byte) (bool, error) {
+		if 1 < len(tup) && string(tup[1]) == tableName {
+			record = tup
+			return false, nil
+		}
+		return true, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	if record == nil {
+		return nil, ErrTableNotFound
+	}
+	return record, nil
+}
+
+// scanCatalog calls fn with the records of a catalog table whose primary key starts with
+// prefix, in primary key order, until fn returns false or an error.
+func (cm *CatalogManager) scanCatalog(t *table.Table, prefix [][]byte, fn func(tup [][]byte) (bool, error)) error {
+	prefixBytes := make([]byte, 0)
+	tuple.Encode(prefix, &prefixBytes)
+	iter, err := btree.NewBTree(t.MetaPageID).Search(cm.bufmgr, btree.NewSearchModeKey(prefixBytes))
+	if err != nil {
+		return err
+	}
+	defer iter.Close()
+	for {
+		keyBytes, valueBytes, ok, err := iter.Next(cm.bufmgr)
+		if err != nil {
+			return err
+		}
+		if !ok || !bytes.HasPrefix(keyBytes, prefixBytes) {
+			return nil
+		}
+		var tup [][]byte
+		tuple.Decode(keyBytes, &tup)
+		t.Format.DecodeValue(valueBytes, &tup)
+		if more, err := fn(tup); !more || err != nil {
+			return err
+		}
+	}
+}
@@ -0,0 +1,173 @@
+package catalog
+
+import (
+	"errors"
+	"os"
+	"reflect"
+	"testing"
+
+	"github.com/Johniel/gorelly/buffer"
+	"github.com/Johniel/gorelly/disk"
+	"github.com/Johniel/gorelly/table"
+	"github.com/Johniel/gorelly/tuple"
+)
+
+func TestOpenCatalogManager(t *testing.T) {
+	tmpfile, err := os.CreateTemp("", "test_open_catalog_*.db")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(tmpfile.Name())
+
+	dm, err := disk.NewDiskManager(tmpfile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	bufmgr := buffer.NewBufferPoolManager(dm, buffer.NewBufferPool(10))
+	cm, err := NewCatalogManager(bufmgr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := cm.CreateSchema("staging"); err != nil {
+		t.Fatal(err)
+	}
+	users, err := cm.CreateTableWithFormat("users", []ColumnDef{
+		{Name: "id", Type: ColumnTypeInt, IsPrimaryKey: true},
+		{Name: "email", Type: ColumnTypeVarchar, Size: 64},
+		{Name: "ssn", Type: ColumnTypeVarchar, Nullable: true, Encryption: &ColumnEncryption{KeyID: 7, Decrypt: PrivilegeSelect}},
+		{Name: "plan", Type: ColumnTypeVarchar, Default: []byte("free")},
+		{Name: "note", Type: ColumnTypeVarchar, Nullable: true, Default: []byte{}},
+	}, tuple.FormatV2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := cm.CreateTable("staging.events", []ColumnDef{{Name: "id", Type: ColumnTypeInt, IsPrimaryKey: true}}); err != nil {
+		t.Fatal(err)
+	}
+	if err := cm.CreateRole("analyst", false); err != nil {
+		t.Fatal(err)
+	}
+	if err := cm.Grant("analyst", "users", PrivilegeSelect); err != nil {
+		t.Fatal(err)
+	}
+
+	// A unique index on email, recorded in indexes_catalog
+	emailIndex := &table.UniqueIndex{Skey: []int{1}}
+	if err := emailIndex.Create(bufmgr); err != nil {
+		t.Fatal(err)
+	}
+	index := IndexDef{IndexID: 100, IndexName: "users_email", TableID: users.TableID, MetaPageID: emailIndex.MetaPageID, IsUnique: true, ColumnIndices: []int{1}}
+	if err := cm.indexesCatalog.Insert(bufmgr, indexRecord(index)); err != nil {
+		t.Fatal(err)
+	}
+	users.Indexes = []IndexDef{index}
+
+	usersTable, err := cm.OpenTable("users")
+	if err != nil {
+		t.Fatal(err)
+	}
+	usersTable.UniqueIndices = []*table.UniqueIndex{emailIndex}
+	if err := usersTable.Insert(bufmgr, [][]byte{[]byte("1"), []byte("alice@example.com"), nil, []byte("pro"), nil}); err != nil {
+		t.Fatal(err)
+	}
+	if err := bufmgr.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	if err := dm.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// After a restart
+	dm, err = disk.OpenDiskManager(tmpfile.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer dm.Close()
+	bufmgr = buffer.NewBufferPoolManager(dm, buffer.NewBufferPool(10))
+	cm, err = OpenCatalogManager(bufmgr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(cm.schemaCache) != 0 {
+		t.Errorf("expected schemas to be loaded lazily, got %d cached", len(cm.schemaCache))
+	}
+	names, err := cm.ListTables()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(names, []string{"staging.events", "users"}) {
+		t.Errorf("unexpected tables %v", names)
+	}
+	if !reflect.DeepEqual(cm.Schemas(), []string{"public", "staging"}) {
+		t.Errorf("unexpected schemas %v", cm.Schemas())
+	}
+
+	schema, err := cm.GetTableSchema("public.users")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(schema, users) {
+		t.Errorf("expected %+v, got %+v", users, schema)
+	}
+	if _, err := cm.GetTableSchema("missing"); err != ErrTableNotFound {
+		t.Errorf("expected ErrTableNotFound, got %v", err)
+	}
+	if privs, err := cm.TablePrivileges("analyst", "users"); err != nil || privs != PrivilegeSelect {
+		t.Errorf("expected the grant to survive, got %v, %v", privs, err)
+	}
+
+	usersTable, err = cm.OpenTable("users")
+	if err != nil {
+		t.Fatal(err)
+	}
+	row, err := usersTable.Get(bufmgr, [][]byte{[]byte("1")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(row[1]) != "alice@example.com" || string(row[3]) != "pro" {
+		t.Errorf("unexpected row %q", row)
+	}
+	// The column definitions travel with the table, e.g. into snapshots
+	if len(usersTable.ColumnDefs) != len(users.Columns) {
+		t.Fatalf("expected %d column definitions, got %d", len(users.Columns), len(usersTable.ColumnDefs))
+	}
+	for i, encoded := range usersTable.ColumnDefs {
+		col, err := DecodeColumnDef(encoded)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(col, users.Columns[i]) {
+			t.Errorf("column %d: expected %+v, got %+v", i, users.Columns[i], col)
+		}
+	}
+	// The unique index is maintained by the reopened table
+	err = usersTable.Insert(bufmgr, [][]byte{[]byte("2"), []byte("alice@example.com"), nil, []byte("free"), nil})
+	var violation *table.ConstraintViolationError
+	if !errors.As(err, &violation) || violation.Index != "users_email" || string(violation.Key[0]) != "alice@example.com" {
+		t.Errorf("expected a violation of users_email, got %v", err)
+	}
+
+	// New tables and roles do not reuse IDs
+	orders, err := cm.CreateTable("orders", []ColumnDef{{Name: "id", Type: ColumnTypeInt, IsPrimaryKey: true}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if orders.TableID <= index.IndexID {
+		t.Errorf("expected a new table ID after %d, got %d", index.IndexID, orders.TableID)
+	}
+	if _, err := cm.CreateTable("users", nil); err != ErrTableExists {
+		t.Errorf("expected ErrTableExists, got %v", err)
+	}
+	if err := cm.CreateRole("writer", false); err != nil {
+		t.Fatal(err)
+	}
+	if id, _, err := cm.findRoleInCatalog("writer"); err != nil || id != 2 {
+		t.Errorf("expected role ID 2, got %d, %v", id, err)
+	}
+	if err := cm.DropTable("staging.events"); err != nil {
+		t.Fatal(err)
+	}
+	if names, _ := cm.ListTables(); !reflect.DeepEqual(names, []string{"orders", "users"}) {
+		t.Errorf("unexpected tables %v", names)
+	}
+}
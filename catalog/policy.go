@@ -0,0 +1,54 @@
+package catalog
+
+// Privileges is a set of privilege bits held by a session.
+type Privileges uint64
+
+// Has reports whether all bits of required are held.
+func (p Privileges) Has(required Privileges) bool {
+	return p&required == required
+}
+
+// MaskFunc redacts a column value (e.g. replaces it with "***").
+type MaskFunc func(value []byte) []byte
+
+// ColumnMask redacts a column for sessions that do not hold the Unmasked privileges.
+type ColumnMask struct {
+	ColumnIndex int        // Index of the masked column in the table's tuples
+	Mask        MaskFunc   // Redaction applied to the column value
+	Unmasked    Privileges // Privileges required to see the raw value (0 masks for everyone)
+}
+
+// SecurityPolicy hides rows and redacts columns of a table depending on the session's privileges.
+// Column indices refer to the full tuples of the table, so the policy must be applied to
+// scan output before any projection.
+type SecurityPolicy struct {
+	RowFilter       func(tup [][]byte) bool // Optional: rows for which it returns false are hidden
+	RowFilterExempt Privileges              // Privileges that bypass RowFilter (0 applies it to everyone)
+	ColumnMasks     []ColumnMask
+}
+
+// Apply returns the tuple as seen by a session holding privs, and false if the row is hidden.
+// The input tuple is not modified.
+func (sp *SecurityPolicy) Apply(tup [][]byte, privs Privileges) ([][]byte, bool) {
+	if sp.RowFilter != nil && !exempt(privs, sp.RowFilterExempt) && !sp.RowFilter(tup) {
+		return nil, false
+	}
+	result := tup
+	copied := false
+	for _, cm := range sp.ColumnMasks {
+		if exempt(privs, cm.Unmasked) || cm.ColumnIndex < 0 || len(tup) <= cm.ColumnIndex {
+			continue
+		}
+		if !copied {
+			result = make([][]byte, len(tup))
+			copy(result, tup)
+			copied = true
+		}
+		result[cm.ColumnIndex] = cm.Mask(tup[cm.ColumnIndex])
+	}
+	return result, true
+}
+
+func exempt(privs Privileges, required Privileges) bool {
+	return required != 0 && privs.Has(required)
+}
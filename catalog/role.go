@@ -0,0 +1,299 @@
+package catalog
+
+import (
+	"bytes"
+	"crypto/pbkdf2"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/binary"
+
+	"github.com/Johniel/gorelly/btree"
+	"github.com/Johniel/gorelly/errcode"
+	"github.com/Johniel/gorelly/table"
+	"github.com/Johniel/gorelly/tuple"
+)
+
+var (
+	ErrRoleNotFound     = errcode.New(errcode.NotFound, "role not found")
+	ErrRoleExists       = errcode.New(errcode.AlreadyExists, "role already exists")
+	ErrPermissionDenied = errcode.New(errcode.PermissionDenied, "permission denied")
+	// ErrAuthenticationFailed is returned by Authenticate for an unknown role, a role without a
+	// password, or a wrong password, without telling which.
+	ErrAuthenticationFailed = errcode.New(errcode.InvalidAuthorization, "password authentication failed")
+)
+
+// Passwords are stored as [iterations:4][salt][key] of PBKDF2 with SHA-256.
+const (
+	passwordIterations = 100000
+	passwordSaltSize   = 16
+	passwordKeySize    = 32
+)
+
+// Table privileges granted to roles. The remaining bits are free for application-defined
+// privileges, such as those exempting a session from a SecurityPolicy.
+const (
+	PrivilegeSelect Privileges = 1 << iota
+	PrivilegeInsert
+	PrivilegeUpdate
+	PrivilegeDelete
+)
+
+// AllPrivileges is held by superuser roles on every table.
+const AllPrivileges = ^Privileges(0)
+
+// CreateRole adds a role to roles_catalog. A superuser role holds every privilege on every table.
+func (cm *CatalogManager) CreateRole(roleName string, superuser bool) error {
+	cm.mu.Lock()
+	defer cm.mu.Unlock()
+
+	if _, _, err := cm.findRoleInCatalog(roleName); err == nil {
+		return ErrRoleExists
+	} else if err != ErrRoleNotFound {
+		return err
+	}
+
+	roleIDBytes := make([]byte, 4)
+	binary.BigEndian.PutUint32(roleIDBytes, cm.nextRoleID)
+	superuserBytes := []byte{0}
+	if superuser {
+		superuserBytes[0] = 1
+	}
+	tup := [][]byte{
+		roleIDBytes,      // PK
+		[]byte(roleName), // role_name
+		superuserBytes,   // superuser
+	}
+	if err := cm.rolesCatalog.Insert(cm.bufmgr, tup); err != nil {
+		return err
+	}
+	cm.nextRoleID++
+	return nil
+}
+
+// SetPassword sets the password a session authenticates as the role with (see Authenticate).
+// Only a salted hash of it is stored.
+func (cm *CatalogManager) SetPassword(roleName string, password string) error {
+	salt := make([]byte, passwordSaltSize)
+	if _, err := rand.Read(salt); err != nil {
+		return err
+	}
+	key, err := pbkdf2.Key(sha256.New, password, salt, passwordIterations, passwordKeySize)
+	if err != nil {
+		return err
+	}
+	stored := binary.BigEndian.AppendUint32(nil, passwordIterations)
+	stored = append(append(stored, salt...), key...)
+
+	cm.mu.Lock()
+	defer cm.mu.Unlock()
+	keyElems, valueElems, err := cm.findRole(roleName)
+	if err != nil {
+		return err
+	}
+	tup := append(keyElems, valueElems[0], valueElems[1], stored)
+	return cm.rolesCatalog.Update(cm.bufmgr, tup)
+}
+
+// Authenticate checks the password of a role, returning ErrAuthenticationFailed unless the role
+// exists and has that password. Roles without a password (see SetPassword) cannot authenticate.
+func (cm *CatalogManager) Authenticate(roleName string, password string) error {
+	cm.mu.RLock()
+	_, valueElems, err := cm.findRole(roleName)
+	cm.mu.RUnlock()
+	if err == ErrRoleNotFound {
+		return ErrAuthenticationFailed
+	}
+	if err != nil {
+		return err
+	}
+	if len(valueElems) < 3 || len(valueElems[2]) != 4+passwordSaltSize+passwordKeySize {
+		return ErrAuthenticationFailed
+	}
+	stored := valueElems[2]
+	iterations := int(binary.BigEndian.Uint32(stored))
+	salt := stored[4 : 4+passwordSaltSize]
+	key, err := pbkdf2.Key(sha256.New, password, salt, iterations, passwordKeySize)
+	if err != nil {
+		return err
+	}
+	if subtle.ConstantTimeCompare(key, stored[4+passwordSaltSize:]) != 1 {
+		return ErrAuthenticationFailed
+	}
+	return nil
+}
+
+// Grant adds privileges on a table to a role.
+func (cm *CatalogManager) Grant(roleName string, tableName string, privs Privileges) error {
+	if err := cm.loadSchema(tableName); err != nil {
+		return err
+	}
+	cm.mu.Lock()
+	defer cm.mu.Unlock()
+
+	roleID, tableID, current, found, err := cm.findGrant(roleName, tableName)
+	if err != nil {
+		return err
+	}
+	tup := grantTuple(roleID, tableID, current|privs)
+	if found {
+		return cm.grantsCatalog.Update(cm.bufmgr, tup)
+	}
+	return cm.grantsCatalog.Insert(cm.bufmgr, tup)
+}
+
+// Revoke removes privileges on a table from a role.
+func (cm *CatalogManager) Revoke(roleName string, tableName string, privs Privileges) error {
+	if err := cm.loadSchema(tableName); err != nil {
+		return err
+	}
+	cm.mu.Lock()
+	defer cm.mu.Unlock()
+
+	roleID, tableID, current, found, err := cm.findGrant(roleName, tableName)
+	if err != nil || !found {
+		return err
+	}
+	tup := grantTuple(roleID, tableID, current&^privs)
+	if current&^privs == 0 {
+		return cm.grantsCatalog.Delete(cm.bufmgr, tup)
+	}
+	return cm.grantsCatalog.Update(cm.bufmgr, tup)
+}
+
+// TablePrivileges returns the privileges a role holds on a table.
+func (cm *CatalogManager) TablePrivileges(roleName string, tableName string) (Privileges, error) {
+	if err := cm.loadSchema(tableName); err != nil {
+		return 0, err
+	}
+	cm.mu.RLock()
+	defer cm.mu.RUnlock()
+
+	_, superuser, err := cm.findRoleInCatalog(roleName)
+	if err != nil {
+		return 0, err
+	}
+	if superuser {
+		if _, exists := cm.schemaCache[canonicalTableName(tableName)]; !exists {
+			return 0, ErrTableNotFound
+		}
+		return AllPrivileges, nil
+	}
+	_, _, privs, _, err := cm.findGrant(roleName, tableName)
+	return privs, err
+}
+
+// CheckPrivileges returns ErrPermissionDenied unless the role holds all of required on the table.
+func (cm *CatalogManager) CheckPrivileges(roleName string, tableName string, required Privileges) error {
+	privs, err := cm.TablePrivileges(roleName, tableName)
+	if err != nil {
+		return err
+	}
+	if !privs.Has(required) {
+		return ErrPermissionDenied
+	}
+	return nil
+}
+
+// TableAccess returns a check for table.Table.Access that enforces the role's
+// INSERT, UPDATE and DELETE privileges on the table.
+// Privileges are looked up on every change, so grants and revokes take effect immediately.
+func (cm *CatalogManager) TableAccess(roleName string, tableName string) func(op table.AccessOp) error {
+	return func(op table.AccessOp) error {
+		var required Privileges
+		switch op {
+		case table.AccessInsert:
+			required = PrivilegeInsert
+		case table.AccessUpdate:
+			required = PrivilegeUpdate
+		case table.AccessDelete:
+			required = PrivilegeDelete
+		}
+		return cm.CheckPrivileges(roleName, tableName, required)
+	}
+}
+
+func (cm *CatalogManager) findRoleInCatalog(roleName string) (uint32, bool, error) {
+	keyElems, valueElems, err := cm.findRole(roleName)
+	if err != nil {
+		return 0, false, err
+	}
+	return binary.BigEndian.Uint32(keyElems[0]), valueElems[1][0] == 1, nil
+}
+
+// findRole returns the roles_catalog record of a role: the role ID as the key, and the name,
+// the superuser flag and, once set, the password as the values.
+func (cm *CatalogManager) findRole(roleName string) (keyElems [][]byte, valueElems [][]byte, err error) {
+	bt := btree.NewBTree(cm.rolesCatalog.MetaPageID)
+	iter, err := bt.Search(cm.bufmgr, btree.NewSearchModeStart())
+	if err != nil {
+		return nil, nil, err
+	}
+	defer iter.Close()
+
+	for {
+		keyBytes, valueBytes, ok, err := iter.Next(cm.bufmgr)
+		if err != nil {
+			return nil, nil, err
+		}
+		if !ok {
+			break
+		}
+
+		var elems [][]byte
+		tuple.Decode(valueBytes, &elems)
+		if 0 < len(elems) && string(elems[0]) == roleName {
+			tuple.Decode(keyBytes, &keyElems)
+			return keyElems, elems, nil
+		}
+	}
+
+	return nil, nil, ErrRoleNotFound
+}
+
+// findGrant looks up the grants_catalog record of a role on a table.
+// found is false (with no error) when the role holds no privileges on the table.
+func (cm *CatalogManager) findGrant(roleName string, tableName string) (roleID uint32, tableID uint32, privs Privileges, found bool, err error) {
+	roleID, _, err = cm.findRoleInCatalog(roleName)
+	if err != nil {
+		return 0, 0, 0, false, err
+	}
+	schema, exists := cm.schemaCache[canonicalTableName(tableName)]
+	if !exists {
+		return 0, 0, 0, false, ErrTableNotFound
+	}
+	tableID = schema.TableID
+
+	keyBytes := make([]byte, 0)
+	tuple.Encode(grantTuple(roleID, tableID, 0)[:2], &keyBytes)
+	bt := btree.NewBTree(cm.grantsCatalog.MetaPageID)
+	iter, err := bt.Search(cm.bufmgr, btree.NewSearchModeKey(keyBytes))
+	if err != nil {
+		return 0, 0, 0, false, err
+	}
+	defer iter.Close()
+	foundKey, valueBytes, ok, err := iter.Next(cm.bufmgr)
+	if err != nil {
+		return 0, 0, 0, false, err
+	}
+	if !ok || !bytes.Equal(foundKey, keyBytes) {
+		return roleID, tableID, 0, false, nil
+	}
+	var valueElems [][]byte
+	tuple.Decode(valueBytes, &valueElems)
+	return roleID, tableID, Privileges(binary.BigEndian.Uint64(valueElems[0])), true, nil
+}
+
+func grantTuple(roleID uint32, tableID uint32, privs Privileges) [][]byte {
+	roleIDBytes := make([]byte, 4)
+	binary.BigEndian.PutUint32(roleIDBytes, roleID)
+	tableIDBytes := make([]byte, 4)
+	binary.BigEndian.PutUint32(tableIDBytes, tableID)
+	privilegesBytes := make([]byte, 8)
+	binary.BigEndian.PutUint64(privilegesBytes, uint64(privs))
+	return [][]byte{
+		roleIDBytes,     // PK part 1
+		tableIDBytes,    // PK part 2
+		privilegesBytes, // privileges
+	}
+}
@@ -0,0 +1,101 @@
+package catalog
+
+import (
+	"sync"
+)
+
+// schemaLock is the schema-level lock of a table name. DDL on the table holds it exclusively;
+// sessions using the table hold it shared (see AcquireSchema), so DDL waits for running
+// statements and statements never observe a table whose DDL is in progress.
+type schemaLock struct {
+	mu   sync.RWMutex
+	refs int // Holders and waiters; the lock is dropped from the map when it reaches 0
+}
+
+// schemaLocks maps table names to their schema locks. Lock order: cm.ddlMu, then schema locks,
+// then cm.mu; only a DDL holds several schema locks at once.
+type schemaLocks struct {
+	locks map[string]*schemaLock
+	mu    sync.Mutex
+}
+
+func (sl *schemaLocks) get(tableName string) *schemaLock {
+	sl.mu.Lock()
+	defer sl.mu.Unlock()
+	if sl.locks == nil {
+		sl.locks = make(map[string]*schemaLock)
+	}
+	lock, ok := sl.locks[tableName]
+	if !ok {
+		lock = &schemaLock{}
+		sl.locks[tableName] = lock
+	}
+	lock.refs++
+	return lock
+}
+
+func (sl *schemaLocks) put(tableName string, lock *schemaLock) {
+	sl.mu.Lock()
+	defer sl.mu.Unlock()
+	lock.refs--
+	if lock.refs == 0 {
+		delete(sl.locks, tableName)
+	}
+}
+
+// lockShared takes the schema lock of a table shared and returns the function releasing it.
+func (sl *schemaLocks) lockShared(tableName string) func() {
+	lock := sl.get(tableName)
+	lock.mu.RLock()
+	return func() {
+		lock.mu.RUnlock()
+		sl.put(tableName, lock)
+	}
+}
+
+// lockExclusive takes the schema lock of a table exclusively and returns the function releasing it.
+func (sl *schemaLocks) lockExclusive(tableName string) func() {
+	lock := sl.get(tableName)
+	lock.mu.Lock()
+	return func() {
+		lock.mu.Unlock()
+		sl.put(tableName, lock)
+	}
+}
+
+// AcquireSchema takes a shared schema lock on a table and returns a copy of its schema.
+// Until release is called, DDL on the table waits, so the schema stays valid for planning and
+// executing statements against the table. If DDL creating the table is in progress, it waits
+// for the DDL to finish, and returns ErrTableNotFound if the table was not created.
+func (cm *CatalogManager) AcquireSchema(tableName string) (schema *TableSchema, release func(), err error) {
+	tableName = canonicalTableName(tableName)
+	release = cm.schemaLocks.lockShared(tableName)
+	cm.mu.RLock()
+	cached, exists := cm.schemaCache[tableName]
+	cm.mu.RUnlock()
+	if !exists {
+		// Not used since the catalog was opened
+		if cached, err = cm.lockedSchema(tableName); err != nil {
+			release()
+			return nil, nil, err
+		}
+	}
+	return cached.clone(), release, nil
+}
+
+// clone returns a deep copy of the schema, so that callers cannot change the cached one.
+func (ts *TableSchema) clone() *TableSchema {
+	c := *ts
+	c.Columns = append([]ColumnDef(nil), ts.Columns...)
+	for i, col := range c.Columns {
+		c.Columns[i].Check = append([]CheckCond(nil), col.Check...)
+	}
+	c.Indexes = make([]IndexDef, len(ts.Indexes))
+	for i, index := range ts.Indexes {
+		c.Indexes[i] = index
+		c.Indexes[i].ColumnIndices = append([]int(nil), index.ColumnIndices...)
+	}
+	c.ForeignKeys = cloneForeignKeys(ts.ForeignKeys)
+	c.ReferencedBy = cloneForeignKeys(ts.ReferencedBy)
+	return &c
+}
@@ -0,0 +1,194 @@
+package catalog
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/Johniel/gorelly/btree"
+	"github.com/Johniel/gorelly/buffer"
+	"github.com/Johniel/gorelly/disk"
+	"github.com/Johniel/gorelly/table"
+)
+
+func TestSchemaLocks(t *testing.T) {
+	tmpfile, err := os.CreateTemp("", "test_schema_locks_*.db")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(tmpfile.Name())
+
+	dm, err := disk.NewDiskManager(tmpfile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer dm.Close()
+
+	pool := buffer.NewBufferPool(64)
+	bufmgr := buffer.NewBufferPoolManager(dm, pool)
+
+	cm, err := NewCatalogManager(bufmgr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	columns := []ColumnDef{
+		{Name: "id", Type: ColumnTypeInt, IsPrimaryKey: true},
+		{Name: "name", Type: ColumnTypeVarchar},
+		{Name: "note", Type: ColumnTypeVarchar},
+	}
+	base, err := cm.CreateTable("base", columns)
+	if err != nil {
+		t.Fatal(err)
+	}
+	baseTable := &table.Table{MetaPageID: base.MetaPageID, NumKeyElems: base.NumKeyElems}
+	const numRows = 20
+	for i := 0; i < numRows; i++ {
+		if err := baseTable.Insert(bufmgr, [][]byte{[]byte(fmt.Sprintf("%02d", i)), []byte("n"), []byte("x")}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	t.Run("ConcurrentDDLAndScans", func(t *testing.T) {
+		const numTables = 8
+		var wg sync.WaitGroup
+		errs := make(chan error, 64)
+		for i := 0; i < numTables; i++ {
+			wg.Add(1)
+			go func(i int) {
+				defer wg.Done()
+				if _, err := cm.CreateTable(fmt.Sprintf("t%d", i), columns); err != nil {
+					errs <- err
+				}
+			}(i)
+		}
+		for i := 0; i < 4; i++ {
+			wg.Add(1)
+			go func(i int) {
+				defer wg.Done()
+				for j := 0; j < 20; j++ {
+					// A table being created is either not there yet or complete
+					if schema, release, err := cm.AcquireSchema(fmt.Sprintf("t%d", (i+j)%numTables)); err == nil {
+						if len(schema.Columns) != len(columns) {
+							errs <- fmt.Errorf("observed %d columns", len(schema.Columns))
+						}
+						release()
+					} else if err != ErrTableNotFound {
+						errs <- err
+					}
+
+					schema, release, err := cm.AcquireSchema("base")
+					if err != nil {
+						errs <- err
+						return
+					}
+					iter, err := btree.NewBTree(schema.MetaPageID).Search(bufmgr, btree.NewSearchModeStart())
+					if err != nil {
+						errs <- err
+						release()
+						return
+					}
+					n := 0
+					for {
+						_, _, ok, err := iter.Next(bufmgr)
+						if err != nil {
+							errs <- err
+							break
+						}
+						if !ok {
+							break
+						}
+						n++
+					}
+					release()
+					if n != numRows {
+						errs <- fmt.Errorf("scanned %d rows, expected %d", n, numRows)
+					}
+				}
+			}(i)
+		}
+		wg.Wait()
+		close(errs)
+		for err := range errs {
+			t.Error(err)
+		}
+		for i := 0; i < numTables; i++ {
+			schema, release, err := cm.AcquireSchema(fmt.Sprintf("t%d", i))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(schema.Columns) != len(columns) {
+				t.Errorf("expected %d columns, got %d", len(columns), len(schema.Columns))
+			}
+			release()
+		}
+	})
+
+	t.Run("DDLWaitsForUsers", func(t *testing.T) {
+		_, release, err := cm.AcquireSchema("base")
+		if err != nil {
+			t.Fatal(err)
+		}
+		done := make(chan error)
+		go func() {
+			_, err := cm.CreateTable("base", columns)
+			done <- err
+		}()
+		select {
+		case err := <-done:
+			t.Fatalf("DDL ran while the schema was in use: %v", err)
+		case <-time.After(50 * time.Millisecond):
+		}
+		release()
+		if err := <-done; err != ErrTableExists {
+			t.Errorf("expected ErrTableExists, got %v", err)
+		}
+	})
+
+	t.Run("FailedCreateLeavesNothing", func(t *testing.T) {
+		countTables := func() int {
+			iter, err := btree.NewBTree(cm.tablesCatalog.MetaPageID).Search(bufmgr, btree.NewSearchModeStart())
+			if err != nil {
+				t.Fatal(err)
+			}
+			n := 0
+			for {
+				_, _, ok, err := iter.Next(bufmgr)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if !ok {
+					return n
+				}
+				n++
+			}
+		}
+		before := countTables()
+		// The column record of the second column is rejected by the catalog
+		cm.columnsCatalog.Checks = []*table.Check{{
+			Name:  "short_column_name",
+			Holds: func(tup [][]byte) bool { return len(tup[2]) < 3000 },
+		}}
+		badColumns := []ColumnDef{
+			{Name: "id", Type: ColumnTypeInt, IsPrimaryKey: true},
+			{Name: strings.Repeat("c", 3000), Type: ColumnTypeVarchar},
+		}
+		_, err := cm.CreateTable("bad", badColumns)
+		cm.columnsCatalog.Checks = nil
+		if !errors.Is(err, table.ErrCheckViolation) {
+			t.Fatalf("expected ErrCheckViolation, got %v", err)
+		}
+		if _, _, err := cm.AcquireSchema("bad"); err != ErrTableNotFound {
+			t.Errorf("expected ErrTableNotFound, got %v", err)
+		}
+		if after := countTables(); after != before {
+			t.Errorf("expected %d catalog records, got %d", before, after)
+		}
+		if _, err := cm.CreateTable("bad", columns); err != nil {
+			t.Errorf("expected the name to be usable again, got %v", err)
+		}
+	})
+}
@@ -0,0 +1,145 @@
+package catalog
+
+import (
+	"encoding/binary"
+	"errors"
+	"time"
+
+	"github.com/Johniel/gorelly/btree"
+	"github.com/Johniel/gorelly/clock"
+	"github.com/Johniel/gorelly/table"
+	"github.com/Johniel/gorelly/tuple"
+)
+
+// AccessStats returns the access counters of a table. They are created on first use, continuing
+// from the values last persisted to table_stats_catalog by PersistAccessStats.
+//
+// To fill them, pass the counters to the scans of the table (query.SeqScan.Stats and the like)
+// and set &stats.DML as the table's DML counters, which the vacuum scheduler can watch as well.
+func (cm *CatalogManager) AccessStats(tableName string) (*table.AccessStats, error) {
+	tableName = canonicalTableName(tableName)
+	cm.mu.Lock()
+	defer cm.mu.Unlock()
+
+	schema, err := cm.cachedSchema(tableName)
+	if err != nil {
+		return nil, err
+	}
+	if stats, ok := cm.accessStats[tableName]; ok {
+		return stats, nil
+	}
+	persisted, _, err := cm.findAccessStats(schema.TableID)
+	if err != nil {
+		return nil, err
+	}
+	stats := &table.AccessStats{}
+	stats.Add(persisted)
+	cm.accessStats[tableName] = stats
+	return stats, nil
+}
+
+// AccessSnapshots returns the current values of the access counters of every table that has
+// them, by table name, e.g. for capacity dashboards.
+func (cm *CatalogManager) AccessSnapshots() map[string]table.AccessSnapshot {
+	cm.mu.RLock()
+	defer cm.mu.RUnlock()
+	snapshots := make(map[string]table.AccessSnapshot, len(cm.accessStats))
+	for tableName, stats := range cm.accessStats {
+		snapshots[tableName] = stats.Snapshot()
+	}
+	return snapshots
+}
+
+// PersistAccessStats writes the current values of the access counters to table_stats_catalog,
+// so that they survive a restart.
+func (cm *CatalogManager) PersistAccessStats() error {
+	cm.mu.Lock()
+	defer cm.mu.Unlock()
+
+	for tableName, stats := range cm.accessStats {
+		schema, exists := cm.schemaCache[tableName]
+		if !exists {
+			continue
+		}
+		_, found, err := cm.findAccessStats(schema.TableID)
+		if err != nil {
+			return err
+		}
+		tup := accessStatsTuple(schema.TableID, stats.Snapshot())
+		if found {
+			err = cm.statsCatalog.Update(cm.bufmgr, tup)
+		} else {
+			err = cm.statsCatalog.Insert(cm.bufmgr, tup)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// PersistAccessStatsEvery calls PersistAccessStats every interval until the returned stop function
+// is called. Failures are passed to onError, which may be nil.
+func (cm *CatalogManager) PersistAccessStatsEvery(c clock.Clock, interval time.Duration, onError func(error)) (stop func()) {
+	return c.Every(interval, func() {
+		if err := cm.PersistAccessStats(); err != nil && onError != nil {
+			onError(err)
+		}
+	})
+}
+
+func (cm *CatalogManager) findAccessStats(tableID uint32) (table.AccessSnapshot, bool, error) {
+	tableIDBytes := make([]byte, 4)
+	binary.BigEndian.PutUint32(tableIDBytes, tableID)
+	keyBytes := make([]byte, 0)
+	tuple.Encode([][]byte{tableIDBytes}, &keyBytes)
+	bt := btree.NewBTree(cm.statsCatalog.MetaPageID)
+	iter, err := bt.Search(cm.bufmgr, btree.NewSearchModeKey(keyBytes))
+	if err != nil {
+		return table.AccessSnapshot{}, false, err
+	}
+	defer iter.Close()
+	foundKey, valueBytes, ok := iter.Get()
+	if !ok || string(foundKey) != string(keyBytes) {
+		return table.AccessSnapshot{}, false, nil
+	}
+	var valueElems [][]byte
+	tuple.Decode(valueBytes, &valueElems)
+	if len(valueElems) != 8 {
+		return table.AccessSnapshot{}, false, errors.New("malformed table_stats_catalog record")
+	}
+	counter := func(i int) uint64 {
+		return binary.BigEndian.Uint64(valueElems[i])
+	}
+	return table.AccessSnapshot{
+		SeqScans:     counter(0),
+		IndexScans:   counter(1),
+		RowsRead:     counter(2),
+		Inserts:      counter(3),
+		Updates:      counter(4),
+		Deletes:      counter(5),
+		PagesFetched: counter(6),
+		PagesRead:    counter(7),
+	}, true, nil
+}
+
+func accessStatsTuple(tableID uint32, s table.AccessSnapshot) [][]byte {
+	tableIDBytes := make([]byte, 4)
+	binary.BigEndian.PutUint32(tableIDBytes, tableID)
+	tup := [][]byte{tableIDBytes} // PK
+	for _, counter := range []uint64{
+		s.SeqScans,     // seq_scans
+		s.IndexScans,   // index_scans
+		s.RowsRead,     // rows_read
+		s.Inserts,      // inserts
+		s.Updates,      // updates
+		s.Deletes,      // deletes
+		s.PagesFetched, // pages_fetched
+		s.PagesRead,    // pages_read
+	} {
+		counterBytes := make([]byte, 8)
+		binary.BigEndian.PutUint64(counterBytes, counter)
+		tup = append(tup, counterBytes)
+	}
+	return tup
+}
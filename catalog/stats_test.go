@@ -0,0 +1,73 @@
+package catalog
+
+import (
+	"os"
+	"testing"
+
+	"github.com/Johniel/gorelly/buffer"
+	"github.com/Johniel/gorelly/disk"
+	"github.com/Johniel/gorelly/table"
+)
+
+func TestAccessStats(t *testing.T) {
+	tmpfile, err := os.CreateTemp("", "test_access_stats_*.db")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(tmpfile.Name())
+
+	dm, err := disk.NewDiskManager(tmpfile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer dm.Close()
+
+	pool := buffer.NewBufferPool(10)
+	bufmgr := buffer.NewBufferPoolManager(dm, pool)
+
+	cm, err := NewCatalogManager(bufmgr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := cm.CreateTable("users", []ColumnDef{
+		{Name: "id", Type: ColumnTypeInt, IsPrimaryKey: true},
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := cm.AccessStats("missing"); err != ErrTableNotFound {
+		t.Errorf("expected ErrTableNotFound, got %v", err)
+	}
+
+	stats, err := cm.AccessStats("users")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if again, _ := cm.AccessStats("users"); again != stats {
+		t.Errorf("expected the same counters on every call")
+	}
+	stats.Add(table.AccessSnapshot{SeqScans: 2, IndexScans: 3, RowsRead: 40, Inserts: 5, PagesFetched: 10, PagesRead: 4})
+	if err := cm.PersistAccessStats(); err != nil {
+		t.Fatal(err)
+	}
+	// Persisting again updates the existing record
+	stats.SeqScans.Add(1)
+	if err := cm.PersistAccessStats(); err != nil {
+		t.Fatal(err)
+	}
+
+	snapshot := cm.AccessSnapshots()["users"]
+	if snapshot.RowsWritten() != 5 || snapshot.CacheHitRatio() != 0.6 {
+		t.Errorf("unexpected snapshot %+v", snapshot)
+	}
+
+	// Counters created again, e.g. after a restart, continue from the persisted values
+	delete(cm.accessStats, "users")
+	reloaded, err := cm.AccessStats("users")
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := table.AccessSnapshot{SeqScans: 3, IndexScans: 3, RowsRead: 40, Inserts: 5, PagesFetched: 10, PagesRead: 4}
+	if got := reloaded.Snapshot(); got != expected {
+		t.Errorf("expected %+v, got %+v", expected, got)
+	}
+}
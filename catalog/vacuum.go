@@ -0,0 +1,45 @@
+package catalog
+
+import (
+	"github.com/Johniel/gorelly/btree"
+)
+
+// VacuumProgress returns the progress of the incremental vacuum pass of a target last saved by
+// SetVacuumProgress, or nil if the target has no pass in progress.
+// It makes the catalog manager a vacuum.ProgressStore.
+func (cm *CatalogManager) VacuumProgress(name string) ([]byte, error) {
+	cm.mu.RLock()
+	defer cm.mu.RUnlock()
+	tup, err := cm.vacuumCatalog.Get(cm.bufmgr, [][]byte{[]byte(name)})
+	if err == btree.ErrKeyNotFound {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return tup[1], nil
+}
+
+// SetVacuumProgress saves the progress of the incremental vacuum pass of a target in
+// vacuum_progress_catalog, so that the pass resumes there after a restart. Empty progress, for a
+// completed pass, removes it.
+func (cm *CatalogManager) SetVacuumProgress(name string, progress []byte) error {
+	cm.mu.Lock()
+	defer cm.mu.Unlock()
+	key := [][]byte{[]byte(name)}
+	_, err := cm.vacuumCatalog.Get(cm.bufmgr, key)
+	found := err == nil
+	if err != nil && err != btree.ErrKeyNotFound {
+		return err
+	}
+	switch {
+	case len(progress) == 0 && !found:
+		return nil
+	case len(progress) == 0:
+		return cm.vacuumCatalog.Delete(cm.bufmgr, key)
+	case found:
+		return cm.vacuumCatalog.Update(cm.bufmgr, [][]byte{[]byte(name), progress})
+	default:
+		return cm.vacuumCatalog.Insert(cm.bufmgr, [][]byte{[]byte(name), progress})
+	}
+}
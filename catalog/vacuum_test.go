@@ -0,0 +1,54 @@
+package catalog
+
+import (
+	"os"
+	"testing"
+
+	"github.com/Johniel/gorelly/buffer"
+	"github.com/Johniel/gorelly/disk"
+	"github.com/Johniel/gorelly/vacuum"
+)
+
+func TestVacuumProgress(t *testing.T) {
+	tmpfile, err := os.CreateTemp("", "test_vacuum_progress_*.db")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(tmpfile.Name())
+
+	dm, err := disk.NewDiskManager(tmpfile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer dm.Close()
+
+	pool := buffer.NewBufferPool(10)
+	bufmgr := buffer.NewBufferPoolManager(dm, pool)
+
+	cm, err := NewCatalogManager(bufmgr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var _ vacuum.ProgressStore = cm
+
+	if progress, err := cm.VacuumProgress("users"); err != nil || progress != nil {
+		t.Errorf("expected no progress, got %q, %v", progress, err)
+	}
+	for _, progress := range []string{"row0042", "row0100"} {
+		if err := cm.SetVacuumProgress("users", []byte(progress)); err != nil {
+			t.Fatal(err)
+		}
+		if got, err := cm.VacuumProgress("users"); err != nil || string(got) != progress {
+			t.Errorf("expected %q, got %q, %v", progress, got, err)
+		}
+	}
+	// A completed pass removes the progress
+	for range 2 {
+		if err := cm.SetVacuumProgress("users", nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if progress, err := cm.VacuumProgress("users"); err != nil || progress != nil {
+		t.Errorf("expected the progress to be removed, got %q, %v", progress, err)
+	}
+}
@@ -0,0 +1,148 @@
+// Package clock abstracts time for the database's timestamps and background workers.
+// Production code uses System; tests inject a Logical clock to drive schedules deterministically.
+package clock
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+// Clock provides the current time and periodic callbacks.
+type Clock interface {
+	// Now returns the current time.
+	Now() time.Time
+	// Every calls fn every interval until the returned stop function is called.
+	// stop waits for a running call of fn to return. interval must be positive.
+	Every(interval time.Duration, fn func()) (stop func())
+}
+
+// System is the wall clock. Every runs fn from a background goroutine driven by a time.Ticker.
+var System Clock = systemClock{}
+
+type systemClock struct{}
+
+func (systemClock) Now() time.Time {
+	return time.Now()
+}
+
+func (systemClock) Every(interval time.Duration, fn func()) func() {
+	stop := make(chan struct{})
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-stop:
+				return
+			case <-ticker.C:
+				fn()
+			}
+		}
+	}()
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			close(stop)
+			<-done
+		})
+	}
+}
+
+// Logical is a manually advanced clock. Time only moves when Advance is called, and the
+// callbacks registered with Every run synchronously inside Advance, in deadline order
+// (registration order for equal deadlines), which makes background work reproducible.
+type Logical struct {
+	now     time.Time
+	timers  []*logicalTimer
+	nextSeq uint64
+	mu      sync.Mutex
+}
+
+type logicalTimer struct {
+	seq      uint64
+	interval time.Duration
+	deadline time.Time
+	fn       func()
+	stopped  bool
+}
+
+// NewLogical creates a logical clock starting at start.
+func NewLogical(start time.Time) *Logical {
+	return &Logical{now: start}
+}
+
+func (l *Logical) Now() time.Time {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.now
+}
+
+func (l *Logical) Every(interval time.Duration, fn func()) func() {
+	if interval <= 0 {
+		panic("clock: non-positive interval for Logical.Every")
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	timer := &logicalTimer{
+		seq:      l.nextSeq,
+		interval: interval,
+		deadline: l.now.Add(interval),
+		fn:       fn,
+	}
+	l.nextSeq++
+	l.timers = append(l.timers, timer)
+	return func() {
+		l.mu.Lock()
+		defer l.mu.Unlock()
+		timer.stopped = true
+		for i, t := range l.timers {
+			if t == timer {
+				l.timers = append(l.timers[:i], l.timers[i+1:]...)
+				break
+			}
+		}
+	}
+}
+
+// Advance moves the clock forward by d, running every callback that falls due on the way.
+// Now returns each callback's deadline while it runs.
+func (l *Logical) Advance(d time.Duration) {
+	l.mu.Lock()
+	target := l.now.Add(d)
+	for {
+		timer := l.nextDue(target)
+		if timer == nil {
+			break
+		}
+		l.now = timer.deadline
+		timer.deadline = timer.deadline.Add(timer.interval)
+		l.mu.Unlock()
+		timer.fn()
+		l.mu.Lock()
+	}
+	l.now = target
+	l.mu.Unlock()
+}
+
+// nextDue returns the timer with the earliest deadline not after target, or nil.
+func (l *Logical) nextDue(target time.Time) *logicalTimer {
+	due := make([]*logicalTimer, 0, len(l.timers))
+	for _, timer := range l.timers {
+		if !timer.stopped && !timer.deadline.After(target) {
+			due = append(due, timer)
+		}
+	}
+	if len(due) == 0 {
+		return nil
+	}
+	sort.Slice(due, func(i, j int) bool {
+		if !due[i].deadline.Equal(due[j].deadline) {
+			return due[i].deadline.Before(due[j].deadline)
+		}
+		return due[i].seq < due[j].seq
+	})
+	return due[0]
+}
@@ -0,0 +1,53 @@
+package clock
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestLogical(t *testing.T) {
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	c := NewLogical(start)
+
+	var fired []string
+	stopA := c.Every(2*time.Second, func() {
+		fired = append(fired, "a@"+c.Now().Sub(start).String())
+	})
+	c.Every(3*time.Second, func() {
+		fired = append(fired, "b@"+c.Now().Sub(start).String())
+	})
+
+	c.Advance(6 * time.Second)
+	expected := []string{"a@2s", "b@3s", "a@4s", "a@6s", "b@6s"}
+	if !reflect.DeepEqual(fired, expected) {
+		t.Errorf("expected %v, got %v", expected, fired)
+	}
+	if now := c.Now(); !now.Equal(start.Add(6 * time.Second)) {
+		t.Errorf("expected the clock at +6s, got %v", now.Sub(start))
+	}
+
+	fired = nil
+	stopA()
+	c.Advance(3 * time.Second)
+	if expected := []string{"b@9s"}; !reflect.DeepEqual(fired, expected) {
+		t.Errorf("expected %v after stopping a, got %v", expected, fired)
+	}
+}
+
+func TestSystemEvery(t *testing.T) {
+	calls := make(chan struct{}, 1)
+	stop := System.Every(time.Millisecond, func() {
+		select {
+		case calls <- struct{}{}:
+		default:
+		}
+	})
+	select {
+	case <-calls:
+	case <-time.After(time.Second):
+		t.Error("expected the callback to run")
+	}
+	stop()
+	stop() // Stopping twice is harmless
+}
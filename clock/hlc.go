@@ -0,0 +1,79 @@
+package clock
+
+import (
+	"sync"
+	"time"
+)
+
+// Timestamp is a hybrid logical clock timestamp: the upper 48 bits are a wall time in
+// milliseconds since the Unix epoch and the lower 16 bits a logical counter that orders events
+// within the same millisecond. Timestamps compare as plain integers; the zero value is before
+// any timestamp an HLC returns.
+type Timestamp uint64
+
+const timestampLogicalBits = 16
+
+// NewTimestamp returns the timestamp of wall time t (truncated to milliseconds) and the
+// logical counter.
+func NewTimestamp(t time.Time, logical uint16) Timestamp {
+	return Timestamp(uint64(t.UnixMilli())<<timestampLogicalBits | uint64(logical))
+}
+
+// WallTime returns the wall time component of the timestamp.
+func (ts Timestamp) WallTime() time.Time {
+	return time.UnixMilli(int64(ts >> timestampLogicalBits))
+}
+
+// Logical returns the logical counter component of the timestamp.
+func (ts Timestamp) Logical() uint16 {
+	return uint16(ts)
+}
+
+// HLC is a hybrid logical clock. The timestamps it returns follow the wall time of the
+// underlying Clock while it moves forward, but never go backwards and never repeat, even when
+// the wall clock jumps back or stalls; meanwhile the logical counter orders them.
+type HLC struct {
+	clock Clock
+	last  Timestamp
+	mu    sync.Mutex
+}
+
+// NewHLC creates a hybrid logical clock reading the wall time from c.
+func NewHLC(c Clock) *HLC {
+	return &HLC{clock: c}
+}
+
+// Now returns a timestamp greater than every timestamp returned or observed before.
+func (h *HLC) Now() Timestamp {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return h.advance(0)
+}
+
+// Update makes the clock observe a timestamp from elsewhere, e.g. a replicated commit or a
+// commit recovered from the log, and returns a timestamp greater than both it and every
+// timestamp returned before.
+func (h *HLC) Update(remote Timestamp) Timestamp {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return h.advance(remote)
+}
+
+// Last returns the latest timestamp returned or observed, without advancing the clock.
+func (h *HLC) Last() Timestamp {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return h.last
+}
+
+func (h *HLC) advance(remote Timestamp) Timestamp {
+	physical := NewTimestamp(h.clock.Now(), 0)
+	latest := max(h.last, remote)
+	if latest < physical {
+		h.last = physical
+	} else {
+		// A full logical counter carries into the wall time
+		h.last = latest + 1
+	}
+	return h.last
+}
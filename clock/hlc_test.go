@@ -0,0 +1,79 @@
+package clock
+
+import (
+	"testing"
+	"time"
+)
+
+// settableClock is a clock whose time can be set backwards, like a wall clock being corrected.
+type settableClock struct {
+	now time.Time
+}
+
+func (c *settableClock) Now() time.Time {
+	return c.now
+}
+
+func (c *settableClock) Every(interval time.Duration, fn func()) func() {
+	return func() {}
+}
+
+func TestHLC(t *testing.T) {
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	c := &settableClock{now: start}
+	h := NewHLC(c)
+
+	t.Run("FollowsWallTime", func(t *testing.T) {
+		ts := h.Now()
+		if !ts.WallTime().Equal(start) || ts.Logical() != 0 {
+			t.Errorf("expected %v/0, got %v/%d", start, ts.WallTime(), ts.Logical())
+		}
+		// Within the same millisecond the logical counter orders the timestamps
+		next := h.Now()
+		if !next.WallTime().Equal(start) || next.Logical() != 1 {
+			t.Errorf("expected %v/1, got %v/%d", start, next.WallTime(), next.Logical())
+		}
+		c.now = start.Add(time.Second)
+		if ts := h.Now(); !ts.WallTime().Equal(c.now) || ts.Logical() != 0 {
+			t.Errorf("expected %v/0, got %v/%d", c.now, ts.WallTime(), ts.Logical())
+		}
+	})
+
+	t.Run("WallClockJumpsBack", func(t *testing.T) {
+		last := h.Last()
+		c.now = start.Add(-time.Hour)
+		for i := 0; i < 3; i++ {
+			ts := h.Now()
+			if ts <= last {
+				t.Fatalf("timestamp %d not after %d", ts, last)
+			}
+			if !ts.WallTime().Equal(last.WallTime()) {
+				t.Errorf("expected the wall time to stay at %v, got %v", last.WallTime(), ts.WallTime())
+			}
+			last = ts
+		}
+	})
+
+	t.Run("Update", func(t *testing.T) {
+		remote := NewTimestamp(start.Add(time.Hour), 7)
+		if ts := h.Update(remote); ts != remote+1 {
+			t.Errorf("expected %d, got %d", remote+1, ts)
+		}
+		if ts := h.Now(); ts <= remote+1 {
+			t.Errorf("expected a timestamp after the remote one, got %d", ts)
+		}
+		// An older remote timestamp does not move the clock back
+		last := h.Last()
+		if ts := h.Update(NewTimestamp(start, 0)); ts <= last {
+			t.Errorf("expected a timestamp after %d, got %d", last, ts)
+		}
+	})
+
+	t.Run("LogicalCarry", func(t *testing.T) {
+		full := NewTimestamp(start.Add(2*time.Hour), 0xFFFF)
+		ts := h.Update(full)
+		if !ts.WallTime().Equal(start.Add(2*time.Hour+time.Millisecond)) || ts.Logical() != 0 {
+			t.Errorf("expected the full counter to carry, got %v/%d", ts.WallTime(), ts.Logical())
+		}
+	})
+}
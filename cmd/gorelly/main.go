@@ -0,0 +1,99 @@
+// Command gorelly is an interactive shell for a gorelly database:
+//
+//	gorelly [-buffers n] [-deflate] [-verify] file.rly
+//
+// It opens the database in the file, creating it if it does not exist, and reads statements from
+// the standard input: SQL SELECT statements and BEGIN, COMMIT and ROLLBACK, each ended with a
+// semicolon, and the commands of the shell, which start with a backslash (see \?). The rows of a
+// query are printed as an aligned table. The database is checkpointed and closed on exit, when
+// the input ends or on \q.
+//
+// With -verify, it checks the database instead, like fsck: the database is recovered, every table
+// is cross-checked against its indexes and the discrepancies are printed, and the command exits
+// with status 1 if there are any.
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/Johniel/gorelly"
+	"github.com/Johniel/gorelly/disk"
+)
+
+func main() {
+	buffers := flag.Int("buffers", gorelly.DefaultBufferPoolSize, "number of buffer pool frames")
+	deflate := flag.Bool("deflate", false, "compress the pages of the heap file with DEFLATE")
+	verify := flag.Bool("verify", false, "check the tables against their indexes and exit")
+	flag.Usage = func() {
+		fmt.Fprintf(flag.CommandLine.Output(), "usage: %s [flags] file.rly\n", os.Args[0])
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+	if flag.NArg() != 1 {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	opts := gorelly.Options{BufferPoolSize: *buffers}
+	if *deflate {
+		opts.Compression = disk.CompressionDeflate
+	}
+	if *verify {
+		ok, err := runVerify(flag.Arg(0), opts)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "gorelly:", err)
+			os.Exit(1)
+		}
+		if !ok {
+			os.Exit(1)
+		}
+		return
+	}
+	if err := run(flag.Arg(0), opts); err != nil {
+		fmt.Fprintln(os.Stderr, "gorelly:", err)
+		os.Exit(1)
+	}
+}
+
+func run(path string, opts gorelly.Options) error {
+	db, err := gorelly.Open(path, opts)
+	if err != nil {
+		return err
+	}
+	sh, err := newShell(db, os.Stdout)
+	if err != nil {
+		db.Close()
+		return err
+	}
+	if stat, err := os.Stdin.Stat(); err == nil && stat.Mode()&os.ModeCharDevice != 0 {
+		sh.prompt = true
+		fmt.Fprintf(os.Stdout, "gorelly: %s (type \\? for help)\n", path)
+	}
+	err = sh.run(os.Stdin)
+	sh.close()
+	if closeErr := db.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+// runVerify checks the database in the file and reports whether its tables and indexes agree.
+func runVerify(path string, opts gorelly.Options) (bool, error) {
+	db, err := gorelly.Open(path, opts)
+	if err != nil {
+		return false, err
+	}
+	sh, err := newShell(db, os.Stdout)
+	if err != nil {
+		db.Close()
+		return false, err
+	}
+	n, err := sh.verify()
+	sh.close()
+	if closeErr := db.Close(); err == nil {
+		err = closeErr
+	}
+	return n == 0, err
+}
@@ -0,0 +1,315 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/Johniel/gorelly"
+	"github.com/Johniel/gorelly/catalog"
+	"github.com/Johniel/gorelly/server"
+)
+
+const help = `Statements, ended with a semicolon:
+  SELECT ... | EXPLAIN SELECT ...   run a query (see the sql package for the dialect)
+  BEGIN | COMMIT | ROLLBACK         control the transaction of the session
+  DECLARE | FETCH | CLOSE           read a query through a named cursor
+Commands:
+  \tables                           list the tables
+  \schema TABLE                     show the columns and indexes of a table
+  \explain SELECT ...               show the plan of a query
+  \verify                           check the tables against their indexes
+  \advise                           suggest indexes for the queries run so far
+  \?                                show this help
+  \q                                quit
+`
+
+// shell runs the statements of a session against a database. The statements are run by a
+// server.Server session over an in-process connection, so that they behave as they do for the
+// clients of a server, with every privilege since the shell opened the database itself.
+type shell struct {
+	db     *gorelly.DB
+	srv    *server.Server
+	client *server.Client
+	out    io.Writer
+	prompt bool // Whether to print prompts, i.e. whether the input is a terminal
+}
+
+func newShell(db *gorelly.DB, out io.Writer) (*shell, error) {
+	srv := server.New(db)
+	serverConn, clientConn := net.Pipe()
+	go srv.ServeConnAs(serverConn, "")
+	client, err := server.NewClient(clientConn)
+	if err != nil {
+		srv.Close()
+		return nil, err
+	}
+	return &shell{db: db, srv: srv, client: client, out: out}, nil
+}
+
+// close ends the session, rolling back a transaction left open.
+func (sh *shell) close() {
+	sh.client.Close()
+	sh.srv.Close()
+}
+
+// run reads statements and commands from in until it ends or \q. A statement may span several
+// lines up to its semicolon; a command takes its line.
+func (sh *shell) run(in io.Reader) error {
+	scanner := bufio.NewScanner(in)
+	var statement strings.Builder
+	for {
+		sh.printPrompt(statement.Len() != 0)
+		if !scanner.Scan() {
+			break
+		}
+		line := strings.TrimSpace(scanner.Text())
+		if statement.Len() == 0 && strings.HasPrefix(line, `\`) {
+			if quit := sh.command(line); quit {
+				return nil
+			}
+			continue
+		}
+		if line == "" {
+			continue
+		}
+		if statement.Len() != 0 {
+			statement.WriteByte('\n')
+		}
+		statement.WriteString(line)
+		if strings.HasSuffix(line, ";") {
+			sh.query(statement.String())
+			statement.Reset()
+		}
+	}
+	if statement.Len() != 0 {
+		sh.query(statement.String())
+	}
+	if sh.prompt {
+		fmt.Fprintln(sh.out)
+	}
+	return scanner.Err()
+}
+
+func (sh *shell) printPrompt(continued bool) {
+	if !sh.prompt {
+		return
+	}
+	switch {
+	case continued:
+		fmt.Fprint(sh.out, "gorelly-> ")
+	case sh.client.InTransaction():
+		fmt.Fprint(sh.out, "gorelly=*> ")
+	default:
+		fmt.Fprint(sh.out, "gorelly=> ")
+	}
+}
+
+// command runs a backslash command and reports whether it is \q.
+func (sh *shell) command(line string) bool {
+	name, arg, _ := strings.Cut(line, " ")
+	arg = strings.TrimSpace(arg)
+	switch name {
+	case `\q`:
+		return true
+	case `\?`:
+		fmt.Fprint(sh.out, help)
+	case `\tables`:
+		names, err := sh.db.Catalog().ListTables()
+		if err != nil {
+			sh.printError(err)
+			return false
+		}
+		rows := make([][]string, len(names))
+		for i, name := range names {
+			rows[i] = []string{name}
+		}
+		sh.printTable([]string{"Table"}, rows)
+	case `\schema`:
+		if arg == "" {
+			fmt.Fprintln(sh.out, `\schema requires a table name`)
+			return false
+		}
+		sh.schema(arg)
+	case `\explain`:
+		if arg == "" {
+			fmt.Fprintln(sh.out, `\explain requires a query`)
+			return false
+		}
+		sh.query("EXPLAIN " + arg)
+	case `\advise`:
+		sh.advise()
+	case `\verify`:
+		if _, err := sh.verify(); err != nil {
+			sh.printError(err)
+		}
+	default:
+		fmt.Fprintf(sh.out, "unknown command %s; type \\? for help\n", name)
+	}
+	return false
+}
+
+// schema prints the columns of a table, then its indexes.
+func (sh *shell) schema(tableName string) {
+	schema, err := sh.db.Catalog().GetTableSchema(tableName)
+	if err != nil {
+		sh.printError(err)
+		return
+	}
+	var rows [][]string
+	for _, col := range schema.Columns {
+		if col.Dropped {
+			continue
+		}
+		nullable, key := "not null", ""
+		if col.Nullable {
+			nullable = "null"
+		}
+		if col.IsPrimaryKey {
+			key = "primary key"
+		}
+		rows = append(rows, []string{col.Name, col.Type.String(), nullable, key})
+	}
+	fmt.Fprintf(sh.out, "Table %q\n", schema.TableName)
+	sh.printTable([]string{"Column", "Type", "Nullable", "Key"}, rows)
+	if len(schema.Indexes) == 0 {
+		return
+	}
+	fmt.Fprintln(sh.out, "Indexes:")
+	for _, index := range schema.Indexes {
+		fmt.Fprintf(sh.out, "    %q %s(%s)\n", index.IndexName, indexKind(index), indexColumns(schema, index))
+	}
+}
+
+func indexKind(index catalog.IndexDef) string {
+	if index.IsUnique {
+		return "UNIQUE "
+	}
+	return ""
+}
+
+func indexColumns(schema *catalog.TableSchema, index catalog.IndexDef) string {
+	names := make([]string, len(index.ColumnIndices))
+	for i, col := range index.ColumnIndices {
+		names[i] = schema.Columns[col].Name
+	}
+	return strings.Join(names, ", ")
+}
+
+// advise prints the indexes suggested for the queries run against the database, with the rows
+// each would save reading.
+func (sh *shell) advise() {
+	suggestions, err := sh.db.AdviseIndexes()
+	if err != nil {
+		sh.printError(err)
+		return
+	}
+	rows := make([][]string, len(suggestions))
+	for i, suggestion := range suggestions {
+		rows[i] = []string{
+			suggestion.Table,
+			strings.Join(suggestion.Columns, ", "),
+			strings.Join(suggestion.Include, ", "),
+			fmt.Sprint(suggestion.Benefit),
+			fmt.Sprint(suggestion.Queries),
+		}
+	}
+	sh.printTable([]string{"Table", "Columns", "Include", "Benefit", "Queries"}, rows)
+}
+
+// verify prints the discrepancies between the tables and their indexes and returns their number.
+func (sh *shell) verify() (int, error) {
+	discrepancies, err := sh.db.Verify()
+	if err != nil {
+		return 0, err
+	}
+	rows := make([][]string, len(discrepancies))
+	for i, d := range discrepancies {
+		rows[i] = []string{d.Table, d.IndexName, d.Kind.String(), fmt.Sprintf("%x", d.Key), fmt.Sprintf("%x", d.Pkey)}
+	}
+	sh.printTable([]string{"Table", "Index", "Problem", "Key", "Primary key"}, rows)
+	return len(discrepancies), nil
+}
+
+// query runs a statement and prints its rows, or its command tag if it returns none.
+func (sh *shell) query(text string) {
+	rows, err := sh.client.Query(text)
+	if err != nil {
+		sh.printError(err)
+		return
+	}
+	if rows.Columns == nil {
+		fmt.Fprintln(sh.out, rows.Tag)
+		return
+	}
+	header := make([]string, len(rows.Columns.Columns))
+	for i, col := range rows.Columns.Columns {
+		header[i] = col.Name
+	}
+	var values [][]string
+	for {
+		row, ok, err := rows.Next()
+		if err != nil {
+			sh.printError(err)
+			return
+		}
+		if !ok {
+			break
+		}
+		fields := make([]string, row.Len())
+		for i, v := range row.Values() {
+			fields[i] = v.String()
+		}
+		values = append(values, fields)
+	}
+	sh.printTable(header, values)
+}
+
+// printTable prints rows under a header with the columns aligned, followed by the number of rows:
+//
+//	 id | name
+//	----+-------
+//	 1  | alice
+//	(1 row)
+func (sh *shell) printTable(header []string, rows [][]string) {
+	widths := make([]int, len(header))
+	for i, name := range header {
+		widths[i] = utf8.RuneCountInString(name)
+	}
+	for _, row := range rows {
+		for i, field := range row {
+			widths[i] = max(widths[i], utf8.RuneCountInString(field))
+		}
+	}
+	line := func(fields []string) {
+		var b strings.Builder
+		for i, field := range fields {
+			if 0 < i {
+				b.WriteString("|")
+			}
+			b.WriteString(" " + field + strings.Repeat(" ", widths[i]-utf8.RuneCountInString(field)) + " ")
+		}
+		fmt.Fprintln(sh.out, strings.TrimRight(b.String(), " "))
+	}
+	line(header)
+	rules := make([]string, len(widths))
+	for i, width := range widths {
+		rules[i] = strings.Repeat("-", width+2)
+	}
+	fmt.Fprintln(sh.out, strings.Join(rules, "+"))
+	for _, row := range rows {
+		line(row)
+	}
+	if len(rows) == 1 {
+		fmt.Fprintln(sh.out, "(1 row)")
+	} else {
+		fmt.Fprintf(sh.out, "(%d rows)\n", len(rows))
+	}
+}
+
+func (sh *shell) printError(err error) {
+	fmt.Fprintf(sh.out, "ERROR: %v\n", err)
+}
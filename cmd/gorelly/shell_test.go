@@ -0,0 +1,163 @@
+package main
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/Johniel/gorelly"
+	"github.com/Johniel/gorelly/catalog"
+	"github.com/Johniel/gorelly/transaction"
+	"github.com/Johniel/gorelly/types"
+)
+
+func TestShell(t *testing.T) {
+	db, err := gorelly.Open(filepath.Join(t.TempDir(), "test.rly"), gorelly.Options{
+		SyncPolicy: transaction.SyncPolicy{Mode: transaction.SyncModeNone},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	cm := db.Catalog()
+	_, err = cm.CreateTable("users", []catalog.ColumnDef{
+		{Name: "id", Type: catalog.ColumnTypeInt, IsPrimaryKey: true},
+		{Name: "name", Type: catalog.ColumnTypeVarchar, Nullable: true},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := cm.CreateIndex("users", []string{"name"}, true); err != nil {
+		t.Fatal(err)
+	}
+	users, err := db.Table("users")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, tup := range [][][]byte{
+		{types.EncodeInt(1), []byte("alice")},
+		{types.EncodeInt(2), nil},
+		{types.EncodeInt(10), []byte("bob")},
+	} {
+		if err := users.Insert(db.BufferPool(), tup); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var out strings.Builder
+	sh, err := newShell(db, &out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sh.close()
+	script := `\tables
+\schema users
+SELECT id, name
+  FROM users
+  WHERE id >= 2;
+\explain SELECT name FROM users WHERE id = 1
+BEGIN;
+SELECT * FROM users WHERE id = 3;
+COMMIT;
+SELECT * FROM missing;
+\nope
+\verify
+\q
+SELECT * FROM users;
+`
+	if err := sh.run(strings.NewReader(script)); err != nil {
+		t.Fatal(err)
+	}
+	expected := ` Table
+-------
+ users
+(1 row)
+Table "users"
+ Column | Type    | Nullable | Key
+--------+---------+----------+-------------
+ id     | INT     | not null | primary key
+ name   | VARCHAR | null     |
+(2 rows)
+Indexes:
+    "users_name_key" UNIQUE (name)
+ id | name
+----+------
+ 2  | NULL
+ 10 | bob
+(2 rows)
+ QUERY PLAN
+-------------------------------
+ Project: name
+   -> SeqScan on users: id = 1
+(2 rows)
+BEGIN
+ id | name
+----+------
+(0 rows)
+COMMIT
+ERROR: table not found
+unknown command \nope; type \? for help
+ Table | Index | Problem | Key | Primary key
+-------+-------+---------+-----+-------------
+(0 rows)
+`
+	if out.String() != expected {
+		t.Errorf("expected\n%s\ngot\n%s", expected, out.String())
+	}
+}
+
+func TestShellAdvise(t *testing.T) {
+	db, err := gorelly.Open(filepath.Join(t.TempDir(), "test.rly"), gorelly.Options{
+		SyncPolicy: transaction.SyncPolicy{Mode: transaction.SyncModeNone},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	if _, err := db.CreateTable("items", []catalog.ColumnDef{
+		{Name: "id", Type: catalog.ColumnTypeInt, IsPrimaryKey: true},
+		{Name: "kind", Type: catalog.ColumnTypeVarchar},
+		{Name: "price", Type: catalog.ColumnTypeInt},
+	}); err != nil {
+		t.Fatal(err)
+	}
+	items, err := db.Table("items")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, kind := range []string{"book", "pen", "pen", "ink"} {
+		if err := items.Insert(db.BufferPool(), [][]byte{types.EncodeInt(int64(i)), []byte(kind), types.EncodeInt(10)}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var out strings.Builder
+	sh, err := newShell(db, &out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sh.close()
+	script := `SELECT price FROM items WHERE kind = 'book';
+SELECT price FROM items WHERE kind = 'ink';
+\advise
+`
+	if err := sh.run(strings.NewReader(script)); err != nil {
+		t.Fatal(err)
+	}
+	expected := ` price
+-------
+ 10
+(1 row)
+ price
+-------
+ 10
+(1 row)
+ Table | Columns | Include | Benefit | Queries
+-------+---------+---------+---------+---------
+ items | kind    | price   | 6       | 1
+(1 row)
+`
+	if out.String() != expected {
+		t.Errorf("expected\n%s\ngot\n%s", expected, out.String())
+	}
+}
@@ -0,0 +1,529 @@
+package gorelly
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+
+	"github.com/Johniel/gorelly/buffer"
+	"github.com/Johniel/gorelly/catalog"
+	"github.com/Johniel/gorelly/clock"
+	"github.com/Johniel/gorelly/disk"
+	"github.com/Johniel/gorelly/errcode"
+	"github.com/Johniel/gorelly/table"
+	"github.com/Johniel/gorelly/transaction"
+)
+
+var (
+	// ErrDBClosed is returned when using a DB after Close.
+	ErrDBClosed = errcode.New(errcode.ObjectNotInPrerequisiteState, "database is closed")
+	// ErrInvalidOptions is returned by Open for options it cannot open a database with.
+	ErrInvalidOptions = errcode.New(errcode.InvalidParameter, "invalid database options")
+)
+
+// DefaultBufferPoolSize is the number of buffer frames of a DB opened without Options.BufferPoolSize.
+const DefaultBufferPoolSize = 256
+
+// Options configures Open. The zero value is usable.
+type Options struct {
+	BufferPoolSize int                    // Number of buffer frames (DefaultBufferPoolSize if 0)
+	LogPath        string                 // Path of the write-ahead log (the heap file path plus ".wal" if empty)
+	SyncPolicy     transaction.SyncPolicy // Durability of the log (fsync on every append by default)
+	// Page compression of the heap file, which must be the same every time the file is opened.
+	Compression disk.Compression
+	// Clock of transaction start times, commit timestamps and now() (clock.System if nil)
+	Clock clock.Clock
+	// Whether the tables created through the DB are versioned (see
+	// catalog.CatalogManager.CreateVersionedTable), so that their readers see a snapshot
+	Versioned bool
+	// Isolation level of the transactions begun by Begin (read committed by default), which
+	// decides the snapshots their statements read at (see Tx.Snapshot)
+	Isolation transaction.IsolationLevel
+}
+
+// DB is an open database: a heap file and its write-ahead log, with the buffer pool, the
+// transaction, lock and recovery managers, and the catalog built on top of them.
+// It is safe for concurrent use.
+type DB struct {
+	dm       *disk.DiskManager
+	bufmgr   *buffer.BufferPoolManager
+	log      *transaction.LogManager
+	locks    *transaction.LockManager
+	recovery *transaction.RecoveryManager
+	txns     *transaction.TransactionManager
+	catalog  *catalog.CatalogManager
+	workload *catalog.Workload
+	selfTest *disk.SelfTestReport
+	clock    clock.Clock
+	opts     Options
+	closed   bool
+	mu       sync.RWMutex
+	frozen   bool       // Whether FreezeWrites holds the writes
+	freezeMu sync.Mutex // Guards frozen; writers held by a freeze keep mu shared
+}
+
+// Open opens the database in the heap file at path, creating it if it does not exist, and
+// recovers it from its log: the changes of committed transactions are redone and those of
+// transactions interrupted by a crash are undone before Open returns.
+//
+// The environment is checked with disk.SelfTest first, so that a filesystem that loses synced
+// data or a heap file of the wrong page size fails Open; the warnings of the check are kept in
+// SelfTestReport.
+func Open(path string, opts Options) (*DB, error) {
+	if opts.BufferPoolSize < 0 {
+		return nil, ErrInvalidOptions
+	}
+	if opts.BufferPoolSize == 0 {
+		opts.BufferPoolSize = DefaultBufferPoolSize
+	}
+	if opts.LogPath == "" {
+		opts.LogPath = path + ".wal"
+	}
+	if opts.Clock == nil {
+		opts.Clock = clock.System
+	}
+
+	report, err := disk.SelfTest(disk.Environment{
+		HeapPath:   path,
+		LogPath:    opts.LogPath,
+		Compressed: opts.Compression != disk.CompressionNone,
+	})
+	if err != nil {
+		return nil, err
+	}
+	heapFile, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return nil, err
+	}
+	dm, err := disk.NewCompressedDiskManager(heapFile, opts.Compression)
+	if err != nil {
+		heapFile.Close()
+		return nil, err
+	}
+	log, err := transaction.OpenLogManager(opts.LogPath, opts.SyncPolicy)
+	if err != nil {
+		dm.Close()
+		return nil, err
+	}
+	db := &DB{
+		dm:       dm,
+		bufmgr:   buffer.NewBufferPoolManager(dm, buffer.NewBufferPool(opts.BufferPoolSize)),
+		log:      log,
+		locks:    transaction.NewLockManager(),
+		workload: catalog.NewWorkload(),
+		selfTest: report,
+		clock:    opts.Clock,
+		opts:     opts,
+	}
+	db.bufmgr.SetWAL(log)
+	if err := db.open(); err != nil {
+		return nil, errors.Join(err, log.Close(), dm.Close())
+	}
+	return db, nil
+}
+
+// open recovers the database and loads its catalog, creating the catalog of a new database.
+func (db *DB) open() error {
+	db.recovery = transaction.NewRecoveryManager(db.log, db.bufmgr)
+	if err := db.recovery.Recover(); err != nil {
+		return err
+	}
+	db.txns = transaction.NewTransactionManagerWithManagers(db.log, db.locks, db.recovery)
+	db.txns.SetBufferPoolManager(db.bufmgr)
+	db.txns.SetClock(db.clock)
+
+	var err error
+	if db.dm.NumPages() == 0 {
+		// The catalog tables are not logged, so they are written back before anything refers to them
+		if db.catalog, err = catalog.NewCatalogManager(db.bufmgr); err != nil {
+			return err
+		}
+		return db.bufmgr.Flush()
+	}
+	db.catalog, err = catalog.OpenCatalogManager(db.bufmgr)
+	return err
+}
+
+// Begin starts a transaction.
+func (db *DB) Begin() (*Tx, error) {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+	if db.closed {
+		return nil, ErrDBClosed
+	}
+	txn := db.txns.BeginWithIsolation(db.opts.Isolation)
+	return &Tx{db: db, txn: txn, log: transaction.NewLockingPageLog(db.log, db.locks, txn)}, nil
+}
+
+// Table opens a table outside of any transaction. Its changes are not logged, so it is meant
+// for reading; change tables through Tx.Table instead.
+func (db *DB) Table(name string) (*table.Table, error) {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+	if db.closed {
+		return nil, ErrDBClosed
+	}
+	return db.catalog.OpenTable(name)
+}
+
+// Catalog returns the catalog of the database. Schema changes made directly through it are not
+// logged and become durable only at the next Checkpoint, so a crash before it loses them along
+// with the committed rows of the tables they created; create tables and indexes with
+// DB.CreateTable, DB.CreateIndex or their Tx counterparts instead.
+func (db *DB) Catalog() *catalog.CatalogManager {
+	return db.catalog
+}
+
+// CreateTable creates a table in a transaction of its own, durable once it returns. It is
+// versioned if Options.Versioned is set.
+func (db *DB) CreateTable(name string, columns []catalog.ColumnDef) (*catalog.TableSchema, error) {
+	tx, err := db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	schema, err := tx.CreateTable(name, columns)
+	if err != nil {
+		if rollbackErr := tx.Rollback(); rollbackErr != nil {
+			return nil, errors.Join(err, rollbackErr)
+		}
+		return nil, err
+	}
+	return schema, tx.Commit()
+}
+
+// CreateIndex creates an index on the columns of a table in a transaction of its own, durable
+// once it returns.
+func (db *DB) CreateIndex(tableName string, columns []string, unique bool) (catalog.IndexDef, error) {
+	tx, err := db.Begin()
+	if err != nil {
+		return catalog.IndexDef{}, err
+	}
+	index, err := tx.CreateIndex(tableName, columns, unique)
+	if err != nil {
+		if rollbackErr := tx.Rollback(); rollbackErr != nil {
+			return catalog.IndexDef{}, errors.Join(err, rollbackErr)
+		}
+		return catalog.IndexDef{}, err
+	}
+	return index, tx.Commit()
+}
+
+// SelfTestReport returns the report of the environment check run by Open.
+func (db *DB) SelfTestReport() *disk.SelfTestReport {
+	return db.selfTest
+}
+
+// Workload returns the workload the query shapes run against the database are recorded in, as
+// the input of AdviseIndexes.
+func (db *DB) Workload() *catalog.Workload {
+	return db.workload
+}
+
+// AdviseIndexes suggests the indexes missing for the recorded workload (see
+// catalog.CatalogManager.AdviseIndexes).
+func (db *DB) AdviseIndexes() ([]catalog.IndexSuggestion, error) {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+	if db.closed {
+		return nil, ErrDBClosed
+	}
+	return db.catalog.AdviseIndexes(db.workload)
+}
+
+// BufferPool returns the buffer pool manager the tables of the database are read through.
+func (db *DB) BufferPool() *buffer.BufferPoolManager {
+	return db.bufmgr
+}
+
+// Transactions returns the transaction manager of the database.
+func (db *DB) Transactions() *transaction.TransactionManager {
+	return db.txns
+}
+
+// Clock returns the clock of the database (see Options.Clock).
+func (db *DB) Clock() clock.Clock {
+	return db.clock
+}
+
+// Locks returns the lock manager of the database.
+func (db *DB) Locks() *transaction.LockManager {
+	return db.locks
+}
+
+// Log returns the write-ahead log of the database.
+func (db *DB) Log() *transaction.LogManager {
+	return db.log
+}
+
+// Checkpoint writes back the dirty pages and appends a checkpoint record to the log, so that
+// the next recovery reads the log only from here on (see transaction.LogManager.Checkpoint).
+func (db *DB) Checkpoint() error {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+	if db.closed {
+		return ErrDBClosed
+	}
+	return db.log.Checkpoint(db.bufmgr)
+}
+
+// Vacuum removes the rows of the versioned tables that were deleted by transactions no active
+// transaction may still need to see the rows of, and the row versions they no longer see (see
+// table.Table.Vacuum), in a transaction of its own. It returns how many rows and versions it
+// removed.
+func (db *DB) Vacuum() (int, int, error) {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+	if db.closed {
+		return 0, 0, ErrDBClosed
+	}
+	names, err := db.catalog.ListTables()
+	if err != nil {
+		return 0, 0, err
+	}
+	// Taken before the transaction begins, which would hold it back
+	horizon := db.txns.Horizon().Bytes()
+	txn := db.txns.BeginWithIsolation(db.opts.Isolation)
+	tx := &Tx{db: db, txn: txn, log: transaction.NewLockingPageLog(db.log, db.locks, txn)}
+	rows, versions := 0, 0
+	for _, name := range names {
+		t, err := tx.Table(name)
+		if err != nil {
+			return 0, 0, errors.Join(err, tx.Rollback())
+		}
+		if t.Versions == nil {
+			continue
+		}
+		r, v, err := t.Vacuum(db.bufmgr, horizon)
+		if err != nil {
+			return 0, 0, errors.Join(err, tx.Rollback())
+		}
+		rows += r
+		versions += v
+	}
+	if err := tx.Commit(); err != nil {
+		return 0, 0, err
+	}
+	return rows, versions, nil
+}
+
+// Discrepancy is a disagreement between a table and one of its indexes found by Verify.
+type Discrepancy struct {
+	Table     string // Name of the table
+	IndexName string // Name of the index in the catalog
+	table.IndexDiscrepancy
+}
+
+func (d Discrepancy) String() string {
+	return fmt.Sprintf("table %q, index %q: %v", d.Table, d.IndexName, d.IndexDiscrepancy)
+}
+
+// Verify cross-checks every table of the database against its indexes (see
+// table.Table.VerifyIndexes), e.g. after a crash or a suspected bug. The discrepancies are
+// returned in the order of the table names; an empty result means the tables and their indexes
+// agree. The check is meant to run while no transaction changes the tables.
+func (db *DB) Verify() ([]Discrepancy, error) {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+	if db.closed {
+		return nil, ErrDBClosed
+	}
+	names, err := db.catalog.ListTables()
+	if err != nil {
+		return nil, err
+	}
+	var discrepancies []Discrepancy
+	for _, name := range names {
+		schema, err := db.catalog.GetTableSchema(name)
+		if err != nil {
+			return nil, err
+		}
+		t, err := db.catalog.OpenTable(name)
+		if err != nil {
+			return nil, err
+		}
+		found, err := t.VerifyIndexes(db.bufmgr)
+		if err != nil {
+			return nil, err
+		}
+		// The indexes of the handle are those of the schema that have a tree, in order
+		var indexNames []string
+		for _, index := range schema.Indexes {
+			if index.MetaPageID.Valid() {
+				indexNames = append(indexNames, index.IndexName)
+			}
+		}
+		for _, d := range found {
+			discrepancies = append(discrepancies, Discrepancy{Table: name, IndexName: indexNames[d.Index], IndexDiscrepancy: d})
+		}
+	}
+	return discrepancies, nil
+}
+
+// FreezeWrites checkpoints the database and then holds every change to its files until Thaw, so
+// that a filesystem snapshot of the heap file and the log taken in between is consistent: opened
+// from the snapshot, the database recovers like after a crash at the time of the freeze.
+// Log appends and page writes wait during the freeze, reads go on. Freezing a frozen DB returns
+// disk.ErrAlreadyFrozen.
+func (db *DB) FreezeWrites() error {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+	if db.closed {
+		return ErrDBClosed
+	}
+	db.freezeMu.Lock()
+	defer db.freezeMu.Unlock()
+	if db.frozen {
+		return disk.ErrAlreadyFrozen
+	}
+	if err := db.log.Checkpoint(db.bufmgr); err != nil {
+		return err
+	}
+	// The log is frozen first, so the heap file written back next holds no change it lacks
+	if err := db.log.Freeze(); err != nil {
+		return err
+	}
+	if err := db.bufmgr.FreezeWrites(); err != nil {
+		db.log.Thaw()
+		return err
+	}
+	db.frozen = true
+	return nil
+}
+
+// Thaw lifts a freeze started by FreezeWrites and resumes the writers it held. Thawing a DB that
+// is not frozen does nothing.
+func (db *DB) Thaw() {
+	db.freezeMu.Lock()
+	defer db.freezeMu.Unlock()
+	if !db.frozen {
+		return
+	}
+	db.frozen = false
+	db.bufmgr.ThawWrites()
+	db.log.Thaw()
+}
+
+// Close shuts the database down cleanly: it lifts a freeze, writes back every dirty page,
+// checkpoints the log and closes the log and the heap file. Transactions still active are undone by the recovery of
+// the next Open. Closing a closed DB does nothing.
+func (db *DB) Close() error {
+	// The writers held by a freeze keep mu until they are resumed
+	db.Thaw()
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	if db.closed {
+		return nil
+	}
+	db.closed = true
+	err := db.log.Checkpoint(db.bufmgr)
+	return errors.Join(err, db.log.Close(), db.dm.Close())
+}
+
+// Tx is a transaction of a DB. The tables it opens log their changes to it, so that they are
+// undone by Rollback or by the recovery after a crash before Commit.
+//
+// The pages a transaction changes are locked until it ends, so that undoing its changes never
+// undoes those of another transaction: a change to a page another active transaction changed
+// waits for that transaction to end (see btree.PageLocker), and fails with
+// transaction.ErrDeadlock if the two transactions wait for each other; the transaction should
+// then be rolled back and retried. Inserts also take the key-range locks of the gaps they
+// insert into (see transaction.LockManager.LockInsert), held until the transaction ends.
+//
+// The rows of versioned tables (see Options.Versioned) carry the IDs of the transactions that
+// wrote and deleted them, which the tables opened by Table maintain, and the versions that
+// updates replace are kept, so that a statement reading at the snapshot of Snapshot sees
+// neither the uncommitted changes of other transactions nor, under repeatable read, the
+// changes committed after its transaction's first statement, without waiting for the writers.
+// The plans of the sql package read at a snapshot given with sql.Plan.ReadAt, and
+// query.FilterVisible reads other plans at one. The tables themselves read the latest version
+// of every row, including the uncommitted changes of other transactions, as do the reads of
+// tables that are not versioned; transactions that need repeatable reads of those lock the
+// keys they read through DB.Locks (e.g. LockRange) themselves.
+//
+// The tables and indexes a transaction creates are visible to other sessions once it committed;
+// DDL transactions run one at a time, so a second transaction creating a table waits for the
+// first one to end.
+type Tx struct {
+	db  *DB
+	txn *transaction.Transaction
+	log *transaction.PageLog
+	ddl *catalog.DDL // Schema changes of the transaction (nil until the first one)
+}
+
+// Txn returns the underlying transaction.
+func (tx *Tx) Txn() *transaction.Transaction {
+	return tx.txn
+}
+
+// Table opens a table whose changes are made in the transaction, including one the transaction
+// created. The changes to a versioned table are stamped with the ID of the transaction (see
+// table.Table.Writer).
+func (tx *Tx) Table(name string) (*table.Table, error) {
+	var t *table.Table
+	var err error
+	if tx.ddl != nil {
+		t, err = tx.ddl.OpenTable(name)
+	} else {
+		t, err = tx.db.Table(name)
+	}
+	if err != nil {
+		return nil, err
+	}
+	t.Log = tx.log
+	t.Writer = tx.txn.ID.Bytes()
+	t.LockInsert = tx.db.locks.InsertLocker(tx.txn, tx.db.bufmgr, t.MetaPageID)
+	t.LockScan = tx.db.locks.RangeLocker(tx.txn, tx.db.bufmgr, t.MetaPageID)
+	return t, nil
+}
+
+// CreateTable creates a table in the transaction: its trees and catalog records are logged, so
+// that Rollback or a crash before Commit removes it. It is versioned if Options.Versioned is set.
+func (tx *Tx) CreateTable(name string, columns []catalog.ColumnDef) (*catalog.TableSchema, error) {
+	if tx.db.opts.Versioned {
+		return tx.beginDDL().CreateVersionedTable(name, columns)
+	}
+	return tx.beginDDL().CreateTable(name, columns)
+}
+
+// Snapshot returns the snapshot a statement of the transaction starting now reads the
+// versioned tables at: a fresh one under read committed, and the one of the transaction's first
+// statement under repeatable read (see transaction.TransactionManager.StatementSnapshot).
+func (tx *Tx) Snapshot() *transaction.Snapshot {
+	return tx.db.txns.StatementSnapshot(tx.txn)
+}
+
+// CreateIndex creates an index on the columns of a table in the transaction, as CreateTable does.
+func (tx *Tx) CreateIndex(tableName string, columns []string, unique bool) (catalog.IndexDef, error) {
+	return tx.beginDDL().CreateIndex(tableName, columns, unique)
+}
+
+// beginDDL returns the DDL of the transaction, starting it on first use.
+func (tx *Tx) beginDDL() *catalog.DDL {
+	if tx.ddl == nil {
+		tx.ddl = tx.db.catalog.BeginDDL(tx.log)
+	}
+	return tx.ddl
+}
+
+// Commit commits the transaction; its changes are durable according to Options.SyncPolicy.
+func (tx *Tx) Commit() error {
+	err := tx.db.txns.Commit(tx.txn)
+	if tx.ddl != nil {
+		if err != nil {
+			tx.ddl.Discard()
+		} else {
+			tx.ddl.Publish()
+		}
+	}
+	return err
+}
+
+// Rollback aborts the transaction and undoes its changes.
+func (tx *Tx) Rollback() error {
+	err := tx.db.txns.Abort(tx.txn)
+	if tx.ddl != nil {
+		tx.ddl.Discard()
+	}
+	return err
+}
@@ -0,0 +1,871 @@
+package gorelly
+
+import (
+	"bytes"
+	"errors"
+	"math/rand/v2"
+	"os"
+	"path/filepath"
+	"reflect"
+	"runtime"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/Johniel/gorelly/catalog"
+	"github.com/Johniel/gorelly/clock"
+	"github.com/Johniel/gorelly/disk"
+	"github.com/Johniel/gorelly/errcode"
+	"github.com/Johniel/gorelly/query"
+	"github.com/Johniel/gorelly/sql"
+	"github.com/Johniel/gorelly/table"
+	"github.com/Johniel/gorelly/transaction"
+	"github.com/Johniel/gorelly/types"
+)
+
+func TestDB(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "test.rly")
+	opts := Options{BufferPoolSize: 16, SyncPolicy: transaction.SyncPolicy{Mode: transaction.SyncModeNone}}
+
+	if _, err := Open(path, Options{BufferPoolSize: -1}); err != ErrInvalidOptions {
+		t.Errorf("expected ErrInvalidOptions, got %v", err)
+	}
+
+	db, err := Open(path, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(path + ".wal"); err != nil {
+		t.Errorf("expected the log next to the heap file: %v", err)
+	}
+	_, err = db.Catalog().CreateTable("users", []catalog.ColumnDef{
+		{Name: "id", Type: catalog.ColumnTypeInt, IsPrimaryKey: true},
+		{Name: "name", Type: catalog.ColumnTypeVarchar},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Checkpoint(); err != nil {
+		t.Fatal(err)
+	}
+	row := func(id int64, name string) [][]byte {
+		return [][]byte{types.EncodeInt(id), []byte(name)}
+	}
+	insert := func(t *testing.T, db *DB, rows ...[][]byte) *Tx {
+		t.Helper()
+		tx, err := db.Begin()
+		if err != nil {
+			t.Fatal(err)
+		}
+		users, err := tx.Table("users")
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, r := range rows {
+			if err := users.Insert(db.BufferPool(), r); err != nil {
+				t.Fatal(err)
+			}
+		}
+		return tx
+	}
+	checkRows := func(t *testing.T, db *DB, want map[int64]string) {
+		t.Helper()
+		users, err := db.Table("users")
+		if err != nil {
+			t.Fatal(err)
+		}
+		for id := int64(1); id <= 4; id++ {
+			got, err := users.Get(db.BufferPool(), [][]byte{types.EncodeInt(id)})
+			name, ok := want[id]
+			if !ok {
+				if err == nil {
+					t.Errorf("row %d: expected no row, got %q", id, got)
+				}
+				continue
+			}
+			if err != nil {
+				t.Fatalf("row %d: %v", id, err)
+			}
+			if !reflect.DeepEqual(got, row(id, name)) {
+				t.Errorf("row %d: expected %q, got %q", id, row(id, name), got)
+			}
+		}
+	}
+
+	if err := insert(t, db, row(1, "alice"), row(2, "bob")).Commit(); err != nil {
+		t.Fatal(err)
+	}
+	if err := insert(t, db, row(3, "carol")).Rollback(); err != nil {
+		t.Fatal(err)
+	}
+	want := map[int64]string{1: "alice", 2: "bob"}
+	checkRows(t, db, want)
+
+	// A clean shutdown keeps the committed rows
+	if err := db.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.Begin(); err != ErrDBClosed {
+		t.Errorf("expected ErrDBClosed, got %v", err)
+	}
+	if err := db.Close(); err != nil {
+		t.Errorf("expected closing twice to do nothing, got %v", err)
+	}
+	db, err = Open(path, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkRows(t, db, want)
+
+	t.Run("Recovery", func(t *testing.T) {
+		// A crash loses no committed transaction and keeps no uncommitted one
+		if err := insert(t, db, row(3, "carol")).Commit(); err != nil {
+			t.Fatal(err)
+		}
+		insert(t, db, row(4, "dave"))
+		if err := db.BufferPool().Flush(); err != nil {
+			t.Fatal(err)
+		}
+		if err := db.Log().Flush(); err != nil {
+			t.Fatal(err)
+		}
+		want[3] = "carol"
+
+		// The crashed DB is abandoned without writing anything else
+		db, err = Open(path, opts)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer db.Close()
+		checkRows(t, db, want)
+	})
+
+	t.Run("Compression", func(t *testing.T) {
+		compressed := opts
+		compressed.Compression = disk.CompressionDeflate
+		path := filepath.Join(dir, "compressed.rly")
+		db, err := Open(path, compressed)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := db.Catalog().CreateTable("users", []catalog.ColumnDef{
+			{Name: "id", Type: catalog.ColumnTypeInt, IsPrimaryKey: true},
+			{Name: "name", Type: catalog.ColumnTypeVarchar},
+		}); err != nil {
+			t.Fatal(err)
+		}
+		if err := insert(t, db, row(1, "alice")).Commit(); err != nil {
+			t.Fatal(err)
+		}
+		if err := db.Close(); err != nil {
+			t.Fatal(err)
+		}
+		db, err = Open(path, compressed)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer db.Close()
+		checkRows(t, db, map[int64]string{1: "alice"})
+	})
+}
+
+func TestTxIsolation(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "test.rly")
+	opts := Options{BufferPoolSize: 64, SyncPolicy: transaction.SyncPolicy{Mode: transaction.SyncModeNone}}
+	db, err := Open(path, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { db.Close() }()
+	if _, err := db.Catalog().CreateTable("users", []catalog.ColumnDef{
+		{Name: "id", Type: catalog.ColumnTypeInt, IsPrimaryKey: true},
+		{Name: "name", Type: catalog.ColumnTypeVarchar},
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Checkpoint(); err != nil {
+		t.Fatal(err)
+	}
+	row := func(id int64) [][]byte {
+		return [][]byte{types.EncodeInt(id), bytes.Repeat([]byte{byte('a' + id%26)}, 100)}
+	}
+	insert := func(tx *Tx, id int64) error {
+		users, err := tx.Table("users")
+		if err != nil {
+			return err
+		}
+		return users.Insert(db.BufferPool(), row(id))
+	}
+	checkRows := func(t *testing.T, tried map[int64]bool, committed map[int64]bool) {
+		t.Helper()
+		users, err := db.Table("users")
+		if err != nil {
+			t.Fatal(err)
+		}
+		for id := range tried {
+			_, err := users.Get(db.BufferPool(), [][]byte{types.EncodeInt(id)})
+			if found := err == nil; found != committed[id] {
+				t.Errorf("row %d: found = %v, want %v (%v)", id, found, committed[id], err)
+			}
+		}
+	}
+
+	t.Run("SamePage", func(t *testing.T) {
+		// The second insert lands on the page of the first one and waits for it to roll back
+		a, err := db.Begin()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := insert(a, 1); err != nil {
+			t.Fatal(err)
+		}
+		b, err := db.Begin()
+		if err != nil {
+			t.Fatal(err)
+		}
+		inserted := make(chan error)
+		go func() { inserted <- insert(b, 2) }()
+		select {
+		case err := <-inserted:
+			t.Fatalf("expected the insert to wait for the page, got %v", err)
+		case <-time.After(50 * time.Millisecond):
+		}
+		if err := a.Rollback(); err != nil {
+			t.Fatal(err)
+		}
+		if err := <-inserted; err != nil {
+			t.Fatal(err)
+		}
+		if err := b.Commit(); err != nil {
+			t.Fatal(err)
+		}
+		checkRows(t, map[int64]bool{1: true, 2: true}, map[int64]bool{2: true})
+	})
+
+	t.Run("Deadlock", func(t *testing.T) {
+		if _, err := db.Catalog().CreateTable("teams", []catalog.ColumnDef{
+			{Name: "id", Type: catalog.ColumnTypeInt, IsPrimaryKey: true},
+			{Name: "name", Type: catalog.ColumnTypeVarchar},
+		}); err != nil {
+			t.Fatal(err)
+		}
+		insertTeam := func(tx *Tx, id int64) error {
+			teams, err := tx.Table("teams")
+			if err != nil {
+				return err
+			}
+			return teams.Insert(db.BufferPool(), row(id))
+		}
+		a, err := db.Begin()
+		if err != nil {
+			t.Fatal(err)
+		}
+		b, err := db.Begin()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := insert(a, 3); err != nil {
+			t.Fatal(err)
+		}
+		if err := insertTeam(b, 1); err != nil {
+			t.Fatal(err)
+		}
+		inserted := make(chan error)
+		go func() { inserted <- insertTeam(a, 2) }()
+		time.Sleep(50 * time.Millisecond)
+		// Each transaction now waits for a page of the other one
+		err = insert(b, 4)
+		if !errors.Is(err, transaction.ErrDeadlock) {
+			t.Fatalf("expected ErrDeadlock, got %v", err)
+		}
+		if errcode.Of(err) != errcode.Deadlock {
+			t.Errorf("expected a deadlock error code, got %v", errcode.Of(err))
+		}
+		if err := b.Rollback(); err != nil {
+			t.Fatal(err)
+		}
+		if err := <-inserted; err != nil {
+			t.Fatal(err)
+		}
+		if err := a.Commit(); err != nil {
+			t.Fatal(err)
+		}
+		checkRows(t, map[int64]bool{3: true, 4: true}, map[int64]bool{3: true})
+	})
+
+	t.Run("KeyLocks", func(t *testing.T) {
+		// An insert waits for the transaction holding a range lock over its gap
+		a, err := db.Begin()
+		if err != nil {
+			t.Fatal(err)
+		}
+		users, err := db.Table("users")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := db.Locks().LockRange(a.Txn(), db.BufferPool(), users.MetaPageID, nil, nil); err != nil {
+			t.Fatal(err)
+		}
+		b, err := db.Begin()
+		if err != nil {
+			t.Fatal(err)
+		}
+		inserted := make(chan error)
+		go func() { inserted <- insert(b, 5) }()
+		select {
+		case err := <-inserted:
+			t.Fatalf("expected the insert to wait for the range lock, got %v", err)
+		case <-time.After(50 * time.Millisecond):
+		}
+		if err := a.Commit(); err != nil {
+			t.Fatal(err)
+		}
+		if err := <-inserted; err != nil {
+			t.Fatal(err)
+		}
+		if err := b.Commit(); err != nil {
+			t.Fatal(err)
+		}
+		checkRows(t, map[int64]bool{5: true}, map[int64]bool{5: true})
+	})
+
+	t.Run("Concurrent", func(t *testing.T) {
+		// Writers of a tree must be serialized, so each statement runs under mu, but the
+		// transactions of the workers interleave and commit or roll back in any order
+		var mu sync.Mutex
+		// Key-range locks would make a worker wait under mu for another one that needs mu to
+		// end its transaction, so only the page locks are exercised here; a worker waiting
+		// for a page releases mu until it is granted
+		insert := func(tx *Tx, id int64) error {
+			users, err := tx.Table("users")
+			if err != nil {
+				return err
+			}
+			users.LockInsert = nil
+			users.Log = &unlockingPageLog{PageLog: tx.log, mu: &mu}
+			return users.Insert(db.BufferPool(), row(id))
+		}
+		tried := make(map[int64]bool)
+		committed := map[int64]bool{2: true}
+		var wg sync.WaitGroup
+		for w := range 4 {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				rnd := rand.New(rand.NewPCG(uint64(w), 0))
+				for i := range 40 {
+					mu.Lock()
+					tx, err := db.Begin()
+					mu.Unlock()
+					if err != nil {
+						t.Error(err)
+						return
+					}
+					var ids []int64
+					for j := range 3 {
+						id := int64(1000*(w+1) + 10*i + j)
+						mu.Lock()
+						tried[id] = true
+						err := insert(tx, id)
+						mu.Unlock()
+						if errors.Is(err, transaction.ErrDeadlock) {
+							ids = nil
+							break
+						}
+						if err != nil {
+							t.Error(err)
+							return
+						}
+						ids = append(ids, id)
+						runtime.Gosched()
+					}
+					mu.Lock()
+					if ids != nil && rnd.IntN(2) == 0 {
+						err = tx.Commit()
+						for _, id := range ids {
+							committed[id] = true
+						}
+					} else {
+						err = tx.Rollback()
+					}
+					mu.Unlock()
+					if err != nil {
+						t.Error(err)
+						return
+					}
+				}
+			}()
+		}
+		wg.Wait()
+		if len(committed) == 1 {
+			t.Fatal("no transaction committed")
+		}
+		checkRows(t, tried, committed)
+		if pinned := db.BufferPool().PoolStats().Pinned; pinned != 0 {
+			t.Errorf("pinned pages = %d, want 0", pinned)
+		}
+
+		// The committed rows survive a restart, and no rolled back one comes back
+		if err := db.Close(); err != nil {
+			t.Fatal(err)
+		}
+		if db, err = Open(path, opts); err != nil {
+			t.Fatal(err)
+		}
+		checkRows(t, tried, committed)
+	})
+}
+
+// unlockingPageLog is the PageLog of a transaction whose writers are serialized by mu. It
+// releases mu while waiting for a page, so that the transaction holding the page can end.
+type unlockingPageLog struct {
+	*transaction.PageLog
+	mu *sync.Mutex
+}
+
+func (l *unlockingPageLog) WaitPage(pageID disk.PageID) error {
+	l.mu.Unlock()
+	defer l.mu.Lock()
+	return l.PageLog.WaitPage(pageID)
+}
+
+func TestDBCreateTable(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "test.rly")
+	opts := Options{BufferPoolSize: 32, SyncPolicy: transaction.SyncPolicy{Mode: transaction.SyncModeNone}}
+	db, err := Open(path, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if db.SelfTestReport() == nil {
+		t.Error("expected the report of the environment check")
+	}
+	columns := []catalog.ColumnDef{
+		{Name: "id", Type: catalog.ColumnTypeInt, IsPrimaryKey: true},
+		{Name: "name", Type: catalog.ColumnTypeVarchar},
+	}
+	row := func(id int64, name string) [][]byte {
+		return [][]byte{types.EncodeInt(id), []byte(name)}
+	}
+	insert := func(tx *Tx, tableName string, r [][]byte) {
+		t.Helper()
+		tbl, err := tx.Table(tableName)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := tbl.Insert(db.BufferPool(), r); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if _, err := db.CreateTable("users", columns); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.CreateTable("users", columns); err != catalog.ErrTableExists {
+		t.Errorf("expected ErrTableExists, got %v", err)
+	}
+	tx, err := db.Begin()
+	if err != nil {
+		t.Fatal(err)
+	}
+	insert(tx, "users", row(1, "alice"))
+	if err := tx.Commit(); err != nil {
+		t.Fatal(err)
+	}
+
+	// A table created and filled by a transaction rolled back is gone
+	tx, err = db.Begin()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := tx.CreateTable("orders", columns); err != nil {
+		t.Fatal(err)
+	}
+	insert(tx, "orders", row(1, "book"))
+	if err := tx.Rollback(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.Table("orders"); err != catalog.ErrTableNotFound {
+		t.Errorf("expected ErrTableNotFound, got %v", err)
+	}
+
+	// The table becomes visible with the commit of the transaction that created it
+	tx, err = db.Begin()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := tx.CreateTable("orders", columns); err != nil {
+		t.Fatal(err)
+	}
+	insert(tx, "orders", row(1, "book"))
+	if _, err := tx.CreateIndex("orders", []string{"name"}, true); err != nil {
+		t.Fatal(err)
+	}
+	if err := tx.Commit(); err != nil {
+		t.Fatal(err)
+	}
+
+	// The schema changes survive a crash with only the log written
+	if err := db.Log().Flush(); err != nil {
+		t.Fatal(err)
+	}
+	db, err = Open(path, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	for name, want := range map[string][][]byte{"users": row(1, "alice"), "orders": row(1, "book")} {
+		tbl, err := db.Table(name)
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		got, err := tbl.Get(db.BufferPool(), [][]byte{types.EncodeInt(1)})
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("%s: expected %q, got %q", name, want, got)
+		}
+	}
+	orders, err := db.Table("orders")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(orders.UniqueIndices) != 1 {
+		t.Fatalf("expected the index of orders, got %d indexes", len(orders.UniqueIndices))
+	}
+	problems, err := orders.VerifyIndexes(db.BufferPool())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(problems) != 0 {
+		t.Errorf("expected the index to match the rows, got %+v", problems)
+	}
+}
+
+func TestDBFreezeWrites(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "test.rly")
+	opts := Options{BufferPoolSize: 16, SyncPolicy: transaction.SyncPolicy{Mode: transaction.SyncModeNone}}
+	db, err := Open(path, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	if _, err := db.CreateTable("users", []catalog.ColumnDef{
+		{Name: "id", Type: catalog.ColumnTypeInt, IsPrimaryKey: true},
+		{Name: "name", Type: catalog.ColumnTypeVarchar},
+	}); err != nil {
+		t.Fatal(err)
+	}
+	insert := func(id int64) error {
+		tx, err := db.Begin()
+		if err != nil {
+			return err
+		}
+		users, err := tx.Table("users")
+		if err != nil {
+			return err
+		}
+		if err := users.Insert(db.BufferPool(), [][]byte{types.EncodeInt(id), []byte("x")}); err != nil {
+			return errors.Join(err, tx.Rollback())
+		}
+		return tx.Commit()
+	}
+	if err := insert(1); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := db.FreezeWrites(); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.FreezeWrites(); err != disk.ErrAlreadyFrozen {
+		t.Errorf("expected ErrAlreadyFrozen, got %v", err)
+	}
+	inserted := make(chan error)
+	go func() { inserted <- insert(2) }()
+	select {
+	case err := <-inserted:
+		t.Fatalf("expected the commit to wait for the thaw, got %v", err)
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	// A copy of the files taken during the freeze opens with the rows committed before it
+	snapshot := filepath.Join(dir, "snapshot.rly")
+	for from, to := range map[string]string{path: snapshot, path + ".wal": snapshot + ".wal"} {
+		data, err := os.ReadFile(from)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(to, data, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	db.Thaw()
+	if err := <-inserted; err != nil {
+		t.Fatal(err)
+	}
+
+	copied, err := Open(snapshot, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer copied.Close()
+	users, err := copied.Table("users")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := users.Get(copied.BufferPool(), [][]byte{types.EncodeInt(1)}); err != nil {
+		t.Errorf("expected the row committed before the freeze: %v", err)
+	}
+	if _, err := users.Get(copied.BufferPool(), [][]byte{types.EncodeInt(2)}); err == nil {
+		t.Error("expected no row committed after the freeze")
+	}
+}
+
+func TestDBAdviseIndexes(t *testing.T) {
+	db, err := Open(filepath.Join(t.TempDir(), "test.rly"), Options{SyncPolicy: transaction.SyncPolicy{Mode: transaction.SyncModeNone}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	if _, err := db.CreateTable("users", []catalog.ColumnDef{
+		{Name: "id", Type: catalog.ColumnTypeInt, IsPrimaryKey: true},
+		{Name: "email", Type: catalog.ColumnTypeVarchar},
+	}); err != nil {
+		t.Fatal(err)
+	}
+	db.Workload().Record(catalog.WorkloadQuery{
+		Table:           "users",
+		EqualityColumns: []string{"email"},
+		Projected:       []string{"id"},
+		Count:           10,
+		RowsScanned:     1000,
+		RowsReturned:    10,
+	})
+	suggestions, err := db.AdviseIndexes()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(suggestions) != 1 || !reflect.DeepEqual(suggestions[0].Columns, []string{"email"}) {
+		t.Errorf("expected an index on email, got %+v", suggestions)
+	}
+}
+
+func TestDBClock(t *testing.T) {
+	start := time.Date(2024, 2, 29, 12, 30, 0, 0, time.UTC)
+	c := clock.NewLogical(start)
+	db, err := Open(filepath.Join(t.TempDir(), "test.rly"), Options{
+		SyncPolicy: transaction.SyncPolicy{Mode: transaction.SyncModeNone},
+		Clock:      c,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	if db.Clock() != c {
+		t.Fatalf("expected the clock of the options")
+	}
+	c.Advance(time.Minute)
+	tx, err := db.Begin()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tx.Rollback()
+	if expected := start.Add(time.Minute); !tx.txn.StartTime.Equal(expected) {
+		t.Errorf("expected the transaction to start at %v, got %v", expected, tx.txn.StartTime)
+	}
+}
+
+func TestDBVerify(t *testing.T) {
+	db, err := Open(filepath.Join(t.TempDir(), "test.rly"), Options{SyncPolicy: transaction.SyncPolicy{Mode: transaction.SyncModeNone}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	if _, err := db.CreateTable("users", []catalog.ColumnDef{
+		{Name: "id", Type: catalog.ColumnTypeInt, IsPrimaryKey: true},
+		{Name: "email", Type: catalog.ColumnTypeVarchar},
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.CreateIndex("users", []string{"email"}, true); err != nil {
+		t.Fatal(err)
+	}
+	users, err := db.Table("users")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := users.Insert(db.BufferPool(), [][]byte{types.EncodeInt(1), []byte("a@x")}); err != nil {
+		t.Fatal(err)
+	}
+	discrepancies, err := db.Verify()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(discrepancies) != 0 {
+		t.Errorf("expected no discrepancy, got %v", discrepancies)
+	}
+
+	// A row inserted without maintaining the index has no entry in it
+	users.UniqueIndices = nil
+	if err := users.Insert(db.BufferPool(), [][]byte{types.EncodeInt(2), []byte("b@x")}); err != nil {
+		t.Fatal(err)
+	}
+	discrepancies, err = db.Verify()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(discrepancies) != 1 {
+		t.Fatalf("expected one discrepancy, got %v", discrepancies)
+	}
+	d := discrepancies[0]
+	if d.Table != "users" || d.IndexName != "users_email_key" || d.Kind != table.DiscrepancyMissingEntry {
+		t.Errorf("expected a missing entry in users_email_key, got %v", d)
+	}
+}
+
+func TestDBVersioned(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "test.rly")
+	opts := Options{SyncPolicy: transaction.SyncPolicy{Mode: transaction.SyncModeNone}, Versioned: true}
+	db, err := Open(path, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { db.Close() }()
+	if _, err := db.CreateTable("users", []catalog.ColumnDef{
+		{Name: "id", Type: catalog.ColumnTypeInt, IsPrimaryKey: true},
+		{Name: "name", Type: catalog.ColumnTypeVarchar},
+	}); err != nil {
+		t.Fatal(err)
+	}
+	row := func(id int64, name string) [][]byte {
+		return [][]byte{types.EncodeInt(id), []byte(name)}
+	}
+	// read returns the rows a statement of tx sees
+	read := func(t *testing.T, tx *Tx) map[int64]string {
+		t.Helper()
+		plan, err := sql.NewPlanner(db.Catalog()).PlanQuery("SELECT id, name FROM users")
+		if err != nil {
+			t.Fatal(err)
+		}
+		plan.ReadAt(tx.Snapshot)
+		exec, err := plan.Root.Start(db.BufferPool())
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer query.CloseExecutor(db.BufferPool(), exec)
+		rows := make(map[int64]string)
+		for {
+			tup, ok, err := exec.Next(db.BufferPool())
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !ok {
+				return rows
+			}
+			id, err := types.DecodeInt(tup[0])
+			if err != nil {
+				t.Fatal(err)
+			}
+			rows[id] = string(tup[1])
+		}
+	}
+	begin := func(t *testing.T) (*Tx, *table.Table) {
+		t.Helper()
+		tx, err := db.Begin()
+		if err != nil {
+			t.Fatal(err)
+		}
+		users, err := tx.Table("users")
+		if err != nil {
+			t.Fatal(err)
+		}
+		return tx, users
+	}
+
+	tx, users := begin(t)
+	for _, r := range [][][]byte{row(1, "alice"), row(2, "bob")} {
+		if err := users.Insert(db.BufferPool(), r); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		t.Fatal(err)
+	}
+
+	reader, _ := begin(t)
+	writer, users := begin(t)
+	if err := users.Update(db.BufferPool(), row(1, "alicia")); err != nil {
+		t.Fatal(err)
+	}
+	if err := users.Delete(db.BufferPool(), row(2, "")); err != nil {
+		t.Fatal(err)
+	}
+	if err := users.Insert(db.BufferPool(), row(3, "carol")); err != nil {
+		t.Fatal(err)
+	}
+	before := map[int64]string{1: "alice", 2: "bob"}
+	after := map[int64]string{1: "alicia", 3: "carol"}
+	if got := read(t, reader); !reflect.DeepEqual(got, before) {
+		t.Errorf("expected the reader not to see the uncommitted changes, got %v", got)
+	}
+	if got := read(t, writer); !reflect.DeepEqual(got, after) {
+		t.Errorf("expected the writer to see its own changes, got %v", got)
+	}
+	if err := writer.Commit(); err != nil {
+		t.Fatal(err)
+	}
+	// Under read committed, the next statement sees the committed changes
+	if got := read(t, reader); !reflect.DeepEqual(got, after) {
+		t.Errorf("expected the reader to see the committed changes, got %v", got)
+	}
+	if err := reader.Commit(); err != nil {
+		t.Fatal(err)
+	}
+
+	// The deleted row is replaced by an insert, and the versioned schema survives a reopen
+	tx, users = begin(t)
+	if err := users.Insert(db.BufferPool(), row(2, "bobby")); err != nil {
+		t.Fatal(err)
+	}
+	if err := tx.Commit(); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if db, err = Open(path, opts); err != nil {
+		t.Fatal(err)
+	}
+	tx, _ = begin(t)
+	if got, expected := read(t, tx), map[int64]string{1: "alicia", 2: "bobby", 3: "carol"}; !reflect.DeepEqual(got, expected) {
+		t.Errorf("expected %v after reopening, got %v", expected, got)
+	}
+	if err := tx.Commit(); err != nil {
+		t.Fatal(err)
+	}
+
+	// Once no transaction needs them, Vacuum removes the deleted rows and the replaced versions
+	tx, users = begin(t)
+	if err := users.Delete(db.BufferPool(), row(3, "")); err != nil {
+		t.Fatal(err)
+	}
+	if err := tx.Commit(); err != nil {
+		t.Fatal(err)
+	}
+	rows, versions, err := db.Vacuum()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rows != 1 || versions != 2 {
+		t.Errorf("expected 1 row and 2 versions to be removed, got %d and %d", rows, versions)
+	}
+	raw, err := db.Table("users")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := raw.Get(db.BufferPool(), [][]byte{types.EncodeInt(3)}); err == nil {
+		t.Error("expected the deleted row to be removed")
+	}
+}
@@ -0,0 +1,139 @@
+package disk
+
+import (
+	"bytes"
+	"compress/flate"
+	"encoding/binary"
+	"io"
+	"os"
+	"sync"
+
+	"github.com/Johniel/gorelly/errcode"
+)
+
+var (
+	// ErrUnknownCompression is returned when creating a DiskManager with an unknown Compression.
+	ErrUnknownCompression = errcode.New(errcode.InvalidParameter, "unknown page compression")
+	// ErrCompressedPageCorrupt is returned when a compressed page cannot be decompressed.
+	ErrCompressedPageCorrupt = errcode.New(errcode.Corruption, "compressed page is corrupt")
+)
+
+// Compression is the algorithm a DiskManager compresses pages with on disk. Pages are
+// compressed when they are written and decompressed when they are read, so the buffer pool
+// only ever sees uncompressed pages.
+type Compression uint8
+
+const (
+	CompressionNone    Compression = iota // Pages are stored as they are
+	CompressionDeflate                    // DEFLATE (compress/flate) at its fastest level
+)
+
+// CompressedPageOverhead is the size of the header a page of a compressing DiskManager starts
+// with on disk, holding how the page is stored and the length of its data.
+const CompressedPageOverhead = 4
+
+// CompressedSlotSize is the number of bytes the slot of a page of a compressing DiskManager
+// takes in the heap file. A slot is twice PageSize, so that slots start at block boundaries on
+// filesystems whose blocks are no larger than a page, and the blocks of a slot after the data of
+// its page hold no data and take no space (see NewCompressedDiskManager).
+const CompressedSlotSize = 2 * PageSize
+
+// How a page of a compressing DiskManager is stored, in the first byte of its header. The header
+// ends with the length of the stored data (2B).
+const (
+	storedUnwritten byte = iota // Allocated but never written: reads as zeros
+	storedPlain                 // Did not compress: the page as it is
+	storedDeflate
+)
+
+// NewCompressedDiskManager creates a DiskManager that compresses every page with compression.
+// Each page keeps a slot of CompressedSlotSize bytes in the heap file, of which only the header
+// and the compressed data are written; a page that does not compress is stored as it is. The
+// rest of the slot is a hole of the sparse heap file: on Linux, the blocks after the data are
+// deallocated (fallocate with FALLOC_FL_PUNCH_HOLE) every time a page is written, so that a page
+// that shrinks gives its blocks back. A page that compresses to at most PageSize minus the header
+// thus takes one block of PageSize bytes on disk, and one that does not compress takes two.
+// Compressed heap files have a different layout, so a heap file must always be opened with the
+// same setting. Compressed pages are not encrypted.
+func NewCompressedDiskManager(heapFile *os.File, compression Compression) (*DiskManager, error) {
+	if CompressionDeflate < compression {
+		return nil, ErrUnknownCompression
+	}
+	return newDiskManagerAt(heapFile, nil, compression, 0)
+}
+
+var deflateWriters = sync.Pool{
+	New: func() any {
+		w, _ := flate.NewWriter(nil, flate.BestSpeed)
+		return w
+	},
+}
+
+// compressPage stores page in raw (PageSize+CompressedPageOverhead bytes) and returns the number
+// of bytes of raw in use.
+func (c Compression) compressPage(raw []byte, page []byte) int {
+	if c == CompressionDeflate {
+		out := &boundedWriter{buf: raw[CompressedPageOverhead : CompressedPageOverhead+PageSize-1]}
+		w := deflateWriters.Get().(*flate.Writer)
+		w.Reset(out)
+		_, err := w.Write(page)
+		if err == nil {
+			err = w.Close()
+		}
+		deflateWriters.Put(w)
+		if err == nil {
+			return putPageHeader(raw, storedDeflate, out.n)
+		}
+	}
+	copy(raw[CompressedPageOverhead:], page)
+	return putPageHeader(raw, storedPlain, PageSize)
+}
+
+func putPageHeader(raw []byte, stored byte, n int) int {
+	raw[0] = stored
+	raw[1] = 0
+	binary.BigEndian.PutUint16(raw[2:], uint16(n))
+	return CompressedPageOverhead + n
+}
+
+// decompressPage reads a page stored by compressPage into page.
+func decompressPage(page []byte, raw []byte) error {
+	n := int(binary.BigEndian.Uint16(raw[2:]))
+	if PageSize < n {
+		return ErrCompressedPageCorrupt
+	}
+	data := raw[CompressedPageOverhead : CompressedPageOverhead+n]
+	switch raw[0] {
+	case storedUnwritten:
+		clear(page)
+	case storedPlain:
+		if n != PageSize {
+			return ErrCompressedPageCorrupt
+		}
+		copy(page, data)
+	case storedDeflate:
+		r := flate.NewReader(bytes.NewReader(data))
+		defer r.Close()
+		if _, err := io.ReadFull(r, page[:PageSize]); err != nil {
+			return ErrCompressedPageCorrupt
+		}
+	default:
+		return ErrCompressedPageCorrupt
+	}
+	return nil
+}
+
+// boundedWriter writes into buf and fails once it is full, so that compressing a page that
+// does not compress stops early.
+type boundedWriter struct {
+	buf []byte
+	n   int
+}
+
+func (w *boundedWriter) Write(p []byte) (int, error) {
+	if len(w.buf)-w.n < len(p) {
+		return 0, io.ErrShortWrite
+	}
+	w.n += copy(w.buf[w.n:], p)
+	return len(p), nil
+}
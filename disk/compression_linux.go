@@ -0,0 +1,23 @@
+package disk
+
+import (
+	"os"
+	"syscall"
+)
+
+// Modes of fallocate(2).
+const (
+	fallocKeepSize  = 0x01
+	fallocPunchHole = 0x02
+)
+
+// punchHole deallocates the blocks of the file that lie entirely in [offset, offset+length),
+// which then read as zeros, without changing the size of the file. Filesystems that cannot punch
+// holes keep the blocks.
+func punchHole(f *os.File, offset int64, length int64) error {
+	err := syscall.Fallocate(int(f.Fd()), fallocPunchHole|fallocKeepSize, offset, length)
+	if err == syscall.EOPNOTSUPP || err == syscall.ENOSYS {
+		return nil
+	}
+	return err
+}
@@ -0,0 +1,72 @@
+package disk
+
+import (
+	"bytes"
+	"math/rand"
+	"os"
+	"syscall"
+	"testing"
+)
+
+func TestCompressedPageHoles(t *testing.T) {
+	tmpfile, err := os.CreateTemp("", "test_compressed_holes_*.db")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(tmpfile.Name())
+	if err := syscall.Fallocate(int(tmpfile.Fd()), fallocPunchHole|fallocKeepSize, 0, PageSize); err == syscall.EOPNOTSUPP {
+		t.Skip("the filesystem cannot punch holes")
+	}
+	dm, err := NewCompressedDiskManager(tmpfile, CompressionDeflate)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer dm.Close()
+
+	allocated := func() int64 {
+		t.Helper()
+		if err := dm.Sync(); err != nil {
+			t.Fatal(err)
+		}
+		var stat syscall.Stat_t
+		if err := syscall.Fstat(int(tmpfile.Fd()), &stat); err != nil {
+			t.Fatal(err)
+		}
+		return stat.Blocks * 512
+	}
+
+	// Pages that do not compress take two blocks each
+	const numPages = 16
+	random := make([]byte, PageSize)
+	rand.New(rand.NewSource(1)).Read(random)
+	for range numPages {
+		if err := dm.WritePageData(dm.AllocatePage(), random); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if got := allocated(); got < numPages*PageSize {
+		t.Fatalf("expected at least %d bytes allocated, got %d", numPages*PageSize, got)
+	}
+
+	// Once rewritten compressed, they give back the blocks after their data. The filesystem may
+	// take a few more blocks to map the extents of the sparse file.
+	compressible := make([]byte, PageSize)
+	copy(compressible, bytes.Repeat([]byte("gorelly "), 100))
+	for pageID := range PageID(numPages) {
+		if err := dm.WritePageData(pageID, compressible); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if got, want := allocated(), int64((numPages+2)*PageSize); want < got {
+		t.Errorf("expected at most %d bytes allocated, got %d", want, got)
+	}
+	for pageID := range PageID(numPages) {
+		got := make([]byte, PageSize)
+		if err := dm.ReadPageData(pageID, got); err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(got, compressible) {
+			t.Errorf("page %d: expected the rewritten page back", pageID)
+		}
+	}
+}
@@ -0,0 +1,99 @@
+package disk
+
+import (
+	"bytes"
+	"math/rand"
+	"os"
+	"testing"
+)
+
+func TestCompressedDiskManager(t *testing.T) {
+	tmpfile, err := os.CreateTemp("", "test_compressed_disk_*.db")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(tmpfile.Name())
+
+	if _, err := NewCompressedDiskManager(tmpfile, CompressionDeflate+1); err != ErrUnknownCompression {
+		t.Errorf("expected ErrUnknownCompression, got %v", err)
+	}
+	dm, err := NewCompressedDiskManager(tmpfile, CompressionDeflate)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// A page that compresses well, one that does not compress, and one never written
+	compressible := make([]byte, PageSize)
+	copy(compressible, bytes.Repeat([]byte("gorelly "), 100))
+	random := make([]byte, PageSize)
+	rand.New(rand.NewSource(1)).Read(random)
+	pages := [][]byte{compressible, random, make([]byte, PageSize), compressible}
+	for i := range pages {
+		pageID := dm.AllocatePage()
+		if i == 2 {
+			continue
+		}
+		if err := dm.WritePageData(pageID, pages[i]); err != nil {
+			t.Fatal(err)
+		}
+	}
+	checkPages := func(t *testing.T, dm *DiskManager) {
+		t.Helper()
+		for i, want := range pages {
+			got := make([]byte, PageSize)
+			if err := dm.ReadPageData(PageID(i), got); err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(got, want) {
+				t.Errorf("page %d: expected the written page back", i)
+			}
+		}
+	}
+	checkPages(t, dm)
+
+	// Only the compressed data of the last page is written
+	stat, err := tmpfile.Stat()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := 4 * dm.RawPageSize(); want <= stat.Size() || stat.Size() <= 3*dm.RawPageSize() {
+		t.Errorf("expected a heap file shorter than %d bytes, got %d", want, stat.Size())
+	}
+
+	t.Run("Reopen", func(t *testing.T) {
+		dm.FreePage(1)
+		if err := dm.Close(); err != nil {
+			t.Fatal(err)
+		}
+		heapFile, err := os.OpenFile(tmpfile.Name(), os.O_RDWR, 0644)
+		if err != nil {
+			t.Fatal(err)
+		}
+		reopened, err := NewCompressedDiskManager(heapFile, CompressionDeflate)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer reopened.Close()
+		if reopened.NumPages() != 4 || reopened.NumFreePages() != 1 {
+			t.Errorf("expected 4 pages and 1 free page, got %d and %d", reopened.NumPages(), reopened.NumFreePages())
+		}
+		checkPages(t, reopened)
+
+		// Staged writes are compressed as well
+		sw := NewSequentialWriter(reopened, 4)
+		for i := 0; i < 3; i++ {
+			pageID, err := sw.WritePage(compressible)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if int(pageID) == len(pages) {
+				pages = append(pages, nil)
+			}
+			pages[pageID] = compressible
+		}
+		if err := sw.Close(); err != nil {
+			t.Fatal(err)
+		}
+		checkPages(t, reopened)
+	})
+}
@@ -6,6 +6,15 @@ import (
 	"encoding/binary"
 	"io"
 	"os"
+	"slices"
+	"sync"
+
+	"github.com/Johniel/gorelly/errcode"
+)
+
+var (
+	// ErrAlreadyFrozen is returned when freezing a DiskManager that is already frozen.
+	ErrAlreadyFrozen = errcode.New(errcode.ObjectNotInPrerequisiteState, "disk writes already frozen")
 )
 
 // PageSize is the size of a page in bytes (4KB).
@@ -36,25 +45,71 @@ func (p PageID) ToBytes() []byte {
 	return bytes
 }
 
+// PageStore stores the pages of a database: DiskManager keeps them in a single heap file and
+// Tablespace in a directory of heap files. BufferPoolManager works on any PageStore.
+type PageStore interface {
+	ReadPageData(pageID PageID, data []byte) error
+	WritePageData(pageID PageID, data []byte) error
+	AllocatePage() PageID
+	FreePage(pageID PageID)
+	Sync() error
+	Freeze() error
+	Thaw()
+	Close() error
+}
+
+// PageClaimer is implemented by PageStores that can mark a given page as allocated, e.g. to redo
+// the allocation of a page that a crash lost along with the page itself.
+type PageClaimer interface {
+	ClaimPage(pageID PageID) error
+}
+
 // DiskManager manages disk I/O operations for the database.
 // It handles reading and writing pages to/from a heap file.
 // The heap file is organized as a sequence of fixed-size pages.
+// A DiskManager is safe for concurrent use: page I/O, allocation and freezes are serialized
+// by its own mutexes, so unlike SequentialWriter it needs no ownercheck.
 type DiskManager struct {
-	heapFile   *os.File
-	nextPageID uint64
+	heapFile    *os.File
+	base        PageID      // ID of the first page of the heap file (0 unless it is part of a Tablespace)
+	nextPageID  uint64      // Number of pages allocated in the heap file
+	frozen      bool        // Whether page writes are blocked (see Freeze)
+	thawed      *sync.Cond  // Signalled when the freeze is lifted
+	freezeMu    sync.Mutex  // Protects frozen
+	keyring     *Keyring    // Page encryption keys (nil stores pages in plaintext)
+	compression Compression // Page compression (see NewCompressedDiskManager)
+	ioMu        sync.Mutex  // Serializes page reads and writes
+	freePages   []PageID    // Pages released by FreePage, reused by AllocatePage
+	allocMu     sync.Mutex  // Protects nextPageID and freePages
 }
 
 func NewDiskManager(heapFile *os.File) (*DiskManager, error) {
+	return newDiskManager(heapFile, nil)
+}
+
+func newDiskManager(heapFile *os.File, keyring *Keyring) (*DiskManager, error) {
+	return newDiskManagerAt(heapFile, keyring, CompressionNone, 0)
+}
+
+// newDiskManagerAt creates a disk manager for a heap file whose first page is base.
+func newDiskManagerAt(heapFile *os.File, keyring *Keyring, compression Compression, base PageID) (*DiskManager, error) {
 	stat, err := heapFile.Stat()
 	if err != nil {
 		return nil, err
 	}
-	heapFileSize := stat.Size()
-	nextPageID := uint64(heapFileSize) / PageSize
-	return &DiskManager{
-		heapFile:   heapFile,
-		nextPageID: nextPageID,
-	}, nil
+	dm := &DiskManager{
+		heapFile:    heapFile,
+		base:        base,
+		keyring:     keyring,
+		compression: compression,
+	}
+	dm.thawed = sync.NewCond(&dm.freezeMu)
+	// The last page of a compressed heap file may be shorter than its slot
+	dm.nextPageID = uint64((stat.Size() + dm.RawPageSize() - 1) / dm.RawPageSize())
+	if err := dm.loadFreeList(); err != nil {
+		return nil, err
+	}
+	return dm, nil
 }
 
 func OpenDiskManager(heapFilePath string) (*DiskManager, error) {
@@ -66,29 +121,168 @@ func OpenDiskManager(heapFilePath string) (*DiskManager, error) {
 }
 
 func (dm *DiskManager) ReadPageData(pageID PageID, data []byte) error {
-	offset := int64(PageSize) * int64(pageID.ToU64())
+	dm.ioMu.Lock()
+	defer dm.ioMu.Unlock()
+	if dm.compression != CompressionNone {
+		raw := make([]byte, CompressedSlotSize)
+		if err := dm.readRaw(pageID, raw); err != nil {
+			return err
+		}
+		return decompressPage(data, raw)
+	}
+	if dm.keyring == nil {
+		return dm.readRaw(pageID, data)
+	}
+	raw := make([]byte, PageSize+EncryptedPageOverhead)
+	if err := dm.readRaw(pageID, raw); err != nil {
+		return err
+	}
+	return dm.keyring.decryptPage(pageID, data, raw)
+}
+
+func (dm *DiskManager) WritePageData(pageID PageID, data []byte) error {
+	dm.waitThawed()
+	dm.ioMu.Lock()
+	defer dm.ioMu.Unlock()
+	if dm.compression != CompressionNone {
+		raw := make([]byte, PageSize+CompressedPageOverhead)
+		n := dm.compression.compressPage(raw, data)
+		return dm.writeRaw(pageID, raw[:n])
+	}
+	if dm.keyring == nil {
+		return dm.writeRaw(pageID, data)
+	}
+	raw := make([]byte, PageSize+EncryptedPageOverhead)
+	if err := dm.keyring.encryptPage(pageID, raw, data); err != nil {
+		return err
+	}
+	return dm.writeRaw(pageID, raw)
+}
+
+// RawPageSize returns the number of bytes a page takes in the heap file.
+func (dm *DiskManager) RawPageSize() int64 {
+	if dm.keyring != nil {
+		return PageSize + EncryptedPageOverhead
+	}
+	if dm.compression != CompressionNone {
+		return CompressedSlotSize
+	}
+	return PageSize
+}
+
+// ReadRawPage reads the RawPageSize bytes a page takes in the heap file, encrypted if the
+// manager encrypts pages, e.g. to copy the heap file. A page that was allocated but never
+// written reads as zeros.
+func (dm *DiskManager) ReadRawPage(pageID PageID, raw []byte) error {
+	dm.ioMu.Lock()
+	defer dm.ioMu.Unlock()
+	err := dm.readRaw(pageID, raw)
+	if err == io.EOF || err == io.ErrUnexpectedEOF {
+		clear(raw)
+		return nil
+	}
+	return err
+}
+
+// readRaw reads the on-disk bytes of a page, which are encrypted if the manager has a keyring
+// and compressed if it has a compression.
+func (dm *DiskManager) readRaw(pageID PageID, raw []byte) error {
+	offset := dm.RawPageSize() * int64(pageID-dm.base)
 	_, err := dm.heapFile.Seek(offset, io.SeekStart)
 	if err != nil {
 		return err
 	}
-	_, err = io.ReadFull(dm.heapFile, data)
+	n, err := io.ReadFull(dm.heapFile, raw)
+	if err == io.ErrUnexpectedEOF && dm.compression != CompressionNone {
+		// Only the used part of the slot of the last page is written
+		clear(raw[n:])
+		return nil
+	}
 	return err
 }
 
-func (dm *DiskManager) WritePageData(pageID PageID, data []byte) error {
-	offset := int64(PageSize) * int64(pageID.ToU64())
+// writeRaw writes the on-disk bytes of a page at the start of its slot; those of a compressed
+// page may be shorter than the slot, whose rest is punched out of the heap file.
+func (dm *DiskManager) writeRaw(pageID PageID, raw []byte) error {
+	offset := dm.RawPageSize() * int64(pageID-dm.base)
 	_, err := dm.heapFile.Seek(offset, io.SeekStart)
 	if err != nil {
 		return err
 	}
-	_, err = dm.heapFile.Write(data)
-	return err
+	if _, err := dm.heapFile.Write(raw); err != nil {
+		return err
+	}
+	if dm.compression != CompressionNone {
+		return punchHole(dm.heapFile, offset+int64(len(raw)), dm.RawPageSize()-int64(len(raw)))
+	}
+	return nil
 }
 
+// AllocatePage returns a page released by FreePage if there is one, and a new page at the end of
+// the heap file otherwise.
 func (dm *DiskManager) AllocatePage() PageID {
-	pageID := dm.nextPageID
+	if pageID, ok := dm.allocateFree(); ok {
+		return pageID
+	}
+	dm.allocMu.Lock()
+	defer dm.allocMu.Unlock()
+	pageID := dm.base + PageID(dm.nextPageID)
 	dm.nextPageID++
-	return PageID(pageID)
+	return pageID
+}
+
+// allocateFree is AllocatePage restricted to the pages released by FreePage.
+func (dm *DiskManager) allocateFree() (PageID, bool) {
+	dm.allocMu.Lock()
+	defer dm.allocMu.Unlock()
+	n := len(dm.freePages)
+	if n == 0 {
+		return InvalidPageID, false
+	}
+	pageID := dm.freePages[n-1]
+	dm.freePages = dm.freePages[:n-1]
+	return pageID, true
+}
+
+// FreePage releases a page that is no longer referenced so that AllocatePage can reuse it.
+// The free list is saved in the heap file by Close and reused after a restart; pages freed
+// before a crash are not reused after it.
+func (dm *DiskManager) FreePage(pageID PageID) {
+	dm.allocMu.Lock()
+	defer dm.allocMu.Unlock()
+	dm.freePages = append(dm.freePages, pageID)
+}
+
+// ClaimPage marks a page as allocated: it is taken off the free list, or if it lies past the
+// allocated pages, those are extended up to it and the pages skipped are freed.
+func (dm *DiskManager) ClaimPage(pageID PageID) error {
+	dm.allocMu.Lock()
+	defer dm.allocMu.Unlock()
+	if i := slices.Index(dm.freePages, pageID); 0 <= i {
+		dm.freePages = slices.Delete(dm.freePages, i, i+1)
+		return nil
+	}
+	for next := dm.base + PageID(dm.nextPageID); next <= pageID; next++ {
+		if next < pageID {
+			dm.freePages = append(dm.freePages, next)
+		}
+		dm.nextPageID++
+	}
+	return nil
+}
+
+// NumFreePages returns the number of freed pages waiting to be reused.
+func (dm *DiskManager) NumFreePages() int {
+	dm.allocMu.Lock()
+	defer dm.allocMu.Unlock()
+	return len(dm.freePages)
+}
+
+// NumPages returns the number of pages allocated in the heap file.
+func (dm *DiskManager) NumPages() PageID {
+	dm.allocMu.Lock()
+	defer dm.allocMu.Unlock()
+	return PageID(dm.nextPageID)
 }
 
 func (dm *DiskManager) Sync() error {
@@ -98,6 +292,44 @@ func (dm *DiskManager) Sync() error {
 	return nil
 }
 
+// Freeze syncs the heap file and then blocks every subsequent WritePageData call until Thaw is called.
+// While frozen, the heap file is not modified, so an external filesystem snapshot taken
+// during the freeze sees a stable file.
+func (dm *DiskManager) Freeze() error {
+	dm.freezeMu.Lock()
+	defer dm.freezeMu.Unlock()
+	if dm.frozen {
+		return ErrAlreadyFrozen
+	}
+	if err := dm.heapFile.Sync(); err != nil {
+		return err
+	}
+	dm.frozen = true
+	return nil
+}
+
+// Thaw lifts a freeze and wakes up the writers blocked by it.
+func (dm *DiskManager) Thaw() {
+	dm.freezeMu.Lock()
+	defer dm.freezeMu.Unlock()
+	dm.frozen = false
+	dm.thawed.Broadcast()
+}
+
+func (dm *DiskManager) waitThawed() {
+	dm.freezeMu.Lock()
+	defer dm.freezeMu.Unlock()
+	for dm.frozen {
+		dm.thawed.Wait()
+	}
+}
+
+// Close saves the free list in the heap file and closes it. It must only be called once no
+// transaction that freed pages can be rolled back anymore.
 func (dm *DiskManager) Close() error {
+	if err := dm.writeFreeList(); err != nil {
+		dm.heapFile.Close()
+		return err
+	}
 	return dm.heapFile.Close()
 }
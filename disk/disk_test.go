@@ -4,6 +4,7 @@ import (
 	"os"
 	"reflect"
 	"testing"
+	"time"
 )
 
 func TestDiskManager(t *testing.T) {
@@ -57,3 +58,110 @@ func TestDiskManager(t *testing.T) {
 		t.Errorf("world page: expected %v, got %v", world, buf)
 	}
 }
+
+func TestDiskManagerFreeList(t *testing.T) {
+	tmpfile, err := os.CreateTemp("", "test_disk_free_list_*.db")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(tmpfile.Name())
+
+	dm, err := NewDiskManager(tmpfile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	page := make([]byte, PageSize)
+	for i := 0; i < 5; i++ {
+		if err := dm.WritePageData(dm.AllocatePage(), page); err != nil {
+			t.Fatal(err)
+		}
+	}
+	dm.FreePage(1)
+	dm.FreePage(3)
+	if err := dm.Close(); err != nil {
+		t.Fatal(err)
+	}
+	fileSize := func() int64 {
+		t.Helper()
+		stat, err := os.Stat(tmpfile.Name())
+		if err != nil {
+			t.Fatal(err)
+		}
+		return stat.Size()
+	}
+	if got := fileSize(); got != 6*PageSize {
+		t.Errorf("expected the free list to take one page after the 5 pages, got %d bytes", got)
+	}
+
+	// The freed pages are reused after a restart
+	dm, err = OpenDiskManager(tmpfile.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := fileSize(); got != 5*PageSize {
+		t.Errorf("expected the free list to be cut off the heap file, got %d bytes", got)
+	}
+	if dm.NumPages() != 5 || dm.NumFreePages() != 2 {
+		t.Errorf("expected 5 pages with 2 free, got %d with %d free", dm.NumPages(), dm.NumFreePages())
+	}
+	if first, second := dm.AllocatePage(), dm.AllocatePage(); first != 3 || second != 1 {
+		t.Errorf("expected the freed pages 3 and 1 to be reused, got %d and %d", first, second)
+	}
+	if got := dm.AllocatePage(); got != 5 {
+		t.Errorf("expected a new page 5, got %d", got)
+	}
+	if err := dm.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if got := fileSize(); got != 5*PageSize {
+		t.Errorf("expected no free list without free pages, got %d bytes", got)
+	}
+}
+
+func TestDiskManagerFreeze(t *testing.T) {
+	tmpfile, err := os.CreateTemp("", "test_disk_freeze_*.db")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(tmpfile.Name())
+
+	disk, err := NewDiskManager(tmpfile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer disk.Close()
+
+	if err := disk.Freeze(); err != nil {
+		t.Fatal(err)
+	}
+	if err := disk.Freeze(); err != ErrAlreadyFrozen {
+		t.Errorf("expected ErrAlreadyFrozen, got %v", err)
+	}
+
+	data := make([]byte, PageSize)
+	copy(data, []byte("frozen"))
+	pageID := disk.AllocatePage()
+	done := make(chan error)
+	go func() {
+		done <- disk.WritePageData(pageID, data)
+	}()
+
+	select {
+	case <-done:
+		t.Fatal("write completed while frozen")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	disk.Thaw()
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+
+	buf := make([]byte, PageSize)
+	if err := disk.ReadPageData(pageID, buf); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(data, buf) {
+		t.Errorf("expected %v, got %v", data[:8], buf[:8])
+	}
+}
@@ -0,0 +1,238 @@
+package disk
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/binary"
+	"os"
+	"sync"
+
+	"github.com/Johniel/gorelly/errcode"
+)
+
+var (
+	// ErrKeyNotFound is returned when a page is encrypted with a key missing from the keyring.
+	ErrKeyNotFound = errcode.New(errcode.NotFound, "encryption key not found")
+	// ErrKeyExists is returned when adding a key with an ID that is already in use.
+	ErrKeyExists = errcode.New(errcode.AlreadyExists, "encryption key already exists")
+	// ErrInvalidKeyID is returned for the reserved key ID 0.
+	ErrInvalidKeyID = errcode.New(errcode.InvalidParameter, "encryption key ID 0 is reserved")
+	// ErrZoneNotFound is returned when a key zone has no active key.
+	ErrZoneNotFound = errcode.New(errcode.NotFound, "key zone has no active key")
+	// ErrKeyActive is returned when removing a key that is still active in its zone.
+	ErrKeyActive = errcode.New(errcode.ObjectNotInPrerequisiteState, "encryption key is active")
+	// ErrPageAuthentication is returned when an encrypted page fails authentication
+	// (it was modified, or moved from another page ID).
+	ErrPageAuthentication = errcode.New(errcode.Corruption, "encrypted page failed authentication")
+)
+
+const (
+	keyIDSize = 4
+	nonceSize = 12
+	tagSize   = 16
+	// EncryptedPageOverhead is the number of bytes an encrypted page takes on disk in addition
+	// to PageSize: the ID of the key it is encrypted with, the nonce and the authentication tag.
+	EncryptedPageOverhead = keyIDSize + nonceSize + tagSize
+)
+
+// Keyring holds the page encryption keys, grouped in key zones.
+// Every key belongs to one zone, and each zone has one active key used for new writes;
+// pages written with an older key of the zone stay readable until they are re-encrypted.
+// Which zone a page belongs to is decided by the zone function (every page is in the
+// zone "" by default), e.g. to give each table its own keys.
+type Keyring struct {
+	keys    map[uint32]cipher.AEAD
+	keyZone map[uint32]string
+	active  map[string]uint32
+	zoneOf  func(PageID) string
+	mu      sync.RWMutex
+}
+
+func NewKeyring() *Keyring {
+	return &Keyring{
+		keys:    make(map[uint32]cipher.AEAD),
+		keyZone: make(map[uint32]string),
+		active:  make(map[string]uint32),
+	}
+}
+
+// AddKey adds an AES key (16, 24 or 32 bytes) to a zone. The first key of a zone becomes its active key.
+func (kr *Keyring) AddKey(zone string, keyID uint32, key []byte) error {
+	if keyID == 0 {
+		return ErrInvalidKeyID
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return err
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return err
+	}
+
+	kr.mu.Lock()
+	defer kr.mu.Unlock()
+	if _, ok := kr.keys[keyID]; ok {
+		return ErrKeyExists
+	}
+	kr.keys[keyID] = aead
+	kr.keyZone[keyID] = zone
+	if _, ok := kr.active[zone]; !ok {
+		kr.active[zone] = keyID
+	}
+	return nil
+}
+
+// SetActiveKey makes keyID the key new writes to its zone are encrypted with (key rotation).
+func (kr *Keyring) SetActiveKey(keyID uint32) error {
+	kr.mu.Lock()
+	defer kr.mu.Unlock()
+	zone, ok := kr.keyZone[keyID]
+	if !ok {
+		return ErrKeyNotFound
+	}
+	kr.active[zone] = keyID
+	return nil
+}
+
+// ActiveKey returns the ID of the active key of a zone.
+func (kr *Keyring) ActiveKey(zone string) (uint32, error) {
+	kr.mu.RLock()
+	defer kr.mu.RUnlock()
+	keyID, ok := kr.active[zone]
+	if !ok {
+		return 0, ErrZoneNotFound
+	}
+	return keyID, nil
+}
+
+// RemoveKey removes a retired key, e.g. once every page of its zone has been re-encrypted.
+// Pages still encrypted with it become unreadable.
+func (kr *Keyring) RemoveKey(keyID uint32) error {
+	kr.mu.Lock()
+	defer kr.mu.Unlock()
+	zone, ok := kr.keyZone[keyID]
+	if !ok {
+		return ErrKeyNotFound
+	}
+	if kr.active[zone] == keyID {
+		return ErrKeyActive
+	}
+	delete(kr.keys, keyID)
+	delete(kr.keyZone, keyID)
+	return nil
+}
+
+// SetZoneFunc sets the function assigning pages to key zones. nil puts every page in the zone "".
+func (kr *Keyring) SetZoneFunc(zoneOf func(PageID) string) {
+	kr.mu.Lock()
+	defer kr.mu.Unlock()
+	kr.zoneOf = zoneOf
+}
+
+// Zone returns the key zone of a page.
+func (kr *Keyring) Zone(pageID PageID) string {
+	kr.mu.RLock()
+	zoneOf := kr.zoneOf
+	kr.mu.RUnlock()
+	if zoneOf == nil {
+		return ""
+	}
+	return zoneOf(pageID)
+}
+
+// encryptPage encrypts a page with the active key of its zone into dst (PageSize+EncryptedPageOverhead bytes).
+func (kr *Keyring) encryptPage(pageID PageID, dst []byte, page []byte) error {
+	zone := kr.Zone(pageID)
+	kr.mu.RLock()
+	keyID, ok := kr.active[zone]
+	aead := kr.keys[keyID]
+	kr.mu.RUnlock()
+	if !ok {
+		return ErrZoneNotFound
+	}
+
+	binary.BigEndian.PutUint32(dst, keyID)
+	nonce := dst[keyIDSize : keyIDSize+nonceSize]
+	if _, err := rand.Read(nonce); err != nil {
+		return err
+	}
+	aead.Seal(dst[keyIDSize+nonceSize:keyIDSize+nonceSize], nonce, page, pageID.ToBytes())
+	return nil
+}
+
+// decryptPage decrypts an encrypted page into page. A page that is all zeros on disk
+// (allocated but never written) decrypts to a zero page.
+func (kr *Keyring) decryptPage(pageID PageID, page []byte, src []byte) error {
+	keyID := binary.BigEndian.Uint32(src)
+	if keyID == 0 {
+		clear(page)
+		return nil
+	}
+	kr.mu.RLock()
+	aead, ok := kr.keys[keyID]
+	kr.mu.RUnlock()
+	if !ok {
+		return ErrKeyNotFound
+	}
+	nonce := src[keyIDSize : keyIDSize+nonceSize]
+	if _, err := aead.Open(page[:0], nonce, src[keyIDSize+nonceSize:], pageID.ToBytes()); err != nil {
+		return ErrPageAuthentication
+	}
+	return nil
+}
+
+// NewEncryptedDiskManager creates a DiskManager that encrypts every page with AES-GCM using
+// the keys of keyring. Encrypted heap files have a different layout: each page takes
+// PageSize+EncryptedPageOverhead bytes, so a plaintext heap file cannot be opened encrypted.
+func NewEncryptedDiskManager(heapFile *os.File, keyring *Keyring) (*DiskManager, error) {
+	return newDiskManager(heapFile, keyring)
+}
+
+// PageKeyID returns the ID of the key a page is encrypted with on disk (0 for a page never written).
+func (dm *DiskManager) PageKeyID(pageID PageID) (uint32, error) {
+	if dm.keyring == nil {
+		return 0, nil
+	}
+	dm.ioMu.Lock()
+	defer dm.ioMu.Unlock()
+	raw := make([]byte, PageSize+EncryptedPageOverhead)
+	if err := dm.readRaw(pageID, raw); err != nil {
+		return 0, err
+	}
+	return binary.BigEndian.Uint32(raw), nil
+}
+
+// ReencryptPage rewrites a page with the active key of its zone if it is encrypted with another key.
+// It reports whether the page was rewritten. Concurrent page writes are serialized with it,
+// so it can run while the database is online.
+func (dm *DiskManager) ReencryptPage(pageID PageID) (bool, error) {
+	if dm.keyring == nil {
+		return false, nil
+	}
+	dm.waitThawed()
+	dm.ioMu.Lock()
+	defer dm.ioMu.Unlock()
+
+	raw := make([]byte, PageSize+EncryptedPageOverhead)
+	if err := dm.readRaw(pageID, raw); err != nil {
+		return false, err
+	}
+	keyID := binary.BigEndian.Uint32(raw)
+	active, err := dm.keyring.ActiveKey(dm.keyring.Zone(pageID))
+	if err != nil {
+		return false, err
+	}
+	if keyID == 0 || keyID == active {
+		return false, nil
+	}
+	page := make([]byte, PageSize)
+	if err := dm.keyring.decryptPage(pageID, page, raw); err != nil {
+		return false, err
+	}
+	if err := dm.keyring.encryptPage(pageID, raw, page); err != nil {
+		return false, err
+	}
+	return true, dm.writeRaw(pageID, raw)
+}
@@ -0,0 +1,115 @@
+package disk
+
+import (
+	"bytes"
+	"os"
+	"testing"
+)
+
+func TestEncryptedDiskManager(t *testing.T) {
+	tmpfile, err := os.CreateTemp("", "test_encrypted_disk_*.db")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(tmpfile.Name())
+
+	keyring := NewKeyring()
+	if err := keyring.AddKey("", 0, bytes.Repeat([]byte{1}, 16)); err != ErrInvalidKeyID {
+		t.Errorf("expected ErrInvalidKeyID, got %v", err)
+	}
+	if err := keyring.AddKey("", 1, bytes.Repeat([]byte{1}, 16)); err != nil {
+		t.Fatal(err)
+	}
+	if err := keyring.AddKey("secret", 2, bytes.Repeat([]byte{2}, 32)); err != nil {
+		t.Fatal(err)
+	}
+	// Odd pages belong to the "secret" zone
+	keyring.SetZoneFunc(func(pageID PageID) string {
+		if pageID%2 == 1 {
+			return "secret"
+		}
+		return ""
+	})
+
+	dm, err := NewEncryptedDiskManager(tmpfile, keyring)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer dm.Close()
+
+	plaintext := make([]byte, PageSize)
+	copy(plaintext, []byte("top secret payload"))
+	for i := 0; i < 2; i++ {
+		if err := dm.WritePageData(dm.AllocatePage(), plaintext); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	t.Run("ReadBack", func(t *testing.T) {
+		for pageID, keyID := range []uint32{1, 2} {
+			data := make([]byte, PageSize)
+			if err := dm.ReadPageData(PageID(pageID), data); err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(data, plaintext) {
+				t.Errorf("page %d: expected the written plaintext", pageID)
+			}
+			if got, err := dm.PageKeyID(PageID(pageID)); err != nil || got != keyID {
+				t.Errorf("page %d: expected key %d, got %d (%v)", pageID, keyID, got, err)
+			}
+		}
+		raw, err := os.ReadFile(tmpfile.Name())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(raw) != 2*(PageSize+EncryptedPageOverhead) {
+			t.Errorf("expected 2 encrypted pages on disk, got %d bytes", len(raw))
+		}
+		if bytes.Contains(raw, []byte("top secret")) {
+			t.Error("expected no plaintext in the heap file")
+		}
+	})
+
+	t.Run("Tampering", func(t *testing.T) {
+		// Copy page 0 over page 2: the page ID is authenticated, so the copy is rejected
+		raw := make([]byte, PageSize+EncryptedPageOverhead)
+		if err := dm.readRaw(0, raw); err != nil {
+			t.Fatal(err)
+		}
+		if err := dm.writeRaw(2, raw); err != nil {
+			t.Fatal(err)
+		}
+		if err := dm.ReadPageData(2, make([]byte, PageSize)); err != ErrPageAuthentication {
+			t.Errorf("expected ErrPageAuthentication, got %v", err)
+		}
+	})
+
+	t.Run("Rotation", func(t *testing.T) {
+		if err := keyring.AddKey("secret", 3, bytes.Repeat([]byte{3}, 32)); err != nil {
+			t.Fatal(err)
+		}
+		if err := keyring.SetActiveKey(3); err != nil {
+			t.Fatal(err)
+		}
+		if err := keyring.RemoveKey(3); err != ErrKeyActive {
+			t.Errorf("expected ErrKeyActive, got %v", err)
+		}
+		// Page 0 is in the other zone and keeps its key
+		if rewritten, err := dm.ReencryptPage(0); err != nil || rewritten {
+			t.Errorf("expected page 0 to be left alone, got %v (%v)", rewritten, err)
+		}
+		if rewritten, err := dm.ReencryptPage(1); err != nil || !rewritten {
+			t.Errorf("expected page 1 to be rewritten, got %v (%v)", rewritten, err)
+		}
+		if err := keyring.RemoveKey(2); err != nil {
+			t.Fatal(err)
+		}
+		data := make([]byte, PageSize)
+		if err := dm.ReadPageData(1, data); err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(data, plaintext) {
+			t.Error("expected the re-encrypted page to keep its content")
+		}
+	})
+}
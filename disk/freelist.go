@@ -0,0 +1,84 @@
+package disk
+
+import (
+	"encoding/binary"
+	"hash/crc32"
+)
+
+// The free list is kept in memory while the heap file is open. Close appends it to the heap file
+// as trailer pages after the last allocated page: the IDs of the free pages (8B each) from the
+// start of the first trailer page on, and a footer at the end of the last one:
+// [checksum of the IDs (4B)][number of trailer pages (4B)][number of IDs (4B)][magic (8B)].
+// Opening the heap file loads the free list and cuts the trailer off again, so a crash while
+// the file is open loses the pages freed before it instead of reusing pages that are in use.
+const freeListFooterSize = 20
+
+var freeListMagic = []byte("RLYFREE\x00")
+
+// loadFreeList reads the free list appended by Close, if any, and removes it from the heap file.
+// The caller must have set nextPageID to the number of pages in the file.
+func (dm *DiskManager) loadFreeList() error {
+	if dm.nextPageID == 0 {
+		return nil
+	}
+	size := dm.RawPageSize()
+	last := make([]byte, size)
+	if err := dm.readRaw(dm.base+PageID(dm.nextPageID-1), last); err != nil {
+		return err
+	}
+	footer := last[size-freeListFooterSize:]
+	if string(footer[12:]) != string(freeListMagic) {
+		return nil
+	}
+	numPages := uint64(binary.BigEndian.Uint32(footer[4:]))
+	count := int64(binary.BigEndian.Uint32(footer[8:]))
+	if numPages == 0 || dm.nextPageID < numPages || numPages*uint64(size)-freeListFooterSize < uint64(count)*8 {
+		// A page that happens to end like a footer
+		return nil
+	}
+	start := dm.nextPageID - numPages
+	ids := make([]byte, count*8)
+	if _, err := dm.heapFile.ReadAt(ids, int64(start)*size); err != nil {
+		return err
+	}
+	if crc32.ChecksumIEEE(ids) != binary.BigEndian.Uint32(footer) {
+		return nil
+	}
+
+	dm.freePages = make([]PageID, count)
+	for i := range dm.freePages {
+		dm.freePages[i] = PageID(binary.BigEndian.Uint64(ids[i*8:]))
+	}
+	dm.nextPageID = start
+	if err := dm.heapFile.Truncate(int64(start) * size); err != nil {
+		return err
+	}
+	return dm.heapFile.Sync()
+}
+
+// writeFreeList appends the free list to the heap file (see loadFreeList).
+func (dm *DiskManager) writeFreeList() error {
+	dm.allocMu.Lock()
+	defer dm.allocMu.Unlock()
+	if len(dm.freePages) == 0 {
+		return nil
+	}
+	size := dm.RawPageSize()
+	numPages := (int64(len(dm.freePages))*8 + freeListFooterSize + size - 1) / size
+	trailer := make([]byte, numPages*size)
+	for i, pageID := range dm.freePages {
+		binary.BigEndian.PutUint64(trailer[i*8:], uint64(pageID))
+	}
+	footer := trailer[len(trailer)-freeListFooterSize:]
+	binary.BigEndian.PutUint32(footer, crc32.ChecksumIEEE(trailer[:len(dm.freePages)*8]))
+	binary.BigEndian.PutUint32(footer[4:], uint32(numPages))
+	binary.BigEndian.PutUint32(footer[8:], uint32(len(dm.freePages)))
+	copy(footer[12:], freeListMagic)
+
+	dm.ioMu.Lock()
+	defer dm.ioMu.Unlock()
+	if _, err := dm.heapFile.WriteAt(trailer, int64(dm.nextPageID)*size); err != nil {
+		return err
+	}
+	return dm.heapFile.Sync()
+}
@@ -0,0 +1,172 @@
+package disk
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/Johniel/gorelly/errcode"
+)
+
+var (
+	// ErrSyncUnreliable is returned by SelfTest when data synced to a file cannot be read back.
+	ErrSyncUnreliable = errcode.New(errcode.ObjectNotInPrerequisiteState, "filesystem does not honor fsync")
+	// ErrHeapFileSize is returned by SelfTest when the size of the heap file is not a multiple
+	// of its page size.
+	ErrHeapFileSize = errcode.New(errcode.Corruption, "heap file size does not match the page size")
+)
+
+// Environment locates the files of a database for SelfTest.
+type Environment struct {
+	HeapPath  string // Path of the heap file; it need not exist yet
+	LogPath   string // Optional path of the log (for a segmented log, the path its segments are named after)
+	Encrypted bool   // Whether the heap file holds encrypted pages (see SetKeyring)
+	// Whether the heap file holds compressed pages (see NewCompressedDiskManager). The last page
+	// of a compressed heap file may be cut short, so its size is not checked.
+	Compressed bool
+}
+
+// SelfTestReport describes the environment checked by SelfTest.
+type SelfTestReport struct {
+	SectorSize int64  // Logical sector size of the heap file's device (0 if unknown)
+	FileSystem string // Type of the heap file's filesystem ("" if unknown)
+	SameDevice bool   // Whether the heap file and the log are on the same device
+	// Problems that do not keep the database from working but put its durability or
+	// performance at risk, as messages meant for the operator.
+	Warnings []string
+}
+
+// SelfTest checks that the environment can hold the database before it is opened, so that a
+// misconfiguration fails early with an actionable message instead of corrupting data later:
+//
+//   - a page written and synced to the directories of the heap file and the log reads back
+//     after reopening the file, or ErrSyncUnreliable is returned;
+//   - an existing heap file that is not compressed holds whole pages of the expected size
+//     (PageSize, plus EncryptedPageOverhead if encrypted), or ErrHeapFileSize is returned;
+//   - the sector size and filesystem are detected where the platform allows it, and
+//     configurations with known durability issues are reported as warnings.
+func SelfTest(env Environment) (*SelfTestReport, error) {
+	heapDir := filepath.Dir(env.HeapPath)
+	if err := checkSync(heapDir); err != nil {
+		return nil, err
+	}
+	logDir := ""
+	if env.LogPath != "" {
+		logDir = filepath.Dir(env.LogPath)
+		if logDir != heapDir {
+			if err := checkSync(logDir); err != nil {
+				return nil, err
+			}
+		}
+	}
+	if !env.Compressed {
+		if err := checkHeapFileSize(env.HeapPath, env.Encrypted); err != nil {
+			return nil, err
+		}
+	}
+
+	heapFS := fileSystemOf(heapDir)
+	report := &SelfTestReport{SectorSize: heapFS.sectorSize, FileSystem: heapFS.name}
+	var logFS *fileSystem
+	if logDir != "" {
+		fs := fileSystemOf(logDir)
+		logFS = &fs
+		report.SameDevice = heapFS.device != 0 && heapFS.device == fs.device
+	}
+	report.Warnings = environmentWarnings(heapFS, logFS)
+	return report, nil
+}
+
+// fileSystem describes the filesystem and device holding a directory, as far as the platform
+// allows detecting them; unknown values are zero.
+type fileSystem struct {
+	name       string // Filesystem type, e.g. "ext4"
+	device     uint64 // ID of the device
+	sectorSize int64  // Logical sector size of the device
+}
+
+// Filesystems on which the database can run but its files are at risk.
+var fileSystemIssues = map[string]string{
+	"tmpfs": "is on tmpfs, whose contents are lost at shutdown",
+	"nfs":   "is on NFS, where fsync and locking depend on the server and mount options",
+}
+
+// environmentWarnings returns the warnings for the filesystems of the heap file and the log
+// (nil if the database has no log).
+func environmentWarnings(heapFS fileSystem, logFS *fileSystem) []string {
+	var warnings []string
+	if issue, ok := fileSystemIssues[heapFS.name]; ok {
+		warnings = append(warnings, "the heap file "+issue)
+	}
+	if heapFS.sectorSize != 0 && PageSize%heapFS.sectorSize != 0 {
+		warnings = append(warnings, fmt.Sprintf("the page size %d is not a multiple of the sector size %d of the heap file's device, so page writes are not sector aligned",
+			PageSize, heapFS.sectorSize))
+	}
+	if logFS == nil {
+		return warnings
+	}
+	if issue, ok := fileSystemIssues[logFS.name]; ok {
+		warnings = append(warnings, "the log "+issue)
+		if heapFS.device != 0 && heapFS.device == logFS.device {
+			warnings = append(warnings, "the heap file and the log share a device that "+issue+
+				"; the log cannot recover the heap file if both are lost, so move the log to another device")
+		}
+	}
+	return warnings
+}
+
+// checkSync writes a page to a new file in dir, syncs it, and reads it back after reopening the file.
+func checkSync(dir string) error {
+	file, err := os.CreateTemp(dir, ".gorelly-selftest-*")
+	if err != nil {
+		return err
+	}
+	path := file.Name()
+	defer os.Remove(path)
+
+	page := make([]byte, PageSize)
+	for i := range page {
+		page[i] = byte(i * 7)
+	}
+	if _, err := file.Write(page); err != nil {
+		file.Close()
+		return err
+	}
+	if err := file.Sync(); err != nil {
+		file.Close()
+		return fmt.Errorf("%w: syncing a file in %s failed (%v); put the database on a filesystem that supports fsync", ErrSyncUnreliable, dir, err)
+	}
+	if err := file.Close(); err != nil {
+		return err
+	}
+
+	readBack, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	if !bytes.Equal(readBack, page) {
+		return fmt.Errorf("%w: a page synced to %s read back differently; put the database on a filesystem that honors fsync", ErrSyncUnreliable, dir)
+	}
+	return nil
+}
+
+// checkHeapFileSize checks that an existing heap file holds whole pages.
+func checkHeapFileSize(heapPath string, encrypted bool) error {
+	stat, err := os.Stat(heapPath)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	pageSize := int64(PageSize)
+	if encrypted {
+		pageSize += EncryptedPageOverhead
+	}
+	if stat.Size()%pageSize != 0 {
+		return fmt.Errorf("%w: %s has %d bytes, not a multiple of the %d byte pages; it was written with a different page size or encryption setting, or was cut short",
+			ErrHeapFileSize, heapPath, stat.Size(), pageSize)
+	}
+	return nil
+}
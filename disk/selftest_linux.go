@@ -0,0 +1,53 @@
+package disk
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"syscall"
+)
+
+// Magic numbers of the filesystem types reported by statfs (see statfs(2)).
+var fileSystemNames = map[int64]string{
+	0xEF53:     "ext4",
+	0x58465342: "xfs",
+	0x9123683E: "btrfs",
+	0x2FC12FC1: "zfs",
+	0x01021994: "tmpfs",
+	0x6969:     "nfs",
+	0x794C7630: "overlay",
+}
+
+func fileSystemOf(dir string) fileSystem {
+	var fs fileSystem
+	var statfs syscall.Statfs_t
+	if err := syscall.Statfs(dir, &statfs); err == nil {
+		fs.name = fileSystemNames[int64(statfs.Type)]
+	}
+	var stat syscall.Stat_t
+	if err := syscall.Stat(dir, &stat); err == nil {
+		fs.device = uint64(stat.Dev)
+		fs.sectorSize = sectorSize(fs.device)
+	}
+	return fs
+}
+
+// sectorSize reads the logical sector size of a block device from sysfs. A partition has no
+// queue of its own, so the queue of the disk it belongs to is read instead. It returns 0 if the
+// device has no block queue, e.g. for tmpfs or overlay.
+func sectorSize(device uint64) int64 {
+	major := (device>>8)&0xfff | (device>>32)&^0xfff
+	minor := device&0xff | (device>>12)&^0xff
+	base := fmt.Sprintf("/sys/dev/block/%d:%d", major, minor)
+	for _, path := range []string{base + "/queue/logical_block_size", base + "/../queue/logical_block_size"} {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			continue
+		}
+		if size, err := strconv.ParseInt(strings.TrimSpace(string(data)), 10, 64); err == nil {
+			return size
+		}
+	}
+	return 0
+}
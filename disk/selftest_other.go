@@ -0,0 +1,8 @@
+//go:build !linux
+
+package disk
+
+// fileSystemOf cannot detect the filesystem on this platform.
+func fileSystemOf(dir string) fileSystem {
+	return fileSystem{}
+}
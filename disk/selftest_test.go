@@ -0,0 +1,83 @@
+package disk
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestSelfTest(t *testing.T) {
+	dir := t.TempDir()
+	heapPath := filepath.Join(dir, "heap.db")
+	env := Environment{HeapPath: heapPath, LogPath: filepath.Join(dir, "wal.log")}
+
+	// A new database
+	report, err := SelfTest(env)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.SectorSize < 0 {
+		t.Errorf("unexpected sector size %d", report.SectorSize)
+	}
+	if fileSystemOf(dir).device != 0 && !report.SameDevice {
+		t.Error("expected the heap file and the log in the same directory to share a device")
+	}
+	if entries, err := os.ReadDir(dir); err != nil || len(entries) != 0 {
+		t.Errorf("expected the self-test to leave no files behind, got %v (%v)", entries, err)
+	}
+
+	// An existing heap file of whole pages
+	if err := os.WriteFile(heapPath, make([]byte, 3*PageSize), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := SelfTest(env); err != nil {
+		t.Fatal(err)
+	}
+	// The same file does not hold whole encrypted pages
+	env.Encrypted = true
+	if _, err := SelfTest(env); !errors.Is(err, ErrHeapFileSize) {
+		t.Errorf("expected ErrHeapFileSize, got %v", err)
+	}
+	env.Encrypted = false
+	if err := os.WriteFile(heapPath, make([]byte, 3*PageSize+100), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := SelfTest(env); !errors.Is(err, ErrHeapFileSize) {
+		t.Errorf("expected ErrHeapFileSize, got %v", err)
+	}
+	// The last page of a compressed heap file may be cut short
+	env.Compressed = true
+	if _, err := SelfTest(env); err != nil {
+		t.Errorf("expected a compressed heap file to pass, got %v", err)
+	}
+
+	// A directory that cannot be written to fails the sync check
+	if _, err := SelfTest(Environment{HeapPath: filepath.Join(dir, "missing", "heap.db")}); err == nil {
+		t.Error("expected an error for a missing directory")
+	}
+}
+
+func TestEnvironmentWarnings(t *testing.T) {
+	ext4 := fileSystem{name: "ext4", device: 1, sectorSize: 512}
+	if warnings := environmentWarnings(ext4, &ext4); len(warnings) != 0 {
+		t.Errorf("expected no warnings, got %v", warnings)
+	}
+
+	tmpfs := fileSystem{name: "tmpfs", device: 2}
+	warnings := environmentWarnings(ext4, &tmpfs)
+	if len(warnings) != 1 || !strings.Contains(warnings[0], "the log is on tmpfs") {
+		t.Errorf("expected a warning about the log, got %v", warnings)
+	}
+	// Sharing the device makes the log useless for recovering the heap file
+	warnings = environmentWarnings(tmpfs, &tmpfs)
+	if len(warnings) != 3 || !strings.Contains(warnings[2], "share a device") {
+		t.Errorf("expected warnings about both files and the shared device, got %v", warnings)
+	}
+
+	odd := fileSystem{name: "ext4", sectorSize: 3000}
+	if warnings := environmentWarnings(odd, nil); len(warnings) != 1 || !strings.Contains(warnings[0], "sector size 3000") {
+		t.Errorf("expected a warning about the sector size, got %v", warnings)
+	}
+}
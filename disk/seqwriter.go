@@ -0,0 +1,160 @@
+package disk
+
+import (
+	"io"
+	"sort"
+
+	"github.com/Johniel/gorelly/errcode"
+	"github.com/Johniel/gorelly/ownercheck"
+)
+
+var (
+	// ErrWriterClosed is returned when writing to a SequentialWriter that was closed.
+	ErrWriterClosed = errcode.New(errcode.ObjectNotInPrerequisiteState, "sequential writer closed")
+)
+
+// SequentialWriter writes brand-new pages straight to the heap file, bypassing the buffer pool,
+// so that bulk loads, compaction and materialization do not evict hot pages.
+// Pages are staged in a small buffer of its own and written once it is full, with one write
+// per run of consecutive page IDs.
+//
+// A page written through a SequentialWriter is not readable until Flush or Close returns,
+// and must not be cached in the buffer pool before that. A SequentialWriter is not safe for
+// concurrent use; with the gorellycheck build tag, concurrent calls panic (see ownercheck).
+type SequentialWriter struct {
+	store   PageStore
+	staged  []stagedPage
+	maxSize int
+	closed  bool
+	owner   ownercheck.Owner
+}
+
+type stagedPage struct {
+	pageID PageID
+	data   []byte
+}
+
+// stagedWriter is a PageStore that writes staged pages in runs of consecutive page IDs.
+// SequentialWriter writes the pages of other stores one by one.
+type stagedWriter interface {
+	writeStaged(pages []stagedPage) error
+}
+
+// NewSequentialWriter creates a writer staging up to stagingPages pages (at least 1) in memory.
+func NewSequentialWriter(store PageStore, stagingPages int) *SequentialWriter {
+	if stagingPages < 1 {
+		stagingPages = 1
+	}
+	return &SequentialWriter{
+		store:   store,
+		staged:  make([]stagedPage, 0, stagingPages),
+		maxSize: stagingPages,
+	}
+}
+
+// WritePage allocates a page, stages a copy of data (at most PageSize bytes, zero-padded)
+// as its content and returns its ID.
+func (sw *SequentialWriter) WritePage(data []byte) (PageID, error) {
+	defer sw.owner.Acquire("SequentialWriter", "WritePage")()
+	pageID := sw.store.AllocatePage()
+	if err := sw.writePageAt(pageID, data); err != nil {
+		return InvalidPageID, err
+	}
+	return pageID, nil
+}
+
+// WritePageAt stages data as the content of a page the caller allocated with AllocatePage,
+// e.g. to link pages to each other before writing them.
+func (sw *SequentialWriter) WritePageAt(pageID PageID, data []byte) error {
+	defer sw.owner.Acquire("SequentialWriter", "WritePageAt")()
+	return sw.writePageAt(pageID, data)
+}
+
+func (sw *SequentialWriter) writePageAt(pageID PageID, data []byte) error {
+	if sw.closed {
+		return ErrWriterClosed
+	}
+	page := make([]byte, PageSize)
+	copy(page, data)
+	sw.staged = append(sw.staged, stagedPage{pageID: pageID, data: page})
+	if len(sw.staged) < sw.maxSize {
+		return nil
+	}
+	return sw.flush()
+}
+
+// Flush writes the staged pages to the heap file without syncing it.
+func (sw *SequentialWriter) Flush() error {
+	defer sw.owner.Acquire("SequentialWriter", "Flush")()
+	return sw.flush()
+}
+
+func (sw *SequentialWriter) flush() error {
+	if len(sw.staged) == 0 {
+		return nil
+	}
+	sort.Slice(sw.staged, func(i, j int) bool {
+		return sw.staged[i].pageID < sw.staged[j].pageID
+	})
+
+	if w, ok := sw.store.(stagedWriter); ok {
+		if err := w.writeStaged(sw.staged); err != nil {
+			return err
+		}
+	} else {
+		for _, page := range sw.staged {
+			if err := sw.store.WritePageData(page.pageID, page.data); err != nil {
+				return err
+			}
+		}
+	}
+	sw.staged = sw.staged[:0]
+	return nil
+}
+
+// Close flushes the staged pages and syncs the heap file. The writer cannot be used afterwards.
+func (sw *SequentialWriter) Close() error {
+	defer sw.owner.Acquire("SequentialWriter", "Close")()
+	if sw.closed {
+		return nil
+	}
+	if err := sw.flush(); err != nil {
+		return err
+	}
+	sw.closed = true
+	return sw.store.Sync()
+}
+
+// writeStaged writes pages, sorted by page ID, with one write per run of consecutive page IDs.
+func (dm *DiskManager) writeStaged(pages []stagedPage) error {
+	dm.waitThawed()
+	dm.ioMu.Lock()
+	defer dm.ioMu.Unlock()
+
+	rawSize := int(dm.RawPageSize())
+	for start := 0; start < len(pages); {
+		end := start + 1
+		for end < len(pages) && pages[end].pageID == pages[end-1].pageID+1 {
+			end++
+		}
+		run := make([]byte, rawSize*(end-start))
+		for i, page := range pages[start:end] {
+			raw := run[i*rawSize : (i+1)*rawSize]
+			if dm.compression != CompressionNone {
+				dm.compression.compressPage(raw, page.data)
+			} else if dm.keyring == nil {
+				copy(raw, page.data)
+			} else if err := dm.keyring.encryptPage(page.pageID, raw, page.data); err != nil {
+				return err
+			}
+		}
+		if _, err := dm.heapFile.Seek(int64(rawSize)*int64(pages[start].pageID-dm.base), io.SeekStart); err != nil {
+			return err
+		}
+		if _, err := dm.heapFile.Write(run); err != nil {
+			return err
+		}
+		start = end
+	}
+	return nil
+}
@@ -0,0 +1,81 @@
+package disk
+
+import (
+	"bytes"
+	"os"
+	"testing"
+)
+
+func TestSequentialWriter(t *testing.T) {
+	open := func(t *testing.T, keyring *Keyring) *DiskManager {
+		tmpfile, err := os.CreateTemp("", "test_seqwriter_*.db")
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { os.Remove(tmpfile.Name()) })
+		var dm *DiskManager
+		if keyring == nil {
+			dm, err = NewDiskManager(tmpfile)
+		} else {
+			dm, err = NewEncryptedDiskManager(tmpfile, keyring)
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { dm.Close() })
+		return dm
+	}
+
+	check := func(t *testing.T, dm *DiskManager) {
+		sw := NewSequentialWriter(dm, 2)
+		contents := map[PageID][]byte{}
+		for i := 0; i < 5; i++ {
+			data := bytes.Repeat([]byte{byte('a' + i)}, 100)
+			pageID, err := sw.WritePage(data)
+			if err != nil {
+				t.Fatal(err)
+			}
+			contents[pageID] = data
+		}
+		// Pages allocated up front and written out of order
+		first, second := dm.AllocatePage(), dm.AllocatePage()
+		if err := sw.WritePageAt(second, []byte("second")); err != nil {
+			t.Fatal(err)
+		}
+		if err := sw.WritePageAt(first, []byte("first")); err != nil {
+			t.Fatal(err)
+		}
+		contents[first] = []byte("first")
+		contents[second] = []byte("second")
+		if err := sw.Close(); err != nil {
+			t.Fatal(err)
+		}
+
+		for pageID, data := range contents {
+			page := make([]byte, PageSize)
+			if err := dm.ReadPageData(pageID, page); err != nil {
+				t.Fatal(err)
+			}
+			expected := make([]byte, PageSize)
+			copy(expected, data)
+			if !bytes.Equal(page, expected) {
+				t.Errorf("page %d: unexpected content %q", pageID, page[:10])
+			}
+		}
+		if _, err := sw.WritePage(nil); err != ErrWriterClosed {
+			t.Errorf("expected ErrWriterClosed, got %v", err)
+		}
+	}
+
+	t.Run("Plain", func(t *testing.T) {
+		check(t, open(t, nil))
+	})
+
+	t.Run("Encrypted", func(t *testing.T) {
+		keyring := NewKeyring()
+		if err := keyring.AddKey("", 1, bytes.Repeat([]byte{1}, 16)); err != nil {
+			t.Fatal(err)
+		}
+		check(t, open(t, keyring))
+	})
+}
@@ -0,0 +1,280 @@
+package disk
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/Johniel/gorelly/errcode"
+)
+
+var (
+	// ErrInvalidTablespace is returned when opening a tablespace whose heap files do not form
+	// consecutive page ranges.
+	ErrInvalidTablespace = errcode.New(errcode.Corruption, "heap files of the tablespace overlap or do not start at page 0")
+)
+
+// DefaultFilePages is the number of pages OpenTablespace puts in a heap file before starting
+// the next one (1 GiB per file).
+const DefaultFilePages = 1 << 18
+
+// tablespaceFileExt is the extension of the heap files of a tablespace.
+const tablespaceFileExt = ".rly"
+
+// Tablespace stores the pages of a database in a directory of heap files, so that a large
+// database can span several files, and devices by linking its files to other file systems.
+// Each heap file holds a range of consecutive page IDs and is named after the first of them,
+// in 16 hexadecimal digits (e.g. "0000000000040000.rly"). Pages are allocated at the end of the
+// last file until it holds filePages pages, then a new file is started.
+//
+// Each heap file is managed by a DiskManager, which keeps its own free list; pages freed in any
+// file are reused before new ones are allocated. Like a DiskManager, a Tablespace is safe for
+// concurrent use.
+type Tablespace struct {
+	dir       string
+	filePages uint64
+	files     []*DiskManager // Sorted by first page ID
+	frozen    bool           // Whether page writes are blocked (see Freeze)
+	mu        sync.RWMutex   // Protects files and frozen
+}
+
+// OpenTablespace opens the tablespace in dir with DefaultFilePages pages per heap file,
+// creating dir and the first heap file if needed.
+func OpenTablespace(dir string) (*Tablespace, error) {
+	return OpenTablespaceWithFilePages(dir, DefaultFilePages)
+}
+
+// OpenTablespaceWithFilePages is OpenTablespace with filePages pages per new heap file.
+// The ranges of existing files are given by their names and are kept as they are.
+func OpenTablespaceWithFilePages(dir string, filePages uint64) (*Tablespace, error) {
+	if filePages == 0 {
+		filePages = DefaultFilePages
+	}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	var bases []PageID
+	for _, entry := range entries {
+		name, ok := strings.CutSuffix(entry.Name(), tablespaceFileExt)
+		if !ok || len(name) != 16 {
+			continue
+		}
+		base, err := strconv.ParseUint(name, 16, 64)
+		if err != nil {
+			continue
+		}
+		bases = append(bases, PageID(base))
+	}
+	sort.Slice(bases, func(i, j int) bool { return bases[i] < bases[j] })
+
+	ts := &Tablespace{dir: dir, filePages: filePages}
+	if len(bases) == 0 {
+		bases = append(bases, 0)
+	}
+	for i, base := range bases {
+		dm, err := ts.openFile(base)
+		if err != nil {
+			ts.Close()
+			return nil, err
+		}
+		ts.files = append(ts.files, dm)
+		if (i == 0 && base != 0) || (0 < i && base < ts.files[i-1].end()) {
+			ts.Close()
+			return nil, ErrInvalidTablespace
+		}
+	}
+	return ts, nil
+}
+
+func (ts *Tablespace) openFile(base PageID) (*DiskManager, error) {
+	path := filepath.Join(ts.dir, fmt.Sprintf("%016x%s", uint64(base), tablespaceFileExt))
+	heapFile, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return nil, err
+	}
+	dm, err := newDiskManagerAt(heapFile, nil, CompressionNone, base)
+	if err != nil {
+		heapFile.Close()
+		return nil, err
+	}
+	return dm, nil
+}
+
+// end returns the ID after the last page allocated in the heap file.
+func (dm *DiskManager) end() PageID {
+	return dm.base + dm.NumPages()
+}
+
+// fileOf returns the heap file holding a page.
+func (ts *Tablespace) fileOf(pageID PageID) *DiskManager {
+	ts.mu.RLock()
+	defer ts.mu.RUnlock()
+	i := sort.Search(len(ts.files), func(i int) bool { return pageID < ts.files[i].base })
+	return ts.files[max(i-1, 0)]
+}
+
+func (ts *Tablespace) ReadPageData(pageID PageID, data []byte) error {
+	return ts.fileOf(pageID).ReadPageData(pageID, data)
+}
+
+func (ts *Tablespace) WritePageData(pageID PageID, data []byte) error {
+	return ts.fileOf(pageID).WritePageData(pageID, data)
+}
+
+// writeStaged writes pages, sorted by page ID, to their heap files.
+func (ts *Tablespace) writeStaged(pages []stagedPage) error {
+	for start := 0; start < len(pages); {
+		dm := ts.fileOf(pages[start].pageID)
+		end := start + 1
+		for end < len(pages) && ts.fileOf(pages[end].pageID) == dm {
+			end++
+		}
+		if err := dm.writeStaged(pages[start:end]); err != nil {
+			return err
+		}
+		start = end
+	}
+	return nil
+}
+
+// AllocatePage returns a page released by FreePage if there is one, and a new page at the end of
+// the last heap file otherwise, which is a new heap file if the last one is full. It panics if
+// the new heap file cannot be created, as the allocation cannot fail otherwise.
+func (ts *Tablespace) AllocatePage() PageID {
+	ts.mu.Lock()
+	defer ts.mu.Unlock()
+	for _, dm := range ts.files {
+		if pageID, ok := dm.allocateFree(); ok {
+			return pageID
+		}
+	}
+	last := ts.files[len(ts.files)-1]
+	if uint64(last.NumPages()) < ts.filePages {
+		return last.AllocatePage()
+	}
+	dm, err := ts.openFile(last.end())
+	if err != nil {
+		panic(fmt.Sprintf("disk: cannot create heap file of tablespace %s: %v", ts.dir, err))
+	}
+	// An empty file has nothing to sync for the freeze
+	dm.frozen = ts.frozen
+	ts.files = append(ts.files, dm)
+	return dm.AllocatePage()
+}
+
+// ClaimPage marks a page as allocated (see DiskManager.ClaimPage), starting the heap files up to
+// the one holding it as AllocatePage would.
+func (ts *Tablespace) ClaimPage(pageID PageID) error {
+	ts.mu.Lock()
+	defer ts.mu.Unlock()
+	for {
+		last := ts.files[len(ts.files)-1]
+		if pageID < last.base+PageID(max(ts.filePages, uint64(last.NumPages()))) {
+			break
+		}
+		if uint64(last.NumPages()) < ts.filePages {
+			last.ClaimPage(last.base + PageID(ts.filePages) - 1)
+			last.FreePage(last.base + PageID(ts.filePages) - 1)
+		}
+		dm, err := ts.openFile(last.end())
+		if err != nil {
+			return err
+		}
+		dm.frozen = ts.frozen
+		ts.files = append(ts.files, dm)
+	}
+	i := sort.Search(len(ts.files), func(i int) bool { return pageID < ts.files[i].base })
+	return ts.files[max(i-1, 0)].ClaimPage(pageID)
+}
+
+// FreePage releases a page that is no longer referenced so that AllocatePage can reuse it.
+func (ts *Tablespace) FreePage(pageID PageID) {
+	ts.fileOf(pageID).FreePage(pageID)
+}
+
+// NumFreePages returns the number of freed pages waiting to be reused.
+func (ts *Tablespace) NumFreePages() int {
+	ts.mu.RLock()
+	defer ts.mu.RUnlock()
+	n := 0
+	for _, dm := range ts.files {
+		n += dm.NumFreePages()
+	}
+	return n
+}
+
+// NumPages returns the ID after the last allocated page.
+func (ts *Tablespace) NumPages() PageID {
+	ts.mu.RLock()
+	defer ts.mu.RUnlock()
+	return ts.files[len(ts.files)-1].end()
+}
+
+// NumFiles returns the number of heap files of the tablespace.
+func (ts *Tablespace) NumFiles() int {
+	ts.mu.RLock()
+	defer ts.mu.RUnlock()
+	return len(ts.files)
+}
+
+func (ts *Tablespace) Sync() error {
+	ts.mu.RLock()
+	defer ts.mu.RUnlock()
+	for _, dm := range ts.files {
+		if err := dm.Sync(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Freeze freezes every heap file (see DiskManager.Freeze). Heap files created while frozen
+// are frozen as well.
+func (ts *Tablespace) Freeze() error {
+	ts.mu.Lock()
+	defer ts.mu.Unlock()
+	if ts.frozen {
+		return ErrAlreadyFrozen
+	}
+	for i, dm := range ts.files {
+		if err := dm.Freeze(); err != nil {
+			for _, frozen := range ts.files[:i] {
+				frozen.Thaw()
+			}
+			return err
+		}
+	}
+	ts.frozen = true
+	return nil
+}
+
+// Thaw lifts a freeze of every heap file.
+func (ts *Tablespace) Thaw() {
+	ts.mu.Lock()
+	defer ts.mu.Unlock()
+	ts.frozen = false
+	for _, dm := range ts.files {
+		dm.Thaw()
+	}
+}
+
+// Close closes every heap file, saving its free list (see DiskManager.Close).
+func (ts *Tablespace) Close() error {
+	ts.mu.Lock()
+	defer ts.mu.Unlock()
+	var firstErr error
+	for _, dm := range ts.files {
+		if err := dm.Close(); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	return firstErr
+}
@@ -0,0 +1,140 @@
+package disk
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestTablespace(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "tablespace")
+	ts, err := OpenTablespaceWithFilePages(dir, 4)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var _ PageStore = ts
+
+	contents := map[PageID][]byte{}
+	for i := 0; i < 6; i++ {
+		pageID := ts.AllocatePage()
+		if pageID != PageID(i) {
+			t.Fatalf("expected page %d, got %d", i, pageID)
+		}
+		data := bytes.Repeat([]byte{byte('a' + i)}, PageSize)
+		if err := ts.WritePageData(pageID, data); err != nil {
+			t.Fatal(err)
+		}
+		contents[pageID] = data
+	}
+	// Pages written with a SequentialWriter may span two heap files
+	sw := NewSequentialWriter(ts, 8)
+	for i := 6; i < 10; i++ {
+		data := bytes.Repeat([]byte{byte('a' + i)}, PageSize)
+		pageID, err := sw.WritePage(data)
+		if err != nil {
+			t.Fatal(err)
+		}
+		contents[pageID] = data
+	}
+	if err := sw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if ts.NumFiles() != 3 || ts.NumPages() != 10 {
+		t.Errorf("expected 10 pages in 3 files, got %d in %d", ts.NumPages(), ts.NumFiles())
+	}
+	for _, name := range []string{"0000000000000000.rly", "0000000000000004.rly", "0000000000000008.rly"} {
+		if _, err := os.Stat(filepath.Join(dir, name)); err != nil {
+			t.Error(err)
+		}
+	}
+
+	ts.FreePage(5)
+	if err := ts.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// Reopened with another file size, the existing files keep their ranges
+	ts, err = OpenTablespaceWithFilePages(dir, 8)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ts.Close()
+	for pageID, data := range contents {
+		page := make([]byte, PageSize)
+		if err := ts.ReadPageData(pageID, page); err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(page, data) {
+			t.Errorf("page %d: unexpected content %q", pageID, page[:1])
+		}
+	}
+	if ts.NumFreePages() != 1 {
+		t.Errorf("expected the free list to be kept, got %d free pages", ts.NumFreePages())
+	}
+	if pageID := ts.AllocatePage(); pageID != 5 {
+		t.Errorf("expected the freed page to be reused, got %d", pageID)
+	}
+	for i := 10; i < 16; i++ {
+		if pageID := ts.AllocatePage(); pageID != PageID(i) {
+			t.Errorf("expected page %d, got %d", i, pageID)
+		}
+	}
+	if ts.NumFiles() != 3 {
+		t.Errorf("expected the last file to grow to 8 pages, got %d files", ts.NumFiles())
+	}
+
+	// New heap files are frozen with the others
+	if err := ts.Freeze(); err != nil {
+		t.Fatal(err)
+	}
+	if err := ts.Freeze(); err != ErrAlreadyFrozen {
+		t.Errorf("expected ErrAlreadyFrozen, got %v", err)
+	}
+	pageID := ts.AllocatePage()
+	if ts.NumFiles() != 4 {
+		t.Errorf("expected a fourth file, got %d files", ts.NumFiles())
+	}
+	written := make(chan error)
+	go func() { written <- ts.WritePageData(pageID, make([]byte, PageSize)) }()
+	select {
+	case err := <-written:
+		t.Errorf("expected the write to wait for Thaw, got %v", err)
+	default:
+	}
+	ts.Thaw()
+	if err := <-written; err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestTablespaceInvalid(t *testing.T) {
+	dir := t.TempDir()
+	ts, err := OpenTablespaceWithFilePages(dir, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 4; i++ {
+		if err := ts.WritePageData(ts.AllocatePage(), make([]byte, PageSize)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := ts.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// A heap file overlapping the range of the previous one
+	if err := os.Rename(filepath.Join(dir, "0000000000000002.rly"), filepath.Join(dir, "0000000000000001.rly")); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := OpenTablespace(dir); err != ErrInvalidTablespace {
+		t.Errorf("expected ErrInvalidTablespace, got %v", err)
+	}
+	// No heap file for the first pages
+	if err := os.Remove(filepath.Join(dir, "0000000000000000.rly")); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := OpenTablespace(dir); err != ErrInvalidTablespace {
+		t.Errorf("expected ErrInvalidTablespace, got %v", err)
+	}
+}
@@ -0,0 +1,108 @@
+// Package errcode defines stable, SQLSTATE-like codes for the errors returned by the database,
+// so that callers (drivers, servers, retry helpers) can branch on a code instead of matching messages.
+// Every sentinel error of the other packages is created with New and carries a code;
+// Of extracts it from any error, including wrapped ones.
+package errcode
+
+import "errors"
+
+// Code is a five-character error code modelled on SQLSTATE. The first two characters are the class.
+type Code string
+
+const (
+	// OK is the code of a nil error.
+	OK Code = "00000"
+	// FeatureNotSupported means the statement uses a feature the database does not implement.
+	FeatureNotSupported Code = "0A000"
+	// DataException covers malformed values and encodings.
+	DataException Code = "22000"
+	// InvalidParameter covers invalid arguments and settings.
+	InvalidParameter Code = "22023"
+	// ConstraintViolation covers integrity constraint violations such as duplicate keys.
+	ConstraintViolation Code = "23000"
+	// InvalidTransactionState covers operations on transactions in the wrong state.
+	InvalidTransactionState Code = "25000"
+	// InvalidAuthorization means a session failed to authenticate as a role.
+	InvalidAuthorization Code = "28000"
+	// SerializationFailure means the transaction conflicted with another and may be retried.
+	SerializationFailure Code = "40001"
+	// Deadlock means the transaction was chosen as a deadlock victim and may be retried.
+	Deadlock Code = "40P01"
+	// PermissionDenied means the role lacks a required privilege.
+	PermissionDenied Code = "42501"
+	// SyntaxError means a statement could not be parsed.
+	SyntaxError Code = "42601"
+	// NotFound means the referenced object (key, table, role, ...) does not exist.
+	NotFound Code = "42704"
+	// AlreadyExists means an object with the same name or ID already exists.
+	AlreadyExists Code = "42710"
+	// ResourceExhausted means a resource such as the buffer pool ran out.
+	ResourceExhausted Code = "53000"
+	// LimitExceeded means a value exceeds a size or count limit of the storage format.
+	LimitExceeded Code = "54000"
+	// ObjectNotInPrerequisiteState covers operations not allowed in the object's current state.
+	ObjectNotInPrerequisiteState Code = "55000"
+	// LockNotAvailable means a lock could not be acquired in time.
+	LockNotAvailable Code = "55P03"
+	// Internal is the code of errors that carry no code, e.g. I/O errors from the OS.
+	Internal Code = "XX000"
+	// Corruption means stored data failed validation.
+	Corruption Code = "XX001"
+)
+
+// Class returns the two-character class of the code.
+func (c Code) Class() string {
+	return string(c[:2])
+}
+
+// Retryable reports whether a transaction that failed with this code can be retried as a whole.
+func (c Code) Retryable() bool {
+	return c == SerializationFailure || c == Deadlock
+}
+
+// Error is an error carrying a code.
+type Error struct {
+	code Code
+	msg  string
+	err  error // Wrapped error (nil for sentinels)
+}
+
+// New returns a new error with the given code and message.
+// Like errors.New, each call returns a distinct error.
+func New(code Code, msg string) error {
+	return &Error{code: code, msg: msg}
+}
+
+// Wrap attaches a code to err. It returns nil if err is nil.
+func Wrap(code Code, err error) error {
+	if err == nil {
+		return nil
+	}
+	return &Error{code: code, msg: err.Error(), err: err}
+}
+
+func (e *Error) Error() string {
+	return e.msg
+}
+
+func (e *Error) Unwrap() error {
+	return e.err
+}
+
+// Code returns the error's code.
+func (e *Error) Code() Code {
+	return e.code
+}
+
+// Of returns the code of err: OK for nil, the code of the first coded error in err's chain,
+// or Internal if there is none.
+func Of(err error) Code {
+	if err == nil {
+		return OK
+	}
+	var coded *Error
+	if errors.As(err, &coded) {
+		return coded.code
+	}
+	return Internal
+}
@@ -0,0 +1,57 @@
+package errcode
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"testing"
+)
+
+func TestOf(t *testing.T) {
+	errMissing := New(NotFound, "missing")
+	errDeadlock := New(Deadlock, "deadlock")
+
+	tests := []struct {
+		name string
+		err  error
+		code Code
+	}{
+		{"Nil", nil, OK},
+		{"Sentinel", errMissing, NotFound},
+		{"Wrapped", fmt.Errorf("loading table: %w", errMissing), NotFound},
+		{"Joined", errors.Join(io.EOF, errDeadlock), Deadlock},
+		{"Uncoded", io.EOF, Internal},
+		{"Wrap", Wrap(Corruption, io.ErrUnexpectedEOF), Corruption},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if code := Of(tt.err); code != tt.code {
+				t.Errorf("expected %s, got %s", tt.code, code)
+			}
+		})
+	}
+
+	if New(NotFound, "missing") == errMissing {
+		t.Error("expected New to return distinct errors")
+	}
+	if !errors.Is(Wrap(Corruption, io.ErrUnexpectedEOF), io.ErrUnexpectedEOF) {
+		t.Error("expected Wrap to keep the wrapped error")
+	}
+	if Wrap(Corruption, nil) != nil {
+		t.Error("expected wrapping nil to return nil")
+	}
+}
+
+func TestCode(t *testing.T) {
+	if ConstraintViolation.Class() != "23" {
+		t.Errorf("expected class 23, got %s", ConstraintViolation.Class())
+	}
+	for _, code := range []Code{SerializationFailure, Deadlock} {
+		if !code.Retryable() {
+			t.Errorf("expected %s to be retryable", code)
+		}
+	}
+	if NotFound.Retryable() {
+		t.Error("expected NotFound not to be retryable")
+	}
+}
@@ -1,6 +1,8 @@
-// Package internal provides example code demonstrating how to use the relly database.
-// This file contains comprehensive examples for common database operations.
-package internal
+// Package gorelly is the entry point of the relly database: Open assembles a database from
+// its heap file and write-ahead log (see DB).
+//
+// This file contains examples demonstrating how to use the components of the database directly.
+package gorelly
 
 import (
 	"fmt"
@@ -13,6 +15,7 @@ import (
 	"github.com/Johniel/gorelly/table"
 	"github.com/Johniel/gorelly/transaction"
 	"github.com/Johniel/gorelly/tuple"
+	"github.com/Johniel/gorelly/types"
 )
 
 // ExampleBasicTableCreation demonstrates how to create a simple table and insert tuples.
@@ -311,6 +314,7 @@ func ExampleBTreeDirectUsage() {
 		fmt.Printf("Error searching B+ tree: %v\n", err)
 		return
 	}
+	defer iter.Close()
 
 	fmt.Println("B+ tree contents:")
 	for {
@@ -754,12 +758,21 @@ func ExampleCompleteWorkflow() {
 	fmt.Printf("Table created with meta page ID: %d\n", simpleTable.MetaPageID)
 
 	// 3. データを挿入
+	// 年齢は数値として比較できるよう INT の順序保存エンコーディングで格納する
+	// （文字列のままだと "9" >= "30" のように辞書順で比較されてしまう）
+	people := types.NewSchema(
+		types.Column{Name: "id", Kind: types.KindVarchar},
+		types.Column{Name: "first", Kind: types.KindVarchar},
+		types.Column{Name: "last", Kind: types.KindVarchar},
+		types.Column{Name: "age", Kind: types.KindInt64},
+	)
 	tuples := [][][]byte{
-		{[]byte("1"), []byte("Alice"), []byte("Smith"), []byte("25")},
-		{[]byte("2"), []byte("Bob"), []byte("Johnson"), []byte("30")},
-		{[]byte("3"), []byte("Charlie"), []byte("Williams"), []byte("35")},
-		{[]byte("4"), []byte("Dave"), []byte("Miller"), []byte("28")},
-		{[]byte("5"), []byte("Eve"), []byte("Brown"), []byte("32")},
+		{[]byte("1"), []byte("Alice"), []byte("Smith"), types.EncodeInt(25)},
+		{[]byte("2"), []byte("Bob"), []byte("Johnson"), types.EncodeInt(30)},
+		{[]byte("3"), []byte("Charlie"), []byte("Williams"), types.EncodeInt(35)},
+		{[]byte("4"), []byte("Dave"), []byte("Miller"), types.EncodeInt(28)},
+		{[]byte("5"), []byte("Eve"), []byte("Brown"), types.EncodeInt(32)},
+		{[]byte("6"), []byte("Frank"), []byte("Davis"), types.EncodeInt(9)},
 	}
 
 	for _, tup := range tuples {
@@ -800,8 +813,13 @@ func ExampleCompleteWorkflow() {
 		if !ok {
 			break
 		}
-		fmt.Printf("ID: %s, First: %s, Last: %s, Age: %s\n",
-			string(tuple[0]), string(tuple[1]), string(tuple[2]), string(tuple[3]))
+		row, err := people.Decode(tuple)
+		if err != nil {
+			fmt.Printf("Error decoding tuple: %v\n", err)
+			return
+		}
+		fmt.Printf("ID: %s, First: %s, Last: %s, Age: %d\n",
+			row.Get("id"), row.Get("first"), row.Get("last"), row.Get("age").Int64())
 	}
 
 	// 6. フィルタリング（年齢が30以上のレコード）
@@ -814,13 +832,7 @@ func ExampleCompleteWorkflow() {
 				return true
 			},
 		},
-		Cond: func(tup [][]byte) bool {
-			if len(tup) > 3 {
-				age := string(tup[3])
-				return age >= "30"
-			}
-			return false
-		},
+		Cond: query.CompareValue(people.ColumnIndex("age"), query.CompareGe, types.Int64(30)),
 	}
 
 	executor, err = filter.Start(bufmgr)
@@ -838,8 +850,13 @@ func ExampleCompleteWorkflow() {
 		if !ok {
 			break
 		}
-		fmt.Printf("ID: %s, First: %s, Last: %s, Age: %s\n",
-			string(tuple[0]), string(tuple[1]), string(tuple[2]), string(tuple[3]))
+		row, err := people.Decode(tuple)
+		if err != nil {
+			fmt.Printf("Error decoding tuple: %v\n", err)
+			return
+		}
+		fmt.Printf("ID: %s, First: %s, Last: %s, Age: %d\n",
+			row.Get("id"), row.Get("first"), row.Get("last"), row.Get("age").Int64())
 	}
 
 	fmt.Println("\nComplete workflow finished successfully")
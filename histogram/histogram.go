@@ -0,0 +1,119 @@
+// Package histogram provides latency histograms in the style of HdrHistogram.
+// Durations are counted in buckets whose width grows with the magnitude of the value,
+// so that every recorded duration is known to within a fixed relative error
+// while the histogram keeps a small, fixed amount of memory.
+package histogram
+
+import (
+	"math/bits"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	// subBucketBits is the number of bits of precision kept for each power of two.
+	// With 16 sub-buckets, a bucket is at most 1/16 (6.25%) as wide as its lower bound.
+	subBucketBits  = 4
+	subBucketCount = 1 << subBucketBits
+	// numBuckets covers every non-negative int64 nanosecond count.
+	numBuckets = (64 - subBucketBits) * subBucketCount
+)
+
+// Histogram records durations. The zero value is an empty histogram ready to use,
+// and all methods are safe for concurrent use.
+type Histogram struct {
+	counts [numBuckets]atomic.Uint64
+	total  atomic.Uint64
+	sum    atomic.Uint64 // Sum of the recorded durations in nanoseconds
+	max    atomic.Uint64 // Largest recorded duration in nanoseconds
+}
+
+// Record adds a duration to the histogram. Negative durations are recorded as zero.
+func (h *Histogram) Record(d time.Duration) {
+	v := uint64(max(d, 0))
+	h.counts[bucketIndex(v)].Add(1)
+	h.total.Add(1)
+	h.sum.Add(v)
+	for {
+		old := h.max.Load()
+		if v <= old || h.max.CompareAndSwap(old, v) {
+			return
+		}
+	}
+}
+
+// Since records the time elapsed since start, e.g. in a defer.
+func (h *Histogram) Since(start time.Time) {
+	h.Record(time.Since(start))
+}
+
+// Snapshot returns a copy of the current contents of the histogram.
+// Durations recorded while the snapshot is taken may be partially included.
+func (h *Histogram) Snapshot() Snapshot {
+	s := Snapshot{
+		Count:  h.total.Load(),
+		Sum:    time.Duration(h.sum.Load()),
+		Max:    time.Duration(h.max.Load()),
+		counts: make([]uint64, numBuckets),
+	}
+	for i := range h.counts {
+		s.counts[i] = h.counts[i].Load()
+	}
+	return s
+}
+
+// Snapshot is a point-in-time copy of a Histogram.
+type Snapshot struct {
+	Count  uint64        // Number of recorded durations
+	Sum    time.Duration // Sum of the recorded durations
+	Max    time.Duration // Largest recorded duration
+	counts []uint64
+}
+
+// Mean returns the average recorded duration, or 0 if nothing was recorded.
+func (s Snapshot) Mean() time.Duration {
+	if s.Count == 0 {
+		return 0
+	}
+	return s.Sum / time.Duration(s.Count)
+}
+
+// Quantile returns an upper bound of the q-quantile (0 <= q <= 1) of the recorded durations,
+// e.g. Quantile(0.99) for the 99th percentile. The bound exceeds the true value by at most
+// the width of its bucket and never exceeds Max. It returns 0 if nothing was recorded.
+func (s Snapshot) Quantile(q float64) time.Duration {
+	if s.Count == 0 {
+		return 0
+	}
+	rank := uint64(q * float64(s.Count))
+	rank = min(max(rank, 1), s.Count)
+	var seen uint64
+	for i, count := range s.counts {
+		seen += count
+		if rank <= seen {
+			return min(time.Duration(bucketUpperBound(i)), s.Max)
+		}
+	}
+	return s.Max
+}
+
+// bucketIndex returns the bucket counting the value v.
+// Values below 2*subBucketCount have a bucket each; above that, every power of two
+// is split into subBucketCount buckets of equal width.
+func bucketIndex(v uint64) int {
+	if v < 2*subBucketCount {
+		return int(v)
+	}
+	shift := bits.Len64(v) - subBucketBits - 1
+	return (shift+1)*subBucketCount + int(v>>shift) - subBucketCount
+}
+
+// bucketUpperBound returns the largest value counted in bucket i.
+func bucketUpperBound(i int) uint64 {
+	if i < 2*subBucketCount {
+		return uint64(i)
+	}
+	shift := i/subBucketCount - 1
+	sub := uint64(i%subBucketCount + subBucketCount)
+	return (sub+1)<<shift - 1
+}
@@ -0,0 +1,78 @@
+package histogram
+
+import (
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestBucketIndex(t *testing.T) {
+	// Every value falls in a bucket whose bounds contain it, and buckets are contiguous
+	prev := -1
+	for _, v := range []uint64{0, 1, 31, 32, 33, 34, 1000, 1 << 20, 1<<40 + 12345, 1<<63 - 1} {
+		i := bucketIndex(v)
+		if i < prev || numBuckets <= i {
+			t.Fatalf("value %d: bucket %d out of order or range", v, i)
+		}
+		prev = i
+		if upper := bucketUpperBound(i); upper < v {
+			t.Errorf("value %d: bucket %d ends at %d", v, i, upper)
+		}
+		if 0 < i && v <= bucketUpperBound(i-1) {
+			t.Errorf("value %d: also within bucket %d", v, i-1)
+		}
+		if upper := bucketUpperBound(i); 2*subBucketCount <= v && float64(upper-v) > float64(v)/subBucketCount {
+			t.Errorf("value %d: bucket %d ending at %d is too wide", v, i, upper)
+		}
+	}
+}
+
+func TestHistogramQuantile(t *testing.T) {
+	var h Histogram
+	if q := h.Snapshot().Quantile(0.99); q != 0 {
+		t.Errorf("expected 0 for an empty histogram, got %v", q)
+	}
+
+	// 990 fast and 10 slow operations: the median hides the slow ones, the tail does not
+	for i := 0; i < 990; i++ {
+		h.Record(100 * time.Microsecond)
+	}
+	for i := 0; i < 10; i++ {
+		h.Record(50 * time.Millisecond)
+	}
+	s := h.Snapshot()
+	if s.Count != 1000 || s.Max != 50*time.Millisecond {
+		t.Errorf("expected 1000 durations up to 50ms, got %d up to %v", s.Count, s.Max)
+	}
+	within := func(got time.Duration, want time.Duration) bool {
+		return want <= got && got <= want+want/subBucketCount
+	}
+	if p50 := s.Quantile(0.5); !within(p50, 100*time.Microsecond) {
+		t.Errorf("expected p50 near 100us, got %v", p50)
+	}
+	if p999 := s.Quantile(0.999); p999 != 50*time.Millisecond {
+		t.Errorf("expected p99.9 of 50ms, got %v", p999)
+	}
+	if mean := s.Mean(); mean != (990*100*time.Microsecond+10*50*time.Millisecond)/1000 {
+		t.Errorf("unexpected mean %v", mean)
+	}
+}
+
+func TestHistogramConcurrentRecord(t *testing.T) {
+	var h Histogram
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			for j := 0; j < 1000; j++ {
+				h.Record(time.Duration(i*1000 + j))
+			}
+		}(i)
+	}
+	wg.Wait()
+	s := h.Snapshot()
+	if s.Count != 8000 || s.Max != 7999 {
+		t.Errorf("expected 8000 durations up to 7999ns, got %d up to %v", s.Count, s.Max)
+	}
+}
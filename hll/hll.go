@@ -0,0 +1,119 @@
+// Package hll provides HyperLogLog sketches for approximate distinct counting.
+// A sketch estimates the number of distinct values it has seen using a fixed
+// amount of memory, regardless of how many values are added.
+package hll
+
+import (
+	"hash/fnv"
+	"math"
+	"math/bits"
+
+	"github.com/Johniel/gorelly/errcode"
+)
+
+const (
+	// MinPrecision is the smallest supported precision (16 registers).
+	MinPrecision = 4
+	// MaxPrecision is the largest supported precision (65536 registers).
+	MaxPrecision = 16
+	// DefaultPrecision uses 16384 registers, giving a standard error of about 0.8%.
+	DefaultPrecision = 14
+)
+
+var (
+	// ErrPrecisionMismatch is returned when merging sketches with different precisions.
+	ErrPrecisionMismatch = errcode.New(errcode.InvalidParameter, "sketch precision mismatch")
+)
+
+// Sketch is a HyperLogLog sketch.
+// Each value is hashed; the first precision bits of the hash select a register,
+// and the register keeps the maximum number of leading zeros seen in the remaining bits.
+type Sketch struct {
+	precision uint8
+	registers []uint8
+}
+
+func NewSketch(precision uint8) *Sketch {
+	if precision < MinPrecision || MaxPrecision < precision {
+		panic("hll precision out of range")
+	}
+	return &Sketch{
+		precision: precision,
+		registers: make([]uint8, 1<<precision),
+	}
+}
+
+func (s *Sketch) Precision() uint8 {
+	return s.precision
+}
+
+// Add records a value in the sketch.
+func (s *Sketch) Add(value []byte) {
+	hash := hash64(value)
+	index := hash >> (64 - s.precision)
+	// The sentinel bit bounds the rank when the remaining bits are all zero.
+	rest := hash<<s.precision | 1<<(s.precision-1)
+	rank := uint8(bits.LeadingZeros64(rest)) + 1
+	if s.registers[index] < rank {
+		s.registers[index] = rank
+	}
+}
+
+// Merge folds other into s, so that s estimates the distinct count of the union of both inputs.
+func (s *Sketch) Merge(other *Sketch) error {
+	if s.precision != other.precision {
+		return ErrPrecisionMismatch
+	}
+	for i, rank := range other.registers {
+		if s.registers[i] < rank {
+			s.registers[i] = rank
+		}
+	}
+	return nil
+}
+
+// Estimate returns the estimated number of distinct values added to the sketch.
+func (s *Sketch) Estimate() uint64 {
+	m := float64(len(s.registers))
+	sum := 0.0
+	zeros := 0
+	for _, rank := range s.registers {
+		sum += math.Ldexp(1, -int(rank))
+		if rank == 0 {
+			zeros++
+		}
+	}
+	estimate := alpha(len(s.registers)) * m * m / sum
+	// Small range correction: fall back to linear counting while registers are still empty.
+	if estimate <= 2.5*m && 0 < zeros {
+		estimate = m * math.Log(m/float64(zeros))
+	}
+	return uint64(estimate + 0.5)
+}
+
+func alpha(m int) float64 {
+	switch m {
+	case 16:
+		return 0.673
+	case 32:
+		return 0.697
+	case 64:
+		return 0.709
+	default:
+		return 0.7213 / (1 + 1.079/float64(m))
+	}
+}
+
+// hash64 hashes value with FNV-1a and mixes the result with the MurmurHash3 finalizer,
+// since HyperLogLog relies on every output bit being well distributed.
+func hash64(value []byte) uint64 {
+	h := fnv.New64a()
+	h.Write(value)
+	x := h.Sum64()
+	x ^= x >> 33
+	x *= 0xff51afd7ed558ccd
+	x ^= x >> 33
+	x *= 0xc4ceb9fe1a85ec53
+	x ^= x >> 33
+	return x
+}
@@ -0,0 +1,49 @@
+package hll
+
+import (
+	"encoding/binary"
+	"testing"
+)
+
+func TestSketchEstimate(t *testing.T) {
+	for _, n := range []int{0, 10, 1000, 100000} {
+		s := NewSketch(DefaultPrecision)
+		for i := 0; i < n; i++ {
+			value := make([]byte, 8)
+			binary.BigEndian.PutUint64(value, uint64(i))
+			// Add every value twice: duplicates must not change the estimate
+			s.Add(value)
+			s.Add(value)
+		}
+		estimate := float64(s.Estimate())
+		if diff := estimate - float64(n); diff < -0.03*float64(n)-1 || 0.03*float64(n)+1 < diff {
+			t.Errorf("n=%d: estimate %v is off by more than 3%%", n, estimate)
+		}
+	}
+}
+
+func TestSketchMerge(t *testing.T) {
+	a := NewSketch(DefaultPrecision)
+	b := NewSketch(DefaultPrecision)
+	for i := 0; i < 20000; i++ {
+		value := make([]byte, 8)
+		binary.BigEndian.PutUint64(value, uint64(i))
+		if i < 15000 {
+			a.Add(value)
+		}
+		if 5000 <= i {
+			b.Add(value)
+		}
+	}
+	if err := a.Merge(b); err != nil {
+		t.Fatal(err)
+	}
+	estimate := float64(a.Estimate())
+	if estimate < 19400 || 20600 < estimate {
+		t.Errorf("expected about 20000 distinct values after merge, got %v", estimate)
+	}
+
+	if err := a.Merge(NewSketch(MinPrecision)); err != ErrPrecisionMismatch {
+		t.Errorf("expected ErrPrecisionMismatch, got %v", err)
+	}
+}
@@ -0,0 +1,6 @@
+//go:build !gorellycheck
+
+package ownercheck
+
+// Enabled reports whether the checks are compiled in.
+const Enabled = false
@@ -0,0 +1,63 @@
+// Package ownercheck detects concurrent use of structures that are not safe for it,
+// such as a SequentialWriter or two writers on the same B+ tree.
+// The checks are compiled in only with the gorellycheck build tag, e.g.
+//
+//	go test -tags gorellycheck ./...
+//
+// Without the tag Enabled is false and the checks do nothing. With it, a structure used by
+// two operations at once fails fast with a panic naming both of them, instead of its data
+// being corrupted silently.
+package ownercheck
+
+import (
+	"fmt"
+	"sync"
+	"sync/atomic"
+)
+
+// Owner tracks the operation that has exclusive use of a structure.
+// The zero value is an Owner not in use.
+type Owner struct {
+	op atomic.Pointer[string] // Operation in progress (nil if none)
+}
+
+func release() {}
+
+// Acquire marks op as using the structure and returns the function that ends the use,
+// typically deferred right away:
+//
+//	defer sw.owner.Acquire("SequentialWriter", "Flush")()
+//
+// If another operation is using the structure, Acquire panics. Acquire is not reentrant,
+// so operations that call each other acquire only in their exported entry points.
+func (o *Owner) Acquire(structure string, op string) func() {
+	if !Enabled {
+		return release
+	}
+	if !o.op.CompareAndSwap(nil, &op) {
+		held := "another operation"
+		if current := o.op.Load(); current != nil {
+			held = *current
+		}
+		panic(fmt.Sprintf("ownercheck: concurrent use of %s: %s called while %s is in progress; "+
+			"%s is not safe for concurrent use", structure, op, held, structure))
+	}
+	return func() {
+		o.op.Store(nil)
+	}
+}
+
+// Registry keeps an Owner per key, for structures that have no value of their own
+// to hold one, e.g. a B+ tree identified by its meta page. The zero value is ready to use.
+type Registry struct {
+	owners sync.Map // key -> *Owner
+}
+
+// Acquire is like Owner.Acquire on the Owner of key.
+func (r *Registry) Acquire(key any, structure string, op string) func() {
+	if !Enabled {
+		return release
+	}
+	owner, _ := r.owners.LoadOrStore(key, &Owner{})
+	return owner.(*Owner).Acquire(structure, op)
+}
@@ -0,0 +1,49 @@
+package ownercheck
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestOwnerAcquire(t *testing.T) {
+	var owner Owner
+	done := owner.Acquire("Writer", "Flush")
+
+	defer func() {
+		r := recover()
+		if !Enabled {
+			if r != nil {
+				t.Fatalf("expected no check without the gorellycheck tag, got %v", r)
+			}
+			return
+		}
+		msg, _ := r.(string)
+		if !strings.Contains(msg, "Close called while Flush is in progress") {
+			t.Fatalf("expected a diagnostic naming both operations, got %v", r)
+		}
+		// The owner is still held by Flush until it ends
+		done()
+		owner.Acquire("Writer", "Close")()
+	}()
+	owner.Acquire("Writer", "Close")()
+	done()
+	if Enabled {
+		t.Fatal("expected a panic")
+	}
+}
+
+func TestRegistryAcquire(t *testing.T) {
+	var registry Registry
+	defer registry.Acquire(1, "Tree", "Insert")()
+	// Other keys are independent
+	registry.Acquire(2, "Tree", "Insert")()
+	if !Enabled {
+		return
+	}
+	defer func() {
+		if recover() == nil {
+			t.Fatal("expected a panic")
+		}
+	}()
+	registry.Acquire(1, "Tree", "Delete")
+}
@@ -0,0 +1,395 @@
+package query
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"sort"
+	"sync"
+	"sync/atomic"
+
+	"github.com/Johniel/gorelly/buffer"
+	"github.com/Johniel/gorelly/disk"
+	"github.com/Johniel/gorelly/errcode"
+	"github.com/Johniel/gorelly/tuple"
+	"github.com/Johniel/gorelly/types"
+)
+
+var (
+	// ErrInvalidSumInput is returned when SUM reads a value that is not an 8-byte big-endian integer.
+	ErrInvalidSumInput = errcode.New(errcode.DataException, "SUM input is not an 8-byte integer")
+	// ErrUnsupportedAggregate is returned when starting an aggregation of SUM or AVG over values
+	// of a kind they cannot add up.
+	ErrUnsupportedAggregate = errcode.New(errcode.InvalidParameter, "aggregate not supported for the value kind")
+)
+
+// AggregateKind is an aggregate function.
+type AggregateKind int
+
+const (
+	// AggregateCount counts the rows of the group (COUNT(*)).
+	AggregateCount AggregateKind = iota
+	// AggregateSum adds up a column of 8-byte big-endian signed integers, or of INT or FLOAT values.
+	AggregateSum
+	// AggregateMin returns the bytewise smallest value of a column.
+	AggregateMin
+	// AggregateMax returns the bytewise largest value of a column.
+	AggregateMax
+	// AggregateAvg returns the mean of a column of INT or FLOAT values as a FLOAT.
+	AggregateAvg
+)
+
+// Aggregate is an aggregate function applied to a column (ColumnIndex is ignored by COUNT).
+//
+// ValueKind is the kind of the column's values, encoded as in the types package. With a kind,
+// NULLs (empty values) are skipped as in SQL, and the results are typed values too: COUNT is an
+// INT, SUM of INT or FLOAT values is of the same kind (ErrIntegerOverflow if an INT sum does
+// not fit in 64 bits) and AVG is a FLOAT; SUM and AVG without any value are NULL. MIN and MAX
+// of order-preserving encodings are the smallest and largest values. Without a kind (types.KindNull), COUNT and SUM are 8-byte big-endian integers and AVG
+// is not supported.
+type Aggregate struct {
+	Kind        AggregateKind
+	ColumnIndex int
+	ValueKind   types.Kind
+}
+
+// check returns ErrUnsupportedAggregate if the aggregate cannot be computed over its value kind.
+func (a Aggregate) check() error {
+	numeric := a.ValueKind == types.KindInt64 || a.ValueKind == types.KindFloat64
+	switch {
+	case a.Kind == AggregateSum && !numeric && a.ValueKind != types.KindNull:
+		return ErrUnsupportedAggregate
+	case a.Kind == AggregateAvg && !numeric:
+		return ErrUnsupportedAggregate
+	}
+	return nil
+}
+
+// checkAggregates checks every aggregate of a plan.
+func checkAggregates(aggregates []Aggregate) error {
+	for _, aggregate := range aggregates {
+		if err := aggregate.check(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// HashAggregate groups the rows of InnerPlan by the GroupBy columns (GROUP BY) and computes
+// the aggregates of every group. Each output tuple holds the group columns followed by the
+// aggregate values (see Aggregate for their encodings). Groups are returned in the order of
+// their encoded group key. Without GroupBy columns it computes scalar aggregates: it returns a
+// single group even for empty input, where COUNT is 0 and typed SUM and AVG are NULL.
+type HashAggregate struct {
+	InnerPlan  PlanNode
+	GroupBy    []int
+	Aggregates []Aggregate
+}
+
+func (ha *HashAggregate) Start(bufmgr *buffer.BufferPoolManager) (Executor, error) {
+	if err := checkAggregates(ha.Aggregates); err != nil {
+		return nil, err
+	}
+	innerIter, err := ha.InnerPlan.Start(bufmgr)
+	if err != nil {
+		return nil, err
+	}
+	groups := newAggregateTable(ha.GroupBy, ha.Aggregates)
+	if err := groups.consume(bufmgr, innerIter, nil); err != nil {
+		return nil, closeOnError(bufmgr, innerIter, err)
+	}
+	return &ExecMaterialized{tuples: groups.result()}, nil
+}
+
+// ParallelHashAggregate computes the same result as HashAggregate over the union of Partitions,
+// running one worker per partition. Each worker builds a local hash table of partial aggregates
+// for its partition, and the partial aggregates are merged once all workers are done.
+// The partitions must be disjoint (see PartitionSeqScan) and safe to run concurrently;
+// concurrent scans rely on the buffer pool being large enough that pages being read are not evicted.
+type ParallelHashAggregate struct {
+	Partitions []PlanNode
+	GroupBy    []int
+	Aggregates []Aggregate
+}
+
+func (pha *ParallelHashAggregate) Start(bufmgr *buffer.BufferPoolManager) (Executor, error) {
+	if err := checkAggregates(pha.Aggregates); err != nil {
+		return nil, err
+	}
+	locals := make([]*aggregateTable, len(pha.Partitions))
+	errs := make([]error, len(pha.Partitions))
+	// Set once a worker fails, so that the others stop reading their partitions early
+	var failed atomic.Bool
+	var wg sync.WaitGroup
+	for i, partition := range pha.Partitions {
+		wg.Add(1)
+		go func(i int, partition PlanNode) {
+			defer wg.Done()
+			innerIter, err := partition.Start(bufmgr)
+			if err != nil {
+				errs[i] = err
+				failed.Store(true)
+				return
+			}
+			locals[i] = newAggregateTable(pha.GroupBy, pha.Aggregates)
+			if errs[i] = locals[i].consume(bufmgr, innerIter, &failed); errs[i] != nil {
+				failed.Store(true)
+			}
+			// A worker that failed or was stopped leaves its input positioned mid-partition
+			if failed.Load() {
+				errs[i] = closeOnError(bufmgr, innerIter, errs[i])
+			}
+		}(i, partition)
+	}
+	wg.Wait()
+	if err := errors.Join(errs...); err != nil {
+		return nil, err
+	}
+
+	merged := newAggregateTable(pha.GroupBy, pha.Aggregates)
+	for _, local := range locals {
+		if err := merged.merge(local); err != nil {
+			return nil, err
+		}
+	}
+	return &ExecMaterialized{tuples: merged.result()}, nil
+}
+
+// PartitionSeqScan splits a full scan of a table into len(bounds)+1 scans of disjoint primary
+// key ranges: (-inf, bounds[0]), [bounds[0], bounds[1]), ..., [bounds[n-1], +inf).
+// bounds must be primary key prefixes in ascending order.
+func PartitionSeqScan(tableMetaPageID disk.PageID, bounds []Tuple) []PlanNode {
+	encode := func(key Tuple) []byte {
+		encoded := make([]byte, 0)
+		tuple.Encode(key, &encoded)
+		return encoded
+	}
+	partitions := make([]PlanNode, 0, len(bounds)+1)
+	for i := 0; i <= len(bounds); i++ {
+		searchMode := NewTupleSearchModeStart()
+		if 0 < i {
+			searchMode = NewTupleSearchModeKey(bounds[i-1])
+		}
+		whileCond := func(TupleSlice) bool { return true }
+		if i < len(bounds) {
+			upper := encode(bounds[i])
+			whileCond = func(pkey TupleSlice) bool {
+				return bytes.Compare(encode(pkey), upper) < 0
+			}
+		}
+		partitions = append(partitions, &SeqScan{
+			TableMetaPageID: tableMetaPageID,
+			SearchMode:      searchMode,
+			WhileCond:       whileCond,
+		})
+	}
+	return partitions
+}
+
+// aggregateTable is a hash table from encoded group keys to partial aggregates.
+type aggregateTable struct {
+	groupBy    []int
+	aggregates []Aggregate
+	groups     map[string]*aggregateGroup
+}
+
+type aggregateGroup struct {
+	key    Tuple
+	states []aggregateState
+}
+
+// aggregateState is the partial state of one aggregate. Partial states of the same group
+// computed by different workers combine with merge.
+type aggregateState struct {
+	n     int64   // Row count (COUNT) or sum of integers (SUM)
+	f     float64 // Sum of FLOAT values (SUM), or of every value (AVG)
+	count int64   // Number of values added up (typed SUM, AVG)
+	value []byte  // Current minimum or maximum (MIN, MAX)
+	set   bool    // Whether value holds a value
+}
+
+func newAggregateTable(groupBy []int, aggregates []Aggregate) *aggregateTable {
+	return &aggregateTable{
+		groupBy:    groupBy,
+		aggregates: aggregates,
+		groups:     make(map[string]*aggregateGroup),
+	}
+}
+
+func (at *aggregateTable) group(tup Tuple) *aggregateGroup {
+	key := string(distinctKey(tup, at.groupBy))
+	group, ok := at.groups[key]
+	if !ok {
+		groupKey := make(Tuple, len(at.groupBy))
+		for i, colIdx := range at.groupBy {
+			if 0 <= colIdx && colIdx < len(tup) {
+				groupKey[i] = tup[colIdx]
+			}
+		}
+		group = &aggregateGroup{key: groupKey, states: make([]aggregateState, len(at.aggregates))}
+		at.groups[key] = group
+	}
+	return group
+}
+
+// consume adds up the tuples of innerIter until it is exhausted or, if stop is not nil, until
+// stop is set.
+func (at *aggregateTable) consume(bufmgr *buffer.BufferPoolManager, innerIter Executor, stop *atomic.Bool) error {
+	for {
+		if stop != nil && stop.Load() {
+			return nil
+		}
+		tup, ok, err := innerIter.Next(bufmgr)
+		if err != nil {
+			return err
+		}
+		if !ok {
+			return nil
+		}
+		group := at.group(tup)
+		for i, aggregate := range at.aggregates {
+			if err := group.states[i].add(aggregate, tup); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+func (at *aggregateTable) merge(other *aggregateTable) error {
+	for key, otherGroup := range other.groups {
+		group, ok := at.groups[key]
+		if !ok {
+			at.groups[key] = otherGroup
+			continue
+		}
+		for i, aggregate := range at.aggregates {
+			if err := group.states[i].merge(aggregate, otherGroup.states[i]); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func (at *aggregateTable) result() []Tuple {
+	if len(at.groupBy) == 0 && len(at.groups) == 0 {
+		at.groups[""] = &aggregateGroup{states: make([]aggregateState, len(at.aggregates))}
+	}
+	keys := make([]string, 0, len(at.groups))
+	for key := range at.groups {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	results := make([]Tuple, 0, len(keys))
+	for _, key := range keys {
+		group := at.groups[key]
+		result := make(Tuple, 0, len(group.key)+len(at.aggregates))
+		result = append(result, group.key...)
+		for i, aggregate := range at.aggregates {
+			result = append(result, group.states[i].final(aggregate))
+		}
+		results = append(results, result)
+	}
+	return results
+}
+
+func (s *aggregateState) add(aggregate Aggregate, tup Tuple) error {
+	if aggregate.Kind == AggregateCount {
+		s.n++
+		return nil
+	}
+	if aggregate.ColumnIndex < 0 || len(tup) <= aggregate.ColumnIndex {
+		return nil
+	}
+	value := tup[aggregate.ColumnIndex]
+	if aggregate.ValueKind == types.KindNull {
+		switch aggregate.Kind {
+		case AggregateSum:
+			if len(value) != 8 {
+				return ErrInvalidSumInput
+			}
+			s.n += int64(binary.BigEndian.Uint64(value))
+		case AggregateMin, AggregateMax:
+			return s.merge(aggregate, aggregateState{value: value, set: true})
+		}
+		return nil
+	}
+
+	if len(value) == 0 {
+		return nil // NULL
+	}
+	switch aggregate.Kind {
+	case AggregateSum, AggregateAvg:
+		v, err := types.DecodeValue(aggregate.ValueKind, value)
+		if err != nil {
+			return err
+		}
+		if aggregate.ValueKind == types.KindInt64 && aggregate.Kind == AggregateSum {
+			sum, err := arithInt(ArithAdd, s.n, v.Int64())
+			if err != nil {
+				return err
+			}
+			s.n = sum.Int64()
+		} else if aggregate.ValueKind == types.KindInt64 {
+			s.f += float64(v.Int64())
+		} else {
+			s.f += v.Float64()
+		}
+		s.count++
+	case AggregateMin, AggregateMax:
+		return s.merge(aggregate, aggregateState{value: value, set: true})
+	}
+	return nil
+}
+
+// merge folds other into s. It returns ErrIntegerOverflow if the INT sum no longer fits in 64 bits.
+func (s *aggregateState) merge(aggregate Aggregate, other aggregateState) error {
+	switch aggregate.Kind {
+	case AggregateCount, AggregateSum, AggregateAvg:
+		if aggregate.Kind == AggregateSum && aggregate.ValueKind == types.KindInt64 {
+			sum, err := arithInt(ArithAdd, s.n, other.n)
+			if err != nil {
+				return err
+			}
+			s.n = sum.Int64()
+		} else {
+			s.n += other.n
+		}
+		s.f += other.f
+		s.count += other.count
+	case AggregateMin, AggregateMax:
+		if !other.set {
+			return nil
+		}
+		cmp := bytes.Compare(other.value, s.value)
+		if !s.set || (aggregate.Kind == AggregateMin && cmp < 0) || (aggregate.Kind == AggregateMax && 0 < cmp) {
+			s.value = other.value
+			s.set = true
+		}
+	}
+	return nil
+}
+
+func (s *aggregateState) final(aggregate Aggregate) []byte {
+	typed := aggregate.ValueKind != types.KindNull
+	switch {
+	case typed && aggregate.Kind == AggregateCount:
+		return types.EncodeInt(s.n)
+	case typed && (aggregate.Kind == AggregateSum || aggregate.Kind == AggregateAvg) && s.count == 0:
+		return nil // NULL
+	case typed && aggregate.Kind == AggregateSum && aggregate.ValueKind == types.KindInt64:
+		return types.EncodeInt(s.n)
+	case typed && aggregate.Kind == AggregateSum:
+		return types.EncodeFloat(s.f)
+	case aggregate.Kind == AggregateAvg:
+		return types.EncodeFloat(s.f / float64(s.count))
+	case aggregate.Kind == AggregateCount || aggregate.Kind == AggregateSum:
+		n := make([]byte, 8)
+		binary.BigEndian.PutUint64(n, uint64(s.n))
+		return n
+	default:
+		// MIN and MAX of an empty group are empty
+		return s.value
+	}
+}
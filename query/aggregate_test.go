@@ -0,0 +1,312 @@
+package query
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"os"
+	"reflect"
+	"testing"
+
+	"github.com/Johniel/gorelly/buffer"
+	"github.com/Johniel/gorelly/disk"
+	"github.com/Johniel/gorelly/table"
+	"github.com/Johniel/gorelly/types"
+)
+
+func TestHashAggregate(t *testing.T) {
+	tmpfile, err := os.CreateTemp("", "test_hash_aggregate_*.db")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(tmpfile.Name())
+
+	dm, err := disk.NewDiskManager(tmpfile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer dm.Close()
+
+	pool := buffer.NewBufferPool(10)
+	bufmgr := buffer.NewBufferPoolManager(dm, pool)
+
+	u64 := func(v uint64) []byte {
+		b := make([]byte, 8)
+		binary.BigEndian.PutUint64(b, v)
+		return b
+	}
+
+	// Rows are [id, region, amount]
+	orders := &table.SimpleTable{
+		MetaPageID:  disk.InvalidPageID,
+		NumKeyElems: 1,
+	}
+	if err := orders.Create(bufmgr); err != nil {
+		t.Fatal(err)
+	}
+	regions := []string{"east", "north", "west"}
+	expected := map[string][3]uint64{} // count, sum, max amount
+	for i := uint64(0); i < 90; i++ {
+		region := regions[i%3]
+		amount := i * 10
+		if err := orders.Insert(bufmgr, [][]byte{u64(i), []byte(region), u64(amount)}); err != nil {
+			t.Fatal(err)
+		}
+		e := expected[region]
+		e[0]++
+		e[1] += amount
+		e[2] = max(e[2], amount)
+		expected[region] = e
+	}
+
+	aggregates := []Aggregate{
+		{Kind: AggregateCount},
+		{Kind: AggregateSum, ColumnIndex: 2},
+		{Kind: AggregateMax, ColumnIndex: 2},
+		{Kind: AggregateMin, ColumnIndex: 1},
+	}
+	var want []Tuple
+	for _, region := range regions {
+		e := expected[region]
+		want = append(want, Tuple{[]byte(region), u64(e[0]), u64(e[1]), u64(e[2]), []byte(region)})
+	}
+
+	collect := func(plan PlanNode) []Tuple {
+		executor, err := plan.Start(bufmgr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var results []Tuple
+		for {
+			tup, ok, err := executor.Next(bufmgr)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !ok {
+				return results
+			}
+			results = append(results, tup)
+		}
+	}
+
+	t.Run("Serial", func(t *testing.T) {
+		plan := &HashAggregate{
+			InnerPlan: &SeqScan{
+				TableMetaPageID: orders.MetaPageID,
+				SearchMode:      NewTupleSearchModeStart(),
+				WhileCond:       func(TupleSlice) bool { return true },
+			},
+			GroupBy:    []int{1},
+			Aggregates: aggregates,
+		}
+		if results := collect(plan); !reflect.DeepEqual(results, want) {
+			t.Errorf("expected %x, got %x", want, results)
+		}
+	})
+
+	t.Run("Parallel", func(t *testing.T) {
+		partitions := PartitionSeqScan(orders.MetaPageID, []Tuple{{u64(20)}, {u64(45)}, {u64(70)}})
+		if len(partitions) != 4 {
+			t.Fatalf("expected 4 partitions, got %d", len(partitions))
+		}
+		plan := &ParallelHashAggregate{
+			Partitions: partitions,
+			GroupBy:    []int{1},
+			Aggregates: aggregates,
+		}
+		if results := collect(plan); !reflect.DeepEqual(results, want) {
+			t.Errorf("expected %x, got %x", want, results)
+		}
+	})
+
+	t.Run("NoGroupBy", func(t *testing.T) {
+		plan := &ParallelHashAggregate{
+			Partitions: PartitionSeqScan(orders.MetaPageID, []Tuple{{u64(200)}}),
+			Aggregates: []Aggregate{{Kind: AggregateCount}},
+		}
+		if results := collect(plan); !reflect.DeepEqual(results, []Tuple{{u64(90)}}) {
+			t.Errorf("expected a single count of 90, got %x", results)
+		}
+	})
+
+	t.Run("InvalidSum", func(t *testing.T) {
+		plan := &HashAggregate{
+			InnerPlan: &SeqScan{
+				TableMetaPageID: orders.MetaPageID,
+				SearchMode:      NewTupleSearchModeStart(),
+				WhileCond:       func(TupleSlice) bool { return true },
+			},
+			Aggregates: []Aggregate{{Kind: AggregateSum, ColumnIndex: 1}},
+		}
+		if _, err := plan.Start(bufmgr); err != ErrInvalidSumInput {
+			t.Errorf("expected ErrInvalidSumInput, got %v", err)
+		}
+		if pinned := bufmgr.PoolStats().Pinned; pinned != 0 {
+			t.Errorf("expected the failed aggregate to close its input, got %d pinned pages", pinned)
+		}
+
+		parallel := &ParallelHashAggregate{
+			Partitions: PartitionSeqScan(orders.MetaPageID, []Tuple{{u64(20)}, {u64(45)}, {u64(70)}}),
+			Aggregates: []Aggregate{{Kind: AggregateSum, ColumnIndex: 1}},
+		}
+		if _, err := parallel.Start(bufmgr); !errors.Is(err, ErrInvalidSumInput) {
+			t.Errorf("expected ErrInvalidSumInput, got %v", err)
+		}
+		if pinned := bufmgr.PoolStats().Pinned; pinned != 0 {
+			t.Errorf("expected every worker to close its partition, got %d pinned pages", pinned)
+		}
+	})
+}
+
+func TestHashAggregateTyped(t *testing.T) {
+	tmpfile, err := os.CreateTemp("", "test_hash_aggregate_typed_*.db")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(tmpfile.Name())
+
+	dm, err := disk.NewDiskManager(tmpfile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer dm.Close()
+
+	pool := buffer.NewBufferPool(10)
+	bufmgr := buffer.NewBufferPoolManager(dm, pool)
+
+	// Rows are [id, region, quantity, price]; the quantity of order 4 is NULL
+	orders := &table.SimpleTable{
+		MetaPageID:  disk.InvalidPageID,
+		NumKeyElems: 1,
+	}
+	if err := orders.Create(bufmgr); err != nil {
+		t.Fatal(err)
+	}
+	for _, row := range []struct {
+		id       int64
+		region   string
+		quantity types.Value
+		price    float64
+	}{
+		{1, "east", types.Int64(-3), 2.5},
+		{2, "east", types.Int64(10), 0.5},
+		{3, "west", types.Int64(9), 4},
+		{4, "west", types.Null, 1},
+		{5, "east", types.Int64(30), -1},
+	} {
+		tup := [][]byte{types.EncodeInt(row.id), []byte(row.region), row.quantity.Encode(), types.EncodeFloat(row.price)}
+		if err := orders.Insert(bufmgr, tup); err != nil {
+			t.Fatal(err)
+		}
+	}
+	scan := func(whileCond func(TupleSlice) bool) PlanNode {
+		return &SeqScan{
+			TableMetaPageID: orders.MetaPageID,
+			SearchMode:      NewTupleSearchModeStart(),
+			WhileCond:       whileCond,
+		}
+	}
+	all := func(TupleSlice) bool { return true }
+	aggregates := []Aggregate{
+		{Kind: AggregateCount, ValueKind: types.KindInt64},
+		{Kind: AggregateSum, ColumnIndex: 2, ValueKind: types.KindInt64},
+		{Kind: AggregateAvg, ColumnIndex: 2, ValueKind: types.KindInt64},
+		{Kind: AggregateMin, ColumnIndex: 2, ValueKind: types.KindInt64},
+		{Kind: AggregateMax, ColumnIndex: 2, ValueKind: types.KindInt64},
+		{Kind: AggregateSum, ColumnIndex: 3, ValueKind: types.KindFloat64},
+		{Kind: AggregateAvg, ColumnIndex: 3, ValueKind: types.KindFloat64},
+	}
+	schema := types.NewSchema(
+		types.Column{Name: "region", Kind: types.KindVarchar},
+		types.Column{Name: "count", Kind: types.KindInt64},
+		types.Column{Name: "sum_quantity", Kind: types.KindInt64},
+		types.Column{Name: "avg_quantity", Kind: types.KindFloat64},
+		types.Column{Name: "min_quantity", Kind: types.KindInt64},
+		types.Column{Name: "max_quantity", Kind: types.KindInt64},
+		types.Column{Name: "sum_price", Kind: types.KindFloat64},
+		types.Column{Name: "avg_price", Kind: types.KindFloat64},
+	)
+	collect := func(plan PlanNode, schema *types.Schema) []string {
+		executor, err := plan.Start(bufmgr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var results []string
+		for {
+			tup, ok, err := executor.Next(bufmgr)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !ok {
+				return results
+			}
+			row, err := schema.Decode(tup)
+			if err != nil {
+				t.Fatal(err)
+			}
+			results = append(results, fmt.Sprint(row.Values()))
+		}
+	}
+
+	t.Run("GroupBy", func(t *testing.T) {
+		plan := &HashAggregate{InnerPlan: scan(all), GroupBy: []int{1}, Aggregates: aggregates}
+		// The MIN of east is -3, which sorts before 10 and 30 as an INT; the NULL quantity of
+		// west is counted by COUNT(*) only
+		expected := []string{"[east 3 37 12.333333333333334 -3 30 2 0.6666666666666666]", "[west 2 9 9 9 9 5 2.5]"}
+		if results := collect(plan, schema); !reflect.DeepEqual(results, expected) {
+			t.Errorf("expected %v, got %v", expected, results)
+		}
+	})
+
+	t.Run("Scalar", func(t *testing.T) {
+		none := func(TupleSlice) bool { return false }
+		plan := &ParallelHashAggregate{Partitions: []PlanNode{scan(none), scan(none)}, Aggregates: aggregates}
+		scalar := types.NewSchema(schema.Columns[1:]...)
+		expected := []string{"[0 NULL NULL NULL NULL NULL NULL]"}
+		if results := collect(plan, scalar); !reflect.DeepEqual(results, expected) {
+			t.Errorf("expected %v, got %v", expected, results)
+		}
+	})
+
+	t.Run("Unsupported", func(t *testing.T) {
+		for _, aggregate := range []Aggregate{
+			{Kind: AggregateAvg, ColumnIndex: 2},
+			{Kind: AggregateSum, ColumnIndex: 1, ValueKind: types.KindVarchar},
+		} {
+			plan := &HashAggregate{InnerPlan: scan(all), Aggregates: []Aggregate{aggregate}}
+			if _, err := plan.Start(bufmgr); err != ErrUnsupportedAggregate {
+				t.Errorf("%+v: expected ErrUnsupportedAggregate, got %v", aggregate, err)
+			}
+		}
+	})
+	t.Run("Overflow", func(t *testing.T) {
+		big := &table.SimpleTable{
+			MetaPageID:  disk.InvalidPageID,
+			NumKeyElems: 1,
+		}
+		if err := big.Create(bufmgr); err != nil {
+			t.Fatal(err)
+		}
+		for id := int64(1); id <= 2; id++ {
+			if err := big.Insert(bufmgr, [][]byte{types.EncodeInt(id), types.EncodeInt(1 << 62)}); err != nil {
+				t.Fatal(err)
+			}
+		}
+		sum := []Aggregate{{Kind: AggregateSum, ColumnIndex: 1, ValueKind: types.KindInt64}}
+		plan := &HashAggregate{
+			InnerPlan:  &SeqScan{TableMetaPageID: big.MetaPageID, SearchMode: NewTupleSearchModeStart(), WhileCond: all},
+			Aggregates: sum,
+		}
+		if _, err := plan.Start(bufmgr); err != ErrIntegerOverflow {
+			t.Errorf("expected ErrIntegerOverflow, got %v", err)
+		}
+		// Each partition holds one row, so the sum overflows when the partial sums are merged
+		parallel := &ParallelHashAggregate{
+			Partitions: PartitionSeqScan(big.MetaPageID, []Tuple{{types.EncodeInt(2)}}),
+			Aggregates: sum,
+		}
+		if _, err := parallel.Start(bufmgr); err != ErrIntegerOverflow {
+			t.Errorf("expected ErrIntegerOverflow, got %v", err)
+		}
+	})
+}
@@ -0,0 +1,242 @@
+package query
+
+import (
+	"encoding/binary"
+	"sync"
+
+	"github.com/Johniel/gorelly/btree"
+	"github.com/Johniel/gorelly/buffer"
+	"github.com/Johniel/gorelly/errcode"
+	"github.com/Johniel/gorelly/tuple"
+)
+
+var (
+	// ErrCursorNotFound is returned when no cursor is declared under the given name.
+	ErrCursorNotFound = errcode.New(errcode.NotFound, "cursor not found")
+	// ErrCursorExists is returned when declaring a cursor with a name that is already in use.
+	ErrCursorExists = errcode.New(errcode.AlreadyExists, "cursor already exists")
+)
+
+// Cursor is a named, server-side handle on a running query.
+// Rows are fetched in batches across multiple calls instead of all at once.
+// A holdable cursor survives the end of the transaction that declared it:
+// its remaining rows are written to a temporary B+ tree when the transaction commits.
+type Cursor struct {
+	Name     string // Name the cursor was declared with
+	Holdable bool   // Whether the cursor survives transaction commit
+	executor Executor
+	done     bool // Whether the executor has been exhausted
+}
+
+// Fetch returns up to n rows from the cursor.
+// It returns fewer than n rows (possibly none) once the query is exhausted.
+func (c *Cursor) Fetch(bufmgr *buffer.BufferPoolManager, n int) ([]Tuple, error) {
+	var rows []Tuple
+	for len(rows) < n && !c.done {
+		tup, ok, err := c.executor.Next(bufmgr)
+		if err != nil {
+			return nil, err
+		}
+		if !ok {
+			c.done = true
+			break
+		}
+		rows = append(rows, tup)
+	}
+	return rows, nil
+}
+
+// materialize drains the remaining rows of the cursor into a temporary B+ tree, so that the
+// cursor no longer depends on the pages of the underlying plan, nor holds the remaining rows in
+// memory. The plan is closed.
+func (c *Cursor) materialize(bufmgr *buffer.BufferPoolManager) error {
+	if _, ok := c.executor.(*execSpilled); ok {
+		return nil
+	}
+	tree, err := btree.CreateBTree(bufmgr)
+	if err != nil {
+		return err
+	}
+	spilled := &execSpilled{tree: tree}
+	var seq uint64
+	for !c.done {
+		tup, ok, err := c.executor.Next(bufmgr)
+		if err == nil && ok {
+			err = spilled.add(bufmgr, seq, tup)
+			seq++
+		}
+		if err != nil {
+			spilled.Close(bufmgr)
+			return err
+		}
+		if !ok {
+			c.done = true
+		}
+	}
+	if err := CloseExecutor(bufmgr, c.executor); err != nil {
+		spilled.Close(bufmgr)
+		return err
+	}
+	c.executor = spilled
+	c.done = seq == 0
+	return nil
+}
+
+// close releases the executor of the cursor, e.g. the leaf pinned by a scan.
+func (c *Cursor) close(bufmgr *buffer.BufferPoolManager) error {
+	c.done = true
+	return CloseExecutor(bufmgr, c.executor)
+}
+
+// execSpilled replays the rows a holdable cursor wrote to a temporary B+ tree, in order, and
+// drops the tree once it is exhausted or closed. The tree is not logged: after a crash, its
+// pages are leaked.
+type execSpilled struct {
+	tree *btree.BTree
+	iter *btree.Iter // Iterator over the rows, once the first is read
+}
+
+// add writes the row with sequence number seq.
+func (es *execSpilled) add(bufmgr *buffer.BufferPoolManager, seq uint64, tup Tuple) error {
+	key := binary.BigEndian.AppendUint64(nil, seq)
+	value := make([]byte, 0)
+	tuple.Encode(tup, &value)
+	return es.tree.Insert(bufmgr, key, value)
+}
+
+func (es *execSpilled) Next(bufmgr *buffer.BufferPoolManager) (Tuple, bool, error) {
+	if es.tree == nil {
+		return nil, false, nil
+	}
+	if es.iter == nil {
+		iter, err := es.tree.Search(bufmgr, btree.NewSearchModeStart())
+		if err != nil {
+			return nil, false, err
+		}
+		es.iter = iter
+	}
+	_, value, ok, err := es.iter.Next(bufmgr)
+	if err != nil || !ok {
+		if closeErr := es.Close(bufmgr); err == nil {
+			err = closeErr
+		}
+		return nil, false, err
+	}
+	var tup Tuple
+	tuple.Decode(value, &tup)
+	return tup, true, nil
+}
+
+func (es *execSpilled) Close(bufmgr *buffer.BufferPoolManager) error {
+	if es.tree == nil {
+		return nil
+	}
+	if es.iter != nil {
+		es.iter.Close()
+	}
+	_, err := es.tree.Drop(bufmgr)
+	es.tree, es.iter = nil, nil
+	return err
+}
+
+// ExecMaterialized is an executor that replays tuples held in memory.
+type ExecMaterialized struct {
+	tuples  []Tuple
+	current int
+}
+
+func (em *ExecMaterialized) Next(bufmgr *buffer.BufferPoolManager) (Tuple, bool, error) {
+	if em.current >= len(em.tuples) {
+		return nil, false, nil
+	}
+	tup := em.tuples[em.current]
+	em.current++
+	return tup, true, nil
+}
+
+func (em *ExecMaterialized) Close(bufmgr *buffer.BufferPoolManager) error {
+	em.current = len(em.tuples)
+	return nil
+}
+
+// CursorManager keeps track of the named cursors of a session.
+type CursorManager struct {
+	cursors map[string]*Cursor
+	mu      sync.Mutex
+}
+
+func NewCursorManager() *CursorManager {
+	return &CursorManager{
+		cursors: make(map[string]*Cursor),
+	}
+}
+
+// Declare starts the given plan and registers it under name.
+// It returns ErrCursorExists if a cursor with the same name is already open.
+func (cm *CursorManager) Declare(bufmgr *buffer.BufferPoolManager, name string, plan PlanNode, holdable bool) (*Cursor, error) {
+	cm.mu.Lock()
+	defer cm.mu.Unlock()
+
+	if _, ok := cm.cursors[name]; ok {
+		return nil, ErrCursorExists
+	}
+	executor, err := plan.Start(bufmgr)
+	if err != nil {
+		return nil, err
+	}
+	cursor := &Cursor{
+		Name:     name,
+		Holdable: holdable,
+		executor: executor,
+	}
+	cm.cursors[name] = cursor
+	return cursor, nil
+}
+
+// Fetch returns up to n rows from the named cursor.
+func (cm *CursorManager) Fetch(bufmgr *buffer.BufferPoolManager, name string, n int) ([]Tuple, error) {
+	cm.mu.Lock()
+	defer cm.mu.Unlock()
+
+	cursor, ok := cm.cursors[name]
+	if !ok {
+		return nil, ErrCursorNotFound
+	}
+	return cursor.Fetch(bufmgr, n)
+}
+
+// Close releases the named cursor and the pages its query holds.
+func (cm *CursorManager) Close(bufmgr *buffer.BufferPoolManager, name string) error {
+	cm.mu.Lock()
+	defer cm.mu.Unlock()
+
+	cursor, ok := cm.cursors[name]
+	if !ok {
+		return ErrCursorNotFound
+	}
+	delete(cm.cursors, name)
+	return cursor.close(bufmgr)
+}
+
+// EndTransaction must be called when the transaction owning the cursors ends.
+// On commit, holdable cursors are materialized and kept open while all other cursors are closed.
+// On abort, every cursor is closed.
+func (cm *CursorManager) EndTransaction(bufmgr *buffer.BufferPoolManager, committed bool) error {
+	cm.mu.Lock()
+	defer cm.mu.Unlock()
+
+	var firstErr error
+	for name, cursor := range cm.cursors {
+		var err error
+		if !committed || !cursor.Holdable {
+			delete(cm.cursors, name)
+			err = cursor.close(bufmgr)
+		} else {
+			err = cursor.materialize(bufmgr)
+		}
+		if err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	return firstErr
+}
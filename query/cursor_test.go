@@ -0,0 +1,151 @@
+package query
+
+import (
+	"os"
+	"testing"
+
+	"github.com/Johniel/gorelly/buffer"
+	"github.com/Johniel/gorelly/disk"
+	"github.com/Johniel/gorelly/table"
+)
+
+func TestCursorManager(t *testing.T) {
+	tmpfile, err := os.CreateTemp("", "test_cursor_*.db")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(tmpfile.Name())
+
+	dm, err := disk.NewDiskManager(tmpfile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer dm.Close()
+
+	pool := buffer.NewBufferPool(10)
+	bufmgr := buffer.NewBufferPoolManager(dm, pool)
+
+	simpleTable := &table.SimpleTable{
+		MetaPageID:  disk.InvalidPageID,
+		NumKeyElems: 1,
+	}
+	if err := simpleTable.Create(bufmgr); err != nil {
+		t.Fatal(err)
+	}
+	for _, id := range []string{"1", "2", "3", "4", "5"} {
+		if err := simpleTable.Insert(bufmgr, [][]byte{[]byte(id), []byte("name" + id)}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	newScan := func() PlanNode {
+		return &SeqScan{
+			TableMetaPageID: simpleTable.MetaPageID,
+			SearchMode:      NewTupleSearchModeStart(),
+			WhileCond: func(pkey [][]byte) bool {
+				return true
+			},
+		}
+	}
+
+	t.Run("FetchInBatches", func(t *testing.T) {
+		cm := NewCursorManager()
+		if _, err := cm.Declare(bufmgr, "c", newScan(), false); err != nil {
+			t.Fatal(err)
+		}
+
+		expectedSizes := []int{2, 2, 1, 0}
+		var ids []string
+		for i, expected := range expectedSizes {
+			rows, err := cm.Fetch(bufmgr, "c", 2)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(rows) != expected {
+				t.Fatalf("Fetch %d: expected %d rows, got %d", i, expected, len(rows))
+			}
+			for _, row := range rows {
+				ids = append(ids, string(row[0]))
+			}
+		}
+		if len(ids) != 5 || ids[0] != "1" || ids[4] != "5" {
+			t.Errorf("Unexpected rows: %v", ids)
+		}
+	})
+
+	t.Run("DuplicateAndMissingNames", func(t *testing.T) {
+		cm := NewCursorManager()
+		if _, err := cm.Declare(bufmgr, "c", newScan(), false); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := cm.Declare(bufmgr, "c", newScan(), false); err != ErrCursorExists {
+			t.Errorf("Expected ErrCursorExists, got %v", err)
+		}
+		if err := cm.Close(bufmgr, "c"); err != nil {
+			t.Fatal(err)
+		}
+		if pinned := bufmgr.PoolStats().Pinned; pinned != 0 {
+			t.Errorf("Expected Close to release the scan, %d buffers pinned", pinned)
+		}
+		if _, err := cm.Fetch(bufmgr, "c", 1); err != ErrCursorNotFound {
+			t.Errorf("Expected ErrCursorNotFound, got %v", err)
+		}
+		if err := cm.Close(bufmgr, "c"); err != ErrCursorNotFound {
+			t.Errorf("Expected ErrCursorNotFound, got %v", err)
+		}
+	})
+
+	t.Run("HoldableSurvivesCommit", func(t *testing.T) {
+		cm := NewCursorManager()
+		if _, err := cm.Declare(bufmgr, "held", newScan(), true); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := cm.Declare(bufmgr, "plain", newScan(), false); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := cm.Fetch(bufmgr, "held", 2); err != nil {
+			t.Fatal(err)
+		}
+
+		if err := cm.EndTransaction(bufmgr, true); err != nil {
+			t.Fatal(err)
+		}
+
+		if _, err := cm.Fetch(bufmgr, "plain", 1); err != ErrCursorNotFound {
+			t.Errorf("Expected non-holdable cursor to be closed, got %v", err)
+		}
+		rows, err := cm.Fetch(bufmgr, "held", 10)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(rows) != 3 {
+			t.Fatalf("Expected 3 remaining rows, got %d", len(rows))
+		}
+		if string(rows[0][0]) != "3" || string(rows[2][1]) != "name5" {
+			t.Errorf("Expected the remaining rows 3 to 5, got %q", rows)
+		}
+		// The rows were spilled to a temporary tree, dropped once they are read
+		if pinned := bufmgr.PoolStats().Pinned; pinned != 0 {
+			t.Errorf("Expected no pinned buffers, got %d", pinned)
+		}
+		if dm.NumFreePages() == 0 {
+			t.Error("Expected the temporary tree to be freed")
+		}
+	})
+
+	t.Run("AbortClosesHoldable", func(t *testing.T) {
+		cm := NewCursorManager()
+		if _, err := cm.Declare(bufmgr, "held", newScan(), true); err != nil {
+			t.Fatal(err)
+		}
+		if err := cm.EndTransaction(bufmgr, false); err != nil {
+			t.Fatal(err)
+		}
+		if pinned := bufmgr.PoolStats().Pinned; pinned != 0 {
+			t.Errorf("Expected the cursor to be released, %d buffers pinned", pinned)
+		}
+		if _, err := cm.Fetch(bufmgr, "held", 1); err != ErrCursorNotFound {
+			t.Errorf("Expected ErrCursorNotFound, got %v", err)
+		}
+	})
+}
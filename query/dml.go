@@ -0,0 +1,206 @@
+package query
+
+import (
+	"bytes"
+
+	"github.com/Johniel/gorelly/buffer"
+	"github.com/Johniel/gorelly/table"
+	"github.com/Johniel/gorelly/transaction"
+	"github.com/Johniel/gorelly/types"
+)
+
+// Values returns the given tuples (VALUES), e.g. the rows of an Insert.
+type Values struct {
+	Tuples []Tuple
+}
+
+func (v *Values) Start(bufmgr *buffer.BufferPoolManager) (Executor, error) {
+	return &ExecMaterialized{tuples: v.Tuples}, nil
+}
+
+// Insert inserts the tuples of InnerPlan into Table and returns a single tuple holding the
+// number of inserted rows as an INT (see types.EncodeInt).
+//
+// Like Update and Delete, it reads all of the tuples of InnerPlan before changing the table, so
+// that a scan of the same table never sees the changes of the statement, and it changes the table
+// through Table, with its indexes, logging and checks. To make the changes in a transaction, set
+// the table's Log to the transaction's page log (see transaction.PageLog) and Txn to the
+// transaction, whose ID is then stored as the xmin of the rows written, so that FilterVisible
+// with a snapshot of the transaction sees them.
+type Insert struct {
+	InnerPlan  PlanNode
+	Table      *table.Table
+	Txn        *transaction.Transaction // Optional transaction writing the rows
+	XminColumn int                      // Column set to the ID of Txn, if set
+}
+
+func (i *Insert) Start(bufmgr *buffer.BufferPoolManager) (Executor, error) {
+	tuples, err := materialize(bufmgr, i.InnerPlan)
+	if err != nil {
+		return nil, err
+	}
+	for _, tup := range tuples {
+		if i.Txn != nil {
+			setColumn(tup, i.XminColumn, i.Txn.ID.Bytes())
+		}
+		if err := i.Table.Insert(bufmgr, tup); err != nil {
+			return nil, err
+		}
+	}
+	return affectedRows(len(tuples)), nil
+}
+
+// Assignment sets a column of the rows changed by an Update to the value of an expression
+// evaluated against the current row (SET column = value).
+type Assignment struct {
+	Column int
+	Value  Expr
+}
+
+// Update replaces the rows of Table returned by InnerPlan, e.g. a filtered scan of the table,
+// with the rows the assignments of Set make of them, and returns a single tuple holding the
+// number of updated rows as an INT. The expressions are evaluated against the rows decoded with
+// Schema. Without Set, the tuples of InnerPlan are the new rows themselves, which replace the
+// rows with the same primary key.
+//
+// A row whose primary key changes is moved to its new key (see table.Table.UpdateKey). As with
+// Insert, the rows are read up front and Txn, if set, is stored as the xmin of the new rows.
+type Update struct {
+	InnerPlan  PlanNode
+	Table      *table.Table
+	Set        []Assignment
+	Schema     *types.Schema            // Kinds of the columns of the rows, required with Set
+	Context    *EvalContext             // Context the expressions of Set are evaluated in (nil for the default)
+	Txn        *transaction.Transaction // Optional transaction writing the rows
+	XminColumn int                      // Column set to the ID of Txn, if set
+}
+
+func (u *Update) Start(bufmgr *buffer.BufferPoolManager) (Executor, error) {
+	tuples, err := materialize(bufmgr, u.InnerPlan)
+	if err != nil {
+		return nil, err
+	}
+	for _, tup := range tuples {
+		newTuple, err := u.newTuple(tup)
+		if err != nil {
+			return nil, err
+		}
+		if u.Txn != nil {
+			setColumn(newTuple, u.XminColumn, u.Txn.ID.Bytes())
+		}
+		if keyChanged(tup, newTuple, u.Table.NumKeyElems) {
+			err = u.Table.UpdateKey(bufmgr, tup[:u.Table.NumKeyElems], newTuple)
+		} else {
+			err = u.Table.Update(bufmgr, newTuple)
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	return affectedRows(len(tuples)), nil
+}
+
+// newTuple returns the row that replaces tup.
+func (u *Update) newTuple(tup Tuple) (Tuple, error) {
+	if len(u.Set) == 0 {
+		return tup, nil
+	}
+	row, err := u.Schema.Decode(tup)
+	if err != nil {
+		return nil, err
+	}
+	values := row.Values()
+	for _, assignment := range u.Set {
+		v, err := assignment.Value.Eval(u.Context, row)
+		if err != nil {
+			return nil, err
+		}
+		if assignment.Column < 0 || len(values) <= assignment.Column {
+			return nil, types.ErrColumnCount
+		}
+		values[assignment.Column] = v
+	}
+	return u.Schema.Encode(values...)
+}
+
+// Delete deletes the rows of Table whose primary keys lead the tuples of InnerPlan, e.g. a
+// filtered scan of the table or the keys of an index, and returns a single tuple holding the
+// number of deleted rows as an INT. As with Insert, the rows are read up front.
+//
+// If Txn is set, the rows are not removed but marked as deleted by the transaction: its ID is
+// stored as their xmax, so that FilterVisible hides them from the snapshots that see it.
+type Delete struct {
+	InnerPlan  PlanNode
+	Table      *table.Table
+	Txn        *transaction.Transaction // Optional transaction deleting the rows
+	XmaxColumn int                      // Column set to the ID of Txn, if set
+}
+
+func (d *Delete) Start(bufmgr *buffer.BufferPoolManager) (Executor, error) {
+	tuples, err := materialize(bufmgr, d.InnerPlan)
+	if err != nil {
+		return nil, err
+	}
+	for _, tup := range tuples {
+		if d.Txn == nil {
+			err = d.Table.Delete(bufmgr, tup)
+		} else {
+			err = d.markDeleted(bufmgr, tup)
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	return affectedRows(len(tuples)), nil
+}
+
+// markDeleted stores the ID of the transaction as the xmax of the row with the primary key of tup.
+func (d *Delete) markDeleted(bufmgr *buffer.BufferPoolManager, tup Tuple) error {
+	row, err := d.Table.Get(bufmgr, tup)
+	if err != nil {
+		return err
+	}
+	setColumn(row, d.XmaxColumn, d.Txn.ID.Bytes())
+	return d.Table.Update(bufmgr, row)
+}
+
+// materialize returns copies of all of the tuples of a plan.
+func materialize(bufmgr *buffer.BufferPoolManager, plan PlanNode) ([]Tuple, error) {
+	iter, err := plan.Start(bufmgr)
+	if err != nil {
+		return nil, err
+	}
+	var tuples []Tuple
+	for {
+		tup, ok, err := iter.Next(bufmgr)
+		if err != nil {
+			return nil, err
+		}
+		if !ok {
+			return tuples, nil
+		}
+		tuples = append(tuples, cloneTuple(tup))
+	}
+}
+
+// setColumn sets a column of a tuple, ignoring columns the tuple does not have.
+func setColumn(tup Tuple, columnIndex int, value []byte) {
+	if 0 <= columnIndex && columnIndex < len(tup) {
+		tup[columnIndex] = value
+	}
+}
+
+// keyChanged reports whether the leading numKeyElems elements of two tuples differ.
+func keyChanged(oldTuple, newTuple Tuple, numKeyElems int) bool {
+	for i := 0; i < numKeyElems; i++ {
+		if !bytes.Equal(oldTuple[i], newTuple[i]) {
+			return true
+		}
+	}
+	return false
+}
+
+// affectedRows returns the executor of the single tuple holding a number of changed rows.
+func affectedRows(n int) Executor {
+	return &ExecMaterialized{tuples: []Tuple{{types.EncodeInt(int64(n))}}}
+}
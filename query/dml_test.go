@@ -0,0 +1,181 @@
+package query
+
+import (
+	"errors"
+	"os"
+	"reflect"
+	"testing"
+
+	"github.com/Johniel/gorelly/btree"
+	"github.com/Johniel/gorelly/buffer"
+	"github.com/Johniel/gorelly/disk"
+	"github.com/Johniel/gorelly/table"
+	"github.com/Johniel/gorelly/transaction"
+	"github.com/Johniel/gorelly/types"
+)
+
+func TestDML(t *testing.T) {
+	tmpfile, err := os.CreateTemp("", "test_dml_*.db")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(tmpfile.Name())
+
+	dm, err := disk.NewDiskManager(tmpfile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer dm.Close()
+
+	pool := buffer.NewBufferPool(10)
+	bufmgr := buffer.NewBufferPoolManager(dm, pool)
+
+	// Rows are [id, name, qty, xmin, xmax], with a unique index on name
+	schema := types.NewSchema(
+		types.Column{Name: "id", Kind: types.KindInt64},
+		types.Column{Name: "name", Kind: types.KindVarchar},
+		types.Column{Name: "qty", Kind: types.KindInt64},
+		types.Column{Name: "xmin", Kind: types.KindBlob},
+		types.Column{Name: "xmax", Kind: types.KindBlob},
+	)
+	items := &table.Table{
+		MetaPageID:    disk.InvalidPageID,
+		NumKeyElems:   1,
+		UniqueIndices: []*table.UniqueIndex{{MetaPageID: disk.InvalidPageID, Skey: []int{1}}},
+	}
+	if err := items.Create(bufmgr); err != nil {
+		t.Fatal(err)
+	}
+	row := func(id int64, name string, qty int64) Tuple {
+		tup, err := schema.Encode(types.Int64(id), types.Varchar(name), types.Int64(qty), types.Null, types.Null)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return tup
+	}
+	scan := func() *SeqScan {
+		return &SeqScan{
+			TableMetaPageID: items.MetaPageID,
+			SearchMode:      NewTupleSearchModeStart(),
+			WhileCond:       func(TupleSlice) bool { return true },
+			NumKeyElems:     1,
+		}
+	}
+	where := func(op CompareOp, id int64) PlanNode {
+		return &Filter{InnerPlan: scan(), Cond: CompareValue(0, op, types.Int64(id))}
+	}
+	// run returns the number of rows changed by a statement
+	run := func(plan PlanNode) (int64, error) {
+		exec, err := plan.Start(bufmgr)
+		if err != nil {
+			return 0, err
+		}
+		tup, ok, err := exec.Next(bufmgr)
+		if err != nil || !ok {
+			t.Fatalf("expected a count, got %v", err)
+		}
+		return types.DecodeInt(tup[0])
+	}
+	// contents returns the rows of a plan as "id:name:qty"
+	contents := func(plan PlanNode) []string {
+		exec, err := plan.Start(bufmgr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var rows []string
+		for {
+			tup, ok, err := exec.Next(bufmgr)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !ok {
+				return rows
+			}
+			r, err := schema.Decode(tup)
+			if err != nil {
+				t.Fatal(err)
+			}
+			rows = append(rows, r.At(0).String()+":"+r.At(1).String()+":"+r.At(2).String())
+		}
+	}
+	check := func(expected ...string) {
+		t.Helper()
+		if rows := contents(scan()); !reflect.DeepEqual(rows, expected) {
+			t.Errorf("expected %v, got %v", expected, rows)
+		}
+	}
+	qty := &ColumnRef{Index: 2, Name: "qty"}
+
+	n, err := run(&Insert{InnerPlan: &Values{Tuples: []Tuple{row(1, "apple", 5), row(2, "pear", 0), row(3, "plum", 7)}}, Table: items})
+	if err != nil || n != 3 {
+		t.Fatalf("expected 3 inserted rows, got %d, %v", n, err)
+	}
+	check("1:apple:5", "2:pear:0", "3:plum:7")
+
+	// The scan of the table is read before the rows it returns are changed
+	n, err = run(&Update{
+		InnerPlan: where(CompareGe, 2),
+		Table:     items,
+		Set:       []Assignment{{Column: 2, Value: &ArithExpr{Op: ArithAdd, Left: qty, Right: &Literal{Value: types.Int64(1)}}}},
+		Schema:    schema,
+	})
+	if err != nil || n != 2 {
+		t.Fatalf("expected 2 updated rows, got %d, %v", n, err)
+	}
+	check("1:apple:5", "2:pear:1", "3:plum:8")
+
+	n, err = run(&Update{
+		InnerPlan: where(CompareEq, 1),
+		Table:     items,
+		Set:       []Assignment{{Column: 0, Value: &Literal{Value: types.Int64(10)}}},
+		Schema:    schema,
+	})
+	if err != nil || n != 1 {
+		t.Fatalf("expected 1 updated row, got %d, %v", n, err)
+	}
+	check("2:pear:1", "3:plum:8", "10:apple:5")
+
+	if _, err := run(&Insert{InnerPlan: &Values{Tuples: []Tuple{row(4, "plum", 1)}}, Table: items}); !errors.Is(err, btree.ErrDuplicateKey) {
+		t.Errorf("expected ErrDuplicateKey, got %v", err)
+	}
+
+	n, err = run(&Delete{InnerPlan: &Project{InnerPlan: where(CompareLt, 10), ColumnIndices: []int{0}}, Table: items})
+	if err != nil || n != 2 {
+		t.Fatalf("expected 2 deleted rows, got %d, %v", n, err)
+	}
+	check("10:apple:5")
+
+	t.Run("Transaction", func(t *testing.T) {
+		tm := transaction.NewTransactionManager()
+		txn := tm.Begin()
+		other := tm.Begin()
+		visible := func(txn *transaction.Transaction) []string {
+			return contents(&FilterVisible{
+				InnerPlan:  scan(),
+				Snapshot:   func() *transaction.Snapshot { return tm.StatementSnapshot(txn) },
+				XminColumn: 3,
+				XmaxColumn: 4,
+			})
+		}
+
+		n, err := run(&Insert{InnerPlan: &Values{Tuples: []Tuple{row(20, "fig", 1), row(21, "kiwi", 2)}}, Table: items, Txn: txn, XminColumn: 3})
+		if err != nil || n != 2 {
+			t.Fatalf("expected 2 inserted rows, got %d, %v", n, err)
+		}
+		n, err = run(&Delete{
+			InnerPlan:  &FilterVisible{InnerPlan: where(CompareEq, 21), Snapshot: func() *transaction.Snapshot { return tm.StatementSnapshot(txn) }, XminColumn: 3, XmaxColumn: 4},
+			Table:      items,
+			Txn:        txn,
+			XmaxColumn: 4,
+		})
+		if err != nil || n != 1 {
+			t.Fatalf("expected 1 deleted row, got %d, %v", n, err)
+		}
+		if rows, expected := visible(txn), []string{"20:fig:1"}; !reflect.DeepEqual(rows, expected) {
+			t.Errorf("expected the transaction to see %v, got %v", expected, rows)
+		}
+		if rows := visible(other); rows != nil {
+			t.Errorf("expected other transactions to see nothing, got %v", rows)
+		}
+	})
+}
@@ -0,0 +1,792 @@
+package query
+
+import (
+	"encoding/binary"
+	"fmt"
+	"math"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/Johniel/gorelly/btree/memcmpable"
+	"github.com/Johniel/gorelly/clock"
+	"github.com/Johniel/gorelly/errcode"
+	"github.com/Johniel/gorelly/tuple"
+	"github.com/Johniel/gorelly/types"
+)
+
+var (
+	// ErrInvalidOperand is returned when evaluating an operator on values of kinds it does not
+	// apply to, e.g. comparing a VARCHAR with an INT or adding BOOLs.
+	ErrInvalidOperand = errcode.New(errcode.DataException, "invalid operand kind for the operator")
+	// ErrDivisionByZero is returned when evaluating a division or a modulo by zero.
+	ErrDivisionByZero = errcode.New(errcode.DataException, "division by zero")
+	// ErrIntegerOverflow is returned when the result of INT arithmetic does not fit in 64 bits.
+	ErrIntegerOverflow = errcode.New(errcode.DataException, "integer out of range")
+	// ErrInvalidExpr is returned when decoding bytes that EncodeExpr did not produce.
+	ErrInvalidExpr = errcode.New(errcode.DataException, "invalid expression encoding")
+)
+
+// Expr is a scalar expression over the columns of a row, e.g. the condition of a Filter or an
+// output column of a Project. Unlike a func(TupleSlice) bool condition, it can be inspected
+// (see Walk, Conjuncts and ColumnComparison), e.g. to pick an index, and stored (see EncodeExpr).
+//
+// Expressions are evaluated against rows of typed values and follow SQL semantics: operators
+// return NULL when an operand is NULL, and AND, OR and NOT use three-valued logic.
+type Expr interface {
+	// Eval returns the value of the expression for a row, in the context of the statement
+	// evaluating it (nil for the default context).
+	Eval(ctx *EvalContext, row types.Row) (types.Value, error)
+	// String returns the expression as it would be written in a statement.
+	String() string
+	// encode returns the elements of the encoding of the expression, the first being its tag.
+	encode() [][]byte
+}
+
+// EvalContext is the state of the statement an expression is evaluated in, besides the row.
+// The zero value, like a nil *EvalContext, reads the system clock.
+type EvalContext struct {
+	Clock clock.Clock // Source of now() (nil uses clock.System)
+}
+
+// Now returns the current time of the context's clock.
+func (ctx *EvalContext) Now() time.Time {
+	if ctx == nil || ctx.Clock == nil {
+		return clock.System.Now()
+	}
+	return ctx.Clock.Now()
+}
+
+// ColumnRef is the value of a column of the row, or NULL if the row has no such column.
+type ColumnRef struct {
+	Index int
+	Name  string // Name shown by String; "$<Index>" if empty
+}
+
+func (c *ColumnRef) Eval(ctx *EvalContext, row types.Row) (types.Value, error) {
+	if c.Index < 0 || row.Len() <= c.Index {
+		return types.Null, nil
+	}
+	return row.At(c.Index), nil
+}
+
+func (c *ColumnRef) String() string {
+	if c.Name == "" {
+		return fmt.Sprintf("$%d", c.Index)
+	}
+	return c.Name
+}
+
+// Literal is a constant.
+type Literal struct {
+	Value types.Value
+}
+
+func (l *Literal) Eval(*EvalContext, types.Row) (types.Value, error) {
+	return l.Value, nil
+}
+
+func (l *Literal) String() string {
+	switch l.Value.Kind() {
+	case types.KindVarchar, types.KindBlob, types.KindTimestamp, types.KindInterval:
+		return quoteString(l.Value.String())
+	default:
+		return l.Value.String()
+	}
+}
+
+// CompareExpr compares two values with types.Compare. The values must be of the same kind, or
+// both numbers (INT and FLOAT compare exactly with each other).
+type CompareExpr struct {
+	Op    CompareOp
+	Left  Expr
+	Right Expr
+}
+
+func (c *CompareExpr) Eval(ctx *EvalContext, row types.Row) (types.Value, error) {
+	left, right, err := evalOperands(ctx, row, c.Left, c.Right)
+	if err != nil || left.IsNull() || right.IsNull() {
+		return types.Null, err
+	}
+	if left.Kind() != right.Kind() && !(isNumeric(left) && isNumeric(right)) {
+		return types.Null, fmt.Errorf("%w: %s %s %s", ErrInvalidOperand, left.Kind(), c.Op, right.Kind())
+	}
+	return types.Bool(c.Op.holds(types.Compare(left, right))), nil
+}
+
+func (c *CompareExpr) String() string {
+	return operand(c.Left, precCompare+1) + " " + c.Op.String() + " " + operand(c.Right, precCompare+1)
+}
+
+// AndExpr holds when all of its operands hold: it is FALSE if one of them is FALSE, and
+// otherwise NULL if one of them is NULL. Without operands, it is TRUE.
+type AndExpr struct {
+	Operands []Expr
+}
+
+func (a *AndExpr) Eval(ctx *EvalContext, row types.Row) (types.Value, error) {
+	return evalLogical(ctx, row, a.Operands, false)
+}
+
+func (a *AndExpr) String() string {
+	return joinOperands(a.Operands, " AND ", precAnd, "TRUE")
+}
+
+// OrExpr holds when one of its operands holds: it is TRUE if one of them is TRUE, and
+// otherwise NULL if one of them is NULL. Without operands, it is FALSE.
+type OrExpr struct {
+	Operands []Expr
+}
+
+func (o *OrExpr) Eval(ctx *EvalContext, row types.Row) (types.Value, error) {
+	return evalLogical(ctx, row, o.Operands, true)
+}
+
+func (o *OrExpr) String() string {
+	return joinOperands(o.Operands, " OR ", precOr, "FALSE")
+}
+
+// NotExpr negates a BOOL; NOT NULL is NULL.
+type NotExpr struct {
+	Operand Expr
+}
+
+func (n *NotExpr) Eval(ctx *EvalContext, row types.Row) (types.Value, error) {
+	v, err := n.Operand.Eval(ctx, row)
+	if err != nil || v.IsNull() {
+		return types.Null, err
+	}
+	if v.Kind() != types.KindBool {
+		return types.Null, fmt.Errorf("%w: NOT %s", ErrInvalidOperand, v.Kind())
+	}
+	return types.Bool(!v.Bool()), nil
+}
+
+func (n *NotExpr) String() string {
+	return "NOT " + operand(n.Operand, precNot)
+}
+
+// ArithOp is an arithmetic operator.
+type ArithOp int
+
+const (
+	ArithAdd ArithOp = iota
+	ArithSub
+	ArithMul
+	ArithDiv
+	ArithMod
+)
+
+// String returns the SQL symbol of the operator, e.g. "+".
+func (op ArithOp) String() string {
+	switch op {
+	case ArithAdd:
+		return "+"
+	case ArithSub:
+		return "-"
+	case ArithMul:
+		return "*"
+	case ArithDiv:
+		return "/"
+	case ArithMod:
+		return "%"
+	default:
+		return "?"
+	}
+}
+
+// ArithExpr applies an arithmetic operator to two numbers. The result is an INT if both are
+// INTs, in which case division truncates toward zero and overflows are errors, and a FLOAT
+// otherwise. Dividing by zero is an error.
+//
+// It also does date arithmetic: TIMESTAMP ± INTERVAL and INTERVAL + TIMESTAMP are TIMESTAMPs,
+// TIMESTAMP - TIMESTAMP is an INTERVAL, INTERVAL ± INTERVAL is an INTERVAL, and an INTERVAL can
+// be multiplied or divided by an INT.
+type ArithExpr struct {
+	Op    ArithOp
+	Left  Expr
+	Right Expr
+}
+
+func (a *ArithExpr) Eval(ctx *EvalContext, row types.Row) (types.Value, error) {
+	left, right, err := evalOperands(ctx, row, a.Left, a.Right)
+	if err != nil || left.IsNull() || right.IsNull() {
+		return types.Null, err
+	}
+	if isTemporal(left) || isTemporal(right) {
+		return arithTemporal(a.Op, left, right)
+	}
+	if !isNumeric(left) || !isNumeric(right) {
+		return types.Null, fmt.Errorf("%w: %s %s %s", ErrInvalidOperand, left.Kind(), a.Op, right.Kind())
+	}
+	if left.Kind() == types.KindInt64 && right.Kind() == types.KindInt64 {
+		return arithInt(a.Op, left.Int64(), right.Int64())
+	}
+	return arithFloat(a.Op, toFloat(left), toFloat(right))
+}
+
+func (a *ArithExpr) String() string {
+	prec := a.precedence()
+	// The operators are left-associative: a right operand of the same precedence needs parentheses
+	return operand(a.Left, prec) + " " + a.Op.String() + " " + operand(a.Right, prec+1)
+}
+
+func (a *ArithExpr) precedence() int {
+	if a.Op == ArithAdd || a.Op == ArithSub {
+		return precAdd
+	}
+	return precMul
+}
+
+// NowExpr is now(): the current time of the clock of the EvalContext as a TIMESTAMP, read anew
+// at every evaluation.
+type NowExpr struct{}
+
+func (n *NowExpr) Eval(ctx *EvalContext, _ types.Row) (types.Value, error) {
+	return types.Timestamp(ctx.Now()), nil
+}
+
+func (n *NowExpr) String() string {
+	return "now()"
+}
+
+// LikeExpr matches a VARCHAR against a pattern, in which % matches any sequence of characters,
+// _ matches one character and a backslash makes the next character match itself.
+type LikeExpr struct {
+	Operand Expr
+	Pattern string
+}
+
+func (l *LikeExpr) Eval(ctx *EvalContext, row types.Row) (types.Value, error) {
+	v, err := l.Operand.Eval(ctx, row)
+	if err != nil || v.IsNull() {
+		return types.Null, err
+	}
+	if v.Kind() != types.KindVarchar {
+		return types.Null, fmt.Errorf("%w: %s LIKE", ErrInvalidOperand, v.Kind())
+	}
+	return types.Bool(matchLike(v.Varchar(), l.Pattern)), nil
+}
+
+func (l *LikeExpr) String() string {
+	return operand(l.Operand, precCompare+1) + " LIKE " + quoteString(l.Pattern)
+}
+
+// Holds reports whether a condition is TRUE for a row; FALSE and NULL do not hold.
+func Holds(ctx *EvalContext, cond Expr, row types.Row) (bool, error) {
+	v, err := cond.Eval(ctx, row)
+	if err != nil {
+		return false, err
+	}
+	if !v.IsNull() && v.Kind() != types.KindBool {
+		return false, fmt.Errorf("%w: condition of kind %s", ErrInvalidOperand, v.Kind())
+	}
+	return v.Bool(), nil
+}
+
+// ExprCond adapts a condition to the func(TupleSlice) bool of Filter.Cond and of the scans'
+// WhileCond: tuples are decoded with the schema, and those whose decoding or evaluation fails
+// do not match. Filter.Predicate reports such errors instead.
+func ExprCond(ctx *EvalContext, cond Expr, schema *types.Schema) func(TupleSlice) bool {
+	return func(tup TupleSlice) bool {
+		row, err := schema.Decode(tup)
+		if err != nil {
+			return false
+		}
+		ok, err := Holds(ctx, cond, row)
+		return err == nil && ok
+	}
+}
+
+// Walk calls fn for the expression and, as long as fn returns true, for its operands,
+// depth-first.
+func Walk(e Expr, fn func(Expr) bool) {
+	if !fn(e) {
+		return
+	}
+	switch e := e.(type) {
+	case *CompareExpr:
+		Walk(e.Left, fn)
+		Walk(e.Right, fn)
+	case *ArithExpr:
+		Walk(e.Left, fn)
+		Walk(e.Right, fn)
+	case *AndExpr:
+		for _, operand := range e.Operands {
+			Walk(operand, fn)
+		}
+	case *OrExpr:
+		for _, operand := range e.Operands {
+			Walk(operand, fn)
+		}
+	case *NotExpr:
+		Walk(e.Operand, fn)
+	case *LikeExpr:
+		Walk(e.Operand, fn)
+	}
+}
+
+// Conjuncts splits a condition into the conditions that must all hold for it to hold, i.e. the
+// operands of its nested ANDs.
+func Conjuncts(cond Expr) []Expr {
+	and, ok := cond.(*AndExpr)
+	if !ok {
+		return []Expr{cond}
+	}
+	var conjuncts []Expr
+	for _, operand := range and.Operands {
+		conjuncts = append(conjuncts, Conjuncts(operand)...)
+	}
+	return conjuncts
+}
+
+// Conjunction returns the condition that holds when all of conds hold: the condition itself if
+// there is only one, and an AndExpr otherwise.
+func Conjunction(conds ...Expr) Expr {
+	if len(conds) == 1 {
+		return conds[0]
+	}
+	return &AndExpr{Operands: conds}
+}
+
+// ColumnComparison reports whether a condition compares a column with a constant, as in
+// "age >= 30" or "30 <= age", and returns it in the form "column op value".
+func ColumnComparison(cond Expr) (columnIndex int, op CompareOp, value types.Value, ok bool) {
+	c, isCompare := cond.(*CompareExpr)
+	if !isCompare {
+		return 0, 0, types.Null, false
+	}
+	if col, isColumn := c.Left.(*ColumnRef); isColumn {
+		if lit, isLiteral := c.Right.(*Literal); isLiteral {
+			return col.Index, c.Op, lit.Value, true
+		}
+	}
+	if lit, isLiteral := c.Left.(*Literal); isLiteral {
+		if col, isColumn := c.Right.(*ColumnRef); isColumn {
+			return col.Index, c.Op.Flip(), lit.Value, true
+		}
+	}
+	return 0, 0, types.Null, false
+}
+
+// exprColumns returns the columns an expression reads.
+func exprColumns(e Expr) []int {
+	var columns []int
+	Walk(e, func(e Expr) bool {
+		if col, ok := e.(*ColumnRef); ok {
+			columns = append(columns, col.Index)
+		}
+		return true
+	})
+	return columns
+}
+
+// Tags of the encodings of the expressions.
+const (
+	exprTagColumn byte = iota + 1
+	exprTagLiteral
+	exprTagCompare
+	exprTagAnd
+	exprTagOr
+	exprTagNot
+	exprTagArith
+	exprTagLike
+	exprTagNow
+)
+
+// EncodeExpr serializes an expression, e.g. to store it in the catalog; DecodeExpr reads it back.
+// Each node is a tuple of its tag, its fields and the encodings of its operands.
+func EncodeExpr(e Expr) []byte {
+	encoded := make([]byte, 0)
+	tuple.Encode(e.encode(), &encoded)
+	return encoded
+}
+
+func (c *ColumnRef) encode() [][]byte {
+	return [][]byte{{exprTagColumn}, binary.BigEndian.AppendUint32(nil, uint32(c.Index)), []byte(c.Name)}
+}
+
+func (l *Literal) encode() [][]byte {
+	return [][]byte{{exprTagLiteral}, {byte(l.Value.Kind())}, l.Value.Encode()}
+}
+
+func (c *CompareExpr) encode() [][]byte {
+	return [][]byte{{exprTagCompare}, {byte(c.Op)}, EncodeExpr(c.Left), EncodeExpr(c.Right)}
+}
+
+func (a *AndExpr) encode() [][]byte {
+	return append([][]byte{{exprTagAnd}}, encodeOperands(a.Operands)...)
+}
+
+func (o *OrExpr) encode() [][]byte {
+	return append([][]byte{{exprTagOr}}, encodeOperands(o.Operands)...)
+}
+
+func (n *NotExpr) encode() [][]byte {
+	return [][]byte{{exprTagNot}, EncodeExpr(n.Operand)}
+}
+
+func (a *ArithExpr) encode() [][]byte {
+	return [][]byte{{exprTagArith}, {byte(a.Op)}, EncodeExpr(a.Left), EncodeExpr(a.Right)}
+}
+
+func (l *LikeExpr) encode() [][]byte {
+	return [][]byte{{exprTagLike}, EncodeExpr(l.Operand), []byte(l.Pattern)}
+}
+
+func (n *NowExpr) encode() [][]byte {
+	return [][]byte{{exprTagNow}}
+}
+
+func encodeOperands(operands []Expr) [][]byte {
+	elems := make([][]byte, len(operands))
+	for i, operand := range operands {
+		elems[i] = EncodeExpr(operand)
+	}
+	return elems
+}
+
+// DecodeExpr deserializes an expression encoded by EncodeExpr.
+func DecodeExpr(b []byte) (Expr, error) {
+	// Every memcmpable-encoded element is a whole number of blocks
+	if len(b) == 0 || len(b)%memcmpable.EscapeLength != 0 {
+		return nil, ErrInvalidExpr
+	}
+	var elems [][]byte
+	tuple.Decode(b, &elems)
+	if len(elems[0]) != 1 {
+		return nil, ErrInvalidExpr
+	}
+	fields := elems[1:]
+
+	switch tag := elems[0][0]; tag {
+	case exprTagColumn:
+		if len(fields) != 2 || len(fields[0]) != 4 {
+			return nil, ErrInvalidExpr
+		}
+		return &ColumnRef{Index: int(int32(binary.BigEndian.Uint32(fields[0]))), Name: string(fields[1])}, nil
+	case exprTagLiteral:
+		if len(fields) != 2 || len(fields[0]) != 1 {
+			return nil, ErrInvalidExpr
+		}
+		kind := types.Kind(fields[0][0])
+		v, err := types.DecodeValue(kind, fields[1])
+		if err != nil || (v.IsNull() && kind != types.KindNull && kind != types.KindVarchar && kind != types.KindBlob) {
+			return nil, ErrInvalidExpr
+		}
+		return &Literal{Value: v}, nil
+	case exprTagCompare, exprTagArith:
+		if len(fields) != 3 || len(fields[0]) != 1 {
+			return nil, ErrInvalidExpr
+		}
+		left, err := DecodeExpr(fields[1])
+		if err != nil {
+			return nil, err
+		}
+		right, err := DecodeExpr(fields[2])
+		if err != nil {
+			return nil, err
+		}
+		if tag == exprTagCompare {
+			if CompareGe < CompareOp(fields[0][0]) {
+				return nil, ErrInvalidExpr
+			}
+			return &CompareExpr{Op: CompareOp(fields[0][0]), Left: left, Right: right}, nil
+		}
+		if ArithMod < ArithOp(fields[0][0]) {
+			return nil, ErrInvalidExpr
+		}
+		return &ArithExpr{Op: ArithOp(fields[0][0]), Left: left, Right: right}, nil
+	case exprTagAnd, exprTagOr:
+		var operands []Expr
+		for _, field := range fields {
+			operand, err := DecodeExpr(field)
+			if err != nil {
+				return nil, err
+			}
+			operands = append(operands, operand)
+		}
+		if tag == exprTagAnd {
+			return &AndExpr{Operands: operands}, nil
+		}
+		return &OrExpr{Operands: operands}, nil
+	case exprTagNot:
+		if len(fields) != 1 {
+			return nil, ErrInvalidExpr
+		}
+		operand, err := DecodeExpr(fields[0])
+		if err != nil {
+			return nil, err
+		}
+		return &NotExpr{Operand: operand}, nil
+	case exprTagLike:
+		if len(fields) != 2 {
+			return nil, ErrInvalidExpr
+		}
+		operand, err := DecodeExpr(fields[0])
+		if err != nil {
+			return nil, err
+		}
+		return &LikeExpr{Operand: operand, Pattern: string(fields[1])}, nil
+	case exprTagNow:
+		if len(fields) != 0 {
+			return nil, ErrInvalidExpr
+		}
+		return &NowExpr{}, nil
+	default:
+		return nil, ErrInvalidExpr
+	}
+}
+
+// Precedences of the operators, from the loosest; operands of a looser operator are parenthesized.
+const (
+	precOr = iota + 1
+	precAnd
+	precNot
+	precCompare
+	precAdd
+	precMul
+	precOperand
+)
+
+func precedence(e Expr) int {
+	switch e := e.(type) {
+	case *OrExpr:
+		if len(e.Operands) == 0 {
+			return precOperand
+		}
+		return precOr
+	case *AndExpr:
+		if len(e.Operands) == 0 {
+			return precOperand
+		}
+		return precAnd
+	case *NotExpr:
+		return precNot
+	case *CompareExpr, *LikeExpr:
+		return precCompare
+	case *ArithExpr:
+		return e.precedence()
+	default:
+		return precOperand
+	}
+}
+
+// operand formats an operand, in parentheses if its operator binds looser than minPrec.
+func operand(e Expr, minPrec int) string {
+	if precedence(e) < minPrec {
+		return "(" + e.String() + ")"
+	}
+	return e.String()
+}
+
+func joinOperands(operands []Expr, sep string, prec int, empty string) string {
+	if len(operands) == 0 {
+		return empty
+	}
+	parts := make([]string, len(operands))
+	for i, e := range operands {
+		parts[i] = operand(e, prec)
+	}
+	return strings.Join(parts, sep)
+}
+
+// quoteString formats a string literal, in which a quote is written twice.
+func quoteString(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", "''") + "'"
+}
+
+func evalOperands(ctx *EvalContext, row types.Row, left, right Expr) (types.Value, types.Value, error) {
+	l, err := left.Eval(ctx, row)
+	if err != nil {
+		return types.Null, types.Null, err
+	}
+	r, err := right.Eval(ctx, row)
+	if err != nil {
+		return types.Null, types.Null, err
+	}
+	return l, r, nil
+}
+
+// evalLogical evaluates an AND (or an OR, if isOr is set) of operands with three-valued logic,
+// stopping at the first operand that decides the result.
+func evalLogical(ctx *EvalContext, row types.Row, operands []Expr, isOr bool) (types.Value, error) {
+	null := false
+	for _, e := range operands {
+		v, err := e.Eval(ctx, row)
+		if err != nil {
+			return types.Null, err
+		}
+		if v.IsNull() {
+			null = true
+			continue
+		}
+		if v.Kind() != types.KindBool {
+			return types.Null, fmt.Errorf("%w: logical operand of kind %s", ErrInvalidOperand, v.Kind())
+		}
+		if v.Bool() == isOr {
+			return v, nil
+		}
+	}
+	if null {
+		return types.Null, nil
+	}
+	return types.Bool(!isOr), nil
+}
+
+func isNumeric(v types.Value) bool {
+	return v.Kind() == types.KindInt64 || v.Kind() == types.KindFloat64
+}
+
+func toFloat(v types.Value) float64 {
+	if v.Kind() == types.KindInt64 {
+		return float64(v.Int64())
+	}
+	return v.Float64()
+}
+
+func arithInt(op ArithOp, a, b int64) (types.Value, error) {
+	var r int64
+	switch op {
+	case ArithAdd:
+		r = a + b
+		if (0 < b && r < a) || (b < 0 && a < r) {
+			return types.Null, ErrIntegerOverflow
+		}
+	case ArithSub:
+		r = a - b
+		if (0 < b && a < r) || (b < 0 && r < a) {
+			return types.Null, ErrIntegerOverflow
+		}
+	case ArithMul:
+		r = a * b
+		if a != 0 && (r/a != b || (a == -1 && b == math.MinInt64)) {
+			return types.Null, ErrIntegerOverflow
+		}
+	case ArithDiv, ArithMod:
+		if b == 0 {
+			return types.Null, ErrDivisionByZero
+		}
+		if op == ArithMod {
+			r = a % b
+		} else if a == math.MinInt64 && b == -1 {
+			return types.Null, ErrIntegerOverflow
+		} else {
+			r = a / b
+		}
+	default:
+		return types.Null, ErrInvalidOperand
+	}
+	return types.Int64(r), nil
+}
+
+func isTemporal(v types.Value) bool {
+	return v.Kind() == types.KindTimestamp || v.Kind() == types.KindInterval
+}
+
+// arithTemporal applies an operator to a TIMESTAMP or an INTERVAL, computing in the
+// microseconds both are stored as so that overflows are reported as for INTs.
+func arithTemporal(op ArithOp, left, right types.Value) (types.Value, error) {
+	invalid := fmt.Errorf("%w: %s %s %s", ErrInvalidOperand, left.Kind(), op, right.Kind())
+	micros := func(v types.Value) int64 {
+		switch v.Kind() {
+		case types.KindTimestamp:
+			return v.Timestamp().UnixMicro()
+		case types.KindInterval:
+			return v.Interval().Microseconds()
+		default:
+			return v.Int64()
+		}
+	}
+	var kind types.Kind
+	switch lk, rk := left.Kind(), right.Kind(); {
+	case lk == types.KindTimestamp && rk == types.KindInterval && (op == ArithAdd || op == ArithSub):
+		kind = types.KindTimestamp
+	case lk == types.KindInterval && rk == types.KindTimestamp && op == ArithAdd:
+		kind = types.KindTimestamp
+	case lk == types.KindTimestamp && rk == types.KindTimestamp && op == ArithSub:
+		kind = types.KindInterval
+	case lk == types.KindInterval && rk == types.KindInterval && (op == ArithAdd || op == ArithSub):
+		kind = types.KindInterval
+	case lk == types.KindInterval && rk == types.KindInt64 && (op == ArithMul || op == ArithDiv):
+		kind = types.KindInterval
+	case lk == types.KindInt64 && rk == types.KindInterval && op == ArithMul:
+		kind = types.KindInterval
+	default:
+		return types.Null, invalid
+	}
+	r, err := arithInt(op, micros(left), micros(right))
+	if err != nil {
+		return types.Null, err
+	}
+	if kind == types.KindTimestamp {
+		return types.Timestamp(time.UnixMicro(r.Int64())), nil
+	}
+	if r.Int64() < math.MinInt64/1000 || math.MaxInt64/1000 < r.Int64() {
+		// Out of the range of time.Duration
+		return types.Null, ErrIntegerOverflow
+	}
+	return types.Interval(time.Duration(r.Int64()) * time.Microsecond), nil
+}
+
+func arithFloat(op ArithOp, a, b float64) (types.Value, error) {
+	switch op {
+	case ArithAdd:
+		return types.Float64(a + b), nil
+	case ArithSub:
+		return types.Float64(a - b), nil
+	case ArithMul:
+		return types.Float64(a * b), nil
+	case ArithDiv, ArithMod:
+		if b == 0 {
+			return types.Null, ErrDivisionByZero
+		}
+		if op == ArithMod {
+			return types.Float64(math.Mod(a, b)), nil
+		}
+		return types.Float64(a / b), nil
+	default:
+		return types.Null, ErrInvalidOperand
+	}
+}
+
+// matchLike reports whether s matches a LIKE pattern. On a mismatch after a %, the match
+// resumes one character further past the position the % was tried at.
+func matchLike(s, pattern string) bool {
+	si, pi := 0, 0
+	starP, starS := -1, 0 // Pattern position after the last %, and the position in s it matches up to
+	for si < len(s) {
+		if pi < len(pattern) {
+			pc, pn := utf8.DecodeRuneInString(pattern[pi:])
+			sc, sn := utf8.DecodeRuneInString(s[si:])
+			switch {
+			case pc == '%':
+				starP, starS = pi+pn, si
+				pi += pn
+				continue
+			case pc == '_':
+				pi, si = pi+pn, si+sn
+				continue
+			case pc == '\\' && pi+pn < len(pattern):
+				ec, en := utf8.DecodeRuneInString(pattern[pi+pn:])
+				if ec == sc {
+					pi, si = pi+pn+en, si+sn
+					continue
+				}
+			case pc == sc:
+				pi, si = pi+pn, si+sn
+				continue
+			}
+		}
+		if starP < 0 {
+			return false
+		}
+		_, sn := utf8.DecodeRuneInString(s[starS:])
+		starS += sn
+		pi, si = starP, starS
+	}
+	for pi < len(pattern) && pattern[pi] == '%' {
+		pi++
+	}
+	return pi == len(pattern)
+}
@@ -0,0 +1,303 @@
+package query
+
+import (
+	"errors"
+	"os"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/Johniel/gorelly/buffer"
+	"github.com/Johniel/gorelly/clock"
+	"github.com/Johniel/gorelly/disk"
+	"github.com/Johniel/gorelly/table"
+	"github.com/Johniel/gorelly/types"
+)
+
+func TestExprEval(t *testing.T) {
+	schema := types.NewSchema(
+		types.Column{Name: "id", Kind: types.KindInt64},
+		types.Column{Name: "name", Kind: types.KindVarchar},
+		types.Column{Name: "score", Kind: types.KindFloat64},
+		types.Column{Name: "note", Kind: types.KindVarchar},
+	)
+	row, err := schema.NewRow(types.Int64(7), types.Varchar("50% off_été"), types.Float64(2.5), types.Null)
+	if err != nil {
+		t.Fatal(err)
+	}
+	id := &ColumnRef{Index: 0, Name: "id"}
+	name := &ColumnRef{Index: 1, Name: "name"}
+	score := &ColumnRef{Index: 2, Name: "score"}
+	note := &ColumnRef{Index: 3, Name: "note"}
+	lit := func(v types.Value) Expr { return &Literal{Value: v} }
+	truth, falsity, null := lit(types.Bool(true)), lit(types.Bool(false)), lit(types.Null)
+	day := time.Date(2024, 2, 29, 12, 30, 0, 0, time.UTC)
+
+	tests := []struct {
+		expr     Expr
+		expected types.Value
+		err      error
+	}{
+		{&CompareExpr{Op: CompareGt, Left: id, Right: score}, types.Bool(true), nil},
+		{&CompareExpr{Op: CompareEq, Left: lit(types.Float64(7)), Right: id}, types.Bool(true), nil},
+		{&CompareExpr{Op: CompareEq, Left: note, Right: note}, types.Null, nil},
+		{&CompareExpr{Op: CompareEq, Left: id, Right: name}, types.Null, ErrInvalidOperand},
+		{&AndExpr{Operands: []Expr{truth, null}}, types.Null, nil},
+		{&AndExpr{Operands: []Expr{null, falsity}}, types.Bool(false), nil},
+		{&AndExpr{Operands: []Expr{falsity, id}}, types.Bool(false), nil},
+		{&AndExpr{}, types.Bool(true), nil},
+		{&OrExpr{Operands: []Expr{falsity, null}}, types.Null, nil},
+		{&OrExpr{Operands: []Expr{null, truth}}, types.Bool(true), nil},
+		{&OrExpr{Operands: []Expr{falsity, id}}, types.Null, ErrInvalidOperand},
+		{&NotExpr{Operand: null}, types.Null, nil},
+		{&NotExpr{Operand: falsity}, types.Bool(true), nil},
+		{&ArithExpr{Op: ArithAdd, Left: id, Right: lit(types.Int64(3))}, types.Int64(10), nil},
+		{&ArithExpr{Op: ArithDiv, Left: lit(types.Int64(-7)), Right: lit(types.Int64(2))}, types.Int64(-3), nil},
+		{&ArithExpr{Op: ArithMod, Left: id, Right: lit(types.Int64(4))}, types.Int64(3), nil},
+		{&ArithExpr{Op: ArithMul, Left: id, Right: score}, types.Float64(17.5), nil},
+		{&ArithExpr{Op: ArithSub, Left: id, Right: note}, types.Null, nil},
+		{&ArithExpr{Op: ArithDiv, Left: id, Right: lit(types.Int64(0))}, types.Null, ErrDivisionByZero},
+		{&ArithExpr{Op: ArithDiv, Left: score, Right: lit(types.Float64(0))}, types.Null, ErrDivisionByZero},
+		{&ArithExpr{Op: ArithAdd, Left: lit(types.Int64(1 << 62)), Right: lit(types.Int64(1 << 62))}, types.Null, ErrIntegerOverflow},
+		{&ArithExpr{Op: ArithMul, Left: lit(types.Int64(-1)), Right: lit(types.Int64(-1 << 63))}, types.Null, ErrIntegerOverflow},
+		{&ArithExpr{Op: ArithAdd, Left: name, Right: id}, types.Null, ErrInvalidOperand},
+		{&LikeExpr{Operand: name, Pattern: `50\%%_t_`}, types.Bool(true), nil},
+		{&LikeExpr{Operand: name, Pattern: `%off%`}, types.Bool(true), nil},
+		{&LikeExpr{Operand: name, Pattern: `50\_%`}, types.Bool(false), nil},
+		{&LikeExpr{Operand: name, Pattern: `%été`}, types.Bool(true), nil},
+		{&LikeExpr{Operand: name, Pattern: `%ét`}, types.Bool(false), nil},
+		{&LikeExpr{Operand: note, Pattern: `%`}, types.Null, nil},
+		{&LikeExpr{Operand: id, Pattern: `%`}, types.Null, ErrInvalidOperand},
+		{&ColumnRef{Index: 9}, types.Null, nil},
+		{&ArithExpr{Op: ArithAdd, Left: lit(types.Timestamp(day)), Right: lit(types.Interval(90 * time.Minute))}, types.Timestamp(day.Add(90 * time.Minute)), nil},
+		{&ArithExpr{Op: ArithAdd, Left: lit(types.Interval(time.Hour)), Right: lit(types.Timestamp(day))}, types.Timestamp(day.Add(time.Hour)), nil},
+		{&ArithExpr{Op: ArithSub, Left: lit(types.Timestamp(day)), Right: lit(types.Interval(24 * time.Hour))}, types.Timestamp(day.AddDate(0, 0, -1)), nil},
+		{&ArithExpr{Op: ArithSub, Left: lit(types.Timestamp(day)), Right: lit(types.Timestamp(day.Add(time.Second)))}, types.Interval(-time.Second), nil},
+		{&ArithExpr{Op: ArithSub, Left: lit(types.Interval(time.Hour)), Right: lit(types.Interval(time.Minute))}, types.Interval(59 * time.Minute), nil},
+		{&ArithExpr{Op: ArithMul, Left: id, Right: lit(types.Interval(time.Second))}, types.Interval(7 * time.Second), nil},
+		{&ArithExpr{Op: ArithDiv, Left: lit(types.Interval(time.Minute)), Right: lit(types.Int64(4))}, types.Interval(15 * time.Second), nil},
+		{&ArithExpr{Op: ArithDiv, Left: lit(types.Interval(time.Minute)), Right: lit(types.Int64(0))}, types.Null, ErrDivisionByZero},
+		{&ArithExpr{Op: ArithMul, Left: lit(types.Interval(time.Hour)), Right: lit(types.Int64(1 << 40))}, types.Null, ErrIntegerOverflow},
+		{&ArithExpr{Op: ArithAdd, Left: lit(types.Timestamp(day)), Right: lit(types.Timestamp(day))}, types.Null, ErrInvalidOperand},
+		{&ArithExpr{Op: ArithSub, Left: lit(types.Interval(time.Hour)), Right: lit(types.Timestamp(day))}, types.Null, ErrInvalidOperand},
+		{&ArithExpr{Op: ArithAdd, Left: lit(types.Timestamp(day)), Right: id}, types.Null, ErrInvalidOperand},
+		{&ArithExpr{Op: ArithAdd, Left: lit(types.Timestamp(day)), Right: note}, types.Null, nil},
+	}
+	for _, tt := range tests {
+		v, err := tt.expr.Eval(nil, row)
+		if !errors.Is(err, tt.err) {
+			t.Errorf("%s: expected error %v, got %v", tt.expr, tt.err, err)
+			continue
+		}
+		if err == nil && !reflect.DeepEqual(v, tt.expected) {
+			t.Errorf("%s: expected %v, got %v", tt.expr, tt.expected, v)
+		}
+	}
+
+	t.Run("Now", func(t *testing.T) {
+		c := clock.NewLogical(day)
+		ctx := &EvalContext{Clock: c}
+		// now() - 1h is an hour before the time of the clock
+		expr := &ArithExpr{Op: ArithSub, Left: &NowExpr{}, Right: lit(types.Interval(time.Hour))}
+		v, err := expr.Eval(ctx, row)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if expected := types.Timestamp(day.Add(-time.Hour)); !reflect.DeepEqual(v, expected) {
+			t.Errorf("expected %v, got %v", expected, v)
+		}
+		// now() is read anew at every evaluation
+		c.Advance(time.Minute)
+		if v, err = expr.Eval(ctx, row); err != nil {
+			t.Fatal(err)
+		}
+		if expected := types.Timestamp(day.Add(time.Minute - time.Hour)); !reflect.DeepEqual(v, expected) {
+			t.Errorf("expected %v, got %v", expected, v)
+		}
+	})
+}
+
+func TestExprString(t *testing.T) {
+	a, b, c := &ColumnRef{Index: 0, Name: "a"}, &ColumnRef{Index: 1}, &Literal{Value: types.Int64(3)}
+	tests := []struct {
+		expr     Expr
+		expected string
+	}{
+		{&ArithExpr{Op: ArithMul, Left: &ArithExpr{Op: ArithAdd, Left: a, Right: b}, Right: c}, "(a + $1) * 3"},
+		{&ArithExpr{Op: ArithSub, Left: &ArithExpr{Op: ArithSub, Left: a, Right: b}, Right: c}, "a - $1 - 3"},
+		{&ArithExpr{Op: ArithSub, Left: a, Right: &ArithExpr{Op: ArithSub, Left: b, Right: c}}, "a - ($1 - 3)"},
+		{&ArithExpr{Op: ArithSub, Left: &NowExpr{}, Right: &Literal{Value: types.Interval(time.Hour)}}, "now() - '1h0m0s'"},
+		{
+			&AndExpr{Operands: []Expr{
+				&OrExpr{Operands: []Expr{
+					&CompareExpr{Op: CompareLe, Left: a, Right: c},
+					&AndExpr{Operands: []Expr{&LikeExpr{Operand: b, Pattern: "it's%"}, &Literal{Value: types.Null}}},
+				}},
+				&NotExpr{Operand: &CompareExpr{Op: CompareNe, Left: b, Right: &Literal{Value: types.Varchar("x")}}},
+			}},
+			"(a <= 3 OR $1 LIKE 'it''s%' AND NULL) AND NOT $1 <> 'x'",
+		},
+	}
+	for _, tt := range tests {
+		if s := tt.expr.String(); s != tt.expected {
+			t.Errorf("expected %q, got %q", tt.expected, s)
+		}
+	}
+}
+
+func TestEncodeExpr(t *testing.T) {
+	expr := &AndExpr{Operands: []Expr{
+		&CompareExpr{Op: CompareGe, Left: &ArithExpr{Op: ArithMod, Left: &ColumnRef{Index: 2, Name: "age"}, Right: &Literal{Value: types.Int64(-5)}}, Right: &Literal{Value: types.Float64(1.5)}},
+		&OrExpr{Operands: []Expr{&NotExpr{Operand: &Literal{Value: types.Bool(false)}}, &Literal{Value: types.Null}}},
+		&LikeExpr{Operand: &ColumnRef{Index: 1}, Pattern: "a%"},
+		&OrExpr{},
+		&CompareExpr{Op: CompareLt, Left: &ColumnRef{Index: 3}, Right: &ArithExpr{Op: ArithSub, Left: &NowExpr{}, Right: &Literal{Value: types.Interval(time.Hour)}}},
+	}}
+	decoded, err := DecodeExpr(EncodeExpr(expr))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(decoded, Expr(expr)) {
+		t.Errorf("expected %s, got %s", expr, decoded)
+	}
+
+	for _, b := range [][]byte{nil, {1, 2, 3}, EncodeExpr(expr)[1:], make([]byte, 9)} {
+		if _, err := DecodeExpr(b); !errors.Is(err, ErrInvalidExpr) {
+			t.Errorf("%x: expected ErrInvalidExpr, got %v", b, err)
+		}
+	}
+}
+
+func TestExprInspection(t *testing.T) {
+	age := &ColumnRef{Index: 2, Name: "age"}
+	flipped := &CompareExpr{Op: CompareLt, Left: &Literal{Value: types.Int64(30)}, Right: age}
+	other := &CompareExpr{Op: CompareEq, Left: age, Right: &ArithExpr{Op: ArithAdd, Left: age, Right: age}}
+	cond := &AndExpr{Operands: []Expr{flipped, &AndExpr{Operands: []Expr{other}}}}
+
+	if conjuncts := Conjuncts(cond); !reflect.DeepEqual(conjuncts, []Expr{flipped, other}) {
+		t.Errorf("unexpected conjuncts %v", conjuncts)
+	}
+	if Conjunction(flipped) != Expr(flipped) {
+		t.Errorf("expected the single condition itself")
+	}
+	col, op, v, ok := ColumnComparison(flipped)
+	if !ok || col != 2 || op != CompareGt || v.Int64() != 30 {
+		t.Errorf("unexpected comparison %d %v %v %v", col, op, v, ok)
+	}
+	if _, _, _, ok := ColumnComparison(other); ok {
+		t.Errorf("expected no comparison with a constant")
+	}
+	if columns := exprColumns(cond); !reflect.DeepEqual(columns, []int{2, 2, 2, 2}) {
+		t.Errorf("unexpected columns %v", columns)
+	}
+}
+
+func TestFilterAndProjectExprs(t *testing.T) {
+	tmpfile, err := os.CreateTemp("", "test_filter_project_exprs_*.db")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(tmpfile.Name())
+
+	dm, err := disk.NewDiskManager(tmpfile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer dm.Close()
+
+	pool := buffer.NewBufferPool(10)
+	bufmgr := buffer.NewBufferPoolManager(dm, pool)
+
+	schema := types.NewSchema(
+		types.Column{Name: "id", Kind: types.KindInt64},
+		types.Column{Name: "price", Kind: types.KindInt64},
+		types.Column{Name: "qty", Kind: types.KindInt64},
+	)
+	items := &table.SimpleTable{
+		MetaPageID:  disk.InvalidPageID,
+		NumKeyElems: 1,
+	}
+	if err := items.Create(bufmgr); err != nil {
+		t.Fatal(err)
+	}
+	for _, values := range [][]types.Value{
+		{types.Int64(1), types.Int64(10), types.Int64(3)},
+		{types.Int64(2), types.Int64(5), types.Null},
+		{types.Int64(3), types.Int64(7), types.Int64(0)},
+		{types.Int64(4), types.Int64(20), types.Int64(1)},
+	} {
+		tup, err := schema.Encode(values...)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := items.Insert(bufmgr, tup); err != nil {
+			t.Fatal(err)
+		}
+	}
+	scan := &SeqScan{
+		TableMetaPageID: items.MetaPageID,
+		SearchMode:      NewTupleSearchModeStart(),
+		WhileCond:       func(TupleSlice) bool { return true },
+		NumKeyElems:     1,
+	}
+	id, price, qty := &ColumnRef{Index: 0, Name: "id"}, &ColumnRef{Index: 1, Name: "price"}, &ColumnRef{Index: 2, Name: "qty"}
+	total := &ArithExpr{Op: ArithMul, Left: price, Right: qty}
+	outSchema := types.NewSchema(types.Column{Name: "id", Kind: types.KindInt64}, types.Column{Name: "total", Kind: types.KindInt64})
+	run := func(plan PlanNode) ([]string, error) {
+		exec, err := plan.Start(bufmgr)
+		if err != nil {
+			return nil, err
+		}
+		var rows []string
+		for {
+			tup, ok, err := exec.Next(bufmgr)
+			if err != nil || !ok {
+				return rows, err
+			}
+			row, err := outSchema.Decode(tup)
+			if err != nil {
+				return nil, err
+			}
+			rows = append(rows, row.At(0).String()+":"+row.At(1).String())
+		}
+	}
+
+	// The row with a NULL quantity has a NULL total, which is not > 5
+	plan := &Project{
+		InnerPlan: &Filter{
+			InnerPlan: scan,
+			Predicate: &CompareExpr{Op: CompareGt, Left: total, Right: &Literal{Value: types.Int64(5)}},
+			Schema:    schema,
+		},
+		Exprs:  []Expr{id, total},
+		Schema: schema,
+	}
+	rows, err := run(plan)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected := []string{"1:30", "4:20"}; !reflect.DeepEqual(rows, expected) {
+		t.Errorf("expected %v, got %v", expected, rows)
+	}
+	if !reflect.DeepEqual(plan.Ordering(), []SortKey{{ColumnIndex: 0, Ascending: true}}) {
+		t.Errorf("expected the ordering of the ids, got %v", plan.Ordering())
+	}
+
+	// Errors of the evaluation are returned
+	plan.Exprs = []Expr{id, &ArithExpr{Op: ArithDiv, Left: price, Right: qty}}
+	plan.InnerPlan = scan
+	if _, err := run(plan); !errors.Is(err, ErrDivisionByZero) {
+		t.Errorf("expected ErrDivisionByZero, got %v", err)
+	}
+
+	// ExprCond adapts the expression to the callback of the scans
+	scanWhile := *scan
+	scanWhile.WhileCond = ExprCond(nil, &CompareExpr{Op: CompareLt, Left: id, Right: &Literal{Value: types.Int64(3)}}, schema)
+	rows, err = run(&Project{InnerPlan: &scanWhile, Exprs: []Expr{id, price}, Schema: schema})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected := []string{"1:10", "2:5"}; !reflect.DeepEqual(rows, expected) {
+		t.Errorf("expected %v, got %v", expected, rows)
+	}
+}
@@ -0,0 +1,131 @@
+package query
+
+import (
+	"bytes"
+
+	"github.com/Johniel/gorelly/buffer"
+	"github.com/Johniel/gorelly/disk"
+	"github.com/Johniel/gorelly/table"
+	"github.com/Johniel/gorelly/tuple"
+)
+
+// IndexNestedLoopJoin joins the tuples of OuterPlan with the rows of an inner table: for every
+// outer tuple, it scans the inner table's index for the entries whose key starts with the values
+// of the outer tuple's OuterColumns, so that the inner table is never read in full. Each match is
+// returned as the outer tuple followed by the inner row, in the order of the outer tuples and
+// then of the index.
+//
+// If IndexMetaPageID is disk.InvalidPageID, the inner rows are looked up by the leading columns
+// of their primary key instead, e.g. to follow a foreign key to the row it references.
+// Outer tuples with a NULL in OuterColumns match nothing.
+type IndexNestedLoopJoin struct {
+	OuterPlan       PlanNode
+	OuterColumns    []int              // Columns of the outer tuples matched with the leading key columns
+	TableMetaPageID disk.PageID        // Page ID of the inner table's B+ tree meta page
+	IndexMetaPageID disk.PageID        // Page ID of the inner table's index, or disk.InvalidPageID for its primary key
+	Format          tuple.Format       // Row format of the inner table
+	Defaults        [][]byte           // Default value of every column of the inner table, as in SeqScan
+	DroppedColumns  []int              // Columns dropped from the inner table, as in SeqScan
+	Stats           *table.AccessStats // Optional access counters of the inner table, as in SeqScan
+}
+
+func (j *IndexNestedLoopJoin) Start(bufmgr *buffer.BufferPoolManager) (Executor, error) {
+	outerIter, err := j.OuterPlan.Start(bufmgr)
+	if err != nil {
+		return nil, err
+	}
+	return &ExecIndexNestedLoopJoin{join: j, outerIter: outerIter}, nil
+}
+
+// Ordering returns the ordering of the outer plan, whose columns keep their positions.
+func (j *IndexNestedLoopJoin) Ordering() []SortKey {
+	return orderingOf(j.OuterPlan)
+}
+
+// innerPlan returns the scan of the inner rows matching an outer tuple, or nil if it has a NULL
+// join key.
+func (j *IndexNestedLoopJoin) innerPlan(outer Tuple) PlanNode {
+	key := make([][]byte, len(j.OuterColumns))
+	for i, colIdx := range j.OuterColumns {
+		if len(outer) <= colIdx || len(outer[colIdx]) == 0 {
+			return nil
+		}
+		key[i] = outer[colIdx]
+	}
+	if j.IndexMetaPageID.Valid() {
+		return &IndexScan{
+			TableMetaPageID: j.TableMetaPageID,
+			IndexMetaPageID: j.IndexMetaPageID,
+			Prefix:          key,
+			Format:          j.Format,
+			Defaults:        j.Defaults,
+			DroppedColumns:  j.DroppedColumns,
+			Stats:           j.Stats,
+		}
+	}
+	return &SeqScan{
+		TableMetaPageID: j.TableMetaPageID,
+		SearchMode:      NewTupleSearchModeKey(key),
+		WhileCond: func(pkey TupleSlice) bool {
+			for i, elem := range key {
+				if len(pkey) <= i || !bytes.Equal(pkey[i], elem) {
+					return false
+				}
+			}
+			return true
+		},
+		Format:         j.Format,
+		Defaults:       j.Defaults,
+		DroppedColumns: j.DroppedColumns,
+		Stats:          j.Stats,
+	}
+}
+
+// ExecIndexNestedLoopJoin is the executor for index nested-loop joins.
+type ExecIndexNestedLoopJoin struct {
+	join      *IndexNestedLoopJoin
+	outerIter Executor
+	outer     Tuple    // Outer tuple whose matches are being returned
+	innerIter Executor // Scan of the matches of outer, or nil between outer tuples
+}
+
+func (ej *ExecIndexNestedLoopJoin) Next(bufmgr *buffer.BufferPoolManager) (Tuple, bool, error) {
+	for {
+		if ej.innerIter != nil {
+			inner, ok, err := ej.innerIter.Next(bufmgr)
+			if err != nil {
+				return nil, false, err
+			}
+			if ok {
+				result := make([][]byte, 0, len(ej.outer)+len(inner))
+				result = append(result, ej.outer...)
+				return append(result, inner...), true, nil
+			}
+			ej.innerIter = nil
+		}
+
+		outer, ok, err := ej.outerIter.Next(bufmgr)
+		if err != nil || !ok {
+			return nil, false, err
+		}
+		innerPlan := ej.join.innerPlan(outer)
+		if innerPlan == nil {
+			continue
+		}
+		// The outer tuple is returned with every match, after the outer plan moved on
+		ej.outer = cloneTuple(outer)
+		if ej.innerIter, err = innerPlan.Start(bufmgr); err != nil {
+			return nil, false, err
+		}
+	}
+}
+
+func (ej *ExecIndexNestedLoopJoin) Close(bufmgr *buffer.BufferPoolManager) error {
+	if ej.innerIter != nil {
+		if err := CloseExecutor(bufmgr, ej.innerIter); err != nil {
+			return err
+		}
+		ej.innerIter = nil
+	}
+	return CloseExecutor(bufmgr, ej.outerIter)
+}
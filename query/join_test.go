@@ -0,0 +1,157 @@
+package query
+
+import (
+	"fmt"
+	"os"
+	"reflect"
+	"testing"
+
+	"github.com/Johniel/gorelly/buffer"
+	"github.com/Johniel/gorelly/catalog"
+	"github.com/Johniel/gorelly/disk"
+	"github.com/Johniel/gorelly/table"
+	"github.com/Johniel/gorelly/types"
+)
+
+func TestIndexNestedLoopJoin(t *testing.T) {
+	tmpfile, err := os.CreateTemp("", "test_index_nested_loop_join_*.db")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(tmpfile.Name())
+
+	dm, err := disk.NewDiskManager(tmpfile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer dm.Close()
+
+	pool := buffer.NewBufferPool(32)
+	bufmgr := buffer.NewBufferPoolManager(dm, pool)
+
+	cm, err := catalog.NewCatalogManager(bufmgr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	insert := func(tableName string, rows ...[]any) *catalog.TableSchema {
+		schema, err := cm.GetTableSchema(tableName)
+		if err != nil {
+			t.Fatal(err)
+		}
+		tbl, err := cm.OpenTable(tableName)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, row := range rows {
+			tup, err := schema.BindRow(row...)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := tbl.Insert(bufmgr, tup); err != nil {
+				t.Fatal(err)
+			}
+		}
+		return schema
+	}
+	if _, err := cm.CreateTable("users", []catalog.ColumnDef{
+		{Name: "id", Type: catalog.ColumnTypeInt, IsPrimaryKey: true},
+		{Name: "name", Type: catalog.ColumnTypeVarchar},
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := cm.CreateTable("orders", []catalog.ColumnDef{
+		{Name: "id", Type: catalog.ColumnTypeInt, IsPrimaryKey: true},
+		{Name: "user_id", Type: catalog.ColumnTypeInt, Nullable: true},
+		{Name: "amount", Type: catalog.ColumnTypeInt},
+	}); err != nil {
+		t.Fatal(err)
+	}
+	users := insert("users", []any{1, "alice"}, []any{2, "bob"}, []any{3, "carol"})
+	insert("orders", []any{10, 1, 5}, []any{11, 2, 7}, []any{12, 1, 9}, []any{13, types.Null, 4})
+	index, err := cm.CreateIndex("orders", []string{"user_id"}, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	orders, err := cm.GetTableSchema("orders")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// run returns the rows of the plan formatted as the values of the kinds
+	run := func(plan PlanNode, kinds ...types.Kind) []string {
+		var columns []types.Column
+		for _, kind := range kinds {
+			columns = append(columns, types.Column{Kind: kind})
+		}
+		schema := types.NewSchema(columns...)
+		exec, err := plan.Start(bufmgr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var rows []string
+		for {
+			tup, ok, err := exec.Next(bufmgr)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !ok {
+				return rows
+			}
+			if len(tup) != len(kinds) {
+				t.Fatalf("expected %d columns, got %d", len(kinds), len(tup))
+			}
+			row, err := schema.Decode(tup)
+			if err != nil {
+				t.Fatal(err)
+			}
+			rows = append(rows, fmt.Sprint(row.Values()))
+		}
+	}
+
+	t.Run("Index", func(t *testing.T) {
+		var stats table.AccessStats
+		plan := &IndexNestedLoopJoin{
+			OuterPlan: &SeqScan{
+				TableMetaPageID: users.MetaPageID,
+				SearchMode:      NewTupleSearchModeStart(),
+				WhileCond:       func(TupleSlice) bool { return true },
+				NumKeyElems:     users.NumKeyElems,
+			},
+			OuterColumns:    []int{0},
+			TableMetaPageID: orders.MetaPageID,
+			IndexMetaPageID: index.MetaPageID,
+			Stats:           &stats,
+		}
+		expected := []string{"[1 alice 10 1 5]", "[1 alice 12 1 9]", "[2 bob 11 2 7]"}
+		if rows := run(plan, types.KindInt64, types.KindVarchar, types.KindInt64, types.KindInt64, types.KindInt64); !reflect.DeepEqual(rows, expected) {
+			t.Errorf("expected %v, got %v", expected, rows)
+		}
+		if stats.SeqScans.Load() != 0 || stats.IndexScans.Load() != 3 {
+			t.Errorf("expected one index scan per user and no scan of orders, got %d and %d", stats.IndexScans.Load(), stats.SeqScans.Load())
+		}
+		if !reflect.DeepEqual(plan.Ordering(), []SortKey{{ColumnIndex: 0, Ascending: true}}) {
+			t.Errorf("expected the ordering of the users, got %v", plan.Ordering())
+		}
+	})
+
+	t.Run("PrimaryKey", func(t *testing.T) {
+		plan := &IndexNestedLoopJoin{
+			OuterPlan: &Project{
+				InnerPlan: &SeqScan{
+					TableMetaPageID: orders.MetaPageID,
+					SearchMode:      NewTupleSearchModeStart(),
+					WhileCond:       func(TupleSlice) bool { return true },
+				},
+				ColumnIndices: []int{0, 2, 1},
+			},
+			OuterColumns:    []int{2},
+			TableMetaPageID: users.MetaPageID,
+			IndexMetaPageID: disk.InvalidPageID,
+		}
+		// Order 13 has no user
+		expected := []string{"[10 5 1 1 alice]", "[11 7 2 2 bob]", "[12 9 1 1 alice]"}
+		if rows := run(plan, types.KindInt64, types.KindInt64, types.KindInt64, types.KindInt64, types.KindVarchar); !reflect.DeepEqual(rows, expected) {
+			t.Errorf("expected %v, got %v", expected, rows)
+		}
+	})
+}
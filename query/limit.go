@@ -0,0 +1,200 @@
+package query
+
+import (
+	"bytes"
+	"container/heap"
+	"errors"
+	"sort"
+
+	"github.com/Johniel/gorelly/buffer"
+)
+
+// Closer is implemented by executors that can stop before they are run to completion, releasing
+// the pages they keep pinned (see Executor), e.g. once a Limit has returned all of its tuples.
+// Next must not be called after Close.
+type Closer interface {
+	Close(bufmgr *buffer.BufferPoolManager) error
+}
+
+// CloseExecutor stops an executor early: it closes the executor if it is a Closer, and otherwise
+// reads it to the end.
+func CloseExecutor(bufmgr *buffer.BufferPoolManager, exec Executor) error {
+	if c, ok := exec.(Closer); ok {
+		return c.Close(bufmgr)
+	}
+	for {
+		_, ok, err := exec.Next(bufmgr)
+		if err != nil || !ok {
+			return err
+		}
+	}
+}
+
+// closeOnError closes an executor abandoned because of err and returns err, joined with the
+// error of closing it if there is one.
+func closeOnError(bufmgr *buffer.BufferPoolManager, exec Executor, err error) error {
+	if closeErr := CloseExecutor(bufmgr, exec); closeErr != nil {
+		return errors.Join(err, closeErr)
+	}
+	return err
+}
+
+// Limit returns at most Count tuples of InnerPlan, after skipping the first Offset of them
+// (LIMIT ... OFFSET); a negative Count returns every tuple after Offset. Once it has returned
+// Count tuples, it closes its input instead of reading it to the end.
+// To return the first tuples in the order of sort keys, a TopN is cheaper than a Sort below a Limit.
+type Limit struct {
+	InnerPlan PlanNode
+	Count     int
+	Offset    int
+}
+
+func (l *Limit) Start(bufmgr *buffer.BufferPoolManager) (Executor, error) {
+	innerIter, err := l.InnerPlan.Start(bufmgr)
+	if err != nil {
+		return nil, err
+	}
+	return &ExecLimit{innerIter: innerIter, remaining: l.Count, skip: l.Offset}, nil
+}
+
+// Ordering returns the ordering of the inner plan, which limiting preserves.
+func (l *Limit) Ordering() []SortKey {
+	return orderingOf(l.InnerPlan)
+}
+
+// ExecLimit is the executor for limit operations.
+type ExecLimit struct {
+	innerIter Executor
+	remaining int // Number of tuples still to return (negative for no limit)
+	skip      int // Number of tuples still to skip
+	closed    bool
+}
+
+func (el *ExecLimit) Next(bufmgr *buffer.BufferPoolManager) (Tuple, bool, error) {
+	for !el.closed {
+		if el.remaining == 0 {
+			return nil, false, el.Close(bufmgr)
+		}
+		tup, ok, err := el.innerIter.Next(bufmgr)
+		if err != nil || !ok {
+			el.closed = true
+			return nil, false, err
+		}
+		if 0 < el.skip {
+			el.skip--
+			continue
+		}
+		if 0 < el.remaining {
+			el.remaining--
+		}
+		return tup, true, nil
+	}
+	return nil, false, nil
+}
+
+func (el *ExecLimit) Close(bufmgr *buffer.BufferPoolManager) error {
+	if el.closed {
+		return nil
+	}
+	el.closed = true
+	return CloseExecutor(bufmgr, el.innerIter)
+}
+
+// TopN returns the first N tuples of InnerPlan in the order of SortKeys (ORDER BY ... LIMIT N),
+// like a Limit on a Sort, but keeps only the N first tuples seen so far in a bounded heap instead
+// of holding and sorting all of them. Tuples equal on the sort keys are returned in the order the
+// inner plan produced them. If the inner plan is already in order (see Sort.Presorted), its
+// first N tuples are returned as they come.
+type TopN struct {
+	InnerPlan PlanNode
+	SortKeys  []SortKey
+	N         int
+}
+
+func (tn *TopN) Start(bufmgr *buffer.BufferPoolManager) (Executor, error) {
+	if (&Sort{InnerPlan: tn.InnerPlan, SortKeys: tn.SortKeys}).Presorted() {
+		return (&Limit{InnerPlan: tn.InnerPlan, Count: max(tn.N, 0)}).Start(bufmgr)
+	}
+	if tn.N <= 0 {
+		return &ExecMaterialized{}, nil
+	}
+	innerIter, err := tn.InnerPlan.Start(bufmgr)
+	if err != nil {
+		return nil, err
+	}
+
+	h := &topNHeap{sortKeys: tn.SortKeys}
+	for seq := 0; ; seq++ {
+		tup, ok, err := innerIter.Next(bufmgr)
+		if err != nil {
+			return nil, err
+		}
+		if !ok {
+			break
+		}
+		entry := topNEntry{seq: seq}
+		if len(h.entries) == tn.N {
+			// The tuple comes after the worst one kept unless it sorts strictly before it
+			if compareTuples(tup, h.entries[0].tup, tn.SortKeys) >= 0 {
+				continue
+			}
+			entry.tup = cloneTuple(tup)
+			h.entries[0] = entry
+			heap.Fix(h, 0)
+			continue
+		}
+		entry.tup = cloneTuple(tup)
+		heap.Push(h, entry)
+	}
+
+	sort.Slice(h.entries, func(i, j int) bool { return h.less(h.entries[i], h.entries[j]) })
+	tuples := make([]Tuple, len(h.entries))
+	for i, entry := range h.entries {
+		tuples[i] = entry.tup
+	}
+	return &ExecMaterialized{tuples: tuples}, nil
+}
+
+// Ordering returns the sort keys.
+func (tn *TopN) Ordering() []SortKey {
+	return tn.SortKeys
+}
+
+// cloneTuple returns a deep copy of a tuple.
+func cloneTuple(tup Tuple) Tuple {
+	clone := make(Tuple, len(tup))
+	for i := range tup {
+		clone[i] = bytes.Clone(tup[i])
+	}
+	return clone
+}
+
+// topNEntry is a tuple kept by a TopN, with its position in the input to break ties.
+type topNEntry struct {
+	tup Tuple
+	seq int
+}
+
+// topNHeap is a max-heap of the tuples kept by a TopN: the root is the one that sorts last.
+type topNHeap struct {
+	entries  []topNEntry
+	sortKeys []SortKey
+}
+
+// less reports whether a sorts before b.
+func (h *topNHeap) less(a, b topNEntry) bool {
+	if c := compareTuples(a.tup, b.tup, h.sortKeys); c != 0 {
+		return c < 0
+	}
+	return a.seq < b.seq
+}
+
+func (h *topNHeap) Len() int           { return len(h.entries) }
+func (h *topNHeap) Less(i, j int) bool { return h.less(h.entries[j], h.entries[i]) }
+func (h *topNHeap) Swap(i, j int)      { h.entries[i], h.entries[j] = h.entries[j], h.entries[i] }
+func (h *topNHeap) Push(x any)         { h.entries = append(h.entries, x.(topNEntry)) }
+func (h *topNHeap) Pop() any {
+	last := h.entries[len(h.entries)-1]
+	h.entries = h.entries[:len(h.entries)-1]
+	return last
+}
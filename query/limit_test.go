@@ -0,0 +1,156 @@
+package query
+
+import (
+	"math"
+	"os"
+	"reflect"
+	"sort"
+	"testing"
+
+	"github.com/Johniel/gorelly/buffer"
+	"github.com/Johniel/gorelly/catalog"
+	"github.com/Johniel/gorelly/disk"
+	"github.com/Johniel/gorelly/table"
+	"github.com/Johniel/gorelly/transaction"
+	"github.com/Johniel/gorelly/types"
+)
+
+func TestLimitAndTopN(t *testing.T) {
+	tmpfile, err := os.CreateTemp("", "test_limit_top_n_*.db")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(tmpfile.Name())
+
+	dm, err := disk.NewDiskManager(tmpfile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer dm.Close()
+
+	pool := buffer.NewBufferPool(10)
+	bufmgr := buffer.NewBufferPoolManager(dm, pool)
+
+	// Rows are [id, score]; scores repeat, so that ties must keep the order of the scan
+	scores := &table.SimpleTable{
+		MetaPageID:  disk.InvalidPageID,
+		NumKeyElems: 1,
+	}
+	if err := scores.Create(bufmgr); err != nil {
+		t.Fatal(err)
+	}
+	var rows []Tuple
+	for i := int64(0); i < 500; i++ {
+		row := Tuple{types.EncodeInt(i), types.EncodeInt(i * 37 % 50)}
+		if err := scores.Insert(bufmgr, row); err != nil {
+			t.Fatal(err)
+		}
+		rows = append(rows, row)
+	}
+	scan := func() *SeqScan {
+		return &SeqScan{
+			TableMetaPageID: scores.MetaPageID,
+			SearchMode:      NewTupleSearchModeStart(),
+			WhileCond:       func(TupleSlice) bool { return true },
+			NumKeyElems:     1,
+		}
+	}
+	collect := func(plan PlanNode) []Tuple {
+		executor, err := plan.Start(bufmgr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var results []Tuple
+		for {
+			tup, ok, err := executor.Next(bufmgr)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !ok {
+				return results
+			}
+			results = append(results, tup)
+		}
+	}
+
+	t.Run("TopN", func(t *testing.T) {
+		sortKeys := []SortKey{{ColumnIndex: 1, Ascending: false}}
+		sorted := append([]Tuple(nil), rows...)
+		sort.SliceStable(sorted, func(i, j int) bool { return compareTuples(sorted[i], sorted[j], sortKeys) < 0 })
+		for _, n := range []int{0, 1, 7, 500, 600} {
+			expected := sorted[:min(n, len(sorted))]
+			results := collect(&TopN{InnerPlan: scan(), SortKeys: sortKeys, N: n})
+			if len(results) != len(expected) || (0 < n && !reflect.DeepEqual(results, expected)) {
+				t.Errorf("N=%d: expected %x, got %x", n, expected, results)
+			}
+		}
+	})
+
+	t.Run("TopNPresorted", func(t *testing.T) {
+		plan := &TopN{InnerPlan: scan(), SortKeys: []SortKey{{ColumnIndex: 0, Ascending: true}}, N: 3}
+		if results := collect(plan); !reflect.DeepEqual(results, rows[:3]) {
+			t.Errorf("expected %x, got %x", rows[:3], results)
+		}
+		if pinned := bufmgr.PoolStats().Pinned; pinned != 0 {
+			t.Errorf("expected the scan to be closed, got %d pinned pages", pinned)
+		}
+	})
+
+	t.Run("Limit", func(t *testing.T) {
+		plan := &Limit{
+			InnerPlan: &Project{
+				InnerPlan:     &Filter{InnerPlan: scan(), Cond: CompareValue(1, CompareEq, types.Int64(0))},
+				ColumnIndices: []int{0},
+			},
+			Count:  2,
+			Offset: 1,
+		}
+		expected := []Tuple{{types.EncodeInt(50)}, {types.EncodeInt(100)}}
+		if results := collect(plan); !reflect.DeepEqual(results, expected) {
+			t.Errorf("expected %x, got %x", expected, results)
+		}
+		if pinned := bufmgr.PoolStats().Pinned; pinned != 0 {
+			t.Errorf("expected the scan to be closed, got %d pinned pages", pinned)
+		}
+		if results := collect(&Limit{InnerPlan: scan(), Count: -1, Offset: 498}); !reflect.DeepEqual(results, rows[498:]) {
+			t.Errorf("expected %x, got %x", rows[498:], results)
+		}
+	})
+
+	t.Run("CloseInput", func(t *testing.T) {
+		// Closing an executor releases its input without reading the rest of it
+		var read int
+		counted := scan()
+		counted.WhileCond = func(TupleSlice) bool {
+			read++
+			return true
+		}
+		everything := &transaction.Snapshot{Xmax: math.MaxUint64}
+		for name, plan := range map[string]PlanNode{
+			"ApplyPolicy":         &ApplyPolicy{InnerPlan: counted},
+			"DecryptColumns":      &DecryptColumns{InnerPlan: counted, Schema: &catalog.TableSchema{}},
+			"FilterVisible":       &FilterVisible{InnerPlan: counted, Snapshot: func() *transaction.Snapshot { return everything }, XmaxColumn: -1},
+			"ApproxCountDistinct": &ApproxCountDistinct{InnerPlan: counted, ColumnIndices: []int{1}},
+		} {
+			read = 0
+			executor, err := plan.Start(bufmgr)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if _, ok := executor.(*ExecApproxCountDistinct); !ok {
+				if _, _, err := executor.Next(bufmgr); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if err := CloseExecutor(bufmgr, executor); err != nil {
+				t.Fatal(err)
+			}
+			if 1 < read {
+				t.Errorf("%s: expected Close not to drain the input, read %d rows", name, read)
+			}
+			if pinned := bufmgr.PoolStats().Pinned; pinned != 0 {
+				t.Errorf("%s: expected the scan to be closed, got %d pinned pages", name, pinned)
+			}
+		}
+	})
+}
@@ -0,0 +1,61 @@
+package query
+
+import (
+	"github.com/Johniel/gorelly/buffer"
+)
+
+// Options are the settings of a whole query, as opposed to those of a single plan node.
+type Options struct {
+	// Seed of the randomized operators of the query (TABLESAMPLE). With a seed, running the
+	// query again over the same data returns the same rows, e.g. for reproducible test
+	// expectations. Samples with a Seed of their own keep it. Nil leaves every sample as is.
+	Seed *int64
+}
+
+// StartWithOptions applies opts to every node of plan and starts it.
+// The plan keeps the options, so starting it again without them reproduces the same results.
+func StartWithOptions(bufmgr *buffer.BufferPoolManager, plan PlanNode, opts Options) (Executor, error) {
+	opts.apply(plan)
+	return plan.Start(bufmgr)
+}
+
+// apply stores the options in plan and the plans below it.
+func (opts Options) apply(plan PlanNode) {
+	if ss, ok := plan.(*SeqScan); ok && opts.Seed != nil && ss.Sample != nil && ss.Sample.Seed == nil {
+		// The sample may be shared with other plans, which are not part of the query
+		sample := *ss.Sample
+		sample.Seed = opts.Seed
+		ss.Sample = &sample
+	}
+	if c, ok := plan.(composite); ok {
+		for _, input := range c.inputs() {
+			opts.apply(input)
+		}
+	}
+}
+
+// composite is implemented by plan nodes that run other plans.
+type composite interface {
+	inputs() []PlanNode
+}
+
+func (f *Filter) inputs() []PlanNode                  { return []PlanNode{f.InnerPlan} }
+func (fv *FilterVisible) inputs() []PlanNode          { return []PlanNode{fv.InnerPlan} }
+func (a *Authorize) inputs() []PlanNode               { return []PlanNode{a.InnerPlan} }
+func (ap *ApplyPolicy) inputs() []PlanNode            { return []PlanNode{ap.InnerPlan} }
+func (dc *DecryptColumns) inputs() []PlanNode         { return []PlanNode{dc.InnerPlan} }
+func (p *Project) inputs() []PlanNode                 { return []PlanNode{p.InnerPlan} }
+func (acd *ApproxCountDistinct) inputs() []PlanNode   { return []PlanNode{acd.InnerPlan} }
+func (s *Sort) inputs() []PlanNode                    { return []PlanNode{s.InnerPlan} }
+func (ha *HashAggregate) inputs() []PlanNode          { return []PlanNode{ha.InnerPlan} }
+func (pha *ParallelHashAggregate) inputs() []PlanNode { return pha.Partitions }
+func (pss *ParallelSeqScan) inputs() []PlanNode       { return []PlanNode{pss.Scan} }
+func (j *IndexNestedLoopJoin) inputs() []PlanNode     { return []PlanNode{j.OuterPlan} }
+func (l *Limit) inputs() []PlanNode                   { return []PlanNode{l.InnerPlan} }
+func (tn *TopN) inputs() []PlanNode                   { return []PlanNode{tn.InnerPlan} }
+func (u *Union) inputs() []PlanNode                   { return []PlanNode{u.LeftPlan, u.RightPlan} }
+func (i *Intersect) inputs() []PlanNode               { return []PlanNode{i.LeftPlan, i.RightPlan} }
+func (e *Except) inputs() []PlanNode                  { return []PlanNode{e.LeftPlan, e.RightPlan} }
+func (i *Insert) inputs() []PlanNode                  { return []PlanNode{i.InnerPlan} }
+func (u *Update) inputs() []PlanNode                  { return []PlanNode{u.InnerPlan} }
+func (d *Delete) inputs() []PlanNode                  { return []PlanNode{d.InnerPlan} }
@@ -0,0 +1,94 @@
+package query
+
+import (
+	"bytes"
+	"errors"
+	"sync"
+
+	"github.com/Johniel/gorelly/btree"
+	"github.com/Johniel/gorelly/buffer"
+	"github.com/Johniel/gorelly/tuple"
+)
+
+// ParallelSeqScan returns the same tuples as Scan, in the same order, reading them with Degree
+// workers. The primary key range of Scan is split into Degree parts of about the same number of
+// pages (see btree.BTree.SplitKeys), each read by its own scan, and the tuples are returned
+// once every worker is done. Small tables are split into fewer parts, down to one for a table
+// of a single leaf. Backward scans are not supported.
+// Like ParallelHashAggregate, it relies on the buffer pool being large enough that pages being
+// read are not evicted. Scan.LockKey is called from the workers concurrently.
+type ParallelSeqScan struct {
+	Scan   *SeqScan
+	Degree int
+}
+
+func (pss *ParallelSeqScan) Start(bufmgr *buffer.BufferPoolManager) (Executor, error) {
+	bounds, err := btree.NewBTree(pss.Scan.TableMetaPageID).SplitKeys(bufmgr, pss.Degree)
+	if err != nil {
+		return nil, err
+	}
+	var start []byte
+	if !pss.Scan.SearchMode.IsStart {
+		start = pss.Scan.SearchMode.Encode().Key
+	}
+	results := make([][]Tuple, len(bounds)+1)
+	errs := make([]error, len(bounds)+1)
+	var wg sync.WaitGroup
+	for i := range results {
+		partition := *pss.Scan
+		if 0 < i && bytes.Compare(start, bounds[i-1]) < 0 {
+			partition.start = bounds[i-1]
+		}
+		if i < len(bounds) {
+			partition.WhileCond = belowKey(pss.Scan.WhileCond, bounds[i])
+		}
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			results[i], errs[i] = collect(bufmgr, &partition)
+		}(i)
+	}
+	wg.Wait()
+	if err := errors.Join(errs...); err != nil {
+		return nil, err
+	}
+	var tuples []Tuple
+	for _, result := range results {
+		tuples = append(tuples, result...)
+	}
+	return &ExecMaterialized{tuples: tuples}, nil
+}
+
+// Ordering returns the ordering of Scan.
+func (pss *ParallelSeqScan) Ordering() []SortKey {
+	return pss.Scan.Ordering()
+}
+
+// belowKey returns a WhileCond that holds while whileCond does and the primary key is below
+// the encoded key upper.
+func belowKey(whileCond func(TupleSlice) bool, upper []byte) func(TupleSlice) bool {
+	return func(pkey TupleSlice) bool {
+		encoded := make([]byte, 0)
+		tuple.Encode(pkey, &encoded)
+		return bytes.Compare(encoded, upper) < 0 && whileCond(pkey)
+	}
+}
+
+// collect runs a plan to the end and returns its tuples.
+func collect(bufmgr *buffer.BufferPoolManager, plan PlanNode) ([]Tuple, error) {
+	exec, err := plan.Start(bufmgr)
+	if err != nil {
+		return nil, err
+	}
+	var tuples []Tuple
+	for {
+		tup, ok, err := exec.Next(bufmgr)
+		if err != nil {
+			return nil, errors.Join(err, CloseExecutor(bufmgr, exec))
+		}
+		if !ok {
+			return tuples, nil
+		}
+		tuples = append(tuples, tup)
+	}
+}
@@ -0,0 +1,93 @@
+package query
+
+import (
+	"bytes"
+	"encoding/binary"
+	"os"
+	"reflect"
+	"testing"
+
+	"github.com/Johniel/gorelly/btree"
+	"github.com/Johniel/gorelly/buffer"
+	"github.com/Johniel/gorelly/disk"
+	"github.com/Johniel/gorelly/table"
+)
+
+func TestParallelSeqScan(t *testing.T) {
+	tmpfile, err := os.CreateTemp("", "test_parallel_seq_scan_*.db")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(tmpfile.Name())
+
+	dm, err := disk.NewDiskManager(tmpfile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer dm.Close()
+
+	pool := buffer.NewBufferPool(64)
+	bufmgr := buffer.NewBufferPoolManager(dm, pool)
+
+	u64 := func(v uint64) []byte {
+		b := make([]byte, 8)
+		binary.BigEndian.PutUint64(b, v)
+		return b
+	}
+
+	items := &table.SimpleTable{
+		MetaPageID:  disk.InvalidPageID,
+		NumKeyElems: 1,
+	}
+	if err := items.Create(bufmgr); err != nil {
+		t.Fatal(err)
+	}
+	const numRows = 2000
+	for i := uint64(0); i < numRows; i++ {
+		if err := items.Insert(bufmgr, [][]byte{u64(i), bytes.Repeat([]byte{'x'}, 100)}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	collectAll := func(plan PlanNode) []Tuple {
+		tuples, err := collect(bufmgr, plan)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return tuples
+	}
+
+	for _, tc := range []struct {
+		name       string
+		searchMode TupleSearchMode
+		whileCond  func(TupleSlice) bool
+		expected   int
+	}{
+		{"Full", NewTupleSearchModeStart(), func(TupleSlice) bool { return true }, numRows},
+		{"Range", NewTupleSearchModeKey([][]byte{u64(500)}), func(pkey TupleSlice) bool {
+			return bytes.Compare(pkey[0], u64(1500)) < 0
+		}, 1000},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			scan := &SeqScan{
+				TableMetaPageID: items.MetaPageID,
+				SearchMode:      tc.searchMode,
+				WhileCond:       tc.whileCond,
+			}
+			want := collectAll(scan)
+			if len(want) != tc.expected {
+				t.Fatalf("expected %d rows, got %d", tc.expected, len(want))
+			}
+			bounds, err := btree.NewBTree(items.MetaPageID).SplitKeys(bufmgr, 4)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(bounds) != 3 {
+				t.Fatalf("expected the table to be split in 4, got %d bounds", len(bounds))
+			}
+			if got := collectAll(&ParallelSeqScan{Scan: scan, Degree: 4}); !reflect.DeepEqual(got, want) {
+				t.Errorf("expected the rows of the serial scan, got %d rows", len(got))
+			}
+		})
+	}
+}
@@ -0,0 +1,123 @@
+package query
+
+import (
+	"bytes"
+
+	"github.com/Johniel/gorelly/table"
+	"github.com/Johniel/gorelly/types"
+)
+
+// CompareOp is a comparison operator.
+type CompareOp int
+
+const (
+	CompareEq CompareOp = iota
+	CompareNe
+	CompareLt
+	CompareLe
+	CompareGt
+	CompareGe
+)
+
+// String returns the SQL symbol of the operator, e.g. "<=".
+func (op CompareOp) String() string {
+	switch op {
+	case CompareEq:
+		return "="
+	case CompareNe:
+		return "<>"
+	case CompareLt:
+		return "<"
+	case CompareLe:
+		return "<="
+	case CompareGt:
+		return ">"
+	case CompareGe:
+		return ">="
+	default:
+		return "?"
+	}
+}
+
+// Flip returns the operator comparing the operands the other way round, so that "a < b" is
+// "b > a".
+func (op CompareOp) Flip() CompareOp {
+	switch op {
+	case CompareLt:
+		return CompareGt
+	case CompareLe:
+		return CompareGe
+	case CompareGt:
+		return CompareLt
+	case CompareGe:
+		return CompareLe
+	default:
+		return op
+	}
+}
+
+// holds reports whether the result of a three-way comparison satisfies the operator.
+func (op CompareOp) holds(cmp int) bool {
+	switch op {
+	case CompareEq:
+		return cmp == 0
+	case CompareNe:
+		return cmp != 0
+	case CompareLt:
+		return cmp < 0
+	case CompareLe:
+		return cmp <= 0
+	case CompareGt:
+		return 0 < cmp
+	case CompareGe:
+		return 0 <= cmp
+	default:
+		return false
+	}
+}
+
+// Compare returns a condition for Filter.Cond or a scan's WhileCond that compares a column with
+// value. Values must use the order-preserving encodings of the column type (see
+// catalog.ColumnDef.Bind), so that the byte-wise comparison matches the order of the values;
+// e.g. the INT 9 sorts before 10, which the strings "9" and "10" do not.
+// Tuples without the column never match.
+func Compare(columnIndex int, op CompareOp, value []byte) func(TupleSlice) bool {
+	return func(tup TupleSlice) bool {
+		if columnIndex < 0 || len(tup) <= columnIndex {
+			return false
+		}
+		return op.holds(bytes.Compare(tup[columnIndex], value))
+	}
+}
+
+// CompareRange returns the range of the values of a column that Compare with the same
+// arguments matches, for SeqScan.ZoneRanges. CompareNe does not restrict the range.
+func CompareRange(columnIndex int, op CompareOp, value []byte) table.ColumnRange {
+	r := table.ColumnRange{ColumnIndex: columnIndex}
+	switch op {
+	case CompareEq:
+		r.Lo, r.LoInclusive, r.Hi, r.HiInclusive = value, true, value, true
+	case CompareLt:
+		r.Hi = value
+	case CompareLe:
+		r.Hi, r.HiInclusive = value, true
+	case CompareGt:
+		r.Lo = value
+	case CompareGe:
+		r.Lo, r.LoInclusive = value, true
+	}
+	return r
+}
+
+// CompareValue is Compare with a typed value, encoded for the comparison with v.Encode; the
+// column must hold values of the kind of v. Unlike with Compare, a NULL column or a NULL v never
+// matches, as in SQL.
+func CompareValue(columnIndex int, op CompareOp, v types.Value) func(TupleSlice) bool {
+	if v.IsNull() {
+		return func(TupleSlice) bool { return false }
+	}
+	cond := Compare(columnIndex, op, v.Encode())
+	return func(tup TupleSlice) bool {
+		return columnIndex < len(tup) && len(tup[columnIndex]) != 0 && cond(tup)
+	}
+}
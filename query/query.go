@@ -3,12 +3,22 @@
 package query
 
 import (
+	"bytes"
+	"encoding/binary"
+	"hash/fnv"
+	"math/rand"
 	"sort"
 
 	"github.com/Johniel/gorelly/btree"
 	"github.com/Johniel/gorelly/buffer"
+	"github.com/Johniel/gorelly/catalog"
 	"github.com/Johniel/gorelly/disk"
+	"github.com/Johniel/gorelly/errcode"
+	"github.com/Johniel/gorelly/hll"
+	"github.com/Johniel/gorelly/table"
+	"github.com/Johniel/gorelly/transaction"
 	"github.com/Johniel/gorelly/tuple"
+	"github.com/Johniel/gorelly/types"
 )
 
 // Tuple represents a database record as a slice of byte slices.
@@ -41,7 +51,28 @@ func (tsm TupleSearchMode) Encode() btree.SearchMode {
 	return btree.NewSearchModeKey(keyBytes)
 }
 
+// prefixSearchMode returns the search mode of the keys that start with the values of prefix,
+// in descending key order if reverse. The encoding of the values is a prefix of the encoding of
+// the keys, so the keys lie between the encoded prefix and the next byte string of its length.
+func prefixSearchMode(prefix [][]byte, reverse bool) btree.SearchMode {
+	prefixBytes := make([]byte, 0)
+	tuple.Encode(prefix, &prefixBytes)
+	var end []byte
+	for i := len(prefixBytes) - 1; 0 <= i; i-- {
+		if prefixBytes[i] != 0xff {
+			end = append(bytes.Clone(prefixBytes[:i]), prefixBytes[i]+1)
+			break
+		}
+	}
+	if reverse {
+		return btree.SearchMode{IsStart: end == nil, Key: end, ExcludeKey: end != nil, End: prefixBytes, EndInclusive: true}
+	}
+	return btree.NewSearchModeRange(prefixBytes, true, end, false)
+}
+
 // Executor executes a query plan and produces tuples one at a time.
+// Scan executors keep the B+ tree leaf they are positioned on pinned until Next reports
+// that there are no more tuples, so an executor should be run to completion or closed (see Closer).
 type Executor interface {
 	// Next returns the next tuple from the execution result.
 	// Returns (nil, false, nil) when there are no more tuples.
@@ -54,54 +85,334 @@ type PlanNode interface {
 	Start(bufmgr *buffer.BufferPoolManager) (Executor, error)
 }
 
+// Ordered is implemented by plan nodes that know the order of the tuples they produce.
+// Ordering returns the sort keys the tuples are ordered by, most significant first, or nil
+// if the order is unknown. Sort uses it to return the tuples of its inner plan as they are
+// when its keys are a prefix of the ordering, e.g. ORDER BY on the primary key or an index.
+type Ordered interface {
+	Ordering() []SortKey
+}
+
+// orderingOf returns the ordering of the tuples produced by plan, or nil if it is unknown.
+func orderingOf(plan PlanNode) []SortKey {
+	if o, ok := plan.(Ordered); ok {
+		return o.Ordering()
+	}
+	return nil
+}
+
+// keyOrdering returns the ordering of tuples sorted by the given columns, descending if backward.
+func keyOrdering(columnIndices []int, backward bool) []SortKey {
+	if len(columnIndices) == 0 {
+		return nil
+	}
+	ordering := make([]SortKey, len(columnIndices))
+	for i, colIdx := range columnIndices {
+		ordering[i] = SortKey{ColumnIndex: colIdx, Ascending: !backward}
+	}
+	return ordering
+}
+
+// leadingColumns returns the column indices 0 to n-1.
+func leadingColumns(n int) []int {
+	columnIndices := make([]int, n)
+	for i := range columnIndices {
+		columnIndices[i] = i
+	}
+	return columnIndices
+}
+
+// SchemaVersioned is implemented by executors that read rows from a table.
+// SchemaVersion returns the number of columns the last returned row was stored with.
+// Columns are only ever appended to a table, so the count identifies the schema the row
+// was written under; it is less than the width of the row if trailing columns were padded.
+type SchemaVersioned interface {
+	SchemaVersion() int
+}
+
+// padRow appends the defaults of the trailing columns missing from row, clears the values of
+// the dropped columns, and returns the row together with the number of columns it was stored with.
+func padRow(row [][]byte, defaults [][]byte, dropped []int) ([][]byte, int) {
+	stored := len(row)
+	for i := stored; i < len(defaults); i++ {
+		row = append(row, defaults[i])
+	}
+	for _, i := range dropped {
+		if i < len(row) {
+			row[i] = nil
+		}
+	}
+	return row, stored
+}
+
+// scanStats records a scan in the AccessStats of its table. The zero value, without stats,
+// records nothing.
+type scanStats struct {
+	stats *table.AccessStats
+	seen  btree.PageCounts // Pages of the scan's iterator already recorded
+}
+
+// row records a row returned by the scan.
+func (ss *scanStats) row() {
+	if ss.stats != nil {
+		ss.stats.RowsRead.Add(1)
+	}
+}
+
+// pages records the pages the scan's iterator fetched since the previous call.
+func (ss *scanStats) pages(iter *btree.Iter) {
+	if ss.stats != nil {
+		counts := iter.PageCounts()
+		ss.stats.AddPages(btree.PageCounts{Fetched: counts.Fetched - ss.seen.Fetched, Read: counts.Read - ss.seen.Read})
+		ss.seen = counts
+	}
+}
+
+// lookup records the pages of a point lookup made for the scan.
+func (ss *scanStats) lookup(iter *btree.Iter) {
+	if ss.stats != nil {
+		ss.stats.AddPages(iter.PageCounts())
+	}
+}
+
+// SampleMethod specifies how a sampling scan chooses the tuples it returns.
+type SampleMethod int
+
+const (
+	// SampleMethodBernoulli decides independently for each tuple whether it is returned.
+	SampleMethodBernoulli SampleMethod = iota
+	// SampleMethodSystem decides per leaf page whether all of its tuples are returned.
+	// Skipped pages are not decoded, which makes it cheaper but less uniform than Bernoulli sampling.
+	SampleMethodSystem
+)
+
+// TableSample configures a sampling scan (TABLESAMPLE).
+// Whether a tuple (or page) is returned is derived from the seed and its primary key (or page
+// ID), so scans with the same seed return the same sample of the same data, in either direction.
+type TableSample struct {
+	Method  SampleMethod // Sampling method
+	Percent float64      // Percentage of tuples (or pages) to return, between 0 and 100
+	// Seed of the sample (REPEATABLE), or nil to draw a random seed every time the scan is
+	// started. Options.Seed sets it for every sample of a query.
+	Seed *int64
+}
+
+// seed returns the seed a scan of the sample is started with.
+func (ts *TableSample) seed() uint64 {
+	if ts.Seed != nil {
+		return uint64(*ts.Seed)
+	}
+	return rand.Uint64()
+}
+
+// accept reports whether the tuple or page identified by key is part of the sample with seed.
+func (ts *TableSample) accept(seed uint64, key []byte) bool {
+	h := fnv.New64a()
+	binary.Write(h, binary.BigEndian, seed)
+	h.Write(key)
+	// The finalizer of SplitMix64 spreads the hash over all bits
+	x := h.Sum64()
+	x = (x ^ (x >> 30)) * 0xbf58476d1ce4e5b9
+	x = (x ^ (x >> 27)) * 0x94d049bb133111eb
+	x ^= x >> 31
+	return float64(x>>11)/(1<<53)*100 < ts.Percent
+}
+
 // SeqScan performs a sequential scan on a table.
 // It scans the table starting from SearchMode and continues while WhileCond returns true.
+// If Sample is set, only a random fraction of the scanned tuples is returned.
+// If Backward is set, it scans in descending primary key order, starting from the last key
+// not greater than SearchMode's key (or the end of the table), so WhileCond is a lower bound.
+// If Zones is set, leaf pages whose zones exclude one of ZoneRanges are skipped; the scan still
+// returns rows outside the ranges from the other pages, so it needs a Filter on the same ranges.
 type SeqScan struct {
 	TableMetaPageID disk.PageID           // Page ID of the table's B+ tree meta page
 	SearchMode      TupleSearchMode       // Starting point for the scan
 	WhileCond       func(TupleSlice) bool // Condition to continue scanning
+	Backward        bool                  // Whether to scan in descending key order
+	Sample          *TableSample          // Optional sampling mode (nil scans every tuple)
+	// Optional next-key lock hook, called with every primary key read (including the one that
+	// ends the scan) and with nil when the scan reaches the end of the table.
+	// Keys on pages skipped by SYSTEM sampling or by Zones are not locked.
+	LockKey func(pkeyBytes []byte) error
+	// If set, only the primary key columns are decoded and returned; the values are not read.
+	KeyOnly bool
+	// Number of primary key columns, if known. Project uses it to switch the scan
+	// to KeyOnly automatically when it needs only key columns, and Sort to skip sorting
+	// by the primary key.
+	NumKeyElems int
+	Format      tuple.Format // Row format of the table
+	// Default value of every column of the table (see catalog.TableSchema.Defaults).
+	// Rows written before trailing columns were added are padded with their defaults.
+	Defaults [][]byte
+	// Columns dropped from the table (see catalog.TableSchema.DroppedColumns), returned as NULL.
+	DroppedColumns []int
+	Stats          *table.AccessStats  // Optional access counters of the table the scan is recorded in
+	Zones          *table.ZoneMap      // Optional zone map of the table, used to skip leaf pages
+	ZoneRanges     []table.ColumnRange // Ranges of the rows wanted (see CompareRange)
+	start          []byte              // Encoded key to start from instead of SearchMode (see ParallelSeqScan)
 }
 
 func (ss *SeqScan) Start(bufmgr *buffer.BufferPoolManager) (Executor, error) {
 	bt := btree.NewBTree(ss.TableMetaPageID)
-	tableIter, err := bt.Search(bufmgr, ss.SearchMode.Encode())
+	searchMode := ss.SearchMode.Encode()
+	if ss.start != nil {
+		searchMode = btree.NewSearchModeKey(ss.start)
+	}
+	searchMode.Reverse = ss.Backward
+	tableIter, err := bt.Search(bufmgr, searchMode)
 	if err != nil {
 		return nil, err
 	}
+	if ss.Stats != nil {
+		ss.Stats.SeqScans.Add(1)
+	}
+	var seed uint64
+	if ss.Sample != nil {
+		seed = ss.Sample.seed()
+	}
 	return &ExecSeqScan{
-		tableIter: tableIter,
-		whileCond: ss.WhileCond,
+		tableIter:  tableIter,
+		stats:      scanStats{stats: ss.Stats},
+		whileCond:  ss.WhileCond,
+		sample:     ss.Sample,
+		seed:       seed,
+		zones:      ss.Zones,
+		zoneRanges: ss.ZoneRanges,
+		page:       disk.InvalidPageID,
+		lockKey:    ss.LockKey,
+		keyOnly:    ss.KeyOnly,
+		format:     ss.Format,
+		defaults:   ss.Defaults,
+		dropped:    ss.DroppedColumns,
 	}, nil
 }
 
+// Ordering returns the primary key columns if NumKeyElems is known.
+func (ss *SeqScan) Ordering() []SortKey {
+	return keyOrdering(leadingColumns(ss.NumKeyElems), ss.Backward)
+}
+
 // ExecSeqScan is the executor for sequential scan operations.
 type ExecSeqScan struct {
-	tableIter *btree.Iter
-	whileCond func(TupleSlice) bool
+	tableIter    *btree.Iter
+	whileCond    func(TupleSlice) bool
+	sample       *TableSample
+	seed         uint64 // Seed of the sample
+	zones        *table.ZoneMap
+	zoneRanges   []table.ColumnRange
+	page         disk.PageID // Leaf page the decision whether to skip it was made for
+	pageIncluded bool        // Whether page is scanned
+	lockKey      func([]byte) error
+	keyOnly      bool
+	format       tuple.Format
+	defaults     [][]byte
+	dropped      []int
+	version      int // Number of columns the last returned row was stored with
+	stats        scanStats
 }
 
 func (ess *ExecSeqScan) Next(bufmgr *buffer.BufferPoolManager) (Tuple, bool, error) {
-	pkeyBytes, tupleBytes, ok, err := ess.tableIter.Next(bufmgr)
-	if err != nil {
-		return nil, false, err
+	for {
+		if ess.zones != nil || (ess.sample != nil && ess.sample.Method == SampleMethodSystem) {
+			if err := ess.skipExcludedPages(bufmgr); err != nil {
+				return nil, false, err
+			}
+		}
+		var pkeyBytes, tupleBytes []byte
+		var ok bool
+		var err error
+		if ess.keyOnly {
+			pkeyBytes, ok, err = ess.tableIter.NextKey(bufmgr)
+		} else {
+			pkeyBytes, tupleBytes, ok, err = ess.tableIter.Next(bufmgr)
+		}
+		if err != nil {
+			return nil, false, err
+		}
+		ess.stats.pages(ess.tableIter)
+		if ess.lockKey != nil {
+			if err := ess.lockKey(pkeyBytes); err != nil {
+				return nil, false, err
+			}
+		}
+		if !ok {
+			return nil, false, nil
+		}
+		pkey := make([][]byte, 0)
+		tuple.Decode(pkeyBytes, &pkey)
+		if !ess.whileCond(pkey) {
+			ess.tableIter.Close()
+			return nil, false, nil
+		}
+		if ess.sample != nil && ess.sample.Method == SampleMethodBernoulli && !ess.sample.accept(ess.seed, pkeyBytes) {
+			continue
+		}
+		ess.stats.row()
+		if ess.keyOnly {
+			ess.version = len(pkey)
+			return pkey, true, nil
+		}
+		result := make([][]byte, len(pkey))
+		copy(result, pkey)
+		ess.format.DecodeValue(tupleBytes, &result)
+		result, ess.version = padRow(result, ess.defaults, ess.dropped)
+		return result, true, nil
 	}
-	if !ok {
-		return nil, false, nil
+}
+
+func (ess *ExecSeqScan) SchemaVersion() int {
+	return ess.version
+}
+
+// Close releases the leaf the scan is positioned on.
+func (ess *ExecSeqScan) Close(bufmgr *buffer.BufferPoolManager) error {
+	ess.tableIter.Close()
+	return nil
+}
+
+// skipExcludedPages advances the table iterator past leaf pages that are not part of the sample
+// or whose zones exclude the scanned ranges.
+// The decision is made once per page, when the iterator first enters it.
+func (ess *ExecSeqScan) skipExcludedPages(bufmgr *buffer.BufferPoolManager) error {
+	for {
+		pageID := ess.tableIter.PageID()
+		if pageID != ess.page {
+			ess.page = pageID
+			ess.pageIncluded = ess.includesPage(pageID)
+		}
+		if ess.pageIncluded {
+			return nil
+		}
+		if err := ess.tableIter.SkipPage(bufmgr); err != nil {
+			return err
+		}
+		if ess.tableIter.PageID() == pageID {
+			// No next page: the iterator is at the end position
+			return nil
+		}
 	}
-	pkey := make([][]byte, 0)
-	tuple.Decode(pkeyBytes, &pkey)
-	if !ess.whileCond(pkey) {
-		return nil, false, nil
+}
+
+func (ess *ExecSeqScan) includesPage(pageID disk.PageID) bool {
+	if ess.zones != nil && !ess.zones.MayMatch(pageID, ess.zoneRanges) {
+		return false
 	}
-	result := make([][]byte, len(pkey))
-	copy(result, pkey)
-	tuple.Decode(tupleBytes, &result)
-	return result, true, nil
+	if ess.sample != nil && ess.sample.Method == SampleMethodSystem {
+		return ess.sample.accept(ess.seed, binary.BigEndian.AppendUint64(nil, uint64(pageID)))
+	}
+	return true
 }
 
+// Filter returns the tuples of InnerPlan that satisfy Cond and Predicate, either of which may
+// be nil. Predicate is evaluated against the rows decoded with Schema, and only TRUE holds;
+// unlike with Cond, errors of its evaluation are returned (see ExprCond to ignore them).
 type Filter struct {
 	InnerPlan PlanNode
 	Cond      func(TupleSlice) bool
+	Predicate Expr
+	Schema    *types.Schema // Kinds of the columns of the inner tuples, required with Predicate
+	Context   *EvalContext  // Context Predicate is evaluated in (nil for the default)
 }
 
 func (f *Filter) Start(bufmgr *buffer.BufferPoolManager) (Executor, error) {
@@ -112,13 +423,24 @@ func (f *Filter) Start(bufmgr *buffer.BufferPoolManager) (Executor, error) {
 	return &ExecFilter{
 		innerIter: innerIter,
 		cond:      f.Cond,
+		predicate: f.Predicate,
+		schema:    f.Schema,
+		context:   f.Context,
 	}, nil
 }
 
+// Ordering returns the ordering of the inner plan, which filtering preserves.
+func (f *Filter) Ordering() []SortKey {
+	return orderingOf(f.InnerPlan)
+}
+
 // ExecFilter is the executor for filter operations.
 type ExecFilter struct {
 	innerIter Executor
 	cond      func(TupleSlice) bool
+	predicate Expr
+	schema    *types.Schema
+	context   *EvalContext
 }
 
 func (ef *ExecFilter) Next(bufmgr *buffer.BufferPoolManager) (Tuple, bool, error) {
@@ -130,92 +452,425 @@ func (ef *ExecFilter) Next(bufmgr *buffer.BufferPoolManager) (Tuple, bool, error
 		if !ok {
 			return nil, false, nil
 		}
-		if ef.cond(tuple) {
-			return tuple, true, nil
+		if ef.cond != nil && !ef.cond(tuple) {
+			continue
+		}
+		if ef.predicate != nil {
+			row, err := ef.schema.Decode(tuple)
+			if err != nil {
+				return nil, false, err
+			}
+			holds, err := Holds(ef.context, ef.predicate, row)
+			if err != nil {
+				return nil, false, err
+			}
+			if !holds {
+				continue
+			}
 		}
+		return tuple, true, nil
 	}
 }
 
+func (ef *ExecFilter) Close(bufmgr *buffer.BufferPoolManager) error {
+	return CloseExecutor(bufmgr, ef.innerIter)
+}
+
+// ErrNoSnapshot is returned when starting a FilterVisible without a Snapshot.
+var ErrNoSnapshot = errcode.New(errcode.InvalidTransactionState, "no snapshot to read at")
+
+// FilterVisible returns only the rows of InnerPlan that are visible in a snapshot.
+// Rows carry the encoded IDs of the transactions that wrote and deleted them in the
+// XminColumn and XmaxColumn columns (XmaxColumn is -1 if rows are never soft-deleted).
+// The snapshot is obtained each time the plan starts, so passing
+// TransactionManager.StatementSnapshot gives every statement the snapshot its
+// transaction's isolation level calls for.
+// If Versions is set, a row that is invisible because of an update the snapshot does not see
+// is replaced by the version of the row the snapshot sees, if any, so that readers need not
+// wait for writers.
+type FilterVisible struct {
+	InnerPlan  PlanNode
+	Snapshot   func() *transaction.Snapshot
+	XminColumn int
+	XmaxColumn int
+	Versions   *table.VersionStore // Optional store of the replaced versions of the rows
+}
+
+func (fv *FilterVisible) Start(bufmgr *buffer.BufferPoolManager) (Executor, error) {
+	if fv.Snapshot == nil {
+		return nil, ErrNoSnapshot
+	}
+	snapshot := fv.Snapshot()
+	innerIter, err := fv.InnerPlan.Start(bufmgr)
+	if err != nil {
+		return nil, err
+	}
+	return &ExecFilterVisible{
+		innerIter:  innerIter,
+		snapshot:   snapshot,
+		xminColumn: fv.XminColumn,
+		xmaxColumn: fv.XmaxColumn,
+		versions:   fv.Versions,
+	}, nil
+}
+
+// Ordering returns the ordering of the inner plan, which filtering preserves. Older versions
+// of the rows share only their primary key with the rows they replace, so with Versions only
+// the leading primary key columns of the ordering are kept.
+func (fv *FilterVisible) Ordering() []SortKey {
+	ordering := orderingOf(fv.InnerPlan)
+	if fv.Versions == nil {
+		return ordering
+	}
+	for i, key := range ordering {
+		if fv.Versions.NumKeyElems <= key.ColumnIndex {
+			return ordering[:i]
+		}
+	}
+	return ordering
+}
+
+// ExecFilterVisible is the executor for snapshot visibility filtering.
+type ExecFilterVisible struct {
+	innerIter  Executor
+	snapshot   *transaction.Snapshot
+	xminColumn int
+	xmaxColumn int
+	versions   *table.VersionStore
+}
+
+func (efv *ExecFilterVisible) Next(bufmgr *buffer.BufferPoolManager) (Tuple, bool, error) {
+	for {
+		tup, ok, err := efv.innerIter.Next(bufmgr)
+		if err != nil {
+			return nil, false, err
+		}
+		if !ok {
+			return nil, false, nil
+		}
+		var xmax []byte
+		if 0 <= efv.xmaxColumn && efv.xmaxColumn < len(tup) {
+			xmax = tup[efv.xmaxColumn]
+		}
+		if len(tup) <= efv.xminColumn {
+			continue
+		}
+		if efv.snapshot.RowVisible(tup[efv.xminColumn], xmax) {
+			return tup, true, nil
+		}
+		// A row deleted in the snapshot is gone; only a row whose writer it does not see has an
+		// older version to show
+		if efv.versions != nil && !efv.snapshot.Visible(transaction.TransactionIDFromBytes(tup[efv.xminColumn])) {
+			version, err := efv.versions.Lookup(bufmgr, tup, efv.visible)
+			if err != nil {
+				return nil, false, err
+			}
+			if version != nil {
+				return version, true, nil
+			}
+		}
+	}
+}
+
+// visible reports whether the snapshot sees the changes of the transaction with the encoded ID.
+func (efv *ExecFilterVisible) visible(id []byte) bool {
+	return efv.snapshot.Visible(transaction.TransactionIDFromBytes(id))
+}
+
+func (efv *ExecFilterVisible) Close(bufmgr *buffer.BufferPoolManager) error {
+	return CloseExecutor(bufmgr, efv.innerIter)
+}
+
+// LookupOrder is the order in which a batched IndexScan returns the rows of a batch.
+type LookupOrder int
+
+const (
+	// LookupOrderIndex returns the rows in index order, as without batching; the rows of a
+	// batch are buffered until all of them are looked up.
+	LookupOrderIndex LookupOrder = iota
+	// LookupOrderKey returns the rows in primary key order within each batch, looking each up
+	// only when it is returned.
+	LookupOrderKey
+)
+
+// IndexScan looks up the tuples of a table in index order, starting from SearchMode
+// and continuing while WhileCond returns true for the index key.
+// If Prefix is set, it scans only the entries whose index key starts with the values of Prefix,
+// e.g. the first k columns of a multi-column index, and SearchMode is ignored; WhileCond may
+// then be nil.
+// If Backward is set, it scans in descending index key order, as in SeqScan.
+// If BatchSize is positive, the primary keys of up to BatchSize index entries are collected
+// before their rows are looked up in primary key order, which turns random reads of the table
+// into mostly sequential ones when the scan fetches many rows; the rows of a batch are returned
+// in BatchOrder. Rows missing from the table are skipped instead of ending a batched scan.
 type IndexScan struct {
 	TableMetaPageID disk.PageID
 	IndexMetaPageID disk.PageID
 	SearchMode      TupleSearchMode
 	WhileCond       func(TupleSlice) bool
+	Prefix          [][]byte           // Values of the leading index key columns the scanned entries have (nil for no prefix)
+	Backward        bool               // Whether to scan in descending key order
+	KeyColumns      []int              // Table columns of the index key, if known; lets Sort skip sorting by them
+	Format          tuple.Format       // Row format of the table
+	Defaults        [][]byte           // Default value of every column of the table, as in SeqScan
+	DroppedColumns  []int              // Columns dropped from the table, as in SeqScan
+	Stats           *table.AccessStats // Optional access counters of the table, as in SeqScan
+	BatchSize       int                // Number of index entries whose rows are looked up together (0 for no batching)
+	BatchOrder      LookupOrder        // Order of the rows of a batch
+	// Optional lock hook, called with the primary key of every row looked up before it is read.
+	// Unlike with SeqScan, the gaps between the entries of the index are not locked.
+	LockKey func(pkeyBytes []byte) error
 }
 
 func (is *IndexScan) Start(bufmgr *buffer.BufferPoolManager) (Executor, error) {
 	tableBtree := btree.NewBTree(is.TableMetaPageID)
 	indexBtree := btree.NewBTree(is.IndexMetaPageID)
-	indexIter, err := indexBtree.Search(bufmgr, is.SearchMode.Encode())
+	searchMode := is.SearchMode.Encode()
+	if is.Prefix != nil {
+		searchMode = prefixSearchMode(is.Prefix, is.Backward)
+	}
+	searchMode.Reverse = is.Backward
+	indexIter, err := indexBtree.Search(bufmgr, searchMode)
 	if err != nil {
 		return nil, err
 	}
+	if is.Stats != nil {
+		is.Stats.IndexScans.Add(1)
+	}
 	return &ExecIndexScan{
 		tableBtree: tableBtree,
+		stats:      scanStats{stats: is.Stats},
 		indexIter:  indexIter,
 		whileCond:  is.WhileCond,
+		format:     is.Format,
+		defaults:   is.Defaults,
+		dropped:    is.DroppedColumns,
+		batchSize:  is.BatchSize,
+		batchOrder: is.BatchOrder,
+		lockKey:    is.LockKey,
 	}, nil
 }
 
+// Ordering returns the index key columns if KeyColumns is known, unless batches are returned in
+// primary key order.
+func (is *IndexScan) Ordering() []SortKey {
+	if 0 < is.BatchSize && is.BatchOrder == LookupOrderKey {
+		return nil
+	}
+	return keyOrdering(is.KeyColumns, is.Backward)
+}
+
 // ExecIndexScan is the executor for index scan operations.
 type ExecIndexScan struct {
 	tableBtree *btree.BTree
 	indexIter  *btree.Iter
 	whileCond  func(TupleSlice) bool
+	format     tuple.Format
+	defaults   [][]byte
+	dropped    []int
+	version    int
+	stats      scanStats
+	batchSize  int
+	batchOrder LookupOrder
+	lockKey    func([]byte) error
+	batch      []batchEntry // Entries of the current batch not returned yet
+	exhausted  bool         // Whether the batched scan read its last index entry
+}
+
+// batchEntry is an index entry of a batch of a batched index scan.
+type batchEntry struct {
+	pkeyBytes []byte
+	looked    bool // Whether the row was looked up
+	row       Tuple
+	version   int
 }
 
 func (eis *ExecIndexScan) Next(bufmgr *buffer.BufferPoolManager) (Tuple, bool, error) {
-	skeyBytes, pkeyBytes, ok, err := eis.indexIter.Next(bufmgr)
-	if err != nil {
-		return nil, false, err
+	if eis.batchSize <= 0 {
+		return eis.nextUnbatched(bufmgr)
 	}
-	if !ok {
-		return nil, false, nil
+	for {
+		if len(eis.batch) == 0 {
+			if err := eis.readBatch(bufmgr); err != nil {
+				return nil, false, err
+			}
+			if len(eis.batch) == 0 {
+				return nil, false, nil
+			}
+		}
+		entry := &eis.batch[0]
+		eis.batch = eis.batch[1:]
+		if !entry.looked {
+			row, version, ok, err := eis.lookup(bufmgr, entry.pkeyBytes)
+			if err != nil {
+				return nil, false, err
+			}
+			if ok {
+				entry.row, entry.version = row, version
+			}
+		}
+		if entry.row != nil {
+			eis.version = entry.version
+			return entry.row, true, nil
+		}
+	}
+}
+
+// readBatch reads the next batch of index entries and sorts them by primary key. In index order,
+// their rows are looked up in primary key order right away.
+func (eis *ExecIndexScan) readBatch(bufmgr *buffer.BufferPoolManager) error {
+	eis.batch = make([]batchEntry, 0, eis.batchSize)
+	for !eis.exhausted && len(eis.batch) < eis.batchSize {
+		skeyBytes, pkeyBytes, ok, err := eis.indexIter.Next(bufmgr)
+		if err != nil {
+			return err
+		}
+		eis.stats.pages(eis.indexIter)
+		if !ok {
+			eis.exhausted = true
+			break
+		}
+		if !eis.while(skeyBytes) {
+			eis.indexIter.Close()
+			eis.exhausted = true
+			break
+		}
+		eis.batch = append(eis.batch, batchEntry{pkeyBytes: bytes.Clone(pkeyBytes)})
+	}
+
+	byKey := make([]*batchEntry, len(eis.batch))
+	for i := range eis.batch {
+		byKey[i] = &eis.batch[i]
+	}
+	sort.SliceStable(byKey, func(i, j int) bool { return bytes.Compare(byKey[i].pkeyBytes, byKey[j].pkeyBytes) < 0 })
+	if eis.batchOrder == LookupOrderKey {
+		sorted := make([]batchEntry, len(byKey))
+		for i, entry := range byKey {
+			sorted[i] = *entry
+		}
+		eis.batch = sorted
+		return nil
+	}
+	for _, entry := range byKey {
+		row, version, ok, err := eis.lookup(bufmgr, entry.pkeyBytes)
+		if err != nil {
+			return err
+		}
+		entry.looked = true
+		if ok {
+			entry.row, entry.version = row, version
+		}
+	}
+	return nil
+}
+
+// while reports whether the scan continues with the index entry whose key is skeyBytes.
+func (eis *ExecIndexScan) while(skeyBytes []byte) bool {
+	if eis.whileCond == nil {
+		return true
 	}
 	skey := make([][]byte, 0)
 	tuple.Decode(skeyBytes, &skey)
-	if !eis.whileCond(skey) {
-		return nil, false, nil
+	return eis.whileCond(skey)
+}
+
+// lookup reads the row stored under the primary key from the table.
+func (eis *ExecIndexScan) lookup(bufmgr *buffer.BufferPoolManager, pkeyBytes []byte) (Tuple, int, bool, error) {
+	if eis.lockKey != nil {
+		if err := eis.lockKey(pkeyBytes); err != nil {
+			return nil, 0, false, err
+		}
 	}
 	tableIter, err := eis.tableBtree.Search(bufmgr, btree.NewSearchModeKey(pkeyBytes))
 	if err != nil {
-		return nil, false, err
+		return nil, 0, false, err
 	}
-	_, tupleBytes, ok, err := tableIter.Next(bufmgr)
+	_, tupleBytes, ok := tableIter.Get()
+	tableIter.Close()
+	eis.stats.lookup(tableIter)
+	if !ok {
+		return nil, 0, false, nil
+	}
+	eis.stats.row()
+	result := make([][]byte, 0)
+	tuple.Decode(pkeyBytes, &result)
+	eis.format.DecodeValue(tupleBytes, &result)
+	result, version := padRow(result, eis.defaults, eis.dropped)
+	return result, version, true, nil
+}
+
+func (eis *ExecIndexScan) nextUnbatched(bufmgr *buffer.BufferPoolManager) (Tuple, bool, error) {
+	skeyBytes, pkeyBytes, ok, err := eis.indexIter.Next(bufmgr)
 	if err != nil {
 		return nil, false, err
 	}
+	eis.stats.pages(eis.indexIter)
 	if !ok {
 		return nil, false, nil
 	}
-	result := make([][]byte, 0)
-	tuple.Decode(pkeyBytes, &result)
-	tuple.Decode(tupleBytes, &result)
+	if !eis.while(skeyBytes) {
+		eis.indexIter.Close()
+		return nil, false, nil
+	}
+	result, version, ok, err := eis.lookup(bufmgr, pkeyBytes)
+	if err != nil || !ok {
+		return nil, false, err
+	}
+	eis.version = version
 	return result, true, nil
 }
 
+func (eis *ExecIndexScan) SchemaVersion() int {
+	return eis.version
+}
+
+// Close releases the index leaf the scan is positioned on.
+func (eis *ExecIndexScan) Close(bufmgr *buffer.BufferPoolManager) error {
+	eis.indexIter.Close()
+	eis.batch = nil
+	return nil
+}
+
+// IndexOnlyScan returns the index key columns followed by the primary key columns
+// of the entries of an index, without reading the table.
+// If Backward is set, it scans in descending index key order, as in SeqScan.
 type IndexOnlyScan struct {
 	IndexMetaPageID disk.PageID
 	SearchMode      TupleSearchMode
 	WhileCond       func(TupleSlice) bool
+	Backward        bool               // Whether to scan in descending key order
+	NumKeyElems     int                // Number of index key columns, if known; lets Sort skip sorting by them
+	Stats           *table.AccessStats // Optional access counters of the indexed table, as in SeqScan
 }
 
 func (ios *IndexOnlyScan) Start(bufmgr *buffer.BufferPoolManager) (Executor, error) {
 	bt := btree.NewBTree(ios.IndexMetaPageID)
-	indexIter, err := bt.Search(bufmgr, ios.SearchMode.Encode())
+	searchMode := ios.SearchMode.Encode()
+	searchMode.Reverse = ios.Backward
+	indexIter, err := bt.Search(bufmgr, searchMode)
 	if err != nil {
 		return nil, err
 	}
+	if ios.Stats != nil {
+		ios.Stats.IndexScans.Add(1)
+	}
 	return &ExecIndexOnlyScan{
 		indexIter: indexIter,
 		whileCond: ios.WhileCond,
+		stats:     scanStats{stats: ios.Stats},
 	}, nil
 }
 
+// Ordering returns the index key columns if NumKeyElems is known.
+func (ios *IndexOnlyScan) Ordering() []SortKey {
+	return keyOrdering(leadingColumns(ios.NumKeyElems), ios.Backward)
+}
+
 // ExecIndexOnlyScan is the executor for index-only scan operations.
 type ExecIndexOnlyScan struct {
 	indexIter *btree.Iter
 	whileCond func(TupleSlice) bool
+	stats     scanStats
 }
 
 func (eios *ExecIndexOnlyScan) Next(bufmgr *buffer.BufferPoolManager) (Tuple, bool, error) {
@@ -223,39 +878,325 @@ func (eios *ExecIndexOnlyScan) Next(bufmgr *buffer.BufferPoolManager) (Tuple, bo
 	if err != nil {
 		return nil, false, err
 	}
+	eios.stats.pages(eios.indexIter)
 	if !ok {
 		return nil, false, nil
 	}
 	skey := make([][]byte, 0)
 	tuple.Decode(skeyBytes, &skey)
 	if !eios.whileCond(skey) {
+		eios.indexIter.Close()
 		return nil, false, nil
 	}
+	eios.stats.row()
 	result := make([][]byte, len(skey))
 	copy(result, skey)
 	tuple.Decode(pkeyBytes, &result)
 	return result, true, nil
 }
 
+// Close releases the index leaf the scan is positioned on.
+func (eios *ExecIndexOnlyScan) Close(bufmgr *buffer.BufferPoolManager) error {
+	eios.indexIter.Close()
+	return nil
+}
+
+// TextSearch finds the tuples of a table whose indexed text column contains
+// every term of Query, using an inverted text index.
+// If Phrase is true, the terms must appear consecutively and in order.
+// Tuples are returned in primary key order.
+type TextSearch struct {
+	TableMetaPageID disk.PageID      // Page ID of the table's B+ tree meta page
+	Index           *table.TextIndex // Text index on the table
+	Query           []byte           // Search text, tokenized with the index's tokenizer
+	Phrase          bool             // Whether to match the terms as a phrase
+	Format          tuple.Format     // Row format of the table
+	Defaults        [][]byte         // Default value of every column of the table, as in SeqScan
+	DroppedColumns  []int            // Columns dropped from the table, as in SeqScan
+}
+
+func (ts *TextSearch) Start(bufmgr *buffer.BufferPoolManager) (Executor, error) {
+	pkeys, err := ts.Index.Search(bufmgr, ts.Query, ts.Phrase)
+	if err != nil {
+		return nil, err
+	}
+	return &ExecTextSearch{
+		tableBtree: btree.NewBTree(ts.TableMetaPageID),
+		pkeys:      pkeys,
+		format:     ts.Format,
+		defaults:   ts.Defaults,
+		dropped:    ts.DroppedColumns,
+	}, nil
+}
+
+// ExecTextSearch is the executor for text search operations.
+type ExecTextSearch struct {
+	tableBtree *btree.BTree
+	pkeys      [][]byte // Encoded primary keys of the matching tuples
+	current    int
+	format     tuple.Format
+	defaults   [][]byte
+	dropped    []int
+	version    int
+}
+
+func (ets *ExecTextSearch) Next(bufmgr *buffer.BufferPoolManager) (Tuple, bool, error) {
+	for ets.current < len(ets.pkeys) {
+		pkeyBytes := ets.pkeys[ets.current]
+		ets.current++
+		tableIter, err := ets.tableBtree.Search(bufmgr, btree.NewSearchModeKey(pkeyBytes))
+		if err != nil {
+			return nil, false, err
+		}
+		foundKey, tupleBytes, ok := tableIter.Get()
+		tableIter.Close()
+		if !ok || compareBytes(foundKey, pkeyBytes) != 0 {
+			// Stale posting: the tuple no longer exists
+			continue
+		}
+		result := make([][]byte, 0)
+		tuple.Decode(pkeyBytes, &result)
+		ets.format.DecodeValue(tupleBytes, &result)
+		result, ets.version = padRow(result, ets.defaults, ets.dropped)
+		return result, true, nil
+	}
+	return nil, false, nil
+}
+
+func (ets *ExecTextSearch) SchemaVersion() int {
+	return ets.version
+}
+
+// Close skips the remaining matches; the scan holds no pages between calls to Next.
+func (ets *ExecTextSearch) Close(bufmgr *buffer.BufferPoolManager) error {
+	ets.current = len(ets.pkeys)
+	return nil
+}
+
+// Authorize runs Check before starting InnerPlan and fails the query if it returns an error,
+// e.g. to require the session's role to hold SELECT on the scanned table.
+type Authorize struct {
+	InnerPlan PlanNode
+	Check     func() error
+}
+
+func (a *Authorize) Start(bufmgr *buffer.BufferPoolManager) (Executor, error) {
+	if err := a.Check(); err != nil {
+		return nil, err
+	}
+	return a.InnerPlan.Start(bufmgr)
+}
+
+// Ordering returns the ordering of the inner plan.
+func (a *Authorize) Ordering() []SortKey {
+	return orderingOf(a.InnerPlan)
+}
+
+// ApplyPolicy enforces a table's security policy on the rows produced by InnerPlan:
+// rows hidden by the policy are skipped and masked columns are redacted unless the
+// session holds the required privileges. It must sit directly above the table scan,
+// before any Project, because the policy refers to the table's column indices.
+type ApplyPolicy struct {
+	InnerPlan  PlanNode
+	Policy     *catalog.SecurityPolicy
+	Privileges catalog.Privileges // Privileges of the session running the query
+}
+
+func (ap *ApplyPolicy) Start(bufmgr *buffer.BufferPoolManager) (Executor, error) {
+	innerIter, err := ap.InnerPlan.Start(bufmgr)
+	if err != nil {
+		return nil, err
+	}
+	return &ExecApplyPolicy{
+		innerIter:  innerIter,
+		policy:     ap.Policy,
+		privileges: ap.Privileges,
+	}, nil
+}
+
+type ExecApplyPolicy struct {
+	innerIter  Executor
+	policy     *catalog.SecurityPolicy
+	privileges catalog.Privileges
+}
+
+func (eap *ExecApplyPolicy) Next(bufmgr *buffer.BufferPoolManager) (Tuple, bool, error) {
+	for {
+		tuple, ok, err := eap.innerIter.Next(bufmgr)
+		if err != nil {
+			return nil, false, err
+		}
+		if !ok {
+			return nil, false, nil
+		}
+		if eap.policy == nil {
+			return tuple, true, nil
+		}
+		if visible, ok := eap.policy.Apply(tuple, eap.privileges); ok {
+			return visible, true, nil
+		}
+	}
+}
+
+func (eap *ExecApplyPolicy) Close(bufmgr *buffer.BufferPoolManager) error {
+	return CloseExecutor(bufmgr, eap.innerIter)
+}
+
+// DecryptColumns decrypts the encrypted columns (see catalog.ColumnEncryption) of the rows
+// produced by InnerPlan for a session holding Privileges; columns the session may not decrypt
+// keep their encrypted values. Like ApplyPolicy, it must sit above the table scan before any
+// Project, and below an ApplyPolicy whose policy reads the plain values.
+type DecryptColumns struct {
+	InnerPlan  PlanNode
+	Schema     *catalog.TableSchema
+	Keyring    *catalog.ColumnKeyring
+	Privileges catalog.Privileges // Privileges of the session running the query
+}
+
+func (dc *DecryptColumns) Start(bufmgr *buffer.BufferPoolManager) (Executor, error) {
+	innerIter, err := dc.InnerPlan.Start(bufmgr)
+	if err != nil {
+		return nil, err
+	}
+	return &ExecDecryptColumns{
+		innerIter:  innerIter,
+		schema:     dc.Schema,
+		keyring:    dc.Keyring,
+		privileges: dc.Privileges,
+	}, nil
+}
+
+// Ordering returns the prefix of the inner plan's ordering up to the first encrypted column,
+// since encrypted values are not ordered like the plain ones.
+func (dc *DecryptColumns) Ordering() []SortKey {
+	ordering := orderingOf(dc.InnerPlan)
+	for i, key := range ordering {
+		if key.ColumnIndex < len(dc.Schema.Columns) && dc.Schema.Columns[key.ColumnIndex].Encryption != nil {
+			return ordering[:i]
+		}
+	}
+	return ordering
+}
+
+type ExecDecryptColumns struct {
+	innerIter  Executor
+	schema     *catalog.TableSchema
+	keyring    *catalog.ColumnKeyring
+	privileges catalog.Privileges
+}
+
+func (edc *ExecDecryptColumns) Next(bufmgr *buffer.BufferPoolManager) (Tuple, bool, error) {
+	tuple, ok, err := edc.innerIter.Next(bufmgr)
+	if err != nil || !ok {
+		return nil, false, err
+	}
+	decrypted, err := edc.schema.DecryptRow(edc.keyring, tuple, edc.privileges)
+	if err != nil {
+		return nil, false, err
+	}
+	return decrypted, true, nil
+}
+
+func (edc *ExecDecryptColumns) Close(bufmgr *buffer.BufferPoolManager) error {
+	return CloseExecutor(bufmgr, edc.innerIter)
+}
+
+// Project returns the ColumnIndices columns of the tuples of InnerPlan. If Exprs is set, it
+// returns the values of the expressions instead, evaluated against the rows decoded with Schema.
 type Project struct {
 	InnerPlan     PlanNode
 	ColumnIndices []int
+	Exprs         []Expr
+	Schema        *types.Schema // Kinds of the columns of the inner tuples, required with Exprs
+	Context       *EvalContext  // Context Exprs are evaluated in (nil for the default)
 }
 
 func (p *Project) Start(bufmgr *buffer.BufferPoolManager) (Executor, error) {
-	innerIter, err := p.InnerPlan.Start(bufmgr)
+	innerIter, err := p.keyOnlyPlan().Start(bufmgr)
 	if err != nil {
 		return nil, err
 	}
 	return &ExecProject{
 		innerIter:     innerIter,
 		columnIndices: p.ColumnIndices,
+		exprs:         p.Exprs,
+		schema:        p.Schema,
+		context:       p.Context,
 	}, nil
 }
 
+// readColumns returns the columns of the inner tuples that the projection reads.
+func (p *Project) readColumns() []int {
+	if p.Exprs == nil {
+		return p.ColumnIndices
+	}
+	var columns []int
+	for _, e := range p.Exprs {
+		columns = append(columns, exprColumns(e)...)
+	}
+	return columns
+}
+
+// outputColumns returns the column of the inner tuples that each projected column copies, or
+// -1 for the expressions that are not a column.
+func (p *Project) outputColumns() []int {
+	if p.Exprs == nil {
+		return p.ColumnIndices
+	}
+	columns := make([]int, len(p.Exprs))
+	for i, e := range p.Exprs {
+		columns[i] = -1
+		if col, ok := e.(*ColumnRef); ok {
+			columns[i] = col.Index
+		}
+	}
+	return columns
+}
+
+// keyOnlyPlan returns a key-only copy of the inner plan if it is a SeqScan with a known
+// number of key columns and every projected column is a key column; otherwise the inner plan itself.
+func (p *Project) keyOnlyPlan() PlanNode {
+	scan, ok := p.InnerPlan.(*SeqScan)
+	if !ok || scan.KeyOnly || scan.NumKeyElems <= 0 {
+		return p.InnerPlan
+	}
+	for _, colIdx := range p.readColumns() {
+		if colIdx < 0 || scan.NumKeyElems <= colIdx {
+			return p.InnerPlan
+		}
+	}
+	keyOnly := *scan
+	keyOnly.KeyOnly = true
+	return &keyOnly
+}
+
+// Ordering returns the prefix of the inner plan's ordering whose columns are projected,
+// renumbered to their positions in the projected tuples.
+func (p *Project) Ordering() []SortKey {
+	var ordering []SortKey
+	columns := p.outputColumns()
+	for _, key := range orderingOf(p.InnerPlan) {
+		pos := -1
+		for i, colIdx := range columns {
+			if colIdx == key.ColumnIndex {
+				pos = i
+				break
+			}
+		}
+		if pos < 0 {
+			break
+		}
+		ordering = append(ordering, SortKey{ColumnIndex: pos, Ascending: key.Ascending})
+	}
+	return ordering
+}
+
 type ExecProject struct {
 	innerIter     Executor
 	columnIndices []int
+	exprs         []Expr
+	schema        *types.Schema
+	context       *EvalContext
 }
 
 func (ep *ExecProject) Next(bufmgr *buffer.BufferPoolManager) (Tuple, bool, error) {
@@ -267,6 +1208,22 @@ func (ep *ExecProject) Next(bufmgr *buffer.BufferPoolManager) (Tuple, bool, erro
 		return nil, false, nil
 	}
 
+	if ep.exprs != nil {
+		row, err := ep.schema.Decode(inputTuple)
+		if err != nil {
+			return nil, false, err
+		}
+		result := make([][]byte, len(ep.exprs))
+		for i, e := range ep.exprs {
+			v, err := e.Eval(ep.context, row)
+			if err != nil {
+				return nil, false, err
+			}
+			result[i] = v.Encode()
+		}
+		return result, true, nil
+	}
+
 	result := make([][]byte, len(ep.columnIndices))
 	for i, colIdx := range ep.columnIndices {
 		if colIdx < 0 || colIdx >= len(inputTuple) {
@@ -280,6 +1237,82 @@ func (ep *ExecProject) Next(bufmgr *buffer.BufferPoolManager) (Tuple, bool, erro
 	return result, true, nil
 }
 
+func (ep *ExecProject) Close(bufmgr *buffer.BufferPoolManager) error {
+	return CloseExecutor(bufmgr, ep.innerIter)
+}
+
+// ApproxCountDistinct estimates the number of distinct values of the given columns
+// (APPROX_COUNT_DISTINCT) using a HyperLogLog sketch instead of remembering every value.
+// It emits a single tuple holding the estimate as an 8-byte big-endian integer.
+type ApproxCountDistinct struct {
+	InnerPlan     PlanNode // The inner plan node producing the input tuples
+	ColumnIndices []int    // Columns whose combined value is counted
+	Precision     uint8    // Sketch precision (0 uses hll.DefaultPrecision)
+}
+
+func (acd *ApproxCountDistinct) Start(bufmgr *buffer.BufferPoolManager) (Executor, error) {
+	innerIter, err := acd.InnerPlan.Start(bufmgr)
+	if err != nil {
+		return nil, err
+	}
+	precision := acd.Precision
+	if precision == 0 {
+		precision = hll.DefaultPrecision
+	}
+	return &ExecApproxCountDistinct{
+		innerIter:     innerIter,
+		columnIndices: acd.ColumnIndices,
+		sketch:        hll.NewSketch(precision),
+	}, nil
+}
+
+// ExecApproxCountDistinct is the executor for approximate distinct count operations.
+type ExecApproxCountDistinct struct {
+	innerIter     Executor
+	columnIndices []int
+	sketch        *hll.Sketch
+	done          bool
+}
+
+func (eacd *ExecApproxCountDistinct) Next(bufmgr *buffer.BufferPoolManager) (Tuple, bool, error) {
+	if eacd.done {
+		return nil, false, nil
+	}
+	for {
+		inputTuple, ok, err := eacd.innerIter.Next(bufmgr)
+		if err != nil {
+			return nil, false, err
+		}
+		if !ok {
+			break
+		}
+		eacd.sketch.Add(distinctKey(inputTuple, eacd.columnIndices))
+	}
+	eacd.done = true
+	count := make([]byte, 8)
+	binary.BigEndian.PutUint64(count, eacd.sketch.Estimate())
+	return Tuple{count}, true, nil
+}
+
+func (eacd *ExecApproxCountDistinct) Close(bufmgr *buffer.BufferPoolManager) error {
+	eacd.done = true
+	return CloseExecutor(bufmgr, eacd.innerIter)
+}
+
+// distinctKey encodes the given columns of a tuple into a single byte sequence.
+// The memcmpable encoding keeps column boundaries unambiguous, so ("ab", "c") and ("a", "bc") differ.
+func distinctKey(tup Tuple, columnIndices []int) []byte {
+	elems := make([][]byte, len(columnIndices))
+	for i, colIdx := range columnIndices {
+		if 0 <= colIdx && colIdx < len(tup) {
+			elems[i] = tup[colIdx]
+		}
+	}
+	key := make([]byte, 0)
+	tuple.Encode(elems, &key)
+	return key
+}
+
 // SortKey specifies a column to sort by and the sort direction.
 type SortKey struct {
 	ColumnIndex int  // Index of the column to sort by (0-based)
@@ -289,12 +1322,18 @@ type SortKey struct {
 // Sort performs sorting on tuples from an inner plan.
 // It sorts tuples based on the specified sort keys.
 // Multiple sort keys can be specified for multi-column sorting.
+// If the inner plan is Ordered and the sort keys are a prefix of its ordering, e.g. for ORDER BY
+// on the primary key or an index scanned Backward for descending keys, the tuples are already
+// in order and are returned as the inner plan produces them, without being read up front.
 type Sort struct {
 	InnerPlan PlanNode  // The inner plan node to sort
 	SortKeys  []SortKey // Sort keys specifying columns and sort directions
 }
 
 func (s *Sort) Start(bufmgr *buffer.BufferPoolManager) (Executor, error) {
+	if s.Presorted() {
+		return s.InnerPlan.Start(bufmgr)
+	}
 	innerIter, err := s.InnerPlan.Start(bufmgr)
 	if err != nil {
 		return nil, err
@@ -305,7 +1344,7 @@ func (s *Sort) Start(bufmgr *buffer.BufferPoolManager) (Executor, error) {
 	for {
 		tup, ok, err := innerIter.Next(bufmgr)
 		if err != nil {
-			return nil, err
+			return nil, closeOnError(bufmgr, innerIter, err)
 		}
 		if !ok {
 			break
@@ -330,6 +1369,26 @@ func (s *Sort) Start(bufmgr *buffer.BufferPoolManager) (Executor, error) {
 	}, nil
 }
 
+// Presorted reports whether the inner plan already produces the tuples in the order of the sort keys,
+// so that Start does not sort them.
+func (s *Sort) Presorted() bool {
+	ordering := orderingOf(s.InnerPlan)
+	if len(ordering) < len(s.SortKeys) {
+		return false
+	}
+	for i, key := range s.SortKeys {
+		if ordering[i] != key {
+			return false
+		}
+	}
+	return true
+}
+
+// Ordering returns the sort keys.
+func (s *Sort) Ordering() []SortKey {
+	return s.SortKeys
+}
+
 // ExecSort is the executor for sort operations.
 type ExecSort struct {
 	tuples  []Tuple // Sorted tuples
@@ -353,6 +1412,11 @@ func (es *ExecSort) Next(bufmgr *buffer.BufferPoolManager) (Tuple, bool, error)
 	return result, true, nil
 }
 
+func (es *ExecSort) Close(bufmgr *buffer.BufferPoolManager) error {
+	es.current = len(es.tuples)
+	return nil
+}
+
 // compareTuples compares two tuples based on the specified sort keys.
 // Returns -1 if a < b, 0 if a == b, 1 if a > b.
 func compareTuples(a, b Tuple, sortKeys []SortKey) int {
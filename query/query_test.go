@@ -1,13 +1,23 @@
 package query
 
 import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
 	"os"
 	"reflect"
+	"slices"
+	"sort"
 	"testing"
+	"time"
 
 	"github.com/Johniel/gorelly/buffer"
+	"github.com/Johniel/gorelly/catalog"
 	"github.com/Johniel/gorelly/disk"
 	"github.com/Johniel/gorelly/table"
+	"github.com/Johniel/gorelly/transaction"
+	"github.com/Johniel/gorelly/tuple"
+	"github.com/Johniel/gorelly/types"
 )
 
 func TestProject(t *testing.T) {
@@ -662,3 +672,1695 @@ func TestSort(t *testing.T) {
 		}
 	})
 }
+
+func TestSeqScanSample(t *testing.T) {
+	tmpfile, err := os.CreateTemp("", "test_seq_scan_sample_*.db")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(tmpfile.Name())
+
+	dm, err := disk.NewDiskManager(tmpfile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer dm.Close()
+
+	pool := buffer.NewBufferPool(10)
+	bufmgr := buffer.NewBufferPoolManager(dm, pool)
+
+	simpleTable := &table.SimpleTable{
+		MetaPageID:  disk.InvalidPageID,
+		NumKeyElems: 1,
+	}
+	if err := simpleTable.Create(bufmgr); err != nil {
+		t.Fatal(err)
+	}
+
+	const numTuples = 2000
+	for i := 0; i < numTuples; i++ {
+		key := []byte(fmt.Sprintf("%05d", i))
+		if err := simpleTable.Insert(bufmgr, [][]byte{key, []byte("value")}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	count := func(sample *TableSample) int {
+		scan := &SeqScan{
+			TableMetaPageID: simpleTable.MetaPageID,
+			SearchMode:      NewTupleSearchModeStart(),
+			WhileCond: func(pkey [][]byte) bool {
+				return true
+			},
+			Sample: sample,
+		}
+		executor, err := scan.Start(bufmgr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		n := 0
+		var prev string
+		for {
+			tup, ok, err := executor.Next(bufmgr)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !ok {
+				break
+			}
+			if string(tup[0]) <= prev {
+				t.Fatalf("Sampled tuples out of order: %s after %s", tup[0], prev)
+			}
+			prev = string(tup[0])
+			n++
+		}
+		return n
+	}
+
+	for _, method := range []SampleMethod{SampleMethodBernoulli, SampleMethodSystem} {
+		if n := count(&TableSample{Method: method, Percent: 0}); n != 0 {
+			t.Errorf("Method %d, 0%%: expected no tuples, got %d", method, n)
+		}
+		if n := count(&TableSample{Method: method, Percent: 100}); n != numTuples {
+			t.Errorf("Method %d, 100%%: expected %d tuples, got %d", method, numTuples, n)
+		}
+	}
+
+	if n := count(&TableSample{Method: SampleMethodBernoulli, Percent: 50}); n < numTuples/4 || numTuples*3/4 < n {
+		t.Errorf("Bernoulli 50%%: got %d of %d tuples", n, numTuples)
+	}
+	if n := count(&TableSample{Method: SampleMethodSystem, Percent: 50}); n == numTuples {
+		t.Errorf("System 50%%: expected some pages to be skipped")
+	}
+	if n := count(nil); n != numTuples {
+		t.Errorf("No sampling: expected %d tuples, got %d", numTuples, n)
+	}
+}
+
+func TestSeqScanSampleSeed(t *testing.T) {
+	tmpfile, err := os.CreateTemp("", "test_seq_scan_sample_seed_*.db")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(tmpfile.Name())
+
+	dm, err := disk.NewDiskManager(tmpfile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer dm.Close()
+
+	pool := buffer.NewBufferPool(10)
+	bufmgr := buffer.NewBufferPoolManager(dm, pool)
+
+	simpleTable := &table.SimpleTable{
+		MetaPageID:  disk.InvalidPageID,
+		NumKeyElems: 1,
+	}
+	if err := simpleTable.Create(bufmgr); err != nil {
+		t.Fatal(err)
+	}
+	const numTuples = 2000
+	for i := 0; i < numTuples; i++ {
+		key := []byte(fmt.Sprintf("%05d", i))
+		if err := simpleTable.Insert(bufmgr, [][]byte{key, []byte("value")}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// sampleKeys returns the sorted keys of a sample, scanned through a Filter
+	sampleKeys := func(sample *TableSample, backward bool, opts Options) []string {
+		t.Helper()
+		plan := &Filter{
+			InnerPlan: &SeqScan{
+				TableMetaPageID: simpleTable.MetaPageID,
+				SearchMode:      NewTupleSearchModeStart(),
+				WhileCond:       func(TupleSlice) bool { return true },
+				Backward:        backward,
+				Sample:          sample,
+			},
+			Cond: func(TupleSlice) bool { return true },
+		}
+		executor, err := StartWithOptions(bufmgr, plan, opts)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var keys []string
+		for {
+			tup, ok, err := executor.Next(bufmgr)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !ok {
+				break
+			}
+			keys = append(keys, string(tup[0]))
+		}
+		sort.Strings(keys)
+		return keys
+	}
+	seed := func(seed int64) *int64 { return &seed }
+
+	for _, method := range []SampleMethod{SampleMethodBernoulli, SampleMethodSystem} {
+		sample := &TableSample{Method: method, Percent: 30}
+		first := sampleKeys(sample, false, Options{Seed: seed(42)})
+		if len(first) == 0 || len(first) == numTuples {
+			t.Fatalf("Method %d: expected a partial sample, got %d tuples", method, len(first))
+		}
+		if sample.Seed != nil {
+			t.Errorf("Method %d: expected the query options to leave the shared sample alone", method)
+		}
+		if again := sampleKeys(sample, false, Options{Seed: seed(42)}); !reflect.DeepEqual(first, again) {
+			t.Errorf("Method %d: expected the same seed to return the same sample", method)
+		}
+		if other := sampleKeys(sample, false, Options{Seed: seed(7)}); reflect.DeepEqual(first, other) {
+			t.Errorf("Method %d: expected another seed to return another sample", method)
+		}
+		// A sample's own seed (REPEATABLE) takes precedence, and holds in either direction
+		repeatable := &TableSample{Method: method, Percent: 30, Seed: seed(42)}
+		if got := sampleKeys(repeatable, true, Options{Seed: seed(7)}); !reflect.DeepEqual(first, got) {
+			t.Errorf("Method %d: expected a backward scan with the sample's seed to return the same sample", method)
+		}
+	}
+}
+
+func TestSeqScanZones(t *testing.T) {
+	tmpfile, err := os.CreateTemp("", "test_seq_scan_zones_*.db")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(tmpfile.Name())
+
+	dm, err := disk.NewDiskManager(tmpfile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer dm.Close()
+
+	pool := buffer.NewBufferPool(10)
+	bufmgr := buffer.NewBufferPoolManager(dm, pool)
+
+	// Schema: [id, ts, payload], with ts growing with id
+	tbl := &table.Table{
+		MetaPageID:  disk.InvalidPageID,
+		NumKeyElems: 1,
+	}
+	if err := tbl.Create(bufmgr); err != nil {
+		t.Fatal(err)
+	}
+	tbl.Zones = table.NewZoneMap(tbl, 1)
+	for i := 0; i < 1000; i++ {
+		row := [][]byte{[]byte(fmt.Sprintf("%04d", i)), []byte(fmt.Sprintf("%06d", i*10)), bytes.Repeat([]byte("x"), 40)}
+		if err := tbl.Insert(bufmgr, row); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// WHERE ts BETWEEN '005000' AND '005100'
+	lo, hi := []byte("005000"), []byte("005100")
+	run := func(backward bool, zones *table.ZoneMap) (ids []string, scanned int) {
+		t.Helper()
+		scan := &SeqScan{
+			TableMetaPageID: tbl.MetaPageID,
+			SearchMode:      NewTupleSearchModeStart(),
+			WhileCond: func(pkey TupleSlice) bool {
+				return true
+			},
+			Backward:   backward,
+			Zones:      zones,
+			ZoneRanges: []table.ColumnRange{CompareRange(1, CompareGe, lo), CompareRange(1, CompareLe, hi)},
+		}
+		executor, err := scan.Start(bufmgr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		ge, le := Compare(1, CompareGe, lo), Compare(1, CompareLe, hi)
+		for {
+			tup, ok, err := executor.Next(bufmgr)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !ok {
+				break
+			}
+			scanned++
+			if ge(tup) && le(tup) {
+				ids = append(ids, string(tup[0]))
+			}
+		}
+		return ids, scanned
+	}
+
+	for _, backward := range []bool{false, true} {
+		expected, all := run(backward, nil)
+		if len(expected) != 11 || all != 1000 {
+			t.Fatalf("backward=%v: expected 11 of 1000 rows without zones, got %d of %d", backward, len(expected), all)
+		}
+		got, scanned := run(backward, tbl.Zones)
+		if !reflect.DeepEqual(got, expected) {
+			t.Errorf("backward=%v: expected %v, got %v", backward, expected, got)
+		}
+		if all/4 < scanned {
+			t.Errorf("backward=%v: expected the zones to skip most pages, scanned %d of %d rows", backward, scanned, all)
+		}
+	}
+}
+
+func TestApproxCountDistinct(t *testing.T) {
+	tmpfile, err := os.CreateTemp("", "test_approx_count_distinct_*.db")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(tmpfile.Name())
+
+	dm, err := disk.NewDiskManager(tmpfile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer dm.Close()
+
+	pool := buffer.NewBufferPool(10)
+	bufmgr := buffer.NewBufferPoolManager(dm, pool)
+
+	simpleTable := &table.SimpleTable{
+		MetaPageID:  disk.InvalidPageID,
+		NumKeyElems: 1,
+	}
+	if err := simpleTable.Create(bufmgr); err != nil {
+		t.Fatal(err)
+	}
+
+	// Insert test data: [id, group] with 1000 rows spread over 100 groups
+	for i := 0; i < 1000; i++ {
+		tup := [][]byte{[]byte(fmt.Sprintf("%04d", i)), []byte(fmt.Sprintf("group%d", i%100))}
+		if err := simpleTable.Insert(bufmgr, tup); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tests := []struct {
+		name          string
+		columnIndices []int
+		expected      float64
+	}{
+		{"PrimaryKey", []int{0}, 1000},
+		{"Group", []int{1}, 100},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			plan := &ApproxCountDistinct{
+				InnerPlan: &SeqScan{
+					TableMetaPageID: simpleTable.MetaPageID,
+					SearchMode:      NewTupleSearchModeStart(),
+					WhileCond: func(pkey [][]byte) bool {
+						return true
+					},
+				},
+				ColumnIndices: tt.columnIndices,
+			}
+			executor, err := plan.Start(bufmgr)
+			if err != nil {
+				t.Fatal(err)
+			}
+			tup, ok, err := executor.Next(bufmgr)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !ok {
+				t.Fatal("Expected a result tuple")
+			}
+			estimate := float64(binary.BigEndian.Uint64(tup[0]))
+			if estimate < tt.expected*0.95 || tt.expected*1.05 < estimate {
+				t.Errorf("Expected about %v distinct values, got %v", tt.expected, estimate)
+			}
+			if _, ok, _ := executor.Next(bufmgr); ok {
+				t.Error("Expected a single result tuple")
+			}
+		})
+	}
+}
+
+func TestTextSearch(t *testing.T) {
+	tmpfile, err := os.CreateTemp("", "test_text_search_*.db")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(tmpfile.Name())
+
+	dm, err := disk.NewDiskManager(tmpfile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer dm.Close()
+
+	pool := buffer.NewBufferPool(10)
+	bufmgr := buffer.NewBufferPoolManager(dm, pool)
+
+	// Schema: [id, title, body]
+	textIndex := &table.TextIndex{MetaPageID: disk.InvalidPageID, Column: 2}
+	tbl := &table.Table{
+		MetaPageID:  disk.InvalidPageID,
+		NumKeyElems: 1,
+		TextIndices: []*table.TextIndex{textIndex},
+	}
+	if err := tbl.Create(bufmgr); err != nil {
+		t.Fatal(err)
+	}
+
+	tuples := [][][]byte{
+		{[]byte("1"), []byte("Intro"), []byte("B+ trees store sorted keys")},
+		{[]byte("2"), []byte("Buffers"), []byte("The buffer pool caches pages")},
+		{[]byte("3"), []byte("Leaves"), []byte("Leaf pages store keys and values")},
+	}
+	for _, tup := range tuples {
+		if err := tbl.Insert(bufmgr, tup); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	search := &TextSearch{
+		TableMetaPageID: tbl.MetaPageID,
+		Index:           textIndex,
+		Query:           []byte("store keys"),
+	}
+	executor, err := search.Start(bufmgr)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var results []Tuple
+	for {
+		tup, ok, err := executor.Next(bufmgr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !ok {
+			break
+		}
+		results = append(results, tup)
+	}
+
+	expected := []Tuple{tuples[0], tuples[2]}
+	if !reflect.DeepEqual(results, expected) {
+		t.Errorf("Expected %v, got %v", expected, results)
+	}
+}
+
+func TestTimestampRangeScan(t *testing.T) {
+	tmpfile, err := os.CreateTemp("", "test_timestamp_range_scan_*.db")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(tmpfile.Name())
+
+	dm, err := disk.NewDiskManager(tmpfile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer dm.Close()
+
+	pool := buffer.NewBufferPool(10)
+	bufmgr := buffer.NewBufferPoolManager(dm, pool)
+
+	simpleTable := &table.SimpleTable{
+		MetaPageID:  disk.InvalidPageID,
+		NumKeyElems: 1,
+	}
+	if err := simpleTable.Create(bufmgr); err != nil {
+		t.Fatal(err)
+	}
+
+	// Schema: [recorded_at (TIMESTAMP), event]
+	base := time.Date(1969, 12, 31, 22, 0, 0, 0, time.UTC)
+	for i := 0; i < 6; i++ {
+		ts := types.EncodeTimestamp(base.Add(time.Duration(i) * time.Hour))
+		if err := simpleTable.Insert(bufmgr, [][]byte{ts, []byte(fmt.Sprintf("event%d", i))}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// recorded_at >= base + 1h AND recorded_at < base + 1h + 3h
+	from := types.EncodeTimestamp(base.Add(time.Hour))
+	to, err := types.AddInterval(from, types.EncodeInterval(3*time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+	scan := &SeqScan{
+		TableMetaPageID: simpleTable.MetaPageID,
+		SearchMode:      NewTupleSearchModeKey([][]byte{from}),
+		WhileCond: func(pkey [][]byte) bool {
+			return compareBytes(pkey[0], to) < 0
+		},
+	}
+	executor, err := scan.Start(bufmgr)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var events []string
+	for {
+		tup, ok, err := executor.Next(bufmgr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !ok {
+			break
+		}
+		events = append(events, string(tup[1]))
+	}
+	expected := []string{"event1", "event2", "event3"}
+	if !reflect.DeepEqual(events, expected) {
+		t.Errorf("Expected %v, got %v", expected, events)
+	}
+}
+
+func TestDecryptColumns(t *testing.T) {
+	tmpfile, err := os.CreateTemp("", "test_decrypt_columns_*.db")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(tmpfile.Name())
+
+	dm, err := disk.NewDiskManager(tmpfile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer dm.Close()
+
+	pool := buffer.NewBufferPool(10)
+	bufmgr := buffer.NewBufferPoolManager(dm, pool)
+
+	simpleTable := &table.SimpleTable{
+		MetaPageID:  disk.InvalidPageID,
+		NumKeyElems: 1,
+	}
+	if err := simpleTable.Create(bufmgr); err != nil {
+		t.Fatal(err)
+	}
+	keyring := catalog.NewColumnKeyring()
+	if err := keyring.AddKey(1, bytes.Repeat([]byte{7}, 16)); err != nil {
+		t.Fatal(err)
+	}
+	const privilegePII = catalog.PrivilegeDelete << 1
+	schema := &catalog.TableSchema{
+		TableID:     1,
+		NumKeyElems: 1,
+		Columns: []catalog.ColumnDef{
+			{Name: "id", Type: catalog.ColumnTypeVarchar, IsPrimaryKey: true},
+			{Name: "email", Type: catalog.ColumnTypeVarchar, Encryption: &catalog.ColumnEncryption{KeyID: 1, Deterministic: true, Decrypt: privilegePII}},
+		},
+	}
+	for _, row := range [][][]byte{
+		{[]byte("1"), []byte("alice@example.com")},
+		{[]byte("2"), []byte("bob@example.com")},
+	} {
+		stored, err := schema.EncryptRow(keyring, row)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := simpleTable.Insert(bufmgr, stored); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// The equality predicate compares the encrypted values
+	lookup, err := schema.EncryptValue(keyring, 1, []byte("bob@example.com"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	run := func(privs catalog.Privileges) []string {
+		plan := &Project{
+			InnerPlan: &DecryptColumns{
+				InnerPlan: &Filter{
+					InnerPlan: &SeqScan{
+						TableMetaPageID: simpleTable.MetaPageID,
+						SearchMode:      NewTupleSearchModeStart(),
+						WhileCond:       func(TupleSlice) bool { return true },
+					},
+					Cond: func(tup TupleSlice) bool { return bytes.Equal(tup[1], lookup) },
+				},
+				Schema:     schema,
+				Keyring:    keyring,
+				Privileges: privs,
+			},
+			ColumnIndices: []int{1},
+		}
+		executor, err := plan.Start(bufmgr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var results []string
+		for {
+			tup, ok, err := executor.Next(bufmgr)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !ok {
+				break
+			}
+			results = append(results, string(tup[0]))
+		}
+		return results
+	}
+
+	if results := run(privilegePII); !reflect.DeepEqual(results, []string{"bob@example.com"}) {
+		t.Errorf("Expected the matching row decrypted, got %v", results)
+	}
+	if results := run(0); len(results) != 1 || results[0] != string(lookup) {
+		t.Errorf("Expected the matching row encrypted without privileges, got %q", results)
+	}
+}
+
+func TestApplyPolicy(t *testing.T) {
+	tmpfile, err := os.CreateTemp("", "test_apply_policy_*.db")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(tmpfile.Name())
+
+	dm, err := disk.NewDiskManager(tmpfile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer dm.Close()
+
+	pool := buffer.NewBufferPool(10)
+	bufmgr := buffer.NewBufferPoolManager(dm, pool)
+
+	simpleTable := &table.SimpleTable{
+		MetaPageID:  disk.InvalidPageID,
+		NumKeyElems: 1,
+	}
+	if err := simpleTable.Create(bufmgr); err != nil {
+		t.Fatal(err)
+	}
+
+	// Schema: [id, name, email, tenant]
+	rows := [][][]byte{
+		{[]byte("1"), []byte("alice"), []byte("alice@example.com"), []byte("a")},
+		{[]byte("2"), []byte("bob"), []byte("bob@example.com"), []byte("b")},
+		{[]byte("3"), []byte("carol"), []byte("carol@example.com"), []byte("a")},
+	}
+	for _, row := range rows {
+		if err := simpleTable.Insert(bufmgr, row); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	const (
+		privilegeAllTenants = catalog.PrivilegeDelete << (1 + iota)
+		privilegePII
+	)
+	policy := &catalog.SecurityPolicy{
+		RowFilter:       func(tup [][]byte) bool { return string(tup[3]) == "a" },
+		RowFilterExempt: privilegeAllTenants,
+		ColumnMasks: []catalog.ColumnMask{
+			{
+				ColumnIndex: 2,
+				Mask:        func([]byte) []byte { return []byte("***") },
+				Unmasked:    privilegePII,
+			},
+		},
+	}
+
+	run := func(privs catalog.Privileges) []string {
+		plan := &Project{
+			InnerPlan: &ApplyPolicy{
+				InnerPlan: &SeqScan{
+					TableMetaPageID: simpleTable.MetaPageID,
+					SearchMode:      NewTupleSearchModeStart(),
+					WhileCond:       func(TupleSlice) bool { return true },
+				},
+				Policy:     policy,
+				Privileges: privs,
+			},
+			ColumnIndices: []int{1, 2},
+		}
+		executor, err := plan.Start(bufmgr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var results []string
+		for {
+			tup, ok, err := executor.Next(bufmgr)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !ok {
+				break
+			}
+			results = append(results, string(tup[0])+":"+string(tup[1]))
+		}
+		return results
+	}
+
+	t.Run("NoPrivileges", func(t *testing.T) {
+		expected := []string{"alice:***", "carol:***"}
+		if results := run(0); !reflect.DeepEqual(results, expected) {
+			t.Errorf("Expected %v, got %v", expected, results)
+		}
+	})
+
+	t.Run("PII", func(t *testing.T) {
+		expected := []string{"alice:alice@example.com", "carol:carol@example.com"}
+		if results := run(privilegePII); !reflect.DeepEqual(results, expected) {
+			t.Errorf("Expected %v, got %v", expected, results)
+		}
+	})
+
+	t.Run("AllTenants", func(t *testing.T) {
+		expected := []string{"alice:***", "bob:***", "carol:***"}
+		if results := run(privilegeAllTenants); !reflect.DeepEqual(results, expected) {
+			t.Errorf("Expected %v, got %v", expected, results)
+		}
+	})
+
+	t.Run("SourceUnchanged", func(t *testing.T) {
+		expected := []string{"alice:alice@example.com", "bob:bob@example.com", "carol:carol@example.com"}
+		if results := run(privilegeAllTenants | privilegePII); !reflect.DeepEqual(results, expected) {
+			t.Errorf("Expected %v, got %v", expected, results)
+		}
+	})
+}
+
+func TestAuthorize(t *testing.T) {
+	tmpfile, err := os.CreateTemp("", "test_authorize_*.db")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(tmpfile.Name())
+
+	dm, err := disk.NewDiskManager(tmpfile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer dm.Close()
+
+	pool := buffer.NewBufferPool(10)
+	bufmgr := buffer.NewBufferPoolManager(dm, pool)
+
+	cm, err := catalog.NewCatalogManager(bufmgr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	schema, err := cm.CreateTable("items", []catalog.ColumnDef{
+		{Name: "id", Type: catalog.ColumnTypeInt, IsPrimaryKey: true},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := cm.CreateRole("reader", false); err != nil {
+		t.Fatal(err)
+	}
+
+	plan := &Authorize{
+		InnerPlan: &SeqScan{
+			TableMetaPageID: schema.MetaPageID,
+			SearchMode:      NewTupleSearchModeStart(),
+			WhileCond:       func(TupleSlice) bool { return true },
+		},
+		Check: func() error { return cm.CheckPrivileges("reader", "items", catalog.PrivilegeSelect) },
+	}
+	if _, err := plan.Start(bufmgr); err != catalog.ErrPermissionDenied {
+		t.Errorf("Expected ErrPermissionDenied, got %v", err)
+	}
+	if err := cm.Grant("reader", "items", catalog.PrivilegeSelect); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := plan.Start(bufmgr); err != nil {
+		t.Errorf("Expected the query to start, got %v", err)
+	}
+}
+
+func TestSeqScanLockKey(t *testing.T) {
+	tmpfile, err := os.CreateTemp("", "test_seq_scan_lock_key_*.db")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(tmpfile.Name())
+
+	dm, err := disk.NewDiskManager(tmpfile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer dm.Close()
+
+	pool := buffer.NewBufferPool(10)
+	bufmgr := buffer.NewBufferPoolManager(dm, pool)
+
+	simpleTable := &table.SimpleTable{
+		MetaPageID:  disk.InvalidPageID,
+		NumKeyElems: 1,
+	}
+	if err := simpleTable.Create(bufmgr); err != nil {
+		t.Fatal(err)
+	}
+	for _, id := range []string{"a", "b", "c"} {
+		if err := simpleTable.Insert(bufmgr, [][]byte{[]byte(id)}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	scan := func(whileCond func(TupleSlice) bool) []string {
+		var locked []string
+		plan := &SeqScan{
+			TableMetaPageID: simpleTable.MetaPageID,
+			SearchMode:      NewTupleSearchModeStart(),
+			WhileCond:       whileCond,
+			LockKey: func(pkeyBytes []byte) error {
+				if pkeyBytes == nil {
+					locked = append(locked, "supremum")
+					return nil
+				}
+				var pkey [][]byte
+				tuple.Decode(pkeyBytes, &pkey)
+				locked = append(locked, string(pkey[0]))
+				return nil
+			},
+		}
+		executor, err := plan.Start(bufmgr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for {
+			_, ok, err := executor.Next(bufmgr)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !ok {
+				break
+			}
+		}
+		return locked
+	}
+
+	// The key that ends the scan is locked too, covering the gap after the last returned row
+	locked := scan(func(pkey TupleSlice) bool { return string(pkey[0]) < "b" })
+	if expected := []string{"a", "b"}; !reflect.DeepEqual(locked, expected) {
+		t.Errorf("Expected %v, got %v", expected, locked)
+	}
+	locked = scan(func(TupleSlice) bool { return true })
+	if expected := []string{"a", "b", "c", "supremum"}; !reflect.DeepEqual(locked, expected) {
+		t.Errorf("Expected %v, got %v", expected, locked)
+	}
+}
+
+func TestSeqScanKeyOnly(t *testing.T) {
+	tmpfile, err := os.CreateTemp("", "test_seq_scan_key_only_*.db")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(tmpfile.Name())
+
+	dm, err := disk.NewDiskManager(tmpfile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer dm.Close()
+
+	pool := buffer.NewBufferPool(10)
+	bufmgr := buffer.NewBufferPoolManager(dm, pool)
+
+	simpleTable := &table.SimpleTable{
+		MetaPageID:  disk.InvalidPageID,
+		NumKeyElems: 1,
+	}
+	if err := simpleTable.Create(bufmgr); err != nil {
+		t.Fatal(err)
+	}
+	for _, row := range [][][]byte{
+		{[]byte("a"), []byte("Alice")},
+		{[]byte("b"), []byte("Bob")},
+	} {
+		if err := simpleTable.Insert(bufmgr, row); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	collect := func(plan PlanNode) [][][]byte {
+		executor, err := plan.Start(bufmgr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var results [][][]byte
+		for {
+			tup, ok, err := executor.Next(bufmgr)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !ok {
+				return results
+			}
+			results = append(results, tup)
+		}
+	}
+	newScan := func() *SeqScan {
+		return &SeqScan{
+			TableMetaPageID: simpleTable.MetaPageID,
+			SearchMode:      NewTupleSearchModeStart(),
+			WhileCond:       func(TupleSlice) bool { return true },
+			NumKeyElems:     1,
+		}
+	}
+
+	t.Run("KeyOnly", func(t *testing.T) {
+		scan := newScan()
+		scan.KeyOnly = true
+		expected := [][][]byte{{[]byte("a")}, {[]byte("b")}}
+		if results := collect(scan); !reflect.DeepEqual(results, expected) {
+			t.Errorf("Expected %q, got %q", expected, results)
+		}
+	})
+
+	t.Run("ProjectKeyColumns", func(t *testing.T) {
+		project := &Project{InnerPlan: newScan(), ColumnIndices: []int{0}}
+		if scan, ok := project.keyOnlyPlan().(*SeqScan); !ok || !scan.KeyOnly {
+			t.Error("Expected a key-only scan when only key columns are projected")
+		}
+		if project.InnerPlan.(*SeqScan).KeyOnly {
+			t.Error("Expected the inner plan to be left unchanged")
+		}
+		expected := [][][]byte{{[]byte("a")}, {[]byte("b")}}
+		if results := collect(project); !reflect.DeepEqual(results, expected) {
+			t.Errorf("Expected %q, got %q", expected, results)
+		}
+	})
+
+	t.Run("ProjectValueColumns", func(t *testing.T) {
+		project := &Project{InnerPlan: newScan(), ColumnIndices: []int{1, 0}}
+		if project.keyOnlyPlan().(*SeqScan).KeyOnly {
+			t.Error("Expected a full scan when a value column is projected")
+		}
+		expected := [][][]byte{{[]byte("Alice"), []byte("a")}, {[]byte("Bob"), []byte("b")}}
+		if results := collect(project); !reflect.DeepEqual(results, expected) {
+			t.Errorf("Expected %q, got %q", expected, results)
+		}
+	})
+}
+
+func TestFilterVisibleIsolation(t *testing.T) {
+	tmpfile, err := os.CreateTemp("", "test_filter_visible_*.db")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(tmpfile.Name())
+
+	dm, err := disk.NewDiskManager(tmpfile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer dm.Close()
+
+	pool := buffer.NewBufferPool(10)
+	bufmgr := buffer.NewBufferPoolManager(dm, pool)
+
+	// Rows are [id, xmin, xmax]
+	rows := &table.SimpleTable{
+		MetaPageID:  disk.InvalidPageID,
+		NumKeyElems: 1,
+	}
+	if err := rows.Create(bufmgr); err != nil {
+		t.Fatal(err)
+	}
+	tm := transaction.NewTransactionManager()
+	insert := func(txn *transaction.Transaction, id string) {
+		if err := rows.Insert(bufmgr, [][]byte{[]byte(id), txn.ID.Bytes(), {}}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	setup := tm.Begin()
+	insert(setup, "a")
+	if err := tm.Commit(setup); err != nil {
+		t.Fatal(err)
+	}
+
+	readCommitted := tm.BeginWithIsolation(transaction.IsolationReadCommitted)
+	repeatableRead := tm.BeginWithIsolation(transaction.IsolationRepeatableRead)
+	statement := func(txn *transaction.Transaction) []string {
+		plan := &FilterVisible{
+			InnerPlan: &SeqScan{
+				TableMetaPageID: rows.MetaPageID,
+				SearchMode:      NewTupleSearchModeStart(),
+				WhileCond:       func(TupleSlice) bool { return true },
+			},
+			Snapshot:   func() *transaction.Snapshot { return tm.StatementSnapshot(txn) },
+			XminColumn: 1,
+			XmaxColumn: 2,
+		}
+		executor, err := plan.Start(bufmgr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var ids []string
+		for {
+			tup, ok, err := executor.Next(bufmgr)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !ok {
+				return ids
+			}
+			ids = append(ids, string(tup[0]))
+		}
+	}
+
+	for _, txn := range []*transaction.Transaction{readCommitted, repeatableRead} {
+		if ids := statement(txn); !reflect.DeepEqual(ids, []string{"a"}) {
+			t.Errorf("expected [a] in the first statement, got %v", ids)
+		}
+	}
+
+	// Another transaction inserts b and soft-deletes a, then commits
+	writer := tm.Begin()
+	insert(writer, "b")
+	if err := rows.Update(bufmgr, [][]byte{[]byte("a"), setup.ID.Bytes(), writer.ID.Bytes()}); err != nil {
+		t.Fatal(err)
+	}
+	if ids := statement(readCommitted); !reflect.DeepEqual(ids, []string{"a"}) {
+		t.Errorf("expected uncommitted changes to be invisible, got %v", ids)
+	}
+	if err := tm.Commit(writer); err != nil {
+		t.Fatal(err)
+	}
+
+	if ids := statement(readCommitted); !reflect.DeepEqual(ids, []string{"b"}) {
+		t.Errorf("expected ReadCommitted to see the committed changes, got %v", ids)
+	}
+	if ids := statement(repeatableRead); !reflect.DeepEqual(ids, []string{"a"}) {
+		t.Errorf("expected RepeatableRead to keep its snapshot, got %v", ids)
+	}
+}
+
+func TestFilterVisibleVersions(t *testing.T) {
+	tmpfile, err := os.CreateTemp("", "test_filter_visible_versions_*.db")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(tmpfile.Name())
+
+	dm, err := disk.NewDiskManager(tmpfile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer dm.Close()
+
+	pool := buffer.NewBufferPool(10)
+	bufmgr := buffer.NewBufferPoolManager(dm, pool)
+
+	// Rows are [id, balance, xmin, xmax]
+	accounts := &table.Table{
+		MetaPageID:  disk.InvalidPageID,
+		NumKeyElems: 1,
+		Versions:    &table.VersionStore{NumKeyElems: 1, XminColumn: 2, XmaxColumn: 3},
+	}
+	if err := accounts.Create(bufmgr); err != nil {
+		t.Fatal(err)
+	}
+	tm := transaction.NewTransactionManager()
+	setup := tm.Begin()
+	for _, id := range []string{"alice", "bob"} {
+		if err := accounts.Insert(bufmgr, [][]byte{[]byte(id), []byte("100"), setup.ID.Bytes(), {}}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := tm.Commit(setup); err != nil {
+		t.Fatal(err)
+	}
+
+	statement := func(txn *transaction.Transaction) []string {
+		plan := &FilterVisible{
+			InnerPlan: &SeqScan{
+				TableMetaPageID: accounts.MetaPageID,
+				SearchMode:      NewTupleSearchModeStart(),
+				WhileCond:       func(TupleSlice) bool { return true },
+			},
+			Snapshot:   func() *transaction.Snapshot { return tm.StatementSnapshot(txn) },
+			XminColumn: 2,
+			XmaxColumn: 3,
+			Versions:   accounts.Versions,
+		}
+		executor, err := plan.Start(bufmgr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var rows []string
+		for {
+			tup, ok, err := executor.Next(bufmgr)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !ok {
+				return rows
+			}
+			rows = append(rows, fmt.Sprintf("%s=%s", tup[0], tup[1]))
+		}
+	}
+
+	reader := tm.BeginWithIsolation(transaction.IsolationRepeatableRead)
+	if rows := statement(reader); !reflect.DeepEqual(rows, []string{"alice=100", "bob=100"}) {
+		t.Fatalf("expected the initial balances, got %v", rows)
+	}
+
+	// A writer moves money and deletes bob; the reader keeps seeing the versions of its snapshot
+	writer := tm.Begin()
+	if err := accounts.Update(bufmgr, [][]byte{[]byte("alice"), []byte("150"), writer.ID.Bytes(), {}}); err != nil {
+		t.Fatal(err)
+	}
+	if err := accounts.Update(bufmgr, [][]byte{[]byte("bob"), []byte("50"), writer.ID.Bytes(), writer.ID.Bytes()}); err != nil {
+		t.Fatal(err)
+	}
+	if rows := statement(writer); !reflect.DeepEqual(rows, []string{"alice=150"}) {
+		t.Errorf("expected the writer to see its own changes, got %v", rows)
+	}
+	if err := tm.Commit(writer); err != nil {
+		t.Fatal(err)
+	}
+	if rows := statement(reader); !reflect.DeepEqual(rows, []string{"alice=100", "bob=100"}) {
+		t.Errorf("expected the reader to keep seeing its snapshot, got %v", rows)
+	}
+	later := tm.Begin()
+	if rows := statement(later); !reflect.DeepEqual(rows, []string{"alice=150"}) {
+		t.Errorf("expected a later transaction to see the committed changes, got %v", rows)
+	}
+
+	// The versions stay until the reader ends
+	if n, err := accounts.Versions.Prune(bufmgr, tm.Horizon().Bytes()); err != nil || n != 0 {
+		t.Errorf("expected no version to be pruned while the reader runs, got %d (%v)", n, err)
+	}
+	if err := tm.Commit(reader); err != nil {
+		t.Fatal(err)
+	}
+	if err := tm.Commit(later); err != nil {
+		t.Fatal(err)
+	}
+	if n, err := accounts.Versions.Prune(bufmgr, tm.Horizon().Bytes()); err != nil || n != 2 {
+		t.Errorf("expected the 2 versions to be pruned, got %d (%v)", n, err)
+	}
+}
+
+func TestCompare(t *testing.T) {
+	nine, _ := catalog.ColumnTypeInt.Bind(9)
+	ten, _ := catalog.ColumnTypeInt.Bind(10)
+	row := TupleSlice{[]byte("id"), nine}
+
+	tests := []struct {
+		op       CompareOp
+		value    []byte
+		expected bool
+	}{
+		{CompareEq, nine, true},
+		{CompareNe, nine, false},
+		{CompareLt, ten, true},
+		{CompareLe, nine, true},
+		{CompareGt, ten, false},
+		{CompareGe, ten, false},
+	}
+	for _, tt := range tests {
+		if got := Compare(1, tt.op, tt.value)(row); got != tt.expected {
+			t.Errorf("op %d: expected %v, got %v", tt.op, tt.expected, got)
+		}
+	}
+	if Compare(2, CompareEq, nine)(row) {
+		t.Error("expected a missing column not to match")
+	}
+	// The hand-rolled string encoding gets the order wrong
+	if !Compare(0, CompareGt, []byte("10"))(TupleSlice{[]byte("9")}) {
+		t.Error("expected \"9\" > \"10\" byte-wise")
+	}
+}
+
+func TestCompareValue(t *testing.T) {
+	row := TupleSlice{[]byte("1"), types.EncodeInt(9), nil}
+	if !CompareValue(1, CompareLt, types.Int64(30))(row) {
+		t.Error("expected 9 < 30")
+	}
+	if CompareValue(1, CompareGe, types.Int64(30))(row) {
+		t.Error("expected not 9 >= 30")
+	}
+	if CompareValue(2, CompareEq, types.Null)(row) || CompareValue(2, CompareNe, types.Int64(9))(row) {
+		t.Error("expected comparisons with NULL not to match")
+	}
+	if CompareValue(3, CompareNe, types.Int64(9))(row) {
+		t.Error("expected a missing column not to match")
+	}
+}
+
+func TestSeqScanSchemaDrift(t *testing.T) {
+	tmpfile, err := os.CreateTemp("", "test_seq_scan_schema_drift_*.db")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(tmpfile.Name())
+
+	dm, err := disk.NewDiskManager(tmpfile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer dm.Close()
+
+	pool := buffer.NewBufferPool(10)
+	bufmgr := buffer.NewBufferPoolManager(dm, pool)
+
+	simpleTable := &table.SimpleTable{
+		MetaPageID:  disk.InvalidPageID,
+		NumKeyElems: 1,
+	}
+	if err := simpleTable.Create(bufmgr); err != nil {
+		t.Fatal(err)
+	}
+	// Row 1 was written before the columns "age" and "city" were added
+	if err := simpleTable.Insert(bufmgr, [][]byte{[]byte("1"), []byte("Alice")}); err != nil {
+		t.Fatal(err)
+	}
+	if err := simpleTable.Insert(bufmgr, [][]byte{[]byte("2"), []byte("Bob"), []byte("25"), []byte("Paris")}); err != nil {
+		t.Fatal(err)
+	}
+
+	schema := &catalog.TableSchema{Columns: []catalog.ColumnDef{
+		{Name: "id"},
+		{Name: "name"},
+		{Name: "age", Default: []byte("0")},
+		{Name: "city", Nullable: true},
+	}}
+	scan := &SeqScan{
+		TableMetaPageID: simpleTable.MetaPageID,
+		SearchMode:      NewTupleSearchModeStart(),
+		WhileCond:       func(pkey [][]byte) bool { return true },
+		Defaults:        schema.Defaults(),
+	}
+
+	t.Run("PadsMissingColumns", func(t *testing.T) {
+		exec, err := scan.Start(bufmgr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		expected := []struct {
+			row     Tuple
+			version int
+		}{
+			{Tuple{[]byte("1"), []byte("Alice"), []byte("0"), nil}, 2},
+			{Tuple{[]byte("2"), []byte("Bob"), []byte("25"), []byte("Paris")}, 4},
+		}
+		for _, want := range expected {
+			row, ok, err := exec.Next(bufmgr)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !ok {
+				t.Fatal("expected a row")
+			}
+			if !reflect.DeepEqual(row, want.row) {
+				t.Errorf("expected %q, got %q", want.row, row)
+			}
+			if version := exec.(SchemaVersioned).SchemaVersion(); version != want.version {
+				t.Errorf("expected schema version %d, got %d", want.version, version)
+			}
+		}
+	})
+
+	t.Run("FilterOnAddedColumn", func(t *testing.T) {
+		filter := &Filter{
+			InnerPlan: scan,
+			Cond: func(row TupleSlice) bool {
+				return string(row[2]) == "0"
+			},
+		}
+		exec, err := filter.Start(bufmgr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		row, ok, err := exec.Next(bufmgr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !ok || string(row[0]) != "1" {
+			t.Errorf("expected the old row to match the default, got %q", row)
+		}
+		if _, ok, _ := exec.Next(bufmgr); ok {
+			t.Error("expected a single matching row")
+		}
+	})
+
+	t.Run("DroppedColumn", func(t *testing.T) {
+		// "name" dropped: the values stored in it are no longer returned
+		dropped := *scan
+		dropped.DroppedColumns = []int{1}
+		exec, err := dropped.Start(bufmgr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, want := range []Tuple{
+			{[]byte("1"), nil, []byte("0"), nil},
+			{[]byte("2"), nil, []byte("25"), []byte("Paris")},
+		} {
+			row, ok, err := exec.Next(bufmgr)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !ok || !reflect.DeepEqual(row, want) {
+				t.Errorf("expected %q, got %q", want, row)
+			}
+		}
+	})
+}
+
+func TestIndexSkipScan(t *testing.T) {
+	tmpfile, err := os.CreateTemp("", "test_index_skip_scan_*.db")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(tmpfile.Name())
+
+	dm, err := disk.NewDiskManager(tmpfile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer dm.Close()
+
+	pool := buffer.NewBufferPool(10)
+	bufmgr := buffer.NewBufferPoolManager(dm, pool)
+
+	// Schema: [id, region, score] with an index over (region, score)
+	tbl := &table.Table{
+		MetaPageID:    disk.InvalidPageID,
+		NumKeyElems:   1,
+		UniqueIndices: []*table.UniqueIndex{{MetaPageID: disk.InvalidPageID, Skey: []int{1, 2}}},
+	}
+	if err := tbl.Create(bufmgr); err != nil {
+		t.Fatal(err)
+	}
+	id := 0
+	for _, region := range []string{"ap", "eu", "us"} {
+		for score := 0; score < 20; score++ {
+			row := [][]byte{[]byte(fmt.Sprintf("%03d", id)), []byte(region), []byte(fmt.Sprintf("%02d", score))}
+			if err := tbl.Insert(bufmgr, row); err != nil {
+				t.Fatal(err)
+			}
+			id++
+		}
+	}
+
+	// WHERE score BETWEEN '05' AND '06'
+	scan := &IndexSkipScan{
+		TableMetaPageID: tbl.MetaPageID,
+		IndexMetaPageID: tbl.UniqueIndices[0].MetaPageID,
+		Suffix:          [][]byte{[]byte("05")},
+		WhileCond: func(skey TupleSlice) bool {
+			return string(skey[0]) <= "06"
+		},
+	}
+	executor, err := scan.Start(bufmgr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	for {
+		tup, ok, err := executor.Next(bufmgr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !ok {
+			break
+		}
+		got = append(got, fmt.Sprintf("%s/%s", tup[1], tup[2]))
+	}
+	expected := []string{"ap/05", "ap/06", "eu/05", "eu/06", "us/05", "us/06"}
+	if !reflect.DeepEqual(got, expected) {
+		t.Errorf("Expected %v, got %v", expected, got)
+	}
+}
+
+func TestIndexScanPrefix(t *testing.T) {
+	tmpfile, err := os.CreateTemp("", "test_index_scan_prefix_*.db")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(tmpfile.Name())
+
+	dm, err := disk.NewDiskManager(tmpfile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer dm.Close()
+
+	pool := buffer.NewBufferPool(10)
+	bufmgr := buffer.NewBufferPoolManager(dm, pool)
+
+	// Schema: [id, region, city, score] with an index over (region, city, score)
+	tbl := &table.Table{
+		MetaPageID:    disk.InvalidPageID,
+		NumKeyElems:   1,
+		UniqueIndices: []*table.UniqueIndex{{MetaPageID: disk.InvalidPageID, Skey: []int{1, 2, 3}}},
+	}
+	if err := tbl.Create(bufmgr); err != nil {
+		t.Fatal(err)
+	}
+	id := 0
+	// "e" and "eu" check that a prefix matches whole values, not the bytes they start with
+	for _, region := range []string{"ap", "e", "eu", "us"} {
+		for _, city := range []string{"a", "b"} {
+			for score := 0; score < 50; score++ {
+				row := [][]byte{[]byte(fmt.Sprintf("%03d", id)), []byte(region), []byte(city), []byte(fmt.Sprintf("%02d", score))}
+				if err := tbl.Insert(bufmgr, row); err != nil {
+					t.Fatal(err)
+				}
+				id++
+			}
+		}
+	}
+
+	scan := func(prefix [][]byte, backward bool) []string {
+		t.Helper()
+		plan := &IndexScan{
+			TableMetaPageID: tbl.MetaPageID,
+			IndexMetaPageID: tbl.UniqueIndices[0].MetaPageID,
+			Prefix:          prefix,
+			Backward:        backward,
+		}
+		executor, err := plan.Start(bufmgr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var got []string
+		for {
+			tup, ok, err := executor.Next(bufmgr)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !ok {
+				return got
+			}
+			got = append(got, fmt.Sprintf("%s/%s/%s", tup[1], tup[2], tup[3]))
+		}
+	}
+
+	// WHERE region = 'eu'
+	got := scan([][]byte{[]byte("eu")}, false)
+	if len(got) != 100 || got[0] != "eu/a/00" || got[99] != "eu/b/49" || !sort.StringsAreSorted(got) {
+		t.Errorf("expected the 100 rows of eu in index order, got %d: %v", len(got), got)
+	}
+	backward := scan([][]byte{[]byte("eu")}, true)
+	slices.Reverse(backward)
+	if !reflect.DeepEqual(backward, got) {
+		t.Errorf("expected the backward scan to return the same rows in reverse order, got %v", backward)
+	}
+	// WHERE region = 'e' AND city = 'b'
+	got = scan([][]byte{[]byte("e"), []byte("b")}, true)
+	if len(got) != 50 || got[0] != "e/b/49" || got[49] != "e/b/00" {
+		t.Errorf("expected the 50 rows of e/b in descending order, got %d: %v", len(got), got)
+	}
+	// The last and missing prefixes
+	if got := scan([][]byte{[]byte("us"), []byte("b"), []byte("49")}, false); !reflect.DeepEqual(got, []string{"us/b/49"}) {
+		t.Errorf("expected the last row, got %v", got)
+	}
+	if got := scan([][]byte{[]byte("eu"), []byte("c")}, true); len(got) != 0 {
+		t.Errorf("expected no rows for a missing prefix, got %v", got)
+	}
+}
+
+func TestIndexScanBatched(t *testing.T) {
+	tmpfile, err := os.CreateTemp("", "test_index_scan_batched_*.db")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(tmpfile.Name())
+
+	dm, err := disk.NewDiskManager(tmpfile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer dm.Close()
+
+	// A small pool, so that random lookups read pages from disk again and again
+	pool := buffer.NewBufferPool(6)
+	bufmgr := buffer.NewBufferPoolManager(dm, pool)
+
+	// Schema: [id, name, payload], with names in a different order than the ids
+	tbl := &table.Table{
+		MetaPageID:    disk.InvalidPageID,
+		NumKeyElems:   1,
+		UniqueIndices: []*table.UniqueIndex{{MetaPageID: disk.InvalidPageID, Skey: []int{1}}},
+	}
+	if err := tbl.Create(bufmgr); err != nil {
+		t.Fatal(err)
+	}
+	const numRows = 1000
+	for i := 0; i < numRows; i++ {
+		row := [][]byte{[]byte(fmt.Sprintf("%04d", i)), []byte(fmt.Sprintf("name%04d", i*7919%numRows)), bytes.Repeat([]byte("x"), 40)}
+		if err := tbl.Insert(bufmgr, row); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	run := func(batchSize int, order LookupOrder) ([]string, uint64) {
+		t.Helper()
+		stats := &table.AccessStats{}
+		plan := &IndexScan{
+			TableMetaPageID: tbl.MetaPageID,
+			IndexMetaPageID: tbl.UniqueIndices[0].MetaPageID,
+			SearchMode:      NewTupleSearchModeStart(),
+			WhileCond:       func(skey TupleSlice) bool { return string(skey[0]) < "name0500" },
+			KeyColumns:      []int{1},
+			Stats:           stats,
+			BatchSize:       batchSize,
+			BatchOrder:      order,
+		}
+		if order == LookupOrderKey && 0 < batchSize && plan.Ordering() != nil {
+			t.Errorf("expected no ordering for batches in key order, got %v", plan.Ordering())
+		}
+		executor, err := plan.Start(bufmgr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var names []string
+		for {
+			tup, ok, err := executor.Next(bufmgr)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !ok {
+				return names, stats.PagesRead.Load()
+			}
+			names = append(names, string(tup[1]))
+		}
+	}
+
+	expected, unbatchedReads := run(0, LookupOrderIndex)
+	if len(expected) != 500 || !sort.StringsAreSorted(expected) {
+		t.Fatalf("expected 500 names in index order, got %d", len(expected))
+	}
+	got, batchedReads := run(200, LookupOrderIndex)
+	if !reflect.DeepEqual(got, expected) {
+		t.Errorf("expected the batched scan to return the rows in index order")
+	}
+	if unbatchedReads <= batchedReads {
+		t.Errorf("expected batching to read fewer pages, got %d batched and %d unbatched", batchedReads, unbatchedReads)
+	}
+
+	got, _ = run(200, LookupOrderKey)
+	if len(got) != len(expected) {
+		t.Fatalf("expected %d rows in key order, got %d", len(expected), len(got))
+	}
+	sorted := append([]string(nil), got...)
+	sort.Strings(sorted)
+	if !reflect.DeepEqual(sorted, expected) {
+		t.Errorf("expected the same rows in key order")
+	}
+	if sort.StringsAreSorted(got) {
+		t.Errorf("expected the rows of a batch to follow the primary key, not the index")
+	}
+}
+
+func TestScanAccessStats(t *testing.T) {
+	tmpfile, err := os.CreateTemp("", "test_scan_access_stats_*.db")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(tmpfile.Name())
+
+	dm, err := disk.NewDiskManager(tmpfile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer dm.Close()
+
+	pool := buffer.NewBufferPool(10)
+	bufmgr := buffer.NewBufferPoolManager(dm, pool)
+
+	stats := &table.AccessStats{}
+	tbl := &table.Table{
+		MetaPageID:    disk.InvalidPageID,
+		NumKeyElems:   1,
+		UniqueIndices: []*table.UniqueIndex{{MetaPageID: disk.InvalidPageID, Skey: []int{1}}},
+		Counters:      &stats.DML,
+	}
+	if err := tbl.Create(bufmgr); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 100; i++ {
+		row := [][]byte{[]byte(fmt.Sprintf("%03d", i)), []byte(fmt.Sprintf("name%03d", i))}
+		if err := tbl.Insert(bufmgr, row); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	count := func(plan PlanNode) {
+		t.Helper()
+		executor, err := plan.Start(bufmgr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for {
+			_, ok, err := executor.Next(bufmgr)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !ok {
+				break
+			}
+		}
+	}
+	count(&SeqScan{
+		TableMetaPageID: tbl.MetaPageID,
+		SearchMode:      NewTupleSearchModeStart(),
+		WhileCond:       func(TupleSlice) bool { return true },
+		Stats:           stats,
+	})
+	count(&IndexScan{
+		TableMetaPageID: tbl.MetaPageID,
+		IndexMetaPageID: tbl.UniqueIndices[0].MetaPageID,
+		SearchMode:      NewTupleSearchModeKey([][]byte{[]byte("name090")}),
+		WhileCond:       func(TupleSlice) bool { return true },
+		Stats:           stats,
+	})
+
+	snapshot := stats.Snapshot()
+	if snapshot.SeqScans != 1 || snapshot.IndexScans != 1 || snapshot.RowsRead != 110 || snapshot.Inserts != 100 {
+		t.Errorf("unexpected counters %+v", snapshot)
+	}
+	if snapshot.PagesFetched == 0 || snapshot.PagesFetched < snapshot.PagesRead {
+		t.Errorf("unexpected page counts %+v", snapshot)
+	}
+}
+
+// unordered hides the ordering of a plan, so that Sort always sorts its tuples.
+type unordered struct {
+	PlanNode
+}
+
+func TestSortPresorted(t *testing.T) {
+	tmpfile, err := os.CreateTemp("", "test_sort_presorted_*.db")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(tmpfile.Name())
+
+	dm, err := disk.NewDiskManager(tmpfile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer dm.Close()
+
+	pool := buffer.NewBufferPool(10)
+	bufmgr := buffer.NewBufferPoolManager(dm, pool)
+
+	// Schema: [region, seq, code] with primary key (region, seq) and an index over code
+	tbl := &table.Table{
+		MetaPageID:    disk.InvalidPageID,
+		NumKeyElems:   2,
+		UniqueIndices: []*table.UniqueIndex{{MetaPageID: disk.InvalidPageID, Skey: []int{2}}},
+	}
+	if err := tbl.Create(bufmgr); err != nil {
+		t.Fatal(err)
+	}
+	n := 0
+	for _, region := range []string{"ap", "eu", "us"} {
+		for seq := 0; seq < 20; seq++ {
+			row := [][]byte{[]byte(region), []byte(fmt.Sprintf("%02d", seq)), []byte(fmt.Sprintf("%03d", (n*37)%60))}
+			if err := tbl.Insert(bufmgr, row); err != nil {
+				t.Fatal(err)
+			}
+			n++
+		}
+	}
+
+	all := func(TupleSlice) bool { return true }
+	seqScan := func(backward bool) *SeqScan {
+		return &SeqScan{
+			TableMetaPageID: tbl.MetaPageID,
+			SearchMode:      NewTupleSearchModeStart(),
+			WhileCond:       all,
+			Backward:        backward,
+			NumKeyElems:     2,
+		}
+	}
+	indexScan := func(backward bool) *IndexScan {
+		return &IndexScan{
+			TableMetaPageID: tbl.MetaPageID,
+			IndexMetaPageID: tbl.UniqueIndices[0].MetaPageID,
+			SearchMode:      NewTupleSearchModeStart(),
+			WhileCond:       all,
+			Backward:        backward,
+			KeyColumns:      []int{2},
+		}
+	}
+	asc := func(colIdx int) SortKey { return SortKey{ColumnIndex: colIdx, Ascending: true} }
+	desc := func(colIdx int) SortKey { return SortKey{ColumnIndex: colIdx, Ascending: false} }
+
+	tests := []struct {
+		name      string
+		plan      PlanNode
+		keys      []SortKey
+		presorted bool
+	}{
+		{"PrimaryKey", seqScan(false), []SortKey{asc(0), asc(1)}, true},
+		{"PrimaryKeyPrefix", seqScan(false), []SortKey{asc(0)}, true},
+		{"PrimaryKeyDescending", seqScan(true), []SortKey{desc(0), desc(1)}, true},
+		{"DescendingOnForwardScan", seqScan(false), []SortKey{desc(0), desc(1)}, false},
+		{"MixedDirections", seqScan(true), []SortKey{desc(0), asc(1)}, false},
+		{"NotAPrefix", seqScan(false), []SortKey{asc(1)}, false},
+		{"Index", indexScan(false), []SortKey{asc(2)}, true},
+		{"IndexDescending", indexScan(true), []SortKey{desc(2)}, true},
+		{"IndexThenPrimaryKey", indexScan(false), []SortKey{asc(2), asc(0)}, false},
+		{"Filter", &Filter{InnerPlan: seqScan(false), Cond: func(tup TupleSlice) bool { return string(tup[0]) != "eu" }}, []SortKey{asc(0), asc(1)}, true},
+		{"Project", &Project{InnerPlan: indexScan(true), ColumnIndices: []int{1, 2}}, []SortKey{desc(1)}, true},
+		{"ProjectedAway", &Project{InnerPlan: seqScan(false), ColumnIndices: []int{1, 2}}, []SortKey{asc(0)}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sort := &Sort{InnerPlan: tt.plan, SortKeys: tt.keys}
+			if sort.Presorted() != tt.presorted {
+				t.Fatalf("expected Presorted() = %v", tt.presorted)
+			}
+			// The sort key columns come out as if the tuples had been sorted
+			collect := func(plan PlanNode) []string {
+				executor, err := plan.Start(bufmgr)
+				if err != nil {
+					t.Fatal(err)
+				}
+				var got []string
+				for {
+					tup, ok, err := executor.Next(bufmgr)
+					if err != nil {
+						t.Fatal(err)
+					}
+					if !ok {
+						break
+					}
+					var key []string
+					for _, sortKey := range tt.keys {
+						key = append(key, string(tup[sortKey.ColumnIndex]))
+					}
+					got = append(got, fmt.Sprint(key))
+				}
+				return got
+			}
+			got := collect(sort)
+			expected := collect(&Sort{InnerPlan: unordered{tt.plan}, SortKeys: tt.keys})
+			if len(got) == 0 || !reflect.DeepEqual(got, expected) {
+				t.Errorf("expected %v, got %v", expected, got)
+			}
+		})
+	}
+}
@@ -0,0 +1,192 @@
+package query
+
+import (
+	"fmt"
+
+	"github.com/Johniel/gorelly/buffer"
+	"github.com/Johniel/gorelly/errcode"
+	"github.com/Johniel/gorelly/tuple"
+)
+
+var (
+	// ErrArityMismatch is returned when the inputs of a set operation return tuples with different
+	// numbers of columns.
+	ErrArityMismatch = errcode.New(errcode.InvalidParameter, "set operation inputs have different numbers of columns")
+)
+
+// Union returns the tuples of LeftPlan followed by those of RightPlan (UNION ALL), streaming
+// both. Unless All is set, it returns every distinct tuple once (UNION), in the order of its
+// first occurrence, remembering the tuples returned so far in a hash set.
+//
+// Like the other set operations, it compares whole tuples, with NULLs (empty values) equal to
+// each other, and fails with ErrArityMismatch if two tuples have different numbers of columns.
+type Union struct {
+	LeftPlan  PlanNode
+	RightPlan PlanNode
+	All       bool
+}
+
+func (u *Union) Start(bufmgr *buffer.BufferPoolManager) (Executor, error) {
+	leftIter, err := u.LeftPlan.Start(bufmgr)
+	if err != nil {
+		return nil, err
+	}
+	es := &ExecSetOperation{leftIter: leftIter, rightPlan: u.RightPlan, arity: -1}
+	if !u.All {
+		es.returned = make(map[string]bool)
+	}
+	return es, nil
+}
+
+// Intersect returns the distinct tuples of LeftPlan that RightPlan also returns (INTERSECT), in
+// the order of their first occurrence in LeftPlan. It reads RightPlan into a hash set first, then
+// streams LeftPlan.
+type Intersect struct {
+	LeftPlan  PlanNode
+	RightPlan PlanNode
+}
+
+func (i *Intersect) Start(bufmgr *buffer.BufferPoolManager) (Executor, error) {
+	return startFilteredSetOperation(bufmgr, i.LeftPlan, i.RightPlan, true)
+}
+
+// Except returns the distinct tuples of LeftPlan that RightPlan does not return (EXCEPT), in the
+// order of their first occurrence in LeftPlan. It reads RightPlan into a hash set first, then
+// streams LeftPlan.
+type Except struct {
+	LeftPlan  PlanNode
+	RightPlan PlanNode
+}
+
+func (e *Except) Start(bufmgr *buffer.BufferPoolManager) (Executor, error) {
+	return startFilteredSetOperation(bufmgr, e.LeftPlan, e.RightPlan, false)
+}
+
+// startFilteredSetOperation reads the tuples of rightPlan and returns the executor of the
+// distinct tuples of leftPlan that are among them (or not, unless keepMatches is set).
+func startFilteredSetOperation(bufmgr *buffer.BufferPoolManager, leftPlan, rightPlan PlanNode, keepMatches bool) (Executor, error) {
+	rightIter, err := rightPlan.Start(bufmgr)
+	if err != nil {
+		return nil, err
+	}
+	es := &ExecSetOperation{
+		arity:       -1,
+		returned:    make(map[string]bool),
+		right:       make(map[string]bool),
+		keepMatches: keepMatches,
+	}
+	for {
+		tup, ok, err := rightIter.Next(bufmgr)
+		if err != nil {
+			return nil, closeOnError(bufmgr, rightIter, err)
+		}
+		if !ok {
+			break
+		}
+		if err := es.checkArity(tup); err != nil {
+			return nil, closeOnError(bufmgr, rightIter, err)
+		}
+		es.right[string(setKey(tup))] = true
+	}
+	if es.leftIter, err = leftPlan.Start(bufmgr); err != nil {
+		return nil, closeOnError(bufmgr, rightIter, err)
+	}
+	return es, nil
+}
+
+// ExecSetOperation is the executor for set operations.
+type ExecSetOperation struct {
+	leftIter    Executor
+	rightIter   Executor        // Right input of a Union once the left one is exhausted
+	rightPlan   PlanNode        // Right input of a Union, until it is started
+	arity       int             // Number of columns of the tuples seen so far, or -1 before the first
+	returned    map[string]bool // Tuples returned so far, or nil for UNION ALL
+	right       map[string]bool // Tuples of the right input of an Intersect or Except
+	keepMatches bool            // Whether tuples found in right are returned (Intersect) rather than skipped (Except)
+}
+
+func (es *ExecSetOperation) Next(bufmgr *buffer.BufferPoolManager) (Tuple, bool, error) {
+	for {
+		iter := es.leftIter
+		if iter == nil {
+			iter = es.rightIter
+		}
+		if iter == nil {
+			return nil, false, nil
+		}
+		tup, ok, err := iter.Next(bufmgr)
+		if err != nil {
+			return nil, false, err
+		}
+		if !ok {
+			if err := es.advance(bufmgr); err != nil {
+				return nil, false, err
+			}
+			continue
+		}
+		if err := es.checkArity(tup); err != nil {
+			return nil, false, err
+		}
+		if es.returned == nil {
+			return tup, true, nil
+		}
+		key := string(setKey(tup))
+		if es.returned[key] || (es.right != nil && es.right[key] != es.keepMatches) {
+			continue
+		}
+		es.returned[key] = true
+		return tup, true, nil
+	}
+}
+
+// advance moves on to the right input of a Union once the current input is exhausted.
+func (es *ExecSetOperation) advance(bufmgr *buffer.BufferPoolManager) error {
+	if es.leftIter == nil {
+		es.rightIter = nil
+		return nil
+	}
+	es.leftIter = nil
+	if es.rightPlan == nil {
+		return nil
+	}
+	rightIter, err := es.rightPlan.Start(bufmgr)
+	if err != nil {
+		return err
+	}
+	es.rightIter, es.rightPlan = rightIter, nil
+	return nil
+}
+
+func (es *ExecSetOperation) checkArity(tup Tuple) error {
+	if es.arity < 0 {
+		es.arity = len(tup)
+	} else if len(tup) != es.arity {
+		return fmt.Errorf("%w: %d and %d", ErrArityMismatch, es.arity, len(tup))
+	}
+	return nil
+}
+
+func (es *ExecSetOperation) Close(bufmgr *buffer.BufferPoolManager) error {
+	es.rightPlan = nil
+	if es.leftIter != nil {
+		if err := CloseExecutor(bufmgr, es.leftIter); err != nil {
+			return err
+		}
+		es.leftIter = nil
+	}
+	if es.rightIter != nil {
+		if err := CloseExecutor(bufmgr, es.rightIter); err != nil {
+			return err
+		}
+		es.rightIter = nil
+	}
+	return nil
+}
+
+// setKey encodes a whole tuple into a single byte sequence, as distinctKey does for some of its
+// columns.
+func setKey(tup Tuple) []byte {
+	key := make([]byte, 0)
+	tuple.Encode(tup, &key)
+	return key
+}
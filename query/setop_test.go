@@ -0,0 +1,150 @@
+package query
+
+import (
+	"errors"
+	"os"
+	"reflect"
+	"testing"
+
+	"github.com/Johniel/gorelly/buffer"
+	"github.com/Johniel/gorelly/disk"
+	"github.com/Johniel/gorelly/table"
+	"github.com/Johniel/gorelly/types"
+)
+
+func TestSetOperations(t *testing.T) {
+	tmpfile, err := os.CreateTemp("", "test_set_operations_*.db")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(tmpfile.Name())
+
+	dm, err := disk.NewDiskManager(tmpfile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer dm.Close()
+
+	pool := buffer.NewBufferPool(10)
+	bufmgr := buffer.NewBufferPoolManager(dm, pool)
+
+	// createTable returns a table of rows [id, value], where a nil value is NULL
+	createTable := func(values ...any) *table.SimpleTable {
+		tbl := &table.SimpleTable{
+			MetaPageID:  disk.InvalidPageID,
+			NumKeyElems: 1,
+		}
+		if err := tbl.Create(bufmgr); err != nil {
+			t.Fatal(err)
+		}
+		for i, v := range values {
+			row := Tuple{types.EncodeInt(int64(i)), {}}
+			if v != nil {
+				row[1] = types.EncodeInt(int64(v.(int)))
+			}
+			if err := tbl.Insert(bufmgr, row); err != nil {
+				t.Fatal(err)
+			}
+		}
+		return tbl
+	}
+	left := createTable(1, 2, 2, 3, nil)
+	right := createTable(2, 3, 3, 4, nil)
+	scan := func(tbl *table.SimpleTable) *SeqScan {
+		return &SeqScan{
+			TableMetaPageID: tbl.MetaPageID,
+			SearchMode:      NewTupleSearchModeStart(),
+			WhileCond:       func(TupleSlice) bool { return true },
+			NumKeyElems:     1,
+		}
+	}
+	values := func(tbl *table.SimpleTable) PlanNode {
+		return &Project{InnerPlan: scan(tbl), ColumnIndices: []int{1}}
+	}
+	// run returns the values of the tuples of the plan, with -1 for NULL
+	run := func(plan PlanNode) ([]int64, error) {
+		executor, err := plan.Start(bufmgr)
+		if err != nil {
+			return nil, err
+		}
+		var results []int64
+		for {
+			tup, ok, err := executor.Next(bufmgr)
+			if err != nil {
+				return results, err
+			}
+			if !ok {
+				return results, nil
+			}
+			if len(tup[0]) == 0 {
+				results = append(results, -1)
+				continue
+			}
+			v, err := types.DecodeInt(tup[0])
+			if err != nil {
+				return results, err
+			}
+			results = append(results, v)
+		}
+	}
+
+	tests := []struct {
+		name     string
+		plan     PlanNode
+		expected []int64
+	}{
+		{"UnionAll", &Union{LeftPlan: values(left), RightPlan: values(right), All: true}, []int64{1, 2, 2, 3, -1, 2, 3, 3, 4, -1}},
+		{"Union", &Union{LeftPlan: values(left), RightPlan: values(right)}, []int64{1, 2, 3, -1, 4}},
+		{"Intersect", &Intersect{LeftPlan: values(left), RightPlan: values(right)}, []int64{2, 3, -1}},
+		{"Except", &Except{LeftPlan: values(left), RightPlan: values(right)}, []int64{1}},
+		{"ExceptSelf", &Except{LeftPlan: values(left), RightPlan: values(left)}, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			results, err := run(tt.plan)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(results, tt.expected) {
+				t.Errorf("expected %v, got %v", tt.expected, results)
+			}
+			if pinned := bufmgr.PoolStats().Pinned; pinned != 0 {
+				t.Errorf("expected no pinned pages, got %d", pinned)
+			}
+		})
+	}
+
+	t.Run("Limit", func(t *testing.T) {
+		results, err := run(&Limit{InnerPlan: &Union{LeftPlan: values(left), RightPlan: values(right)}, Count: 2})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(results, []int64{1, 2}) {
+			t.Errorf("expected [1 2], got %v", results)
+		}
+		if pinned := bufmgr.PoolStats().Pinned; pinned != 0 {
+			t.Errorf("expected the union to close its input, got %d pinned pages", pinned)
+		}
+	})
+
+	t.Run("ArityMismatch", func(t *testing.T) {
+		// The right input fails while it is read into the hash set
+		mixed := &Union{LeftPlan: values(right), RightPlan: scan(right), All: true}
+		if _, err := (&Intersect{LeftPlan: values(left), RightPlan: mixed}).Start(bufmgr); !errors.Is(err, ErrArityMismatch) {
+			t.Errorf("expected ErrArityMismatch, got %v", err)
+		}
+		if pinned := bufmgr.PoolStats().Pinned; pinned != 0 {
+			t.Errorf("expected the failed intersect to close its right input, got %d pinned pages", pinned)
+		}
+
+		for _, plan := range []PlanNode{
+			&Union{LeftPlan: values(left), RightPlan: scan(right), All: true},
+			&Intersect{LeftPlan: values(left), RightPlan: scan(right)},
+			&Except{LeftPlan: scan(left), RightPlan: values(right)},
+		} {
+			if _, err := run(plan); !errors.Is(err, ErrArityMismatch) {
+				t.Errorf("expected ErrArityMismatch, got %v", err)
+			}
+		}
+	})
+}
@@ -0,0 +1,150 @@
+package query
+
+import (
+	"bytes"
+
+	"github.com/Johniel/gorelly/btree"
+	"github.com/Johniel/gorelly/buffer"
+	"github.com/Johniel/gorelly/disk"
+	"github.com/Johniel/gorelly/table"
+	"github.com/Johniel/gorelly/tuple"
+)
+
+// IndexSkipScan scans a composite index for a condition on its columns after the first,
+// e.g. WHERE b = 5 on an index over (a, b), without a condition on the first column.
+// Instead of reading the whole index or table, it enumerates the distinct values of the
+// first column and probes one sub-range per value: for a value v it starts at the index key
+// (v, Suffix...) and continues while WhileCond holds for the remaining key columns, then
+// seeks directly to the next value of the first column. It pays off when the first column
+// has few distinct values. Tuples are returned in index order.
+type IndexSkipScan struct {
+	TableMetaPageID disk.PageID           // Page ID of the table's B+ tree meta page
+	IndexMetaPageID disk.PageID           // Page ID of the index's B+ tree meta page
+	Suffix          [][]byte              // Start of each sub-range in the index columns after the first
+	WhileCond       func(TupleSlice) bool // Condition on the index columns after the first to continue a sub-range
+	Format          tuple.Format          // Row format of the table
+	Defaults        [][]byte              // Default value of every column of the table, as in SeqScan
+	DroppedColumns  []int                 // Columns dropped from the table, as in SeqScan
+	Stats           *table.AccessStats    // Optional access counters of the table, as in SeqScan
+}
+
+func (iss *IndexSkipScan) Start(bufmgr *buffer.BufferPoolManager) (Executor, error) {
+	indexIter, err := btree.NewBTree(iss.IndexMetaPageID).Search(bufmgr, btree.NewSearchModeStart())
+	if err != nil {
+		return nil, err
+	}
+	if iss.Stats != nil {
+		iss.Stats.IndexScans.Add(1)
+	}
+	return &ExecIndexSkipScan{
+		stats:      scanStats{stats: iss.Stats},
+		tableBtree: btree.NewBTree(iss.TableMetaPageID),
+		indexIter:  indexIter,
+		suffix:     iss.Suffix,
+		whileCond:  iss.WhileCond,
+		format:     iss.Format,
+		defaults:   iss.Defaults,
+		dropped:    iss.DroppedColumns,
+	}, nil
+}
+
+// ExecIndexSkipScan is the executor for index skip scan operations.
+type ExecIndexSkipScan struct {
+	tableBtree *btree.BTree
+	indexIter  *btree.Iter
+	suffix     [][]byte
+	whileCond  func(TupleSlice) bool
+	format     tuple.Format
+	defaults   [][]byte
+	dropped    []int
+	leading    []byte // Value of the first index column whose sub-range is being scanned
+	inRange    bool   // Whether the iterator is inside the sub-range of leading
+	version    int
+	stats      scanStats
+}
+
+func (eiss *ExecIndexSkipScan) Next(bufmgr *buffer.BufferPoolManager) (Tuple, bool, error) {
+	for {
+		eiss.stats.pages(eiss.indexIter)
+		skeyBytes, pkeyBytes, ok := eiss.indexIter.Get()
+		if !ok {
+			return nil, false, nil
+		}
+		skey := make([][]byte, 0)
+		tuple.Decode(skeyBytes, &skey)
+
+		if !eiss.inRange || !bytes.Equal(skey[0], eiss.leading) {
+			// First entry of a new leading value: jump to the start of its sub-range
+			eiss.leading = skey[0]
+			eiss.inRange = true
+			start := make([]byte, 0)
+			tuple.Encode(append([][]byte{eiss.leading}, eiss.suffix...), &start)
+			if err := eiss.indexIter.Seek(bufmgr, start); err != nil {
+				return nil, false, err
+			}
+			continue
+		}
+		if !eiss.whileCond(skey[1:]) {
+			// End of the sub-range: skip the remaining entries of this leading value
+			if err := eiss.skipLeading(bufmgr); err != nil {
+				return nil, false, err
+			}
+			continue
+		}
+		if err := eiss.indexIter.Advance(bufmgr); err != nil {
+			return nil, false, err
+		}
+
+		tableIter, err := eiss.tableBtree.Search(bufmgr, btree.NewSearchModeKey(pkeyBytes))
+		if err != nil {
+			return nil, false, err
+		}
+		foundKey, tupleBytes, ok := tableIter.Get()
+		tableIter.Close()
+		eiss.stats.lookup(tableIter)
+		if !ok || !bytes.Equal(foundKey, pkeyBytes) {
+			// Stale index entry: the tuple no longer exists
+			continue
+		}
+		eiss.stats.row()
+		result := make([][]byte, 0)
+		tuple.Decode(pkeyBytes, &result)
+		eiss.format.DecodeValue(tupleBytes, &result)
+		result, eiss.version = padRow(result, eiss.defaults, eiss.dropped)
+		return result, true, nil
+	}
+}
+
+func (eiss *ExecIndexSkipScan) SchemaVersion() int {
+	return eiss.version
+}
+
+// Close releases the index leaf the scan is positioned on.
+func (eiss *ExecIndexSkipScan) Close(bufmgr *buffer.BufferPoolManager) error {
+	eiss.indexIter.Close()
+	return nil
+}
+
+// skipLeading seeks past every index entry whose first column is eiss.leading.
+// Encoded tuples are self-delimiting, so those entries are exactly the keys prefixed by the
+// encoding of the leading value, and the first key after them is at least its successor.
+func (eiss *ExecIndexSkipScan) skipLeading(bufmgr *buffer.BufferPoolManager) error {
+	prefix := make([]byte, 0)
+	tuple.Encode([][]byte{eiss.leading}, &prefix)
+	eiss.inRange = false
+	for i := len(prefix) - 1; 0 <= i; i-- {
+		if prefix[i] != 0xff {
+			prefix[i]++
+			return eiss.indexIter.Seek(bufmgr, prefix[:i+1])
+		}
+	}
+	// No key sorts after the prefix: move to the end of the index
+	for {
+		if _, ok := eiss.indexIter.GetKey(); !ok {
+			return nil
+		}
+		if err := eiss.indexIter.SkipPage(bufmgr); err != nil {
+			return err
+		}
+	}
+}
@@ -0,0 +1,221 @@
+package server
+
+import (
+	"bufio"
+	"net"
+
+	"github.com/Johniel/gorelly/types"
+)
+
+// Client is a connection to a Server. It runs one statement at a time and is not safe for
+// concurrent use.
+type Client struct {
+	conn   net.Conn
+	r      *bufio.Reader
+	w      *bufio.Writer
+	status byte  // Transaction status of the last ready for query
+	rows   *Rows // Result still being read, if any
+}
+
+// Dial connects to the server at the TCP address addr and logs in as role.
+func Dial(addr string, role string, password string) (*Client, error) {
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+	c, err := Login(conn, role, password)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return c, nil
+}
+
+// Login starts a session as role on an established connection to a server, e.g. one served by
+// Server.ServeConn. A failed login returns the error the server reported.
+func Login(conn net.Conn, role string, password string) (*Client, error) {
+	w := bufio.NewWriter(conn)
+	if err := writeMessage(w, msgLogin, encodeLogin(role, password)); err != nil {
+		return nil, err
+	}
+	if err := w.Flush(); err != nil {
+		return nil, err
+	}
+	c := &Client{conn: conn, r: bufio.NewReader(conn), w: w}
+	typ, payload, err := readMessage(c.r)
+	if err != nil {
+		return nil, err
+	}
+	if typ == msgError {
+		return nil, decodeError(payload)
+	}
+	if err := c.ready(typ, payload); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// NewClient starts a session on an established connection to a server that is already bound
+// to a role, i.e. served by Server.ServeConnAs.
+func NewClient(conn net.Conn) (*Client, error) {
+	c := &Client{conn: conn, r: bufio.NewReader(conn), w: bufio.NewWriter(conn)}
+	if err := c.readReady(); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// InTransaction reports whether the session is in a transaction begun with BEGIN.
+func (c *Client) InTransaction() bool {
+	return c.status == statusInTransaction
+}
+
+// Query sends a statement and returns its result, whose rows are read from the connection as
+// Rows.Next returns them. A result still being read is discarded first. An error of the
+// statement reported before its first row is returned by Query; later ones by Rows.Next.
+func (c *Client) Query(text string) (*Rows, error) {
+	if c.rows != nil {
+		if err := c.rows.Close(); err != nil && !isStatementError(err) {
+			return nil, err
+		}
+	}
+	if err := writeMessage(c.w, msgQuery, []byte(text)); err != nil {
+		return nil, err
+	}
+	if err := c.w.Flush(); err != nil {
+		return nil, err
+	}
+	rows := &Rows{client: c}
+	typ, payload, err := readMessage(c.r)
+	if err != nil {
+		return nil, err
+	}
+	if typ == msgRowDescription {
+		if rows.Columns, err = decodeRowDescription(payload); err != nil {
+			return nil, err
+		}
+		c.rows = rows
+		return rows, nil
+	}
+	if err := rows.end(typ, payload); err != nil {
+		return nil, err
+	}
+	return rows, nil
+}
+
+// Exec runs a statement and returns its command tag, discarding its rows.
+func (c *Client) Exec(text string) (string, error) {
+	rows, err := c.Query(text)
+	if err != nil {
+		return "", err
+	}
+	if err := rows.Close(); err != nil {
+		return "", err
+	}
+	return rows.Tag, nil
+}
+
+// Close terminates the session, which rolls back its transaction, and closes the connection.
+func (c *Client) Close() error {
+	writeMessage(c.w, msgTerminate, nil)
+	c.w.Flush()
+	return c.conn.Close()
+}
+
+func (c *Client) readReady() error {
+	typ, payload, err := readMessage(c.r)
+	if err != nil {
+		return err
+	}
+	return c.ready(typ, payload)
+}
+
+func (c *Client) ready(typ byte, payload []byte) error {
+	if typ != msgReady || len(payload) != 1 {
+		return ErrProtocol
+	}
+	c.status = payload[0]
+	return nil
+}
+
+// Rows is the result of a statement.
+type Rows struct {
+	Columns *types.Schema // Columns of the rows, or nil for a statement without rows
+	Tag     string        // Command tag, e.g. "SELECT 3", set once all rows were read
+	client  *Client
+	err     error
+	done    bool
+}
+
+// Next returns the next row, or false after the last one.
+func (rs *Rows) Next() (types.Row, bool, error) {
+	if rs.done {
+		return types.Row{}, false, rs.err
+	}
+	typ, payload, err := readMessage(rs.client.r)
+	if err != nil {
+		rs.done, rs.err = true, err
+		return types.Row{}, false, err
+	}
+	if typ != msgDataRow {
+		err := rs.end(typ, payload)
+		return types.Row{}, false, err
+	}
+	tup, err := decodeDataRow(payload)
+	if err == nil {
+		var row types.Row
+		if row, err = rs.Columns.Decode(tup); err == nil {
+			return row, true, nil
+		}
+	}
+	rs.done, rs.err = true, err
+	return types.Row{}, false, err
+}
+
+// Close reads and discards the rest of the result, and returns the error of the statement, if any.
+func (rs *Rows) Close() error {
+	for !rs.done {
+		rs.Next()
+	}
+	return rs.err
+}
+
+// end reads the end of a result starting with a message other than a data row.
+func (rs *Rows) end(typ byte, payload []byte) error {
+	rs.done = true
+	switch typ {
+	case msgCommandComplete:
+		rs.Tag = string(payload)
+	case msgError:
+		rs.err = &statementError{decodeError(payload)}
+	default:
+		rs.err = ErrProtocol
+		return rs.err
+	}
+	if err := rs.client.readReady(); err != nil {
+		rs.err = err
+		return err
+	}
+	if rs.client.rows == rs {
+		rs.client.rows = nil
+	}
+	return rs.err
+}
+
+// statementError is an error the server reported for a statement; the session can go on.
+type statementError struct {
+	err error
+}
+
+func (e *statementError) Error() string {
+	return e.err.Error()
+}
+
+func (e *statementError) Unwrap() error {
+	return e.err
+}
+
+func isStatementError(err error) bool {
+	_, ok := err.(*statementError)
+	return ok
+}
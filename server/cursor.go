@@ -0,0 +1,178 @@
+package server
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+
+	"github.com/Johniel/gorelly/sql"
+)
+
+// cursorStatement is a statement on a named cursor of the session (see query.CursorManager):
+//
+//	DECLARE name CURSOR [WITH HOLD | WITHOUT HOLD] FOR query
+//	FETCH [NEXT | ALL | count | FORWARD [ALL | count]] [FROM | IN] name
+//	CLOSE name
+//
+// A cursor returns the rows of its query a FETCH at a time. It is closed when the transaction
+// that declared it ends, unless it is declared WITH HOLD, in which case its remaining rows are
+// kept when the transaction commits. Cursor names are case insensitive.
+type cursorStatement struct {
+	command  string // DECLARE, FETCH or CLOSE
+	name     string
+	holdable bool   // Whether DECLARE has WITH HOLD
+	query    string // Query of DECLARE
+	count    int    // Rows FETCH returns at most
+}
+
+// parseCursorStatement returns the cursor statement text is, or nil if it is another statement.
+func parseCursorStatement(text string) (*cursorStatement, error) {
+	word, rest := cutWord(strings.TrimSuffix(strings.TrimSpace(text), ";"))
+	stmt := &cursorStatement{command: strings.ToUpper(word)}
+	switch stmt.command {
+	case "DECLARE":
+		stmt.name, rest = cutWord(rest)
+		word, rest = cutWord(rest)
+		if stmt.name == "" || !strings.EqualFold(word, "CURSOR") {
+			return nil, fmt.Errorf("%w: expected DECLARE name CURSOR", sql.ErrSyntax)
+		}
+		word, rest = cutWord(rest)
+		if hold := strings.ToUpper(word); hold == "WITH" || hold == "WITHOUT" {
+			word, rest = cutWord(rest)
+			if !strings.EqualFold(word, "HOLD") {
+				return nil, fmt.Errorf("%w: expected %s HOLD", sql.ErrSyntax, hold)
+			}
+			stmt.holdable = hold == "WITH"
+			word, rest = cutWord(rest)
+		}
+		if !strings.EqualFold(word, "FOR") || rest == "" {
+			return nil, fmt.Errorf("%w: expected FOR and the query of the cursor", sql.ErrSyntax)
+		}
+		stmt.query = rest
+	case "FETCH":
+		words := strings.Fields(rest)
+		stmt.count = 1
+		if 1 < len(words) && strings.EqualFold(words[0], "FORWARD") {
+			words = words[1:]
+		}
+		if 1 < len(words) && !isFromOrIn(words[0]) {
+			switch count, err := strconv.Atoi(words[0]); {
+			case strings.EqualFold(words[0], "NEXT"):
+			case strings.EqualFold(words[0], "ALL"):
+				stmt.count = math.MaxInt
+			case err == nil && 0 <= count:
+				stmt.count = count
+			default:
+				return nil, fmt.Errorf("%w: unexpected %q in FETCH", sql.ErrSyntax, words[0])
+			}
+			words = words[1:]
+		}
+		if 1 < len(words) && isFromOrIn(words[0]) {
+			words = words[1:]
+		}
+		if len(words) != 1 || isFromOrIn(words[0]) {
+			return nil, fmt.Errorf("%w: expected FETCH [count] FROM name", sql.ErrSyntax)
+		}
+		stmt.name = words[0]
+	case "CLOSE":
+		words := strings.Fields(rest)
+		if len(words) != 1 {
+			return nil, fmt.Errorf("%w: expected CLOSE name", sql.ErrSyntax)
+		}
+		stmt.name = words[0]
+	default:
+		return nil, nil
+	}
+	stmt.name = strings.ToLower(stmt.name)
+	return stmt, nil
+}
+
+// cutWord returns the first word of s and the rest of s after the spaces that follow it.
+func cutWord(s string) (string, string) {
+	s = strings.TrimSpace(s)
+	if i := strings.IndexFunc(s, isSpace); 0 <= i {
+		return s[:i], strings.TrimSpace(s[i:])
+	}
+	return s, ""
+}
+
+func isFromOrIn(word string) bool {
+	return strings.EqualFold(word, "FROM") || strings.EqualFold(word, "IN")
+}
+
+func isSpace(r rune) bool {
+	return r == ' ' || r == '\t' || r == '\n' || r == '\r'
+}
+
+// cursor runs a cursor statement of the session.
+func (sess *session) cursor(stmt *cursorStatement) error {
+	bufmgr := sess.server.db.BufferPool()
+	switch stmt.command {
+	case "DECLARE":
+		return sess.declare(stmt)
+	case "FETCH":
+		rows, err := sess.cursors.Fetch(bufmgr, stmt.name, stmt.count)
+		if err != nil {
+			return err
+		}
+		if err := writeMessage(sess.w, msgRowDescription, encodeRowDescription(sess.cursorColumns[stmt.name])); err != nil {
+			return fmt.Errorf("%w: %w", errConnection, err)
+		}
+		var buf []byte
+		for _, tup := range rows {
+			buf = encodeDataRow(buf, tup)
+			if err := writeMessage(sess.w, msgDataRow, buf); err != nil {
+				return fmt.Errorf("%w: %w", errConnection, err)
+			}
+		}
+		return sess.complete(fmt.Sprintf("FETCH %d", len(rows)))
+	default:
+		if err := sess.cursors.Close(bufmgr, stmt.name); err != nil {
+			return err
+		}
+		delete(sess.cursorColumns, stmt.name)
+		return sess.complete("CLOSE CURSOR")
+	}
+}
+
+// declare starts the query of a cursor in the transaction of the session. Outside of one, only
+// a holdable cursor may be declared: its query runs in a transaction of its own, and its rows
+// are kept when it commits.
+func (sess *session) declare(stmt *cursorStatement) error {
+	plan, err := sess.server.planner.PlanQuery(stmt.query)
+	if err != nil {
+		return err
+	}
+	if plan.Explain {
+		return fmt.Errorf("%w: EXPLAIN in a cursor", sql.ErrUnsupported)
+	}
+	tx := sess.tx
+	if tx == nil {
+		if !stmt.holdable {
+			return ErrNoTransaction
+		}
+		if tx, err = sess.server.db.Begin(); err != nil {
+			return err
+		}
+	}
+	sess.prepare(tx, plan)
+	bufmgr := sess.server.db.BufferPool()
+	_, err = sess.cursors.Declare(bufmgr, stmt.name, plan.Root, stmt.holdable)
+	if sess.tx == nil {
+		if err == nil {
+			err = sess.cursors.EndTransaction(bufmgr, true)
+		}
+		if err != nil {
+			tx.Rollback()
+			return err
+		}
+		if err := tx.Commit(); err != nil {
+			return err
+		}
+	} else if err != nil {
+		return err
+	}
+	sess.cursorColumns[stmt.name] = plan.Columns
+	return sess.complete("DECLARE CURSOR")
+}
@@ -0,0 +1,440 @@
+package server
+
+import (
+	"bufio"
+	"encoding/hex"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Johniel/gorelly"
+	"github.com/Johniel/gorelly/catalog"
+	"github.com/Johniel/gorelly/transaction"
+	"github.com/Johniel/gorelly/types"
+)
+
+// TestLogic runs the declarative tests of testdata/logic, each file against a database of its
+// own and through one session, so that new behavior of the SQL stack can be covered by adding a
+// file rather than Go code. The files follow the sqllogictest format: records separated by
+// blank lines, and lines starting with # ignored. The records are:
+//
+//	statement ok
+//	<statement>
+//
+// runs a statement that must succeed, e.g. BEGIN or COMMIT.
+//
+//	statement error [regexp]
+//	<statement>
+//
+// runs a statement that must fail, with an error matching regexp if given.
+//
+//	query <types> [nosort | rowsort]
+//	<statement>
+//	----
+//	<rows>
+//
+// runs a statement and compares its rows, one per line with the values separated by spaces,
+// in order or after sorting both sides with rowsort. types has one letter per column: I for
+// INT, R for FLOAT and T for the other kinds, and NULL is written NULL.
+//
+// As the SQL dialect has no DDL nor INSERT, tables are set up through the DB facade with
+//
+//	table <name> (<column> <type> [PRIMARY KEY] [NULL], ...)
+//	[unique] index <table> (<column>, ...)
+//	insert <table>
+//	<value>, ...
+//
+// where type is a catalog.ColumnType (INT, VARCHAR, ...) and NULL makes the column nullable.
+// Each line of an insert is a row, whose values are written as queries print them or quoted
+// with single quotes. An insert runs in a transaction of its own.
+func TestLogic(t *testing.T) {
+	files, err := filepath.Glob(filepath.Join("testdata", "logic", "*.test"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) == 0 {
+		t.Fatal("no test files")
+	}
+	for _, file := range files {
+		t.Run(strings.TrimSuffix(filepath.Base(file), ".test"), func(t *testing.T) {
+			runLogicTest(t, file)
+		})
+	}
+}
+
+// logicTest is the state of the run of a test file.
+type logicTest struct {
+	t      *testing.T
+	db     *gorelly.DB
+	client *Client
+	path   string
+}
+
+// logicRecord is a record of a test file: its lines and the number of the first one.
+type logicRecord struct {
+	line  int
+	lines []string
+}
+
+func runLogicTest(t *testing.T, path string) {
+	db, err := gorelly.Open(filepath.Join(t.TempDir(), "test.rly"), gorelly.Options{
+		SyncPolicy: transaction.SyncPolicy{Mode: transaction.SyncModeNone},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	srv := New(db)
+	defer srv.Close()
+	serverConn, clientConn := net.Pipe()
+	go srv.ServeConnAs(serverConn, "")
+	client, err := NewClient(clientConn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+
+	records, err := readLogicRecords(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lt := &logicTest{t: t, db: db, client: client, path: path}
+	for _, rec := range records {
+		lt.run(rec)
+	}
+}
+
+// readLogicRecords splits a test file into records.
+func readLogicRecords(path string) ([]logicRecord, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	var records []logicRecord
+	var rec *logicRecord
+	scanner := bufio.NewScanner(f)
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimRight(scanner.Text(), " \t\r")
+		switch {
+		case strings.HasPrefix(text, "#"):
+		case text == "":
+			rec = nil
+		case rec == nil:
+			records = append(records, logicRecord{line: line, lines: []string{text}})
+			rec = &records[len(records)-1]
+		default:
+			rec.lines = append(rec.lines, text)
+		}
+	}
+	return records, scanner.Err()
+}
+
+func (lt *logicTest) errorf(rec logicRecord, format string, args ...any) {
+	lt.t.Helper()
+	lt.t.Errorf("%s:%d: %s", lt.path, rec.line, fmt.Sprintf(format, args...))
+}
+
+func (lt *logicTest) fatalf(rec logicRecord, format string, args ...any) {
+	lt.t.Helper()
+	lt.t.Fatalf("%s:%d: %s", lt.path, rec.line, fmt.Sprintf(format, args...))
+}
+
+func (lt *logicTest) run(rec logicRecord) {
+	header := strings.Fields(rec.lines[0])
+	switch {
+	case header[0] == "statement" && 2 <= len(header):
+		lt.statement(rec, header[1], strings.TrimSpace(strings.TrimPrefix(rec.lines[0], "statement "+header[1])))
+	case header[0] == "query" && 2 <= len(header):
+		lt.query(rec, header[1:])
+	case header[0] == "table":
+		lt.createTable(rec)
+	case header[0] == "index" || (header[0] == "unique" && 2 <= len(header) && header[1] == "index"):
+		lt.createIndex(rec)
+	case header[0] == "insert" && len(header) == 2:
+		lt.insert(rec, header[1])
+	default:
+		lt.fatalf(rec, "unknown record %q", rec.lines[0])
+	}
+}
+
+func (lt *logicTest) statement(rec logicRecord, expect string, pattern string) {
+	text := strings.Join(rec.lines[1:], "\n")
+	_, err := lt.client.Exec(text)
+	switch expect {
+	case "ok":
+		if err != nil {
+			lt.errorf(rec, "%s: %v", text, err)
+		}
+	case "error":
+		if err == nil {
+			lt.errorf(rec, "%s: expected an error", text)
+		} else if pattern != "" && !regexp.MustCompile(pattern).MatchString(err.Error()) {
+			lt.errorf(rec, "%s: expected an error matching %q, got %v", text, pattern, err)
+		}
+	default:
+		lt.fatalf(rec, "expected statement ok or statement error, got %q", rec.lines[0])
+	}
+}
+
+func (lt *logicTest) query(rec logicRecord, args []string) {
+	letters, sortMode := args[0], "nosort"
+	if 2 <= len(args) {
+		sortMode = args[1]
+	}
+	if sortMode != "nosort" && sortMode != "rowsort" {
+		lt.fatalf(rec, "unknown sort mode %q", sortMode)
+	}
+	sep := slices.Index(rec.lines, "----")
+	if sep < 0 {
+		sep = len(rec.lines)
+	}
+	text := strings.Join(rec.lines[1:sep], "\n")
+	var expected []string
+	if sep < len(rec.lines) {
+		for _, line := range rec.lines[sep+1:] {
+			expected = append(expected, strings.Join(strings.Fields(line), " "))
+		}
+	}
+
+	rows, err := lt.client.Query(text)
+	if err != nil {
+		lt.errorf(rec, "%s: %v", text, err)
+		return
+	}
+	if rows.Columns == nil || len(rows.Columns.Columns) != len(letters) {
+		rows.Close()
+		lt.errorf(rec, "%s: expected %d columns, got %v", text, len(letters), rows.Columns)
+		return
+	}
+	for i, col := range rows.Columns.Columns {
+		if letter := kindLetter(col.Kind); letter != letters[i] {
+			lt.errorf(rec, "%s: column %s: expected type %c, got %c", text, col.Name, letters[i], letter)
+		}
+	}
+	var got []string
+	for {
+		row, ok, err := rows.Next()
+		if err != nil {
+			lt.errorf(rec, "%s: %v", text, err)
+			return
+		}
+		if !ok {
+			break
+		}
+		var values []string
+		for _, v := range row.Values() {
+			values = append(values, v.String())
+		}
+		got = append(got, strings.Join(strings.Fields(strings.Join(values, " ")), " "))
+	}
+	if sortMode == "rowsort" {
+		slices.Sort(got)
+		slices.Sort(expected)
+	}
+	if !slices.Equal(got, expected) {
+		lt.errorf(rec, "%s: expected\n%s\ngot\n%s", text, strings.Join(expected, "\n"), strings.Join(got, "\n"))
+	}
+}
+
+// kindLetter returns the letter of a column kind in the types of a query record.
+func kindLetter(kind types.Kind) byte {
+	switch kind {
+	case types.KindInt64:
+		return 'I'
+	case types.KindFloat64:
+		return 'R'
+	default:
+		return 'T'
+	}
+}
+
+// parseColumnType returns the column type of the name catalog.ColumnType.String gives it.
+func parseColumnType(name string) (catalog.ColumnType, bool) {
+	for ct := catalog.ColumnTypeInt; ct <= catalog.ColumnTypeBool; ct++ {
+		if strings.EqualFold(ct.String(), name) {
+			return ct, true
+		}
+	}
+	return 0, false
+}
+
+// splitParens returns the text before the parentheses at the end of s and the comma-separated
+// items between them.
+func splitParens(s string) (string, []string, bool) {
+	open := strings.Index(s, "(")
+	if open < 0 || !strings.HasSuffix(s, ")") {
+		return "", nil, false
+	}
+	items := strings.Split(s[open+1:len(s)-1], ",")
+	for i := range items {
+		items[i] = strings.TrimSpace(items[i])
+	}
+	return strings.TrimSpace(s[:open]), items, true
+}
+
+func (lt *logicTest) createTable(rec logicRecord) {
+	head, items, ok := splitParens(strings.Join(rec.lines, " "))
+	name := strings.TrimSpace(strings.TrimPrefix(head, "table"))
+	if !ok || name == "" {
+		lt.fatalf(rec, "expected table <name> (<column> <type>, ...)")
+	}
+	var columns []catalog.ColumnDef
+	for _, item := range items {
+		words := strings.Fields(item)
+		if len(words) < 2 {
+			lt.fatalf(rec, "invalid column %q", item)
+		}
+		ct, ok := parseColumnType(words[1])
+		if !ok {
+			lt.fatalf(rec, "unknown type %q", words[1])
+		}
+		col := catalog.ColumnDef{Name: words[0], Type: ct}
+		switch options := strings.ToUpper(strings.Join(words[2:], " ")); options {
+		case "":
+		case "PRIMARY KEY":
+			col.IsPrimaryKey = true
+		case "NULL":
+			col.Nullable = true
+		default:
+			lt.fatalf(rec, "invalid column options %q", options)
+		}
+		columns = append(columns, col)
+	}
+	if _, err := lt.db.CreateTable(name, columns); err != nil {
+		lt.fatalf(rec, "%v", err)
+	}
+}
+
+func (lt *logicTest) createIndex(rec logicRecord) {
+	head, columns, ok := splitParens(rec.lines[0])
+	words := strings.Fields(head)
+	unique := words[0] == "unique"
+	if unique {
+		words = words[1:]
+	}
+	if !ok || len(words) != 2 {
+		lt.fatalf(rec, "expected [unique] index <table> (<column>, ...)")
+	}
+	if _, err := lt.db.CreateIndex(words[1], columns, unique); err != nil {
+		lt.fatalf(rec, "%v", err)
+	}
+}
+
+func (lt *logicTest) insert(rec logicRecord, tableName string) {
+	schema, err := lt.db.Catalog().GetTableSchema(tableName)
+	if err != nil {
+		lt.fatalf(rec, "%v", err)
+	}
+	tx, err := lt.db.Begin()
+	if err != nil {
+		lt.fatalf(rec, "%v", err)
+	}
+	defer tx.Rollback()
+	tbl, err := tx.Table(tableName)
+	if err != nil {
+		lt.fatalf(rec, "%v", err)
+	}
+	for i, line := range rec.lines[1:] {
+		fields, err := splitLogicRow(line)
+		if err != nil || len(fields) != len(schema.Columns) {
+			lt.fatalf(rec, "row %d: expected %d values, got %q", i+1, len(schema.Columns), line)
+		}
+		values := make([]any, len(fields))
+		for j, field := range fields {
+			if values[j], err = parseLogicValue(schema.Columns[j].Type, field); err != nil {
+				lt.fatalf(rec, "row %d: column %s: %v", i+1, schema.Columns[j].Name, err)
+			}
+		}
+		tup, err := schema.BindRow(values...)
+		if err == nil {
+			err = tbl.Insert(lt.db.BufferPool(), tup)
+		}
+		if err != nil {
+			lt.fatalf(rec, "row %d: %v", i+1, err)
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		lt.fatalf(rec, "%v", err)
+	}
+}
+
+// logicField is a value of a row of an insert record, and whether it was quoted.
+type logicField struct {
+	text   string
+	quoted bool
+}
+
+// splitLogicRow splits a row of an insert record into its comma-separated values. A quoted
+// value may contain commas, and a quote written twice.
+func splitLogicRow(line string) ([]logicField, error) {
+	var fields []logicField
+	for rest := line; ; {
+		rest = strings.TrimSpace(rest)
+		var field logicField
+		if strings.HasPrefix(rest, "'") {
+			var b strings.Builder
+			i := 1
+			for ; i < len(rest); i++ {
+				if rest[i] != '\'' {
+					b.WriteByte(rest[i])
+				} else if i+1 < len(rest) && rest[i+1] == '\'' {
+					b.WriteByte('\'')
+					i++
+				} else {
+					break
+				}
+			}
+			if len(rest) <= i {
+				return nil, fmt.Errorf("unterminated quote")
+			}
+			field = logicField{text: b.String(), quoted: true}
+			rest = strings.TrimSpace(rest[i+1:])
+			if rest != "" && !strings.HasPrefix(rest, ",") {
+				return nil, fmt.Errorf("expected a comma after %q", field.text)
+			}
+		} else {
+			end := strings.Index(rest, ",")
+			if end < 0 {
+				end = len(rest)
+			}
+			field = logicField{text: strings.TrimSpace(rest[:end])}
+			rest = rest[end:]
+		}
+		fields = append(fields, field)
+		if rest == "" {
+			return fields, nil
+		}
+		rest = rest[1:]
+	}
+}
+
+// parseLogicValue converts a value of an insert record to a Go value of the column type.
+func parseLogicValue(ct catalog.ColumnType, field logicField) (any, error) {
+	if !field.quoted && strings.EqualFold(field.text, "NULL") {
+		return types.Null, nil
+	}
+	switch ct {
+	case catalog.ColumnTypeInt:
+		return strconv.ParseInt(field.text, 10, 64)
+	case catalog.ColumnTypeFloat:
+		return strconv.ParseFloat(field.text, 64)
+	case catalog.ColumnTypeBool:
+		return strconv.ParseBool(field.text)
+	case catalog.ColumnTypeBlob:
+		return hex.DecodeString(field.text)
+	case catalog.ColumnTypeTimestamp:
+		return time.Parse(time.RFC3339Nano, field.text)
+	case catalog.ColumnTypeInterval:
+		return time.ParseDuration(field.text)
+	default:
+		return field.text, nil
+	}
+}
@@ -0,0 +1,219 @@
+package server
+
+import (
+	"bufio"
+	"encoding/binary"
+	"io"
+
+	"github.com/Johniel/gorelly/errcode"
+	"github.com/Johniel/gorelly/types"
+)
+
+var (
+	// ErrProtocol is returned when a peer sends a message the protocol does not allow.
+	ErrProtocol = errcode.New(errcode.DataException, "protocol violation")
+	// ErrMessageTooLarge is returned when a peer sends a message longer than MaxMessageSize.
+	ErrMessageTooLarge = errcode.New(errcode.LimitExceeded, "message too large")
+)
+
+// MaxMessageSize is the maximum length of the payload of a message.
+const MaxMessageSize = 1 << 24
+
+// Every message is framed as [type:1][payload length:4][payload], with the length in big endian.
+// The client sends:
+//
+//	'L' login:     [role length][role][password length][password] as uvarints but the strings
+//	'Q' query:     the text of a statement
+//	'X' terminate: no payload; the server closes the connection
+//
+// A session served by Server.Serve or Server.ServeConn starts with a login, which the server
+// answers with ready for query, or with an error after which it closes the connection.
+//
+// and the server answers every query with an optional row description, the data rows, and a
+// command complete or an error, followed by ready for query:
+//
+//	'T' row description:  [column count][name length][name][kind:1] ... as uvarints but the kind
+//	'D' data row:         [value count][value length][value] ... as uvarints but the values
+//	'C' command complete: the command tag, e.g. "SELECT 3" or "COMMIT"
+//	'E' error:            [code:5][message]
+//	'Z' ready for query:  [transaction status:1]
+//
+// The values of a data row are the encodings of their kinds (see types.Value.Encode); an empty
+// value is NULL. The server also sends ready for query once the session is bound to a role.
+const (
+	msgLogin           byte = 'L'
+	msgQuery           byte = 'Q'
+	msgTerminate       byte = 'X'
+	msgRowDescription  byte = 'T'
+	msgDataRow         byte = 'D'
+	msgCommandComplete byte = 'C'
+	msgError           byte = 'E'
+	msgReady           byte = 'Z'
+)
+
+// Transaction status of a session, sent with ready for query.
+const (
+	statusIdle          byte = 'I' // Not in a transaction
+	statusInTransaction byte = 'T' // In a transaction begun with BEGIN
+)
+
+func writeMessage(w *bufio.Writer, typ byte, payload []byte) error {
+	var header [5]byte
+	header[0] = typ
+	binary.BigEndian.PutUint32(header[1:], uint32(len(payload)))
+	if _, err := w.Write(header[:]); err != nil {
+		return err
+	}
+	_, err := w.Write(payload)
+	return err
+}
+
+func readMessage(r *bufio.Reader) (byte, []byte, error) {
+	var header [5]byte
+	if _, err := io.ReadFull(r, header[:]); err != nil {
+		return 0, nil, err
+	}
+	n := binary.BigEndian.Uint32(header[1:])
+	if MaxMessageSize < n {
+		return 0, nil, ErrMessageTooLarge
+	}
+	payload := make([]byte, n)
+	if _, err := io.ReadFull(r, payload); err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return 0, nil, err
+	}
+	return header[0], payload, nil
+}
+
+func encodeLogin(role string, password string) []byte {
+	buf := binary.AppendUvarint(nil, uint64(len(role)))
+	buf = append(buf, role...)
+	buf = binary.AppendUvarint(buf, uint64(len(password)))
+	return append(buf, password...)
+}
+
+func decodeLogin(payload []byte) (string, string, error) {
+	d := decoder{buf: payload}
+	role := d.bytes()
+	password := d.bytes()
+	if err := d.finish(); err != nil {
+		return "", "", err
+	}
+	return string(role), string(password), nil
+}
+
+func encodeRowDescription(columns *types.Schema) []byte {
+	buf := binary.AppendUvarint(nil, uint64(len(columns.Columns)))
+	for _, col := range columns.Columns {
+		buf = binary.AppendUvarint(buf, uint64(len(col.Name)))
+		buf = append(buf, col.Name...)
+		buf = append(buf, byte(col.Kind))
+	}
+	return buf
+}
+
+func decodeRowDescription(payload []byte) (*types.Schema, error) {
+	d := decoder{buf: payload}
+	n := d.uvarint()
+	var columns []types.Column
+	for i := uint64(0); i < n && d.err == nil; i++ {
+		name := d.bytes()
+		kind := d.uint8()
+		columns = append(columns, types.Column{Name: string(name), Kind: types.Kind(kind)})
+	}
+	if err := d.finish(); err != nil {
+		return nil, err
+	}
+	return types.NewSchema(columns...), nil
+}
+
+func encodeDataRow(buf []byte, tup [][]byte) []byte {
+	buf = binary.AppendUvarint(buf[:0], uint64(len(tup)))
+	for _, v := range tup {
+		buf = binary.AppendUvarint(buf, uint64(len(v)))
+		buf = append(buf, v...)
+	}
+	return buf
+}
+
+func decodeDataRow(payload []byte) ([][]byte, error) {
+	d := decoder{buf: payload}
+	n := d.uvarint()
+	var tup [][]byte
+	for i := uint64(0); i < n && d.err == nil; i++ {
+		tup = append(tup, d.bytes())
+	}
+	if err := d.finish(); err != nil {
+		return nil, err
+	}
+	return tup, nil
+}
+
+func encodeError(err error) []byte {
+	return append([]byte(errcode.Of(err)), err.Error()...)
+}
+
+// decodeError returns the error an error message reports, carrying its code.
+func decodeError(payload []byte) error {
+	if len(payload) < len(errcode.OK) {
+		return ErrProtocol
+	}
+	return errcode.New(errcode.Code(payload[:len(errcode.OK)]), string(payload[len(errcode.OK):]))
+}
+
+// decoder reads the fields of a payload, remembering the first error.
+type decoder struct {
+	buf []byte
+	err error
+}
+
+func (d *decoder) uvarint() uint64 {
+	if d.err != nil {
+		return 0
+	}
+	v, n := binary.Uvarint(d.buf)
+	if n <= 0 {
+		d.err = ErrProtocol
+		return 0
+	}
+	d.buf = d.buf[n:]
+	return v
+}
+
+// bytes reads a field prefixed with its length.
+func (d *decoder) bytes() []byte {
+	n := d.uvarint()
+	if d.err != nil {
+		return nil
+	}
+	if uint64(len(d.buf)) < n {
+		d.err = ErrProtocol
+		return nil
+	}
+	b := d.buf[:n:n]
+	d.buf = d.buf[n:]
+	return b
+}
+
+func (d *decoder) uint8() byte {
+	if d.err != nil {
+		return 0
+	}
+	if len(d.buf) == 0 {
+		d.err = ErrProtocol
+		return 0
+	}
+	b := d.buf[0]
+	d.buf = d.buf[1:]
+	return b
+}
+
+// finish returns the first error, or ErrProtocol if the payload has bytes left.
+func (d *decoder) finish() error {
+	if d.err == nil && len(d.buf) != 0 {
+		return ErrProtocol
+	}
+	return d.err
+}
@@ -0,0 +1,463 @@
+// Package server serves a database over TCP. Clients send SQL statements in the framed
+// protocol described in protocol.go, which the server compiles with the sql package, runs with
+// the query engine, and answers by streaming the result rows as they are produced.
+//
+// Every connection is a session, bound to a role of the catalog: a client logs in with the
+// name and password of the role (see catalog.CatalogManager.SetPassword) before its first
+// statement. The statements run with the privileges of the role (see sql.Plan.RunAs), which
+// must hold SELECT on the tables they read, and see the rows and values the security policies
+// and column encryption of the tables leave to the role (see Server.SetKeyring).
+//
+// Besides the statements of the sql package, a session accepts
+// BEGIN (or START TRANSACTION), COMMIT (or END) and ROLLBACK (or ABORT), which bind it to a
+// transaction of the database until it ends; a transaction still open when the connection
+// closes is rolled back. The statements of a session run in its transaction, or each in a
+// transaction of its own outside of one, and lock the keys they read until it ends (see
+// sql.Plan.LockKeys), so that the rows a transaction read are not changed under it. Versioned
+// tables are read at the snapshot of the transaction (see gorelly.Tx.Snapshot) and show no
+// uncommitted rows of other sessions.
+//
+// A session also accepts DECLARE, FETCH and CLOSE on named cursors, whose rows are fetched a
+// batch at a time; a cursor declared WITH HOLD outlives the transaction that declared it.
+package server
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+
+	"github.com/Johniel/gorelly"
+	"github.com/Johniel/gorelly/catalog"
+	"github.com/Johniel/gorelly/disk"
+	"github.com/Johniel/gorelly/errcode"
+	"github.com/Johniel/gorelly/query"
+	"github.com/Johniel/gorelly/sql"
+	"github.com/Johniel/gorelly/types"
+)
+
+var (
+	// ErrServerClosed is returned by Serve after Close.
+	ErrServerClosed = errcode.New(errcode.ObjectNotInPrerequisiteState, "server closed")
+	// ErrInTransaction is returned for BEGIN in a session that is already in a transaction.
+	ErrInTransaction = errcode.New(errcode.InvalidTransactionState, "there is already a transaction in progress")
+	// ErrNoTransaction is returned for COMMIT and ROLLBACK, and DECLARE without WITH HOLD, in a
+	// session that is not in a transaction.
+	ErrNoTransaction = errcode.New(errcode.InvalidTransactionState, "there is no transaction in progress")
+)
+
+// Server accepts connections and runs a session for each of them.
+type Server struct {
+	db        *gorelly.DB
+	planner   *sql.Planner
+	listeners map[net.Listener]bool
+	conns     map[net.Conn]bool
+	closed    bool
+	sessions  sync.WaitGroup
+	mu        sync.Mutex
+}
+
+// New returns a server running statements against db.
+func New(db *gorelly.DB) *Server {
+	planner := sql.NewPlanner(db.Catalog())
+	planner.SetClock(db.Clock())
+	return &Server{
+		db:        db,
+		planner:   planner,
+		listeners: make(map[net.Listener]bool),
+		conns:     make(map[net.Conn]bool),
+	}
+}
+
+// SetKeyring sets the keys the statements decrypt the encrypted columns with, for the roles
+// allowed to see them (see catalog.ColumnEncryption). It must be called before serving.
+func (s *Server) SetKeyring(kr *catalog.ColumnKeyring) {
+	s.planner.SetKeyring(kr)
+}
+
+// ListenAndServe listens on the TCP address addr and serves the connections to it.
+func (s *Server) ListenAndServe(addr string) error {
+	l, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	return s.Serve(l)
+}
+
+// Serve accepts connections on l until it fails or the server is closed, in which case it
+// returns ErrServerClosed. It closes l before returning.
+func (s *Server) Serve(l net.Listener) error {
+	s.mu.Lock()
+	if s.closed {
+		s.mu.Unlock()
+		l.Close()
+		return ErrServerClosed
+	}
+	s.listeners[l] = true
+	s.mu.Unlock()
+	defer func() {
+		s.mu.Lock()
+		delete(s.listeners, l)
+		s.mu.Unlock()
+		l.Close()
+	}()
+
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			s.mu.Lock()
+			closed := s.closed
+			s.mu.Unlock()
+			if closed {
+				return ErrServerClosed
+			}
+			return err
+		}
+		if !s.track(conn) {
+			return ErrServerClosed
+		}
+		go s.serveConn(conn, "", true)
+	}
+}
+
+// ServeConn runs a session on an established connection, e.g. an end of a net.Pipe, until it
+// ends. The client logs in first, as with Serve.
+func (s *Server) ServeConn(conn net.Conn) {
+	if s.track(conn) {
+		s.serveConn(conn, "", true)
+	}
+}
+
+// ServeConnAs runs a session bound to role on an established connection whose peer the caller
+// has authenticated, e.g. an in-process client, without a login. The empty role runs the
+// statements with every privilege, as the application embedding the database does.
+func (s *Server) ServeConnAs(conn net.Conn, role string) {
+	if s.track(conn) {
+		s.serveConn(conn, role, false)
+	}
+}
+
+// track registers a connection to serve, or closes it if the server is closed.
+func (s *Server) track(conn net.Conn) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed {
+		conn.Close()
+		return false
+	}
+	s.conns[conn] = true
+	s.sessions.Add(1)
+	return true
+}
+
+// Close stops the listeners, closes every connection and waits for their sessions to end,
+// rolling back the transactions they left open. It does not close the database.
+func (s *Server) Close() error {
+	s.mu.Lock()
+	s.closed = true
+	var err error
+	for l := range s.listeners {
+		err = errors.Join(err, l.Close())
+	}
+	for conn := range s.conns {
+		conn.Close()
+	}
+	s.mu.Unlock()
+	s.sessions.Wait()
+	return err
+}
+
+func (s *Server) serveConn(conn net.Conn, role string, login bool) {
+	sess := &session{
+		server:        s,
+		r:             bufio.NewReader(conn),
+		w:             bufio.NewWriter(conn),
+		role:          role,
+		login:         login,
+		cursors:       query.NewCursorManager(),
+		cursorColumns: make(map[string]*types.Schema),
+	}
+	defer func() {
+		sess.cursors.EndTransaction(s.db.BufferPool(), false)
+		if sess.tx != nil {
+			sess.tx.Rollback()
+		}
+		conn.Close()
+		s.mu.Lock()
+		delete(s.conns, conn)
+		s.mu.Unlock()
+		s.sessions.Done()
+	}()
+	sess.run()
+}
+
+// session is the state of a connection.
+type session struct {
+	server        *Server
+	r             *bufio.Reader
+	w             *bufio.Writer
+	role          string      // Role the statements run as, or "" for every privilege
+	login         bool        // Whether the client logs in before its first statement
+	tx            *gorelly.Tx // Transaction begun with BEGIN, if any
+	cursors       *query.CursorManager
+	cursorColumns map[string]*types.Schema // Columns of the rows of the cursors, by name
+}
+
+// run answers the queries of the client until it terminates or the connection fails.
+func (sess *session) run() {
+	if sess.login {
+		if err := sess.authenticate(); err != nil {
+			return
+		}
+	}
+	if err := sess.ready(); err != nil {
+		return
+	}
+	for {
+		typ, payload, err := readMessage(sess.r)
+		if err != nil {
+			if err == ErrMessageTooLarge {
+				writeMessage(sess.w, msgError, encodeError(err))
+				sess.w.Flush()
+			}
+			return
+		}
+		switch typ {
+		case msgQuery:
+			err = sess.query(string(payload))
+		case msgTerminate:
+			return
+		default:
+			writeMessage(sess.w, msgError, encodeError(ErrProtocol))
+			sess.w.Flush()
+			return
+		}
+		if err != nil {
+			return
+		}
+	}
+}
+
+// authenticate reads the login of the client and binds the session to its role. A failed login
+// is reported to the client and returned.
+func (sess *session) authenticate() error {
+	typ, payload, err := readMessage(sess.r)
+	if err != nil {
+		return err
+	}
+	role, password := "", ""
+	if typ != msgLogin {
+		err = ErrProtocol
+	} else if role, password, err = decodeLogin(payload); err == nil {
+		if role == "" {
+			err = catalog.ErrAuthenticationFailed
+		} else {
+			err = sess.server.db.Catalog().Authenticate(role, password)
+		}
+	}
+	if err != nil {
+		writeMessage(sess.w, msgError, encodeError(err))
+		sess.w.Flush()
+		return err
+	}
+	sess.role = role
+	return nil
+}
+
+// ready tells the client the session waits for the next query.
+func (sess *session) ready() error {
+	status := statusIdle
+	if sess.tx != nil {
+		status = statusInTransaction
+	}
+	if err := writeMessage(sess.w, msgReady, []byte{status}); err != nil {
+		return err
+	}
+	return sess.w.Flush()
+}
+
+// query runs a statement and sends its result. Errors of the statement are sent to the client;
+// only errors of the connection are returned.
+func (sess *session) query(text string) error {
+	err := sess.execute(text)
+	if errors.Is(err, errConnection) {
+		return err
+	}
+	if err != nil {
+		if err := writeMessage(sess.w, msgError, encodeError(err)); err != nil {
+			return err
+		}
+	}
+	return sess.ready()
+}
+
+// errConnection marks the errors of execute raised by writing to the connection.
+var errConnection = errors.New("connection failed")
+
+// explainColumns are the columns of the result of EXPLAIN.
+var explainColumns = types.NewSchema(types.Column{Name: "QUERY PLAN", Kind: types.KindVarchar})
+
+func (sess *session) execute(text string) error {
+	switch transactionCommand(text) {
+	case "BEGIN":
+		if sess.tx != nil {
+			return ErrInTransaction
+		}
+		tx, err := sess.server.db.Begin()
+		if err != nil {
+			return err
+		}
+		sess.tx = tx
+		return sess.complete("BEGIN")
+	case "COMMIT":
+		if sess.tx == nil {
+			return ErrNoTransaction
+		}
+		tx := sess.tx
+		sess.tx = nil
+		// Holdable cursors keep their remaining rows, read in the transaction
+		if err := sess.cursors.EndTransaction(sess.server.db.BufferPool(), true); err != nil {
+			tx.Rollback()
+			return err
+		}
+		if err := tx.Commit(); err != nil {
+			tx.Rollback()
+			return err
+		}
+		return sess.complete("COMMIT")
+	case "ROLLBACK":
+		if sess.tx == nil {
+			return ErrNoTransaction
+		}
+		tx := sess.tx
+		sess.tx = nil
+		sess.cursors.EndTransaction(sess.server.db.BufferPool(), false)
+		if err := tx.Rollback(); err != nil {
+			return err
+		}
+		return sess.complete("ROLLBACK")
+	}
+
+	if stmt, err := parseCursorStatement(text); err != nil || stmt != nil {
+		if err != nil {
+			return err
+		}
+		return sess.cursor(stmt)
+	}
+
+	plan, err := sess.server.planner.PlanQuery(text)
+	if err != nil {
+		return err
+	}
+	if plan.Explain {
+		return sess.explain(plan)
+	}
+	if sess.tx != nil {
+		rows, err := sess.runPlan(sess.tx, plan)
+		if err != nil {
+			return err
+		}
+		return sess.complete(fmt.Sprintf("SELECT %d", rows))
+	}
+
+	// Outside of a transaction, the statement runs in one of its own
+	tx, err := sess.server.db.Begin()
+	if err != nil {
+		return err
+	}
+	rows, err := sess.runPlan(tx, plan)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+	return sess.complete(fmt.Sprintf("SELECT %d", rows))
+}
+
+// prepare makes a plan run in a transaction, as the role of the session.
+func (sess *session) prepare(tx *gorelly.Tx, plan *sql.Plan) {
+	locks := sess.server.db.Locks()
+	plan.LockKeys(func(tableMetaPageID disk.PageID) func(keyBytes []byte) error {
+		return locks.ScanLocker(tx.Txn(), tableMetaPageID)
+	})
+	plan.ReadAt(tx.Snapshot)
+	if sess.role != "" {
+		plan.RunAs(sess.role)
+	}
+}
+
+// runPlan runs the plan of a query in a transaction and sends its rows, returning their number.
+// The query is recorded in the workload of the database for DB.AdviseIndexes.
+func (sess *session) runPlan(tx *gorelly.Tx, plan *sql.Plan) (int, error) {
+	sess.prepare(tx, plan)
+	bufmgr := sess.server.db.BufferPool()
+	exec, err := plan.Root.Start(bufmgr)
+	if err != nil {
+		return 0, err
+	}
+	// Scans keep their leaf pinned until they are closed, also when sending a row fails
+	defer query.CloseExecutor(bufmgr, exec)
+	if err := writeMessage(sess.w, msgRowDescription, encodeRowDescription(plan.Columns)); err != nil {
+		return 0, fmt.Errorf("%w: %w", errConnection, err)
+	}
+	var buf []byte
+	rows := 0
+	for {
+		tup, ok, err := exec.Next(bufmgr)
+		if err != nil {
+			return 0, err
+		}
+		if !ok {
+			break
+		}
+		buf = encodeDataRow(buf, tup)
+		if err := writeMessage(sess.w, msgDataRow, buf); err != nil {
+			return 0, fmt.Errorf("%w: %w", errConnection, err)
+		}
+		rows++
+	}
+	plan.Record(sess.server.db.Workload(), uint64(rows))
+	return rows, nil
+}
+
+// explain sends the plan as EXPLAIN shows it, one row per line in a single column.
+func (sess *session) explain(plan *sql.Plan) error {
+	lines := strings.Split(strings.TrimSuffix(plan.String(), "\n"), "\n")
+	if err := writeMessage(sess.w, msgRowDescription, encodeRowDescription(explainColumns)); err != nil {
+		return fmt.Errorf("%w: %w", errConnection, err)
+	}
+	var buf []byte
+	for _, line := range lines {
+		buf = encodeDataRow(buf, [][]byte{[]byte(line)})
+		if err := writeMessage(sess.w, msgDataRow, buf); err != nil {
+			return fmt.Errorf("%w: %w", errConnection, err)
+		}
+	}
+	return sess.complete("EXPLAIN")
+}
+
+func (sess *session) complete(tag string) error {
+	if err := writeMessage(sess.w, msgCommandComplete, []byte(tag)); err != nil {
+		return fmt.Errorf("%w: %w", errConnection, err)
+	}
+	return nil
+}
+
+// transactionCommand returns BEGIN, COMMIT or ROLLBACK if text is a statement controlling the
+// transaction of the session, and "" otherwise.
+func transactionCommand(text string) string {
+	words := strings.Fields(strings.ToUpper(strings.TrimSuffix(strings.TrimSpace(text), ";")))
+	switch strings.Join(words, " ") {
+	case "BEGIN", "BEGIN TRANSACTION", "START TRANSACTION":
+		return "BEGIN"
+	case "COMMIT", "COMMIT TRANSACTION", "END", "END TRANSACTION":
+		return "COMMIT"
+	case "ROLLBACK", "ROLLBACK TRANSACTION", "ABORT", "ABORT TRANSACTION":
+		return "ROLLBACK"
+	}
+	return ""
+}
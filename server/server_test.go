@@ -0,0 +1,375 @@
+package server
+
+import (
+	"bytes"
+	"fmt"
+	"net"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/Johniel/gorelly"
+	"github.com/Johniel/gorelly/catalog"
+	"github.com/Johniel/gorelly/errcode"
+	"github.com/Johniel/gorelly/transaction"
+	"github.com/Johniel/gorelly/types"
+)
+
+func TestServer(t *testing.T) {
+	db, err := gorelly.Open(filepath.Join(t.TempDir(), "test.rly"), gorelly.Options{
+		SyncPolicy: transaction.SyncPolicy{Mode: transaction.SyncModeNone},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	_, err = db.Catalog().CreateTable("users", []catalog.ColumnDef{
+		{Name: "id", Type: catalog.ColumnTypeInt, IsPrimaryKey: true},
+		{Name: "name", Type: catalog.ColumnTypeVarchar, Nullable: true},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Catalog().CreateRole("app", true); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Catalog().SetPassword("app", "secret"); err != nil {
+		t.Fatal(err)
+	}
+	tx, err := db.Begin()
+	if err != nil {
+		t.Fatal(err)
+	}
+	users, err := tx.Table("users")
+	if err != nil {
+		t.Fatal(err)
+	}
+	const n = 1000
+	for i := int64(1); i <= n; i++ {
+		name := []byte(fmt.Sprintf("user%d", i))
+		if i == 2 {
+			name = nil
+		}
+		if err := users.Insert(db.BufferPool(), [][]byte{types.EncodeInt(i), name}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		t.Fatal(err)
+	}
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := New(db)
+	served := make(chan error, 1)
+	go func() { served <- srv.Serve(l) }()
+
+	c, err := Dial(l.Addr().String(), "app", "secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Rows are streamed with their columns
+	rows, err := c.Query("SELECT name, id FROM users WHERE id <= 3 ORDER BY id DESC")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rows.Columns.Columns[0] != (types.Column{Name: "name", Kind: types.KindVarchar}) {
+		t.Errorf("unexpected columns %+v", rows.Columns.Columns)
+	}
+	var got []string
+	for {
+		row, ok, err := rows.Next()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !ok {
+			break
+		}
+		got = append(got, fmt.Sprintf("%s/%d", row.At(0), row.At(1).Int64()))
+	}
+	if want := []string{"user3/3", "NULL/2", "user1/1"}; fmt.Sprint(got) != fmt.Sprint(want) || rows.Tag != "SELECT 3" {
+		t.Errorf("expected %v and SELECT 3, got %v and %q", want, got, rows.Tag)
+	}
+
+	// A result left unread is discarded by the next statement
+	if _, err := c.Query("SELECT * FROM users"); err != nil {
+		t.Fatal(err)
+	}
+	if tag, err := c.Exec("SELECT id FROM users WHERE id > 10"); err != nil || tag != fmt.Sprintf("SELECT %d", n-10) {
+		t.Errorf("expected SELECT %d, got %q, %v", n-10, tag, err)
+	}
+
+	// Errors carry their codes and leave the session usable
+	if _, err := c.Query("SELEC * FROM users"); errcode.Of(err) != errcode.SyntaxError {
+		t.Errorf("expected a syntax error, got %v", err)
+	}
+	if _, err := c.Query("SELECT * FROM missing"); errcode.Of(err) != errcode.NotFound {
+		t.Errorf("expected table not found, got %v", err)
+	}
+	rows, err = c.Query("EXPLAIN SELECT name FROM users WHERE id = 5")
+	if err != nil {
+		t.Fatal(err)
+	}
+	row, ok, err := rows.Next()
+	if err != nil || !ok || row.At(0).Varchar() != "Project: name" {
+		t.Errorf("expected the plan, got %v, %v, %v", row.At(0), ok, err)
+	}
+	if err := rows.Close(); err != nil || rows.Tag != "EXPLAIN" {
+		t.Errorf("expected EXPLAIN, got %q, %v", rows.Tag, err)
+	}
+
+	t.Run("Transactions", func(t *testing.T) {
+		if _, err := c.Exec("COMMIT"); err == nil || err.Error() != ErrNoTransaction.Error() {
+			t.Errorf("expected ErrNoTransaction, got %v", err)
+		}
+		if tag, err := c.Exec("begin;"); err != nil || tag != "BEGIN" || !c.InTransaction() {
+			t.Fatalf("expected to be in a transaction, got %q, %v", tag, err)
+		}
+		if _, err := c.Exec("START TRANSACTION"); errcode.Of(err) != errcode.InvalidTransactionState {
+			t.Errorf("expected ErrInTransaction, got %v", err)
+		}
+
+		// The keys a transaction read are locked until it ends
+		if tag, err := c.Exec("SELECT id FROM users WHERE id > 990"); err != nil || tag != "SELECT 10" {
+			t.Fatalf("expected SELECT 10, got %q, %v", tag, err)
+		}
+		inserted := make(chan error)
+		go func() {
+			tx, err := db.Begin()
+			if err != nil {
+				inserted <- err
+				return
+			}
+			users, err := tx.Table("users")
+			if err != nil {
+				inserted <- err
+				return
+			}
+			if err := users.Insert(db.BufferPool(), [][]byte{types.EncodeInt(n + 1), nil}); err != nil {
+				tx.Rollback()
+				inserted <- err
+				return
+			}
+			inserted <- tx.Commit()
+		}()
+		select {
+		case err := <-inserted:
+			t.Fatalf("expected the insert to wait for the reading transaction, got %v", err)
+		case <-time.After(50 * time.Millisecond):
+		}
+		if tag, err := c.Exec("COMMIT"); err != nil || tag != "COMMIT" || c.InTransaction() {
+			t.Errorf("expected the transaction to end, got %q, %v", tag, err)
+		}
+		if err := <-inserted; err != nil {
+			t.Fatal(err)
+		}
+		if tag, err := c.Exec("SELECT id FROM users WHERE id > 990"); err != nil || tag != "SELECT 11" {
+			t.Errorf("expected SELECT 11, got %q, %v", tag, err)
+		}
+
+		// A session closed in a transaction rolls it back
+		other, err := Dial(l.Addr().String(), "app", "secret")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := other.Exec("BEGIN"); err != nil {
+			t.Fatal(err)
+		}
+		if len(db.Transactions().ActiveTransactions()) != 1 {
+			t.Errorf("expected the session's transaction to be active")
+		}
+		other.Close()
+		for deadline := time.Now().Add(5 * time.Second); 0 < len(db.Transactions().ActiveTransactions()); {
+			if deadline.Before(time.Now()) {
+				t.Fatal("expected the transaction to be rolled back")
+			}
+			time.Sleep(time.Millisecond)
+		}
+	})
+
+	t.Run("BrokenConnection", func(t *testing.T) {
+		// A connection broken while rows are sent releases the scan and rolls back its statement
+		serverConn, clientConn := net.Pipe()
+		go srv.ServeConn(serverConn)
+		broken, err := Login(clientConn, "app", "secret")
+		if err != nil {
+			t.Fatal(err)
+		}
+		rows, err := broken.Query("SELECT * FROM users")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, ok, err := rows.Next(); err != nil || !ok {
+			t.Fatalf("expected a row, got %v, %v", ok, err)
+		}
+		// The connection drops without the client saying goodbye
+		clientConn.Close()
+		for deadline := time.Now().Add(5 * time.Second); 0 < len(db.Transactions().ActiveTransactions()); {
+			if deadline.Before(time.Now()) {
+				t.Fatal("expected the statement's transaction to be rolled back")
+			}
+			time.Sleep(time.Millisecond)
+		}
+		if pinned := db.BufferPool().PoolStats().Pinned; pinned != 0 {
+			t.Errorf("expected no pinned page, got %d", pinned)
+		}
+	})
+
+	if _, err := c.Exec("BEGIN"); err != nil {
+		t.Fatal(err)
+	}
+	if err := srv.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := <-served; err != ErrServerClosed {
+		t.Errorf("expected ErrServerClosed, got %v", err)
+	}
+	if len(db.Transactions().ActiveTransactions()) != 0 {
+		t.Errorf("expected Close to roll back the open transactions")
+	}
+	if _, err := c.Exec("SELECT * FROM users"); err == nil {
+		t.Errorf("expected the connection to be closed")
+	}
+}
+
+func TestServerRoles(t *testing.T) {
+	db, err := gorelly.Open(filepath.Join(t.TempDir(), "test.rly"), gorelly.Options{
+		SyncPolicy: transaction.SyncPolicy{Mode: transaction.SyncModeNone},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	keyring := catalog.NewColumnKeyring()
+	if err := keyring.AddKey(1, bytes.Repeat([]byte{7}, 32)); err != nil {
+		t.Fatal(err)
+	}
+	const privilegeSSN = catalog.PrivilegeSelect << 8
+	schema, err := db.Catalog().CreateTable("people", []catalog.ColumnDef{
+		{Name: "id", Type: catalog.ColumnTypeInt, IsPrimaryKey: true},
+		{Name: "name", Type: catalog.ColumnTypeVarchar},
+		{Name: "ssn", Type: catalog.ColumnTypeBlob, Encryption: &catalog.ColumnEncryption{KeyID: 1, Decrypt: privilegeSSN}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	tx, err := db.Begin()
+	if err != nil {
+		t.Fatal(err)
+	}
+	people, err := tx.Table("people")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, row := range [][]any{{1, "alice", []byte("111")}, {2, "bob", []byte("222")}} {
+		tup, err := schema.BindRow(row...)
+		if err == nil {
+			tup, err = schema.EncryptRow(keyring, tup)
+		}
+		if err == nil {
+			err = people.Insert(db.BufferPool(), tup)
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		t.Fatal(err)
+	}
+	// Only bob is visible to the roles that may not see the SSNs
+	err = db.Catalog().SetSecurityPolicy("people", &catalog.SecurityPolicy{
+		RowFilter:       func(tup [][]byte) bool { return string(tup[1]) == "bob" },
+		RowFilterExempt: privilegeSSN,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, role := range []string{"admin", "clerk", "intern"} {
+		if err := db.Catalog().CreateRole(role, role == "admin"); err != nil {
+			t.Fatal(err)
+		}
+		if err := db.Catalog().SetPassword(role, role+"-secret"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := db.Catalog().Grant("clerk", "people", catalog.PrivilegeSelect); err != nil {
+		t.Fatal(err)
+	}
+
+	srv := New(db)
+	srv.SetKeyring(keyring)
+	defer srv.Close()
+	login := func(role string, password string) (*Client, error) {
+		serverConn, clientConn := net.Pipe()
+		go srv.ServeConn(serverConn)
+		c, err := Login(clientConn, role, password)
+		if err != nil {
+			clientConn.Close()
+		}
+		return c, err
+	}
+	if _, err := login("clerk", "guess"); errcode.Of(err) != errcode.InvalidAuthorization {
+		t.Errorf("expected a failed login for a wrong password, got %v", err)
+	}
+	if _, err := login("nobody", "nobody-secret"); errcode.Of(err) != errcode.InvalidAuthorization {
+		t.Errorf("expected a failed login for an unknown role, got %v", err)
+	}
+	selectPeople := func(c *Client) ([]string, error) {
+		rows, err := c.Query("SELECT name, ssn FROM people")
+		if err != nil {
+			return nil, err
+		}
+		var got []string
+		for {
+			row, ok, err := rows.Next()
+			if err != nil || !ok {
+				return got, err
+			}
+			ssn := string(row.At(1).Blob())
+			if ssn != "111" && ssn != "222" {
+				ssn = "encrypted"
+			}
+			got = append(got, row.At(0).Varchar()+":"+ssn)
+		}
+	}
+
+	for _, tt := range []struct {
+		role     string
+		expected []string
+	}{
+		{"admin", []string{"alice:111", "bob:222"}},
+		{"clerk", []string{"bob:encrypted"}},
+	} {
+		c, err := login(tt.role, tt.role+"-secret")
+		if err != nil {
+			t.Fatal(err)
+		}
+		got, err := selectPeople(c)
+		if err != nil {
+			t.Fatalf("%s: %v", tt.role, err)
+		}
+		if fmt.Sprint(got) != fmt.Sprint(tt.expected) {
+			t.Errorf("%s: expected %v, got %v", tt.role, tt.expected, got)
+		}
+		c.Close()
+	}
+
+	intern, err := login("intern", "intern-secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer intern.Close()
+	if _, err := selectPeople(intern); errcode.Of(err) != errcode.PermissionDenied {
+		t.Errorf("expected permission denied without SELECT, got %v", err)
+	}
+	// Grants take effect on the next statement of a session
+	if err := db.Catalog().Grant("intern", "people", catalog.PrivilegeSelect|privilegeSSN); err != nil {
+		t.Fatal(err)
+	}
+	if got, err := selectPeople(intern); err != nil || fmt.Sprint(got) != "[alice:111 bob:222]" {
+		t.Errorf("expected both rows decrypted after the grant, got %v, %v", got, err)
+	}
+}
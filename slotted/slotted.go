@@ -10,14 +10,14 @@ import (
 // PointerSize is the size of a pointer entry (2 bytes offset + 2 bytes length).
 const PointerSize = 4
 
-// HeaderSize is the size of the slotted page header (2 bytes NumSlots + 2 bytes FreeSpaceOffset + 4 bytes Pad).
+// HeaderSize is the size of the slotted page header (2 bytes NumSlots + 2 bytes FreeSpaceOffset + 4 bytes Aux).
 const HeaderSize = 8
 
 // Header contains metadata for a slotted page.
 type Header struct {
 	NumSlots        uint16 // Number of slots (tuples) in the page
 	FreeSpaceOffset uint16 // Offset to the start of free space
-	Pad             uint32 // Padding for alignment
+	Aux             uint32 // Free for the user of the page (0 on pages written before it was used)
 }
 
 // Pointer points to a tuple in the data area.
@@ -123,9 +123,20 @@ func (s *Slotted) Data(index int) []byte {
 	return s.body[start:end]
 }
 
+// Aux returns the auxiliary header word, which the slotted page itself does not use.
+func (s *Slotted) Aux() uint32 {
+	return s.header.Aux
+}
+
+// SetAux sets the auxiliary header word.
+func (s *Slotted) SetAux(v uint32) {
+	s.header.Aux = v
+}
+
 func (s *Slotted) Initialize() {
 	s.header.NumSlots = 0
 	s.header.FreeSpaceOffset = uint16(len(s.body))
+	s.header.Aux = 0
 	s.pointers = s.pointers[:0]
 	s.updatePointersInBody()
 }
@@ -0,0 +1,517 @@
+// Package sql compiles SQL statements into query plans.
+// Parse turns the text of a statement into a Select; a Planner binds it against the catalog,
+// picks the access path of the table and builds the tree of query.PlanNodes that runs it.
+//
+// The dialect is a small subset of SQL:
+//
+//	[EXPLAIN] SELECT [/*+ hint [...] */] * | column [, ...] FROM table
+//	    [WHERE column op literal [AND ...]]
+//	    [ORDER BY column [ASC | DESC] [, ...]]
+//	    [LIMIT count [OFFSET skip]]
+//
+// where op is one of =, <>, !=, <, <=, > and >=, and a literal is an integer, a decimal number,
+// a 'string' (in which a quote is written twice), TRUE, FALSE or NULL. Keywords are
+// case-insensitive; names are case-sensitive and may be double-quoted. The hints of the
+// comment after SELECT direct the planner (see Hints); other comments are ignored.
+package sql
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/Johniel/gorelly/errcode"
+	"github.com/Johniel/gorelly/query"
+	"github.com/Johniel/gorelly/types"
+)
+
+var (
+	// ErrSyntax is returned, wrapped with the position of the error, when a statement cannot be parsed.
+	ErrSyntax = errcode.New(errcode.SyntaxError, "syntax error")
+)
+
+// Select is a parsed SELECT statement.
+type Select struct {
+	Explain bool         // Whether the statement is prefixed with EXPLAIN
+	Hints   Hints        // Hints of the /*+ ... */ comment after SELECT
+	Columns []string     // Selected columns, or nil for *
+	Table   string       // Name of the table, qualified with its schema if given
+	Where   []Comparison // Conditions of the WHERE clause, all of which must hold
+	OrderBy []OrderItem  // Sort keys of the ORDER BY clause
+	Limit   *int         // Maximum number of rows of the LIMIT clause, or nil for none
+	Offset  int          // Number of rows skipped by the OFFSET clause
+}
+
+// Hints direct the planner instead of leaving it to choose. They are written in a comment after
+// SELECT, e.g. SELECT /*+ INDEX(users_city_idx) */ * FROM users, as a list of:
+//
+//	INDEX(name)    scan the table through the named index
+//	NO_INDEX       scan the table through its primary key
+//	NO_HASH_JOIN   do not join rows with a hash join
+//	PARALLEL(n)    scan the table with n workers
+//
+// Callers building a Select themselves may set them directly. The planner honors every hint
+// or fails with ErrInvalidHint; see Planner.Plan.
+type Hints struct {
+	Index      string // Name of the index to scan, or "" to let the planner choose
+	NoIndex    bool   // Whether the table must be scanned through its primary key
+	NoHashJoin bool   // Whether hash joins are ruled out
+	Parallel   int    // Number of workers scanning the table; 0 and 1 scan it serially
+}
+
+// Comparison is a condition comparing a column with a literal. Conditions written with the
+// literal first are flipped, so "3 < id" becomes "id > 3".
+type Comparison struct {
+	Column string
+	Op     query.CompareOp
+	Value  types.Value // Literal; integers are INT, decimal numbers FLOAT and strings VARCHAR
+}
+
+// OrderItem is a sort key of the ORDER BY clause.
+type OrderItem struct {
+	Column     string
+	Descending bool
+}
+
+// Parse parses a single statement, optionally terminated by a semicolon.
+func Parse(text string) (*Select, error) {
+	tokens, err := tokenize(text)
+	if err != nil {
+		return nil, err
+	}
+	p := &parser{tokens: tokens}
+	stmt, err := p.parseSelect()
+	if err != nil {
+		return nil, err
+	}
+	p.acceptSymbol(";")
+	if tok := p.peek(); tok.kind != tokenEOF {
+		return nil, p.unexpected(tok)
+	}
+	return stmt, nil
+}
+
+type tokenKind int
+
+const (
+	tokenEOF tokenKind = iota
+	tokenIdent
+	tokenQuotedIdent
+	tokenNumber
+	tokenString
+	tokenSymbol
+	tokenHint
+)
+
+type token struct {
+	kind tokenKind
+	text string // Text of the token; the unquoted value of strings and quoted identifiers, the body of hints
+	pos  int    // Byte offset of the token in the statement
+}
+
+// tokenize splits a statement into tokens, ending with a tokenEOF.
+func tokenize(text string) ([]token, error) {
+	var tokens []token
+	for i := 0; i < len(text); {
+		c := text[i]
+		switch {
+		case c == ' ' || c == '\t' || c == '\n' || c == '\r':
+			i++
+		case c == '-' && i+1 < len(text) && text[i+1] == '-':
+			// Comment to the end of the line
+			for i < len(text) && text[i] != '\n' {
+				i++
+			}
+		case c == '/' && i+1 < len(text) && text[i+1] == '*':
+			// Comment to the closing */, which holds hints if it opens with /*+
+			start := i
+			end := strings.Index(text[i+2:], "*/")
+			if end < 0 {
+				return nil, fmt.Errorf("%w at offset %d: unterminated comment", ErrSyntax, start)
+			}
+			i += 2 + end + 2
+			if body := text[start+2 : i-2]; strings.HasPrefix(body, "+") {
+				tokens = append(tokens, token{kind: tokenHint, text: body[1:], pos: start + 3})
+			}
+		case isIdentStart(c):
+			start := i
+			for i < len(text) && (isIdentStart(text[i]) || isDigit(text[i])) {
+				i++
+			}
+			tokens = append(tokens, token{kind: tokenIdent, text: text[start:i], pos: start})
+		case isDigit(c) || (c == '.' && i+1 < len(text) && isDigit(text[i+1])):
+			start := i
+			for i < len(text) && (isDigit(text[i]) || text[i] == '.' || text[i] == 'e' || text[i] == 'E' ||
+				((text[i] == '+' || text[i] == '-') && (text[i-1] == 'e' || text[i-1] == 'E'))) {
+				i++
+			}
+			tokens = append(tokens, token{kind: tokenNumber, text: text[start:i], pos: start})
+		case c == '\'' || c == '"':
+			start := i
+			s, n, ok := unquote(text[i:], c)
+			if !ok {
+				return nil, fmt.Errorf("%w at offset %d: unterminated quote", ErrSyntax, start)
+			}
+			kind := tokenString
+			if c == '"' {
+				kind = tokenQuotedIdent
+			}
+			tokens = append(tokens, token{kind: kind, text: s, pos: start})
+			i += n
+		default:
+			start := i
+			sym := string(c)
+			if i+1 < len(text) {
+				if two := text[i : i+2]; two == "<=" || two == ">=" || two == "<>" || two == "!=" {
+					sym = two
+				}
+			}
+			if !strings.Contains("*,.;=<>-()", sym) && len(sym) == 1 {
+				return nil, fmt.Errorf("%w at offset %d: unexpected character %q", ErrSyntax, start, sym)
+			}
+			tokens = append(tokens, token{kind: tokenSymbol, text: sym, pos: start})
+			i += len(sym)
+		}
+	}
+	return append(tokens, token{kind: tokenEOF, pos: len(text)}), nil
+}
+
+func isIdentStart(c byte) bool {
+	return c == '_' || ('a' <= c && c <= 'z') || ('A' <= c && c <= 'Z')
+}
+
+func isDigit(c byte) bool {
+	return '0' <= c && c <= '9'
+}
+
+// unquote reads the quoted text at the start of s, where a doubled quote stands for one, and
+// returns it with the number of bytes it spans.
+func unquote(s string, quote byte) (string, int, bool) {
+	var b strings.Builder
+	for i := 1; i < len(s); i++ {
+		if s[i] != quote {
+			b.WriteByte(s[i])
+			continue
+		}
+		if i+1 < len(s) && s[i+1] == quote {
+			b.WriteByte(quote)
+			i++
+			continue
+		}
+		return b.String(), i + 1, true
+	}
+	return "", 0, false
+}
+
+type parser struct {
+	tokens []token
+	pos    int
+}
+
+func (p *parser) peek() token {
+	return p.tokens[p.pos]
+}
+
+func (p *parser) next() token {
+	tok := p.tokens[p.pos]
+	if tok.kind != tokenEOF {
+		p.pos++
+	}
+	return tok
+}
+
+// acceptKeyword consumes the next token if it is the keyword.
+func (p *parser) acceptKeyword(keyword string) bool {
+	if tok := p.peek(); tok.kind == tokenIdent && strings.EqualFold(tok.text, keyword) {
+		p.pos++
+		return true
+	}
+	return false
+}
+
+func (p *parser) expectKeyword(keyword string) error {
+	if !p.acceptKeyword(keyword) {
+		return p.unexpected(p.peek())
+	}
+	return nil
+}
+
+// acceptSymbol consumes the next token if it is the symbol.
+func (p *parser) acceptSymbol(symbol string) bool {
+	if tok := p.peek(); tok.kind == tokenSymbol && tok.text == symbol {
+		p.pos++
+		return true
+	}
+	return false
+}
+
+func (p *parser) expectSymbol(symbol string) error {
+	if !p.acceptSymbol(symbol) {
+		return p.unexpected(p.peek())
+	}
+	return nil
+}
+
+func (p *parser) unexpected(tok token) error {
+	if tok.kind == tokenEOF {
+		return fmt.Errorf("%w at offset %d: unexpected end of statement", ErrSyntax, tok.pos)
+	}
+	return fmt.Errorf("%w at offset %d: unexpected %q", ErrSyntax, tok.pos, tok.text)
+}
+
+// keywords are the reserved words, which are not names unless quoted.
+var keywords = map[string]bool{
+	"EXPLAIN": true, "SELECT": true, "FROM": true, "WHERE": true, "AND": true, "ORDER": true,
+	"BY": true, "ASC": true, "DESC": true, "LIMIT": true, "OFFSET": true, "TRUE": true, "FALSE": true,
+	"NULL": true,
+}
+
+func (p *parser) parseName() (string, error) {
+	tok := p.peek()
+	if tok.kind == tokenQuotedIdent || (tok.kind == tokenIdent && !keywords[strings.ToUpper(tok.text)]) {
+		p.pos++
+		return tok.text, nil
+	}
+	return "", p.unexpected(tok)
+}
+
+func (p *parser) parseSelect() (*Select, error) {
+	stmt := &Select{Explain: p.acceptKeyword("EXPLAIN")}
+	if err := p.expectKeyword("SELECT"); err != nil {
+		return nil, err
+	}
+	if tok := p.peek(); tok.kind == tokenHint {
+		p.pos++
+		hints, err := parseHints(tok)
+		if err != nil {
+			return nil, err
+		}
+		stmt.Hints = hints
+	}
+	if !p.acceptSymbol("*") {
+		for {
+			name, err := p.parseName()
+			if err != nil {
+				return nil, err
+			}
+			stmt.Columns = append(stmt.Columns, name)
+			if !p.acceptSymbol(",") {
+				break
+			}
+		}
+	}
+
+	if err := p.expectKeyword("FROM"); err != nil {
+		return nil, err
+	}
+	name, err := p.parseName()
+	if err != nil {
+		return nil, err
+	}
+	if p.acceptSymbol(".") {
+		table, err := p.parseName()
+		if err != nil {
+			return nil, err
+		}
+		name += "." + table
+	}
+	stmt.Table = name
+
+	if p.acceptKeyword("WHERE") {
+		for {
+			cond, err := p.parseComparison()
+			if err != nil {
+				return nil, err
+			}
+			stmt.Where = append(stmt.Where, cond)
+			if !p.acceptKeyword("AND") {
+				break
+			}
+		}
+	}
+
+	if p.acceptKeyword("ORDER") {
+		if err := p.expectKeyword("BY"); err != nil {
+			return nil, err
+		}
+		for {
+			name, err := p.parseName()
+			if err != nil {
+				return nil, err
+			}
+			item := OrderItem{Column: name}
+			if p.acceptKeyword("DESC") {
+				item.Descending = true
+			} else {
+				p.acceptKeyword("ASC")
+			}
+			stmt.OrderBy = append(stmt.OrderBy, item)
+			if !p.acceptSymbol(",") {
+				break
+			}
+		}
+	}
+
+	if p.acceptKeyword("LIMIT") {
+		count, err := p.parseCount()
+		if err != nil {
+			return nil, err
+		}
+		stmt.Limit = &count
+		if p.acceptKeyword("OFFSET") {
+			if stmt.Offset, err = p.parseCount(); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return stmt, nil
+}
+
+// parseHints parses the body of a hint comment.
+func parseHints(tok token) (Hints, error) {
+	tokens, err := tokenize(tok.text)
+	if err != nil {
+		return Hints{}, err
+	}
+	for i := range tokens {
+		tokens[i].pos += tok.pos
+	}
+	p := &parser{tokens: tokens}
+	var hints Hints
+	for p.peek().kind != tokenEOF {
+		name := p.next()
+		if name.kind != tokenIdent {
+			return Hints{}, p.unexpected(name)
+		}
+		switch strings.ToUpper(name.text) {
+		case "INDEX":
+			if err := p.expectSymbol("("); err != nil {
+				return Hints{}, err
+			}
+			if hints.Index, err = p.parseName(); err != nil {
+				return Hints{}, err
+			}
+			if err := p.expectSymbol(")"); err != nil {
+				return Hints{}, err
+			}
+		case "NO_INDEX":
+			hints.NoIndex = true
+		case "NO_HASH_JOIN":
+			hints.NoHashJoin = true
+		case "PARALLEL":
+			if err := p.expectSymbol("("); err != nil {
+				return Hints{}, err
+			}
+			if hints.Parallel, err = p.parseCount(); err != nil {
+				return Hints{}, err
+			}
+			if hints.Parallel < 1 {
+				return Hints{}, fmt.Errorf("%w at offset %d: invalid degree %d", ErrSyntax, name.pos, hints.Parallel)
+			}
+			if err := p.expectSymbol(")"); err != nil {
+				return Hints{}, err
+			}
+		default:
+			return Hints{}, fmt.Errorf("%w at offset %d: unknown hint %q", ErrSyntax, name.pos, name.text)
+		}
+	}
+	return hints, nil
+}
+
+// parseCount parses the non-negative integer of a LIMIT or OFFSET clause.
+func (p *parser) parseCount() (int, error) {
+	tok := p.next()
+	if tok.kind != tokenNumber {
+		return 0, p.unexpected(tok)
+	}
+	n, err := strconv.ParseInt(tok.text, 10, 32)
+	if err != nil {
+		return 0, fmt.Errorf("%w at offset %d: invalid count %q", ErrSyntax, tok.pos, tok.text)
+	}
+	return int(n), nil
+}
+
+var compareOps = map[string]query.CompareOp{
+	"=": query.CompareEq, "<>": query.CompareNe, "!=": query.CompareNe,
+	"<": query.CompareLt, "<=": query.CompareLe, ">": query.CompareGt, ">=": query.CompareGe,
+}
+
+func (p *parser) parseComparison() (Comparison, error) {
+	literalFirst := p.peekLiteral()
+	var cond Comparison
+	var err error
+	if literalFirst {
+		cond.Value, err = p.parseLiteral()
+	} else {
+		cond.Column, err = p.parseName()
+	}
+	if err != nil {
+		return Comparison{}, err
+	}
+
+	tok := p.next()
+	op, ok := compareOps[tok.text]
+	if tok.kind != tokenSymbol || !ok {
+		return Comparison{}, p.unexpected(tok)
+	}
+	cond.Op = op
+
+	if literalFirst {
+		cond.Column, err = p.parseName()
+		cond.Op = op.Flip()
+	} else {
+		cond.Value, err = p.parseLiteral()
+	}
+	if err != nil {
+		return Comparison{}, err
+	}
+	return cond, nil
+}
+
+// peekLiteral reports whether the next token starts a literal.
+func (p *parser) peekLiteral() bool {
+	tok := p.peek()
+	switch tok.kind {
+	case tokenNumber, tokenString:
+		return true
+	case tokenSymbol:
+		return tok.text == "-"
+	case tokenIdent:
+		switch strings.ToUpper(tok.text) {
+		case "TRUE", "FALSE", "NULL":
+			return true
+		}
+	}
+	return false
+}
+
+func (p *parser) parseLiteral() (types.Value, error) {
+	negative := p.acceptSymbol("-")
+	tok := p.next()
+	switch {
+	case tok.kind == tokenNumber:
+		text := tok.text
+		if negative {
+			text = "-" + text
+		}
+		if n, err := strconv.ParseInt(text, 10, 64); err == nil {
+			return types.Int64(n), nil
+		}
+		if f, err := strconv.ParseFloat(text, 64); err == nil {
+			return types.Float64(f), nil
+		}
+		return types.Null, fmt.Errorf("%w at offset %d: invalid number %q", ErrSyntax, tok.pos, tok.text)
+	case negative:
+		return types.Null, p.unexpected(tok)
+	case tok.kind == tokenString:
+		return types.Varchar(tok.text), nil
+	case tok.kind == tokenIdent && strings.EqualFold(tok.text, "TRUE"):
+		return types.Bool(true), nil
+	case tok.kind == tokenIdent && strings.EqualFold(tok.text, "FALSE"):
+		return types.Bool(false), nil
+	case tok.kind == tokenIdent && strings.EqualFold(tok.text, "NULL"):
+		return types.Null, nil
+	default:
+		return types.Null, p.unexpected(tok)
+	}
+}
@@ -0,0 +1,83 @@
+package sql
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+
+	"github.com/Johniel/gorelly/query"
+	"github.com/Johniel/gorelly/types"
+)
+
+func TestParse(t *testing.T) {
+	stmt, err := Parse(`explain select id, "order" FROM app.users WHERE age >= 30 AND 'Paris' = city AND score < -1.5 AND name <> 'O''Brien' ORDER BY age DESC, id;`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := &Select{
+		Explain: true,
+		Columns: []string{"id", "order"},
+		Table:   "app.users",
+		Where: []Comparison{
+			{Column: "age", Op: query.CompareGe, Value: types.Int64(30)},
+			{Column: "city", Op: query.CompareEq, Value: types.Varchar("Paris")},
+			{Column: "score", Op: query.CompareLt, Value: types.Float64(-1.5)},
+			{Column: "name", Op: query.CompareNe, Value: types.Varchar("O'Brien")},
+		},
+		OrderBy: []OrderItem{{Column: "age", Descending: true}, {Column: "id"}},
+	}
+	if !reflect.DeepEqual(stmt, expected) {
+		t.Errorf("expected %+v, got %+v", expected, stmt)
+	}
+
+	stmt, err = Parse("SELECT * FROM t WHERE 3 < id AND flag = TRUE AND note = NULL -- comment")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stmt.Columns != nil || stmt.Where[0].Op != query.CompareGt || !stmt.Where[1].Value.Bool() || !stmt.Where[2].Value.IsNull() {
+		t.Errorf("unexpected statement %+v", stmt)
+	}
+
+	stmt, err = Parse(`SELECT /*+ INDEX("t_a_idx") no_hash_join PARALLEL(4) */ /* not a hint */ * FROM t`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if hints := (Hints{Index: "t_a_idx", NoHashJoin: true, Parallel: 4}); stmt.Hints != hints {
+		t.Errorf("expected hints %+v, got %+v", hints, stmt.Hints)
+	}
+
+	stmt, err = Parse("SELECT * FROM t ORDER BY id LIMIT 10 OFFSET 20")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stmt.Limit == nil || *stmt.Limit != 10 || stmt.Offset != 20 {
+		t.Errorf("unexpected statement %+v", stmt)
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	for _, text := range []string{
+		"",
+		"SELECT FROM t",
+		"SELECT * FROM select",
+		"SELECT * FROM t WHERE a",
+		"SELECT * FROM t WHERE a = b",
+		"SELECT * FROM t WHERE a = 'open",
+		"SELECT * FROM t ORDER id",
+		"SELECT * FROM t LIMIT x",
+		"SELECT * FROM t LIMIT -1",
+		"SELECT * FROM t LIMIT 1.5",
+		"SELECT * FROM t LIMIT 1 OFFSET",
+		"SELECT * FROM t OFFSET 1",
+		"SELECT * FROM t WHERE a ~ 1",
+		"SELECT /*+ FULL */ * FROM t",
+		"SELECT /*+ PARALLEL(0) */ * FROM t",
+		"SELECT /*+ INDEX(a */ * FROM t",
+		"SELECT * /*+ NO_INDEX */ FROM t",
+		"SELECT * FROM t /* open",
+	} {
+		if _, err := Parse(text); !errors.Is(err, ErrSyntax) {
+			t.Errorf("%q: expected ErrSyntax, got %v", text, err)
+		}
+	}
+}
@@ -0,0 +1,663 @@
+package sql
+
+import (
+	"bytes"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/Johniel/gorelly/catalog"
+	"github.com/Johniel/gorelly/clock"
+	"github.com/Johniel/gorelly/disk"
+	"github.com/Johniel/gorelly/errcode"
+	"github.com/Johniel/gorelly/query"
+	"github.com/Johniel/gorelly/table"
+	"github.com/Johniel/gorelly/transaction"
+	"github.com/Johniel/gorelly/types"
+)
+
+var (
+	// ErrUnsupported is returned when a statement uses a column the planner cannot compare, e.g.
+	// an encrypted one.
+	ErrUnsupported = errcode.New(errcode.FeatureNotSupported, "not supported by the planner")
+	// ErrInvalidHint is returned when the hints of a statement cannot be honored, e.g. an INDEX
+	// hint naming an index the table does not have.
+	ErrInvalidHint = errcode.New(errcode.InvalidParameter, "invalid planner hint")
+)
+
+// Planner compiles statements into plans over the tables of a catalog.
+//
+// The plans check the privileges of the role they run as (see Plan.RunAs), apply the security
+// policy of the table and decrypt its encrypted columns (see SetKeyring). The rows of versioned
+// tables are filtered by transaction visibility (see Plan.ReadAt).
+type Planner struct {
+	catalog *catalog.CatalogManager
+	clock   clock.Clock            // Clock of the expressions of the plans, e.g. now()
+	keyring *catalog.ColumnKeyring // Keys of the encrypted columns, if any
+}
+
+func NewPlanner(cm *catalog.CatalogManager) *Planner {
+	return &Planner{catalog: cm, clock: clock.System}
+}
+
+// SetClock sets the clock the expressions of the plans compiled afterwards read the current
+// time from, e.g. a clock.Logical shared with the transaction manager in tests.
+func (pl *Planner) SetClock(c clock.Clock) {
+	pl.clock = c
+}
+
+// SetKeyring sets the keys the plans compiled afterwards decrypt the encrypted columns of the
+// tables with (see catalog.ColumnEncryption). Without a keyring, they return the encrypted values.
+func (pl *Planner) SetKeyring(kr *catalog.ColumnKeyring) {
+	pl.keyring = kr
+}
+
+// Plan is a compiled statement.
+type Plan struct {
+	Root    query.PlanNode // Root of the plan; its tuples are the result rows
+	Columns *types.Schema  // Names and kinds of the columns of the result rows
+	Explain bool           // Whether the statement asked for the plan rather than its rows
+	// Shape of the statement as recorded in a catalog.Workload (see Record)
+	Query     catalog.WorkloadQuery
+	root      *step
+	scan      query.PlanNode          // Scan of the table at the bottom of Root
+	stats     *table.AccessStats      // Rows read by the scan
+	visible   *query.FilterVisible    // Visibility filter above the scan of a versioned table
+	decrypt   *query.DecryptColumns   // Decryption of the encrypted columns, if any
+	policy    *query.ApplyPolicy      // Security policy of the table, if it has one
+	authorize *query.Authorize        // Privilege check at the top of Root
+	catalog   *catalog.CatalogManager // Catalog the privileges of the role are looked up in
+}
+
+// Record adds an execution of the plan that returned rows rows to a workload, with the rows
+// its scan read, as the input of catalog.CatalogManager.AdviseIndexes.
+func (p *Plan) Record(w *catalog.Workload, rows uint64) {
+	q := p.Query
+	q.Count = 1
+	q.RowsScanned = p.stats.RowsRead.Load()
+	q.RowsReturned = rows
+	w.Record(q)
+}
+
+// LockKeys makes the scan of the plan lock the keys it reads through the hook locker returns
+// for the tree of the table, e.g. transaction.LockManager.ScanLocker for the transaction the
+// plan runs in. A SeqScan takes next-key locks on the primary keys it reads, which also keep
+// other transactions from inserting into the scanned range; an IndexScan locks the primary
+// keys of the rows it looks up.
+func (p *Plan) LockKeys(locker func(tableMetaPageID disk.PageID) func(keyBytes []byte) error) {
+	switch scan := p.scan.(type) {
+	case *query.SeqScan:
+		scan.LockKey = locker(scan.TableMetaPageID)
+	case *query.IndexScan:
+		scan.LockKey = locker(scan.TableMetaPageID)
+	}
+}
+
+// ReadAt makes the plan of a statement on a versioned table read the rows at the snapshot
+// snapshot returns when the plan starts, e.g. gorelly.Tx.Snapshot for the transaction the plan
+// runs in. Such a plan fails to start with query.ErrNoSnapshot until it is given one. It does
+// nothing for the plans of other tables.
+func (p *Plan) ReadAt(snapshot func() *transaction.Snapshot) {
+	if p.visible != nil {
+		p.visible.Snapshot = snapshot
+	}
+}
+
+// RunAs makes the plan run with the privileges of a role on the table: it fails to start with
+// catalog.ErrPermissionDenied unless the role holds SELECT, and the security policy and the
+// decryption of the columns apply to the privileges it holds. They are looked up when the plan
+// starts, so grants and revokes take effect on the next statement. A plan that does not run as
+// a role reads with every privilege, as the application embedding the database does.
+func (p *Plan) RunAs(role string) {
+	tableName := p.Query.Table
+	p.authorize.Check = func() error {
+		privs, err := p.catalog.TablePrivileges(role, tableName)
+		if err != nil {
+			return err
+		}
+		if !privs.Has(catalog.PrivilegeSelect) {
+			return catalog.ErrPermissionDenied
+		}
+		if p.decrypt != nil {
+			p.decrypt.Privileges = privs
+		}
+		if p.policy != nil {
+			p.policy.Privileges = privs
+		}
+		return nil
+	}
+}
+
+// step is a node of the plan as EXPLAIN shows it.
+type step struct {
+	name   string
+	detail string
+	input  *step
+}
+
+// String returns the plan as EXPLAIN shows it: one node per line, each above its input, e.g.
+//
+//	Project: name
+//	  -> Filter: age >= 30
+//	    -> IndexScan on users using users_city_idx: city = 'Paris'
+func (p *Plan) String() string {
+	var b strings.Builder
+	for depth, s := 0, p.root; s != nil; depth, s = depth+1, s.input {
+		if 0 < depth {
+			b.WriteString(strings.Repeat("  ", depth-1) + "  -> ")
+		}
+		b.WriteString(s.name)
+		if s.detail != "" {
+			b.WriteString(": " + s.detail)
+		}
+		b.WriteString("\n")
+	}
+	return b.String()
+}
+
+// PlanQuery parses a statement and plans it.
+func (pl *Planner) PlanQuery(text string) (*Plan, error) {
+	stmt, err := Parse(text)
+	if err != nil {
+		return nil, err
+	}
+	return pl.Plan(stmt)
+}
+
+// Plan binds the names of a statement against the catalog and builds its plan:
+//
+//   - The table is scanned through the key that the most equality conditions of the WHERE
+//     clause fix a prefix of, the primary key before an index and a unique index before
+//     another, continuing on a range condition of the next key column. An IndexScan looks the
+//     rows up in the table, so the primary key is also preferred to an index that only gets a
+//     range. Without such conditions, the whole table is scanned by a SeqScan.
+//   - The rows of a versioned table are replaced by the versions a snapshot sees by a
+//     FilterVisible right above the scan (see ReadAt). The scan finds the rows by the keys of
+//     their latest versions.
+//   - The encrypted columns are decrypted by a DecryptColumns, given a keyring, and the security
+//     policy of the table, if any, is applied by an ApplyPolicy above it, so that conditions
+//     only see the values the role may see (see RunAs).
+//   - The conditions that the scan does not answer exactly are checked by a Filter above it.
+//   - ORDER BY adds a Sort, which returns the rows as they come if the scan already produces
+//     them in order. With a LIMIT, a TopN keeps only the first rows instead of sorting all.
+//   - LIMIT adds a Limit, which stops the scan once it has returned its rows.
+//   - A Project on top returns the selected columns. The plan checks the privileges of the
+//     role it runs as when it starts, which EXPLAIN does not show.
+//
+// Hints override the choice of the access path: INDEX scans the named index and NO_INDEX the
+// primary key, whatever conditions they answer. PARALLEL scans the primary key with a
+// ParallelSeqScan and so cannot be combined with INDEX. NO_HASH_JOIN holds trivially, as a
+// plan reads a single table and never joins.
+//
+// Literals are converted to the type of the column they are compared with: integers to FLOAT,
+// and strings to BLOB, TIMESTAMP (RFC 3339) and INTERVAL (as accepted by time.ParseDuration).
+// Comparisons with NULL never hold.
+func (pl *Planner) Plan(stmt *Select) (*Plan, error) {
+	schema, err := pl.catalog.GetTableSchema(stmt.Table)
+	if err != nil {
+		return nil, err
+	}
+	_, tableName := catalog.SplitTableName(schema.TableName)
+
+	conds := make([]query.Expr, len(stmt.Where))
+	for i, cond := range stmt.Where {
+		col, err := comparableColumn(schema, cond.Column)
+		if err != nil {
+			return nil, err
+		}
+		v, err := bindLiteral(schema.Columns[col], cond.Value)
+		if err != nil {
+			return nil, fmt.Errorf("failed to bind %s: %w", cond.Column, err)
+		}
+		conds[i] = &query.CompareExpr{
+			Op:    cond.Op,
+			Left:  &query.ColumnRef{Index: col, Name: cond.Column},
+			Right: &query.Literal{Value: v},
+		}
+	}
+
+	path, err := hintedAccessPath(schema, conds, stmt.Hints)
+	if err != nil {
+		return nil, err
+	}
+	plan := &Plan{Explain: stmt.Explain}
+	plan.Root, plan.root = path.scan(schema, tableName)
+	plan.scan = plan.Root
+	if scan, ok := plan.scan.(*query.SeqScan); ok && 1 < stmt.Hints.Parallel {
+		plan.Root = &query.ParallelSeqScan{Scan: scan, Degree: stmt.Hints.Parallel}
+		plan.root.name = fmt.Sprintf("ParallelSeqScan on %s with %d workers", tableName, stmt.Hints.Parallel)
+	}
+	if schema.Versioned {
+		plan.visible = &query.FilterVisible{
+			InnerPlan:  plan.Root,
+			XminColumn: schema.XminColumn(),
+			XmaxColumn: schema.XmaxColumn(),
+			Versions:   schema.VersionStore(),
+		}
+		plan.Root = plan.visible
+		plan.root = &step{name: "FilterVisible", input: plan.root}
+	}
+	if encrypted := encryptedColumns(schema); pl.keyring != nil && 0 < len(encrypted) {
+		plan.decrypt = &query.DecryptColumns{
+			InnerPlan:  plan.Root,
+			Schema:     schema,
+			Keyring:    pl.keyring,
+			Privileges: catalog.AllPrivileges,
+		}
+		plan.Root = plan.decrypt
+		plan.root = &step{name: "DecryptColumns", detail: strings.Join(encrypted, ", "), input: plan.root}
+	}
+	if policy := pl.catalog.SecurityPolicy(schema.TableName); policy != nil {
+		plan.policy = &query.ApplyPolicy{
+			InnerPlan:  plan.Root,
+			Policy:     policy,
+			Privileges: catalog.AllPrivileges,
+		}
+		plan.Root = plan.policy
+		plan.root = &step{name: "ApplyPolicy", input: plan.root}
+	}
+	plan.stats = &table.AccessStats{}
+	switch scan := plan.scan.(type) {
+	case *query.SeqScan:
+		scan.Stats = plan.stats
+	case *query.IndexScan:
+		scan.Stats = plan.stats
+	}
+	plan.Query.Table = schema.TableName
+	for _, cond := range stmt.Where {
+		switch cond.Op {
+		case query.CompareEq:
+			if !slices.Contains(plan.Query.EqualityColumns, cond.Column) {
+				plan.Query.EqualityColumns = append(plan.Query.EqualityColumns, cond.Column)
+			}
+		case query.CompareLt, query.CompareLe, query.CompareGt, query.CompareGe:
+			if !slices.Contains(plan.Query.RangeColumns, cond.Column) {
+				plan.Query.RangeColumns = append(plan.Query.RangeColumns, cond.Column)
+			}
+		}
+	}
+
+	if residual := path.residual(conds); 0 < len(residual) {
+		predicate := query.Conjunction(residual...)
+		plan.Root = &query.Filter{
+			InnerPlan: plan.Root,
+			Predicate: predicate,
+			Schema:    schema.RowSchema(),
+			Context:   &query.EvalContext{Clock: pl.clock},
+		}
+		plan.root = &step{name: "Filter", detail: predicate.String(), input: plan.root}
+	}
+
+	if 0 < len(stmt.OrderBy) {
+		sort := &query.Sort{InnerPlan: plan.Root}
+		var keys []string
+		for _, item := range stmt.OrderBy {
+			col, err := comparableColumn(schema, item.Column)
+			if err != nil {
+				return nil, err
+			}
+			sort.SortKeys = append(sort.SortKeys, query.SortKey{ColumnIndex: col, Ascending: !item.Descending})
+			key := item.Column
+			if item.Descending {
+				key += " DESC"
+			}
+			keys = append(keys, key)
+		}
+		detail := strings.Join(keys, ", ")
+		switch {
+		case sort.Presorted():
+			plan.Root = sort
+			plan.root = &step{name: "Sort", detail: detail + " (presorted)", input: plan.root}
+		case stmt.Limit != nil:
+			n := stmt.Offset + *stmt.Limit
+			plan.Root = &query.TopN{InnerPlan: sort.InnerPlan, SortKeys: sort.SortKeys, N: n}
+			plan.root = &step{name: "TopN", detail: fmt.Sprintf("%d by %s", n, detail), input: plan.root}
+		default:
+			plan.Root = sort
+			plan.root = &step{name: "Sort", detail: detail, input: plan.root}
+		}
+	}
+
+	// A TopN already returns no more rows than the limit, which only has to skip the offset
+	if _, topN := plan.Root.(*query.TopN); stmt.Limit != nil && (!topN || 0 < stmt.Offset) {
+		plan.Root = &query.Limit{InnerPlan: plan.Root, Count: *stmt.Limit, Offset: stmt.Offset}
+		detail := fmt.Sprint(*stmt.Limit)
+		if 0 < stmt.Offset {
+			detail += fmt.Sprintf(" OFFSET %d", stmt.Offset)
+		}
+		plan.root = &step{name: "Limit", detail: detail, input: plan.root}
+	}
+
+	project := &query.Project{InnerPlan: plan.Root}
+	var columns []types.Column
+	names := stmt.Columns
+	if names == nil {
+		for _, col := range schema.Columns {
+			if !col.Dropped {
+				names = append(names, col.Name)
+			}
+		}
+	}
+	for _, name := range names {
+		col := columnIndex(schema, name)
+		if col < 0 {
+			return nil, fmt.Errorf("%w: %s", catalog.ErrColumnNotFound, name)
+		}
+		project.ColumnIndices = append(project.ColumnIndices, col)
+		columns = append(columns, types.Column{Name: name, Kind: schema.Columns[col].Type.Kind()})
+	}
+	plan.authorize = &query.Authorize{InnerPlan: project, Check: func() error { return nil }}
+	plan.catalog = pl.catalog
+	plan.Root = plan.authorize
+	plan.root = &step{name: "Project", detail: strings.Join(names, ", "), input: plan.root}
+	plan.Query.Projected = names
+	plan.Columns = types.NewSchema(columns...)
+	return plan, nil
+}
+
+// columnIndex returns the position of a column of the table that is not dropped, or -1.
+func columnIndex(schema *catalog.TableSchema, name string) int {
+	for i, col := range schema.Columns {
+		if col.Name == name && !col.Dropped {
+			return i
+		}
+	}
+	return -1
+}
+
+// encryptedColumns returns the names of the encrypted columns of the table that are not dropped.
+func encryptedColumns(schema *catalog.TableSchema) []string {
+	var names []string
+	for _, col := range schema.Columns {
+		if col.Encryption != nil && !col.Dropped {
+			names = append(names, col.Name)
+		}
+	}
+	return names
+}
+
+// comparableColumn returns the position of a column that conditions and sort keys may use.
+func comparableColumn(schema *catalog.TableSchema, name string) (int, error) {
+	col := columnIndex(schema, name)
+	if col < 0 {
+		return 0, fmt.Errorf("%w: %s", catalog.ErrColumnNotFound, name)
+	}
+	if schema.Columns[col].Encryption != nil {
+		return 0, fmt.Errorf("%w: encrypted column %s", ErrUnsupported, name)
+	}
+	return col, nil
+}
+
+// bindLiteral converts a literal to a value of the type of the column.
+func bindLiteral(col catalog.ColumnDef, v types.Value) (types.Value, error) {
+	if v.IsNull() {
+		return v, nil
+	}
+	switch {
+	case col.Type == catalog.ColumnTypeFloat && v.Kind() == types.KindInt64:
+		return types.Float64(float64(v.Int64())), nil
+	case col.Type == catalog.ColumnTypeBlob && v.Kind() == types.KindVarchar:
+		return types.Blob([]byte(v.Varchar())), nil
+	case col.Type == catalog.ColumnTypeTimestamp && v.Kind() == types.KindVarchar:
+		t, err := time.Parse(time.RFC3339Nano, v.Varchar())
+		if err != nil {
+			return types.Null, catalog.ErrTypeMismatch
+		}
+		return types.Timestamp(t), nil
+	case col.Type == catalog.ColumnTypeInterval && v.Kind() == types.KindVarchar:
+		d, err := time.ParseDuration(v.Varchar())
+		if err != nil {
+			return types.Null, catalog.ErrTypeMismatch
+		}
+		return types.Interval(d), nil
+	case v.Kind() != col.Type.Kind():
+		return types.Null, catalog.ErrTypeMismatch
+	}
+	return v, nil
+}
+
+// keyCondition is a condition of the WHERE clause comparing a column with a constant, which
+// the scan of a key may answer.
+type keyCondition struct {
+	expr   query.Expr
+	column int
+	op     query.CompareOp
+	value  types.Value
+}
+
+// keyConditions returns the conditions comparing a column with a constant.
+func keyConditions(conds []query.Expr) []keyCondition {
+	var keyConds []keyCondition
+	for _, cond := range conds {
+		if col, op, v, ok := query.ColumnComparison(cond); ok {
+			keyConds = append(keyConds, keyCondition{expr: cond, column: col, op: op, value: v})
+		}
+	}
+	return keyConds
+}
+
+func formatConditions(conds []keyCondition) string {
+	parts := make([]string, len(conds))
+	for i, cond := range conds {
+		parts[i] = cond.expr.String()
+	}
+	return strings.Join(parts, " AND ")
+}
+
+// accessPath is the way the rows of a table are read: a scan of the primary key or of an
+// index, from the first key with the values of eq (followed by the lower bound lo, if any)
+// while the keys have them (and are below the upper bound hi, if any).
+type accessPath struct {
+	index   *catalog.IndexDef // Nil for the primary key
+	columns []int             // Table columns of the scanned key
+	eq      []keyCondition    // Equality conditions on the leading columns of the key
+	lo, hi  *keyCondition     // Range conditions on the column of the key after them
+}
+
+// score ranks access paths: two for every equality condition, one for a range.
+func (ap *accessPath) score() int {
+	score := 2 * len(ap.eq)
+	if ap.lo != nil || ap.hi != nil {
+		score++
+	}
+	return score
+}
+
+// hintedAccessPath returns the access path the hints force, or else the one chooseAccessPath picks.
+func hintedAccessPath(schema *catalog.TableSchema, conds []query.Expr, hints Hints) (*accessPath, error) {
+	if hints.Parallel < 0 {
+		return nil, fmt.Errorf("%w: PARALLEL(%d)", ErrInvalidHint, hints.Parallel)
+	}
+	switch {
+	case hints.Index != "" && (hints.NoIndex || 1 < hints.Parallel):
+		return nil, fmt.Errorf("%w: INDEX cannot be combined with NO_INDEX or PARALLEL", ErrInvalidHint)
+	case hints.Index != "":
+		for i := range schema.Indexes {
+			index := &schema.Indexes[i]
+			if index.IndexName == hints.Index && index.MetaPageID.Valid() {
+				return matchKey(index, index.ColumnIndices, keyConditions(conds)), nil
+			}
+		}
+		return nil, fmt.Errorf("%w: no index %s on %s", ErrInvalidHint, hints.Index, schema.TableName)
+	case hints.NoIndex || 1 < hints.Parallel:
+		return matchKey(nil, primaryKeyColumns(schema), keyConditions(conds)), nil
+	}
+	return chooseAccessPath(schema, conds), nil
+}
+
+// primaryKeyColumns returns the table columns of the primary key.
+func primaryKeyColumns(schema *catalog.TableSchema) []int {
+	pkey := make([]int, schema.NumKeyElems)
+	for i := range pkey {
+		pkey[i] = i
+	}
+	return pkey
+}
+
+// chooseAccessPath returns the access path with the best score; on ties the primary key wins,
+// then unique indexes, then the index created first.
+func chooseAccessPath(schema *catalog.TableSchema, conds []query.Expr) *accessPath {
+	keyConds := keyConditions(conds)
+	best := matchKey(nil, primaryKeyColumns(schema), keyConds)
+	for _, unique := range []bool{true, false} {
+		for i := range schema.Indexes {
+			index := &schema.Indexes[i]
+			if index.IsUnique != unique || !index.MetaPageID.Valid() {
+				continue
+			}
+			path := matchKey(index, index.ColumnIndices, keyConds)
+			// An index scan looks up every row it finds, so a range alone does not beat the table
+			if path.score() > best.score() && 0 < len(path.eq) {
+				best = path
+			}
+		}
+	}
+	return best
+}
+
+// matchKey returns the access path of a key given the conditions of the statement.
+func matchKey(index *catalog.IndexDef, columns []int, conds []keyCondition) *accessPath {
+	ap := &accessPath{index: index, columns: columns}
+	for _, col := range columns {
+		var eq *keyCondition
+		for i := range conds {
+			if conds[i].column == col && conds[i].op == query.CompareEq && !conds[i].value.IsNull() {
+				eq = &conds[i]
+				break
+			}
+		}
+		if eq == nil {
+			break
+		}
+		ap.eq = append(ap.eq, *eq)
+	}
+	if len(ap.eq) == len(columns) {
+		return ap
+	}
+	next := columns[len(ap.eq)]
+	for i := range conds {
+		cond := &conds[i]
+		if cond.column != next || cond.value.IsNull() {
+			continue
+		}
+		switch cond.op {
+		case query.CompareGt, query.CompareGe:
+			if ap.lo == nil {
+				ap.lo = cond
+			}
+		case query.CompareLt, query.CompareLe:
+			if ap.hi == nil {
+				ap.hi = cond
+			}
+		}
+	}
+	return ap
+}
+
+// residual returns the conditions the scan of the access path does not answer exactly: all but
+// its equality conditions. Range conditions only bound the scan, which also reads the rows
+// equal to an exclusive lower bound and the NULLs below an upper one.
+func (ap *accessPath) residual(conds []query.Expr) []query.Expr {
+	var residual []query.Expr
+	for _, cond := range conds {
+		answered := false
+		for _, eq := range ap.eq {
+			if eq.expr == cond {
+				answered = true
+				break
+			}
+		}
+		if !answered {
+			residual = append(residual, cond)
+		}
+	}
+	return residual
+}
+
+// searchMode returns where the scan starts.
+func (ap *accessPath) searchMode() query.TupleSearchMode {
+	var key [][]byte
+	for _, eq := range ap.eq {
+		key = append(key, eq.value.Encode())
+	}
+	if ap.lo != nil {
+		key = append(key, ap.lo.value.Encode())
+	}
+	if key == nil {
+		return query.NewTupleSearchModeStart()
+	}
+	return query.NewTupleSearchModeKey(key)
+}
+
+// whileCond returns the condition the keys of the scanned rows meet.
+func (ap *accessPath) whileCond() func(query.TupleSlice) bool {
+	eq := make([][]byte, len(ap.eq))
+	for i, cond := range ap.eq {
+		eq[i] = cond.value.Encode()
+	}
+	var hi []byte
+	var inclusive bool
+	if ap.hi != nil {
+		hi, inclusive = ap.hi.value.Encode(), ap.hi.op == query.CompareLe
+	}
+	return func(key query.TupleSlice) bool {
+		if len(key) < len(eq) {
+			return false
+		}
+		for i, v := range eq {
+			if !bytes.Equal(key[i], v) {
+				return false
+			}
+		}
+		if hi != nil && len(eq) < len(key) {
+			c := bytes.Compare(key[len(eq)], hi)
+			return c < 0 || (inclusive && c == 0)
+		}
+		return true
+	}
+}
+
+// scan returns the scan of the access path with its EXPLAIN step.
+func (ap *accessPath) scan(schema *catalog.TableSchema, tableName string) (query.PlanNode, *step) {
+	var bounds []keyCondition
+	bounds = append(bounds, ap.eq...)
+	if ap.lo != nil {
+		bounds = append(bounds, *ap.lo)
+	}
+	if ap.hi != nil {
+		bounds = append(bounds, *ap.hi)
+	}
+	detail := formatConditions(bounds)
+
+	if ap.index == nil {
+		s := &step{name: "SeqScan on " + tableName, detail: detail}
+		return &query.SeqScan{
+			TableMetaPageID: schema.MetaPageID,
+			SearchMode:      ap.searchMode(),
+			WhileCond:       ap.whileCond(),
+			NumKeyElems:     schema.NumKeyElems,
+			Format:          schema.Format,
+			Defaults:        schema.Defaults(),
+			DroppedColumns:  schema.DroppedColumns(),
+		}, s
+	}
+
+	s := &step{name: "IndexScan on " + tableName + " using " + ap.index.IndexName, detail: detail}
+	keyColumns := append([]int(nil), ap.index.ColumnIndices...)
+	if !ap.index.IsUnique {
+		for i := 0; i < schema.NumKeyElems; i++ {
+			keyColumns = append(keyColumns, i)
+		}
+	}
+	return &query.IndexScan{
+		TableMetaPageID: schema.MetaPageID,
+		IndexMetaPageID: ap.index.MetaPageID,
+		SearchMode:      ap.searchMode(),
+		WhileCond:       ap.whileCond(),
+		KeyColumns:      keyColumns,
+		Format:          schema.Format,
+		Defaults:        schema.Defaults(),
+		DroppedColumns:  schema.DroppedColumns(),
+	}, s
+}
@@ -0,0 +1,520 @@
+package sql
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"reflect"
+	"testing"
+
+	"github.com/Johniel/gorelly/buffer"
+	"github.com/Johniel/gorelly/catalog"
+	"github.com/Johniel/gorelly/disk"
+	"github.com/Johniel/gorelly/query"
+	"github.com/Johniel/gorelly/transaction"
+)
+
+// newTestPlanner returns a planner over a table "users" (id, name, age, city) with a unique
+// index on name and an index on city.
+func newTestPlanner(t *testing.T) (*Planner, *buffer.BufferPoolManager) {
+	tmpfile, err := os.CreateTemp("", "test_planner_*.db")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.Remove(tmpfile.Name()) })
+	dm, err := disk.NewDiskManager(tmpfile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { dm.Close() })
+	bufmgr := buffer.NewBufferPoolManager(dm, buffer.NewBufferPool(32))
+
+	cm, err := catalog.NewCatalogManager(bufmgr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	schema, err := cm.CreateTable("users", []catalog.ColumnDef{
+		{Name: "id", Type: catalog.ColumnTypeInt, IsPrimaryKey: true},
+		{Name: "name", Type: catalog.ColumnTypeVarchar},
+		{Name: "age", Type: catalog.ColumnTypeInt},
+		{Name: "city", Type: catalog.ColumnTypeVarchar},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	users, err := cm.OpenTable("users")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, row := range [][]any{
+		{1, "alice", 30, "Paris"},
+		{2, "bob", 9, "Tokyo"},
+		{3, "carol", 100, "Paris"},
+		{4, "dave", 25, "Oslo"},
+		{5, "eve", 41, "Paris"},
+	} {
+		tup, err := schema.BindRow(row...)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := users.Insert(bufmgr, tup); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := cm.CreateIndex("users", []string{"name"}, true); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := cm.CreateIndex("users", []string{"city"}, false); err != nil {
+		t.Fatal(err)
+	}
+	return NewPlanner(cm), bufmgr
+}
+
+func TestPlanner(t *testing.T) {
+	planner, bufmgr := newTestPlanner(t)
+
+	tests := []struct {
+		text     string
+		explain  string
+		expected [][]string
+	}{
+		{
+			text: "SELECT name, age FROM users WHERE age >= 30 ORDER BY age",
+			explain: "Project: name, age\n" +
+				"  -> Sort: age\n" +
+				"    -> Filter: age >= 30\n" +
+				"      -> SeqScan on users\n",
+			// The INT 100 sorts after 30, which the string "100" does not
+			expected: [][]string{{"alice", "30"}, {"eve", "41"}, {"carol", "100"}},
+		},
+		{
+			text: "SELECT id, name FROM users WHERE city = 'Paris' AND age < 50",
+			explain: "Project: id, name\n" +
+				"  -> Filter: age < 50\n" +
+				"    -> IndexScan on users using users_city_idx: city = 'Paris'\n",
+			expected: [][]string{{"1", "alice"}, {"5", "eve"}},
+		},
+		{
+			text: "SELECT * FROM users WHERE name = 'dave' AND city = 'Oslo'",
+			explain: "Project: id, name, age, city\n" +
+				"  -> Filter: city = 'Oslo'\n" +
+				"    -> IndexScan on users using users_name_key: name = 'dave'\n",
+			expected: [][]string{{"4", "dave", "25", "Oslo"}},
+		},
+		{
+			text: "SELECT id FROM users WHERE id > 2 AND id <= 4 ORDER BY id",
+			explain: "Project: id\n" +
+				"  -> Sort: id (presorted)\n" +
+				"    -> Filter: id > 2 AND id <= 4\n" +
+				"      -> SeqScan on users: id > 2 AND id <= 4\n",
+			expected: [][]string{{"3"}, {"4"}},
+		},
+		{
+			text: "SELECT id FROM users WHERE id = 2 AND name = 'bob'",
+			explain: "Project: id\n" +
+				"  -> Filter: name = 'bob'\n" +
+				"    -> SeqScan on users: id = 2\n",
+			expected: [][]string{{"2"}},
+		},
+		{
+			// A range alone does not make an index scan worth its lookups
+			text: "SELECT id FROM users WHERE name >= 'd'",
+			explain: "Project: id\n" +
+				"  -> Filter: name >= 'd'\n" +
+				"    -> SeqScan on users\n",
+			expected: [][]string{{"4"}, {"5"}},
+		},
+		{
+			text: "SELECT id FROM users WHERE city = NULL",
+			explain: "Project: id\n" +
+				"  -> Filter: city = NULL\n" +
+				"    -> SeqScan on users\n",
+			expected: nil,
+		},
+		{
+			text: "SELECT name FROM users ORDER BY age DESC LIMIT 2",
+			explain: "Project: name\n" +
+				"  -> TopN: 2 by age DESC\n" +
+				"    -> SeqScan on users\n",
+			expected: [][]string{{"carol"}, {"eve"}},
+		},
+		{
+			text: "SELECT name FROM users WHERE city = 'Paris' ORDER BY age LIMIT 1 OFFSET 1",
+			explain: "Project: name\n" +
+				"  -> Limit: 1 OFFSET 1\n" +
+				"    -> TopN: 2 by age\n" +
+				"      -> IndexScan on users using users_city_idx: city = 'Paris'\n",
+			expected: [][]string{{"eve"}},
+		},
+		{
+			text: "SELECT id FROM users ORDER BY id LIMIT 2",
+			explain: "Project: id\n" +
+				"  -> Limit: 2\n" +
+				"    -> Sort: id (presorted)\n" +
+				"      -> SeqScan on users\n",
+			expected: [][]string{{"1"}, {"2"}},
+		},
+		{
+			text: "SELECT /*+ INDEX(users_city_idx) */ id FROM users WHERE id = 3 AND city = 'Paris'",
+			explain: "Project: id\n" +
+				"  -> Filter: id = 3\n" +
+				"    -> IndexScan on users using users_city_idx: city = 'Paris'\n",
+			expected: [][]string{{"3"}},
+		},
+		{
+			// The index is scanned even without a condition, returning the rows in city order
+			text: "SELECT /*+ INDEX(users_city_idx) */ id FROM users",
+			explain: "Project: id\n" +
+				"  -> IndexScan on users using users_city_idx\n",
+			expected: [][]string{{"4"}, {"1"}, {"3"}, {"5"}, {"2"}},
+		},
+		{
+			text: "SELECT /*+ NO_INDEX */ id FROM users WHERE name = 'bob'",
+			explain: "Project: id\n" +
+				"  -> Filter: name = 'bob'\n" +
+				"    -> SeqScan on users\n",
+			expected: [][]string{{"2"}},
+		},
+		{
+			text: "SELECT /*+ PARALLEL(4) NO_HASH_JOIN */ id FROM users WHERE city = 'Paris' ORDER BY id",
+			explain: "Project: id\n" +
+				"  -> Sort: id (presorted)\n" +
+				"    -> Filter: city = 'Paris'\n" +
+				"      -> ParallelSeqScan on users with 4 workers\n",
+			expected: [][]string{{"1"}, {"3"}, {"5"}},
+		},
+	}
+	for _, tt := range tests {
+		plan, err := planner.PlanQuery(tt.text)
+		if err != nil {
+			t.Fatalf("%s: %v", tt.text, err)
+		}
+		if got := plan.String(); got != tt.explain {
+			t.Errorf("%s: expected plan\n%s\ngot\n%s", tt.text, tt.explain, got)
+		}
+		exec, err := plan.Root.Start(bufmgr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var rows [][]string
+		for {
+			tup, ok, err := exec.Next(bufmgr)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !ok {
+				break
+			}
+			row, err := plan.Columns.Decode(tup)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var values []string
+			for _, v := range row.Values() {
+				values = append(values, v.String())
+			}
+			rows = append(rows, values)
+		}
+		if !reflect.DeepEqual(rows, tt.expected) {
+			t.Errorf("%s: expected %v, got %v", tt.text, tt.expected, rows)
+		}
+	}
+
+	plan, err := planner.PlanQuery("EXPLAIN SELECT id FROM users")
+	if err != nil || !plan.Explain {
+		t.Errorf("expected an EXPLAIN plan, got %+v (%v)", plan, err)
+	}
+}
+
+func TestPlanRecord(t *testing.T) {
+	planner, bufmgr := newTestPlanner(t)
+	plan, err := planner.PlanQuery("SELECT name FROM users WHERE age >= 30 AND city = 'Paris' AND age < 90")
+	if err != nil {
+		t.Fatal(err)
+	}
+	exec, err := plan.Root.Start(bufmgr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rows := 0
+	for {
+		_, ok, err := exec.Next(bufmgr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !ok {
+			break
+		}
+		rows++
+	}
+
+	// The index on city reads the three rows in Paris, of which alice and eve are returned
+	w := catalog.NewWorkload()
+	plan.Record(w, uint64(rows))
+	expected := []catalog.WorkloadQuery{{
+		Table:           "users",
+		EqualityColumns: []string{"city"},
+		RangeColumns:    []string{"age"},
+		Projected:       []string{"name"},
+		Count:           1,
+		RowsScanned:     3,
+		RowsReturned:    2,
+	}}
+	if got := w.Queries(); !reflect.DeepEqual(got, expected) {
+		t.Errorf("expected %+v, got %+v", expected, got)
+	}
+}
+
+func TestPlannerErrors(t *testing.T) {
+	planner, _ := newTestPlanner(t)
+	tests := []struct {
+		text     string
+		expected error
+	}{
+		{"SELECT * FROM missing", catalog.ErrTableNotFound},
+		{"SELECT email FROM users", catalog.ErrColumnNotFound},
+		{"SELECT id FROM users WHERE email = 'x'", catalog.ErrColumnNotFound},
+		{"SELECT id FROM users ORDER BY email", catalog.ErrColumnNotFound},
+		{"SELECT id FROM users WHERE age >= '30'", catalog.ErrTypeMismatch},
+		{"SELECT id FROM users WHERE age >= 29.5", catalog.ErrTypeMismatch},
+		{"SELECT id FROM", ErrSyntax},
+		{"SELECT /*+ INDEX(users_age_idx) */ id FROM users", ErrInvalidHint},
+		{"SELECT /*+ INDEX(users_city_idx) PARALLEL(2) */ id FROM users", ErrInvalidHint},
+		{"SELECT /*+ INDEX(users_city_idx) NO_INDEX */ id FROM users", ErrInvalidHint},
+	}
+	for _, tt := range tests {
+		if _, err := planner.PlanQuery(tt.text); !errors.Is(err, tt.expected) {
+			t.Errorf("%s: expected %v, got %v", tt.text, tt.expected, err)
+		}
+	}
+}
+
+func TestPlannerVersioned(t *testing.T) {
+	tmpfile, err := os.CreateTemp("", "test_planner_*.db")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.Remove(tmpfile.Name()) })
+	dm, err := disk.NewDiskManager(tmpfile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { dm.Close() })
+	bufmgr := buffer.NewBufferPoolManager(dm, buffer.NewBufferPool(32))
+	cm, err := catalog.NewCatalogManager(bufmgr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	schema, err := cm.CreateVersionedTable("items", []catalog.ColumnDef{
+		{Name: "id", Type: catalog.ColumnTypeInt, IsPrimaryKey: true},
+		{Name: "name", Type: catalog.ColumnTypeVarchar},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	items, err := cm.OpenTable("items")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tm := transaction.NewTransactionManager()
+	writer := tm.Begin()
+	items.Writer = writer.ID.Bytes()
+	for _, row := range [][]any{{1, "pen"}, {2, "ink"}} {
+		tup, err := schema.BindRow(row...)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := items.Insert(bufmgr, tup); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	planner := NewPlanner(cm)
+	plan, err := planner.PlanQuery("SELECT id FROM items")
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := "Project: id\n" +
+		"  -> FilterVisible\n" +
+		"    -> SeqScan on items\n"
+	if got := plan.String(); got != expected {
+		t.Errorf("expected plan\n%s\ngot\n%s", expected, got)
+	}
+	if _, err := plan.Root.Start(bufmgr); !errors.Is(err, query.ErrNoSnapshot) {
+		t.Errorf("expected ErrNoSnapshot without a snapshot, got %v", err)
+	}
+
+	count := func(txn *transaction.Transaction) int {
+		t.Helper()
+		plan, err := planner.PlanQuery("SELECT id FROM items")
+		if err != nil {
+			t.Fatal(err)
+		}
+		plan.ReadAt(func() *transaction.Snapshot { return tm.StatementSnapshot(txn) })
+		exec, err := plan.Root.Start(bufmgr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer query.CloseExecutor(bufmgr, exec)
+		rows := 0
+		for {
+			_, ok, err := exec.Next(bufmgr)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !ok {
+				return rows
+			}
+			rows++
+		}
+	}
+	reader := tm.Begin()
+	if n := count(reader); n != 0 {
+		t.Errorf("expected no uncommitted rows, got %d", n)
+	}
+	if n := count(writer); n != 2 {
+		t.Errorf("expected the writer to see its 2 rows, got %d", n)
+	}
+	if err := tm.Commit(writer); err != nil {
+		t.Fatal(err)
+	}
+	if n := count(reader); n != 2 {
+		t.Errorf("expected 2 rows after the commit, got %d", n)
+	}
+}
+
+func TestPlannerRunAs(t *testing.T) {
+	tmpfile, err := os.CreateTemp("", "test_planner_*.db")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.Remove(tmpfile.Name()) })
+	dm, err := disk.NewDiskManager(tmpfile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { dm.Close() })
+	bufmgr := buffer.NewBufferPoolManager(dm, buffer.NewBufferPool(32))
+	cm, err := catalog.NewCatalogManager(bufmgr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyring := catalog.NewColumnKeyring()
+	if err := keyring.AddKey(1, bytes.Repeat([]byte{7}, 32)); err != nil {
+		t.Fatal(err)
+	}
+
+	const privilegeSSN = catalog.PrivilegeSelect << 8
+	schema, err := cm.CreateTable("people", []catalog.ColumnDef{
+		{Name: "id", Type: catalog.ColumnTypeInt, IsPrimaryKey: true},
+		{Name: "name", Type: catalog.ColumnTypeVarchar},
+		{Name: "ssn", Type: catalog.ColumnTypeVarchar, Encryption: &catalog.ColumnEncryption{KeyID: 1, Decrypt: privilegeSSN}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	people, err := cm.OpenTable("people")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, row := range [][]any{{1, "alice", "111"}, {2, "bob", "222"}} {
+		tup, err := schema.BindRow(row...)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if tup, err = schema.EncryptRow(keyring, tup); err != nil {
+			t.Fatal(err)
+		}
+		if err := people.Insert(bufmgr, tup); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// Only bob is visible to the roles without the SSN privilege
+	err = cm.SetSecurityPolicy("people", &catalog.SecurityPolicy{
+		RowFilter:       func(tup [][]byte) bool { return string(tup[1]) == "bob" },
+		RowFilterExempt: privilegeSSN,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := cm.CreateRole("admin", true); err != nil {
+		t.Fatal(err)
+	}
+	if err := cm.CreateRole("clerk", false); err != nil {
+		t.Fatal(err)
+	}
+	if err := cm.Grant("clerk", "people", catalog.PrivilegeSelect); err != nil {
+		t.Fatal(err)
+	}
+	if err := cm.CreateRole("intern", false); err != nil {
+		t.Fatal(err)
+	}
+
+	planner := NewPlanner(cm)
+	planner.SetKeyring(keyring)
+	run := func(role string) ([]string, error) {
+		t.Helper()
+		plan, err := planner.PlanQuery("SELECT name, ssn FROM people")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if role != "" {
+			plan.RunAs(role)
+		}
+		exec, err := plan.Root.Start(bufmgr)
+		if err != nil {
+			return nil, err
+		}
+		defer query.CloseExecutor(bufmgr, exec)
+		var rows []string
+		for {
+			tup, ok, err := exec.Next(bufmgr)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !ok {
+				return rows, nil
+			}
+			ssn := string(tup[1])
+			if ssn != "111" && ssn != "222" {
+				ssn = "encrypted"
+			}
+			rows = append(rows, string(tup[0])+":"+ssn)
+		}
+	}
+
+	plan, err := planner.PlanQuery("SELECT name, ssn FROM people")
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := "Project: name, ssn\n" +
+		"  -> ApplyPolicy\n" +
+		"    -> DecryptColumns: ssn\n" +
+		"      -> SeqScan on people\n"
+	if got := plan.String(); got != expected {
+		t.Errorf("expected plan\n%s\ngot\n%s", expected, got)
+	}
+	for _, tt := range []struct {
+		role     string
+		expected []string
+	}{
+		{"", []string{"alice:111", "bob:222"}},
+		{"admin", []string{"alice:111", "bob:222"}},
+		{"clerk", []string{"bob:encrypted"}},
+	} {
+		rows, err := run(tt.role)
+		if err != nil {
+			t.Fatalf("%q: %v", tt.role, err)
+		}
+		if !reflect.DeepEqual(rows, tt.expected) {
+			t.Errorf("%q: expected %v, got %v", tt.role, tt.expected, rows)
+		}
+	}
+	if _, err := run("intern"); err != catalog.ErrPermissionDenied {
+		t.Errorf("expected ErrPermissionDenied without SELECT, got %v", err)
+	}
+	if _, err := run("nobody"); err != catalog.ErrRoleNotFound {
+		t.Errorf("expected ErrRoleNotFound, got %v", err)
+	}
+}
@@ -0,0 +1,120 @@
+package stats
+
+import (
+	"github.com/Johniel/gorelly/query"
+	"github.com/Johniel/gorelly/types"
+)
+
+const (
+	// DefaultEqSelectivity is the estimated fraction of rows equal to a value without statistics.
+	DefaultEqSelectivity = 0.005
+	// DefaultRangeSelectivity is the estimated fraction of rows in a range without statistics.
+	DefaultRangeSelectivity = 1.0 / 3
+)
+
+// Selectivity estimates the fraction of the rows of the table for which "column op v" holds,
+// between 0 and 1. Equality assumes the non-NULL values are spread evenly over the distinct
+// values, and ranges of INT, FLOAT, TIMESTAMP and INTERVAL columns assume they are spread
+// evenly between Min and Max. Without statistics of the column, the defaults are used.
+// NULL never matches, as in SQL.
+func (ts *TableStats) Selectivity(column string, op query.CompareOp, v types.Value) float64 {
+	if v.IsNull() {
+		return 0
+	}
+	cs := ts.Column(column)
+	if cs == nil {
+		if op == query.CompareEq {
+			return DefaultEqSelectivity
+		}
+		if op == query.CompareNe {
+			return 1 - DefaultEqSelectivity
+		}
+		return DefaultRangeSelectivity
+	}
+	if ts.RowCount == 0 {
+		return 0
+	}
+	nonNull := 1 - float64(cs.NullCount)/float64(ts.RowCount)
+	eq := cs.eqSelectivity(v, nonNull)
+	switch op {
+	case query.CompareEq:
+		return eq
+	case query.CompareNe:
+		return clamp(nonNull - eq)
+	}
+
+	// Fraction of the non-NULL values below v
+	below, ok := cs.position(v)
+	if !ok {
+		return nonNull * DefaultRangeSelectivity
+	}
+	var fraction float64
+	switch op {
+	case query.CompareLt:
+		fraction = below * nonNull
+	case query.CompareLe:
+		fraction = below*nonNull + eq
+	case query.CompareGt:
+		fraction = (1-below)*nonNull - eq
+	case query.CompareGe:
+		fraction = (1 - below) * nonNull
+	}
+	return clamp(fraction)
+}
+
+// eqSelectivity estimates the fraction of the rows equal to v, of which the fraction nonNull
+// are not NULL.
+func (cs *ColumnStats) eqSelectivity(v types.Value, nonNull float64) float64 {
+	if !cs.Min.IsNull() && (types.Compare(v, cs.Min) < 0 || 0 < types.Compare(v, cs.Max)) {
+		return 0
+	}
+	if cs.DistinctCount == 0 {
+		if nonNull == 0 {
+			return 0
+		}
+		return DefaultEqSelectivity
+	}
+	return nonNull / float64(cs.DistinctCount)
+}
+
+// position estimates the fraction of the non-NULL values of the column below v, if the bounds
+// of the column tell it.
+func (cs *ColumnStats) position(v types.Value) (float64, bool) {
+	if cs.Min.IsNull() {
+		return 0, false
+	}
+	switch {
+	case types.Compare(v, cs.Min) <= 0:
+		return 0, true
+	case 0 <= types.Compare(v, cs.Max):
+		return 1, true
+	}
+	lo, ok1 := numeric(cs.Min)
+	hi, ok2 := numeric(cs.Max)
+	x, ok3 := numeric(v)
+	if !ok1 || !ok2 || !ok3 || hi <= lo {
+		return 0, false
+	}
+	return clamp((x - lo) / (hi - lo)), true
+}
+
+// numeric returns the value of a number, a timestamp in microseconds or an interval in
+// microseconds as a float64.
+func numeric(v types.Value) (float64, bool) {
+	switch v.Kind() {
+	case types.KindInt64:
+		return float64(v.Int64()), true
+	case types.KindFloat64:
+		return v.Float64(), true
+	case types.KindTimestamp:
+		return float64(v.Timestamp().UnixMicro()), true
+	case types.KindInterval:
+		return float64(v.Interval().Microseconds()), true
+	default:
+		return 0, false
+	}
+}
+
+func clamp(f float64) float64 {
+	return min(max(f, 0), 1)
+}
@@ -0,0 +1,199 @@
+// Package stats computes the statistics of tables (ANALYZE): their row counts and, per column,
+// the number of NULLs, the estimated number of distinct values and the smallest and largest
+// values. They are saved in the catalog, so that users and the planner can inspect them and
+// estimate the selectivity of conditions without scanning the tables.
+package stats
+
+import (
+	"bytes"
+	"time"
+
+	"github.com/Johniel/gorelly/buffer"
+	"github.com/Johniel/gorelly/catalog"
+	"github.com/Johniel/gorelly/hll"
+	"github.com/Johniel/gorelly/query"
+	"github.com/Johniel/gorelly/types"
+)
+
+// MaxBoundSize is the size of the largest value kept as the Min or Max of a column.
+// Columns with larger values, e.g. long texts, get no bounds, which keeps the catalog records
+// small.
+const MaxBoundSize = 64
+
+// TableStats is the statistics of a table as of its last Analyze.
+type TableStats struct {
+	TableName  string
+	RowCount   uint64
+	AnalyzedAt time.Time
+	Columns    []ColumnStats // Columns of the table that were analyzed, in column order
+}
+
+// ColumnStats is the statistics of a column. Min and Max are NULL if the column has no
+// non-NULL values or its values were not compared: encrypted columns and columns with values
+// larger than MaxBoundSize have no bounds, and encrypted columns no distinct count either.
+type ColumnStats struct {
+	Name          string
+	Kind          types.Kind
+	NullCount     uint64
+	DistinctCount uint64 // Estimated with a HyperLogLog sketch
+	Min           types.Value
+	Max           types.Value
+}
+
+// Analyze computes the statistics of a table with a full scan of it, saves them in the catalog
+// and returns them. Dropped columns are skipped.
+func Analyze(bufmgr *buffer.BufferPoolManager, cm *catalog.CatalogManager, tableName string) (*TableStats, error) {
+	schema, err := cm.GetTableSchema(tableName)
+	if err != nil {
+		return nil, err
+	}
+	scan := &query.SeqScan{
+		TableMetaPageID: schema.MetaPageID,
+		SearchMode:      query.NewTupleSearchModeStart(),
+		WhileCond:       func(query.TupleSlice) bool { return true },
+		NumKeyElems:     schema.NumKeyElems,
+		Format:          schema.Format,
+		Defaults:        schema.Defaults(),
+		DroppedColumns:  schema.DroppedColumns(),
+	}
+	exec, err := scan.Start(bufmgr)
+	if err != nil {
+		return nil, err
+	}
+	defer exec.(query.Closer).Close(bufmgr)
+
+	columns := make([]columnAnalyzer, len(schema.Columns))
+	for i, col := range schema.Columns {
+		columns[i].bounded = true
+		if col.Encryption == nil && !col.Dropped {
+			columns[i].sketch = hll.NewSketch(hll.DefaultPrecision)
+		}
+	}
+	analyzedAt := time.Now()
+	var rowCount uint64
+	for {
+		tup, ok, err := exec.Next(bufmgr)
+		if err != nil {
+			return nil, err
+		}
+		if !ok {
+			break
+		}
+		rowCount++
+		for i := range columns {
+			var value []byte
+			if i < len(tup) {
+				value = tup[i]
+			}
+			columns[i].add(value)
+		}
+	}
+
+	saved := &catalog.TableStatistics{RowCount: rowCount, AnalyzedAt: analyzedAt}
+	for _, column := range columns {
+		saved.Columns = append(saved.Columns, column.statistics())
+	}
+	if err := cm.SetTableStatistics(tableName, saved); err != nil {
+		return nil, err
+	}
+	return tableStats(schema, saved)
+}
+
+// Get returns the statistics of a table saved by its last Analyze, or nil if the table was
+// never analyzed. Columns added to the table since then have no statistics.
+func Get(cm *catalog.CatalogManager, tableName string) (*TableStats, error) {
+	schema, err := cm.GetTableSchema(tableName)
+	if err != nil {
+		return nil, err
+	}
+	saved, err := cm.TableStatistics(tableName)
+	if err != nil || saved == nil {
+		return nil, err
+	}
+	return tableStats(schema, saved)
+}
+
+// Column returns the statistics of the column with the given name, or nil if it has none.
+func (ts *TableStats) Column(name string) *ColumnStats {
+	for i := range ts.Columns {
+		if ts.Columns[i].Name == name {
+			return &ts.Columns[i]
+		}
+	}
+	return nil
+}
+
+// tableStats returns the statistics saved in the catalog with the names and types of the columns.
+func tableStats(schema *catalog.TableSchema, saved *catalog.TableStatistics) (*TableStats, error) {
+	ts := &TableStats{TableName: schema.TableName, RowCount: saved.RowCount, AnalyzedAt: saved.AnalyzedAt}
+	for i, col := range schema.Columns {
+		if col.Dropped || len(saved.Columns) <= i {
+			continue
+		}
+		kind := col.Type.Kind()
+		lo, err := types.DecodeValue(kind, saved.Columns[i].Min)
+		if err != nil {
+			return nil, err
+		}
+		hi, err := types.DecodeValue(kind, saved.Columns[i].Max)
+		if err != nil {
+			return nil, err
+		}
+		ts.Columns = append(ts.Columns, ColumnStats{
+			Name:          col.Name,
+			Kind:          kind,
+			NullCount:     saved.Columns[i].NullCount,
+			DistinctCount: saved.Columns[i].DistinctCount,
+			Min:           lo,
+			Max:           hi,
+		})
+	}
+	return ts, nil
+}
+
+// columnAnalyzer accumulates the statistics of a column over the rows of a scan.
+type columnAnalyzer struct {
+	bounded   bool // Whether no value was larger than MaxBoundSize
+	nullCount uint64
+	nonNull   uint64
+	sketch    *hll.Sketch // Distinct values, nil if the values are not compared (encrypted)
+	min, max  []byte
+}
+
+func (ca *columnAnalyzer) add(value []byte) {
+	if len(value) == 0 {
+		ca.nullCount++
+		return
+	}
+	ca.nonNull++
+	if ca.sketch == nil {
+		return
+	}
+	ca.sketch.Add(value)
+	if MaxBoundSize < len(value) {
+		ca.bounded = false
+	}
+	if !ca.bounded {
+		return
+	}
+	// The values use the order-preserving encodings of their types
+	if ca.min == nil || bytes.Compare(value, ca.min) < 0 {
+		ca.min = append([]byte(nil), value...)
+	}
+	if ca.max == nil || 0 < bytes.Compare(value, ca.max) {
+		ca.max = append([]byte(nil), value...)
+	}
+}
+
+func (ca *columnAnalyzer) statistics() catalog.ColumnStatistics {
+	stats := catalog.ColumnStatistics{NullCount: ca.nullCount}
+	if ca.sketch == nil {
+		return stats
+	}
+	// The sketch may overestimate small counts
+	stats.DistinctCount = min(ca.sketch.Estimate(), ca.nonNull)
+	if ca.bounded {
+		stats.Min, stats.Max = ca.min, ca.max
+	}
+	return stats
+}
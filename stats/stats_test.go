@@ -0,0 +1,140 @@
+package stats
+
+import (
+	"math"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/Johniel/gorelly/buffer"
+	"github.com/Johniel/gorelly/catalog"
+	"github.com/Johniel/gorelly/disk"
+	"github.com/Johniel/gorelly/query"
+	"github.com/Johniel/gorelly/types"
+)
+
+func TestAnalyze(t *testing.T) {
+	tmpfile, err := os.CreateTemp("", "test_analyze_*.db")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(tmpfile.Name())
+
+	dm, err := disk.NewDiskManager(tmpfile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer dm.Close()
+
+	pool := buffer.NewBufferPool(10)
+	bufmgr := buffer.NewBufferPoolManager(dm, pool)
+
+	cm, err := catalog.NewCatalogManager(bufmgr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := cm.CreateTable("orders", []catalog.ColumnDef{
+		{Name: "id", Type: catalog.ColumnTypeInt, IsPrimaryKey: true},
+		{Name: "status", Type: catalog.ColumnTypeVarchar},
+		{Name: "discount", Type: catalog.ColumnTypeInt, Nullable: true},
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if ts, err := Get(cm, "orders"); err != nil || ts != nil {
+		t.Fatalf("expected no statistics before ANALYZE, got %v, %v", ts, err)
+	}
+
+	orders, err := cm.OpenTable("orders")
+	if err != nil {
+		t.Fatal(err)
+	}
+	schema, err := cm.GetTableSchema("orders")
+	if err != nil {
+		t.Fatal(err)
+	}
+	statuses := []string{"new", "paid", "shipped", "done"}
+	for id := 1; id <= 100; id++ {
+		var discount any = types.Null
+		if id%4 == 0 {
+			discount = id
+		}
+		row, err := schema.BindRow(id, statuses[id%len(statuses)], discount)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := orders.Insert(bufmgr, row); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	ts, err := Analyze(bufmgr, cm, "orders")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ts.RowCount != 100 || len(ts.Columns) != 3 {
+		t.Fatalf("unexpected statistics %+v", ts)
+	}
+	id := ts.Column("id")
+	if id.NullCount != 0 || id.DistinctCount != 100 || id.Min.Int64() != 1 || id.Max.Int64() != 100 {
+		t.Errorf("unexpected statistics of id %+v", id)
+	}
+	status := ts.Column("status")
+	if status.DistinctCount != 4 || status.Min.Varchar() != "done" || status.Max.Varchar() != "shipped" {
+		t.Errorf("unexpected statistics of status %+v", status)
+	}
+	discount := ts.Column("discount")
+	if discount.NullCount != 75 || discount.DistinctCount != 25 || discount.Min.Int64() != 4 || discount.Max.Int64() != 100 {
+		t.Errorf("unexpected statistics of discount %+v", discount)
+	}
+
+	// The statistics are saved in the catalog
+	saved, err := Get(cm, "orders")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if saved.RowCount != 100 || !saved.AnalyzedAt.Equal(ts.AnalyzedAt.Truncate(time.Microsecond)) || *saved.Column("discount") != *discount {
+		t.Errorf("expected %+v, got %+v", ts, saved)
+	}
+
+	for _, tc := range []struct {
+		column   string
+		op       query.CompareOp
+		value    types.Value
+		expected float64
+	}{
+		{"id", query.CompareEq, types.Int64(7), 0.01},
+		{"id", query.CompareEq, types.Int64(500), 0},
+		{"id", query.CompareNe, types.Int64(7), 0.99},
+		{"id", query.CompareLt, types.Int64(1), 0},
+		{"id", query.CompareGe, types.Int64(1), 1},
+		{"id", query.CompareLt, types.Int64(34), 1.0 / 3},
+		{"id", query.CompareGt, types.Int64(1000), 0},
+		{"status", query.CompareEq, types.Varchar("paid"), 0.25},
+		{"status", query.CompareLt, types.Varchar("a"), 0},
+		{"status", query.CompareLt, types.Varchar("new"), DefaultRangeSelectivity},
+		{"discount", query.CompareEq, types.Int64(8), 0.01},
+		{"discount", query.CompareNe, types.Int64(8), 0.24},
+		{"discount", query.CompareGe, types.Int64(4), 0.25},
+		{"discount", query.CompareEq, types.Null, 0},
+		{"missing", query.CompareEq, types.Int64(1), DefaultEqSelectivity},
+	} {
+		if got := ts.Selectivity(tc.column, tc.op, tc.value); math.Abs(got-tc.expected) > 1e-9 {
+			t.Errorf("%s %v %v: expected %v, got %v", tc.column, tc.op, tc.value, tc.expected, got)
+		}
+	}
+
+	// Analyzing again replaces the statistics
+	row, err := schema.BindRow(101, "new", types.Null)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := orders.Insert(bufmgr, row); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Analyze(bufmgr, cm, "orders"); err != nil {
+		t.Fatal(err)
+	}
+	if saved, err := Get(cm, "orders"); err != nil || saved.RowCount != 101 || saved.Column("id").Max.Int64() != 101 {
+		t.Errorf("expected the new statistics, got %+v, %v", saved, err)
+	}
+}
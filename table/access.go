@@ -0,0 +1,78 @@
+package table
+
+import (
+	"sync/atomic"
+
+	"github.com/Johniel/gorelly/btree"
+)
+
+// AccessStats counts how a table is used, as input for tuning: the scans run against it,
+// the rows they read, the rows changed and how well its pages are cached.
+// Scans record themselves when given the stats (see query.SeqScan.Stats), and DML records the
+// changed rows when DML is set as the Table's Counters. Like DMLCounters, the counters only grow.
+type AccessStats struct {
+	DML          DMLCounters // Rows changed; set &DML as Table.Counters
+	SeqScans     atomic.Uint64
+	IndexScans   atomic.Uint64
+	RowsRead     atomic.Uint64 // Rows returned by scans
+	PagesFetched atomic.Uint64 // B+ tree pages fetched by scans
+	PagesRead    atomic.Uint64 // Pages fetched by scans that had to be read from disk
+}
+
+// AccessSnapshot is a copy of the values of AccessStats.
+type AccessSnapshot struct {
+	SeqScans     uint64
+	IndexScans   uint64
+	RowsRead     uint64
+	Inserts      uint64
+	Updates      uint64
+	Deletes      uint64
+	PagesFetched uint64
+	PagesRead    uint64
+}
+
+// RowsWritten returns the number of rows inserted, updated or deleted.
+func (s AccessSnapshot) RowsWritten() uint64 {
+	return s.Inserts + s.Updates + s.Deletes
+}
+
+// CacheHitRatio returns the fraction of page fetches served from the buffer pool,
+// or 0 if no page was fetched.
+func (s AccessSnapshot) CacheHitRatio() float64 {
+	if s.PagesFetched == 0 {
+		return 0
+	}
+	return float64(s.PagesFetched-s.PagesRead) / float64(s.PagesFetched)
+}
+
+// Snapshot returns the current values of the counters.
+func (as *AccessStats) Snapshot() AccessSnapshot {
+	return AccessSnapshot{
+		SeqScans:     as.SeqScans.Load(),
+		IndexScans:   as.IndexScans.Load(),
+		RowsRead:     as.RowsRead.Load(),
+		Inserts:      as.DML.Inserts.Load(),
+		Updates:      as.DML.Updates.Load(),
+		Deletes:      as.DML.Deletes.Load(),
+		PagesFetched: as.PagesFetched.Load(),
+		PagesRead:    as.PagesRead.Load(),
+	}
+}
+
+// Add adds the values of a snapshot to the counters, e.g. to continue from persisted values.
+func (as *AccessStats) Add(s AccessSnapshot) {
+	as.SeqScans.Add(s.SeqScans)
+	as.IndexScans.Add(s.IndexScans)
+	as.RowsRead.Add(s.RowsRead)
+	as.DML.Inserts.Add(s.Inserts)
+	as.DML.Updates.Add(s.Updates)
+	as.DML.Deletes.Add(s.Deletes)
+	as.PagesFetched.Add(s.PagesFetched)
+	as.PagesRead.Add(s.PagesRead)
+}
+
+// AddPages records pages fetched by a scan, as counted by its B+ tree iterators.
+func (as *AccessStats) AddPages(counts btree.PageCounts) {
+	as.PagesFetched.Add(counts.Fetched)
+	as.PagesRead.Add(counts.Read)
+}
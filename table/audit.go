@@ -0,0 +1,169 @@
+package table
+
+import (
+	"encoding/binary"
+	"sync"
+	"time"
+
+	"github.com/Johniel/gorelly/btree"
+	"github.com/Johniel/gorelly/buffer"
+	"github.com/Johniel/gorelly/clock"
+	"github.com/Johniel/gorelly/disk"
+	"github.com/Johniel/gorelly/errcode"
+	"github.com/Johniel/gorelly/tuple"
+	"github.com/Johniel/gorelly/types"
+)
+
+var (
+	// ErrInvalidAuditRecord is returned when decoding a tuple that is not an audit record.
+	ErrInvalidAuditRecord = errcode.New(errcode.Corruption, "invalid audit record")
+)
+
+// AuditOp identifies the kind of change recorded in an audit trail.
+type AuditOp byte
+
+const (
+	AuditOpInsert AuditOp = 'I'
+	AuditOpUpdate AuditOp = 'U'
+	AuditOpDelete AuditOp = 'D'
+)
+
+// AuditRecord is a decoded entry of an audit trail.
+type AuditRecord struct {
+	Seq    uint64    // Sequence number, increasing in the order changes were made
+	Op     AuditOp   // Kind of change
+	Actor  string    // Who made the change
+	Time   time.Time // When the change was made
+	Before [][]byte  // Tuple before the change (nil for inserts)
+	After  [][]byte  // Tuple after the change (nil for deletes)
+}
+
+// AuditTrail records every change made through a Table into an audit table.
+// The audit table is an ordinary B+ tree with one primary key element, so it can be
+// read with SeqScan like any other table. Its tuples have the schema:
+//
+//	[seq (PK), op, actor, time (TIMESTAMP), before, after]
+//
+// where before and after are the tuple-encoded images of the changed row.
+type AuditTrail struct {
+	MetaPageID disk.PageID   // Page ID of the B+ tree meta page for the audit table
+	Actor      func() string // Returns who is making the change (nil records an empty actor)
+	Clock      clock.Clock   // Optional: source of the change timestamps (nil uses the system clock)
+	nextSeq    uint64        // Next sequence number (0 until loaded from the audit table)
+	mu         sync.Mutex
+}
+
+func (at *AuditTrail) Create(bufmgr *buffer.BufferPoolManager) error {
+	return at.create(bufmgr, nil)
+}
+
+// create creates the audit table, logging its creation to log.
+func (at *AuditTrail) create(bufmgr *buffer.BufferPoolManager, log btree.PageLog) error {
+	bt, err := btree.CreateBTreeWithLog(bufmgr, log)
+	if err != nil {
+		return err
+	}
+	at.MetaPageID = bt.MetaPageID
+	at.nextSeq = 1
+	return nil
+}
+
+// Record appends a change to the audit table.
+func (at *AuditTrail) Record(bufmgr *buffer.BufferPoolManager, op AuditOp, before [][]byte, after [][]byte) error {
+	at.mu.Lock()
+	defer at.mu.Unlock()
+
+	if at.nextSeq == 0 {
+		if err := at.loadNextSeq(bufmgr); err != nil {
+			return err
+		}
+	}
+	seq := make([]byte, 8)
+	binary.BigEndian.PutUint64(seq, at.nextSeq)
+	actor := ""
+	if at.Actor != nil {
+		actor = at.Actor()
+	}
+
+	keyBytes := make([]byte, 0)
+	tuple.Encode([][]byte{seq}, &keyBytes)
+	valueBytes := make([]byte, 0)
+	tuple.Encode([][]byte{
+		{byte(op)},
+		[]byte(actor),
+		types.EncodeTimestamp(at.now()),
+		encodeImage(before),
+		encodeImage(after),
+	}, &valueBytes)
+
+	bt := btree.NewBTree(at.MetaPageID)
+	if err := bt.Insert(bufmgr, keyBytes, valueBytes); err != nil {
+		return err
+	}
+	at.nextSeq++
+	return nil
+}
+
+func (at *AuditTrail) now() time.Time {
+	if at.Clock == nil {
+		return clock.System.Now()
+	}
+	return at.Clock.Now()
+}
+
+// loadNextSeq finds the sequence number following the last record of an existing audit table.
+func (at *AuditTrail) loadNextSeq(bufmgr *buffer.BufferPoolManager) error {
+	bt := btree.NewBTree(at.MetaPageID)
+	iter, err := bt.Search(bufmgr, btree.NewSearchModeStart())
+	if err != nil {
+		return err
+	}
+	defer iter.Close()
+	at.nextSeq = 1
+	for {
+		keyBytes, _, ok, err := iter.Next(bufmgr)
+		if err != nil {
+			return err
+		}
+		if !ok {
+			return nil
+		}
+		var key [][]byte
+		tuple.Decode(keyBytes, &key)
+		at.nextSeq = binary.BigEndian.Uint64(key[0]) + 1
+	}
+}
+
+// DecodeAuditRecord decodes a tuple read from an audit table.
+func DecodeAuditRecord(tup [][]byte) (*AuditRecord, error) {
+	if len(tup) != 6 || len(tup[0]) != 8 || len(tup[1]) != 1 {
+		return nil, ErrInvalidAuditRecord
+	}
+	ts, err := types.DecodeTimestamp(tup[3])
+	if err != nil {
+		return nil, ErrInvalidAuditRecord
+	}
+	return &AuditRecord{
+		Seq:    binary.BigEndian.Uint64(tup[0]),
+		Op:     AuditOp(tup[1][0]),
+		Actor:  string(tup[2]),
+		Time:   ts,
+		Before: decodeImage(tup[4]),
+		After:  decodeImage(tup[5]),
+	}, nil
+}
+
+func encodeImage(tup [][]byte) []byte {
+	image := make([]byte, 0)
+	tuple.Encode(tup, &image)
+	return image
+}
+
+func decodeImage(image []byte) [][]byte {
+	if len(image) == 0 {
+		return nil
+	}
+	var tup [][]byte
+	tuple.Decode(image, &tup)
+	return tup
+}
@@ -0,0 +1,122 @@
+package table
+
+import (
+	"os"
+	"reflect"
+	"testing"
+
+	"github.com/Johniel/gorelly/btree"
+	"github.com/Johniel/gorelly/buffer"
+	"github.com/Johniel/gorelly/disk"
+	"github.com/Johniel/gorelly/tuple"
+)
+
+func TestAuditTrail(t *testing.T) {
+	tmpfile, err := os.CreateTemp("", "test_audit_trail_*.db")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(tmpfile.Name())
+
+	dm, err := disk.NewDiskManager(tmpfile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer dm.Close()
+
+	pool := buffer.NewBufferPool(10)
+	bufmgr := buffer.NewBufferPoolManager(dm, pool)
+
+	actor := "alice"
+	tbl := &Table{
+		MetaPageID:  disk.InvalidPageID,
+		NumKeyElems: 1,
+		Audit: &AuditTrail{
+			MetaPageID: disk.InvalidPageID,
+			Actor:      func() string { return actor },
+		},
+	}
+	if err := tbl.Create(bufmgr); err != nil {
+		t.Fatal(err)
+	}
+
+	// Schema: [id, name]
+	if err := tbl.Insert(bufmgr, [][]byte{[]byte("1"), []byte("Alice")}); err != nil {
+		t.Fatal(err)
+	}
+	actor = "bob"
+	if err := tbl.Update(bufmgr, [][]byte{[]byte("1"), []byte("Alicia")}); err != nil {
+		t.Fatal(err)
+	}
+	if err := tbl.Delete(bufmgr, [][]byte{[]byte("1")}); err != nil {
+		t.Fatal(err)
+	}
+
+	readAudit := func(trail *AuditTrail) []*AuditRecord {
+		bt := btree.NewBTree(trail.MetaPageID)
+		iter, err := bt.Search(bufmgr, btree.NewSearchModeStart())
+		if err != nil {
+			t.Fatal(err)
+		}
+		var records []*AuditRecord
+		for {
+			keyBytes, valueBytes, ok, err := iter.Next(bufmgr)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !ok {
+				break
+			}
+			var tup [][]byte
+			tuple.Decode(keyBytes, &tup)
+			tuple.Decode(valueBytes, &tup)
+			record, err := DecodeAuditRecord(tup)
+			if err != nil {
+				t.Fatal(err)
+			}
+			records = append(records, record)
+		}
+		return records
+	}
+
+	records := readAudit(tbl.Audit)
+	if len(records) != 3 {
+		t.Fatalf("Expected 3 audit records, got %d", len(records))
+	}
+
+	expected := []struct {
+		op     AuditOp
+		actor  string
+		before [][]byte
+		after  [][]byte
+	}{
+		{AuditOpInsert, "alice", nil, [][]byte{[]byte("1"), []byte("Alice")}},
+		{AuditOpUpdate, "bob", [][]byte{[]byte("1"), []byte("Alice")}, [][]byte{[]byte("1"), []byte("Alicia")}},
+		{AuditOpDelete, "bob", [][]byte{[]byte("1"), []byte("Alicia")}, nil},
+	}
+	for i, e := range expected {
+		r := records[i]
+		if r.Seq != uint64(i+1) {
+			t.Errorf("Record %d: expected seq %d, got %d", i, i+1, r.Seq)
+		}
+		if r.Op != e.op || r.Actor != e.actor {
+			t.Errorf("Record %d: expected %c by %s, got %c by %s", i, e.op, e.actor, r.Op, r.Actor)
+		}
+		if !reflect.DeepEqual(r.Before, e.before) || !reflect.DeepEqual(r.After, e.after) {
+			t.Errorf("Record %d: expected %v -> %v, got %v -> %v", i, e.before, e.after, r.Before, r.After)
+		}
+		if r.Time.IsZero() {
+			t.Errorf("Record %d: expected a timestamp", i)
+		}
+	}
+
+	// A reopened trail continues the sequence of the existing audit table
+	reopened := &AuditTrail{MetaPageID: tbl.Audit.MetaPageID}
+	if err := reopened.Record(bufmgr, AuditOpInsert, nil, [][]byte{[]byte("2"), []byte("Bob")}); err != nil {
+		t.Fatal(err)
+	}
+	records = readAudit(reopened)
+	if last := records[len(records)-1]; last.Seq != 4 || last.Actor != "" {
+		t.Errorf("Expected seq 4 with empty actor, got seq %d actor %q", last.Seq, last.Actor)
+	}
+}
@@ -0,0 +1,86 @@
+package table
+
+import (
+	"errors"
+	"os"
+	"reflect"
+	"testing"
+
+	"github.com/Johniel/gorelly/btree"
+	"github.com/Johniel/gorelly/buffer"
+	"github.com/Johniel/gorelly/disk"
+)
+
+func TestTableInsertBatch(t *testing.T) {
+	tmpfile, err := os.CreateTemp("", "test_table_insert_batch_*.db")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(tmpfile.Name())
+
+	dm, err := disk.NewDiskManager(tmpfile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer dm.Close()
+
+	pool := buffer.NewBufferPool(10)
+	bufmgr := buffer.NewBufferPoolManager(dm, pool)
+
+	// [id, email, bio] with a unique index on email and a text index on bio
+	tbl := &Table{
+		NumKeyElems:   1,
+		UniqueIndices: []*UniqueIndex{{Skey: []int{1}}},
+		TextIndices:   []*TextIndex{{Column: 2}},
+		Counters:      &DMLCounters{},
+	}
+	if err := tbl.Create(bufmgr); err != nil {
+		t.Fatal(err)
+	}
+	row := func(id, email, bio string) [][]byte {
+		return [][]byte{[]byte(id), []byte(email), []byte(bio)}
+	}
+	if err := tbl.Insert(bufmgr, row("0", "zed@example.com", "existing")); err != nil {
+		t.Fatal(err)
+	}
+
+	rows := [][][]byte{
+		row("3", "carol@example.com", "hello"),
+		row("1", "alice@example.com", "hello world"),
+		row("0", "other@example.com", "duplicate primary key"),
+		row("2", "zed@example.com", "existing email"),
+		row("4", "alice@example.com", "email within the batch"),
+		row("1", "bob@example.com", "primary key within the batch"),
+	}
+	errs := tbl.InsertBatch(bufmgr, rows)
+	if len(errs) != len(rows) {
+		t.Fatalf("expected %d errors, got %v", len(rows), errs)
+	}
+	for i, expected := range []error{nil, nil, btree.ErrDuplicateKey, btree.ErrDuplicateKey, btree.ErrDuplicateKey, btree.ErrDuplicateKey} {
+		if !errors.Is(errs[i], expected) {
+			t.Errorf("row %d: expected %v, got %v", i, expected, errs[i])
+		}
+	}
+	for _, i := range []int{0, 1} {
+		tup, err := tbl.Get(bufmgr, rows[i][:1])
+		if err != nil || !reflect.DeepEqual(tup, rows[i]) {
+			t.Errorf("expected row %q, got %q, %v", rows[i], tup, err)
+		}
+	}
+	for _, id := range []string{"2", "4"} {
+		if _, err := tbl.Get(bufmgr, [][]byte{[]byte(id)}); !errors.Is(err, btree.ErrKeyNotFound) {
+			t.Errorf("expected row %s not to be inserted, got %v", id, err)
+		}
+	}
+	// The rows that failed on a unique index left no entries behind
+	if found, err := tbl.VerifyIndexes(bufmgr); err != nil || len(found) != 0 {
+		t.Errorf("expected consistent indexes, got %v, %v", found, err)
+	}
+	if inserts := tbl.Counters.Inserts.Load(); inserts != 3 {
+		t.Errorf("expected 3 inserts, got %d", inserts)
+	}
+
+	if errs := tbl.InsertBatch(bufmgr, [][][]byte{row("5", "dave@example.com", "a"), row("6", "erin@example.com", "b")}); errs != nil {
+		t.Errorf("expected no errors, got %v", errs)
+	}
+}
@@ -0,0 +1,55 @@
+package table
+
+import (
+	"fmt"
+
+	"github.com/Johniel/gorelly/errcode"
+)
+
+// ErrCheckViolation is wrapped by every CheckViolationError.
+var ErrCheckViolation = errcode.New(errcode.ConstraintViolation, "check constraint violation")
+
+// Check is a CHECK constraint: a condition every tuple of the table must satisfy. Insert,
+// InsertBatch, Update, Upsert and UpdateKey evaluate it before anything is written.
+type Check struct {
+	Name  string                  // Name reported in a CheckViolationError
+	Holds func(tup [][]byte) bool // Whether a tuple satisfies the constraint
+}
+
+// CheckViolationError is returned when a tuple does not satisfy a Check of the table. It wraps
+// ErrCheckViolation.
+type CheckViolationError struct {
+	Check string   // Name of the check
+	Key   [][]byte // Primary key of the tuple
+}
+
+func (e *CheckViolationError) Error() string {
+	return fmt.Sprintf("row with key %q violates check constraint %q", e.Key, e.Check)
+}
+
+func (e *CheckViolationError) Unwrap() error {
+	return ErrCheckViolation
+}
+
+// withDefaults returns tup completed with the Defaults of the trailing elements it lacks, or tup
+// itself if it lacks none. tup is not modified.
+func (t *Table) withDefaults(tup [][]byte) [][]byte {
+	if len(t.Defaults) <= len(tup) {
+		return tup
+	}
+	completed := make([][]byte, len(t.Defaults))
+	copy(completed, tup)
+	copy(completed[len(tup):], t.Defaults[len(tup):])
+	return completed
+}
+
+// checkConstraints returns a CheckViolationError for the first Check of the table that tup does
+// not satisfy.
+func (t *Table) checkConstraints(tup [][]byte) error {
+	for _, check := range t.Checks {
+		if !check.Holds(tup) {
+			return &CheckViolationError{Check: check.Name, Key: tup[:t.NumKeyElems]}
+		}
+	}
+	return nil
+}
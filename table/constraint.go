@@ -0,0 +1,82 @@
+package table
+
+import (
+	"bytes"
+	"fmt"
+
+	"github.com/Johniel/gorelly/btree"
+	"github.com/Johniel/gorelly/buffer"
+)
+
+// ConstraintViolationError is returned when a tuple would take the secondary key of another tuple
+// in a unique index. It wraps btree.ErrDuplicateKey, so errors.Is(err, btree.ErrDuplicateKey)
+// holds and errcode.Of returns errcode.ConstraintViolation.
+type ConstraintViolationError struct {
+	Index string   // Name of the unique index (UniqueIndex.Name), possibly empty
+	Key   [][]byte // Conflicting secondary key: the elements of the tuple at UniqueIndex.Skey
+}
+
+func (e *ConstraintViolationError) Error() string {
+	if e.Index == "" {
+		return fmt.Sprintf("duplicate key %q violates a unique index", e.Key)
+	}
+	return fmt.Sprintf("duplicate key %q violates unique index %q", e.Key, e.Index)
+}
+
+func (e *ConstraintViolationError) Unwrap() error {
+	return btree.ErrDuplicateKey
+}
+
+// violation returns a ConstraintViolationError for the secondary key of tup if err is
+// btree.ErrDuplicateKey, and err otherwise.
+func (ui *UniqueIndex) violation(tup [][]byte, err error) error {
+	if err != btree.ErrDuplicateKey {
+		return err
+	}
+	key := make([][]byte, len(ui.Skey))
+	for i, idx := range ui.Skey {
+		key[i] = tup[idx]
+	}
+	return &ConstraintViolationError{Index: ui.Name, Key: key}
+}
+
+// coversKey reports whether the secondary key includes every primary key element. Such an index
+// cannot be violated by a tuple whose primary key is free.
+func (ui *UniqueIndex) coversKey(numKeyElems int) bool {
+	for i := 0; i < numKeyElems; i++ {
+		found := false
+		for _, idx := range ui.Skey {
+			found = found || idx == i
+		}
+		if !found {
+			return false
+		}
+	}
+	return true
+}
+
+// checkUniqueKeys is the check phase of a write of tup replacing oldTuple (nil for an insert). It
+// returns a ConstraintViolationError if a unique index already holds a secondary key of tup that
+// differs from that of oldTuple, and otherwise the indexes whose keys differ. Indexes covering the
+// primary key are not searched: a conflict there is one of the primary key, which the caller detects.
+func (t *Table) checkUniqueKeys(bufmgr *buffer.BufferPoolManager, oldTuple [][]byte, tup [][]byte) ([]*UniqueIndex, error) {
+	var changed []*UniqueIndex
+	for _, uniqueIndex := range t.UniqueIndices {
+		newSkey := uniqueIndex.skeyBytes(tup)
+		if oldTuple != nil && bytes.Equal(uniqueIndex.skeyBytes(oldTuple), newSkey) {
+			continue
+		}
+		changed = append(changed, uniqueIndex)
+		if uniqueIndex.coversKey(t.NumKeyElems) {
+			continue
+		}
+		exists, err := uniqueIndex.contains(bufmgr, newSkey)
+		if err != nil {
+			return nil, err
+		}
+		if exists {
+			return nil, uniqueIndex.violation(tup, btree.ErrDuplicateKey)
+		}
+	}
+	return changed, nil
+}
@@ -0,0 +1,87 @@
+package table
+
+import (
+	"errors"
+	"os"
+	"reflect"
+	"testing"
+
+	"github.com/Johniel/gorelly/btree"
+	"github.com/Johniel/gorelly/buffer"
+	"github.com/Johniel/gorelly/disk"
+	"github.com/Johniel/gorelly/errcode"
+)
+
+func TestConstraintViolation(t *testing.T) {
+	tmpfile, err := os.CreateTemp("", "test_constraint_violation_*.db")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(tmpfile.Name())
+
+	dm, err := disk.NewDiskManager(tmpfile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer dm.Close()
+
+	pool := buffer.NewBufferPool(10)
+	bufmgr := buffer.NewBufferPoolManager(dm, pool)
+
+	// [id, first, last, email] with unique indexes on (first, last) and email
+	tbl := &Table{
+		NumKeyElems: 1,
+		UniqueIndices: []*UniqueIndex{
+			{Skey: []int{1, 2}, Name: "users_name_key"},
+			{Skey: []int{3}, Name: "users_email_key"},
+		},
+		Counters: &DMLCounters{},
+	}
+	if err := tbl.Create(bufmgr); err != nil {
+		t.Fatal(err)
+	}
+	if err := tbl.Insert(bufmgr, [][]byte{[]byte("1"), []byte("Ada"), []byte("Lovelace"), []byte("ada@example.com")}); err != nil {
+		t.Fatal(err)
+	}
+	if err := bufmgr.Flush(); err != nil {
+		t.Fatal(err)
+	}
+
+	// The second index is violated: nothing is written, not even to the primary tree
+	before := bufmgr.IOStats()
+	err = tbl.Insert(bufmgr, [][]byte{[]byte("2"), []byte("Ada"), []byte("Byron"), []byte("ada@example.com")})
+	var violation *ConstraintViolationError
+	if !errors.As(err, &violation) {
+		t.Fatalf("expected a ConstraintViolationError, got %v", err)
+	}
+	if violation.Index != "users_email_key" || !reflect.DeepEqual(violation.Key, [][]byte{[]byte("ada@example.com")}) {
+		t.Errorf("unexpected violation %+v", violation)
+	}
+	if !errors.Is(err, btree.ErrDuplicateKey) || errcode.Of(err) != errcode.ConstraintViolation {
+		t.Errorf("expected the violation to wrap ErrDuplicateKey, got %v", err)
+	}
+	if dirtied := bufmgr.IOStats().Sub(before).PagesDirtied; dirtied != 0 {
+		t.Errorf("expected no page to be written, %d were", dirtied)
+	}
+	if _, err := tbl.Get(bufmgr, [][]byte{[]byte("2")}); !errors.Is(err, btree.ErrKeyNotFound) {
+		t.Errorf("expected no row 2, got %v", err)
+	}
+	if inserts := tbl.Counters.Inserts.Load(); inserts != 1 {
+		t.Errorf("expected 1 insert, got %d", inserts)
+	}
+
+	// A multi-column key is reported with all its elements
+	err = tbl.Insert(bufmgr, [][]byte{[]byte("2"), []byte("Ada"), []byte("Lovelace"), []byte("countess@example.com")})
+	if !errors.As(err, &violation) || violation.Index != "users_name_key" {
+		t.Fatalf("expected a violation of users_name_key, got %v", err)
+	}
+	if violation.Error() != `duplicate key ["Ada" "Lovelace"] violates unique index "users_name_key"` {
+		t.Errorf("unexpected message %q", violation.Error())
+	}
+
+	// A duplicate primary key is not a violation of an index
+	err = tbl.Insert(bufmgr, [][]byte{[]byte("1"), []byte("Grace"), []byte("Hopper"), []byte("grace@example.com")})
+	if err != btree.ErrDuplicateKey {
+		t.Errorf("expected ErrDuplicateKey, got %v", err)
+	}
+}
@@ -0,0 +1,100 @@
+package table
+
+import (
+	"bytes"
+	"sort"
+
+	"github.com/Johniel/gorelly/btree"
+	"github.com/Johniel/gorelly/buffer"
+	"github.com/Johniel/gorelly/tuple"
+)
+
+// DeleteBatchSize is the number of rows DeleteWhere collects from the index before deleting them.
+const DeleteBatchSize = 256
+
+// DeleteWhere deletes the rows whose secondary keys in index, one of the table's UniqueIndices,
+// are at least lo and below hi, and that satisfy cond (nil deletes every row in the range).
+// The bounds may hold fewer elements than the secondary key and are compared as encoded
+// prefixes, as in EstimateRange; a nil bound leaves that side of the range open. It returns the
+// number of rows deleted, e.g. to purge the rows older than a date through an index on it.
+//
+// The primary keys are collected from the index in batches of DeleteBatchSize and deleted in
+// primary key order, with their entries in every index. All changes go through the table's Log,
+// so they belong to the single transaction of the caller; on an error, the rows deleted so far
+// are only restored by rolling it back.
+func (t *Table) DeleteWhere(bufmgr *buffer.BufferPoolManager, index *UniqueIndex, lo [][]byte, hi [][]byte, cond func(tup [][]byte) bool) (int, error) {
+	if err := t.checkAccess(AccessDelete); err != nil {
+		return 0, err
+	}
+	var loBytes, hiBytes []byte
+	if lo != nil {
+		tuple.Encode(lo, &loBytes)
+	}
+	if hi != nil {
+		tuple.Encode(hi, &hiBytes)
+	}
+	bt := t.primaryTree()
+	deleted := 0
+	var resume []byte // Secondary key of the last entry of the previous batch
+	for {
+		pkeys, last, err := index.collectPkeys(bufmgr, loBytes, hiBytes, resume)
+		if err != nil {
+			return deleted, err
+		}
+		sort.Slice(pkeys, func(i, j int) bool { return bytes.Compare(pkeys[i], pkeys[j]) < 0 })
+		for _, keyBytes := range pkeys {
+			fullTuple, err := t.fetchTuple(bufmgr, keyBytes)
+			if err != nil {
+				return deleted, err
+			}
+			if t.versioned() && t.Versions.deleted(fullTuple) {
+				continue
+			}
+			if cond != nil && !cond(fullTuple) {
+				continue
+			}
+			if err := t.deleteTuple(bufmgr, bt, keyBytes, fullTuple); err != nil {
+				return deleted, err
+			}
+			deleted++
+		}
+		if len(pkeys) < DeleteBatchSize {
+			return deleted, nil
+		}
+		resume = last
+	}
+}
+
+// collectPkeys returns the primary keys of up to DeleteBatchSize entries whose secondary keys
+// are at least lo, below hi (nil for no bound), and after resume (nil to start at lo), with the
+// secondary key of the last one. The iterator is closed before the entries are deleted.
+func (ui *UniqueIndex) collectPkeys(bufmgr *buffer.BufferPoolManager, lo []byte, hi []byte, resume []byte) ([][]byte, []byte, error) {
+	searchMode := btree.NewSearchModeStart()
+	if resume != nil {
+		searchMode = btree.NewSearchModeKey(resume)
+	} else if lo != nil {
+		searchMode = btree.NewSearchModeKey(lo)
+	}
+	iter, err := btree.NewBTree(ui.MetaPageID).Search(bufmgr, searchMode)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer iter.Close()
+	var pkeys [][]byte
+	var last []byte
+	for len(pkeys) < DeleteBatchSize {
+		skeyBytes, pkeyBytes, ok, err := iter.Next(bufmgr)
+		if err != nil {
+			return nil, nil, err
+		}
+		if !ok || (hi != nil && 0 <= bytes.Compare(skeyBytes, hi)) {
+			break
+		}
+		if resume != nil && bytes.Compare(skeyBytes, resume) <= 0 {
+			continue
+		}
+		last = skeyBytes
+		pkeys = append(pkeys, pkeyBytes)
+	}
+	return pkeys, last, nil
+}
@@ -0,0 +1,107 @@
+package table
+
+import (
+	"fmt"
+	"os"
+	"testing"
+
+	"github.com/Johniel/gorelly/btree"
+	"github.com/Johniel/gorelly/buffer"
+	"github.com/Johniel/gorelly/disk"
+)
+
+func TestTableDeleteWhere(t *testing.T) {
+	tmpfile, err := os.CreateTemp("", "test_table_delete_where_*.db")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(tmpfile.Name())
+
+	dm, err := disk.NewDiskManager(tmpfile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer dm.Close()
+
+	pool := buffer.NewBufferPool(10)
+	bufmgr := buffer.NewBufferPoolManager(dm, pool)
+
+	// Schema: [id, created, payload] with an index over (created, id)
+	tbl := &Table{
+		MetaPageID:    disk.InvalidPageID,
+		NumKeyElems:   1,
+		UniqueIndices: []*UniqueIndex{{MetaPageID: disk.InvalidPageID, Skey: []int{1, 0}}},
+		Counters:      &DMLCounters{},
+	}
+	if err := tbl.Create(bufmgr); err != nil {
+		t.Fatal(err)
+	}
+	const numRows = 1000
+	// The ids run against the creation dates, so that the index and primary key orders differ
+	row := func(i int) [][]byte {
+		return [][]byte{[]byte(fmt.Sprintf("id%04d", numRows-i)), []byte(fmt.Sprintf("2024-%04d", i)), []byte("payload")}
+	}
+	for i := 0; i < numRows; i++ {
+		if err := tbl.Insert(bufmgr, row(i)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	count := func(metaPageID disk.PageID) int {
+		t.Helper()
+		iter, err := btree.NewBTree(metaPageID).Search(bufmgr, btree.NewSearchModeStart())
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer iter.Close()
+		n := 0
+		for {
+			_, _, ok, err := iter.Next(bufmgr)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !ok {
+				return n
+			}
+			n++
+		}
+	}
+
+	// Purge the rows created before 2024-0600, over several batches
+	deleted, err := tbl.DeleteWhere(bufmgr, tbl.UniqueIndices[0], nil, [][]byte{[]byte("2024-0600")}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if deleted != 600 {
+		t.Errorf("expected 600 rows to be deleted, got %d", deleted)
+	}
+	if n := count(tbl.MetaPageID); n != numRows-600 {
+		t.Errorf("expected %d rows, got %d", numRows-600, n)
+	}
+	if n := count(tbl.UniqueIndices[0].MetaPageID); n != numRows-600 {
+		t.Errorf("expected %d index entries, got %d", numRows-600, n)
+	}
+	if _, err := tbl.Get(bufmgr, row(599)); err != btree.ErrKeyNotFound {
+		t.Errorf("expected row 599 to be deleted, got %v", err)
+	}
+	if _, err := tbl.Get(bufmgr, row(600)); err != nil {
+		t.Errorf("expected row 600 to remain, got %v", err)
+	}
+
+	// A predicate restricts the rows of the range that are deleted
+	even := func(tup [][]byte) bool {
+		return (tup[0][len(tup[0])-1]-'0')%2 == 0
+	}
+	deleted, err = tbl.DeleteWhere(bufmgr, tbl.UniqueIndices[0], [][]byte{[]byte("2024-0600")}, [][]byte{[]byte("2024-0700")}, even)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if deleted != 50 {
+		t.Errorf("expected 50 rows to be deleted, got %d", deleted)
+	}
+	if n := count(tbl.MetaPageID); n != numRows-650 {
+		t.Errorf("expected %d rows, got %d", numRows-650, n)
+	}
+	if got := tbl.Counters.Deletes.Load(); got != 650 {
+		t.Errorf("expected 650 deletes to be counted, got %d", got)
+	}
+}
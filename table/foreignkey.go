@@ -0,0 +1,222 @@
+package table
+
+import (
+	"bytes"
+	"fmt"
+
+	"github.com/Johniel/gorelly/btree"
+	"github.com/Johniel/gorelly/buffer"
+	"github.com/Johniel/gorelly/errcode"
+	"github.com/Johniel/gorelly/tuple"
+)
+
+// ErrForeignKeyViolation is wrapped by every ForeignKeyViolationError.
+var ErrForeignKeyViolation = errcode.New(errcode.ConstraintViolation, "foreign key violation")
+
+// ForeignKeyAction is what deleting a tuple, or changing its primary key, does to the tuples
+// referencing it.
+type ForeignKeyAction uint8
+
+const (
+	// ForeignKeyRestrict fails the delete or the key change with a ForeignKeyViolationError.
+	ForeignKeyRestrict ForeignKeyAction = iota
+	// ForeignKeyCascade deletes the referencing tuples as well, or changes their referencing
+	// elements to the new key.
+	ForeignKeyCascade
+)
+
+func (a ForeignKeyAction) String() string {
+	switch a {
+	case ForeignKeyRestrict:
+		return "RESTRICT"
+	case ForeignKeyCascade:
+		return "CASCADE"
+	default:
+		return "UNKNOWN"
+	}
+}
+
+// ForeignKey makes the tuples of a table reference tuples of Parent: the elements Columns of a
+// tuple, in the order of the primary key of Parent, must be the primary key of a tuple of Parent,
+// unless one of them is NULL. Insert, Update, Upsert, UpdateKey and InsertBatch check it.
+type ForeignKey struct {
+	Name    string // Name reported in a ForeignKeyViolationError
+	Columns []int  // Indices of the referencing elements
+	Parent  *Table
+}
+
+// Reference is a ForeignKey of Child seen from the table it references, so that deleting a tuple
+// or changing its primary key finds the tuples of Child referencing it through Index, and
+// applies OnDelete or OnUpdate to them.
+type Reference struct {
+	Name     string       // Name of the ForeignKey
+	Child    *Table       // Referencing table
+	Index    *UniqueIndex // Index of Child whose secondary key starts with the referencing elements
+	OnDelete ForeignKeyAction
+	OnUpdate ForeignKeyAction
+}
+
+// ForeignKeyViolationError is returned when a tuple references a primary key its parent table
+// does not hold, or when deleting a tuple, or changing its primary key, would leave tuples
+// referencing it. It wraps ErrForeignKeyViolation.
+type ForeignKeyViolationError struct {
+	ForeignKey string   // Name of the foreign key
+	Key        [][]byte // Referenced primary key
+	Referenced bool     // Whether the key is still referenced, rather than missing from the parent
+}
+
+func (e *ForeignKeyViolationError) Error() string {
+	if e.Referenced {
+		return fmt.Sprintf("key %q is still referenced through foreign key %q", e.Key, e.ForeignKey)
+	}
+	return fmt.Sprintf("key %q is not present in the table referenced by foreign key %q", e.Key, e.ForeignKey)
+}
+
+func (e *ForeignKeyViolationError) Unwrap() error {
+	return ErrForeignKeyViolation
+}
+
+// key returns the referencing elements of tup, or nil if one of them is NULL.
+func (fk *ForeignKey) key(tup [][]byte) [][]byte {
+	key := make([][]byte, len(fk.Columns))
+	for i, idx := range fk.Columns {
+		if len(tup[idx]) == 0 {
+			return nil
+		}
+		key[i] = tup[idx]
+	}
+	return key
+}
+
+// checkForeignKeys is the check phase of a write of tup replacing oldTuple (nil for an insert).
+// It returns a ForeignKeyViolationError if tup references a missing tuple through a foreign key
+// whose elements differ from those of oldTuple. A tuple may reference itself.
+func (t *Table) checkForeignKeys(bufmgr *buffer.BufferPoolManager, oldTuple [][]byte, tup [][]byte) error {
+	for _, fk := range t.ForeignKeys {
+		key := fk.key(tup)
+		if key == nil {
+			continue
+		}
+		keyBytes := make([]byte, 0)
+		tuple.Encode(key, &keyBytes)
+		if oldTuple != nil {
+			if oldKey := fk.key(oldTuple); oldKey != nil {
+				oldKeyBytes := make([]byte, 0)
+				tuple.Encode(oldKey, &oldKeyBytes)
+				if bytes.Equal(oldKeyBytes, keyBytes) {
+					continue
+				}
+			}
+		}
+		if fk.Parent.MetaPageID == t.MetaPageID {
+			ownKeyBytes := make([]byte, 0)
+			tuple.Encode(tup[:t.NumKeyElems], &ownKeyBytes)
+			if bytes.Equal(ownKeyBytes, keyBytes) {
+				continue
+			}
+		}
+		_, err := fk.Parent.fetchTuple(bufmgr, keyBytes)
+		if err == btree.ErrKeyNotFound {
+			return &ForeignKeyViolationError{ForeignKey: fk.Name, Key: key}
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// referencing returns the primary keys of the tuples of ref.Child that reference the encoded
+// primary key keyBytes. The iterator is closed before the tuples are changed.
+func (ref *Reference) referencing(bufmgr *buffer.BufferPoolManager, keyBytes []byte) ([][]byte, error) {
+	iter, err := btree.NewBTree(ref.Index.MetaPageID).Search(bufmgr, btree.NewSearchModeKey(keyBytes))
+	if err != nil {
+		return nil, err
+	}
+	defer iter.Close()
+	var pkeys [][]byte
+	for {
+		skeyBytes, pkeyBytes, ok, err := iter.Next(bufmgr)
+		if err != nil {
+			return nil, err
+		}
+		if !ok || !bytes.HasPrefix(skeyBytes, keyBytes) {
+			return pkeys, nil
+		}
+		pkeys = append(pkeys, pkeyBytes)
+	}
+}
+
+// deletedTuples is the set of tuples a delete removes, by table and encoded primary key.
+type deletedTuples map[*Table]map[string]bool
+
+func (d deletedTuples) add(t *Table, keyBytes []byte) bool {
+	if d[t] == nil {
+		d[t] = make(map[string]bool)
+	}
+	if d[t][string(keyBytes)] {
+		return false
+	}
+	d[t][string(keyBytes)] = true
+	return true
+}
+
+// checkReferences is the check phase of deleting the tuple stored under keyBytes. It returns a
+// ForeignKeyViolationError if a tuple referencing it through a ForeignKeyRestrict reference, or
+// referencing a tuple the cascades delete, would be left behind. Tuples referencing tuples being
+// deleted are not left behind; deleted collects them.
+func (t *Table) checkReferences(bufmgr *buffer.BufferPoolManager, keyBytes []byte, deleted deletedTuples) error {
+	if !deleted.add(t, keyBytes) {
+		return nil
+	}
+	for _, ref := range t.References {
+		pkeys, err := ref.referencing(bufmgr, keyBytes)
+		if err != nil {
+			return err
+		}
+		for _, pkeyBytes := range pkeys {
+			if deleted[ref.Child][string(pkeyBytes)] {
+				continue
+			}
+			if ref.OnDelete == ForeignKeyRestrict {
+				var key [][]byte
+				tuple.Decode(keyBytes, &key)
+				return &ForeignKeyViolationError{ForeignKey: ref.Name, Key: key, Referenced: true}
+			}
+			if err := ref.Child.checkReferences(bufmgr, pkeyBytes, deleted); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// cascade deletes the tuples referencing the tuple deleted from under keyBytes through
+// ForeignKeyCascade references, through the Log of t. checkReferences must have passed.
+func (t *Table) cascade(bufmgr *buffer.BufferPoolManager, keyBytes []byte) error {
+	for _, ref := range t.References {
+		if ref.OnDelete != ForeignKeyCascade {
+			continue
+		}
+		pkeys, err := ref.referencing(bufmgr, keyBytes)
+		if err != nil {
+			return err
+		}
+		child := *ref.Child
+		child.Log = t.Log
+		for _, pkeyBytes := range pkeys {
+			childTuple, err := child.fetchTuple(bufmgr, pkeyBytes)
+			if err == btree.ErrKeyNotFound {
+				// Deleted by an earlier cascade
+				continue
+			}
+			if err != nil {
+				return err
+			}
+			if err := child.removeTuple(bufmgr, child.primaryTree(), pkeyBytes, childTuple); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
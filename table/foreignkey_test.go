@@ -0,0 +1,153 @@
+package table
+
+import (
+	"errors"
+	"os"
+	"testing"
+
+	"github.com/Johniel/gorelly/btree"
+	"github.com/Johniel/gorelly/buffer"
+	"github.com/Johniel/gorelly/disk"
+)
+
+func TestTableForeignKeys(t *testing.T) {
+	tmpfile, err := os.CreateTemp("", "test_table_foreign_keys_*.db")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(tmpfile.Name())
+
+	dm, err := disk.NewDiskManager(tmpfile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer dm.Close()
+
+	pool := buffer.NewBufferPool(10)
+	bufmgr := buffer.NewBufferPoolManager(dm, pool)
+
+	// [id, manager_id] where manager_id references id, through an index on (manager_id, id)
+	newEmployees := func(action ForeignKeyAction) *Table {
+		t.Helper()
+		managerIndex := &UniqueIndex{Skey: []int{1, 0}}
+		tbl := &Table{NumKeyElems: 1, UniqueIndices: []*UniqueIndex{managerIndex}, Counters: &DMLCounters{}}
+		tbl.ForeignKeys = []*ForeignKey{{Name: "manager_fkey", Columns: []int{1}, Parent: tbl}}
+		tbl.References = []*Reference{{Name: "manager_fkey", Child: tbl, Index: managerIndex, OnDelete: action, OnUpdate: action}}
+		if err := tbl.Create(bufmgr); err != nil {
+			t.Fatal(err)
+		}
+		return tbl
+	}
+	row := func(id, managerID string) [][]byte {
+		return [][]byte{[]byte(id), []byte(managerID)}
+	}
+	exists := func(tbl *Table, id string) bool {
+		t.Helper()
+		_, err := tbl.Get(bufmgr, [][]byte{[]byte(id)})
+		if err != nil && err != btree.ErrKeyNotFound {
+			t.Fatal(err)
+		}
+		return err == nil
+	}
+
+	t.Run("Cascade", func(t *testing.T) {
+		employees := newEmployees(ForeignKeyCascade)
+		// Employee 1 is its own manager
+		for _, tup := range [][][]byte{row("1", "1"), row("2", "1"), row("3", "2"), row("4", "")} {
+			if err := employees.Insert(bufmgr, tup); err != nil {
+				t.Fatal(err)
+			}
+		}
+		var violation *ForeignKeyViolationError
+		if err := employees.Insert(bufmgr, row("5", "9")); !errors.As(err, &violation) || violation.Referenced || string(violation.Key[0]) != "9" {
+			t.Errorf("expected a violation of manager_fkey, got %v", err)
+		}
+		if errs := employees.InsertBatch(bufmgr, [][][]byte{row("5", "3"), row("6", "9")}); errs == nil || errs[0] != nil || !errors.Is(errs[1], ErrForeignKeyViolation) {
+			t.Errorf("expected only the second row to fail, got %v", errs)
+		}
+
+		if err := employees.Delete(bufmgr, [][]byte{[]byte("1")}); err != nil {
+			t.Fatal(err)
+		}
+		for _, id := range []string{"1", "2", "3", "5"} {
+			if exists(employees, id) {
+				t.Errorf("expected employee %s to be deleted", id)
+			}
+		}
+		if !exists(employees, "4") {
+			t.Error("expected employee 4, without manager, to be kept")
+		}
+		if deletes := employees.Counters.Deletes.Load(); deletes != 4 {
+			t.Errorf("expected 4 deletes, got %d", deletes)
+		}
+		if found, err := employees.VerifyIndexes(bufmgr); err != nil || len(found) != 0 {
+			t.Errorf("expected consistent indexes, got %v, %v", found, err)
+		}
+	})
+
+	t.Run("CascadeKeyChange", func(t *testing.T) {
+		employees := newEmployees(ForeignKeyCascade)
+		employees.Checks = []*Check{{Name: "manager_not_7", Holds: func(tup [][]byte) bool { return string(tup[1]) != "7" }}}
+		for _, tup := range [][][]byte{row("1", "1"), row("2", "1"), row("3", "2")} {
+			if err := employees.Insert(bufmgr, tup); err != nil {
+				t.Fatal(err)
+			}
+		}
+		manager := func(id string) string {
+			t.Helper()
+			tup, err := employees.Get(bufmgr, [][]byte{[]byte(id)})
+			if err != nil {
+				t.Fatalf("employee %s: %v", id, err)
+			}
+			return string(tup[1])
+		}
+
+		// Employee 1 manages itself and employee 2, which both follow it to key 5
+		if err := employees.UpdateKey(bufmgr, [][]byte{[]byte("1")}, row("5", "1")); err != nil {
+			t.Fatal(err)
+		}
+		if exists(employees, "1") || manager("5") != "5" || manager("2") != "5" || manager("3") != "2" {
+			t.Errorf("expected the references to employee 1 to follow it to 5")
+		}
+
+		// Moving employee 2 to 7 would make employee 3 fail the check: nothing changes
+		err := employees.UpdateKey(bufmgr, [][]byte{[]byte("2")}, row("7", "5"))
+		var violation *CheckViolationError
+		if !errors.As(err, &violation) || string(violation.Key[0]) != "3" {
+			t.Fatalf("expected employee 3 to fail the check, got %v", err)
+		}
+		if exists(employees, "7") || manager("2") != "5" || manager("3") != "2" {
+			t.Error("expected the failed key change to be undone")
+		}
+		if found, err := employees.VerifyIndexes(bufmgr); err != nil || len(found) != 0 {
+			t.Errorf("expected consistent indexes, got %v, %v", found, err)
+		}
+	})
+
+	t.Run("Restrict", func(t *testing.T) {
+		employees := newEmployees(ForeignKeyRestrict)
+		for _, tup := range [][][]byte{row("1", "1"), row("2", "1")} {
+			if err := employees.Insert(bufmgr, tup); err != nil {
+				t.Fatal(err)
+			}
+		}
+		err := employees.Delete(bufmgr, [][]byte{[]byte("1")})
+		var violation *ForeignKeyViolationError
+		if !errors.As(err, &violation) || !violation.Referenced || string(violation.Key[0]) != "1" {
+			t.Fatalf("expected employee 1 to be still referenced, got %v", err)
+		}
+		if !exists(employees, "1") || !exists(employees, "2") {
+			t.Error("expected the failed delete to change nothing")
+		}
+		if err := employees.UpdateKey(bufmgr, [][]byte{[]byte("1")}, row("3", "1")); !errors.As(err, &violation) || !violation.Referenced {
+			t.Errorf("expected changing the key of employee 1 to be restricted, got %v", err)
+		}
+		// A tuple referencing only itself can be deleted
+		if err := employees.Delete(bufmgr, [][]byte{[]byte("2")}); err != nil {
+			t.Fatal(err)
+		}
+		if err := employees.Delete(bufmgr, [][]byte{[]byte("1")}); err != nil {
+			t.Fatal(err)
+		}
+	})
+}
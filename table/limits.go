@@ -0,0 +1,61 @@
+package table
+
+import (
+	"fmt"
+	"math"
+
+	"github.com/Johniel/gorelly/btree"
+	"github.com/Johniel/gorelly/errcode"
+)
+
+var (
+	// ErrTooManyColumns is returned when a row has more than Limits.MaxColumns elements.
+	ErrTooManyColumns = errcode.New(errcode.LimitExceeded, "row has too many columns")
+	// ErrKeyTooLarge is returned when the encoded primary key of a row exceeds Limits.MaxKeySize.
+	ErrKeyTooLarge = errcode.New(errcode.LimitExceeded, "primary key too large")
+	// ErrRowTooLarge is returned when an encoded row exceeds Limits.MaxRowSize.
+	ErrRowTooLarge = errcode.New(errcode.LimitExceeded, "row too large")
+	// ErrIndexKeyTooLarge is returned when the encoded secondary key of a row exceeds the limits of a unique index.
+	ErrIndexKeyTooLarge = errcode.New(errcode.LimitExceeded, "secondary key too large")
+)
+
+// Limits describes the largest rows a table can store. Sizes are of the encoded key and value
+// (see tuple.Encode and Format), which are slightly larger than the raw column values.
+type Limits struct {
+	MaxColumns int // Maximum number of elements of a row
+	MaxKeySize int // Maximum size of the encoded primary key
+	MaxRowSize int // Maximum size of the encoded primary key and non-key columns together
+}
+
+// Limits returns the limits of the rows of the table.
+func (t *Table) Limits() Limits {
+	treeLimits := btree.NewBTree(t.MetaPageID).Limits()
+	return Limits{
+		MaxColumns: math.MaxUint16, // Column count of FormatV2
+		MaxKeySize: treeLimits.MaxKeySize,
+		MaxRowSize: treeLimits.MaxOverflowSize, // Values too large for a leaf go to overflow pages
+	}
+}
+
+// checkLimits returns a descriptive error wrapping ErrTooManyColumns, ErrKeyTooLarge,
+// ErrRowTooLarge or ErrIndexKeyTooLarge if the encoded row does not fit in the table.
+func (t *Table) checkLimits(tup [][]byte, keyBytes []byte, valueBytes []byte) error {
+	limits := t.Limits()
+	if limits.MaxColumns < len(tup) {
+		return fmt.Errorf("%w: %d columns, limit is %d", ErrTooManyColumns, len(tup), limits.MaxColumns)
+	}
+	if limits.MaxKeySize < len(keyBytes) {
+		return fmt.Errorf("%w: %d bytes encoded, limit is %d", ErrKeyTooLarge, len(keyBytes), limits.MaxKeySize)
+	}
+	if limits.MaxRowSize < len(keyBytes)+len(valueBytes) {
+		return fmt.Errorf("%w: %d bytes encoded, limit is %d", ErrRowTooLarge, len(keyBytes)+len(valueBytes), limits.MaxRowSize)
+	}
+	for _, uniqueIndex := range t.UniqueIndices {
+		skeyBytes := uniqueIndex.skeyBytes(tup)
+		indexLimits := btree.NewBTree(uniqueIndex.MetaPageID).Limits()
+		if indexLimits.MaxKeySize < len(skeyBytes) {
+			return fmt.Errorf("%w: %d bytes encoded, limit is %d", ErrIndexKeyTooLarge, len(skeyBytes), indexLimits.MaxKeySize)
+		}
+	}
+	return nil
+}
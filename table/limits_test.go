@@ -0,0 +1,97 @@
+package table
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/Johniel/gorelly/buffer"
+	"github.com/Johniel/gorelly/disk"
+	"github.com/Johniel/gorelly/tuple"
+)
+
+func TestTableLimits(t *testing.T) {
+	tmpfile, err := os.CreateTemp("", "test_table_limits_*.db")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(tmpfile.Name())
+
+	dm, err := disk.NewDiskManager(tmpfile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer dm.Close()
+
+	pool := buffer.NewBufferPool(10)
+	bufmgr := buffer.NewBufferPoolManager(dm, pool)
+
+	tbl := &Table{
+		MetaPageID:  disk.InvalidPageID,
+		NumKeyElems: 1,
+		Format:      tuple.FormatV2, // Compact values, so that a row can fit while its secondary key does not
+		UniqueIndices: []*UniqueIndex{
+			{
+				MetaPageID: disk.InvalidPageID,
+				Skey:       []int{1},
+			},
+		},
+	}
+	if err := tbl.Create(bufmgr); err != nil {
+		t.Fatal(err)
+	}
+	if err := tbl.Insert(bufmgr, [][]byte{[]byte("1"), []byte("a"), []byte("x")}); err != nil {
+		t.Fatal(err)
+	}
+	limits := tbl.Limits()
+
+	t.Run("KeyTooLarge", func(t *testing.T) {
+		key := bytes.Repeat([]byte("k"), limits.MaxKeySize)
+		err := tbl.Insert(bufmgr, [][]byte{key, []byte("b"), []byte("x")})
+		if !errors.Is(err, ErrKeyTooLarge) {
+			t.Fatalf("expected ErrKeyTooLarge, got %v", err)
+		}
+		if !strings.Contains(err.Error(), "limit is") {
+			t.Errorf("expected the sizes in the error, got %q", err)
+		}
+	})
+
+	t.Run("LargeRow", func(t *testing.T) {
+		// Rows too large for a leaf of the tree are stored in overflow pages
+		large := bytes.Repeat([]byte("v"), 3*disk.PageSize)
+		if err := tbl.Insert(bufmgr, [][]byte{[]byte("3"), []byte("c"), large}); err != nil {
+			t.Fatal(err)
+		}
+		tup, err := tbl.Get(bufmgr, [][]byte{[]byte("3")})
+		if err != nil || !bytes.Equal(tup[2], large) {
+			t.Errorf("expected the large row back, got %v", err)
+		}
+		if limits.MaxRowSize < len(large) {
+			t.Errorf("expected a row limit above %d bytes, got %d", len(large), limits.MaxRowSize)
+		}
+	})
+
+	t.Run("IndexKeyTooLarge", func(t *testing.T) {
+		// Secondary keys are memcomparable-encoded, which makes them larger than in the row
+		skey := bytes.Repeat([]byte("s"), (limits.MaxKeySize/9+1)*8)
+		if err := tbl.Insert(bufmgr, [][]byte{[]byte("2"), skey, nil}); !errors.Is(err, ErrIndexKeyTooLarge) {
+			t.Errorf("expected ErrIndexKeyTooLarge, got %v", err)
+		}
+	})
+
+	t.Run("Unchanged", func(t *testing.T) {
+		// None of the failed writes left anything behind
+		if _, err := tbl.Get(bufmgr, [][]byte{[]byte("2")}); err == nil {
+			t.Error("expected the rejected row not to be inserted")
+		}
+		tup, err := tbl.Get(bufmgr, [][]byte{[]byte("1")})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(tup[2]) != "x" {
+			t.Errorf("expected the original row, got %q", tup)
+		}
+	})
+}
@@ -0,0 +1,290 @@
+package table
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"hash"
+	"hash/crc32"
+	"io"
+	"sort"
+
+	"github.com/Johniel/gorelly/btree"
+	"github.com/Johniel/gorelly/buffer"
+	"github.com/Johniel/gorelly/disk"
+	"github.com/Johniel/gorelly/errcode"
+	"github.com/Johniel/gorelly/tuple"
+)
+
+var (
+	// ErrInvalidSnapshot is returned when loading data that is not a table snapshot of a supported version.
+	ErrInvalidSnapshot = errcode.New(errcode.DataException, "invalid table snapshot")
+	// ErrSnapshotCorrupted is returned when a table snapshot is truncated or fails its checksum.
+	ErrSnapshotCorrupted = errcode.New(errcode.Corruption, "table snapshot is corrupted")
+)
+
+const (
+	snapshotMagic   = "GRLYTSNP"
+	snapshotVersion = 2
+)
+
+// Snapshot writes a dump of the table to w: its definition (primary key length, row format, the
+// columns of its indexes and its ColumnDefs) followed by every row as its encoded key and value,
+// in key order, and a trailer with the row count and a CRC-32 of the whole dump. LoadSnapshot
+// recreates the table from it in another database.
+//
+// If the table has a LockScan hook, Snapshot first takes it over the whole table, so that no
+// other transaction inserts into the table while the dump is taken; the lock is held until the
+// transaction ends. The audit trail and the settings that are not part of the stored data
+// (Access, LockInsert, LockScan, Counters and the Tokenizer of text indexes) are not included.
+func (t *Table) Snapshot(bufmgr *buffer.BufferPoolManager, w io.Writer) error {
+	if t.LockScan != nil {
+		if err := t.LockScan(nil, nil); err != nil {
+			return err
+		}
+	}
+	bw := bufio.NewWriter(w)
+	crc := crc32.NewIEEE()
+	out := io.MultiWriter(bw, crc)
+
+	header := []byte(snapshotMagic)
+	header = append(header, snapshotVersion)
+	header = binary.AppendUvarint(header, uint64(t.NumKeyElems))
+	header = append(header, byte(t.Format))
+	header = binary.AppendUvarint(header, uint64(len(t.UniqueIndices)))
+	for _, uniqueIndex := range t.UniqueIndices {
+		header = binary.AppendUvarint(header, uint64(len(uniqueIndex.Skey)))
+		for _, column := range uniqueIndex.Skey {
+			header = binary.AppendUvarint(header, uint64(column))
+		}
+	}
+	header = binary.AppendUvarint(header, uint64(len(t.TextIndices)))
+	for _, textIndex := range t.TextIndices {
+		header = binary.AppendUvarint(header, uint64(textIndex.Column))
+	}
+	header = binary.AppendUvarint(header, uint64(len(t.ColumnDefs)))
+	for _, columnDef := range t.ColumnDefs {
+		header = binary.AppendUvarint(header, uint64(len(columnDef)))
+		header = append(header, columnDef...)
+	}
+	if _, err := out.Write(header); err != nil {
+		return err
+	}
+
+	iter, err := btree.NewBTree(t.MetaPageID).Search(bufmgr, btree.NewSearchModeStart())
+	if err != nil {
+		return err
+	}
+	defer iter.Close()
+	var numRows uint64
+	var record []byte
+	for {
+		key, value, ok, err := iter.Next(bufmgr)
+		if err != nil {
+			return err
+		}
+		if !ok {
+			break
+		}
+		// Key lengths are stored plus one, so that 0 marks the end of the rows
+		record = binary.AppendUvarint(record[:0], uint64(len(key))+1)
+		record = append(record, key...)
+		record = binary.AppendUvarint(record, uint64(len(value)))
+		record = append(record, value...)
+		if _, err := out.Write(record); err != nil {
+			return err
+		}
+		numRows++
+	}
+
+	trailer := binary.AppendUvarint([]byte{0}, numRows)
+	if _, err := out.Write(trailer); err != nil {
+		return err
+	}
+	if _, err := bw.Write(binary.BigEndian.AppendUint32(nil, crc.Sum32())); err != nil {
+		return err
+	}
+	return bw.Flush()
+}
+
+// LoadSnapshot creates a new table with the definition stored in a dump written by
+// Table.Snapshot and loads its rows with btree.BTree.Load, building the primary tree and the
+// indexes bottom-up from the sorted rows. Text indexes use the default WordTokenizer. Dumps of
+// version 1, which have no ColumnDefs, are accepted as well. It returns ErrInvalidSnapshot if r
+// does not hold a snapshot and ErrSnapshotCorrupted if the dump is damaged; the trees of the new
+// table are left as they were when the damage was found.
+func LoadSnapshot(bufmgr *buffer.BufferPoolManager, r io.Reader) (*Table, error) {
+	in := &snapshotReader{r: bufio.NewReader(r), crc: crc32.NewIEEE()}
+
+	magic := make([]byte, len(snapshotMagic)+1)
+	if _, err := io.ReadFull(in, magic); err != nil || string(magic[:len(snapshotMagic)]) != snapshotMagic {
+		return nil, ErrInvalidSnapshot
+	}
+	version := magic[len(snapshotMagic)]
+	if version != 1 && version != snapshotVersion {
+		return nil, ErrInvalidSnapshot
+	}
+	t := &Table{}
+	t.NumKeyElems = int(in.uvarint())
+	t.Format = tuple.Format(in.byte())
+	for i := in.uvarint(); 0 < i && in.err == nil; i-- {
+		uniqueIndex := &UniqueIndex{}
+		for j := in.uvarint(); 0 < j && in.err == nil; j-- {
+			uniqueIndex.Skey = append(uniqueIndex.Skey, int(in.uvarint()))
+		}
+		t.UniqueIndices = append(t.UniqueIndices, uniqueIndex)
+	}
+	for i := in.uvarint(); 0 < i && in.err == nil; i-- {
+		t.TextIndices = append(t.TextIndices, &TextIndex{Column: int(in.uvarint())})
+	}
+	if version != 1 {
+		for i := in.uvarint(); 0 < i && in.err == nil; i-- {
+			t.ColumnDefs = append(t.ColumnDefs, in.bytes(in.uvarint()))
+		}
+	}
+	if in.err != nil {
+		return nil, ErrSnapshotCorrupted
+	}
+	if err := t.Create(bufmgr); err != nil {
+		return nil, err
+	}
+
+	rows := &snapshotRows{in: in, t: t, indexPairs: make([][]sortedPair, len(t.UniqueIndices)), textPairs: make([][]sortedPair, len(t.TextIndices))}
+	if err := btree.NewBTree(t.MetaPageID).Load(bufmgr, rows); err != nil {
+		if in.err != nil || errors.Is(err, btree.ErrUnsortedInput) || errors.Is(err, btree.ErrDuplicateKey) {
+			return t, ErrSnapshotCorrupted
+		}
+		return t, err
+	}
+	if count := in.uvarint(); in.err != nil || count != rows.numRows {
+		return t, ErrSnapshotCorrupted
+	}
+	sum := in.crc.Sum32()
+	checksum := make([]byte, 4)
+	if _, err := io.ReadFull(in.r, checksum); err != nil || binary.BigEndian.Uint32(checksum) != sum {
+		return t, ErrSnapshotCorrupted
+	}
+
+	for i, uniqueIndex := range t.UniqueIndices {
+		if err := loadSorted(bufmgr, uniqueIndex.MetaPageID, rows.indexPairs[i]); err != nil {
+			if errors.Is(err, btree.ErrDuplicateKey) {
+				return t, ErrSnapshotCorrupted
+			}
+			return t, err
+		}
+	}
+	for i, textIndex := range t.TextIndices {
+		if err := loadSorted(bufmgr, textIndex.MetaPageID, rows.textPairs[i]); err != nil {
+			return t, err
+		}
+	}
+	return t, nil
+}
+
+// loadSorted sorts pairs by key and loads them into the empty B+ tree whose meta page is metaPageID.
+func loadSorted(bufmgr *buffer.BufferPoolManager, metaPageID disk.PageID, pairs []sortedPair) error {
+	sort.Slice(pairs, func(i, j int) bool { return bytes.Compare(pairs[i].key, pairs[j].key) < 0 })
+	return btree.NewBTree(metaPageID).Load(bufmgr, &sortedPairs{pairs: pairs})
+}
+
+// snapshotRows is a btree.PairIterator over the rows of a snapshot, which collects the entries
+// of the unique and text indexes of the table as it goes. A damaged row ends the iteration with
+// ErrSnapshotCorrupted, leaving the error in the reader.
+type snapshotRows struct {
+	in         *snapshotReader
+	t          *Table
+	indexPairs [][]sortedPair
+	textPairs  [][]sortedPair
+	numRows    uint64
+}
+
+func (sr *snapshotRows) Next(bufmgr *buffer.BufferPoolManager) ([]byte, []byte, bool, error) {
+	keyLen := sr.in.uvarint()
+	if sr.in.err != nil {
+		return nil, nil, false, ErrSnapshotCorrupted
+	}
+	if keyLen == 0 {
+		return nil, nil, false, nil
+	}
+	key := sr.in.bytes(keyLen - 1)
+	value := sr.in.bytes(sr.in.uvarint())
+	if sr.in.err != nil {
+		return nil, nil, false, ErrSnapshotCorrupted
+	}
+	if 0 < len(sr.indexPairs) || 0 < len(sr.textPairs) {
+		var tup [][]byte
+		tuple.Decode(key, &tup)
+		sr.t.Format.DecodeValue(value, &tup)
+		for i, uniqueIndex := range sr.t.UniqueIndices {
+			sr.indexPairs[i] = append(sr.indexPairs[i], sortedPair{key: uniqueIndex.skeyBytes(tup), value: key})
+		}
+		for i, textIndex := range sr.t.TextIndices {
+			for term, positions := range textIndex.positions(tup) {
+				sr.textPairs[i] = append(sr.textPairs[i], sortedPair{key: postingKey([]byte(term), key), value: encodePositions(positions)})
+			}
+		}
+	}
+	sr.numRows++
+	return key, value, true, nil
+}
+
+// snapshotReader reads a snapshot while computing its checksum. The first read error is kept
+// in err, after which every read returns zero values.
+type snapshotReader struct {
+	r   *bufio.Reader
+	crc hash.Hash32
+	err error
+}
+
+func (sr *snapshotReader) Read(p []byte) (int, error) {
+	n, err := sr.r.Read(p)
+	sr.crc.Write(p[:n])
+	return n, err
+}
+
+func (sr *snapshotReader) ReadByte() (byte, error) {
+	b, err := sr.r.ReadByte()
+	if err == nil {
+		sr.crc.Write([]byte{b})
+	}
+	return b, err
+}
+
+func (sr *snapshotReader) uvarint() uint64 {
+	if sr.err != nil {
+		return 0
+	}
+	v, err := binary.ReadUvarint(sr)
+	if err != nil {
+		sr.err = err
+	}
+	return v
+}
+
+func (sr *snapshotReader) byte() byte {
+	if sr.err != nil {
+		return 0
+	}
+	b, err := sr.ReadByte()
+	if err != nil {
+		sr.err = err
+	}
+	return b
+}
+
+func (sr *snapshotReader) bytes(n uint64) []byte {
+	if sr.err != nil {
+		return nil
+	}
+	if n > uint64(btree.NewBTree(0).Limits().MaxOverflowSize) {
+		sr.err = ErrSnapshotCorrupted
+		return nil
+	}
+	buf := make([]byte, n)
+	if _, err := io.ReadFull(sr, buf); err != nil {
+		sr.err = err
+		return nil
+	}
+	return buf
+}
@@ -0,0 +1,118 @@
+package table
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"reflect"
+	"testing"
+
+	"github.com/Johniel/gorelly/buffer"
+	"github.com/Johniel/gorelly/disk"
+	"github.com/Johniel/gorelly/tuple"
+)
+
+func TestTableSnapshot(t *testing.T) {
+	newBufmgr := func(t *testing.T) *buffer.BufferPoolManager {
+		tmpfile, err := os.CreateTemp("", "test_table_snapshot_*.db")
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { os.Remove(tmpfile.Name()) })
+		dm, err := disk.NewDiskManager(tmpfile)
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { dm.Close() })
+		return buffer.NewBufferPoolManager(dm, buffer.NewBufferPool(10))
+	}
+
+	srcBufmgr := newBufmgr(t)
+	src := &Table{
+		NumKeyElems:   1,
+		Format:        tuple.FormatV2,
+		UniqueIndices: []*UniqueIndex{{Skey: []int{1}}},
+		TextIndices:   []*TextIndex{{Column: 2}},
+		ColumnDefs:    [][]byte{[]byte("id"), []byte("email"), []byte("note")},
+	}
+	if err := src.Create(srcBufmgr); err != nil {
+		t.Fatal(err)
+	}
+	rows := make(map[string][][]byte)
+	for i := 0; i < 300; i++ {
+		row := [][]byte{
+			[]byte(fmt.Sprintf("%04d", (i*7)%300)),
+			[]byte(fmt.Sprintf("user%d@example.com", i)),
+			[]byte(fmt.Sprintf("note number %d", i)),
+		}
+		if err := src.Insert(srcBufmgr, row); err != nil {
+			t.Fatal(err)
+		}
+		rows[string(row[0])] = row
+	}
+
+	var locked [][]byte
+	src.LockScan = func(from []byte, to []byte) error {
+		locked = append(locked, from, to)
+		return nil
+	}
+	var dump bytes.Buffer
+	if err := src.Snapshot(srcBufmgr, &dump); err != nil {
+		t.Fatal(err)
+	}
+	if len(locked) != 2 || locked[0] != nil || locked[1] != nil {
+		t.Errorf("expected the whole table to be locked, got %q", locked)
+	}
+
+	t.Run("Load", func(t *testing.T) {
+		dstBufmgr := newBufmgr(t)
+		dst, err := LoadSnapshot(dstBufmgr, bytes.NewReader(dump.Bytes()))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if dst.NumKeyElems != 1 || dst.Format != tuple.FormatV2 || !reflect.DeepEqual(dst.UniqueIndices[0].Skey, []int{1}) || dst.TextIndices[0].Column != 2 {
+			t.Errorf("unexpected definition %+v", dst)
+		}
+		if !reflect.DeepEqual(dst.ColumnDefs, src.ColumnDefs) {
+			t.Errorf("expected column definitions %q, got %q", src.ColumnDefs, dst.ColumnDefs)
+		}
+		for key, row := range rows {
+			got, err := dst.Get(dstBufmgr, [][]byte{[]byte(key)})
+			if err != nil {
+				t.Fatalf("row %s: %v", key, err)
+			}
+			if !reflect.DeepEqual(got, row) {
+				t.Errorf("row %s: expected %q, got %q", key, row, got)
+			}
+		}
+		// The indexes were filled too
+		if err := dst.Insert(dstBufmgr, [][]byte{[]byte("9999"), []byte("user1@example.com"), nil}); err == nil {
+			t.Error("expected the unique index to reject a duplicate")
+		}
+		pkeys, err := dst.TextIndices[0].Search(dstBufmgr, []byte("number 42"), true)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(pkeys) != 1 {
+			t.Errorf("expected one text match, got %d", len(pkeys))
+		}
+	})
+
+	t.Run("NotASnapshot", func(t *testing.T) {
+		if _, err := LoadSnapshot(newBufmgr(t), bytes.NewReader([]byte("not a snapshot"))); err != ErrInvalidSnapshot {
+			t.Errorf("expected ErrInvalidSnapshot, got %v", err)
+		}
+	})
+
+	t.Run("Corrupted", func(t *testing.T) {
+		damaged := bytes.Clone(dump.Bytes())
+		damaged[len(damaged)/2] ^= 0xFF
+		if _, err := LoadSnapshot(newBufmgr(t), bytes.NewReader(damaged)); err == nil {
+			t.Error("expected a damaged snapshot to fail")
+		}
+		truncated := dump.Bytes()[:dump.Len()-10]
+		if _, err := LoadSnapshot(newBufmgr(t), bytes.NewReader(truncated)); err != ErrSnapshotCorrupted {
+			t.Errorf("expected ErrSnapshotCorrupted, got %v", err)
+		}
+	})
+}
@@ -3,6 +3,12 @@
 package table
 
 import (
+	"bytes"
+	"errors"
+	"slices"
+	"sort"
+	"sync/atomic"
+
 	"github.com/Johniel/gorelly/btree"
 	"github.com/Johniel/gorelly/buffer"
 	"github.com/Johniel/gorelly/disk"
@@ -12,8 +18,9 @@ import (
 // SimpleTable represents a simple table without secondary indexes.
 // Tuples are stored in a B+ tree with the first NumKeyElems elements as the primary key.
 type SimpleTable struct {
-	MetaPageID  disk.PageID // Page ID of the B+ tree meta page
-	NumKeyElems int         // Number of elements that form the primary key
+	MetaPageID  disk.PageID  // Page ID of the B+ tree meta page
+	NumKeyElems int          // Number of elements that form the primary key
+	Format      tuple.Format // Encoding of the non-key elements
 }
 
 func (st *SimpleTable) Create(bufmgr *buffer.BufferPoolManager) error {
@@ -30,10 +37,55 @@ func (st *SimpleTable) Insert(bufmgr *buffer.BufferPoolManager, tup [][]byte) er
 	keyBytes := make([]byte, 0)
 	tuple.Encode(tup[:st.NumKeyElems], &keyBytes)
 	valueBytes := make([]byte, 0)
-	tuple.Encode(tup[st.NumKeyElems:], &valueBytes)
+	st.Format.EncodeValue(tup[st.NumKeyElems:], &valueBytes)
 	return bt.Insert(bufmgr, keyBytes, valueBytes)
 }
 
+// Upsert inserts a tuple, or replaces the tuple with the same primary key if there is one
+// (see btree.BTree.Upsert).
+func (st *SimpleTable) Upsert(bufmgr *buffer.BufferPoolManager, tup [][]byte) error {
+	bt := btree.NewBTree(st.MetaPageID)
+	keyBytes := make([]byte, 0)
+	tuple.Encode(tup[:st.NumKeyElems], &keyBytes)
+	valueBytes := make([]byte, 0)
+	st.Format.EncodeValue(tup[st.NumKeyElems:], &valueBytes)
+	_, err := bt.Upsert(bufmgr, keyBytes, valueBytes)
+	return err
+}
+
+// BulkInsert inserts tuples into the table, which must be empty (btree.ErrTreeNotEmpty
+// otherwise). The tuples are sorted by primary key and loaded with btree.BTree.Load, which
+// builds the tree bottom-up instead of splitting its nodes over and over. It returns
+// btree.ErrDuplicateKey if two tuples have the same primary key, leaving the table empty.
+func (st *SimpleTable) BulkInsert(bufmgr *buffer.BufferPoolManager, tuples [][][]byte) error {
+	pairs := make([]sortedPair, len(tuples))
+	for i, tup := range tuples {
+		tuple.Encode(tup[:st.NumKeyElems], &pairs[i].key)
+		st.Format.EncodeValue(tup[st.NumKeyElems:], &pairs[i].value)
+	}
+	sort.Slice(pairs, func(i, j int) bool { return bytes.Compare(pairs[i].key, pairs[j].key) < 0 })
+	return btree.NewBTree(st.MetaPageID).Load(bufmgr, &sortedPairs{pairs: pairs})
+}
+
+type sortedPair struct {
+	key   []byte
+	value []byte
+}
+
+// sortedPairs is a btree.PairIterator over pairs sorted by key.
+type sortedPairs struct {
+	pairs []sortedPair
+}
+
+func (sp *sortedPairs) Next(bufmgr *buffer.BufferPoolManager) ([]byte, []byte, bool, error) {
+	if len(sp.pairs) == 0 {
+		return nil, nil, false, nil
+	}
+	pair := sp.pairs[0]
+	sp.pairs = sp.pairs[1:]
+	return pair.key, pair.value, true, nil
+}
+
 // Update updates an existing tuple in the table.
 // The tuple is identified by its primary key (first NumKeyElems elements).
 // Returns an error if the key is not found.
@@ -42,7 +94,7 @@ func (st *SimpleTable) Update(bufmgr *buffer.BufferPoolManager, tup [][]byte) er
 	keyBytes := make([]byte, 0)
 	tuple.Encode(tup[:st.NumKeyElems], &keyBytes)
 	valueBytes := make([]byte, 0)
-	tuple.Encode(tup[st.NumKeyElems:], &valueBytes)
+	st.Format.EncodeValue(tup[st.NumKeyElems:], &valueBytes)
 	return bt.Update(bufmgr, keyBytes, valueBytes)
 }
 
@@ -53,90 +105,746 @@ func (st *SimpleTable) Delete(bufmgr *buffer.BufferPoolManager, tup [][]byte) er
 	return bt.Delete(bufmgr, keyBytes)
 }
 
-// Table represents a table with support for unique secondary indexes.
-// Tuples are stored in a B+ tree, and additional B+ trees are maintained for each unique index.
+// DMLCounters counts the rows changed through a Table.
+// The counters only grow; consumers such as the vacuum scheduler remember the values they last saw.
+type DMLCounters struct {
+	Inserts atomic.Uint64
+	Updates atomic.Uint64
+	Deletes atomic.Uint64
+}
+
+// AccessOp is a kind of change checked by Table.Access.
+type AccessOp int
+
+const (
+	AccessInsert AccessOp = iota
+	AccessUpdate
+	AccessDelete
+)
+
+// Table represents a table with support for unique secondary indexes and text indexes.
+// Tuples are stored in a B+ tree, and additional B+ trees are maintained for each index.
 type Table struct {
-	MetaPageID    disk.PageID    // Page ID of the primary B+ tree meta page
-	NumKeyElems   int            // Number of elements that form the primary key
-	UniqueIndices []*UniqueIndex // List of unique secondary indexes
+	MetaPageID    disk.PageID                 // Page ID of the primary B+ tree meta page
+	NumKeyElems   int                         // Number of elements that form the primary key
+	Format        tuple.Format                // Encoding of the non-key elements
+	UniqueIndices []*UniqueIndex              // List of unique secondary indexes
+	TextIndices   []*TextIndex                // List of inverted text indexes
+	Audit         *AuditTrail                 // Optional audit trail recording every change
+	Counters      *DMLCounters                // Optional counters of changed rows
+	Access        func(op AccessOp) error     // Optional permission check run before every change
+	LockInsert    func(keyBytes []byte) error // Optional key-range lock taken before inserting a primary key
+	// Optional shared key-range lock over [from, to) of the primary tree (nil bounds for the
+	// whole tree), taken by readers that need a stable view of the table such as Snapshot.
+	LockScan func(from []byte, to []byte) error
+	// Optional log of the pages modified in the primary tree and the indexes, e.g. the
+	// transaction.PageLog of the transaction making the changes. The audit trail is not logged.
+	Log btree.PageLog
+	// Optional zone map of the primary tree, updated with every change to its leaf pages.
+	Zones *ZoneMap
+	// Optional store of the row versions replaced by updates, for readers with older snapshots.
+	Versions *VersionStore
+	// Optional encoded ID of the transaction making the changes (see
+	// transaction.TransactionID.Bytes). With Versions, the changes maintain the xmin and xmax
+	// columns of the tuples: Writer is stored as the xmin of the tuples written, and Delete marks
+	// tuples as deleted by storing it as their xmax rather than removing them. A tuple marked as
+	// deleted counts as missing for Update and Delete, and Insert replaces it.
+	Writer []byte
+	// Foreign keys of the table, checked by the changes of its tuples.
+	ForeignKeys []*ForeignKey
+	// Foreign keys of other tables (or this one) referencing the table, applied by its deletes.
+	References []*Reference
+	// Optional values of the trailing elements that the tuples given to Insert, InsertBatch and
+	// Upsert omit, one per element of a full tuple (nil is NULL).
+	Defaults [][]byte
+	// CHECK constraints of the table, evaluated by the changes of its tuples.
+	Checks []*Check
+	// Optional column definitions of the table as encoded by catalog.EncodeColumnDef, one per
+	// column, carried along in snapshots.
+	ColumnDefs [][]byte
 }
 
-func (t *Table) Create(bufmgr *buffer.BufferPoolManager) error {
-	bt, err := btree.CreateBTree(bufmgr)
+// tree returns the B+ tree whose meta page is metaPageID, logging its changes to log.
+func tree(metaPageID disk.PageID, log btree.PageLog) *btree.BTree {
+	bt := btree.NewBTree(metaPageID)
+	bt.Log = log
+	return bt
+}
+
+// primaryTree returns the primary B+ tree, passing its changes on to the Log and the Zones of the table.
+func (t *Table) primaryTree() *btree.BTree {
+	if t.Zones == nil {
+		return tree(t.MetaPageID, t.Log)
+	}
+	return tree(t.MetaPageID, &zoneLog{log: t.Log, zones: t.Zones})
+}
+
+// Create creates the trees of the table and its indexes, audit trail and version store,
+// logging their creation to the Log. If creating one fails, the trees created before it are
+// dropped again.
+func (t *Table) Create(bufmgr *buffer.BufferPoolManager) (err error) {
+	var created []disk.PageID
+	defer func() {
+		if err == nil {
+			return
+		}
+		for _, metaPageID := range created {
+			if _, dropErr := tree(metaPageID, t.Log).Drop(bufmgr); dropErr != nil {
+				err = errors.Join(err, dropErr)
+			}
+		}
+	}()
+	bt, err := btree.CreateBTreeWithLog(bufmgr, t.Log)
 	if err != nil {
 		return err
 	}
 	t.MetaPageID = bt.MetaPageID
+	created = append(created, t.MetaPageID)
 	for _, uniqueIndex := range t.UniqueIndices {
-		if err := uniqueIndex.Create(bufmgr); err != nil {
+		if err := uniqueIndex.CreateWithLog(bufmgr, t.Log); err != nil {
+			return err
+		}
+		created = append(created, uniqueIndex.MetaPageID)
+	}
+	for _, textIndex := range t.TextIndices {
+		if err := textIndex.create(bufmgr, t.Log); err != nil {
+			return err
+		}
+		created = append(created, textIndex.MetaPageID)
+	}
+	if t.Audit != nil {
+		if err := t.Audit.create(bufmgr, t.Log); err != nil {
+			return err
+		}
+		created = append(created, t.Audit.MetaPageID)
+	}
+	if t.Versions != nil {
+		if err := t.Versions.create(bufmgr, t.Log); err != nil {
 			return err
 		}
 	}
 	return nil
 }
 
+// Insert adds a tuple to the table and its indexes. A tuple shorter than Defaults is completed
+// with them. It returns btree.ErrDuplicateKey if the primary key is taken, a
+// ConstraintViolationError if the secondary key of a unique index is, a ForeignKeyViolationError
+// if a tuple it references is missing, and a CheckViolationError if it does not satisfy a Check;
+// all are detected before anything is written.
 func (t *Table) Insert(bufmgr *buffer.BufferPoolManager, tup [][]byte) error {
-	bt := btree.NewBTree(t.MetaPageID)
+	if err := t.checkAccess(AccessInsert); err != nil {
+		return err
+	}
+	tup = t.withDefaults(tup)
+	if t.versioned() {
+		tup = t.stamp(tup)
+	}
+	bt := t.primaryTree()
 	keyBytes := make([]byte, 0)
 	tuple.Encode(tup[:t.NumKeyElems], &keyBytes)
+	if t.LockInsert != nil {
+		if err := t.LockInsert(keyBytes); err != nil {
+			return err
+		}
+	}
+	if t.versioned() {
+		oldTuple, err := t.fetchTuple(bufmgr, keyBytes)
+		if err == nil && t.Versions.deleted(oldTuple) {
+			return t.update(bufmgr, tup, true)
+		}
+		if err != nil && err != btree.ErrKeyNotFound {
+			return err
+		}
+	}
 	valueBytes := make([]byte, 0)
-	tuple.Encode(tup[t.NumKeyElems:], &valueBytes)
+	t.Format.EncodeValue(tup[t.NumKeyElems:], &valueBytes)
+	if err := t.checkLimits(tup, keyBytes, valueBytes); err != nil {
+		return err
+	}
+
+	// Check phase: fail before any write if a check fails, a unique key is taken or a referenced
+	// tuple missing
+	if err := t.checkConstraints(tup); err != nil {
+		return err
+	}
+	if _, err := t.checkUniqueKeys(bufmgr, nil, tup); err != nil {
+		return err
+	}
+	if err := t.checkForeignKeys(bufmgr, nil, tup); err != nil {
+		return err
+	}
+
+	// Write phase
 	if err := bt.Insert(bufmgr, keyBytes, valueBytes); err != nil {
 		return err
 	}
-	for _, uniqueIndex := range t.UniqueIndices {
-		if err := uniqueIndex.Insert(bufmgr, keyBytes, tup); err != nil {
+	for i, uniqueIndex := range t.UniqueIndices {
+		if err := uniqueIndex.insert(bufmgr, t.Log, keyBytes, tup); err != nil {
+			// A key taken since the check phase
+			t.undoInsert(bufmgr, keyBytes, tup, i, 0)
+			return uniqueIndex.violation(tup, err)
+		}
+	}
+	for i, textIndex := range t.TextIndices {
+		if err := textIndex.insert(bufmgr, t.Log, keyBytes, tup); err != nil {
+			t.undoInsert(bufmgr, keyBytes, tup, len(t.UniqueIndices), i)
 			return err
 		}
 	}
+	if t.Counters != nil {
+		t.Counters.Inserts.Add(1)
+	}
+	if t.Audit != nil {
+		return t.Audit.Record(bufmgr, AuditOpInsert, nil, tup)
+	}
 	return nil
 }
 
+// Update replaces the tuple with the primary key of tup. It returns btree.ErrKeyNotFound if there
+// is none. The entries of the replaced tuple move in the unique and text indexes whose keys
+// change; a unique key already taken by another tuple is reported as a ConstraintViolationError
+// before anything is modified.
 func (t *Table) Update(bufmgr *buffer.BufferPoolManager, tup [][]byte) error {
-	bt := btree.NewBTree(t.MetaPageID)
+	if err := t.checkAccess(AccessUpdate); err != nil {
+		return err
+	}
+	if t.versioned() {
+		tup = t.stamp(tup)
+	}
+	return t.update(bufmgr, tup, false)
+}
+
+// update is Update past the access check. With revive, a tuple marked as deleted is replaced
+// like any other.
+func (t *Table) update(bufmgr *buffer.BufferPoolManager, tup [][]byte, revive bool) error {
+	bt := t.primaryTree()
 	keyBytes := make([]byte, 0)
 	tuple.Encode(tup[:t.NumKeyElems], &keyBytes)
 	valueBytes := make([]byte, 0)
-	tuple.Encode(tup[t.NumKeyElems:], &valueBytes)
-	return bt.Update(bufmgr, keyBytes, valueBytes)
+	t.Format.EncodeValue(tup[t.NumKeyElems:], &valueBytes)
+	if err := t.checkLimits(tup, keyBytes, valueBytes); err != nil {
+		return err
+	}
+	if err := t.checkConstraints(tup); err != nil {
+		return err
+	}
+	if len(t.UniqueIndices) == 0 && len(t.TextIndices) == 0 && t.Audit == nil && t.Versions == nil && len(t.ForeignKeys) == 0 {
+		if err := bt.Update(bufmgr, keyBytes, valueBytes); err != nil {
+			return err
+		}
+		if t.Counters != nil {
+			t.Counters.Updates.Add(1)
+		}
+		return nil
+	}
+
+	oldTuple, err := t.fetchTuple(bufmgr, keyBytes)
+	if err != nil {
+		return err
+	}
+	if !revive && t.versioned() && t.Versions.deleted(oldTuple) {
+		return btree.ErrKeyNotFound
+	}
+	changed, err := t.checkUniqueKeys(bufmgr, oldTuple, tup)
+	if err != nil {
+		return err
+	}
+	if err := t.checkForeignKeys(bufmgr, oldTuple, tup); err != nil {
+		return err
+	}
+	if t.Versions != nil {
+		// Stored first, so that readers find the old version once the new one is in place
+		if err := t.Versions.add(bufmgr, t.Log, oldTuple, tup); err != nil {
+			return err
+		}
+	}
+	if err := bt.Update(bufmgr, keyBytes, valueBytes); err != nil {
+		if t.Versions != nil {
+			t.Versions.remove(bufmgr, t.Log, oldTuple, tup)
+		}
+		return err
+	}
+	for _, uniqueIndex := range changed {
+		if err := uniqueIndex.delete(bufmgr, t.Log, oldTuple); err != nil {
+			return err
+		}
+		if err := uniqueIndex.insert(bufmgr, t.Log, keyBytes, tup); err != nil {
+			return err
+		}
+	}
+	for _, textIndex := range t.TextIndices {
+		if err := textIndex.delete(bufmgr, t.Log, keyBytes, oldTuple); err != nil {
+			return err
+		}
+		if err := textIndex.insert(bufmgr, t.Log, keyBytes, tup); err != nil {
+			return err
+		}
+	}
+	if t.Counters != nil {
+		t.Counters.Updates.Add(1)
+	}
+	if t.Audit != nil {
+		return t.Audit.Record(bufmgr, AuditOpUpdate, oldTuple, tup)
+	}
+	return nil
+}
+
+// Upsert inserts a tuple, or replaces the tuple with the same primary key if there is one.
+// A replaced tuple moves its entries in the unique and text indexes whose keys change. As with
+// UpdateKey, a unique key already taken by another tuple is detected before anything is
+// modified, and the table is left untouched.
+//
+// As with Insert, a tuple shorter than Defaults is completed with them, and it must satisfy the
+// checks of the table. A table without indexes, foreign keys, audit log, versions and access
+// check is changed in a single traversal of its tree; otherwise the old tuple is read first, to
+// update its index entries.
+func (t *Table) Upsert(bufmgr *buffer.BufferPoolManager, tup [][]byte) error {
+	tup = t.withDefaults(tup)
+	if t.versioned() {
+		tup = t.stamp(tup)
+	}
+	bt := t.primaryTree()
+	keyBytes := make([]byte, 0)
+	tuple.Encode(tup[:t.NumKeyElems], &keyBytes)
+	valueBytes := make([]byte, 0)
+	t.Format.EncodeValue(tup[t.NumKeyElems:], &valueBytes)
+	if len(t.UniqueIndices) == 0 && len(t.TextIndices) == 0 && t.Audit == nil && t.Versions == nil && t.Access == nil && len(t.ForeignKeys) == 0 {
+		if err := t.checkLimits(tup, keyBytes, valueBytes); err != nil {
+			return err
+		}
+		if err := t.checkConstraints(tup); err != nil {
+			return err
+		}
+		if t.LockInsert != nil {
+			if err := t.LockInsert(keyBytes); err != nil {
+				return err
+			}
+		}
+		inserted, err := bt.Upsert(bufmgr, keyBytes, valueBytes)
+		if err != nil {
+			return err
+		}
+		if t.Counters != nil {
+			if inserted {
+				t.Counters.Inserts.Add(1)
+			} else {
+				t.Counters.Updates.Add(1)
+			}
+		}
+		return nil
+	}
+
+	oldTuple, err := t.fetchTuple(bufmgr, keyBytes)
+	if err == btree.ErrKeyNotFound {
+		return t.Insert(bufmgr, tup)
+	}
+	if err != nil {
+		return err
+	}
+	if err := t.checkAccess(AccessUpdate); err != nil {
+		return err
+	}
+	if err := t.checkLimits(tup, keyBytes, valueBytes); err != nil {
+		return err
+	}
+
+	// Check phase: fail before any write if a check fails, a unique key is taken or a referenced
+	// tuple missing
+	if err := t.checkConstraints(tup); err != nil {
+		return err
+	}
+	changed, err := t.checkUniqueKeys(bufmgr, oldTuple, tup)
+	if err != nil {
+		return err
+	}
+	if err := t.checkForeignKeys(bufmgr, oldTuple, tup); err != nil {
+		return err
+	}
+
+	// Write phase
+	if t.Versions != nil {
+		if err := t.Versions.add(bufmgr, t.Log, oldTuple, tup); err != nil {
+			return err
+		}
+	}
+	if _, err := bt.Upsert(bufmgr, keyBytes, valueBytes); err != nil {
+		if t.Versions != nil {
+			t.Versions.remove(bufmgr, t.Log, oldTuple, tup)
+		}
+		return err
+	}
+	for _, uniqueIndex := range changed {
+		if err := uniqueIndex.delete(bufmgr, t.Log, oldTuple); err != nil {
+			return err
+		}
+		if err := uniqueIndex.insert(bufmgr, t.Log, keyBytes, tup); err != nil {
+			return err
+		}
+	}
+	for _, textIndex := range t.TextIndices {
+		if err := textIndex.delete(bufmgr, t.Log, keyBytes, oldTuple); err != nil {
+			return err
+		}
+		if err := textIndex.insert(bufmgr, t.Log, keyBytes, tup); err != nil {
+			return err
+		}
+	}
+	if t.Counters != nil {
+		t.Counters.Updates.Add(1)
+	}
+	if t.Audit != nil {
+		return t.Audit.Record(bufmgr, AuditOpUpdate, oldTuple, tup)
+	}
+	return nil
 }
 
 // Delete removes a tuple from the table and all associated secondary indexes.
 // The tuple is identified by its primary key (first NumKeyElems elements).
 // Returns an error if the key is not found.
 func (t *Table) Delete(bufmgr *buffer.BufferPoolManager, tup [][]byte) error {
+	if err := t.checkAccess(AccessDelete); err != nil {
+		return err
+	}
 	// First, fetch the old tuple to get the values for index deletion
-	bt := btree.NewBTree(t.MetaPageID)
+	bt := t.primaryTree()
 	keyBytes := make([]byte, 0)
 	tuple.Encode(tup[:t.NumKeyElems], &keyBytes)
 
 	// Search for the tuple to get the full tuple data
-	iter, err := bt.Search(bufmgr, btree.NewSearchModeKey(keyBytes))
+	fullTuple, err := t.fetchTuple(bufmgr, keyBytes)
 	if err != nil {
-		return btree.ErrKeyNotFound
+		return err
 	}
-	_, valueBytes, ok := iter.Get()
-	if !ok {
+	if t.versioned() && t.Versions.deleted(fullTuple) {
 		return btree.ErrKeyNotFound
 	}
+	return t.deleteTuple(bufmgr, bt, keyBytes, fullTuple)
+}
 
-	// Decode the full tuple
-	var fullTuple [][]byte
-	tuple.Decode(keyBytes, &fullTuple)
-	tuple.Decode(valueBytes, &fullTuple)
+// deleteTuple removes the tuple stored under keyBytes, whose content is fullTuple, from the
+// primary tree bt and all secondary indexes, with the tuples referencing it through
+// ForeignKeyCascade references. It fails before any write if tuples referencing it through
+// ForeignKeyRestrict references would be left behind.
+func (t *Table) deleteTuple(bufmgr *buffer.BufferPoolManager, bt *btree.BTree, keyBytes []byte, fullTuple [][]byte) error {
+	if 0 < len(t.References) {
+		if err := t.checkReferences(bufmgr, keyBytes, deletedTuples{}); err != nil {
+			return err
+		}
+	}
+	return t.removeTuple(bufmgr, bt, keyBytes, fullTuple)
+}
 
+// removeTuple is deleteTuple past the check phase.
+func (t *Table) removeTuple(bufmgr *buffer.BufferPoolManager, bt *btree.BTree, keyBytes []byte, fullTuple [][]byte) error {
+	if t.versioned() {
+		return t.markDeleted(bufmgr, bt, keyBytes, fullTuple)
+	}
 	// Delete from all secondary indexes
 	for _, uniqueIndex := range t.UniqueIndices {
-		if err := uniqueIndex.Delete(bufmgr, fullTuple); err != nil {
+		if err := uniqueIndex.delete(bufmgr, t.Log, fullTuple); err != nil {
 			// If index entry doesn't exist, continue (it might have been deleted already)
 			if err != btree.ErrKeyNotFound {
 				return err
 			}
 		}
 	}
+	for _, textIndex := range t.TextIndices {
+		if err := textIndex.delete(bufmgr, t.Log, keyBytes, fullTuple); err != nil {
+			if err != btree.ErrKeyNotFound {
+				return err
+			}
+		}
+	}
 
 	// Delete from the primary table
-	return bt.Delete(bufmgr, keyBytes)
+	if err := bt.Delete(bufmgr, keyBytes); err != nil {
+		return err
+	}
+	if err := t.cascade(bufmgr, keyBytes); err != nil {
+		return err
+	}
+	if t.Counters != nil {
+		t.Counters.Deletes.Add(1)
+	}
+	if t.Audit != nil {
+		return t.Audit.Record(bufmgr, AuditOpDelete, fullTuple, nil)
+	}
+	return nil
+}
+
+// UpdateKey replaces the tuple stored under oldKey with newTuple, whose primary key may differ.
+// The primary tree, unique indexes and text indexes are all moved to the new key.
+// Constraint violations (a missing old key, a new primary or unique key that is already taken, a
+// missing referenced tuple, an old key still referenced through a ForeignKeyRestrict reference,
+// or a failed check) are detected before anything is modified, so the table is left untouched
+// when they occur.
+//
+// The tuples referencing the old key through ForeignKeyCascade references are changed to
+// reference the new key, in the same way, which cascades further if their own primary keys
+// change. If a change fails, those already made are undone, through the Log of t.
+//
+// The primary key of a tuple whose versions the table maintains (see Writer) cannot change
+// (ErrVersionedKeyChange).
+func (t *Table) UpdateKey(bufmgr *buffer.BufferPoolManager, oldKey [][]byte, newTuple [][]byte) error {
+	if err := t.checkAccess(AccessUpdate); err != nil {
+		return err
+	}
+	oldKeyBytes := make([]byte, 0)
+	tuple.Encode(oldKey, &oldKeyBytes)
+	newKeyBytes := make([]byte, 0)
+	tuple.Encode(newTuple[:t.NumKeyElems], &newKeyBytes)
+
+	oldTuple, err := t.fetchTuple(bufmgr, oldKeyBytes)
+	if err != nil {
+		return err
+	}
+	if bytes.Equal(oldKeyBytes, newKeyBytes) {
+		return t.Update(bufmgr, newTuple)
+	}
+	if t.versioned() {
+		return ErrVersionedKeyChange
+	}
+	if t.LockInsert != nil {
+		if err := t.LockInsert(newKeyBytes); err != nil {
+			return err
+		}
+	}
+
+	var changes []tupleChange
+	if err := t.changeKey(bufmgr, oldTuple, newTuple, &changes); err != nil {
+		for i := len(changes) - 1; 0 <= i; i-- {
+			c := changes[i]
+			c.table.replaceTuple(bufmgr, c.newTuple, c.oldTuple)
+		}
+		return err
+	}
+	for _, c := range changes {
+		if c.table.Counters != nil {
+			if c.keyChanged() {
+				// The old key leaves a dead entry behind, like a delete
+				c.table.Counters.Deletes.Add(1)
+				c.table.Counters.Inserts.Add(1)
+			} else {
+				c.table.Counters.Updates.Add(1)
+			}
+		}
+		if c.table.Audit != nil {
+			if err := c.table.Audit.Record(bufmgr, AuditOpUpdate, c.oldTuple, c.newTuple); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// tupleChange is a tuple replaced by UpdateKey, in its own table or in a referencing one.
+type tupleChange struct {
+	table    *Table
+	oldTuple [][]byte
+	newTuple [][]byte
+}
+
+func (c *tupleChange) keyChanged() bool {
+	n := c.table.NumKeyElems
+	return !slices.EqualFunc(c.oldTuple[:n], c.newTuple[:n], bytes.Equal)
+}
+
+// changeKey checks and makes the replacement of oldTuple with newTuple, then cascades it to the
+// tuples referencing the old key. The replacements made are appended to changes, for UpdateKey
+// to undo them if a later one fails.
+func (t *Table) changeKey(bufmgr *buffer.BufferPoolManager, oldTuple [][]byte, newTuple [][]byte, changes *[]tupleChange) error {
+	oldKeyBytes := make([]byte, 0)
+	tuple.Encode(oldTuple[:t.NumKeyElems], &oldKeyBytes)
+	newKeyBytes := make([]byte, 0)
+	tuple.Encode(newTuple[:t.NumKeyElems], &newKeyBytes)
+	keyChanged := !bytes.Equal(oldKeyBytes, newKeyBytes)
+
+	// Check phase: fail before any write if a constraint would be violated
+	if keyChanged {
+		if _, err := t.fetchTuple(bufmgr, newKeyBytes); err == nil {
+			return btree.ErrDuplicateKey
+		}
+	}
+	if err := t.checkConstraints(newTuple); err != nil {
+		return err
+	}
+	if _, err := t.checkUniqueKeys(bufmgr, oldTuple, newTuple); err != nil {
+		return err
+	}
+	if err := t.checkForeignKeys(bufmgr, oldTuple, newTuple); err != nil {
+		return err
+	}
+	var cascades []*Reference
+	if keyChanged {
+		for _, ref := range t.References {
+			pkeys, err := ref.referencing(bufmgr, oldKeyBytes)
+			if err != nil {
+				return err
+			}
+			for _, pkeyBytes := range pkeys {
+				if ref.OnUpdate == ForeignKeyCascade {
+					cascades = append(cascades, ref)
+					break
+				}
+				// Changing a referenced primary key would leave the references dangling
+				if ref.Child.MetaPageID != t.MetaPageID || !bytes.Equal(pkeyBytes, oldKeyBytes) {
+					return &ForeignKeyViolationError{ForeignKey: ref.Name, Key: oldTuple[:t.NumKeyElems], Referenced: true}
+				}
+			}
+		}
+	}
+	valueBytes := make([]byte, 0)
+	t.Format.EncodeValue(newTuple[t.NumKeyElems:], &valueBytes)
+	if err := t.checkLimits(newTuple, newKeyBytes, valueBytes); err != nil {
+		return err
+	}
+
+	// Write phase
+	if err := t.replaceTuple(bufmgr, oldTuple, newTuple); err != nil {
+		return err
+	}
+	*changes = append(*changes, tupleChange{table: t, oldTuple: oldTuple, newTuple: newTuple})
+	for _, ref := range cascades {
+		pkeys, err := ref.referencing(bufmgr, oldKeyBytes)
+		if err != nil {
+			return err
+		}
+		child := *ref.Child
+		child.Log = t.Log
+		for _, pkeyBytes := range pkeys {
+			childTuple, err := child.fetchTuple(bufmgr, pkeyBytes)
+			if err != nil {
+				return err
+			}
+			newChildTuple := slices.Clone(childTuple)
+			for i, idx := range ref.Index.Skey[:t.NumKeyElems] {
+				newChildTuple[idx] = newTuple[i]
+			}
+			if err := child.changeKey(bufmgr, childTuple, newChildTuple, changes); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// replaceTuple writes newTuple in place of oldTuple in the primary tree and the indexes, without
+// checking constraints. If a step fails, the steps already made are undone, so that the table
+// keeps oldTuple.
+func (t *Table) replaceTuple(bufmgr *buffer.BufferPoolManager, oldTuple [][]byte, newTuple [][]byte) error {
+	bt := t.primaryTree()
+	oldKeyBytes := make([]byte, 0)
+	tuple.Encode(oldTuple[:t.NumKeyElems], &oldKeyBytes)
+	newKeyBytes := make([]byte, 0)
+	tuple.Encode(newTuple[:t.NumKeyElems], &newKeyBytes)
+	oldValueBytes := make([]byte, 0)
+	t.Format.EncodeValue(oldTuple[t.NumKeyElems:], &oldValueBytes)
+	newValueBytes := make([]byte, 0)
+	t.Format.EncodeValue(newTuple[t.NumKeyElems:], &newValueBytes)
+	keyChanged := !bytes.Equal(oldKeyBytes, newKeyBytes)
+
+	var undo []func()
+	fail := func(err error) error {
+		for i := len(undo) - 1; 0 <= i; i-- {
+			undo[i]()
+		}
+		return err
+	}
+	if keyChanged {
+		// Inserted first, so that a failure leaves the old tuple in place
+		if err := bt.Insert(bufmgr, newKeyBytes, newValueBytes); err != nil {
+			return err
+		}
+		undo = append(undo, func() { bt.Delete(bufmgr, newKeyBytes) })
+		if err := bt.Delete(bufmgr, oldKeyBytes); err != nil {
+			return fail(err)
+		}
+		undo = append(undo, func() { bt.Insert(bufmgr, oldKeyBytes, oldValueBytes) })
+	} else {
+		if err := bt.Update(bufmgr, newKeyBytes, newValueBytes); err != nil {
+			return err
+		}
+		undo = append(undo, func() { bt.Update(bufmgr, oldKeyBytes, oldValueBytes) })
+	}
+	for _, uniqueIndex := range t.UniqueIndices {
+		// Entries hold the primary key, so they all move with it
+		if !keyChanged && bytes.Equal(uniqueIndex.skeyBytes(oldTuple), uniqueIndex.skeyBytes(newTuple)) {
+			continue
+		}
+		if err := uniqueIndex.delete(bufmgr, t.Log, oldTuple); err != nil {
+			return fail(err)
+		}
+		undo = append(undo, func() { uniqueIndex.insert(bufmgr, t.Log, oldKeyBytes, oldTuple) })
+		if err := uniqueIndex.insert(bufmgr, t.Log, newKeyBytes, newTuple); err != nil {
+			return fail(uniqueIndex.violation(newTuple, err))
+		}
+		undo = append(undo, func() { uniqueIndex.delete(bufmgr, t.Log, newTuple) })
+	}
+	for _, textIndex := range t.TextIndices {
+		if err := textIndex.delete(bufmgr, t.Log, oldKeyBytes, oldTuple); err != nil {
+			return fail(err)
+		}
+		undo = append(undo, func() { textIndex.insert(bufmgr, t.Log, oldKeyBytes, oldTuple) })
+		if err := textIndex.insert(bufmgr, t.Log, newKeyBytes, newTuple); err != nil {
+			return fail(err)
+		}
+		undo = append(undo, func() { textIndex.delete(bufmgr, t.Log, newKeyBytes, newTuple) })
+	}
+	return nil
+}
+
+// undoInsert removes a partially inserted tuple: the primary row and the entries of the first
+// numUnique unique indexes and numText text indexes, so that a failed Insert leaves the table unchanged.
+func (t *Table) undoInsert(bufmgr *buffer.BufferPoolManager, keyBytes []byte, tup [][]byte, numUnique int, numText int) {
+	for _, textIndex := range t.TextIndices[:numText] {
+		textIndex.delete(bufmgr, t.Log, keyBytes, tup)
+	}
+	for _, uniqueIndex := range t.UniqueIndices[:numUnique] {
+		uniqueIndex.delete(bufmgr, t.Log, tup)
+	}
+	t.primaryTree().Delete(bufmgr, keyBytes)
+}
+
+// Get returns the full tuple whose primary key is key (the first NumKeyElems elements).
+// It returns btree.ErrKeyNotFound if there is no such tuple.
+func (t *Table) Get(bufmgr *buffer.BufferPoolManager, key [][]byte) ([][]byte, error) {
+	keyBytes := make([]byte, 0)
+	tuple.Encode(key[:t.NumKeyElems], &keyBytes)
+	return t.fetchTuple(bufmgr, keyBytes)
+}
+
+// EstimateRange returns the approximate number of rows whose primary key is at least lo and
+// below hi (see btree.BTree.EstimateRange). The bounds may hold fewer elements than the primary
+// key and are compared as encoded prefixes; a nil bound leaves that side of the range open.
+func (t *Table) EstimateRange(bufmgr *buffer.BufferPoolManager, lo [][]byte, hi [][]byte) (uint64, error) {
+	var loBytes, hiBytes []byte
+	if lo != nil {
+		tuple.Encode(lo, &loBytes)
+	}
+	if hi != nil {
+		tuple.Encode(hi, &hiBytes)
+	}
+	return btree.NewBTree(t.MetaPageID).EstimateRange(bufmgr, loBytes, hiBytes)
+}
+
+func (t *Table) checkAccess(op AccessOp) error {
+	if t.Access == nil {
+		return nil
+	}
+	return t.Access(op)
+}
+
+// fetchTuple returns the full tuple stored under the encoded primary key.
+// It returns btree.ErrKeyNotFound if no tuple has exactly that key.
+func (t *Table) fetchTuple(bufmgr *buffer.BufferPoolManager, keyBytes []byte) ([][]byte, error) {
+	valueBytes, err := btree.NewBTree(t.MetaPageID).Get(bufmgr, keyBytes)
+	if err != nil {
+		return nil, err
+	}
+	var fullTuple [][]byte
+	tuple.Decode(keyBytes, &fullTuple)
+	t.Format.DecodeValue(valueBytes, &fullTuple)
+	return fullTuple, nil
 }
 
 // UniqueIndex represents a unique secondary index on a table.
@@ -144,10 +852,16 @@ func (t *Table) Delete(bufmgr *buffer.BufferPoolManager, tup [][]byte) error {
 type UniqueIndex struct {
 	MetaPageID disk.PageID // Page ID of the B+ tree meta page for this index
 	Skey       []int       // Indices of tuple elements that form the secondary key
+	Name       string      // Name reported in a ConstraintViolationError; optional
 }
 
 func (ui *UniqueIndex) Create(bufmgr *buffer.BufferPoolManager) error {
-	bt, err := btree.CreateBTree(bufmgr)
+	return ui.CreateWithLog(bufmgr, nil)
+}
+
+// CreateWithLog creates the tree of the index, logging its creation to log (see btree.CreateBTreeWithLog).
+func (ui *UniqueIndex) CreateWithLog(bufmgr *buffer.BufferPoolManager, log btree.PageLog) error {
+	bt, err := btree.CreateBTreeWithLog(bufmgr, log)
 	if err != nil {
 		return err
 	}
@@ -156,25 +870,44 @@ func (ui *UniqueIndex) Create(bufmgr *buffer.BufferPoolManager) error {
 }
 
 func (ui *UniqueIndex) Insert(bufmgr *buffer.BufferPoolManager, pkey []byte, tup [][]byte) error {
-	bt := btree.NewBTree(ui.MetaPageID)
-	skeyBytes := make([]byte, 0)
-	skeyElems := make([][]byte, len(ui.Skey))
-	for i, idx := range ui.Skey {
-		skeyElems[i] = tup[idx]
-	}
-	tuple.Encode(skeyElems, &skeyBytes)
-	return bt.Insert(bufmgr, skeyBytes, pkey)
+	return ui.insert(bufmgr, nil, pkey, tup)
+}
+
+// InsertWithLog is Insert logging the changes to log, e.g. to build the index in a transaction.
+func (ui *UniqueIndex) InsertWithLog(bufmgr *buffer.BufferPoolManager, log btree.PageLog, pkey []byte, tup [][]byte) error {
+	return ui.insert(bufmgr, log, pkey, tup)
+}
+
+func (ui *UniqueIndex) insert(bufmgr *buffer.BufferPoolManager, log btree.PageLog, pkey []byte, tup [][]byte) error {
+	return tree(ui.MetaPageID, log).Insert(bufmgr, ui.skeyBytes(tup), pkey)
 }
 
 // Delete removes an index entry for the given tuple.
 // It constructs the secondary key from the tuple and removes the corresponding entry.
 func (ui *UniqueIndex) Delete(bufmgr *buffer.BufferPoolManager, tup [][]byte) error {
-	bt := btree.NewBTree(ui.MetaPageID)
+	return ui.delete(bufmgr, nil, tup)
+}
+
+func (ui *UniqueIndex) delete(bufmgr *buffer.BufferPoolManager, log btree.PageLog, tup [][]byte) error {
+	return tree(ui.MetaPageID, log).Delete(bufmgr, ui.skeyBytes(tup))
+}
+
+// skeyBytes encodes the secondary key of the given tuple.
+func (ui *UniqueIndex) skeyBytes(tup [][]byte) []byte {
 	skeyBytes := make([]byte, 0)
 	skeyElems := make([][]byte, len(ui.Skey))
 	for i, idx := range ui.Skey {
 		skeyElems[i] = tup[idx]
 	}
 	tuple.Encode(skeyElems, &skeyBytes)
-	return bt.Delete(bufmgr, skeyBytes)
+	return skeyBytes
+}
+
+// contains reports whether an entry with exactly the given encoded secondary key exists.
+func (ui *UniqueIndex) contains(bufmgr *buffer.BufferPoolManager, skeyBytes []byte) (bool, error) {
+	_, err := btree.NewBTree(ui.MetaPageID).Get(bufmgr, skeyBytes)
+	if err == btree.ErrKeyNotFound {
+		return false, nil
+	}
+	return err == nil, err
 }
@@ -1,6 +1,8 @@
 package table
 
 import (
+	"errors"
+	"fmt"
 	"os"
 	"reflect"
 	"testing"
@@ -142,6 +144,167 @@ func TestSimpleTableUpdate(t *testing.T) {
 	})
 }
 
+func TestSimpleTableBulkInsert(t *testing.T) {
+	tmpfile, err := os.CreateTemp("", "test_simple_table_bulk_insert_*.db")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(tmpfile.Name())
+
+	dm, err := disk.NewDiskManager(tmpfile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer dm.Close()
+
+	pool := buffer.NewBufferPool(10)
+	bufmgr := buffer.NewBufferPoolManager(dm, pool)
+
+	simpleTable := &SimpleTable{
+		MetaPageID:  disk.InvalidPageID,
+		NumKeyElems: 1,
+		Format:      tuple.FormatV2,
+	}
+	if err := simpleTable.Create(bufmgr); err != nil {
+		t.Fatal(err)
+	}
+
+	// Tuples in no particular order: [id, name]
+	var tuples [][][]byte
+	for _, i := range []int{5, 2, 9, 0, 7, 1, 8, 3, 6, 4} {
+		for j := 0; j < 100; j++ {
+			id := fmt.Sprintf("%04d", j*10+i)
+			tuples = append(tuples, [][]byte{[]byte(id), []byte("name" + id)})
+		}
+	}
+	if err := simpleTable.BulkInsert(bufmgr, tuples[:1]); err != nil {
+		t.Fatal(err)
+	}
+	if err := simpleTable.BulkInsert(bufmgr, tuples); !errors.Is(err, btree.ErrTreeNotEmpty) {
+		t.Fatalf("expected ErrTreeNotEmpty, got %v", err)
+	}
+	if err := simpleTable.Delete(bufmgr, tuples[0]); err != nil {
+		t.Fatal(err)
+	}
+	if err := simpleTable.BulkInsert(bufmgr, tuples); err != nil {
+		t.Fatal(err)
+	}
+
+	iter, err := btree.NewBTree(simpleTable.MetaPageID).Search(bufmgr, btree.NewSearchModeStart())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer iter.Close()
+	for i := 0; i < len(tuples); i++ {
+		keyBytes, valueBytes, ok, err := iter.Next(bufmgr)
+		if err != nil || !ok {
+			t.Fatalf("expected tuple %d, got %v, %v", i, ok, err)
+		}
+		var tup [][]byte
+		tuple.Decode(keyBytes, &tup)
+		simpleTable.Format.DecodeValue(valueBytes, &tup)
+		id := fmt.Sprintf("%04d", i)
+		if expected := [][]byte{[]byte(id), []byte("name" + id)}; !reflect.DeepEqual(tup, expected) {
+			t.Fatalf("expected %q, got %q", expected, tup)
+		}
+	}
+	if _, _, ok, err := iter.Next(bufmgr); ok || err != nil {
+		t.Errorf("expected the end of the table, got %v, %v", ok, err)
+	}
+}
+
+func TestTableUpsert(t *testing.T) {
+	tmpfile, err := os.CreateTemp("", "test_table_upsert_*.db")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(tmpfile.Name())
+
+	dm, err := disk.NewDiskManager(tmpfile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer dm.Close()
+
+	pool := buffer.NewBufferPool(10)
+	bufmgr := buffer.NewBufferPoolManager(dm, pool)
+
+	// [id, name, age] with a unique index on name
+	tbl := &Table{
+		MetaPageID:    disk.InvalidPageID,
+		NumKeyElems:   1,
+		UniqueIndices: []*UniqueIndex{{MetaPageID: disk.InvalidPageID, Skey: []int{1}}},
+		Counters:      &DMLCounters{},
+	}
+	if err := tbl.Create(bufmgr); err != nil {
+		t.Fatal(err)
+	}
+	row := func(id, name, age string) [][]byte {
+		return [][]byte{[]byte(id), []byte(name), []byte(age)}
+	}
+	get := func(id string) [][]byte {
+		t.Helper()
+		tup, err := tbl.Get(bufmgr, [][]byte{[]byte(id)})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return tup
+	}
+	indexed := func(name string) bool {
+		t.Helper()
+		var skey []byte
+		tuple.Encode([][]byte{[]byte(name)}, &skey)
+		exists, err := tbl.UniqueIndices[0].contains(bufmgr, skey)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return exists
+	}
+
+	for _, tup := range [][][]byte{row("1", "Alice", "30"), row("2", "Bob", "40"), row("1", "Carol", "31")} {
+		if err := tbl.Upsert(bufmgr, tup); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if tup := get("1"); !reflect.DeepEqual(tup, row("1", "Carol", "31")) {
+		t.Errorf("expected the row to be replaced, got %q", tup)
+	}
+	if indexed("Alice") || !indexed("Carol") || !indexed("Bob") {
+		t.Error("expected the index entry of Alice to be replaced by Carol")
+	}
+	if inserts, updates := tbl.Counters.Inserts.Load(), tbl.Counters.Updates.Load(); inserts != 2 || updates != 1 {
+		t.Errorf("expected 2 inserts and 1 update, got %d and %d", inserts, updates)
+	}
+
+	// A unique key taken by another row leaves the table untouched
+	if err := tbl.Upsert(bufmgr, row("2", "Carol", "41")); !errors.Is(err, btree.ErrDuplicateKey) {
+		t.Errorf("expected ErrDuplicateKey, got %v", err)
+	}
+	if tup := get("2"); !reflect.DeepEqual(tup, row("2", "Bob", "40")) {
+		t.Errorf("expected the row to be kept, got %q", tup)
+	}
+	if discrepancies, err := tbl.VerifyIndexes(bufmgr); err != nil || len(discrepancies) != 0 {
+		t.Errorf("expected consistent indexes, got %v, %v", discrepancies, err)
+	}
+
+	// Without indexes the tree is changed in a single traversal
+	plain := &Table{MetaPageID: disk.InvalidPageID, NumKeyElems: 1, Counters: &DMLCounters{}}
+	if err := plain.Create(bufmgr); err != nil {
+		t.Fatal(err)
+	}
+	for _, tup := range [][][]byte{row("1", "Alice", "30"), row("1", "Alice", "31")} {
+		if err := plain.Upsert(bufmgr, tup); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if tup, err := plain.Get(bufmgr, [][]byte{[]byte("1")}); err != nil || !reflect.DeepEqual(tup, row("1", "Alice", "31")) {
+		t.Errorf("expected the replaced row, got %q, %v", tup, err)
+	}
+	if inserts, updates := plain.Counters.Inserts.Load(), plain.Counters.Updates.Load(); inserts != 1 || updates != 1 {
+		t.Errorf("expected 1 insert and 1 update, got %d and %d", inserts, updates)
+	}
+}
+
 func TestTableUpdate(t *testing.T) {
 	tmpfile, err := os.CreateTemp("", "test_table_update_*.db")
 	if err != nil {
@@ -196,11 +359,28 @@ func TestTableUpdate(t *testing.T) {
 		if err := tbl.Update(bufmgr, updatedTuple); err != nil {
 			t.Fatalf("Update failed: %v", err)
 		}
+		if problems, err := tbl.VerifyIndexes(bufmgr); err != nil || len(problems) != 0 {
+			t.Errorf("expected the index entry to move, got %v, %v", problems, err)
+		}
+	})
 
-		// Note: This test doesn't verify index updates because Delete is not implemented
-		// A complete implementation would need to:
-		// 1. Delete old index entries
-		// 2. Insert new index entries
+	// Test: A unique key taken by another tuple is rejected before anything is modified
+	t.Run("UniqueKeyTaken", func(t *testing.T) {
+		if err := tbl.Insert(bufmgr, [][]byte{[]byte("2"), []byte("Carol"), []byte("Jones")}); err != nil {
+			t.Fatal(err)
+		}
+		err := tbl.Update(bufmgr, [][]byte{[]byte("1"), []byte("Bob"), []byte("Jones")})
+		var violation *ConstraintViolationError
+		if !errors.As(err, &violation) {
+			t.Fatalf("expected a ConstraintViolationError, got %v", err)
+		}
+		tup, err := tbl.Get(bufmgr, [][]byte{[]byte("1")})
+		if err != nil || string(tup[2]) != "Johnson" {
+			t.Errorf("expected the tuple to be unchanged, got %q, %v", tup, err)
+		}
+		if problems, err := tbl.VerifyIndexes(bufmgr); err != nil || len(problems) != 0 {
+			t.Errorf("expected consistent indexes, got %v, %v", problems, err)
+		}
 	})
 }
 
@@ -342,16 +522,8 @@ func TestSimpleTableDelete(t *testing.T) {
 		keyBytes := make([]byte, 0)
 		tuple.Encode(keyTuple, &keyBytes)
 
-		iter, err := bt.Search(bufmgr, btree.NewSearchModeKey(keyBytes))
-		if err != nil {
-			t.Fatalf("Search failed: %v", err)
-		}
-		gotKey, _, ok := iter.Get()
-		if ok {
-			// Check if the returned key matches the deleted key
-			if reflect.DeepEqual(keyBytes, gotKey) {
-				t.Error("Expected tuple to be deleted, but it still exists")
-			}
+		if _, err := bt.Get(bufmgr, keyBytes); err != btree.ErrKeyNotFound {
+			t.Errorf("Expected tuple to be deleted, got %v", err)
 		}
 	})
 
@@ -373,25 +545,15 @@ func TestSimpleTableDelete(t *testing.T) {
 		// Check Alice (id=1) still exists
 		keyBytes := make([]byte, 0)
 		tuple.Encode([][]byte{[]byte("1")}, &keyBytes)
-		iter, err := bt.Search(bufmgr, btree.NewSearchModeKey(keyBytes))
-		if err != nil {
-			t.Fatalf("Search failed: %v", err)
-		}
-		_, _, ok := iter.Get()
-		if !ok {
-			t.Error("Expected Alice to still exist")
+		if _, err := bt.Get(bufmgr, keyBytes); err != nil {
+			t.Errorf("Expected Alice to still exist, got %v", err)
 		}
 
 		// Check Charlie (id=3) still exists
 		keyBytes = make([]byte, 0)
 		tuple.Encode([][]byte{[]byte("3")}, &keyBytes)
-		iter, err = bt.Search(bufmgr, btree.NewSearchModeKey(keyBytes))
-		if err != nil {
-			t.Fatalf("Search failed: %v", err)
-		}
-		_, _, ok = iter.Get()
-		if !ok {
-			t.Error("Expected Charlie to still exist")
+		if _, err := bt.Get(bufmgr, keyBytes); err != nil {
+			t.Errorf("Expected Charlie to still exist, got %v", err)
 		}
 	})
 }
@@ -550,3 +712,242 @@ func TestBTreeDelete(t *testing.T) {
 		t.Errorf("Expected ErrKeyNotFound, got %v", err)
 	}
 }
+
+func TestTableUpdateKey(t *testing.T) {
+	tmpfile, err := os.CreateTemp("", "test_table_update_key_*.db")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(tmpfile.Name())
+
+	dm, err := disk.NewDiskManager(tmpfile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer dm.Close()
+
+	pool := buffer.NewBufferPool(10)
+	bufmgr := buffer.NewBufferPoolManager(dm, pool)
+
+	tbl := &Table{
+		MetaPageID:  disk.InvalidPageID,
+		NumKeyElems: 1, // id is primary key
+		UniqueIndices: []*UniqueIndex{
+			{
+				MetaPageID: disk.InvalidPageID,
+				Skey:       []int{2}, // last_name (index 2) has unique index
+			},
+		},
+	}
+	if err := tbl.Create(bufmgr); err != nil {
+		t.Fatal(err)
+	}
+
+	// Insert initial tuples: [id, first_name, last_name]
+	for _, tup := range [][][]byte{
+		{[]byte("1"), []byte("Alice"), []byte("Smith")},
+		{[]byte("2"), []byte("Bob"), []byte("Jones")},
+	} {
+		if err := tbl.Insert(bufmgr, tup); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	lookupIndex := func(lastName string) []byte {
+		indexBt := btree.NewBTree(tbl.UniqueIndices[0].MetaPageID)
+		skeyBytes := make([]byte, 0)
+		tuple.Encode([][]byte{[]byte(lastName)}, &skeyBytes)
+		iter, err := indexBt.Search(bufmgr, btree.NewSearchModeKey(skeyBytes))
+		if err != nil {
+			t.Fatal(err)
+		}
+		key, pkey, ok := iter.Get()
+		if !ok || !reflect.DeepEqual(key, skeyBytes) {
+			return nil
+		}
+		var elems [][]byte
+		tuple.Decode(pkey, &elems)
+		return elems[0]
+	}
+
+	t.Run("MoveToNewKey", func(t *testing.T) {
+		newTuple := [][]byte{[]byte("3"), []byte("Alice"), []byte("Smith")}
+		if err := tbl.UpdateKey(bufmgr, [][]byte{[]byte("1")}, newTuple); err != nil {
+			t.Fatalf("UpdateKey failed: %v", err)
+		}
+
+		oldKeyBytes := make([]byte, 0)
+		tuple.Encode([][]byte{[]byte("1")}, &oldKeyBytes)
+		if _, err := tbl.fetchTuple(bufmgr, oldKeyBytes); err != btree.ErrKeyNotFound {
+			t.Errorf("Expected old key to be gone, got %v", err)
+		}
+		newKeyBytes := make([]byte, 0)
+		tuple.Encode([][]byte{[]byte("3")}, &newKeyBytes)
+		got, err := tbl.fetchTuple(bufmgr, newKeyBytes)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(got, newTuple) {
+			t.Errorf("Expected %v, got %v", newTuple, got)
+		}
+		if pkey := lookupIndex("Smith"); string(pkey) != "3" {
+			t.Errorf("Expected index to point to new key 3, got %q", pkey)
+		}
+	})
+
+	t.Run("DuplicatePrimaryKey", func(t *testing.T) {
+		err := tbl.UpdateKey(bufmgr, [][]byte{[]byte("3")}, [][]byte{[]byte("2"), []byte("Alice"), []byte("Brown")})
+		if err != btree.ErrDuplicateKey {
+			t.Fatalf("Expected ErrDuplicateKey, got %v", err)
+		}
+		if pkey := lookupIndex("Smith"); string(pkey) != "3" {
+			t.Errorf("Expected table to be unchanged, index points to %q", pkey)
+		}
+		if pkey := lookupIndex("Brown"); pkey != nil {
+			t.Errorf("Expected no index entry for Brown, got %q", pkey)
+		}
+	})
+
+	t.Run("DuplicateUniqueKey", func(t *testing.T) {
+		err := tbl.UpdateKey(bufmgr, [][]byte{[]byte("3")}, [][]byte{[]byte("4"), []byte("Alice"), []byte("Jones")})
+		if !errors.Is(err, btree.ErrDuplicateKey) {
+			t.Fatalf("Expected ErrDuplicateKey, got %v", err)
+		}
+		if pkey := lookupIndex("Jones"); string(pkey) != "2" {
+			t.Errorf("Expected Jones to still point to 2, got %q", pkey)
+		}
+	})
+
+	t.Run("SameKey", func(t *testing.T) {
+		err := tbl.UpdateKey(bufmgr, [][]byte{[]byte("2")}, [][]byte{[]byte("2"), []byte("Bob"), []byte("Smith")})
+		if !errors.Is(err, btree.ErrDuplicateKey) {
+			t.Fatalf("Expected ErrDuplicateKey, got %v", err)
+		}
+		if err := tbl.UpdateKey(bufmgr, [][]byte{[]byte("2")}, [][]byte{[]byte("2"), []byte("Bob"), []byte("Brown")}); err != nil {
+			t.Fatal(err)
+		}
+		if pkey := lookupIndex("Brown"); string(pkey) != "2" {
+			t.Errorf("Expected Brown to point to 2, got %q", pkey)
+		}
+		if problems, err := tbl.VerifyIndexes(bufmgr); err != nil || len(problems) != 0 {
+			t.Errorf("Expected consistent indexes, got %v, %v", problems, err)
+		}
+	})
+
+	t.Run("MissingKey", func(t *testing.T) {
+		err := tbl.UpdateKey(bufmgr, [][]byte{[]byte("9")}, [][]byte{[]byte("5"), []byte("Eve"), []byte("White")})
+		if err != btree.ErrKeyNotFound {
+			t.Fatalf("Expected ErrKeyNotFound, got %v", err)
+		}
+	})
+}
+
+func TestTableFormatV2(t *testing.T) {
+	tmpfile, err := os.CreateTemp("", "test_table_format_v2_*.db")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(tmpfile.Name())
+
+	dm, err := disk.NewDiskManager(tmpfile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer dm.Close()
+
+	pool := buffer.NewBufferPool(10)
+	bufmgr := buffer.NewBufferPoolManager(dm, pool)
+
+	tbl := &Table{
+		MetaPageID:  disk.InvalidPageID,
+		NumKeyElems: 1,
+		Format:      tuple.FormatV2,
+	}
+	if err := tbl.Create(bufmgr); err != nil {
+		t.Fatal(err)
+	}
+	if err := tbl.Insert(bufmgr, [][]byte{[]byte("1"), []byte("Alice"), []byte("30")}); err != nil {
+		t.Fatal(err)
+	}
+	if err := tbl.Update(bufmgr, [][]byte{[]byte("1"), []byte("Bob"), []byte("31")}); err != nil {
+		t.Fatal(err)
+	}
+
+	var keyBytes []byte
+	tuple.Encode([][]byte{[]byte("1")}, &keyBytes)
+	iter, err := btree.NewBTree(tbl.MetaPageID).Search(bufmgr, btree.NewSearchModeKey(keyBytes))
+	if err != nil {
+		t.Fatal(err)
+	}
+	foundKey, valueBytes, ok := iter.Get()
+	if !ok || !reflect.DeepEqual(foundKey, keyBytes) {
+		t.Fatalf("expected key %x, got %x", keyBytes, foundKey)
+	}
+	// The value is stored in the v2 format and its columns can be read directly
+	if col, ok := tuple.ColumnV2(valueBytes, 1); !ok || string(col) != "31" {
+		t.Errorf("expected age 31, got %q", col)
+	}
+
+	fullTuple, err := tbl.fetchTuple(bufmgr, keyBytes)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := [][]byte{[]byte("1"), []byte("Bob"), []byte("31")}
+	if !reflect.DeepEqual(fullTuple, expected) {
+		t.Errorf("expected %q, got %q", expected, fullTuple)
+	}
+}
+
+func TestTableEstimateRange(t *testing.T) {
+	tmpfile, err := os.CreateTemp("", "test_table_estimate_*.db")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(tmpfile.Name())
+
+	dm, err := disk.NewDiskManager(tmpfile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer dm.Close()
+
+	pool := buffer.NewBufferPool(10)
+	bufmgr := buffer.NewBufferPoolManager(dm, pool)
+
+	tbl := &Table{
+		MetaPageID:  disk.InvalidPageID,
+		NumKeyElems: 2, // (region, id)
+	}
+	if err := tbl.Create(bufmgr); err != nil {
+		t.Fatal(err)
+	}
+	for _, region := range []string{"eu", "jp", "us"} {
+		for i := 0; i < 100; i++ {
+			if err := tbl.Insert(bufmgr, [][]byte{[]byte(region), []byte(fmt.Sprintf("%03d", i)), []byte("payload")}); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	if _, err := btree.NewBTree(tbl.MetaPageID).RefreshEntryCounts(bufmgr); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		lo, hi   [][]byte
+		expected uint64
+	}{
+		{nil, nil, 300},
+		{[][]byte{[]byte("jp")}, [][]byte{[]byte("us")}, 100},
+		{[][]byte{[]byte("jp"), []byte("050")}, [][]byte{[]byte("us")}, 50},
+		{nil, [][]byte{[]byte("jp")}, 100},
+	}
+	for _, tt := range tests {
+		count, err := tbl.EstimateRange(bufmgr, tt.lo, tt.hi)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if count != tt.expected {
+			t.Errorf("range %q-%q: expected %d, got %d", tt.lo, tt.hi, tt.expected, count)
+		}
+	}
+}
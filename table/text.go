@@ -0,0 +1,234 @@
+package table
+
+import (
+	"bytes"
+	"encoding/binary"
+	"strings"
+	"unicode"
+
+	"github.com/Johniel/gorelly/btree"
+	"github.com/Johniel/gorelly/buffer"
+	"github.com/Johniel/gorelly/disk"
+	"github.com/Johniel/gorelly/tuple"
+)
+
+// Tokenizer splits a column value into search terms.
+type Tokenizer interface {
+	Tokenize(text []byte) [][]byte
+}
+
+// WordTokenizer splits text into lower-cased runs of letters and digits.
+type WordTokenizer struct{}
+
+func (WordTokenizer) Tokenize(text []byte) [][]byte {
+	words := strings.FieldsFunc(string(text), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+	terms := make([][]byte, len(words))
+	for i, word := range words {
+		terms[i] = []byte(strings.ToLower(word))
+	}
+	return terms
+}
+
+// TextIndex represents an inverted index on a text column of a table.
+// It maps every term of the column to the primary keys of the tuples containing it.
+//
+// Each posting is stored as its own B+ tree entry:
+//   - key: memcmpable-encoded term followed by the encoded primary key
+//   - value: positions of the term within the column, as 4-byte big-endian integers
+//
+// Since the encoded term is self-delimiting, all postings of a term are adjacent
+// and sorted by primary key.
+type TextIndex struct {
+	MetaPageID disk.PageID // Page ID of the B+ tree meta page for this index
+	Column     int         // Index of the tuple element that is tokenized
+	Tokenizer  Tokenizer   // Tokenizer used for both indexing and queries (nil uses WordTokenizer)
+}
+
+func (ti *TextIndex) Create(bufmgr *buffer.BufferPoolManager) error {
+	return ti.create(bufmgr, nil)
+}
+
+func (ti *TextIndex) create(bufmgr *buffer.BufferPoolManager, log btree.PageLog) error {
+	bt, err := btree.CreateBTreeWithLog(bufmgr, log)
+	if err != nil {
+		return err
+	}
+	ti.MetaPageID = bt.MetaPageID
+	return nil
+}
+
+// Tokenize splits text into terms using the index's tokenizer.
+func (ti *TextIndex) Tokenize(text []byte) [][]byte {
+	if ti.Tokenizer == nil {
+		return WordTokenizer{}.Tokenize(text)
+	}
+	return ti.Tokenizer.Tokenize(text)
+}
+
+// Insert adds the postings of the given tuple.
+func (ti *TextIndex) Insert(bufmgr *buffer.BufferPoolManager, pkey []byte, tup [][]byte) error {
+	return ti.insert(bufmgr, nil, pkey, tup)
+}
+
+func (ti *TextIndex) insert(bufmgr *buffer.BufferPoolManager, log btree.PageLog, pkey []byte, tup [][]byte) error {
+	bt := tree(ti.MetaPageID, log)
+	for term, positions := range ti.positions(tup) {
+		if err := bt.Insert(bufmgr, postingKey([]byte(term), pkey), encodePositions(positions)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Delete removes the postings of the given tuple.
+func (ti *TextIndex) Delete(bufmgr *buffer.BufferPoolManager, pkey []byte, tup [][]byte) error {
+	return ti.delete(bufmgr, nil, pkey, tup)
+}
+
+func (ti *TextIndex) delete(bufmgr *buffer.BufferPoolManager, log btree.PageLog, pkey []byte, tup [][]byte) error {
+	bt := tree(ti.MetaPageID, log)
+	for term := range ti.positions(tup) {
+		if err := bt.Delete(bufmgr, postingKey([]byte(term), pkey)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Search returns the encoded primary keys of the tuples containing every term of text,
+// in primary key order. If phrase is true, the terms must also appear consecutively and in order.
+func (ti *TextIndex) Search(bufmgr *buffer.BufferPoolManager, text []byte, phrase bool) ([][]byte, error) {
+	terms := ti.Tokenize(text)
+	if len(terms) == 0 {
+		return nil, nil
+	}
+
+	postings := make([]map[string][]uint32, len(terms))
+	for i, term := range terms {
+		p, err := ti.postings(bufmgr, term)
+		if err != nil {
+			return nil, err
+		}
+		postings[i] = p
+	}
+
+	// Walk the first term's postings in key order so that results come out sorted
+	firstPkeys, err := ti.pkeys(bufmgr, terms[0])
+	if err != nil {
+		return nil, err
+	}
+	var result [][]byte
+	for _, pkey := range firstPkeys {
+		matched := true
+		for _, p := range postings[1:] {
+			if _, ok := p[string(pkey)]; !ok {
+				matched = false
+				break
+			}
+		}
+		if matched && phrase {
+			matched = matchesPhrase(postings, string(pkey))
+		}
+		if matched {
+			result = append(result, pkey)
+		}
+	}
+	return result, nil
+}
+
+// positions tokenizes the indexed column of tup and returns the positions of each term.
+func (ti *TextIndex) positions(tup [][]byte) map[string][]uint32 {
+	positions := make(map[string][]uint32)
+	if ti.Column < 0 || len(tup) <= ti.Column {
+		return positions
+	}
+	for i, term := range ti.Tokenize(tup[ti.Column]) {
+		positions[string(term)] = append(positions[string(term)], uint32(i))
+	}
+	return positions
+}
+
+// postings returns the positions of term keyed by encoded primary key.
+func (ti *TextIndex) postings(bufmgr *buffer.BufferPoolManager, term []byte) (map[string][]uint32, error) {
+	result := make(map[string][]uint32)
+	err := ti.scanTerm(bufmgr, term, func(pkey []byte, value []byte) {
+		positions := make([]uint32, len(value)/4)
+		for i := range positions {
+			positions[i] = binary.BigEndian.Uint32(value[4*i:])
+		}
+		result[string(pkey)] = positions
+	})
+	return result, err
+}
+
+// pkeys returns the encoded primary keys of the tuples containing term, in key order.
+func (ti *TextIndex) pkeys(bufmgr *buffer.BufferPoolManager, term []byte) ([][]byte, error) {
+	var result [][]byte
+	err := ti.scanTerm(bufmgr, term, func(pkey []byte, value []byte) {
+		result = append(result, pkey)
+	})
+	return result, err
+}
+
+func (ti *TextIndex) scanTerm(bufmgr *buffer.BufferPoolManager, term []byte, f func(pkey []byte, value []byte)) error {
+	prefix := postingKey(term, nil)
+	bt := btree.NewBTree(ti.MetaPageID)
+	iter, err := bt.Search(bufmgr, btree.NewSearchModeKey(prefix))
+	if err != nil {
+		return err
+	}
+	defer iter.Close()
+	for {
+		key, value, ok, err := iter.Next(bufmgr)
+		if err != nil {
+			return err
+		}
+		if !ok || !bytes.HasPrefix(key, prefix) {
+			return nil
+		}
+		f(key[len(prefix):], value)
+	}
+}
+
+// matchesPhrase reports whether the terms appear consecutively in the tuple identified by pkey.
+func matchesPhrase(postings []map[string][]uint32, pkey string) bool {
+	for _, start := range postings[0][pkey] {
+		matched := true
+		for i, p := range postings[1:] {
+			if !containsPosition(p[pkey], start+uint32(i)+1) {
+				matched = false
+				break
+			}
+		}
+		if matched {
+			return true
+		}
+	}
+	return false
+}
+
+func containsPosition(positions []uint32, pos uint32) bool {
+	for _, p := range positions {
+		if p == pos {
+			return true
+		}
+	}
+	return false
+}
+
+// encodePositions encodes term positions the way TextIndex stores them in a posting.
+func encodePositions(positions []uint32) []byte {
+	value := make([]byte, 0, 4*len(positions))
+	for _, pos := range positions {
+		value = binary.BigEndian.AppendUint32(value, pos)
+	}
+	return value
+}
+
+func postingKey(term []byte, pkey []byte) []byte {
+	key := make([]byte, 0)
+	tuple.Encode([][]byte{term}, &key)
+	return append(key, pkey...)
+}
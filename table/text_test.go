@@ -0,0 +1,117 @@
+package table
+
+import (
+	"os"
+	"reflect"
+	"testing"
+
+	"github.com/Johniel/gorelly/buffer"
+	"github.com/Johniel/gorelly/disk"
+	"github.com/Johniel/gorelly/tuple"
+)
+
+func TestWordTokenizer(t *testing.T) {
+	terms := WordTokenizer{}.Tokenize([]byte("Hello, World! hello-again 42"))
+	expected := [][]byte{[]byte("hello"), []byte("world"), []byte("hello"), []byte("again"), []byte("42")}
+	if !reflect.DeepEqual(terms, expected) {
+		t.Errorf("expected %q, got %q", expected, terms)
+	}
+}
+
+func TestTextIndex(t *testing.T) {
+	tmpfile, err := os.CreateTemp("", "test_text_index_*.db")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(tmpfile.Name())
+
+	dm, err := disk.NewDiskManager(tmpfile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer dm.Close()
+
+	pool := buffer.NewBufferPool(10)
+	bufmgr := buffer.NewBufferPoolManager(dm, pool)
+
+	// Schema: [id, body]
+	textIndex := &TextIndex{MetaPageID: disk.InvalidPageID, Column: 1}
+	tbl := &Table{
+		MetaPageID:  disk.InvalidPageID,
+		NumKeyElems: 1,
+		TextIndices: []*TextIndex{textIndex},
+	}
+	if err := tbl.Create(bufmgr); err != nil {
+		t.Fatal(err)
+	}
+
+	tuples := [][][]byte{
+		{[]byte("1"), []byte("the quick brown fox")},
+		{[]byte("2"), []byte("the lazy dog")},
+		{[]byte("3"), []byte("brown quick dog")},
+	}
+	for _, tup := range tuples {
+		if err := tbl.Insert(bufmgr, tup); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	search := func(text string, phrase bool) []string {
+		pkeys, err := textIndex.Search(bufmgr, []byte(text), phrase)
+		if err != nil {
+			t.Fatal(err)
+		}
+		ids := []string{}
+		for _, pkey := range pkeys {
+			var elems [][]byte
+			tuple.Decode(pkey, &elems)
+			ids = append(ids, string(elems[0]))
+		}
+		return ids
+	}
+
+	tests := []struct {
+		name     string
+		text     string
+		phrase   bool
+		expected []string
+	}{
+		{"SingleTerm", "dog", false, []string{"2", "3"}},
+		{"AllTerms", "quick brown", false, []string{"1", "3"}},
+		{"Phrase", "quick brown", true, []string{"1"}},
+		{"CaseInsensitive", "LAZY", false, []string{"2"}},
+		{"MissingTerm", "cat", false, []string{}},
+		{"Empty", "", false, []string{}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := search(tt.text, tt.phrase); !reflect.DeepEqual(got, tt.expected) {
+				t.Errorf("expected %v, got %v", tt.expected, got)
+			}
+		})
+	}
+
+	t.Run("Update", func(t *testing.T) {
+		if err := tbl.Update(bufmgr, [][]byte{[]byte("2"), []byte("the sleepy cat")}); err != nil {
+			t.Fatal(err)
+		}
+		if got := search("lazy", false); len(got) != 0 {
+			t.Errorf("expected old terms to be removed, got %v", got)
+		}
+		if got := search("cat", false); !reflect.DeepEqual(got, []string{"2"}) {
+			t.Errorf("expected new terms to be indexed, got %v", got)
+		}
+	})
+
+	t.Run("Delete", func(t *testing.T) {
+		if err := tbl.Delete(bufmgr, [][]byte{[]byte("3")}); err != nil {
+			t.Fatal(err)
+		}
+		if got := search("dog", false); len(got) != 0 {
+			t.Errorf("expected deleted tuple to be removed, got %v", got)
+		}
+		if got := search("quick", false); !reflect.DeepEqual(got, []string{"1"}) {
+			t.Errorf("expected %v, got %v", []string{"1"}, got)
+		}
+	})
+}
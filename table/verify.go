@@ -0,0 +1,180 @@
+package table
+
+import (
+	"bytes"
+	"fmt"
+
+	"github.com/Johniel/gorelly/btree"
+	"github.com/Johniel/gorelly/buffer"
+	"github.com/Johniel/gorelly/disk"
+	"github.com/Johniel/gorelly/tuple"
+)
+
+// DiscrepancyKind classifies a disagreement between a table and one of its indexes.
+type DiscrepancyKind int
+
+const (
+	// DiscrepancyMissingEntry means a row has no entry in the index.
+	DiscrepancyMissingEntry DiscrepancyKind = iota
+	// DiscrepancyWrongPkey means the index entry for a row's key points to another row.
+	DiscrepancyWrongPkey
+	// DiscrepancyDanglingEntry means an index entry points to a row that does not exist
+	// or whose indexed columns do not produce the entry.
+	DiscrepancyDanglingEntry
+	// DiscrepancyWrongPositions means a text index posting holds other term positions than the row.
+	DiscrepancyWrongPositions
+)
+
+func (k DiscrepancyKind) String() string {
+	switch k {
+	case DiscrepancyMissingEntry:
+		return "missing entry"
+	case DiscrepancyWrongPkey:
+		return "wrong primary key"
+	case DiscrepancyDanglingEntry:
+		return "dangling entry"
+	case DiscrepancyWrongPositions:
+		return "wrong positions"
+	}
+	return fmt.Sprintf("DiscrepancyKind(%d)", int(k))
+}
+
+// IndexDiscrepancy is a disagreement between a table and one of its indexes found by VerifyIndexes.
+type IndexDiscrepancy struct {
+	Kind      DiscrepancyKind
+	Text      bool   // Whether Index refers to TextIndices rather than UniqueIndices
+	Index     int    // Position of the index in UniqueIndices or TextIndices
+	Key       []byte // Encoded index key (secondary key, or term and primary key for text indexes)
+	Pkey      []byte // Encoded primary key of the row the entry belongs to or points to
+	IndexPkey []byte // Primary key stored in the entry, for DiscrepancyWrongPkey
+}
+
+func (d IndexDiscrepancy) String() string {
+	kind := "unique"
+	if d.Text {
+		kind = "text"
+	}
+	s := fmt.Sprintf("%s index %d: %s: key %x, pkey %x", kind, d.Index, d.Kind, d.Key, d.Pkey)
+	if d.Kind == DiscrepancyWrongPkey {
+		s += fmt.Sprintf(", index points to %x", d.IndexPkey)
+	}
+	return s
+}
+
+// VerifyIndexes cross-checks the table against its indexes, e.g. after a crash or a suspected bug.
+// Every row must have an entry in every index that points back to it, and every index entry
+// must point to an existing row that produces it. The discrepancies are returned in the order
+// found; an empty result means the table and its indexes agree.
+func (t *Table) VerifyIndexes(bufmgr *buffer.BufferPoolManager) ([]IndexDiscrepancy, error) {
+	var found []IndexDiscrepancy
+
+	// Rows against indexes
+	err := scanTree(bufmgr, t.MetaPageID, func(keyBytes []byte, valueBytes []byte) error {
+		var tup [][]byte
+		tuple.Decode(keyBytes, &tup)
+		t.Format.DecodeValue(valueBytes, &tup)
+		for i, uniqueIndex := range t.UniqueIndices {
+			skeyBytes := uniqueIndex.skeyBytes(tup)
+			pkey, ok, err := lookup(bufmgr, uniqueIndex.MetaPageID, skeyBytes)
+			if err != nil {
+				return err
+			}
+			if !ok {
+				found = append(found, IndexDiscrepancy{Kind: DiscrepancyMissingEntry, Index: i, Key: skeyBytes, Pkey: keyBytes})
+			} else if !bytes.Equal(pkey, keyBytes) {
+				found = append(found, IndexDiscrepancy{Kind: DiscrepancyWrongPkey, Index: i, Key: skeyBytes, Pkey: keyBytes, IndexPkey: pkey})
+			}
+		}
+		for i, textIndex := range t.TextIndices {
+			for term, positions := range textIndex.positions(tup) {
+				key := postingKey([]byte(term), keyBytes)
+				value, ok, err := lookup(bufmgr, textIndex.MetaPageID, key)
+				if err != nil {
+					return err
+				}
+				if !ok {
+					found = append(found, IndexDiscrepancy{Kind: DiscrepancyMissingEntry, Text: true, Index: i, Key: key, Pkey: keyBytes})
+				} else if !bytes.Equal(value, encodePositions(positions)) {
+					found = append(found, IndexDiscrepancy{Kind: DiscrepancyWrongPositions, Text: true, Index: i, Key: key, Pkey: keyBytes})
+				}
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	// Index entries against rows
+	for i, uniqueIndex := range t.UniqueIndices {
+		err := scanTree(bufmgr, uniqueIndex.MetaPageID, func(skeyBytes []byte, pkey []byte) error {
+			tup, err := t.fetchTuple(bufmgr, pkey)
+			if err == btree.ErrKeyNotFound || (err == nil && !bytes.Equal(uniqueIndex.skeyBytes(tup), skeyBytes)) {
+				found = append(found, IndexDiscrepancy{Kind: DiscrepancyDanglingEntry, Index: i, Key: skeyBytes, Pkey: pkey})
+				return nil
+			}
+			return err
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+	for i, textIndex := range t.TextIndices {
+		err := scanTree(bufmgr, textIndex.MetaPageID, func(key []byte, value []byte) error {
+			var elems [][]byte
+			tuple.Decode(key, &elems)
+			term := elems[0]
+			pkey := key[len(postingKey(term, nil)):]
+			tup, err := t.fetchTuple(bufmgr, pkey)
+			if err == btree.ErrKeyNotFound {
+				found = append(found, IndexDiscrepancy{Kind: DiscrepancyDanglingEntry, Text: true, Index: i, Key: key, Pkey: pkey})
+				return nil
+			}
+			if err != nil {
+				return err
+			}
+			if _, ok := textIndex.positions(tup)[string(term)]; !ok {
+				found = append(found, IndexDiscrepancy{Kind: DiscrepancyDanglingEntry, Text: true, Index: i, Key: key, Pkey: pkey})
+			}
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+	return found, nil
+}
+
+// scanTree calls f with every key and value of the B+ tree in key order.
+func scanTree(bufmgr *buffer.BufferPoolManager, metaPageID disk.PageID, f func(key []byte, value []byte) error) error {
+	bt := btree.NewBTree(metaPageID)
+	iter, err := bt.Search(bufmgr, btree.NewSearchModeStart())
+	if err != nil {
+		return err
+	}
+	defer iter.Close()
+	for {
+		key, value, ok, err := iter.Next(bufmgr)
+		if err != nil {
+			return err
+		}
+		if !ok {
+			return nil
+		}
+		if err := f(key, value); err != nil {
+			return err
+		}
+	}
+}
+
+// lookup returns the value stored under exactly key in the B+ tree.
+func lookup(bufmgr *buffer.BufferPoolManager, metaPageID disk.PageID, key []byte) ([]byte, bool, error) {
+	value, err := btree.NewBTree(metaPageID).Get(bufmgr, key)
+	if err == btree.ErrKeyNotFound {
+		return nil, false, nil
+	}
+	if err != nil {
+		return nil, false, err
+	}
+	return value, true, nil
+}
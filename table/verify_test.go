@@ -0,0 +1,105 @@
+package table
+
+import (
+	"os"
+	"testing"
+
+	"github.com/Johniel/gorelly/btree"
+	"github.com/Johniel/gorelly/buffer"
+	"github.com/Johniel/gorelly/disk"
+	"github.com/Johniel/gorelly/tuple"
+)
+
+func TestTableVerifyIndexes(t *testing.T) {
+	tmpfile, err := os.CreateTemp("", "test_verify_indexes_*.db")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(tmpfile.Name())
+
+	dm, err := disk.NewDiskManager(tmpfile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer dm.Close()
+
+	pool := buffer.NewBufferPool(10)
+	bufmgr := buffer.NewBufferPoolManager(dm, pool)
+
+	tbl := &Table{
+		NumKeyElems:   1,
+		UniqueIndices: []*UniqueIndex{{Skey: []int{1}}},
+		TextIndices:   []*TextIndex{{Column: 2}},
+	}
+	if err := tbl.Create(bufmgr); err != nil {
+		t.Fatal(err)
+	}
+	rows := [][][]byte{
+		{[]byte("1"), []byte("alice@example.com"), []byte("hello world")},
+		{[]byte("2"), []byte("bob@example.com"), []byte("goodbye world")},
+	}
+	for _, row := range rows {
+		if err := tbl.Insert(bufmgr, row); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	t.Run("Consistent", func(t *testing.T) {
+		found, err := tbl.VerifyIndexes(bufmgr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(found) != 0 {
+			t.Errorf("expected no discrepancies, got %v", found)
+		}
+	})
+
+	t.Run("Corrupted", func(t *testing.T) {
+		pkey := func(id string) []byte {
+			keyBytes := make([]byte, 0)
+			tuple.Encode([][]byte{[]byte(id)}, &keyBytes)
+			return keyBytes
+		}
+		uniqueBt := btree.NewBTree(tbl.UniqueIndices[0].MetaPageID)
+		textBt := btree.NewBTree(tbl.TextIndices[0].MetaPageID)
+
+		// Row 1 loses its unique entry, an entry points to a missing row 3,
+		// row 2 loses the posting of "goodbye" and row 1 gains a posting of a term it lacks
+		if err := tbl.UniqueIndices[0].Delete(bufmgr, rows[0]); err != nil {
+			t.Fatal(err)
+		}
+		if err := uniqueBt.Insert(bufmgr, tbl.UniqueIndices[0].skeyBytes([][]byte{nil, []byte("carol@example.com")}), pkey("3")); err != nil {
+			t.Fatal(err)
+		}
+		if err := textBt.Delete(bufmgr, postingKey([]byte("goodbye"), pkey("2"))); err != nil {
+			t.Fatal(err)
+		}
+		if err := textBt.Insert(bufmgr, postingKey([]byte("missing"), pkey("1")), encodePositions([]uint32{0})); err != nil {
+			t.Fatal(err)
+		}
+
+		found, err := tbl.VerifyIndexes(bufmgr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		expected := []struct {
+			kind DiscrepancyKind
+			text bool
+			pkey string
+		}{
+			{DiscrepancyMissingEntry, false, "1"},
+			{DiscrepancyMissingEntry, true, "2"},
+			{DiscrepancyDanglingEntry, false, "3"},
+			{DiscrepancyDanglingEntry, true, "1"},
+		}
+		if len(found) != len(expected) {
+			t.Fatalf("expected %d discrepancies, got %v", len(expected), found)
+		}
+		for i, want := range expected {
+			got := found[i]
+			if got.Kind != want.kind || got.Text != want.text || string(got.Pkey) != string(pkey(want.pkey)) {
+				t.Errorf("discrepancy %d: expected %v (text %v) for row %s, got %v", i, want.kind, want.text, want.pkey, got)
+			}
+		}
+	})
+}
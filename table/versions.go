@@ -0,0 +1,274 @@
+package table
+
+import (
+	"bytes"
+
+	"github.com/Johniel/gorelly/btree"
+	"github.com/Johniel/gorelly/buffer"
+	"github.com/Johniel/gorelly/disk"
+	"github.com/Johniel/gorelly/errcode"
+	"github.com/Johniel/gorelly/tuple"
+)
+
+// ErrVersionedKeyChange is returned by UpdateKey for a change of the primary key of a tuple whose
+// versions the table maintains, as the versions of a row are found by its primary key.
+var ErrVersionedKeyChange = errcode.New(errcode.FeatureNotSupported, "primary key of a versioned row cannot change")
+
+// VersionStore keeps the versions of a table's rows that updates replaced, so that readers
+// whose snapshot does not see an update still find the version they see, without waiting
+// for the writer (multi-version concurrency control).
+//
+// Rows carry the encoded IDs of the transactions that wrote and deleted them in the
+// XminColumn and XmaxColumn columns (see transaction.Snapshot); rows are deleted by setting
+// their xmax with Update. Set as a Table's Versions, the store receives the replaced version of
+// every updated row, through the table's Log, so that a rolled back update leaves no version
+// behind. query.FilterVisible looks up the versions of the rows invisible to its snapshot.
+//
+// Versions are stored in a B+ tree under the primary key of their row and their xmin, newest
+// first, together with the xmin of the version that replaced them. Prune drops the versions
+// no snapshot can see anymore.
+type VersionStore struct {
+	MetaPageID  disk.PageID // Page ID of the version B+ tree's meta page
+	NumKeyElems int         // Number of primary key elements of the table
+	XminColumn  int         // Column holding the ID of the transaction that wrote a row
+	XmaxColumn  int         // Column holding the ID of the transaction that deleted a row (-1 if none)
+}
+
+func (vs *VersionStore) Create(bufmgr *buffer.BufferPoolManager) error {
+	return vs.create(bufmgr, nil)
+}
+
+// create creates the version tree, logging its creation to log.
+func (vs *VersionStore) create(bufmgr *buffer.BufferPoolManager, log btree.PageLog) error {
+	bt, err := btree.CreateBTreeWithLog(bufmgr, log)
+	if err != nil {
+		return err
+	}
+	vs.MetaPageID = bt.MetaPageID
+	return nil
+}
+
+// versionKey encodes the key of a version: the primary key followed by the inverted xmin,
+// so that the versions of a row are ordered newest first.
+func (vs *VersionStore) versionKey(tup [][]byte) []byte {
+	xmin := bytes.Clone(vs.column(tup, vs.XminColumn))
+	for i := range xmin {
+		xmin[i] = ^xmin[i]
+	}
+	elems := append(append([][]byte{}, tup[:vs.NumKeyElems]...), xmin)
+	keyBytes := make([]byte, 0)
+	tuple.Encode(elems, &keyBytes)
+	return keyBytes
+}
+
+func (vs *VersionStore) column(tup [][]byte, columnIndex int) []byte {
+	if columnIndex < 0 || len(tup) <= columnIndex {
+		return nil
+	}
+	return tup[columnIndex]
+}
+
+// add stores oldTuple, replaced by newTuple, as a version of its row. A version written by the
+// transaction replacing it is visible to no other transaction, so it is not stored.
+func (vs *VersionStore) add(bufmgr *buffer.BufferPoolManager, log btree.PageLog, oldTuple [][]byte, newTuple [][]byte) error {
+	if bytes.Equal(vs.column(oldTuple, vs.XminColumn), vs.column(newTuple, vs.XminColumn)) {
+		return nil
+	}
+	valueBytes := make([]byte, 0)
+	tuple.Encode(append([][]byte{vs.column(newTuple, vs.XminColumn)}, oldTuple...), &valueBytes)
+	return tree(vs.MetaPageID, log).Insert(bufmgr, vs.versionKey(oldTuple), valueBytes)
+}
+
+// remove drops the version add stored, when the update that replaced it failed.
+func (vs *VersionStore) remove(bufmgr *buffer.BufferPoolManager, log btree.PageLog, oldTuple [][]byte, newTuple [][]byte) {
+	if bytes.Equal(vs.column(oldTuple, vs.XminColumn), vs.column(newTuple, vs.XminColumn)) {
+		return
+	}
+	tree(vs.MetaPageID, log).Delete(bufmgr, vs.versionKey(oldTuple))
+}
+
+// Lookup returns the version of the row with the primary key of tup that a snapshot sees: the
+// newest version whose writer it sees, unless it also sees that version deleted. It returns nil
+// if the snapshot sees no version. visible reports whether the snapshot sees the changes of the
+// transaction with the given encoded ID, e.g. transaction.Snapshot.Visible.
+func (vs *VersionStore) Lookup(bufmgr *buffer.BufferPoolManager, tup [][]byte, visible func(id []byte) bool) ([][]byte, error) {
+	prefix := make([]byte, 0)
+	tuple.Encode(tup[:vs.NumKeyElems], &prefix)
+	iter, err := btree.NewBTree(vs.MetaPageID).Search(bufmgr, btree.NewSearchModeKey(prefix))
+	if err != nil {
+		return nil, err
+	}
+	defer iter.Close()
+	for {
+		keyBytes, valueBytes, ok, err := iter.Next(bufmgr)
+		if err != nil {
+			return nil, err
+		}
+		if !ok || !bytes.HasPrefix(keyBytes, prefix) {
+			return nil, nil
+		}
+		var elems [][]byte
+		tuple.Decode(valueBytes, &elems)
+		version := elems[1:]
+		// A version written by a transaction the snapshot does not see: an older one may be visible
+		if !visible(vs.column(version, vs.XminColumn)) {
+			continue
+		}
+		if xmax := vs.column(version, vs.XmaxColumn); len(xmax) != 0 && visible(xmax) {
+			return nil, nil
+		}
+		return version, nil
+	}
+}
+
+// Prune removes the versions replaced by transactions older than horizon, which no snapshot
+// sees anymore (see transaction.TransactionManager.Horizon), and returns how many it removed.
+// horizon is an encoded transaction ID. The removal is not logged.
+func (vs *VersionStore) Prune(bufmgr *buffer.BufferPoolManager, horizon []byte) (int, error) {
+	_, removed, err := vs.PruneFrom(bufmgr, horizon, nil, nil)
+	return removed, err
+}
+
+// PruneFrom is an incremental Prune: it scans the versions from the version key resume on (nil
+// for the first) and stops before the next version once stop, if not nil, returns true for the
+// number of pages the scan has fetched. It always scans at least one version. It returns the key
+// to resume from, nil once the scan reached the end, and how many versions it removed.
+func (vs *VersionStore) PruneFrom(bufmgr *buffer.BufferPoolManager, horizon []byte, resume []byte, stop func(pages uint64) bool) ([]byte, int, error) {
+	searchMode := btree.NewSearchModeStart()
+	if resume != nil {
+		searchMode = btree.NewSearchModeKey(resume)
+	}
+	iter, err := btree.NewBTree(vs.MetaPageID).Search(bufmgr, searchMode)
+	if err != nil {
+		return nil, 0, err
+	}
+	var dead [][]byte
+	var next []byte
+	for {
+		if next != nil && stop != nil && stop(iter.PageCounts().Fetched) {
+			break
+		}
+		keyBytes, valueBytes, ok, err := iter.Next(bufmgr)
+		if err != nil {
+			iter.Close()
+			return nil, 0, err
+		}
+		if !ok {
+			next = nil
+			break
+		}
+		var elems [][]byte
+		tuple.Decode(valueBytes, &elems)
+		if bytes.Compare(elems[0], horizon) < 0 {
+			dead = append(dead, bytes.Clone(keyBytes))
+		}
+		// The smallest key after this one
+		next = append(bytes.Clone(keyBytes), 0)
+	}
+	iter.Close()
+
+	bt := btree.NewBTree(vs.MetaPageID)
+	for i, keyBytes := range dead {
+		if err := bt.Delete(bufmgr, keyBytes); err != nil {
+			return nil, i, err
+		}
+	}
+	return next, len(dead), nil
+}
+
+// deleted reports whether tup is marked as deleted, by an xmax.
+func (vs *VersionStore) deleted(tup [][]byte) bool {
+	return len(vs.column(tup, vs.XmaxColumn)) != 0
+}
+
+// versioned reports whether the changes of t maintain the xmin and xmax columns of its tuples.
+func (t *Table) versioned() bool {
+	return t.Versions != nil && t.Writer != nil
+}
+
+// stamp returns a copy of tup completed up to its xmin and xmax columns, with the Writer of t as
+// its xmin.
+func (t *Table) stamp(tup [][]byte) [][]byte {
+	stamped := make([][]byte, max(len(tup), t.Versions.XminColumn+1, t.Versions.XmaxColumn+1))
+	copy(stamped, tup)
+	stamped[t.Versions.XminColumn] = t.Writer
+	return stamped
+}
+
+// markDeleted stores the Writer of t as the xmax of the tuple stored under keyBytes, whose
+// content is fullTuple. The tuple keeps its entries in the indexes for the readers that do not
+// see the delete, until Vacuum removes it; the tuples referencing it through ForeignKeyCascade
+// references are deleted.
+func (t *Table) markDeleted(bufmgr *buffer.BufferPoolManager, bt *btree.BTree, keyBytes []byte, fullTuple [][]byte) error {
+	marked := make([][]byte, max(len(fullTuple), t.Versions.XmaxColumn+1))
+	copy(marked, fullTuple)
+	marked[t.Versions.XmaxColumn] = t.Writer
+	valueBytes := make([]byte, 0)
+	t.Format.EncodeValue(marked[t.NumKeyElems:], &valueBytes)
+	if err := bt.Update(bufmgr, keyBytes, valueBytes); err != nil {
+		return err
+	}
+	if err := t.cascade(bufmgr, keyBytes); err != nil {
+		return err
+	}
+	if t.Counters != nil {
+		t.Counters.Deletes.Add(1)
+	}
+	if t.Audit != nil {
+		return t.Audit.Record(bufmgr, AuditOpDelete, fullTuple, nil)
+	}
+	return nil
+}
+
+// Vacuum removes the tuples of a table with Versions that transactions older than horizon
+// deleted, which no snapshot sees anymore (see transaction.TransactionManager.Horizon), with
+// their index entries, and prunes the versions of the rows (see VersionStore.Prune). horizon
+// is an encoded transaction ID. It returns how many tuples and versions it removed. The tuples
+// are removed through the Log of t.
+func (t *Table) Vacuum(bufmgr *buffer.BufferPoolManager, horizon []byte) (int, int, error) {
+	iter, err := btree.NewBTree(t.MetaPageID).Search(bufmgr, btree.NewSearchModeStart())
+	if err != nil {
+		return 0, 0, err
+	}
+	var dead [][]byte
+	for {
+		keyBytes, valueBytes, ok, err := iter.Next(bufmgr)
+		if err != nil {
+			iter.Close()
+			return 0, 0, err
+		}
+		if !ok {
+			break
+		}
+		var tup [][]byte
+		tuple.Decode(keyBytes, &tup)
+		t.Format.DecodeValue(valueBytes, &tup)
+		if xmax := t.Versions.column(tup, t.Versions.XmaxColumn); len(xmax) != 0 && bytes.Compare(xmax, horizon) < 0 {
+			dead = append(dead, bytes.Clone(keyBytes))
+		}
+	}
+	iter.Close()
+
+	bt := t.primaryTree()
+	for i, keyBytes := range dead {
+		fullTuple, err := t.fetchTuple(bufmgr, keyBytes)
+		if err != nil {
+			return i, 0, err
+		}
+		for _, uniqueIndex := range t.UniqueIndices {
+			if err := uniqueIndex.delete(bufmgr, t.Log, fullTuple); err != nil && err != btree.ErrKeyNotFound {
+				return i, 0, err
+			}
+		}
+		for _, textIndex := range t.TextIndices {
+			if err := textIndex.delete(bufmgr, t.Log, keyBytes, fullTuple); err != nil && err != btree.ErrKeyNotFound {
+				return i, 0, err
+			}
+		}
+		if err := bt.Delete(bufmgr, keyBytes); err != nil {
+			return i, 0, err
+		}
+	}
+	versions, err := t.Versions.Prune(bufmgr, horizon)
+	return len(dead), versions, err
+}
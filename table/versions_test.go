@@ -0,0 +1,303 @@
+package table
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"os"
+	"testing"
+
+	"github.com/Johniel/gorelly/btree"
+	"github.com/Johniel/gorelly/buffer"
+	"github.com/Johniel/gorelly/disk"
+)
+
+func TestVersionStore(t *testing.T) {
+	tmpfile, err := os.CreateTemp("", "test_version_store_*.db")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(tmpfile.Name())
+
+	dm, err := disk.NewDiskManager(tmpfile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer dm.Close()
+
+	pool := buffer.NewBufferPool(10)
+	bufmgr := buffer.NewBufferPoolManager(dm, pool)
+
+	// Schema: [id, balance, xmin, xmax]
+	tbl := &Table{
+		MetaPageID:  disk.InvalidPageID,
+		NumKeyElems: 1,
+		Versions:    &VersionStore{NumKeyElems: 1, XminColumn: 2, XmaxColumn: 3},
+	}
+	if err := tbl.Create(bufmgr); err != nil {
+		t.Fatal(err)
+	}
+	txnID := func(id uint64) []byte {
+		b := make([]byte, 8)
+		binary.BigEndian.PutUint64(b, id)
+		return b
+	}
+	// A snapshot that sees the transactions before xmax
+	visibleBefore := func(xmax uint64) func([]byte) bool {
+		return func(id []byte) bool {
+			return bytes.Compare(id, txnID(xmax)) < 0
+		}
+	}
+
+	if err := tbl.Insert(bufmgr, [][]byte{[]byte("alice"), []byte("100"), txnID(1), nil}); err != nil {
+		t.Fatal(err)
+	}
+	if err := tbl.Update(bufmgr, [][]byte{[]byte("alice"), []byte("90"), txnID(3), nil}); err != nil {
+		t.Fatal(err)
+	}
+	// A second update by the same transaction keeps no version of its own intermediate row
+	if err := tbl.Update(bufmgr, [][]byte{[]byte("alice"), []byte("80"), txnID(3), nil}); err != nil {
+		t.Fatal(err)
+	}
+	if err := tbl.Update(bufmgr, [][]byte{[]byte("alice"), []byte("70"), txnID(5), txnID(7)}); err != nil {
+		t.Fatal(err)
+	}
+	current, err := tbl.Get(bufmgr, [][]byte{[]byte("alice")})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		xmax     uint64
+		expected string // Balance of the version seen, "" for none
+	}{
+		{1, ""},
+		{2, "100"},
+		{4, "80"},
+	}
+	for _, tt := range tests {
+		version, err := tbl.Versions.Lookup(bufmgr, current, visibleBefore(tt.xmax))
+		if err != nil {
+			t.Fatal(err)
+		}
+		got := ""
+		if version != nil {
+			got = string(version[1])
+		}
+		if got != tt.expected {
+			t.Errorf("snapshot before %d: expected balance %q, got %q", tt.xmax, tt.expected, got)
+		}
+	}
+
+	// Versions replaced by transactions before the horizon are dropped
+	n, err := tbl.Versions.Prune(bufmgr, txnID(4))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 1 {
+		t.Errorf("expected 1 version to be pruned, got %d", n)
+	}
+	version, err := tbl.Versions.Lookup(bufmgr, current, visibleBefore(4))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if version == nil || string(version[1]) != "80" {
+		t.Errorf("expected the version replaced after the horizon to remain, got %q", version)
+	}
+}
+
+func TestVersionedWrites(t *testing.T) {
+	tmpfile, err := os.CreateTemp("", "test_versioned_writes_*.db")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(tmpfile.Name())
+
+	dm, err := disk.NewDiskManager(tmpfile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer dm.Close()
+
+	pool := buffer.NewBufferPool(10)
+	bufmgr := buffer.NewBufferPoolManager(dm, pool)
+
+	// Schema: [id, email, xmin, xmax], with the system columns completed by the writes
+	tbl := &Table{
+		MetaPageID:    disk.InvalidPageID,
+		NumKeyElems:   1,
+		UniqueIndices: []*UniqueIndex{{MetaPageID: disk.InvalidPageID, Skey: []int{1}}},
+		Versions:      &VersionStore{NumKeyElems: 1, XminColumn: 2, XmaxColumn: 3},
+	}
+	if err := tbl.Create(bufmgr); err != nil {
+		t.Fatal(err)
+	}
+	txnID := func(id uint64) []byte {
+		b := make([]byte, 8)
+		binary.BigEndian.PutUint64(b, id)
+		return b
+	}
+	key := [][]byte{[]byte("alice")}
+	get := func() [][]byte {
+		t.Helper()
+		tup, err := tbl.Get(bufmgr, key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return tup
+	}
+
+	tbl.Writer = txnID(1)
+	if err := tbl.Insert(bufmgr, [][]byte{[]byte("alice"), []byte("a@x")}); err != nil {
+		t.Fatal(err)
+	}
+	if tup := get(); len(tup) != 4 || !bytes.Equal(tup[2], txnID(1)) || len(tup[3]) != 0 {
+		t.Fatalf("expected the row stamped with its writer, got %q", tup)
+	}
+
+	// A delete marks the row, which then counts as missing
+	tbl.Writer = txnID(2)
+	if err := tbl.Delete(bufmgr, key); err != nil {
+		t.Fatal(err)
+	}
+	if tup := get(); !bytes.Equal(tup[2], txnID(1)) || !bytes.Equal(tup[3], txnID(2)) {
+		t.Fatalf("expected the row marked as deleted, got %q", tup)
+	}
+	if err := tbl.Update(bufmgr, [][]byte{[]byte("alice"), []byte("b@x")}); err != btree.ErrKeyNotFound {
+		t.Errorf("expected ErrKeyNotFound updating a deleted row, got %v", err)
+	}
+	if err := tbl.Delete(bufmgr, key); err != btree.ErrKeyNotFound {
+		t.Errorf("expected ErrKeyNotFound deleting a deleted row, got %v", err)
+	}
+
+	// An insert replaces the deleted row, whose version stays for the older snapshots
+	tbl.Writer = txnID(3)
+	if err := tbl.Insert(bufmgr, [][]byte{[]byte("alice"), []byte("c@x")}); err != nil {
+		t.Fatal(err)
+	}
+	current := get()
+	if string(current[1]) != "c@x" || !bytes.Equal(current[2], txnID(3)) || len(current[3]) != 0 {
+		t.Fatalf("expected the row replaced, got %q", current)
+	}
+	visibleBefore := func(xmax uint64) func([]byte) bool {
+		return func(id []byte) bool {
+			return bytes.Compare(id, txnID(xmax)) < 0
+		}
+	}
+	for _, tt := range []struct {
+		xmax     uint64
+		expected string // Email of the version seen, "" for none
+	}{
+		{2, "a@x"},
+		{3, ""}, // Sees the delete
+	} {
+		version, err := tbl.Versions.Lookup(bufmgr, current, visibleBefore(tt.xmax))
+		if err != nil {
+			t.Fatal(err)
+		}
+		got := ""
+		if version != nil {
+			got = string(version[1])
+		}
+		if got != tt.expected {
+			t.Errorf("snapshot before %d: expected email %q, got %q", tt.xmax, tt.expected, got)
+		}
+	}
+	if err := tbl.UpdateKey(bufmgr, key, [][]byte{[]byte("bob"), []byte("c@x")}); err != ErrVersionedKeyChange {
+		t.Errorf("expected ErrVersionedKeyChange, got %v", err)
+	}
+
+	// Vacuum removes the rows deleted before the horizon with their index entries
+	tbl.Writer = txnID(4)
+	if err := tbl.Delete(bufmgr, key); err != nil {
+		t.Fatal(err)
+	}
+	if rows, versions, err := tbl.Vacuum(bufmgr, txnID(4)); err != nil || rows != 0 || versions != 1 {
+		t.Errorf("expected only the version replaced before the horizon to be removed, got %d rows and %d versions (%v)", rows, versions, err)
+	}
+	if rows, versions, err := tbl.Vacuum(bufmgr, txnID(5)); err != nil || rows != 1 || versions != 0 {
+		t.Errorf("expected the deleted row to be removed, got %d rows and %d versions (%v)", rows, versions, err)
+	}
+	if _, err := tbl.Get(bufmgr, key); err != btree.ErrKeyNotFound {
+		t.Errorf("expected the row to be gone, got %v", err)
+	}
+	if problems, err := tbl.VerifyIndexes(bufmgr); err != nil || len(problems) != 0 {
+		t.Errorf("expected the index to match the rows, got %+v (%v)", problems, err)
+	}
+}
+
+func TestVersionStorePruneFrom(t *testing.T) {
+	tmpfile, err := os.CreateTemp("", "test_version_store_prune_*.db")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(tmpfile.Name())
+
+	dm, err := disk.NewDiskManager(tmpfile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer dm.Close()
+
+	pool := buffer.NewBufferPool(10)
+	bufmgr := buffer.NewBufferPoolManager(dm, pool)
+
+	// Schema: [id, payload, xmin, xmax]
+	tbl := &Table{
+		MetaPageID:  disk.InvalidPageID,
+		NumKeyElems: 1,
+		Versions:    &VersionStore{NumKeyElems: 1, XminColumn: 2, XmaxColumn: 3},
+	}
+	if err := tbl.Create(bufmgr); err != nil {
+		t.Fatal(err)
+	}
+	txnID := func(id uint64) []byte {
+		b := make([]byte, 8)
+		binary.BigEndian.PutUint64(b, id)
+		return b
+	}
+	const numRows = 500
+	payload := bytes.Repeat([]byte("x"), 100)
+	for i := range numRows {
+		id := []byte(fmt.Sprintf("row%04d", i))
+		if err := tbl.Insert(bufmgr, [][]byte{id, payload, txnID(1), nil}); err != nil {
+			t.Fatal(err)
+		}
+		// Rows with an even number are replaced before the horizon
+		xmin := uint64(2)
+		if i%2 == 1 {
+			xmin = 5
+		}
+		if err := tbl.Update(bufmgr, [][]byte{id, payload, txnID(xmin), nil}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// Steps of at most two pages resume where the previous one stopped
+	var progress []byte
+	steps, removed := 0, 0
+	for {
+		next, n, err := tbl.Versions.PruneFrom(bufmgr, txnID(4), progress, func(pages uint64) bool { return 2 <= pages })
+		if err != nil {
+			t.Fatal(err)
+		}
+		steps++
+		removed += n
+		if next == nil {
+			break
+		}
+		progress = next
+	}
+	if steps < 3 {
+		t.Errorf("expected the pass to take several steps, got %d", steps)
+	}
+	if removed != numRows/2 {
+		t.Errorf("expected %d versions to be pruned, got %d", numRows/2, removed)
+	}
+	if n, err := tbl.Versions.Prune(bufmgr, txnID(4)); err != nil || n != 0 {
+		t.Errorf("expected nothing left to prune, got %d, %v", n, err)
+	}
+	if n, err := tbl.Versions.Prune(bufmgr, txnID(6)); err != nil || n != numRows/2 {
+		t.Errorf("expected the other versions to remain, got %d, %v", n, err)
+	}
+}
@@ -0,0 +1,277 @@
+package table
+
+import (
+	"bytes"
+	"sync"
+
+	"github.com/Johniel/gorelly/btree"
+	"github.com/Johniel/gorelly/buffer"
+	"github.com/Johniel/gorelly/disk"
+	"github.com/Johniel/gorelly/tuple"
+)
+
+// ZoneMap keeps the minimum and maximum values of selected columns for every leaf page of a
+// table's primary tree, so that scans can skip the pages none of whose rows can satisfy a range
+// predicate (see query.SeqScan.Zones). This pays off when the values correlate with the primary
+// key, e.g. the timestamps of an append-ordered time series.
+//
+// The zone map is kept in memory. Set as a Table's Zones, it is updated with every change the
+// table makes to a leaf page, including the pages created by splits. Changes only widen the
+// zone of a page, so that rows restored by a rollback stay covered; Rebuild computes the exact
+// zones, e.g. after the table is opened or as part of its vacuum. Pages without a zone are never
+// skipped, but every change to the primary tree must be made through a Table with the zone map
+// for the zones of the other pages to stay correct.
+type ZoneMap struct {
+	metaPageID  disk.PageID
+	numKeyElems int
+	format      tuple.Format
+	columns     []int // Columns tracked, as indices into the rows of the table
+	zones       map[disk.PageID]*Zone
+	mu          sync.RWMutex
+}
+
+// Zone is the range of the values of the tracked columns on a leaf page.
+type Zone struct {
+	Rows    int          // Number of rows on the page when the zone was last computed
+	Columns []ColumnZone // Ranges of the tracked columns, in the order of ZoneMap.Columns
+}
+
+// ColumnZone is the range of the values of a column on a page.
+type ColumnZone struct {
+	Min []byte
+	Max []byte
+	// Some rows lack the column because they were written before it was added to the table,
+	// so they read as its default, which the range does not bound. Neither does it bound the
+	// non-key columns of rows whose value is stored in overflow pages.
+	Unbounded bool
+}
+
+// ColumnRange restricts the values of a column to a range; nil bounds are unbounded.
+type ColumnRange struct {
+	ColumnIndex int
+	Lo          []byte
+	LoInclusive bool
+	Hi          []byte
+	HiInclusive bool
+}
+
+// NewZoneMap creates an empty zone map of the given columns of t; set it as t.Zones and
+// Rebuild it if t already has rows.
+func NewZoneMap(t *Table, columns ...int) *ZoneMap {
+	return &ZoneMap{
+		metaPageID:  t.MetaPageID,
+		numKeyElems: t.NumKeyElems,
+		format:      t.Format,
+		columns:     columns,
+		zones:       make(map[disk.PageID]*Zone),
+	}
+}
+
+// Columns returns the tracked columns.
+func (zm *ZoneMap) Columns() []int {
+	return zm.columns
+}
+
+// Zone returns the zone of a leaf page, or nil if the page has none.
+func (zm *ZoneMap) Zone(pageID disk.PageID) *Zone {
+	zm.mu.RLock()
+	defer zm.mu.RUnlock()
+	return zm.zones[pageID]
+}
+
+// MayMatch reports whether a row of the leaf page may have its columns in every range.
+// It is false only if the page has a zone that excludes one of the ranges; ranges on columns
+// that are not tracked are ignored.
+func (zm *ZoneMap) MayMatch(pageID disk.PageID, ranges []ColumnRange) bool {
+	zone := zm.Zone(pageID)
+	if zone == nil {
+		return true
+	}
+	if zone.Rows == 0 {
+		return false
+	}
+	for _, r := range ranges {
+		for i, colIdx := range zm.columns {
+			if colIdx == r.ColumnIndex && !zone.Columns[i].overlaps(r) {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+func (cz *ColumnZone) overlaps(r ColumnRange) bool {
+	if cz.Unbounded {
+		return true
+	}
+	if r.Lo != nil {
+		c := bytes.Compare(cz.Max, r.Lo)
+		if c < 0 || (c == 0 && !r.LoInclusive) {
+			return false
+		}
+	}
+	if r.Hi != nil {
+		c := bytes.Compare(cz.Min, r.Hi)
+		if 0 < c || (c == 0 && !r.HiInclusive) {
+			return false
+		}
+	}
+	return true
+}
+
+// Rebuild recomputes the zones of every leaf page from its rows.
+// The table must not be changed while it runs.
+func (zm *ZoneMap) Rebuild(bufmgr *buffer.BufferPoolManager) error {
+	iter, err := btree.NewBTree(zm.metaPageID).Search(bufmgr, btree.NewSearchModeStart())
+	if err != nil {
+		return err
+	}
+	zones := make(map[disk.PageID]*Zone)
+	for {
+		pageID := iter.PageID()
+		key, value, ok, err := iter.Next(bufmgr)
+		if err != nil {
+			return err
+		}
+		if !ok {
+			break
+		}
+		zone := zones[pageID]
+		if zone == nil {
+			zone = zm.newZone()
+			zones[pageID] = zone
+		}
+		zm.addRow(zone, key, value)
+	}
+	zm.mu.Lock()
+	defer zm.mu.Unlock()
+	zm.zones = zones
+	return nil
+}
+
+func (zm *ZoneMap) newZone() *Zone {
+	return &Zone{Columns: make([]ColumnZone, len(zm.columns))}
+}
+
+// addRow widens zone to cover the row stored with the given key and value.
+func (zm *ZoneMap) addRow(zone *Zone, key []byte, value []byte) {
+	row := make([][]byte, 0, zm.numKeyElems)
+	tuple.Decode(key, &row)
+	zm.format.DecodeValue(value, &row)
+	for i, colIdx := range zm.columns {
+		cz := &zone.Columns[i]
+		if len(row) <= colIdx {
+			cz.Unbounded = true
+			continue
+		}
+		// The row may point into the page, so the bounds are copied
+		v := row[colIdx]
+		if zone.Rows == 0 || bytes.Compare(v, cz.Min) < 0 {
+			cz.Min = bytes.Clone(v)
+		}
+		if zone.Rows == 0 || 0 < bytes.Compare(v, cz.Max) {
+			cz.Max = bytes.Clone(v)
+		}
+	}
+	zone.Rows++
+}
+
+// widen returns a zone covering the ranges of both zone and other. The zones handed out by
+// ZoneMap.Zone are never modified.
+func (zone *Zone) widen(other *Zone) *Zone {
+	if zone.Rows == 0 {
+		return other
+	}
+	if other.Rows == 0 {
+		return zone
+	}
+	widened := &Zone{Rows: other.Rows, Columns: make([]ColumnZone, len(zone.Columns))}
+	for i, cz := range zone.Columns {
+		oz := other.Columns[i]
+		widened.Columns[i] = ColumnZone{Min: cz.Min, Max: cz.Max, Unbounded: cz.Unbounded || oz.Unbounded}
+		if bytes.Compare(oz.Min, cz.Min) < 0 {
+			widened.Columns[i].Min = oz.Min
+		}
+		if 0 < bytes.Compare(oz.Max, cz.Max) {
+			widened.Columns[i].Max = oz.Max
+		}
+	}
+	return widened
+}
+
+// update records the content of a changed page of the primary tree. before is nil for a page
+// created by the change, whose zone replaces the one of a page with the same ID freed earlier.
+func (zm *ZoneMap) update(pageID disk.PageID, before []byte, after []byte) {
+	node := btree.NewNode(after)
+	if !node.IsLeaf() {
+		zm.drop(pageID)
+		return
+	}
+	leaf := node.AsLeaf()
+	zone := zm.newZone()
+	for slotID := 0; slotID < leaf.NumPairs(); slotID++ {
+		pair := leaf.PairAt(slotID)
+		if pair.Overflow {
+			// The value is in overflow pages: only the key columns are bounded
+			zm.addRow(zone, pair.Key, nil)
+			continue
+		}
+		zm.addRow(zone, pair.Key, pair.Value)
+	}
+
+	zm.mu.Lock()
+	defer zm.mu.Unlock()
+	if current, ok := zm.zones[pageID]; ok && before != nil {
+		zone = current.widen(zone)
+	}
+	zm.zones[pageID] = zone
+}
+
+// drop removes the zone of a page that was freed or is no longer a leaf.
+func (zm *ZoneMap) drop(pageID disk.PageID) {
+	zm.mu.Lock()
+	defer zm.mu.Unlock()
+	delete(zm.zones, pageID)
+}
+
+// zoneLog passes the changes to the primary tree on to the zone map and to the table's log.
+type zoneLog struct {
+	log   btree.PageLog // The table's log (nil if the changes are not logged)
+	zones *ZoneMap
+}
+
+func (zl *zoneLog) LogPage(pageID disk.PageID, before []byte, after []byte) (uint64, error) {
+	zl.zones.update(pageID, before, after)
+	if zl.log == nil {
+		return 0, nil
+	}
+	return zl.log.LogPage(pageID, before, after)
+}
+
+func (zl *zoneLog) LogFree(pageID disk.PageID) {
+	zl.zones.drop(pageID)
+	if zl.log != nil {
+		zl.log.LogFree(pageID)
+	}
+}
+
+// LockPage, WaitPage and DefersFree make the zone log a btree.PageLocker that passes on to the
+// table's log.
+func (zl *zoneLog) LockPage(pageID disk.PageID) error {
+	if locker, ok := zl.log.(btree.PageLocker); ok {
+		return locker.LockPage(pageID)
+	}
+	return nil
+}
+
+func (zl *zoneLog) WaitPage(pageID disk.PageID) error {
+	if locker, ok := zl.log.(btree.PageLocker); ok {
+		return locker.WaitPage(pageID)
+	}
+	return nil
+}
+
+func (zl *zoneLog) DefersFree() bool {
+	locker, ok := zl.log.(btree.PageLocker)
+	return ok && locker.DefersFree()
+}
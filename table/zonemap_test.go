@@ -0,0 +1,126 @@
+package table
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"testing"
+
+	"github.com/Johniel/gorelly/btree"
+	"github.com/Johniel/gorelly/buffer"
+	"github.com/Johniel/gorelly/disk"
+)
+
+func TestZoneMap(t *testing.T) {
+	tmpfile, err := os.CreateTemp("", "test_zone_map_*.db")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(tmpfile.Name())
+
+	dm, err := disk.NewDiskManager(tmpfile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer dm.Close()
+
+	pool := buffer.NewBufferPool(10)
+	bufmgr := buffer.NewBufferPoolManager(dm, pool)
+
+	tbl := &Table{
+		MetaPageID:  disk.InvalidPageID,
+		NumKeyElems: 1, // (id, ts, payload)
+	}
+	if err := tbl.Create(bufmgr); err != nil {
+		t.Fatal(err)
+	}
+	tbl.Zones = NewZoneMap(tbl, 1)
+	const numRows = 1000
+	ts := func(i int) []byte { return []byte(fmt.Sprintf("%06d", i*10)) }
+	for i := 0; i < numRows; i++ {
+		if err := tbl.Insert(bufmgr, [][]byte{[]byte(fmt.Sprintf("%04d", i)), ts(i), bytes.Repeat([]byte("x"), 40)}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	leaves := func() []disk.PageID {
+		t.Helper()
+		iter, err := btree.NewBTree(tbl.MetaPageID).Search(bufmgr, btree.NewSearchModeStart())
+		if err != nil {
+			t.Fatal(err)
+		}
+		var pageIDs []disk.PageID
+		for {
+			pageID := iter.PageID()
+			_, _, ok, err := iter.Next(bufmgr)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !ok {
+				return pageIDs
+			}
+			if len(pageIDs) == 0 || pageIDs[len(pageIDs)-1] != pageID {
+				pageIDs = append(pageIDs, pageID)
+			}
+		}
+	}
+	matching := func(ranges ...ColumnRange) int {
+		t.Helper()
+		n := 0
+		for _, pageID := range leaves() {
+			if tbl.Zones.MayMatch(pageID, ranges) {
+				n++
+			}
+		}
+		return n
+	}
+
+	// Every leaf, including those created by splits, has a zone
+	pageIDs := leaves()
+	if len(pageIDs) < 4 {
+		t.Fatalf("expected the rows to span several leaves, got %d", len(pageIDs))
+	}
+	for _, pageID := range pageIDs {
+		if tbl.Zones.Zone(pageID) == nil {
+			t.Fatalf("leaf %d has no zone", pageID)
+		}
+	}
+
+	// Only the pages that may hold the range are kept. A split leaves the zone of the split page
+	// covering the rows it moved out, so a few more than the exact pages match.
+	narrow := ColumnRange{ColumnIndex: 1, Lo: ts(500), LoInclusive: true, Hi: ts(510), HiInclusive: true}
+	if n := matching(narrow); n < 1 || len(pageIDs)/4 < n {
+		t.Errorf("expected a few leaves to match a narrow range, got %d of %d", n, len(pageIDs))
+	}
+	if n := matching(ColumnRange{ColumnIndex: 1, Lo: ts(numRows)}); n != 0 {
+		t.Errorf("expected no leaf to match a range past the last value, got %d", n)
+	}
+	if n := matching(ColumnRange{ColumnIndex: 2, Lo: []byte("y")}); n != len(pageIDs) {
+		t.Errorf("expected ranges on untracked columns to be ignored, got %d of %d leaves", n, len(pageIDs))
+	}
+
+	// Changes only widen the zones, so the page still matches its old values
+	if err := tbl.Update(bufmgr, [][]byte{[]byte("0500"), []byte("999999"), []byte("x")}); err != nil {
+		t.Fatal(err)
+	}
+	if n := matching(ColumnRange{ColumnIndex: 1, Lo: []byte("999999"), LoInclusive: true}); n != 1 {
+		t.Errorf("expected the updated leaf to match its new value, got %d leaves", n)
+	}
+	if n := matching(narrow); n < 1 {
+		t.Errorf("expected the updated leaf to still match its old values")
+	}
+
+	// Rebuild computes the exact zones
+	if err := tbl.Delete(bufmgr, [][]byte{[]byte("0500"), []byte("999999"), []byte("x")}); err != nil {
+		t.Fatal(err)
+	}
+	if err := tbl.Zones.Rebuild(bufmgr); err != nil {
+		t.Fatal(err)
+	}
+	if n := matching(ColumnRange{ColumnIndex: 1, Lo: []byte("999999"), LoInclusive: true}); n != 0 {
+		t.Errorf("expected no leaf to match the deleted value after Rebuild, got %d", n)
+	}
+	if n := matching(narrow); n < 1 || 2 < n {
+		t.Errorf("expected 1 or 2 leaves to match a narrow range after Rebuild, got %d", n)
+	}
+}
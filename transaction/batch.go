@@ -0,0 +1,113 @@
+package transaction
+
+import (
+	"errors"
+
+	"github.com/Johniel/gorelly/buffer"
+	"github.com/Johniel/gorelly/errcode"
+	"github.com/Johniel/gorelly/table"
+	"github.com/Johniel/gorelly/tuple"
+)
+
+var (
+	// ErrInvalidBatchOp is returned by ApplyBatch for an operation without a table or tuple.
+	ErrInvalidBatchOp = errcode.New(errcode.InvalidParameter, "invalid batch operation")
+	// ErrBatchUndoFailed is returned with the original error when a failed batch could not be undone.
+	ErrBatchUndoFailed = errcode.New(errcode.Internal, "failed to undo a partially applied batch")
+)
+
+// BatchOpKind is the kind of change of a BatchOp.
+type BatchOpKind int
+
+const (
+	BatchInsert BatchOpKind = iota
+	BatchUpdate
+	BatchDelete
+)
+
+// BatchOp is one change applied by ApplyBatch.
+type BatchOp struct {
+	Kind  BatchOpKind
+	Table *table.Table
+	Tuple [][]byte // Full tuple for inserts and updates; at least the primary key for deletes
+}
+
+// ApplyBatch applies the operations in order, possibly across several tables, as a single
+// transaction: either all of them take effect, or none does and the error of the failing
+// operation is returned.
+//
+// If the manager has a LockManager, every operation first takes an exclusive lock on its key
+// (inserts also lock the gap they insert into, see LockInsert); all locks are held until the
+// batch commits or aborts. When an operation fails, the operations already applied are undone
+// in reverse order before the transaction is aborted. Undo bypasses the tables' Access and
+// LockInsert hooks, since it only restores rows the batch itself changed.
+func (tm *TransactionManager) ApplyBatch(bufmgr *buffer.BufferPoolManager, ops []BatchOp) error {
+	txn := tm.Begin()
+	var undos []func() error
+	for _, op := range ops {
+		undo, err := tm.applyBatchOp(bufmgr, txn, op)
+		if err != nil {
+			for i := len(undos) - 1; 0 <= i; i-- {
+				if undoErr := undos[i](); undoErr != nil {
+					tm.Abort(txn)
+					return errors.Join(err, ErrBatchUndoFailed, undoErr)
+				}
+			}
+			tm.Abort(txn)
+			return err
+		}
+		undos = append(undos, undo)
+	}
+	return tm.Commit(txn)
+}
+
+// applyBatchOp locks and applies one operation and returns the function that undoes it.
+func (tm *TransactionManager) applyBatchOp(bufmgr *buffer.BufferPoolManager, txn *Transaction, op BatchOp) (func() error, error) {
+	if op.Table == nil || len(op.Tuple) < op.Table.NumKeyElems {
+		return nil, ErrInvalidBatchOp
+	}
+	keyBytes := make([]byte, 0)
+	tuple.Encode(op.Tuple[:op.Table.NumKeyElems], &keyBytes)
+	if tm.lockManager != nil {
+		var err error
+		if op.Kind == BatchInsert {
+			err = tm.lockManager.LockInsert(txn, bufmgr, op.Table.MetaPageID, keyBytes)
+		} else {
+			err = tm.lockManager.LockKeyExclusive(txn, KeyLock{TreeMetaPageID: op.Table.MetaPageID, Key: string(keyBytes)})
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	undoTable := *op.Table
+	undoTable.Access = nil
+	undoTable.LockInsert = nil
+	switch op.Kind {
+	case BatchInsert:
+		if err := op.Table.Insert(bufmgr, op.Tuple); err != nil {
+			return nil, err
+		}
+		return func() error { return undoTable.Delete(bufmgr, op.Tuple) }, nil
+	case BatchUpdate:
+		oldTuple, err := op.Table.Get(bufmgr, op.Tuple)
+		if err != nil {
+			return nil, err
+		}
+		if err := op.Table.Update(bufmgr, op.Tuple); err != nil {
+			return nil, err
+		}
+		return func() error { return undoTable.Update(bufmgr, oldTuple) }, nil
+	case BatchDelete:
+		oldTuple, err := op.Table.Get(bufmgr, op.Tuple)
+		if err != nil {
+			return nil, err
+		}
+		if err := op.Table.Delete(bufmgr, op.Tuple); err != nil {
+			return nil, err
+		}
+		return func() error { return undoTable.Insert(bufmgr, oldTuple) }, nil
+	default:
+		return nil, ErrInvalidBatchOp
+	}
+}
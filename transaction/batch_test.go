@@ -0,0 +1,131 @@
+package transaction
+
+import (
+	"errors"
+	"os"
+	"testing"
+
+	"github.com/Johniel/gorelly/btree"
+	"github.com/Johniel/gorelly/buffer"
+	"github.com/Johniel/gorelly/disk"
+	"github.com/Johniel/gorelly/table"
+	"github.com/Johniel/gorelly/tuple"
+)
+
+func TestApplyBatch(t *testing.T) {
+	tmpfile, err := os.CreateTemp("", "test_apply_batch_*.db")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(tmpfile.Name())
+
+	dm, err := disk.NewDiskManager(tmpfile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer dm.Close()
+
+	pool := buffer.NewBufferPool(10)
+	bufmgr := buffer.NewBufferPoolManager(dm, pool)
+
+	// users: [id (PK), email (unique)]; balances: [id (PK), amount]
+	users := &table.Table{NumKeyElems: 1, UniqueIndices: []*table.UniqueIndex{{Skey: []int{1}}}}
+	if err := users.Create(bufmgr); err != nil {
+		t.Fatal(err)
+	}
+	balances := &table.Table{NumKeyElems: 1}
+	if err := balances.Create(bufmgr); err != nil {
+		t.Fatal(err)
+	}
+	if err := users.Insert(bufmgr, [][]byte{[]byte("alice"), []byte("alice@example.com")}); err != nil {
+		t.Fatal(err)
+	}
+	if err := balances.Insert(bufmgr, [][]byte{[]byte("alice"), []byte("100")}); err != nil {
+		t.Fatal(err)
+	}
+
+	lm := NewLockManager()
+	tm := NewTransactionManagerWithManagers(nil, lm, nil)
+
+	expectRow := func(t *testing.T, tbl *table.Table, key string, expected string) {
+		t.Helper()
+		tup, err := tbl.Get(bufmgr, [][]byte{[]byte(key)})
+		if expected == "" {
+			if err != btree.ErrKeyNotFound {
+				t.Errorf("expected %s to be absent, got %q (%v)", key, tup, err)
+			}
+			return
+		}
+		if err != nil {
+			t.Fatalf("%s: %v", key, err)
+		}
+		if string(tup[1]) != expected {
+			t.Errorf("%s: expected %q, got %q", key, expected, tup[1])
+		}
+	}
+	expectUnlocked := func(t *testing.T, tbl *table.Table, key string) {
+		t.Helper()
+		keyBytes := make([]byte, 0)
+		tuple.Encode([][]byte{[]byte(key)}, &keyBytes)
+		txn := tm.Begin()
+		defer lm.UnlockAll(txn)
+		if err := lm.LockKeyExclusive(txn, KeyLock{TreeMetaPageID: tbl.MetaPageID, Key: string(keyBytes)}); err != nil {
+			t.Errorf("expected the lock on %s to be released, got %v", key, err)
+		}
+	}
+
+	t.Run("Commit", func(t *testing.T) {
+		err := tm.ApplyBatch(bufmgr, []BatchOp{
+			{Kind: BatchInsert, Table: users, Tuple: [][]byte{[]byte("bob"), []byte("bob@example.com")}},
+			{Kind: BatchInsert, Table: balances, Tuple: [][]byte{[]byte("bob"), []byte("50")}},
+			{Kind: BatchUpdate, Table: balances, Tuple: [][]byte{[]byte("alice"), []byte("50")}},
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		expectRow(t, users, "bob", "bob@example.com")
+		expectRow(t, balances, "bob", "50")
+		expectRow(t, balances, "alice", "50")
+		expectUnlocked(t, balances, "alice")
+	})
+
+	t.Run("Rollback", func(t *testing.T) {
+		err := tm.ApplyBatch(bufmgr, []BatchOp{
+			{Kind: BatchInsert, Table: balances, Tuple: [][]byte{[]byte("carol"), []byte("10")}},
+			{Kind: BatchUpdate, Table: balances, Tuple: [][]byte{[]byte("alice"), []byte("0")}},
+			{Kind: BatchDelete, Table: users, Tuple: [][]byte{[]byte("bob")}},
+			{Kind: BatchDelete, Table: balances, Tuple: [][]byte{[]byte("bob")}},
+			// Violates the unique email of alice
+			{Kind: BatchInsert, Table: users, Tuple: [][]byte{[]byte("carol"), []byte("alice@example.com")}},
+		})
+		if !errors.Is(err, btree.ErrDuplicateKey) {
+			t.Fatalf("expected ErrDuplicateKey, got %v", err)
+		}
+		expectRow(t, balances, "carol", "")
+		expectRow(t, users, "carol", "")
+		expectRow(t, balances, "alice", "50")
+		expectRow(t, users, "bob", "bob@example.com")
+		expectRow(t, balances, "bob", "50")
+		expectUnlocked(t, users, "bob")
+		expectUnlocked(t, balances, "alice")
+
+		// The unique index entries of the restored and failed rows are consistent
+		if err := users.Insert(bufmgr, [][]byte{[]byte("dave"), []byte("bob@example.com")}); !errors.Is(err, btree.ErrDuplicateKey) {
+			t.Errorf("expected bob's email to be indexed again, got %v", err)
+		}
+		if err := users.Insert(bufmgr, [][]byte{[]byte("carol"), []byte("carol@example.com")}); err != nil {
+			t.Errorf("expected carol to be insertable, got %v", err)
+		}
+	})
+
+	t.Run("InvalidOp", func(t *testing.T) {
+		err := tm.ApplyBatch(bufmgr, []BatchOp{
+			{Kind: BatchUpdate, Table: balances, Tuple: [][]byte{[]byte("alice"), []byte("1")}},
+			{Kind: BatchDelete, Table: nil, Tuple: [][]byte{[]byte("alice")}},
+		})
+		if !errors.Is(err, ErrInvalidBatchOp) {
+			t.Fatalf("expected ErrInvalidBatchOp, got %v", err)
+		}
+		expectRow(t, balances, "alice", "50")
+	})
+}
@@ -0,0 +1,243 @@
+package transaction
+
+import (
+	"bytes"
+	"compress/flate"
+	"encoding/binary"
+	"io"
+
+	"github.com/Johniel/gorelly/btree"
+	"github.com/Johniel/gorelly/buffer"
+	"github.com/Johniel/gorelly/disk"
+	"github.com/Johniel/gorelly/errcode"
+	"github.com/Johniel/gorelly/table"
+	"github.com/Johniel/gorelly/tuple"
+)
+
+var (
+	// ErrInvalidChangeBatch is returned when decoding bytes that are not an encoded ChangeBatch.
+	ErrInvalidChangeBatch = errcode.New(errcode.DataException, "invalid change batch")
+	// ErrAckBeyondDelivered is returned when acknowledging an LSN that has not been delivered yet.
+	ErrAckBeyondDelivered = errcode.New(errcode.InvalidParameter, "acknowledged LSN has not been delivered")
+)
+
+const (
+	changeBatchHeaderSize = 1 + 4 + 8 + 8 // flags, record count, first LSN, last LSN
+	changeBatchCompressed = 1 << 0
+)
+
+// ChangeBatch is a run of consecutive log records delivered to a change data capture consumer.
+type ChangeBatch struct {
+	FirstLSN uint64
+	LastLSN  uint64
+	Records  []*LogRecord
+}
+
+// Encode serializes the batch for transfer, optionally compressing the records with DEFLATE.
+// The header (flags, record count, first and last LSN) is never compressed so that
+// consumers can route and deduplicate batches without decoding them.
+func (cb *ChangeBatch) Encode(compress bool) ([]byte, error) {
+	var payload []byte
+	for _, record := range cb.Records {
+		payload = append(payload, serializeRecord(record)...)
+	}
+
+	header := make([]byte, changeBatchHeaderSize)
+	binary.BigEndian.PutUint32(header[1:], uint32(len(cb.Records)))
+	binary.BigEndian.PutUint64(header[5:], cb.FirstLSN)
+	binary.BigEndian.PutUint64(header[13:], cb.LastLSN)
+	if !compress {
+		return append(header, payload...), nil
+	}
+
+	header[0] |= changeBatchCompressed
+	buf := bytes.NewBuffer(header)
+	w, err := flate.NewWriter(buf, flate.DefaultCompression)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := w.Write(payload); err != nil {
+		return nil, err
+	}
+	if err := w.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// DecodeChangeBatch deserializes a batch produced by ChangeBatch.Encode.
+func DecodeChangeBatch(data []byte) (*ChangeBatch, error) {
+	if len(data) < changeBatchHeaderSize {
+		return nil, ErrInvalidChangeBatch
+	}
+	flags := data[0]
+	count := int(binary.BigEndian.Uint32(data[1:]))
+	batch := &ChangeBatch{
+		FirstLSN: binary.BigEndian.Uint64(data[5:]),
+		LastLSN:  binary.BigEndian.Uint64(data[13:]),
+	}
+
+	payload := data[changeBatchHeaderSize:]
+	if flags&changeBatchCompressed != 0 {
+		var err error
+		payload, err = io.ReadAll(flate.NewReader(bytes.NewReader(payload)))
+		if err != nil {
+			return nil, ErrInvalidChangeBatch
+		}
+	}
+	for i := 0; i < count; i++ {
+		// Each record is [LSN (8B)][size (4B)][body]
+		if len(payload) < 12 {
+			return nil, ErrInvalidChangeBatch
+		}
+		lsn := binary.BigEndian.Uint64(payload)
+		size := int(binary.BigEndian.Uint32(payload[8:]))
+		if len(payload) < 12+size {
+			return nil, ErrInvalidChangeBatch
+		}
+		record, err := deserializeRecord(lsn, payload[12:12+size])
+		if err != nil {
+			return nil, ErrInvalidChangeBatch
+		}
+		batch.Records = append(batch.Records, record)
+		payload = payload[12+size:]
+	}
+	if len(payload) != 0 {
+		return nil, ErrInvalidChangeBatch
+	}
+	return batch, nil
+}
+
+// CDCOffsets is a system table holding the last LSN each CDC consumer has committed.
+// Schema: [subscription_name (PK), committed_lsn]
+type CDCOffsets struct {
+	MetaPageID disk.PageID // Page ID of the B+ tree meta page of the offsets table
+}
+
+func (co *CDCOffsets) Create(bufmgr *buffer.BufferPoolManager) error {
+	st := &table.SimpleTable{NumKeyElems: 1}
+	if err := st.Create(bufmgr); err != nil {
+		return err
+	}
+	co.MetaPageID = st.MetaPageID
+	return nil
+}
+
+// Load returns the committed LSN of a subscription, or 0 if it never committed one.
+func (co *CDCOffsets) Load(bufmgr *buffer.BufferPoolManager, name string) (uint64, error) {
+	keyBytes := make([]byte, 0)
+	tuple.Encode([][]byte{[]byte(name)}, &keyBytes)
+
+	bt := btree.NewBTree(co.MetaPageID)
+	iter, err := bt.Search(bufmgr, btree.NewSearchModeKey(keyBytes))
+	if err != nil {
+		return 0, err
+	}
+	defer iter.Close()
+	foundKey, valueBytes, ok, err := iter.Next(bufmgr)
+	if err != nil {
+		return 0, err
+	}
+	if !ok || !bytes.Equal(foundKey, keyBytes) {
+		return 0, nil
+	}
+	var value [][]byte
+	tuple.Decode(valueBytes, &value)
+	return binary.BigEndian.Uint64(value[0]), nil
+}
+
+// Store records the committed LSN of a subscription.
+func (co *CDCOffsets) Store(bufmgr *buffer.BufferPoolManager, name string, lsn uint64) error {
+	lsnBytes := make([]byte, 8)
+	binary.BigEndian.PutUint64(lsnBytes, lsn)
+	st := &table.SimpleTable{MetaPageID: co.MetaPageID, NumKeyElems: 1}
+	tup := [][]byte{[]byte(name), lsnBytes}
+	if err := st.Update(bufmgr, tup); err != btree.ErrKeyNotFound {
+		return err
+	}
+	return st.Insert(bufmgr, tup)
+}
+
+// Subscription tails the write-ahead log for a change data capture consumer.
+// Records are delivered in LSN order in batches of at most BatchSize records.
+// The consumer acknowledges the last LSN it has durably applied with Ack; the LSN is
+// persisted in CDCOffsets, so a subscription reopened after a restart resumes right after
+// it and the consumer neither loses nor receives again records it has committed.
+type Subscription struct {
+	Name      string // Subscription name, the key in CDCOffsets
+	BatchSize int    // Maximum number of records per batch (0 means unlimited)
+	log       *LogManager
+	offsets   *CDCOffsets
+	bufmgr    *buffer.BufferPoolManager
+	delivered uint64 // LSN of the last delivered record
+	committed uint64 // LSN of the last acknowledged record
+}
+
+// Subscribe opens the subscription called name, resuming after its committed LSN.
+func Subscribe(bufmgr *buffer.BufferPoolManager, log *LogManager, offsets *CDCOffsets, name string, batchSize int) (*Subscription, error) {
+	committed, err := offsets.Load(bufmgr, name)
+	if err != nil {
+		return nil, err
+	}
+	return &Subscription{
+		Name:      name,
+		BatchSize: batchSize,
+		log:       log,
+		offsets:   offsets,
+		bufmgr:    bufmgr,
+		delivered: committed,
+		committed: committed,
+	}, nil
+}
+
+// Next returns the records appended after the last delivered one, or nil if there are none.
+func (s *Subscription) Next() (*ChangeBatch, error) {
+	records, err := s.log.ReadLog()
+	if err != nil {
+		return nil, err
+	}
+	var batch *ChangeBatch
+	for _, record := range records {
+		if record.LSN <= s.delivered {
+			continue
+		}
+		if batch == nil {
+			batch = &ChangeBatch{FirstLSN: record.LSN}
+		}
+		batch.Records = append(batch.Records, record)
+		batch.LastLSN = record.LSN
+		if 0 < s.BatchSize && s.BatchSize <= len(batch.Records) {
+			break
+		}
+	}
+	if batch != nil {
+		s.delivered = batch.LastLSN
+	}
+	return batch, nil
+}
+
+// Ack persists lsn as the last LSN the consumer has durably applied.
+func (s *Subscription) Ack(lsn uint64) error {
+	if s.delivered < lsn {
+		return ErrAckBeyondDelivered
+	}
+	if lsn <= s.committed {
+		return nil
+	}
+	if err := s.offsets.Store(s.bufmgr, s.Name, lsn); err != nil {
+		return err
+	}
+	s.committed = lsn
+	return nil
+}
+
+// Rewind makes the next call to Next redeliver every record after the committed LSN,
+// e.g. after the consumer lost batches it had not acknowledged.
+func (s *Subscription) Rewind() {
+	s.delivered = s.committed
+}
+
+// Committed returns the LSN last acknowledged by the consumer.
+func (s *Subscription) Committed() uint64 {
+	return s.committed
+}
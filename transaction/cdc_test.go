@@ -0,0 +1,176 @@
+package transaction
+
+import (
+	"bytes"
+	"os"
+	"reflect"
+	"testing"
+
+	"github.com/Johniel/gorelly/buffer"
+	"github.com/Johniel/gorelly/disk"
+)
+
+func TestChangeBatchEncode(t *testing.T) {
+	batch := &ChangeBatch{FirstLSN: 3, LastLSN: 4}
+	for i := 0; i < 2; i++ {
+		batch.Records = append(batch.Records, &LogRecord{
+			Type:     LogRecordTypeUpdate,
+			TxnID:    1,
+			PageID:   disk.PageID(i),
+			Offset:   8,
+			OldValue: bytes.Repeat([]byte{0}, 256),
+			NewValue: bytes.Repeat([]byte("abcd"), 64),
+			LSN:      uint64(3 + i),
+		})
+	}
+
+	plain, err := batch.Encode(false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	compressed, err := batch.Encode(true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(plain) <= len(compressed) {
+		t.Errorf("expected compression to shrink the batch: %d -> %d bytes", len(plain), len(compressed))
+	}
+
+	for _, data := range [][]byte{plain, compressed} {
+		decoded, err := DecodeChangeBatch(data)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(decoded, batch) {
+			t.Errorf("expected %+v, got %+v", batch, decoded)
+		}
+	}
+
+	if _, err := DecodeChangeBatch(plain[:len(plain)-1]); err != ErrInvalidChangeBatch {
+		t.Errorf("expected ErrInvalidChangeBatch, got %v", err)
+	}
+}
+
+func TestSubscription(t *testing.T) {
+	dbfile, err := os.CreateTemp("", "test_cdc_*.db")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(dbfile.Name())
+
+	dm, err := disk.NewDiskManager(dbfile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer dm.Close()
+
+	pool := buffer.NewBufferPool(10)
+	bufmgr := buffer.NewBufferPoolManager(dm, pool)
+
+	logfile, err := os.CreateTemp("", "test_cdc_*.log")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(logfile.Name())
+	logfile.Close()
+
+	lm, err := NewLogManager(logfile.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer lm.Close()
+
+	appendRecords := func(n int) {
+		for i := 0; i < n; i++ {
+			if err := lm.AppendLog(&LogRecord{Type: LogRecordTypeUpdate, TxnID: 1, NewValue: []byte{byte(i)}}); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	lsns := func(batch *ChangeBatch) []uint64 {
+		if batch == nil {
+			return nil
+		}
+		var result []uint64
+		for _, record := range batch.Records {
+			result = append(result, record.LSN)
+		}
+		return result
+	}
+
+	offsets := &CDCOffsets{}
+	if err := offsets.Create(bufmgr); err != nil {
+		t.Fatal(err)
+	}
+	sub, err := Subscribe(bufmgr, lm, offsets, "replica", 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	appendRecords(5)
+	t.Run("Batching", func(t *testing.T) {
+		for _, expected := range [][]uint64{{1, 2}, {3, 4}, {5}, nil} {
+			batch, err := sub.Next()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(lsns(batch), expected) {
+				t.Errorf("expected %v, got %v", expected, lsns(batch))
+			}
+		}
+	})
+
+	t.Run("Ack", func(t *testing.T) {
+		if err := sub.Ack(6); err != ErrAckBeyondDelivered {
+			t.Errorf("expected ErrAckBeyondDelivered, got %v", err)
+		}
+		if err := sub.Ack(4); err != nil {
+			t.Fatal(err)
+		}
+		sub.Rewind()
+		batch, err := sub.Next()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(lsns(batch), []uint64{5}) {
+			t.Errorf("expected [5] after rewind, got %v", lsns(batch))
+		}
+	})
+
+	t.Run("Resume", func(t *testing.T) {
+		appendRecords(1)
+		// Reopening the subscription (e.g. after a restart) resumes after the committed LSN
+		resumed, err := Subscribe(bufmgr, lm, offsets, "replica", 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if resumed.Committed() != 4 {
+			t.Errorf("expected committed LSN 4, got %d", resumed.Committed())
+		}
+		batch, err := resumed.Next()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(lsns(batch), []uint64{5, 6}) {
+			t.Errorf("expected [5 6], got %v", lsns(batch))
+		}
+		if err := resumed.Ack(6); err != nil {
+			t.Fatal(err)
+		}
+
+		other, err := Subscribe(bufmgr, lm, offsets, "analytics", 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if other.Committed() != 0 {
+			t.Errorf("expected a new subscription to start from 0, got %d", other.Committed())
+		}
+		committed, err := offsets.Load(bufmgr, "replica")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if committed != 6 {
+			t.Errorf("expected committed LSN 6, got %d", committed)
+		}
+	})
+}
@@ -0,0 +1,154 @@
+package transaction
+
+import (
+	"encoding/binary"
+	"slices"
+
+	"github.com/Johniel/gorelly/buffer"
+	"github.com/Johniel/gorelly/disk"
+)
+
+// CheckpointData is the content of a checkpoint record.
+type CheckpointData struct {
+	// Records with smaller LSNs changed only pages that were written back by the checkpoint,
+	// so Recover does not redo them.
+	RedoLSN uint64
+	// Offset in the log file Recover reads from: the earlier of the first record with RedoLSN
+	// and the Begin record of the oldest transaction active at the checkpoint.
+	StartOffset int64
+	// Transactions active at the checkpoint, with the LSNs of their Begin records.
+	ActiveTxns map[TransactionID]uint64
+	// Pages changed during the checkpoint and still dirty at its end, with the LSNs of their
+	// last logged changes.
+	DirtyPages map[disk.PageID]uint64
+	// Transaction ID after the greatest one in the log at the checkpoint, so that IDs keep
+	// increasing after the records before the checkpoint are truncated. 0 if not recorded.
+	NextTxnID TransactionID
+}
+
+// Checkpoint writes back the dirty pages of bufmgr and appends a durable checkpoint record, so
+// that Recover reads the log only from the checkpoint, or from the Begin record of the oldest
+// transaction still active at the checkpoint, instead of from the start.
+// Writers may keep running during the checkpoint.
+func (lm *LogManager) Checkpoint(bufmgr *buffer.BufferPoolManager) error {
+	lm.mu.Lock()
+	redoLSN := lm.nextLSN
+	startOffset := lm.files.end()
+	lm.mu.Unlock()
+
+	// Changes logged from here on are redone, whether or not the flush writes their pages
+	if err := bufmgr.Flush(); err != nil {
+		return err
+	}
+	dirtyPages := bufmgr.DirtyPages()
+
+	lm.mu.Lock()
+	defer lm.mu.Unlock()
+	data := &CheckpointData{
+		RedoLSN:    redoLSN,
+		ActiveTxns: make(map[TransactionID]uint64, len(lm.active)),
+		DirtyPages: dirtyPages,
+		NextTxnID:  lm.lastTxnID + 1,
+	}
+	for txnID, txn := range lm.active {
+		data.ActiveTxns[txnID] = txn.beginLSN
+		startOffset = min(startOffset, txn.beginOffset)
+	}
+	data.StartOffset = startOffset
+	if err := lm.append(&LogRecord{Type: LogRecordTypeCheckpoint, Checkpoint: data}); err != nil {
+		return err
+	}
+	return lm.sync()
+}
+
+// readRecoveryLog reads the log from where Recover has to start, according to the last checkpoint.
+func (lm *LogManager) readRecoveryLog() ([]*LogRecord, error) {
+	lm.mu.Lock()
+	defer lm.mu.Unlock()
+	return lm.readLogFrom(lm.recoverFrom)
+}
+
+// encodeCheckpoint encodes checkpoint data as [redo LSN][start offset], the number of active
+// transactions followed by [txn ID][begin LSN] each, and the number of dirty pages followed by
+// [page ID][LSN] each, and the next transaction ID, all as uvarints. Maps are encoded in key
+// order. Checkpoints written before the next transaction ID was recorded end after the pages.
+func encodeCheckpoint(data *CheckpointData) []byte {
+	buf := binary.AppendUvarint(nil, data.RedoLSN)
+	buf = binary.AppendUvarint(buf, uint64(data.StartOffset))
+	txnIDs := make([]TransactionID, 0, len(data.ActiveTxns))
+	for txnID := range data.ActiveTxns {
+		txnIDs = append(txnIDs, txnID)
+	}
+	slices.Sort(txnIDs)
+	buf = binary.AppendUvarint(buf, uint64(len(txnIDs)))
+	for _, txnID := range txnIDs {
+		buf = binary.AppendUvarint(buf, uint64(txnID))
+		buf = binary.AppendUvarint(buf, data.ActiveTxns[txnID])
+	}
+	pageIDs := make([]disk.PageID, 0, len(data.DirtyPages))
+	for pageID := range data.DirtyPages {
+		pageIDs = append(pageIDs, pageID)
+	}
+	slices.Sort(pageIDs)
+	buf = binary.AppendUvarint(buf, uint64(len(pageIDs)))
+	for _, pageID := range pageIDs {
+		buf = binary.AppendUvarint(buf, uint64(pageID))
+		buf = binary.AppendUvarint(buf, data.DirtyPages[pageID])
+	}
+	return binary.AppendUvarint(buf, uint64(data.NextTxnID))
+}
+
+func decodeCheckpoint(field []byte) (*CheckpointData, error) {
+	pos := 0
+	next := func() (uint64, bool) {
+		v, n := binary.Uvarint(field[pos:])
+		if n <= 0 {
+			return 0, false
+		}
+		pos += n
+		return v, true
+	}
+	redoLSN, ok1 := next()
+	startOffset, ok2 := next()
+	numTxns, ok3 := next()
+	if !ok1 || !ok2 || !ok3 || uint64(len(field)) < numTxns {
+		return nil, ErrLogCorrupted
+	}
+	data := &CheckpointData{
+		RedoLSN:     redoLSN,
+		StartOffset: int64(startOffset),
+		ActiveTxns:  make(map[TransactionID]uint64, numTxns),
+	}
+	for range numTxns {
+		txnID, ok1 := next()
+		beginLSN, ok2 := next()
+		if !ok1 || !ok2 {
+			return nil, ErrLogCorrupted
+		}
+		data.ActiveTxns[TransactionID(txnID)] = beginLSN
+	}
+	numPages, ok := next()
+	if !ok || uint64(len(field)) < numPages {
+		return nil, ErrLogCorrupted
+	}
+	data.DirtyPages = make(map[disk.PageID]uint64, numPages)
+	for range numPages {
+		pageID, ok1 := next()
+		lsn, ok2 := next()
+		if !ok1 || !ok2 {
+			return nil, ErrLogCorrupted
+		}
+		data.DirtyPages[disk.PageID(pageID)] = lsn
+	}
+	if pos < len(field) {
+		nextTxnID, ok := next()
+		if !ok {
+			return nil, ErrLogCorrupted
+		}
+		data.NextTxnID = TransactionID(nextTxnID)
+	}
+	if pos != len(field) {
+		return nil, ErrLogCorrupted
+	}
+	return data, nil
+}
@@ -0,0 +1,157 @@
+package transaction
+
+import (
+	"fmt"
+	"os"
+	"reflect"
+	"testing"
+
+	"github.com/Johniel/gorelly/btree"
+	"github.com/Johniel/gorelly/buffer"
+	"github.com/Johniel/gorelly/disk"
+	"github.com/Johniel/gorelly/table"
+)
+
+func TestCheckpoint(t *testing.T) {
+	tmpfile, err := os.CreateTemp("", "test_checkpoint_*.db")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(tmpfile.Name())
+
+	logFile, err := os.CreateTemp("", "test_checkpoint_*.log")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(logFile.Name())
+	logFile.Close()
+
+	dm, err := disk.NewDiskManager(tmpfile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer dm.Close()
+
+	lm, err := OpenLogManager(logFile.Name(), SyncPolicy{Mode: SyncModeNone})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer lm.Close()
+
+	bufmgr := buffer.NewBufferPoolManager(dm, buffer.NewBufferPool(16))
+	bufmgr.SetWAL(lm)
+	users := &table.Table{NumKeyElems: 1}
+	if err := users.Create(bufmgr); err != nil {
+		t.Fatal(err)
+	}
+	events := &table.Table{NumKeyElems: 1}
+	if err := events.Create(bufmgr); err != nil {
+		t.Fatal(err)
+	}
+	if err := bufmgr.Flush(); err != nil {
+		t.Fatal(err)
+	}
+
+	tm := NewTransactionManagerWithManagers(lm, nil, nil)
+	key := func(i int) [][]byte {
+		return [][]byte{[]byte(fmt.Sprintf("key%04d", i)), []byte("value")}
+	}
+	insert := func(tbl *table.Table, txn *Transaction, from int, to int) {
+		t.Helper()
+		logged := *tbl
+		logged.Log = NewPageLog(lm, txn)
+		for i := from; i < to; i++ {
+			if err := logged.Insert(bufmgr, key(i)); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+
+	before := tm.Begin()
+	insert(users, before, 0, 100)
+	if err := tm.Commit(before); err != nil {
+		t.Fatal(err)
+	}
+	// A transaction that is still active at the checkpoint and never ends
+	longRunning := tm.Begin()
+	insert(events, longRunning, 0, 10)
+
+	if err := lm.Checkpoint(bufmgr); err != nil {
+		t.Fatal(err)
+	}
+	after := tm.Begin()
+	insert(users, after, 100, 200)
+	if err := tm.Commit(after); err != nil {
+		t.Fatal(err)
+	}
+
+	// Recovery reads from the Begin record of the transaction active at the checkpoint
+	all, err := lm.ReadLog()
+	if err != nil {
+		t.Fatal(err)
+	}
+	records, err := lm.readRecoveryLog()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(all) <= len(records) || records[0].Type != LogRecordTypeBegin || records[0].TxnID != longRunning.ID {
+		t.Fatalf("expected the recovery log to start at the Begin record of txn %d", longRunning.ID)
+	}
+	var checkpoint *CheckpointData
+	for _, record := range records {
+		if record.Type == LogRecordTypeCheckpoint {
+			checkpoint = record.Checkpoint
+		}
+	}
+	if checkpoint == nil || !reflect.DeepEqual(checkpoint.ActiveTxns, map[TransactionID]uint64{longRunning.ID: records[0].LSN}) {
+		t.Fatalf("expected txn %d to be active at the checkpoint, got %+v", longRunning.ID, checkpoint)
+	}
+
+	// Crash and recover
+	heapFile, err := os.OpenFile(tmpfile.Name(), os.O_RDWR, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	dm2, err := disk.NewDiskManager(heapFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer dm2.Close()
+	bufmgr2 := buffer.NewBufferPoolManager(dm2, buffer.NewBufferPool(16))
+	bufmgr2.SetWAL(lm)
+	if err := NewRecoveryManager(lm, bufmgr2).Recover(); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 200; i++ {
+		if _, err := users.Get(bufmgr2, key(i)[:1]); err != nil {
+			t.Fatalf("row %d: %v", i, err)
+		}
+	}
+	for i := 0; i < 10; i++ {
+		if _, err := events.Get(bufmgr2, key(i)[:1]); err != btree.ErrKeyNotFound {
+			t.Errorf("expected uncommitted row %d to be absent, got %v", i, err)
+		}
+	}
+
+	// Recovery ended the transaction, so the next checkpoint does not wait for it
+	if err := lm.Checkpoint(bufmgr2); err != nil {
+		t.Fatal(err)
+	}
+	records, err = lm.readRecoveryLog()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(records) != 1 || records[0].Type != LogRecordTypeCheckpoint || len(records[0].Checkpoint.ActiveTxns) != 0 {
+		t.Errorf("expected the recovery log to hold only the last checkpoint, got %d records", len(records))
+	}
+
+	// The position of the last checkpoint is found again when the log is reopened
+	reopened, err := NewLogManager(logFile.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer reopened.Close()
+	if reread, err := reopened.readRecoveryLog(); err != nil || len(reread) != 1 {
+		t.Errorf("expected the reopened log to start recovery at the last checkpoint, got %d records (%v)", len(reread), err)
+	}
+}
@@ -0,0 +1,334 @@
+//go:build unix
+
+package transaction
+
+import (
+	"bufio"
+	"bytes"
+	"flag"
+	"fmt"
+	"maps"
+	"math/rand"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/Johniel/gorelly/btree"
+	"github.com/Johniel/gorelly/buffer"
+	"github.com/Johniel/gorelly/disk"
+	"github.com/Johniel/gorelly/table"
+	"github.com/Johniel/gorelly/tuple"
+)
+
+var crashIterations = flag.Int("crash.iterations", 20, "number of times TestCrashRecovery kills its workload")
+
+// The environment of a workload process started by TestCrashRecovery.
+const (
+	crashDirEnv  = "GORELLY_CRASH_DIR"
+	crashSeedEnv = "GORELLY_CRASH_SEED"
+	crashMetaEnv = "GORELLY_CRASH_META"
+)
+
+// crashRow is a committed row of the table TestCrashRecovery works on: the primary key maps to a
+// value, kept unique by an index, and to a payload of padding bytes.
+type crashRow struct {
+	value   string
+	padding int
+}
+
+// crashPayload returns the payload of n bytes of a row, long enough to split pages often.
+func crashPayload(n int) []byte {
+	return bytes.Repeat([]byte{byte('a' + n%26)}, n)
+}
+
+// TestCrashRecovery kills a process applying a random workload of transactions with SIGKILL at
+// random points, including in the middle of a log sync, then recovers the database and checks that
+// it holds exactly the committed transactions and that its index agrees with its rows. The number
+// of kills is set with -crash.iterations.
+func TestCrashRecovery(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping crash recovery in short mode")
+	}
+	dir := t.TempDir()
+	dm, err := disk.OpenDiskManager(filepath.Join(dir, "crash.rly"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	bufmgr := buffer.NewBufferPoolManager(dm, buffer.NewBufferPool(16))
+	tbl := &table.Table{
+		MetaPageID:    disk.InvalidPageID,
+		NumKeyElems:   1,
+		UniqueIndices: []*table.UniqueIndex{{MetaPageID: disk.InvalidPageID, Skey: []int{1}}},
+	}
+	if err := tbl.Create(bufmgr); err != nil {
+		t.Fatal(err)
+	}
+	if err := bufmgr.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	if err := dm.Close(); err != nil {
+		t.Fatal(err)
+	}
+	meta := fmt.Sprintf("%d,%d", tbl.MetaPageID, tbl.UniqueIndices[0].MetaPageID)
+
+	committed := map[string]crashRow{}
+	for i := 0; i < *crashIterations; i++ {
+		seed := time.Now().UnixNano()
+		cmd := exec.Command(os.Args[0], "-test.run=^TestCrashRecoveryWorkload$")
+		cmd.Env = append(os.Environ(), crashDirEnv+"="+dir, crashSeedEnv+"="+strconv.FormatInt(seed, 10), crashMetaEnv+"="+meta)
+		var stdout, stderr bytes.Buffer
+		cmd.Stdout = &stdout
+		cmd.Stderr = &stderr
+		if err := cmd.Start(); err != nil {
+			t.Fatal(err)
+		}
+		// The workload also kills itself, at a point of its own choosing
+		timer := time.AfterFunc(time.Duration(rand.Int63n(int64(200*time.Millisecond))), func() {
+			cmd.Process.Kill()
+		})
+		err := cmd.Wait()
+		timer.Stop()
+		if status, ok := err.(*exec.ExitError); !ok || status.Sys().(syscall.WaitStatus).Signal() != syscall.SIGKILL {
+			t.Fatalf("iteration %d (seed %d): expected the workload to be killed, got %v\n%s%s", i, seed, err, stdout.String(), stderr.String())
+		}
+
+		inDoubt := applyCrashOutput(t, committed, stdout.String())
+		if err := verifyCrashRecovery(dir, tbl, committed, inDoubt); err != nil {
+			t.Fatalf("iteration %d (seed %d): %v", i, seed, err)
+		}
+	}
+}
+
+// applyCrashOutput applies the transactions the workload reported as committed to committed.
+// It returns the committed rows including the last transaction if it was killed while committing
+// it, and nil otherwise.
+func applyCrashOutput(t *testing.T, committed map[string]crashRow, output string) map[string]crashRow {
+	var pending []string
+	var inDoubt map[string]crashRow
+	apply := func(rows map[string]crashRow, ops []string) {
+		for _, op := range ops {
+			f := strings.Fields(op)
+			switch f[0] {
+			case "insert":
+				n, _ := strconv.Atoi(f[3])
+				rows[f[1]] = crashRow{value: f[2], padding: n}
+			case "update":
+				n, _ := strconv.Atoi(f[2])
+				rows[f[1]] = crashRow{value: rows[f[1]].value, padding: n}
+			case "delete":
+				delete(rows, f[1])
+			}
+		}
+	}
+	scanner := bufio.NewScanner(strings.NewReader(output))
+	for scanner.Scan() {
+		line := scanner.Text()
+		switch line {
+		case "begin", "abort":
+			pending = nil
+		case "commit":
+			inDoubt = maps.Clone(committed)
+			apply(inDoubt, pending)
+		case "committed":
+			apply(committed, pending)
+			pending, inDoubt = nil, nil
+		default:
+			if !strings.HasPrefix(line, "insert ") && !strings.HasPrefix(line, "update ") && !strings.HasPrefix(line, "delete ") {
+				t.Fatalf("unexpected workload output %q", line)
+			}
+			pending = append(pending, line)
+		}
+	}
+	return inDoubt
+}
+
+// verifyCrashRecovery recovers the database in dir and checks that the table holds the committed
+// rows, or the inDoubt ones if they are not nil, and that its index agrees with them. The database
+// is closed cleanly afterwards. If the rows are inDoubt, committed is updated with the outcome.
+func verifyCrashRecovery(dir string, tbl *table.Table, committed map[string]crashRow, inDoubt map[string]crashRow) error {
+	dm, err := disk.OpenDiskManager(filepath.Join(dir, "crash.rly"))
+	if err != nil {
+		return err
+	}
+	defer dm.Close()
+	lm, err := NewLogManager(filepath.Join(dir, "crash.log"))
+	if err != nil {
+		return err
+	}
+	defer lm.Close()
+	bufmgr := buffer.NewBufferPoolManager(dm, buffer.NewBufferPool(16))
+	bufmgr.SetWAL(lm)
+	if err := NewRecoveryManager(lm, bufmgr).Recover(); err != nil {
+		return fmt.Errorf("recovery failed: %w", err)
+	}
+
+	rows := map[string]crashRow{}
+	iter, err := btree.NewBTree(tbl.MetaPageID).Search(bufmgr, btree.NewSearchModeStart())
+	if err != nil {
+		return err
+	}
+	for {
+		keyBytes, valueBytes, ok, err := iter.Next(bufmgr)
+		if err != nil {
+			iter.Close()
+			return err
+		}
+		if !ok {
+			break
+		}
+		var tup [][]byte
+		tuple.Decode(keyBytes, &tup)
+		tbl.Format.DecodeValue(valueBytes, &tup)
+		if len(tup) != 3 || !bytes.Equal(tup[2], crashPayload(len(tup[2]))) {
+			iter.Close()
+			return fmt.Errorf("corrupted row %q", tup)
+		}
+		rows[string(tup[0])] = crashRow{value: string(tup[1]), padding: len(tup[2])}
+	}
+	iter.Close()
+	switch {
+	case maps.Equal(rows, committed):
+	case inDoubt != nil && maps.Equal(rows, inDoubt):
+		maps.Copy(committed, inDoubt)
+		maps.DeleteFunc(committed, func(key string, _ crashRow) bool {
+			_, ok := inDoubt[key]
+			return !ok
+		})
+	default:
+		return fmt.Errorf("expected the %d committed rows, got %d: %s", len(committed), len(rows), diffCrashRows(committed, rows))
+	}
+	discrepancies, err := tbl.VerifyIndexes(bufmgr)
+	if err != nil {
+		return err
+	}
+	if len(discrepancies) != 0 {
+		return fmt.Errorf("index disagrees with the rows: %v", discrepancies)
+	}
+	if err := lm.Checkpoint(bufmgr); err != nil {
+		return err
+	}
+	return bufmgr.Flush()
+}
+
+// diffCrashRows describes the rows that differ between want and got.
+func diffCrashRows(want map[string]crashRow, got map[string]crashRow) string {
+	var diffs []string
+	for _, key := range slices.Sorted(maps.Keys(want)) {
+		if row, ok := got[key]; !ok {
+			diffs = append(diffs, fmt.Sprintf("%s missing", key))
+		} else if row != want[key] {
+			diffs = append(diffs, fmt.Sprintf("%s is %v instead of %v", key, row, want[key]))
+		}
+	}
+	for _, key := range slices.Sorted(maps.Keys(got)) {
+		if _, ok := want[key]; !ok {
+			diffs = append(diffs, fmt.Sprintf("%s unexpected", key))
+		}
+	}
+	return strings.Join(diffs, ", ")
+}
+
+// TestCrashRecoveryWorkload is the workload process of TestCrashRecovery; it does nothing when
+// run on its own. It applies random transactions to the table until it is killed, or kills
+// itself after a random number of them, and reports them on stdout: "begin", the changes, then
+// "abort", or "commit" before committing and "committed" once the commit returned.
+func TestCrashRecoveryWorkload(t *testing.T) {
+	dir := os.Getenv(crashDirEnv)
+	if dir == "" {
+		t.Skip("run by TestCrashRecovery")
+	}
+	seed, _ := strconv.ParseInt(os.Getenv(crashSeedEnv), 10, 64)
+	var primary, index disk.PageID
+	fmt.Sscanf(os.Getenv(crashMetaEnv), "%d,%d", &primary, &index)
+	rng := rand.New(rand.NewSource(seed))
+
+	dm, err := disk.OpenDiskManager(filepath.Join(dir, "crash.rly"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	lm, err := OpenLogManager(filepath.Join(dir, "crash.log"), SyncPolicy{Mode: SyncModeFdatasync})
+	if err != nil {
+		t.Fatal(err)
+	}
+	bufmgr := buffer.NewBufferPoolManager(dm, buffer.NewBufferPool(16))
+	bufmgr.SetWAL(lm)
+	// Write pages back at random points, so that changes not yet committed reach the disk
+	bufmgr.SetEvictionInjector(func(bpm *buffer.BufferPoolManager, call uint64, pageID disk.PageID) {
+		if rng.Intn(20) == 0 {
+			if _, err := bpm.EvictAll(); err != nil {
+				panic(err)
+			}
+		}
+	})
+	rm := NewRecoveryManager(lm, bufmgr)
+	tm := NewTransactionManagerWithManagers(lm, nil, rm)
+	tbl := &table.Table{
+		MetaPageID:    primary,
+		NumKeyElems:   1,
+		UniqueIndices: []*table.UniqueIndex{{MetaPageID: index, Skey: []int{1}}},
+	}
+
+	kill := func() {
+		syscall.Kill(os.Getpid(), syscall.SIGKILL)
+		select {}
+	}
+	killAfter := rng.Intn(2000)
+	for ops := 0; ; {
+		txn := tm.Begin()
+		tbl.Log = NewPageLog(lm, txn)
+		fmt.Println("begin")
+		for n := 1 + rng.Intn(8); 0 < n; n-- {
+			key := fmt.Sprintf("k%03d", rng.Intn(300))
+			padding := rng.Intn(600)
+			row, err := tbl.Get(bufmgr, [][]byte{[]byte(key)})
+			switch {
+			case err == btree.ErrKeyNotFound:
+				value := fmt.Sprintf("v%d-%d", seed, ops)
+				err = tbl.Insert(bufmgr, [][]byte{[]byte(key), []byte(value), crashPayload(padding)})
+				fmt.Println("insert", key, value, padding)
+			case err != nil:
+			case rng.Intn(3) == 0:
+				err = tbl.Delete(bufmgr, row)
+				fmt.Println("delete", key)
+			default:
+				updated := [][]byte{row[0], row[1], crashPayload(padding)}
+				// A longer payload that does not fit in the leaf is moved by deleting the row
+				if err = tbl.Update(bufmgr, updated); err == btree.ErrKeyNotFound {
+					if err = tbl.Delete(bufmgr, row); err == nil {
+						err = tbl.Insert(bufmgr, updated)
+					}
+				}
+				fmt.Println("update", key, padding)
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if ops++; ops == killAfter {
+				kill()
+			}
+		}
+		if rng.Intn(5) == 0 {
+			if err := tm.Abort(txn); err != nil {
+				t.Fatal(err)
+			}
+			fmt.Println("abort")
+		} else {
+			fmt.Println("commit")
+			if err := tm.Commit(txn); err != nil {
+				t.Fatal(err)
+			}
+			fmt.Println("committed")
+		}
+		if rng.Intn(20) == 0 {
+			if err := lm.Checkpoint(bufmgr); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+}
@@ -0,0 +1,138 @@
+package transaction
+
+import (
+	"encoding/binary"
+
+	"github.com/Johniel/gorelly/disk"
+)
+
+// deltaRunGap is the number of unchanged bytes up to which two changed ranges are encoded as
+// one run: below it, repeating the unchanged bytes is cheaper than the header of another run.
+const deltaRunGap = 4
+
+// DeltaRun is a changed byte range of a delta-encoded update record.
+// Offset is relative to the Offset of the record.
+type DeltaRun struct {
+	Offset   int
+	OldValue []byte
+	NewValue []byte
+}
+
+// NewUpdateRecord returns an update record changing the bytes at offset of a page from
+// oldValue to newValue. If both images have the same length and differ in only a few places,
+// the record is delta-encoded: it keeps only the changed ranges (Delta) and leaves OldValue
+// and NewValue empty, which shrinks the log when e.g. a counter changes inside a large region.
+//
+// Delta runs keep both images of their bytes rather than their XOR, so that, like full images,
+// redoing or undoing a record twice has the same effect as doing it once.
+func NewUpdateRecord(txnID TransactionID, pageID disk.PageID, offset int, oldValue []byte, newValue []byte) *LogRecord {
+	record := &LogRecord{
+		Type:   LogRecordTypeUpdate,
+		TxnID:  txnID,
+		PageID: pageID,
+		Offset: offset,
+	}
+	if len(oldValue) == len(newValue) {
+		delta := diffImages(oldValue, newValue)
+		if len(encodeDelta(delta)) < len(oldValue)+len(newValue) {
+			record.OldValue = []byte{}
+			record.NewValue = []byte{}
+			record.Delta = delta
+			return record
+		}
+	}
+	record.OldValue = append([]byte{}, oldValue...)
+	record.NewValue = append([]byte{}, newValue...)
+	return record
+}
+
+// Redo applies the after image of an update record to page.
+func (r *LogRecord) Redo(page []byte) {
+	if r.Delta == nil {
+		copy(page[r.Offset:r.Offset+len(r.NewValue)], r.NewValue)
+		return
+	}
+	for _, run := range r.Delta {
+		copy(page[r.Offset+run.Offset:], run.NewValue)
+	}
+}
+
+// Undo applies the before image of an update record to page.
+func (r *LogRecord) Undo(page []byte) {
+	if r.Delta == nil {
+		copy(page[r.Offset:r.Offset+len(r.OldValue)], r.OldValue)
+		return
+	}
+	for _, run := range r.Delta {
+		copy(page[r.Offset+run.Offset:], run.OldValue)
+	}
+}
+
+// diffImages returns the changed ranges between two images of the same length.
+func diffImages(oldValue []byte, newValue []byte) []DeltaRun {
+	delta := []DeltaRun{}
+	i := 0
+	for i < len(oldValue) {
+		if oldValue[i] == newValue[i] {
+			i++
+			continue
+		}
+		start, end := i, i+1
+		for j := end; j < len(oldValue) && j-end < deltaRunGap; j++ {
+			if oldValue[j] != newValue[j] {
+				end = j + 1
+			}
+		}
+		delta = append(delta, DeltaRun{
+			Offset:   start,
+			OldValue: append([]byte{}, oldValue[start:end]...),
+			NewValue: append([]byte{}, newValue[start:end]...),
+		})
+		i = end
+	}
+	return delta
+}
+
+// encodeDelta encodes runs as [count] followed by [offset][length][old][new] per run,
+// with count, offset and length as uvarints.
+func encodeDelta(delta []DeltaRun) []byte {
+	buf := binary.AppendUvarint(nil, uint64(len(delta)))
+	for _, run := range delta {
+		buf = binary.AppendUvarint(buf, uint64(run.Offset))
+		buf = binary.AppendUvarint(buf, uint64(len(run.NewValue)))
+		buf = append(buf, run.OldValue...)
+		buf = append(buf, run.NewValue...)
+	}
+	return buf
+}
+
+func decodeDelta(data []byte) ([]DeltaRun, error) {
+	count, n := binary.Uvarint(data)
+	if n <= 0 || uint64(len(data)) < count {
+		return nil, ErrLogCorrupted
+	}
+	pos := n
+	delta := make([]DeltaRun, 0, count)
+	for range count {
+		offset, n := binary.Uvarint(data[pos:])
+		if n <= 0 {
+			return nil, ErrLogCorrupted
+		}
+		pos += n
+		size, n := binary.Uvarint(data[pos:])
+		if n <= 0 || uint64(len(data)-pos-n)/2 < size {
+			return nil, ErrLogCorrupted
+		}
+		pos += n
+		delta = append(delta, DeltaRun{
+			Offset:   int(offset),
+			OldValue: append([]byte{}, data[pos:pos+int(size)]...),
+			NewValue: append([]byte{}, data[pos+int(size):pos+2*int(size)]...),
+		})
+		pos += 2 * int(size)
+	}
+	if pos != len(data) {
+		return nil, ErrLogCorrupted
+	}
+	return delta, nil
+}
@@ -0,0 +1,65 @@
+package transaction
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+)
+
+func TestDeltaUpdateRecord(t *testing.T) {
+	oldValue := bytes.Repeat([]byte{0xaa}, 256)
+	newValue := append([]byte{}, oldValue...)
+	newValue[10] = 1
+	newValue[12] = 2 // Close enough to share a run with offset 10
+	newValue[200] = 3
+
+	record := NewUpdateRecord(7, 3, 100, oldValue, newValue)
+	expected := []DeltaRun{
+		{Offset: 10, OldValue: []byte{0xaa, 0xaa, 0xaa}, NewValue: []byte{1, 0xaa, 2}},
+		{Offset: 200, OldValue: []byte{0xaa}, NewValue: []byte{3}},
+	}
+	if !reflect.DeepEqual(record.Delta, expected) {
+		t.Fatalf("expected runs %+v, got %+v", expected, record.Delta)
+	}
+	full := &LogRecord{Type: LogRecordTypeUpdate, TxnID: 7, PageID: 3, Offset: 100, OldValue: oldValue, NewValue: newValue}
+	if size, fullSize := len(serializeRecord(record)), len(serializeRecord(full)); fullSize/10 < size {
+		t.Errorf("expected the delta record to be much smaller than %d bytes, got %d", fullSize, size)
+	}
+
+	data := serializeRecord(record)
+	decoded, err := deserializeRecord(record.LSN, data[12:])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(decoded, record) {
+		t.Errorf("expected %+v, got %+v", record, decoded)
+	}
+	encoded := encodeDelta(record.Delta)
+	if _, err := decodeDelta(encoded[:len(encoded)-1]); err != ErrLogCorrupted {
+		t.Errorf("expected ErrLogCorrupted for a truncated delta, got %v", err)
+	}
+
+	// Redo and undo reconstruct the images, also when applied twice
+	page := make([]byte, 512)
+	copy(page[100:], oldValue)
+	for range 2 {
+		decoded.Redo(page)
+		if !bytes.Equal(page[100:356], newValue) {
+			t.Fatal("expected the new image after redo")
+		}
+	}
+	for range 2 {
+		decoded.Undo(page)
+		if !bytes.Equal(page[100:356], oldValue) {
+			t.Fatal("expected the old image after undo")
+		}
+	}
+
+	// Images that change size or mostly differ keep the full images
+	if record := NewUpdateRecord(7, 3, 0, []byte("old"), []byte("newer")); record.Delta != nil || string(record.NewValue) != "newer" {
+		t.Errorf("expected full images for different lengths, got %+v", record)
+	}
+	if record := NewUpdateRecord(7, 3, 0, []byte("abcd"), []byte("efgh")); record.Delta != nil {
+		t.Errorf("expected full images for a complete change, got %+v", record)
+	}
+}
@@ -0,0 +1,178 @@
+package transaction
+
+import (
+	"bytes"
+	"slices"
+
+	"github.com/Johniel/gorelly/btree"
+	"github.com/Johniel/gorelly/buffer"
+	"github.com/Johniel/gorelly/disk"
+)
+
+// KeyLock identifies a key of a B+ tree for key-range (next-key) locking.
+// A lock on a key also covers the gap between the key and its predecessor,
+// so locking the key that follows a scanned range keeps new keys out of the range.
+type KeyLock struct {
+	TreeMetaPageID disk.PageID // Meta page of the B+ tree the key belongs to
+	Key            string      // Encoded key (empty for the supremum)
+	Supremum       bool        // The position after the last key, covering the gap at the end of the tree
+}
+
+// LockKeyShared acquires a shared lock on a key and the gap before it.
+func (lm *LockManager) LockKeyShared(txn *Transaction, key KeyLock) error {
+	_, err := lm.lock(txn, key, LockModeShared)
+	return err
+}
+
+// LockKeyExclusive acquires an exclusive lock on a key and the gap before it.
+func (lm *LockManager) LockKeyExclusive(txn *Transaction, key KeyLock) error {
+	_, err := lm.lock(txn, key, LockModeExclusive)
+	return err
+}
+
+// UnlockKey releases the locks held by the transaction on a key.
+func (lm *LockManager) UnlockKey(txn *Transaction, key KeyLock) error {
+	return lm.unlock(txn, key)
+}
+
+// LockRange acquires the next-key locks a serializable range scan over [from, to) needs:
+// a shared lock on every key in the range and on the first key at or after to
+// (the supremum if there is none or to is nil), which covers the gap after the last key in the range.
+// A nil from starts the range at the beginning of the tree.
+// Because every gap in the range is covered, no transaction can insert a phantom into it
+// until the scanning transaction releases its locks.
+//
+// The keys are collected with the tree unpinned before any lock is waited for, so a blocked
+// scan holds no buffer pool frames. A key inserted before the locks are granted shows up
+// when the range is read again, and the locking is repeated until the range is stable.
+func (lm *LockManager) LockRange(txn *Transaction, bufmgr *buffer.BufferPoolManager, treeMetaPageID disk.PageID, from []byte, to []byte) error {
+	locks, err := rangeKeyLocks(bufmgr, treeMetaPageID, from, to)
+	if err != nil {
+		return err
+	}
+	for {
+		for _, key := range locks {
+			if err := lm.LockKeyShared(txn, key); err != nil {
+				return err
+			}
+		}
+		again, err := rangeKeyLocks(bufmgr, treeMetaPageID, from, to)
+		if err != nil {
+			return err
+		}
+		if slices.Equal(locks, again) {
+			return nil
+		}
+		locks = again
+	}
+}
+
+// rangeKeyLocks returns the next-key locks covering [from, to) as LockRange describes,
+// releasing the iterator before returning.
+func rangeKeyLocks(bufmgr *buffer.BufferPoolManager, treeMetaPageID disk.PageID, from []byte, to []byte) ([]KeyLock, error) {
+	bt := btree.NewBTree(treeMetaPageID)
+	searchMode := btree.NewSearchModeStart()
+	if from != nil {
+		searchMode = btree.NewSearchModeKey(from)
+	}
+	iter, err := bt.Search(bufmgr, searchMode)
+	if err != nil {
+		return nil, err
+	}
+	defer iter.Close()
+	var locks []KeyLock
+	for {
+		key, _, ok, err := iter.Next(bufmgr)
+		if err != nil {
+			return nil, err
+		}
+		if !ok {
+			return append(locks, KeyLock{TreeMetaPageID: treeMetaPageID, Supremum: true}), nil
+		}
+		locks = append(locks, KeyLock{TreeMetaPageID: treeMetaPageID, Key: string(key)})
+		if to != nil && 0 <= bytes.Compare(key, to) {
+			return locks, nil
+		}
+	}
+}
+
+// LockInsert acquires the locks needed to insert key into a B+ tree under serializable isolation:
+// an instant-duration exclusive lock on the next key, which waits for range scans covering
+// the gap the key falls into, and an exclusive lock on the key itself held until the
+// transaction releases its locks.
+func (lm *LockManager) LockInsert(txn *Transaction, bufmgr *buffer.BufferPoolManager, treeMetaPageID disk.PageID, key []byte) error {
+	next, err := nextKeyLock(bufmgr, treeMetaPageID, key)
+	if err != nil {
+		return err
+	}
+	req, err := lm.lock(txn, next, LockModeExclusive)
+	if err != nil {
+		return err
+	}
+	lm.release(next, req)
+	return lm.LockKeyExclusive(txn, KeyLock{TreeMetaPageID: treeMetaPageID, Key: string(key)})
+}
+
+// ScanLocker returns a hook for query.SeqScan.LockKey that takes shared next-key locks
+// on the B+ tree for the transaction. A nil key locks the supremum.
+func (lm *LockManager) ScanLocker(txn *Transaction, treeMetaPageID disk.PageID) func(keyBytes []byte) error {
+	return func(keyBytes []byte) error {
+		if keyBytes == nil {
+			return lm.LockKeyShared(txn, KeyLock{TreeMetaPageID: treeMetaPageID, Supremum: true})
+		}
+		return lm.LockKeyShared(txn, KeyLock{TreeMetaPageID: treeMetaPageID, Key: string(keyBytes)})
+	}
+}
+
+// InsertLocker returns a hook for table.Table.LockInsert that runs LockInsert for the transaction.
+func (lm *LockManager) InsertLocker(txn *Transaction, bufmgr *buffer.BufferPoolManager, treeMetaPageID disk.PageID) func(keyBytes []byte) error {
+	return func(keyBytes []byte) error {
+		return lm.LockInsert(txn, bufmgr, treeMetaPageID, keyBytes)
+	}
+}
+
+// RangeLocker returns a hook for table.Table.LockScan that runs LockRange for the transaction.
+func (lm *LockManager) RangeLocker(txn *Transaction, bufmgr *buffer.BufferPoolManager, treeMetaPageID disk.PageID) func(from []byte, to []byte) error {
+	return func(from []byte, to []byte) error {
+		return lm.LockRange(txn, bufmgr, treeMetaPageID, from, to)
+	}
+}
+
+// nextKeyLock returns the lock on the first key greater than key.
+func nextKeyLock(bufmgr *buffer.BufferPoolManager, treeMetaPageID disk.PageID, key []byte) (KeyLock, error) {
+	bt := btree.NewBTree(treeMetaPageID)
+	iter, err := bt.Search(bufmgr, btree.NewSearchModeKey(key))
+	if err != nil {
+		return KeyLock{}, err
+	}
+	defer iter.Close()
+	for {
+		found, _, ok, err := iter.Next(bufmgr)
+		if err != nil {
+			return KeyLock{}, err
+		}
+		if !ok {
+			return KeyLock{TreeMetaPageID: treeMetaPageID, Supremum: true}, nil
+		}
+		if 0 < bytes.Compare(found, key) {
+			return KeyLock{TreeMetaPageID: treeMetaPageID, Key: string(found)}, nil
+		}
+	}
+}
+
+// release drops a single granted request, leaving other locks of the same transaction on target in place.
+func (lm *LockManager) release(target any, req *LockRequest) {
+	lm.mu.Lock()
+	defer lm.mu.Unlock()
+
+	requests := lm.lockTable[target]
+	newRequests := make([]*LockRequest, 0, len(requests))
+	for _, r := range requests {
+		if r != req {
+			newRequests = append(newRequests, r)
+		}
+	}
+	lm.setRequests(target, newRequests)
+	lm.grantPendingLocks(target)
+	lm.updateWaitForGraph(target)
+}
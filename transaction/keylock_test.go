@@ -0,0 +1,238 @@
+package transaction
+
+import (
+	"os"
+	"testing"
+	"time"
+
+	"github.com/Johniel/gorelly/buffer"
+	"github.com/Johniel/gorelly/disk"
+	"github.com/Johniel/gorelly/table"
+	"github.com/Johniel/gorelly/tuple"
+)
+
+func TestKeyRangeLocking(t *testing.T) {
+	tmpfile, err := os.CreateTemp("", "test_key_range_locking_*.db")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(tmpfile.Name())
+
+	dm, err := disk.NewDiskManager(tmpfile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer dm.Close()
+
+	pool := buffer.NewBufferPool(10)
+	bufmgr := buffer.NewBufferPoolManager(dm, pool)
+
+	tbl := &table.Table{NumKeyElems: 1}
+	if err := tbl.Create(bufmgr); err != nil {
+		t.Fatal(err)
+	}
+	for _, id := range []string{"1", "2", "4", "6"} {
+		if err := tbl.Insert(bufmgr, [][]byte{[]byte(id), []byte("row" + id)}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	encodeKey := func(id string) []byte {
+		keyBytes := make([]byte, 0)
+		tuple.Encode([][]byte{[]byte(id)}, &keyBytes)
+		return keyBytes
+	}
+
+	lm := NewLockManager()
+	tm := NewTransactionManager()
+
+	// txn1 scans [2, 5): it locks 2, 4 and the next key 6
+	txn1 := tm.Begin()
+	if err := lm.LockRange(txn1, bufmgr, tbl.MetaPageID, encodeKey("2"), encodeKey("5")); err != nil {
+		t.Fatal(err)
+	}
+
+	t.Run("InsertOutsideRange", func(t *testing.T) {
+		txn2 := tm.Begin()
+		defer lm.UnlockAll(txn2)
+		inserter := &table.Table{
+			MetaPageID:  tbl.MetaPageID,
+			NumKeyElems: 1,
+			LockInsert:  lm.InsertLocker(txn2, bufmgr, tbl.MetaPageID),
+		}
+		if err := inserter.Insert(bufmgr, [][]byte{[]byte("7"), []byte("row7")}); err != nil {
+			t.Fatal(err)
+		}
+	})
+
+	t.Run("OwnInsert", func(t *testing.T) {
+		// The scanning transaction itself may insert into the range it locked
+		if err := lm.LockInsert(txn1, bufmgr, tbl.MetaPageID, encodeKey("21")); err != nil {
+			t.Fatal(err)
+		}
+	})
+
+	t.Run("PhantomBlocked", func(t *testing.T) {
+		txn3 := tm.Begin()
+		defer lm.UnlockAll(txn3)
+		inserter := &table.Table{
+			MetaPageID:  tbl.MetaPageID,
+			NumKeyElems: 1,
+			LockInsert:  lm.InsertLocker(txn3, bufmgr, tbl.MetaPageID),
+		}
+
+		done := make(chan error, 1)
+		go func() {
+			done <- inserter.Insert(bufmgr, [][]byte{[]byte("3"), []byte("row3")})
+		}()
+		select {
+		case err := <-done:
+			t.Fatalf("insert into a locked range should wait, got %v", err)
+		case <-time.After(50 * time.Millisecond):
+		}
+
+		lm.UnlockAll(txn1)
+		select {
+		case err := <-done:
+			if err != nil {
+				t.Fatal(err)
+			}
+		case <-time.After(time.Second):
+			t.Fatal("insert did not proceed after the range locks were released")
+		}
+	})
+}
+
+func TestKeyLockSupremum(t *testing.T) {
+	tmpfile, err := os.CreateTemp("", "test_key_lock_supremum_*.db")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(tmpfile.Name())
+
+	dm, err := disk.NewDiskManager(tmpfile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer dm.Close()
+
+	pool := buffer.NewBufferPool(10)
+	bufmgr := buffer.NewBufferPoolManager(dm, pool)
+
+	tbl := &table.Table{NumKeyElems: 1}
+	if err := tbl.Create(bufmgr); err != nil {
+		t.Fatal(err)
+	}
+
+	lm := NewLockManager()
+	tm := NewTransactionManager()
+
+	// Scanning an empty table locks the supremum, so any insert must wait
+	txn1 := tm.Begin()
+	if err := lm.LockRange(txn1, bufmgr, tbl.MetaPageID, nil, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	txn2 := tm.Begin()
+	defer lm.UnlockAll(txn2)
+	done := make(chan error, 1)
+	go func() {
+		keyBytes := make([]byte, 0)
+		tuple.Encode([][]byte{[]byte("1")}, &keyBytes)
+		done <- lm.LockInsert(txn2, bufmgr, tbl.MetaPageID, keyBytes)
+	}()
+	select {
+	case err := <-done:
+		t.Fatalf("insert should wait for the supremum lock, got %v", err)
+	case <-time.After(50 * time.Millisecond):
+	}
+	lm.UnlockAll(txn1)
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("insert did not proceed after the supremum lock was released")
+	}
+}
+
+func TestLockRangeWaitsUnpinned(t *testing.T) {
+	tmpfile, err := os.CreateTemp("", "test_lock_range_unpinned_*.db")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(tmpfile.Name())
+
+	dm, err := disk.NewDiskManager(tmpfile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer dm.Close()
+
+	pool := buffer.NewBufferPool(10)
+	bufmgr := buffer.NewBufferPoolManager(dm, pool)
+
+	tbl := &table.Table{NumKeyElems: 1}
+	if err := tbl.Create(bufmgr); err != nil {
+		t.Fatal(err)
+	}
+	for _, id := range []string{"1", "2", "3"} {
+		if err := tbl.Insert(bufmgr, [][]byte{[]byte(id), []byte("row" + id)}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	keyBytes := make([]byte, 0)
+	tuple.Encode([][]byte{[]byte("2")}, &keyBytes)
+
+	lm := NewLockManager()
+	tm := NewTransactionManager()
+
+	writer := tm.Begin()
+	if err := lm.LockKeyExclusive(writer, KeyLock{TreeMetaPageID: tbl.MetaPageID, Key: string(keyBytes)}); err != nil {
+		t.Fatal(err)
+	}
+
+	scanner := tm.Begin()
+	done := make(chan error, 1)
+	go func() {
+		done <- lm.LockRange(scanner, bufmgr, tbl.MetaPageID, nil, nil)
+	}()
+	select {
+	case err := <-done:
+		t.Fatalf("range scan should wait for the exclusive key lock, got %v", err)
+	case <-time.After(50 * time.Millisecond):
+	}
+	if pinned := bufmgr.PoolStats().Pinned; pinned != 0 {
+		t.Errorf("pinned pages while waiting = %d, want 0", pinned)
+	}
+
+	// A key inserted while the scan waits must be locked too
+	if err := tbl.Insert(bufmgr, [][]byte{[]byte("25"), []byte("row25")}); err != nil {
+		t.Fatal(err)
+	}
+	lm.UnlockAll(writer)
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("range scan did not proceed after the key lock was released")
+	}
+	inserted := make([]byte, 0)
+	tuple.Encode([][]byte{[]byte("25")}, &inserted)
+	lm.mu.RLock()
+	_, locked := lm.lockTable[KeyLock{TreeMetaPageID: tbl.MetaPageID, Key: string(inserted)}]
+	lm.mu.RUnlock()
+	if !locked {
+		t.Error("key inserted during the wait was not locked")
+	}
+
+	// Released locks leave no entries behind
+	lm.UnlockAll(scanner)
+	lm.mu.RLock()
+	defer lm.mu.RUnlock()
+	if n := len(lm.lockTable); n != 0 {
+		t.Errorf("lock table entries after unlocking = %d, want 0", n)
+	}
+}
@@ -2,15 +2,18 @@
 package transaction
 
 import (
-	"errors"
 	"sync"
+	"time"
+
+	"github.com/Johniel/gorelly/errcode"
+	"github.com/Johniel/gorelly/histogram"
 )
 
 var (
 	// ErrDeadlock is returned when a deadlock is detected.
-	ErrDeadlock = errors.New("deadlock detected")
+	ErrDeadlock = errcode.New(errcode.Deadlock, "deadlock detected")
 	// ErrLockTimeout is returned when a lock request times out.
-	ErrLockTimeout = errors.New("lock request timed out")
+	ErrLockTimeout = errcode.New(errcode.LockNotAvailable, "lock request timed out")
 )
 
 // LockMode represents the type of lock.
@@ -60,14 +63,17 @@ type LockRequest struct {
 //
 //	All operations are protected by a read-write mutex to ensure thread safety.
 type LockManager struct {
-	// lockTable maps each tuple (RID) to a list of lock requests.
+	// lockTable maps each locked resource (a tuple RID or a KeyLock) to a list of lock requests.
 	// Requests are stored in FIFO order to ensure fairness.
-	lockTable map[RID][]*LockRequest
+	lockTable map[any][]*LockRequest
 
 	// waitFor represents the wait-for graph for deadlock detection.
 	// waitFor[txnA][txnB] = true means transaction A is waiting for transaction B.
 	waitFor map[TransactionID]map[TransactionID]bool
 
+	// waitLatency is the time from requesting a lock to being granted it.
+	waitLatency histogram.Histogram
+
 	// mu protects all LockManager state from concurrent access.
 	mu sync.RWMutex
 }
@@ -75,7 +81,7 @@ type LockManager struct {
 // NewLockManager creates a new lock manager.
 func NewLockManager() *LockManager {
 	return &LockManager{
-		lockTable: make(map[RID][]*LockRequest),
+		lockTable: make(map[any][]*LockRequest),
 		waitFor:   make(map[TransactionID]map[TransactionID]bool),
 	}
 }
@@ -104,48 +110,8 @@ func NewLockManager() *LockManager {
 //	    // Handle error
 //	}
 func (lm *LockManager) LockShared(txn *Transaction, rid RID) error {
-	if !txn.IsActive() {
-		return ErrTransactionNotActive
-	}
-
-	lm.mu.Lock()
-	defer lm.mu.Unlock()
-
-	// Check if lock can be granted immediately
-	if lm.canGrantLock(rid, LockModeShared) {
-		lm.grantLock(rid, txn.ID, LockModeShared)
-		return nil
-	}
-
-	// Add to wait queue
-	req := &LockRequest{
-		TxnID:   txn.ID,
-		Mode:    LockModeShared,
-		Granted: false,
-		Cond:    sync.NewCond(&lm.mu),
-	}
-	lm.lockTable[rid] = append(lm.lockTable[rid], req)
-
-	// Update wait-for graph before checking for deadlock
-	lm.updateWaitForGraph(rid)
-
-	// Check for deadlock
-	if lm.hasDeadlock(txn.ID) {
-		lm.removeRequest(rid, req)
-		return ErrDeadlock
-	}
-
-	// Wait for lock
-	for !req.Granted {
-		req.Cond.Wait()
-		// Check again for deadlock after waking up
-		if lm.hasDeadlock(txn.ID) {
-			lm.removeRequest(rid, req)
-			return ErrDeadlock
-		}
-	}
-
-	return nil
+	_, err := lm.lock(txn, rid, LockModeShared)
+	return err
 }
 
 // LockExclusive acquires an exclusive (write) lock on the given tuple for the transaction.
@@ -172,35 +138,43 @@ func (lm *LockManager) LockShared(txn *Transaction, rid RID) error {
 //	}
 //	// Now safe to modify the tuple
 func (lm *LockManager) LockExclusive(txn *Transaction, rid RID) error {
+	_, err := lm.lock(txn, rid, LockModeExclusive)
+	return err
+}
+
+// lock acquires a lock of the given mode on target (a RID or a KeyLock), waiting if necessary.
+// It returns the granted request.
+func (lm *LockManager) lock(txn *Transaction, target any, mode LockMode) (*LockRequest, error) {
 	if !txn.IsActive() {
-		return ErrTransactionNotActive
+		return nil, ErrTransactionNotActive
 	}
 
+	start := time.Now()
 	lm.mu.Lock()
 	defer lm.mu.Unlock()
 
 	// Check if lock can be granted immediately
-	if lm.canGrantLock(rid, LockModeExclusive) {
-		lm.grantLock(rid, txn.ID, LockModeExclusive)
-		return nil
+	if lm.canGrantLock(target, txn.ID, mode) {
+		lm.waitLatency.Since(start)
+		return lm.grantLock(target, txn.ID, mode), nil
 	}
 
 	// Add to wait queue
 	req := &LockRequest{
 		TxnID:   txn.ID,
-		Mode:    LockModeExclusive,
+		Mode:    mode,
 		Granted: false,
 		Cond:    sync.NewCond(&lm.mu),
 	}
-	lm.lockTable[rid] = append(lm.lockTable[rid], req)
+	lm.lockTable[target] = append(lm.lockTable[target], req)
 
 	// Update wait-for graph before checking for deadlock
-	lm.updateWaitForGraph(rid)
+	lm.updateWaitForGraph(target)
 
 	// Check for deadlock
 	if lm.hasDeadlock(txn.ID) {
-		lm.removeRequest(rid, req)
-		return ErrDeadlock
+		lm.removeRequest(target, req)
+		return nil, ErrDeadlock
 	}
 
 	// Wait for lock
@@ -208,12 +182,13 @@ func (lm *LockManager) LockExclusive(txn *Transaction, rid RID) error {
 		req.Cond.Wait()
 		// Check again for deadlock after waking up
 		if lm.hasDeadlock(txn.ID) {
-			lm.removeRequest(rid, req)
-			return ErrDeadlock
+			lm.removeRequest(target, req)
+			return nil, ErrDeadlock
 		}
 	}
 
-	return nil
+	lm.waitLatency.Since(start)
+	return req, nil
 }
 
 // Unlock releases all locks held by the transaction on the given tuple.
@@ -231,10 +206,14 @@ func (lm *LockManager) LockExclusive(txn *Transaction, rid RID) error {
 // the transaction should not acquire new locks (though this is not enforced
 // by the LockManager itself).
 func (lm *LockManager) Unlock(txn *Transaction, rid RID) error {
+	return lm.unlock(txn, rid)
+}
+
+func (lm *LockManager) unlock(txn *Transaction, target any) error {
 	lm.mu.Lock()
 	defer lm.mu.Unlock()
 
-	requests := lm.lockTable[rid]
+	requests := lm.lockTable[target]
 	newRequests := make([]*LockRequest, 0, len(requests))
 
 	for _, req := range requests {
@@ -245,13 +224,13 @@ func (lm *LockManager) Unlock(txn *Transaction, rid RID) error {
 		newRequests = append(newRequests, req)
 	}
 
-	lm.lockTable[rid] = newRequests
+	lm.setRequests(target, newRequests)
 
 	// Try to grant pending locks
-	lm.grantPendingLocks(rid)
+	lm.grantPendingLocks(target)
 
 	// Update wait-for graph after granting locks (as grants may change wait relationships)
-	lm.updateWaitForGraph(rid)
+	lm.updateWaitForGraph(target)
 
 	return nil
 }
@@ -270,7 +249,7 @@ func (lm *LockManager) UnlockAll(txn *Transaction) {
 	lm.mu.Lock()
 	defer lm.mu.Unlock()
 
-	for rid, requests := range lm.lockTable {
+	for target, requests := range lm.lockTable {
 		newRequests := make([]*LockRequest, 0, len(requests))
 		for _, req := range requests {
 			if req.TxnID == txn.ID && req.Granted {
@@ -278,9 +257,9 @@ func (lm *LockManager) UnlockAll(txn *Transaction) {
 			}
 			newRequests = append(newRequests, req)
 		}
-		lm.lockTable[rid] = newRequests
-		lm.grantPendingLocks(rid)
-		lm.updateWaitForGraph(rid)
+		lm.setRequests(target, newRequests)
+		lm.grantPendingLocks(target)
+		lm.updateWaitForGraph(target)
 	}
 
 	// Remove from wait-for graph
@@ -301,11 +280,14 @@ func (lm *LockManager) UnlockAll(txn *Transaction) {
 //   - Shared + Exclusive: Not compatible ✗
 //   - Exclusive + Exclusive: Not compatible ✗
 //
+// Locks already granted to txnID itself are ignored, so a transaction can upgrade
+// its own shared lock to an exclusive one (as next-key locking does).
+//
 // Returns:
 //   - true if the lock can be granted immediately
 //   - false if the lock must wait
-func (lm *LockManager) canGrantLock(rid RID, mode LockMode) bool {
-	requests := lm.lockTable[rid]
+func (lm *LockManager) canGrantLock(target any, txnID TransactionID, mode LockMode) bool {
+	requests := lm.lockTable[target]
 
 	if len(requests) == 0 {
 		return true
@@ -314,7 +296,7 @@ func (lm *LockManager) canGrantLock(rid RID, mode LockMode) bool {
 	// Check if there are any granted locks
 	hasGrantedLocks := false
 	for _, req := range requests {
-		if req.Granted {
+		if req.Granted && req.TxnID != txnID {
 			hasGrantedLocks = true
 			if req.Mode == LockModeExclusive {
 				// Exclusive lock is held, cannot grant
@@ -332,7 +314,7 @@ func (lm *LockManager) canGrantLock(rid RID, mode LockMode) bool {
 		// Check if all granted locks are shared
 		allShared := true
 		for _, req := range requests {
-			if req.Granted && req.Mode == LockModeExclusive {
+			if req.Granted && req.TxnID != txnID && req.Mode == LockModeExclusive {
 				allShared = false
 				break
 			}
@@ -350,13 +332,14 @@ func (lm *LockManager) canGrantLock(rid RID, mode LockMode) bool {
 //
 // After granting, all waiting threads are notified via Broadcast() so they
 // can check if their lock requests can now be granted.
-func (lm *LockManager) grantLock(rid RID, txnID TransactionID, mode LockMode) {
-	requests := lm.lockTable[rid]
+// It returns the granted request.
+func (lm *LockManager) grantLock(target any, txnID TransactionID, mode LockMode) *LockRequest {
+	requests := lm.lockTable[target]
 	for _, req := range requests {
 		if req.TxnID == txnID && !req.Granted {
 			req.Granted = true
 			req.Cond.Broadcast()
-			return
+			return req
 		}
 	}
 
@@ -367,7 +350,8 @@ func (lm *LockManager) grantLock(rid RID, txnID TransactionID, mode LockMode) {
 		Granted: true,
 		Cond:    sync.NewCond(&lm.mu),
 	}
-	lm.lockTable[rid] = append(lm.lockTable[rid], req)
+	lm.lockTable[target] = append(lm.lockTable[target], req)
+	return req
 }
 
 // grantPendingLocks attempts to grant any pending locks that can now be granted.
@@ -378,11 +362,11 @@ func (lm *LockManager) grantLock(rid RID, txnID TransactionID, mode LockMode) {
 //
 // When a lock is granted, the waiting transaction is notified via Broadcast()
 // so it can proceed.
-func (lm *LockManager) grantPendingLocks(rid RID) {
-	requests := lm.lockTable[rid]
+func (lm *LockManager) grantPendingLocks(target any) {
+	requests := lm.lockTable[target]
 	for _, req := range requests {
 		if !req.Granted {
-			if lm.canGrantLock(rid, req.Mode) {
+			if lm.canGrantLock(target, req.TxnID, req.Mode) {
 				req.Granted = true
 				req.Cond.Broadcast()
 				// For exclusive locks, only grant one at a time to maintain FIFO order
@@ -411,8 +395,8 @@ func (lm *LockManager) grantPendingLocks(rid RID) {
 //  2. For each waiting transaction, clears old edges related to this RID and adds new edges to all holding transactions
 //
 // The graph is used by hasDeadlock() to detect cycles, which indicate deadlocks.
-func (lm *LockManager) updateWaitForGraph(rid RID) {
-	requests := lm.lockTable[rid]
+func (lm *LockManager) updateWaitForGraph(target any) {
+	requests := lm.lockTable[target]
 
 	// Find granted transactions (currently holding locks)
 	grantedTxns := make(map[TransactionID]bool)
@@ -437,9 +421,11 @@ func (lm *LockManager) updateWaitForGraph(rid RID) {
 					delete(lm.waitFor[req.TxnID], txnID)
 				}
 			}
-			// Add edges to all currently granted transactions
+			// Add edges to all currently granted transactions (a transaction never waits for itself)
 			for txnID := range grantedTxns {
-				lm.waitFor[req.TxnID][txnID] = true
+				if txnID != req.TxnID {
+					lm.waitFor[req.TxnID][txnID] = true
+				}
 			}
 		}
 	}
@@ -569,15 +555,15 @@ func (lm *LockManager) dfsDeadlock(txnID TransactionID, visited map[TransactionI
 //
 // After removing the request, grantPendingLocks is called to check if any
 // waiting transactions can now acquire their locks.
-func (lm *LockManager) removeRequest(rid RID, req *LockRequest) {
-	requests := lm.lockTable[rid]
+func (lm *LockManager) removeRequest(target any, req *LockRequest) {
+	requests := lm.lockTable[target]
 	newRequests := make([]*LockRequest, 0, len(requests))
 	for _, r := range requests {
 		if r != req {
 			newRequests = append(newRequests, r)
 		}
 	}
-	lm.lockTable[rid] = newRequests
+	lm.setRequests(target, newRequests)
 
 	// Remove from wait-for graph
 	delete(lm.waitFor, req.TxnID)
@@ -585,9 +571,19 @@ func (lm *LockManager) removeRequest(rid RID, req *LockRequest) {
 		delete(waiters, req.TxnID)
 	}
 
-	// Update wait-for graph for this RID after removing the request
-	lm.updateWaitForGraph(rid)
+	// Update wait-for graph for this resource after removing the request
+	lm.updateWaitForGraph(target)
 
 	// Try to grant pending locks after removing the request
-	lm.grantPendingLocks(rid)
+	lm.grantPendingLocks(target)
+}
+
+// setRequests replaces the requests on target, deleting the lock table entry once it is empty
+// so the table does not grow with every key ever locked.
+func (lm *LockManager) setRequests(target any, requests []*LockRequest) {
+	if len(requests) == 0 {
+		delete(lm.lockTable, target)
+		return
+	}
+	lm.lockTable[target] = requests
 }
@@ -2,16 +2,18 @@ package transaction
 
 import (
 	"encoding/binary"
-	"errors"
 	"io"
-	"os"
 	"sync"
 
+	"github.com/Johniel/gorelly/clock"
 	"github.com/Johniel/gorelly/disk"
+	"github.com/Johniel/gorelly/errcode"
 )
 
 var (
-	ErrLogCorrupted = errors.New("log file is corrupted")
+	ErrLogCorrupted = errcode.New(errcode.Corruption, "log file is corrupted")
+	// ErrLogFrozen is returned when freezing a LogManager that is already frozen.
+	ErrLogFrozen = errcode.New(errcode.ObjectNotInPrerequisiteState, "log writes already frozen")
 )
 
 type LogRecordType int
@@ -21,7 +23,19 @@ const (
 	LogRecordTypeCommit
 	LogRecordTypeAbort
 	LogRecordTypeBegin
+	// LogRecordTypeCheckpoint records a checkpoint (see LogManager.Checkpoint), with its content
+	// in Checkpoint.
 	LogRecordTypeCheckpoint
+	// LogRecordTypeBufferedInsert records a pair inserted into the btree.WriteBuffer of the
+	// tree whose meta page is PageID, with the pair in Key and NewValue.
+	LogRecordTypeBufferedInsert
+	// LogRecordTypeBufferFlush records that the write buffer of the tree whose meta page is
+	// PageID was flushed into the tree.
+	LogRecordTypeBufferFlush
+	// LogRecordTypeCreateTree records the allocation and initialization of the meta page PageID
+	// and the root page RootPageID of a new B+ tree (see btree.CreateBTreeWithLog). Redoing it
+	// initializes both pages again; undoing it frees them.
+	LogRecordTypeCreateTree
 )
 
 type LogRecord struct {
@@ -31,150 +45,232 @@ type LogRecord struct {
 	Offset   int
 	OldValue []byte
 	NewValue []byte
-	LSN      uint64 // Log Sequence Number
+	LSN      uint64          // Log Sequence Number
+	CommitTS clock.Timestamp // Commit timestamp; set on commit records only
+	Key      []byte          // Key of a buffered insert; set on buffered insert records only
+	// Changed ranges of a delta-encoded update record (see NewUpdateRecord); nil otherwise.
+	// OldValue and NewValue of a delta-encoded record are empty.
+	Delta      []DeltaRun
+	Checkpoint *CheckpointData // Set on checkpoint records only
+	RootPageID disk.PageID     // Root page of the created tree; set on create tree records only
+}
+
+// LSNSource assigns log sequence numbers. It must return strictly increasing values.
+type LSNSource interface {
+	NextLSN() uint64
 }
 
 type LogManager struct {
-	logFile *os.File
-	nextLSN uint64
-	mu      sync.Mutex
+	files        *logFiles
+	syncPolicy   SyncPolicy
+	stopPeriodic func() // Stops the background sync of SyncModePeriodic
+	nextLSN      uint64
+	lsns         LSNSource                // Optional: overrides the internal LSN counter
+	frozen       bool                     // Whether appends are blocked (see Freeze)
+	thawed       *sync.Cond               // Signalled when the freeze is lifted
+	walBytes     map[TransactionID]uint64 // Bytes appended per transaction, until taken by takeWALBytes
+	lastCommitTS clock.Timestamp          // Greatest commit timestamp in the log
+	lastTxnID    TransactionID            // Greatest transaction ID in the log, or carried by a checkpoint
+	durableLSN   uint64                   // LSN up to which the log is known to be synced
+	active       map[TransactionID]logTxn // Transactions begun and not yet ended in the log
+	recoverFrom  int64                    // Offset Recover reads from, set by the last checkpoint
+	archiveDir   string                   // Optional directory Truncate moves removed records to
+	mu           sync.Mutex
+}
+
+// logTxn locates the Begin record of a transaction active in the log.
+type logTxn struct {
+	beginLSN    uint64
+	beginOffset int64
 }
 
+// NewLogManager opens the log in the single file at logPath.
 func NewLogManager(logPath string) (*LogManager, error) {
-	file, err := os.OpenFile(logPath, os.O_CREATE|os.O_RDWR|os.O_APPEND, 0644)
+	return newLogManager(logPath, 0)
+}
+
+func newLogManager(logPath string, segmentSize int64) (*LogManager, error) {
+	files, err := openLogFiles(logPath, segmentSize)
 	if err != nil {
 		return nil, err
 	}
 
 	lm := &LogManager{
-		logFile: file,
-		nextLSN: 1,
+		files:    files,
+		nextLSN:  1,
+		walBytes: make(map[TransactionID]uint64),
+		active:   make(map[TransactionID]logTxn),
 	}
+	lm.thawed = sync.NewCond(&lm.mu)
 
 	// Recover LSN from log file
 	if err := lm.recoverLSN(); err != nil {
+		files.close()
 		return nil, err
 	}
 
 	return lm, nil
 }
 
-// recoverLSN recovers the next LSN from the log file.
+// recoverLSN recovers the next LSN, the last commit timestamp and transaction ID, the active transactions and
+// the position of the last checkpoint from the log file.
+//
+// A record at the end of the log that is cut short or fails its checksum was torn by a crash
+// while it was appended; it was never synced, so no commit depends on it, and the log is
+// truncated before it. A bad record followed by others is reported as ErrLogCorrupted.
 func (lm *LogManager) recoverLSN() error {
-	stat, err := lm.logFile.Stat()
-	if err != nil {
-		return err
-	}
-
-	if stat.Size() == 0 {
+	if lm.files.end() == 0 {
 		lm.nextLSN = 1
 		return nil
 	}
 
-	lm.logFile.Seek(0, io.SeekStart)
+	offset := lm.files.start()
+	end := lm.files.end()
+	header := make([]byte, 12)
 	var lastLSN uint64
-	for {
-		var lsn uint64
-		if err := binary.Read(lm.logFile, binary.BigEndian, &lsn); err != nil {
-			if err == io.EOF {
+	for offset < end {
+		if end-offset < int64(len(header)) {
+			break
+		}
+		if _, err := lm.files.ReadAt(header, offset); err != nil {
+			return err
+		}
+		lsn := binary.BigEndian.Uint64(header)
+		recordSize := int64(binary.BigEndian.Uint32(header[8:]))
+		if end-offset-12 < recordSize {
+			break
+		}
+		recordData := make([]byte, recordSize)
+		if _, err := lm.files.ReadAt(recordData, offset+12); err != nil {
+			return err
+		}
+		record, err := deserializeRecord(lsn, recordData)
+		if err != nil {
+			if offset+12+recordSize == end {
 				break
 			}
 			return err
 		}
 		lastLSN = lsn
-
-		var recordSize uint32
-		if err := binary.Read(lm.logFile, binary.BigEndian, &recordSize); err != nil {
-			if err == io.EOF {
-				break
-			}
+		lm.observe(record, offset)
+		offset += 12 + recordSize
+	}
+	if offset < end {
+		if err := lm.files.truncate(offset); err != nil {
 			return err
 		}
-		lm.logFile.Seek(int64(recordSize), io.SeekCurrent)
 	}
 	lm.nextLSN = lastLSN + 1
+	lm.durableLSN = lastLSN
 	return nil
 }
 
+// observe updates the state tracked from the log with a record at offset.
+// The caller must hold lm.mu or be opening the log.
+func (lm *LogManager) observe(record *LogRecord, offset int64) {
+	lm.lastCommitTS = max(lm.lastCommitTS, record.CommitTS)
+	lm.lastTxnID = max(lm.lastTxnID, record.TxnID)
+	switch record.Type {
+	case LogRecordTypeBegin:
+		lm.active[record.TxnID] = logTxn{beginLSN: record.LSN, beginOffset: offset}
+	case LogRecordTypeCommit, LogRecordTypeAbort:
+		delete(lm.active, record.TxnID)
+	case LogRecordTypeCheckpoint:
+		if record.Checkpoint != nil {
+			lm.recoverFrom = record.Checkpoint.StartOffset
+			if record.Checkpoint.NextTxnID > 0 {
+				lm.lastTxnID = max(lm.lastTxnID, record.Checkpoint.NextTxnID-1)
+			}
+		}
+	}
+}
+
 func (lm *LogManager) AppendLog(record *LogRecord) error {
 	lm.mu.Lock()
 	defer lm.mu.Unlock()
+	if err := lm.append(record); err != nil {
+		return err
+	}
+	return lm.syncAppended()
+}
+
+// append assigns the LSN of a record and writes it, waiting while the log is frozen.
+// The caller must hold lm.mu.
+func (lm *LogManager) append(record *LogRecord) error {
+	for lm.frozen {
+		lm.thawed.Wait()
+	}
 
-	record.LSN = lm.nextLSN
-	lm.nextLSN++
+	if lm.lsns != nil {
+		record.LSN = lm.lsns.NextLSN()
+		lm.nextLSN = record.LSN + 1
+	} else {
+		record.LSN = lm.nextLSN
+		lm.nextLSN++
+	}
 
 	// Serialize log record
-	data := lm.serializeRecord(record)
+	data := serializeRecord(record)
 
 	// Write to log file
-	if _, err := lm.logFile.Write(data); err != nil {
+	offset := lm.files.end()
+	if err := lm.files.write(data); err != nil {
 		return err
 	}
+	lm.walBytes[record.TxnID] += uint64(len(data))
+	lm.observe(record, offset)
+	return nil
+}
 
-	return lm.logFile.Sync()
+// SetLSNSource makes the log take the LSNs of new records from src, e.g. so that tests can
+// drive a deterministic sequence shared with other components. A nil src restores the
+// internal counter, which continues after the last assigned LSN.
+func (lm *LogManager) SetLSNSource(src LSNSource) {
+	lm.mu.Lock()
+	defer lm.mu.Unlock()
+	lm.lsns = src
 }
 
-func (lm *LogManager) serializeRecord(record *LogRecord) []byte {
-	buf := make([]byte, 0, 1024)
-
-	// LSN
-	lsnBytes := make([]byte, 8)
-	binary.BigEndian.PutUint64(lsnBytes, record.LSN)
-	buf = append(buf, lsnBytes...)
-
-	// Record size (will be filled later)
-	sizePos := len(buf)
-	buf = append(buf, make([]byte, 4)...)
-
-	// Type
-	typeBytes := make([]byte, 4)
-	binary.BigEndian.PutUint32(typeBytes, uint32(record.Type))
-	buf = append(buf, typeBytes...)
-
-	// TxnID
-	txnIDBytes := make([]byte, 8)
-	binary.BigEndian.PutUint64(txnIDBytes, uint64(record.TxnID))
-	buf = append(buf, txnIDBytes...)
-
-	// PageID
-	pageIDBytes := make([]byte, 8)
-	binary.BigEndian.PutUint64(pageIDBytes, uint64(record.PageID))
-	buf = append(buf, pageIDBytes...)
-
-	// Offset
-	offsetBytes := make([]byte, 4)
-	binary.BigEndian.PutUint32(offsetBytes, uint32(record.Offset))
-	buf = append(buf, offsetBytes...)
-
-	// OldValue length and data
-	oldValueLenBytes := make([]byte, 4)
-	binary.BigEndian.PutUint32(oldValueLenBytes, uint32(len(record.OldValue)))
-	buf = append(buf, oldValueLenBytes...)
-	buf = append(buf, record.OldValue...)
-
-	// NewValue length and data
-	newValueLenBytes := make([]byte, 4)
-	binary.BigEndian.PutUint32(newValueLenBytes, uint32(len(record.NewValue)))
-	buf = append(buf, newValueLenBytes...)
-	buf = append(buf, record.NewValue...)
-
-	// Fill in record size
-	binary.BigEndian.PutUint32(buf[sizePos:], uint32(len(buf)-sizePos-4))
-
-	return buf
+// LastCommitTS returns the greatest commit timestamp in the log, or 0 if it has no commits
+// with a timestamp.
+func (lm *LogManager) LastCommitTS() clock.Timestamp {
+	lm.mu.Lock()
+	defer lm.mu.Unlock()
+	return lm.lastCommitTS
+}
+
+// LastTxnID returns the greatest transaction ID in the log, including the IDs assigned before
+// the last checkpoint and since truncated, or 0 if the log has no transactions.
+func (lm *LogManager) LastTxnID() TransactionID {
+	lm.mu.Lock()
+	defer lm.mu.Unlock()
+	return lm.lastTxnID
+}
+
+// takeWALBytes returns the number of log bytes appended for the transaction and stops tracking it.
+func (lm *LogManager) takeWALBytes(txnID TransactionID) uint64 {
+	lm.mu.Lock()
+	defer lm.mu.Unlock()
+	n := lm.walBytes[txnID]
+	delete(lm.walBytes, txnID)
+	return n
 }
 
-// ReadLog reads log records from the log file.
+// ReadLog reads log records from the log file, or from every segment in order if the log is segmented.
 func (lm *LogManager) ReadLog() ([]*LogRecord, error) {
 	lm.mu.Lock()
 	defer lm.mu.Unlock()
+	return lm.readLogFrom(lm.files.start())
+}
 
-	lm.logFile.Seek(0, io.SeekStart)
+// readLogFrom reads the log records from offset on. The caller must hold lm.mu.
+func (lm *LogManager) readLogFrom(offset int64) ([]*LogRecord, error) {
+	reader := io.NewSectionReader(lm.files, offset, lm.files.end()-offset)
 	var records []*LogRecord
 
 	for {
 		var lsn uint64
-		if err := binary.Read(lm.logFile, binary.BigEndian, &lsn); err != nil {
+		if err := binary.Read(reader, binary.BigEndian, &lsn); err != nil {
 			if err == io.EOF {
 				break
 			}
@@ -182,7 +278,7 @@ func (lm *LogManager) ReadLog() ([]*LogRecord, error) {
 		}
 
 		var recordSize uint32
-		if err := binary.Read(lm.logFile, binary.BigEndian, &recordSize); err != nil {
+		if err := binary.Read(reader, binary.BigEndian, &recordSize); err != nil {
 			if err == io.EOF {
 				break
 			}
@@ -190,70 +286,80 @@ func (lm *LogManager) ReadLog() ([]*LogRecord, error) {
 		}
 
 		recordData := make([]byte, recordSize)
-		if _, err := io.ReadFull(lm.logFile, recordData); err != nil {
+		if _, err := io.ReadFull(reader, recordData); err != nil {
 			return nil, err
 		}
 
-		record := lm.deserializeRecord(lsn, recordData)
+		record, err := deserializeRecord(lsn, recordData)
+		if err != nil {
+			return nil, err
+		}
 		records = append(records, record)
 	}
 
 	return records, nil
 }
 
-func (lm *LogManager) deserializeRecord(lsn uint64, data []byte) *LogRecord {
-	pos := 0
-
-	// Type
-	typeVal := binary.BigEndian.Uint32(data[pos:])
-	pos += 4
-
-	// TxnID
-	txnID := TransactionID(binary.BigEndian.Uint64(data[pos:]))
-	pos += 8
-
-	// PageID
-	pageID := disk.PageID(binary.BigEndian.Uint64(data[pos:]))
-	pos += 8
-
-	// Offset
-	offset := int(binary.BigEndian.Uint32(data[pos:]))
-	pos += 4
-
-	// OldValue
-	oldValueLen := int(binary.BigEndian.Uint32(data[pos:]))
-	pos += 4
-	oldValue := make([]byte, oldValueLen)
-	copy(oldValue, data[pos:pos+oldValueLen])
-	pos += oldValueLen
-
-	// NewValue
-	newValueLen := int(binary.BigEndian.Uint32(data[pos:]))
-	pos += 4
-	newValue := make([]byte, newValueLen)
-	copy(newValue, data[pos:pos+newValueLen])
-
-	return &LogRecord{
-		LSN:      lsn,
-		Type:     LogRecordType(typeVal),
-		TxnID:    txnID,
-		PageID:   pageID,
-		Offset:   offset,
-		OldValue: oldValue,
-		NewValue: newValue,
+// Flush flushes the log to disk.
+func (lm *LogManager) Flush() error {
+	lm.mu.Lock()
+	defer lm.mu.Unlock()
+	return lm.sync()
+}
+
+// FlushTo makes the log durable up to and including lsn. It syncs the log file only if the
+// sync policy has not done so already. The LogManager is a buffer.WAL.
+func (lm *LogManager) FlushTo(lsn uint64) error {
+	lm.mu.Lock()
+	defer lm.mu.Unlock()
+	if lsn <= lm.durableLSN {
+		return nil
+	}
+	return lm.sync()
+}
+
+// sync syncs the log file. The caller must hold lm.mu.
+func (lm *LogManager) sync() error {
+	if err := lm.files.sync(); err != nil {
+		return err
 	}
+	lm.durableLSN = lm.nextLSN - 1
+	return nil
 }
 
-// Flush flushes the log to disk.
-func (lm *LogManager) Flush() error {
+// Freeze syncs the log file and then blocks every subsequent AppendLog call until Thaw is called.
+func (lm *LogManager) Freeze() error {
+	lm.mu.Lock()
+	defer lm.mu.Unlock()
+	if lm.frozen {
+		return ErrLogFrozen
+	}
+	if err := lm.files.sync(); err != nil {
+		return err
+	}
+	lm.frozen = true
+	return nil
+}
+
+// Thaw lifts a freeze and wakes up the appenders blocked by it.
+func (lm *LogManager) Thaw() {
 	lm.mu.Lock()
 	defer lm.mu.Unlock()
-	return lm.logFile.Sync()
+	lm.frozen = false
+	lm.thawed.Broadcast()
 }
 
-// Close closes the log file.
+// Close stops the background sync, syncs and closes the log file.
 func (lm *LogManager) Close() error {
+	lm.mu.Lock()
+	stopPeriodic := lm.stopPeriodic
+	lm.stopPeriodic = nil
+	lm.mu.Unlock()
+	if stopPeriodic != nil {
+		stopPeriodic()
+	}
+
 	lm.mu.Lock()
 	defer lm.mu.Unlock()
-	return lm.logFile.Close()
+	return lm.files.close()
 }
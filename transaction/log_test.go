@@ -3,6 +3,7 @@ package transaction
 import (
 	"os"
 	"testing"
+	"time"
 
 	"github.com/Johniel/gorelly/disk"
 )
@@ -183,6 +184,81 @@ func TestLogManagerLSNRecovery(t *testing.T) {
 	}
 }
 
+func TestLogManagerTornTail(t *testing.T) {
+	tmpfile, err := os.CreateTemp("", "test_log_*.log")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(tmpfile.Name())
+	tmpfile.Close()
+
+	lm1, err := NewLogManager(tmpfile.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, recordType := range []LogRecordType{LogRecordTypeBegin, LogRecordTypeCommit} {
+		if err := lm1.AppendLog(&LogRecord{Type: recordType, TxnID: 1}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	lm1.Close()
+	stat, err := os.Stat(tmpfile.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	validSize := stat.Size()
+
+	// A crash while appending a record leaves a part of it behind
+	torn := serializeRecord(&LogRecord{Type: LogRecordTypeUpdate, TxnID: 2, NewValue: []byte("lost"), LSN: 3})
+	for _, tail := range [][]byte{torn[:8], torn[:len(torn)-1]} {
+		file, err := os.OpenFile(tmpfile.Name(), os.O_WRONLY|os.O_APPEND, 0644)
+		if err != nil {
+			t.Fatal(err)
+		}
+		file.Write(tail)
+		file.Close()
+
+		lm2, err := NewLogManager(tmpfile.Name())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if stat, err := os.Stat(tmpfile.Name()); err != nil || stat.Size() != validSize {
+			t.Errorf("expected the torn record to be truncated, got size %d", stat.Size())
+		}
+		record := &LogRecord{Type: LogRecordTypeBegin, TxnID: 3}
+		if err := lm2.AppendLog(record); err != nil {
+			t.Fatal(err)
+		}
+		if record.LSN != 3 {
+			t.Errorf("expected LSN 3 after the truncated tail, got %d", record.LSN)
+		}
+		records, err := lm2.ReadLog()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(records) != 3 {
+			t.Errorf("expected 3 records, got %d", len(records))
+		}
+		lm2.Close()
+		if err := os.Truncate(tmpfile.Name(), validSize); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// A bad record followed by others is not a torn write
+	data, err := os.ReadFile(tmpfile.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	data[12+1] ^= 0xff
+	if err := os.WriteFile(tmpfile.Name(), data, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := NewLogManager(tmpfile.Name()); err != ErrLogCorrupted {
+		t.Errorf("expected ErrLogCorrupted, got %v", err)
+	}
+}
+
 func TestLogManagerFlush(t *testing.T) {
 	tmpfile, err := os.CreateTemp("", "test_log_*.log")
 	if err != nil {
@@ -361,3 +437,99 @@ func TestLogManagerAllRecordTypes(t *testing.T) {
 		}
 	}
 }
+
+func TestLogManagerFreeze(t *testing.T) {
+	tmpfile, err := os.CreateTemp("", "test_log_freeze_*.log")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(tmpfile.Name())
+	tmpfile.Close()
+
+	lm, err := NewLogManager(tmpfile.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer lm.Close()
+
+	if err := lm.Freeze(); err != nil {
+		t.Fatal(err)
+	}
+	if err := lm.Freeze(); err != ErrLogFrozen {
+		t.Errorf("Expected ErrLogFrozen, got %v", err)
+	}
+
+	done := make(chan error)
+	go func() {
+		done <- lm.AppendLog(&LogRecord{Type: LogRecordTypeBegin, TxnID: 1})
+	}()
+	select {
+	case <-done:
+		t.Fatal("AppendLog completed while frozen")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	lm.Thaw()
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+
+	records, err := lm.ReadLog()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(records) != 1 {
+		t.Errorf("Expected 1 record after thaw, got %d", len(records))
+	}
+}
+
+type stepLSNs struct {
+	next uint64
+	step uint64
+}
+
+func (s *stepLSNs) NextLSN() uint64 {
+	lsn := s.next
+	s.next += s.step
+	return lsn
+}
+
+func TestLogManagerLSNSource(t *testing.T) {
+	tmpfile, err := os.CreateTemp("", "test_log_lsn_source_*.log")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(tmpfile.Name())
+	tmpfile.Close()
+
+	lm, err := NewLogManager(tmpfile.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer lm.Close()
+
+	lm.SetLSNSource(&stepLSNs{next: 100, step: 10})
+	var lsns []uint64
+	for i := 0; i < 2; i++ {
+		record := &LogRecord{Type: LogRecordTypeBegin, TxnID: TransactionID(i)}
+		if err := lm.AppendLog(record); err != nil {
+			t.Fatal(err)
+		}
+		lsns = append(lsns, record.LSN)
+	}
+	// The internal counter continues after the last injected LSN
+	lm.SetLSNSource(nil)
+	record := &LogRecord{Type: LogRecordTypeCommit, TxnID: 1}
+	if err := lm.AppendLog(record); err != nil {
+		t.Fatal(err)
+	}
+	lsns = append(lsns, record.LSN)
+
+	expected := []uint64{100, 110, 111}
+	for i := range expected {
+		if lsns[i] != expected[i] {
+			t.Errorf("expected LSNs %v, got %v", expected, lsns)
+			break
+		}
+	}
+}
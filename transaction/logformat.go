@@ -0,0 +1,300 @@
+package transaction
+
+import (
+	"encoding/binary"
+	"hash/crc32"
+	"io"
+	"os"
+
+	"github.com/Johniel/gorelly/clock"
+	"github.com/Johniel/gorelly/disk"
+)
+
+// LogFormat identifies the encoding of a log record body.
+// Every record is framed as [LSN (8B)][body size (4B)][body] in all formats.
+type LogFormat uint8
+
+const (
+	// LogFormatV1 is the original positional layout:
+	// [type (4B)][txn ID (8B)][page ID (8B)][offset (4B)][old len (4B)][old][new len (4B)][new].
+	// Its first byte is always 0, because record types are small.
+	LogFormatV1 LogFormat = 1
+	// LogFormatV2 is [format (1B)] followed by tagged fields [tag][length][data], with tag and
+	// length encoded as uvarints. Readers skip fields with unknown tags, so fields can be added
+	// without breaking older readers, and fields missing from older records keep their zero value.
+	// The last field of every record is its checksum (see logFieldChecksum); a record without it
+	// is rejected. Only LogFormatV1 records are read unverified.
+	LogFormatV2 LogFormat = 2
+
+	// CurrentLogFormat is the format of newly appended records.
+	CurrentLogFormat = LogFormatV2
+)
+
+// Field tags of LogFormatV2. Tags must never be reused for a different field.
+const (
+	logFieldType       = 1
+	logFieldTxnID      = 2
+	logFieldPageID     = 3
+	logFieldOffset     = 4
+	logFieldOldValue   = 5
+	logFieldNewValue   = 6
+	logFieldCommitTS   = 7
+	logFieldKey        = 8
+	logFieldDelta      = 9
+	logFieldCheckpoint = 10
+	// logFieldChecksum holds the CRC-32C (4B) of the record's LSN and of its body up to this
+	// field. It is required and must be the last field, so that it covers every field of the record.
+	logFieldChecksum   = 11
+	logFieldRootPageID = 12
+)
+
+var logChecksumTable = crc32.MakeTable(crc32.Castagnoli)
+
+// recordChecksum returns the checksum of a record with the given LSN and body.
+func recordChecksum(lsn uint64, body []byte) uint32 {
+	var lsnBytes [8]byte
+	binary.BigEndian.PutUint64(lsnBytes[:], lsn)
+	return crc32.Update(crc32.Checksum(lsnBytes[:], logChecksumTable), logChecksumTable, body)
+}
+
+func serializeRecord(record *LogRecord) []byte {
+	body := []byte{byte(LogFormatV2)}
+	body = appendUvarintField(body, logFieldType, uint64(record.Type))
+	body = appendUvarintField(body, logFieldTxnID, uint64(record.TxnID))
+	body = appendUvarintField(body, logFieldPageID, uint64(record.PageID))
+	body = appendUvarintField(body, logFieldOffset, uint64(record.Offset))
+	body = appendField(body, logFieldOldValue, record.OldValue)
+	body = appendField(body, logFieldNewValue, record.NewValue)
+	if record.CommitTS != 0 {
+		body = appendUvarintField(body, logFieldCommitTS, uint64(record.CommitTS))
+	}
+	if record.Key != nil {
+		body = appendField(body, logFieldKey, record.Key)
+	}
+	if record.Delta != nil {
+		body = appendField(body, logFieldDelta, encodeDelta(record.Delta))
+	}
+	if record.Checkpoint != nil {
+		body = appendField(body, logFieldCheckpoint, encodeCheckpoint(record.Checkpoint))
+	}
+	if record.Type == LogRecordTypeCreateTree {
+		body = appendUvarintField(body, logFieldRootPageID, uint64(record.RootPageID))
+	}
+	body = appendField(body, logFieldChecksum, binary.BigEndian.AppendUint32(nil, recordChecksum(record.LSN, body)))
+	return frameRecord(record.LSN, body)
+}
+
+func appendField(buf []byte, tag uint64, data []byte) []byte {
+	buf = binary.AppendUvarint(buf, tag)
+	buf = binary.AppendUvarint(buf, uint64(len(data)))
+	return append(buf, data...)
+}
+
+func appendUvarintField(buf []byte, tag uint64, v uint64) []byte {
+	return appendField(buf, tag, binary.AppendUvarint(nil, v))
+}
+
+func frameRecord(lsn uint64, body []byte) []byte {
+	buf := make([]byte, 12, 12+len(body))
+	binary.BigEndian.PutUint64(buf, lsn)
+	binary.BigEndian.PutUint32(buf[8:], uint32(len(body)))
+	return append(buf, body...)
+}
+
+// deserializeRecord decodes a record body of any supported format.
+func deserializeRecord(lsn uint64, data []byte) (*LogRecord, error) {
+	if len(data) == 0 {
+		return nil, ErrLogCorrupted
+	}
+	if data[0] == 0 {
+		return deserializeRecordV1(lsn, data)
+	}
+	return deserializeRecordV2(lsn, data)
+}
+
+func deserializeRecordV2(lsn uint64, data []byte) (*LogRecord, error) {
+	record := &LogRecord{LSN: lsn}
+	pos := 1
+	verified := false
+	for pos < len(data) {
+		fieldStart := pos
+		tag, n := binary.Uvarint(data[pos:])
+		if n <= 0 {
+			return nil, ErrLogCorrupted
+		}
+		pos += n
+		size, n := binary.Uvarint(data[pos:])
+		if n <= 0 || uint64(len(data)-pos-n) < size {
+			return nil, ErrLogCorrupted
+		}
+		pos += n
+		field := data[pos : pos+int(size)]
+		pos += int(size)
+
+		switch tag {
+		case logFieldOldValue:
+			record.OldValue = append([]byte{}, field...)
+		case logFieldNewValue:
+			record.NewValue = append([]byte{}, field...)
+		case logFieldKey:
+			record.Key = append([]byte{}, field...)
+		case logFieldDelta:
+			delta, err := decodeDelta(field)
+			if err != nil {
+				return nil, err
+			}
+			record.Delta = delta
+		case logFieldCheckpoint:
+			data, err := decodeCheckpoint(field)
+			if err != nil {
+				return nil, err
+			}
+			record.Checkpoint = data
+		case logFieldType, logFieldTxnID, logFieldPageID, logFieldOffset, logFieldCommitTS, logFieldRootPageID:
+			v, n := binary.Uvarint(field)
+			if n != len(field) {
+				return nil, ErrLogCorrupted
+			}
+			switch tag {
+			case logFieldType:
+				record.Type = LogRecordType(v)
+			case logFieldTxnID:
+				record.TxnID = TransactionID(v)
+			case logFieldPageID:
+				record.PageID = disk.PageID(v)
+			case logFieldOffset:
+				record.Offset = int(v)
+			case logFieldCommitTS:
+				record.CommitTS = clock.Timestamp(v)
+			case logFieldRootPageID:
+				record.RootPageID = disk.PageID(v)
+			}
+		case logFieldChecksum:
+			if len(field) != 4 || pos != len(data) || binary.BigEndian.Uint32(field) != recordChecksum(lsn, data[:fieldStart]) {
+				return nil, ErrLogCorrupted
+			}
+			verified = true
+		default:
+			// Written by a newer version; skipped
+		}
+	}
+	if !verified {
+		return nil, ErrLogCorrupted
+	}
+	if record.OldValue == nil {
+		record.OldValue = []byte{}
+	}
+	if record.NewValue == nil {
+		record.NewValue = []byte{}
+	}
+	return record, nil
+}
+
+func deserializeRecordV1(lsn uint64, data []byte) (*LogRecord, error) {
+	const fixedSize = 4 + 8 + 8 + 4 + 4
+	if len(data) < fixedSize {
+		return nil, ErrLogCorrupted
+	}
+	pos := 0
+
+	// Type
+	typeVal := binary.BigEndian.Uint32(data[pos:])
+	pos += 4
+
+	// TxnID
+	txnID := TransactionID(binary.BigEndian.Uint64(data[pos:]))
+	pos += 8
+
+	// PageID
+	pageID := disk.PageID(binary.BigEndian.Uint64(data[pos:]))
+	pos += 8
+
+	// Offset
+	offset := int(binary.BigEndian.Uint32(data[pos:]))
+	pos += 4
+
+	// OldValue
+	oldValueLen := int(binary.BigEndian.Uint32(data[pos:]))
+	pos += 4
+	if len(data)-pos < oldValueLen+4 {
+		return nil, ErrLogCorrupted
+	}
+	oldValue := make([]byte, oldValueLen)
+	copy(oldValue, data[pos:pos+oldValueLen])
+	pos += oldValueLen
+
+	// NewValue
+	newValueLen := int(binary.BigEndian.Uint32(data[pos:]))
+	pos += 4
+	if len(data)-pos < newValueLen {
+		return nil, ErrLogCorrupted
+	}
+	newValue := make([]byte, newValueLen)
+	copy(newValue, data[pos:pos+newValueLen])
+
+	return &LogRecord{
+		LSN:      lsn,
+		Type:     LogRecordType(typeVal),
+		TxnID:    txnID,
+		PageID:   pageID,
+		Offset:   offset,
+		OldValue: oldValue,
+		NewValue: newValue,
+	}, nil
+}
+
+// serializeRecordV1 encodes a record in LogFormatV1. It is kept to produce logs of the old
+// format in tests.
+func serializeRecordV1(record *LogRecord) []byte {
+	body := make([]byte, 0, 32+len(record.OldValue)+len(record.NewValue))
+	body = binary.BigEndian.AppendUint32(body, uint32(record.Type))
+	body = binary.BigEndian.AppendUint64(body, uint64(record.TxnID))
+	body = binary.BigEndian.AppendUint64(body, uint64(record.PageID))
+	body = binary.BigEndian.AppendUint32(body, uint32(record.Offset))
+	body = binary.BigEndian.AppendUint32(body, uint32(len(record.OldValue)))
+	body = append(body, record.OldValue...)
+	body = binary.BigEndian.AppendUint32(body, uint32(len(record.NewValue)))
+	body = append(body, record.NewValue...)
+	return frameRecord(record.LSN, body)
+}
+
+// ConvertLog rewrites the log at srcPath into a new log at dstPath with every record in
+// CurrentLogFormat, keeping their LSNs. Logs are readable in any format, so converting is
+// only needed before handing a log to a tool that reads only the current format.
+// It returns the number of records converted.
+func ConvertLog(srcPath string, dstPath string) (int, error) {
+	src, err := os.Open(srcPath)
+	if err != nil {
+		return 0, err
+	}
+	defer src.Close()
+	dst, err := os.OpenFile(dstPath, os.O_CREATE|os.O_WRONLY|os.O_EXCL, 0644)
+	if err != nil {
+		return 0, err
+	}
+	defer dst.Close()
+
+	n := 0
+	header := make([]byte, 12)
+	for {
+		if _, err := io.ReadFull(src, header); err != nil {
+			if err == io.EOF {
+				break
+			}
+			return n, ErrLogCorrupted
+		}
+		body := make([]byte, binary.BigEndian.Uint32(header[8:]))
+		if _, err := io.ReadFull(src, body); err != nil {
+			return n, ErrLogCorrupted
+		}
+		record, err := deserializeRecord(binary.BigEndian.Uint64(header), body)
+		if err != nil {
+			return n, err
+		}
+		if _, err := dst.Write(serializeRecord(record)); err != nil {
+			return n, err
+		}
+		n++
+	}
+	return n, dst.Sync()
+}
@@ -0,0 +1,156 @@
+package transaction
+
+import (
+	"bytes"
+	"encoding/binary"
+	"os"
+	"reflect"
+	"testing"
+
+	"github.com/Johniel/gorelly/disk"
+)
+
+func TestLogFormat(t *testing.T) {
+	records := []*LogRecord{
+		{Type: LogRecordTypeBegin, TxnID: 7, OldValue: []byte{}, NewValue: []byte{}, LSN: 1},
+		{Type: LogRecordTypeUpdate, TxnID: 7, PageID: disk.InvalidPageID, Offset: 4095, OldValue: []byte("old"), NewValue: []byte("new"), LSN: 2},
+		{Type: LogRecordTypeCommit, TxnID: 7, OldValue: []byte{}, NewValue: []byte{}, LSN: 3},
+	}
+
+	t.Run("RoundTrip", func(t *testing.T) {
+		for _, record := range records {
+			for _, data := range [][]byte{serializeRecord(record), serializeRecordV1(record)} {
+				decoded, err := deserializeRecord(record.LSN, data[12:])
+				if err != nil {
+					t.Fatal(err)
+				}
+				if !reflect.DeepEqual(decoded, record) {
+					t.Errorf("expected %+v, got %+v", record, decoded)
+				}
+			}
+		}
+	})
+
+	t.Run("CreateTree", func(t *testing.T) {
+		record := &LogRecord{Type: LogRecordTypeCreateTree, TxnID: 7, PageID: 5, RootPageID: 6, OldValue: []byte{}, NewValue: []byte{}, LSN: 4}
+		decoded, err := deserializeRecord(record.LSN, serializeRecord(record)[12:])
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(decoded, record) {
+			t.Errorf("expected %+v, got %+v", record, decoded)
+		}
+	})
+
+	t.Run("UnknownField", func(t *testing.T) {
+		// A newer writer puts the field before the checksum, which covers it
+		data := serializeRecord(records[1])[12:]
+		data = appendField(data[:len(data)-6], 99, []byte("table ID"))
+		data = appendField(data, logFieldChecksum, binary.BigEndian.AppendUint32(nil, recordChecksum(2, data)))
+		decoded, err := deserializeRecord(2, data)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(decoded, records[1]) {
+			t.Errorf("expected the unknown field to be skipped, got %+v", decoded)
+		}
+	})
+
+	t.Run("Corrupted", func(t *testing.T) {
+		data := serializeRecord(records[1])[12:]
+		if _, err := deserializeRecord(2, data[:len(data)-1]); err != ErrLogCorrupted {
+			t.Errorf("expected ErrLogCorrupted for a truncated v2 record, got %v", err)
+		}
+		data = serializeRecordV1(records[1])[12:]
+		if _, err := deserializeRecord(2, data[:len(data)-1]); err != ErrLogCorrupted {
+			t.Errorf("expected ErrLogCorrupted for a truncated v1 record, got %v", err)
+		}
+		if _, err := deserializeRecord(2, nil); err != ErrLogCorrupted {
+			t.Errorf("expected ErrLogCorrupted for an empty record, got %v", err)
+		}
+	})
+
+	t.Run("Checksum", func(t *testing.T) {
+		data := serializeRecord(records[1])[12:]
+		// A flipped bit in a value still decodes, but fails the checksum
+		flipped := append([]byte{}, data...)
+		flipped[bytes.Index(flipped, []byte("new"))] ^= 1
+		if _, err := deserializeRecord(2, flipped); err != ErrLogCorrupted {
+			t.Errorf("expected ErrLogCorrupted for a flipped bit, got %v", err)
+		}
+		// The LSN in the frame is covered too
+		if _, err := deserializeRecord(3, data); err != ErrLogCorrupted {
+			t.Errorf("expected ErrLogCorrupted for a wrong LSN, got %v", err)
+		}
+		// Every v2 record must carry a checksum, and nothing may follow it
+		checksumStart := len(data) - 6
+		if _, err := deserializeRecord(2, data[:checksumStart]); err != ErrLogCorrupted {
+			t.Errorf("expected ErrLogCorrupted for a missing checksum, got %v", err)
+		}
+		trailing := appendUvarintField(append([]byte{}, data...), logFieldCommitTS, 1)
+		if _, err := deserializeRecord(2, trailing); err != ErrLogCorrupted {
+			t.Errorf("expected ErrLogCorrupted for a field after the checksum, got %v", err)
+		}
+	})
+
+	t.Run("MixedLogAndConvert", func(t *testing.T) {
+		tmpfile, err := os.CreateTemp("", "test_log_v1_*.log")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer os.Remove(tmpfile.Name())
+		for _, record := range records[:2] {
+			if _, err := tmpfile.Write(serializeRecordV1(record)); err != nil {
+				t.Fatal(err)
+			}
+		}
+		tmpfile.Close()
+
+		// A log written in the old format stays readable and appendable
+		lm, err := NewLogManager(tmpfile.Name())
+		if err != nil {
+			t.Fatal(err)
+		}
+		commit := &LogRecord{Type: LogRecordTypeCommit, TxnID: 7}
+		if err := lm.AppendLog(commit); err != nil {
+			t.Fatal(err)
+		}
+		if commit.LSN != 3 {
+			t.Errorf("expected LSN 3, got %d", commit.LSN)
+		}
+		read, err := lm.ReadLog()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(read, records) {
+			t.Errorf("expected %+v, got %+v", records, read)
+		}
+		if err := lm.Close(); err != nil {
+			t.Fatal(err)
+		}
+
+		dstPath := tmpfile.Name() + ".v2"
+		defer os.Remove(dstPath)
+		n, err := ConvertLog(tmpfile.Name(), dstPath)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if n != 3 {
+			t.Errorf("expected 3 converted records, got %d", n)
+		}
+		data, err := os.ReadFile(dstPath)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, record := range records {
+			expected := serializeRecord(record)
+			if len(data) < len(expected) || !reflect.DeepEqual(data[:len(expected)], expected) {
+				t.Fatalf("expected record %d in the current format", record.LSN)
+			}
+			data = data[len(expected):]
+		}
+		if _, err := ConvertLog(tmpfile.Name(), dstPath); err == nil {
+			t.Error("expected an existing destination to be refused")
+		}
+	})
+}
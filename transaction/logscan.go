@@ -0,0 +1,65 @@
+package transaction
+
+import (
+	"encoding/binary"
+	"io"
+)
+
+// LogRange describes the records of a log.
+type LogRange struct {
+	FirstLSN      uint64 `json:"first_lsn"`      // LSN of the first record (0 if there are none)
+	LastLSN       uint64 `json:"last_lsn"`       // LSN of the last record (0 if there are none)
+	CheckpointLSN uint64 `json:"checkpoint_lsn"` // LSN of the last checkpoint record (0 if there is none)
+	Records       int    `json:"records"`        // Number of records
+}
+
+// ScanLog reads a log, e.g. a copy made by CopyLog, to its end and returns the range of its
+// records. It returns ErrLogCorrupted if a record is cut short or fails its checksum, or if
+// the LSNs are not strictly increasing, i.e. if records are missing or out of order.
+func ScanLog(r io.Reader) (LogRange, error) {
+	var lr LogRange
+	header := make([]byte, 12)
+	for {
+		if _, err := io.ReadFull(r, header); err != nil {
+			if err == io.EOF {
+				return lr, nil
+			}
+			if err == io.ErrUnexpectedEOF {
+				return lr, ErrLogCorrupted
+			}
+			return lr, err
+		}
+		lsn := binary.BigEndian.Uint64(header)
+		body := make([]byte, binary.BigEndian.Uint32(header[8:]))
+		if _, err := io.ReadFull(r, body); err != nil {
+			if err == io.EOF || err == io.ErrUnexpectedEOF {
+				return lr, ErrLogCorrupted
+			}
+			return lr, err
+		}
+		record, err := deserializeRecord(lsn, body)
+		if err != nil {
+			return lr, err
+		}
+		if lsn <= lr.LastLSN {
+			return lr, ErrLogCorrupted
+		}
+		if lr.Records == 0 {
+			lr.FirstLSN = lsn
+		}
+		lr.LastLSN = lsn
+		if record.Type == LogRecordTypeCheckpoint {
+			lr.CheckpointLSN = lsn
+		}
+		lr.Records++
+	}
+}
+
+// CopyLog writes the records kept in the log to w, from every segment in order if the log is
+// segmented, and returns their range. Appends wait until the copy is done.
+func (lm *LogManager) CopyLog(w io.Writer) (LogRange, error) {
+	lm.mu.Lock()
+	defer lm.mu.Unlock()
+	start := lm.files.start()
+	return ScanLog(io.TeeReader(io.NewSectionReader(lm.files, start, lm.files.end()-start), w))
+}
@@ -0,0 +1,120 @@
+package transaction
+
+import (
+	"time"
+
+	"github.com/Johniel/gorelly/clock"
+	"github.com/Johniel/gorelly/errcode"
+)
+
+var (
+	// ErrInvalidSyncPolicy is returned for a periodic sync policy without a positive interval.
+	ErrInvalidSyncPolicy = errcode.New(errcode.InvalidParameter, "periodic log sync requires a positive interval")
+)
+
+// SyncMode selects how appended log records are made durable.
+type SyncMode int
+
+const (
+	// SyncModeFsync calls fsync after every append (the default).
+	SyncModeFsync SyncMode = iota
+	// SyncModeFdatasync calls fdatasync after every append, skipping metadata that is not needed
+	// to read the data back. On platforms without fdatasync it falls back to fsync.
+	SyncModeFdatasync
+	// SyncModeODSync opens the log with O_DSYNC so that every write is synchronous.
+	// On platforms without O_DSYNC it falls back to O_SYNC.
+	SyncModeODSync
+	// SyncModePeriodic syncs in the background every SyncPolicy.Interval; a crash loses
+	// at most the records appended during the last interval.
+	SyncModePeriodic
+	// SyncModeNone never syncs except on Flush, Freeze and Close. Meant for tests.
+	SyncModeNone
+)
+
+// SyncPolicy is the durability setting of a LogManager.
+type SyncPolicy struct {
+	Mode     SyncMode
+	Interval time.Duration // Sync interval of SyncModePeriodic
+	Clock    clock.Clock   // Optional: clock driving SyncModePeriodic (nil uses the system clock)
+}
+
+// OpenLogManager opens the log at logPath with the given sync policy.
+func OpenLogManager(logPath string, policy SyncPolicy) (*LogManager, error) {
+	lm, err := NewLogManager(logPath)
+	if err != nil {
+		return nil, err
+	}
+	if err := lm.SetSyncPolicy(policy); err != nil {
+		lm.Close()
+		return nil, err
+	}
+	return lm, nil
+}
+
+// SetSyncPolicy changes the durability setting. Switching to or from SyncModeODSync reopens
+// the log file; switching away from SyncModePeriodic stops the background sync after a final sync.
+func (lm *LogManager) SetSyncPolicy(policy SyncPolicy) error {
+	if policy.Mode == SyncModePeriodic && policy.Interval <= 0 {
+		return ErrInvalidSyncPolicy
+	}
+
+	lm.mu.Lock()
+	stopPeriodic := lm.stopPeriodic
+	lm.stopPeriodic = nil
+	lm.mu.Unlock()
+	// Stop outside the lock: a running background sync needs it to finish
+	if stopPeriodic != nil {
+		stopPeriodic()
+	}
+
+	lm.mu.Lock()
+	defer lm.mu.Unlock()
+	if (policy.Mode == SyncModeODSync) != (lm.syncPolicy.Mode == SyncModeODSync) {
+		if err := lm.reopen(policy.Mode == SyncModeODSync); err != nil {
+			return err
+		}
+	} else if err := lm.files.sync(); err != nil {
+		return err
+	}
+	lm.syncPolicy = policy
+	if policy.Mode == SyncModePeriodic {
+		c := policy.Clock
+		if c == nil {
+			c = clock.System
+		}
+		lm.stopPeriodic = c.Every(policy.Interval, func() { lm.Flush() })
+	}
+	return nil
+}
+
+// SyncPolicy returns the current durability setting.
+func (lm *LogManager) SyncPolicy() SyncPolicy {
+	lm.mu.Lock()
+	defer lm.mu.Unlock()
+	return lm.syncPolicy
+}
+
+// reopen syncs and closes the log file and opens it again, with or without O_DSYNC.
+// In a segmented log, only the last segment is reopened.
+func (lm *LogManager) reopen(dsync bool) error {
+	return lm.files.reopen(dsync)
+}
+
+// syncAppended makes an appended record durable according to the sync policy.
+func (lm *LogManager) syncAppended() error {
+	switch lm.syncPolicy.Mode {
+	case SyncModeFsync:
+		return lm.sync()
+	case SyncModeFdatasync:
+		if err := lm.files.datasync(); err != nil {
+			return err
+		}
+	case SyncModeODSync:
+		// O_DSYNC writes are already durable
+	default:
+		// Periodic and none defer the sync
+		return nil
+	}
+	lm.durableLSN = lm.nextLSN - 1
+	return nil
+}
@@ -0,0 +1,12 @@
+package transaction
+
+import (
+	"os"
+	"syscall"
+)
+
+const oDSync = syscall.O_DSYNC
+
+func fdatasync(file *os.File) error {
+	return syscall.Fdatasync(int(file.Fd()))
+}
@@ -0,0 +1,11 @@
+//go:build !linux
+
+package transaction
+
+import "os"
+
+const oDSync = os.O_SYNC
+
+func fdatasync(file *os.File) error {
+	return file.Sync()
+}
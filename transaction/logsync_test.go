@@ -0,0 +1,64 @@
+package transaction
+
+import (
+	"os"
+	"testing"
+	"time"
+
+	"github.com/Johniel/gorelly/clock"
+)
+
+func TestLogManagerSyncPolicy(t *testing.T) {
+	tmpfile, err := os.CreateTemp("", "test_log_sync_*.log")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(tmpfile.Name())
+	tmpfile.Close()
+
+	lm, err := OpenLogManager(tmpfile.Name(), SyncPolicy{Mode: SyncModeNone})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer lm.Close()
+
+	if _, err := OpenLogManager(tmpfile.Name(), SyncPolicy{Mode: SyncModePeriodic}); err != ErrInvalidSyncPolicy {
+		t.Errorf("expected ErrInvalidSyncPolicy, got %v", err)
+	}
+
+	c := clock.NewLogical(time.Unix(0, 0))
+	policies := []SyncPolicy{
+		{Mode: SyncModeFsync},
+		{Mode: SyncModeFdatasync},
+		{Mode: SyncModeODSync},
+		{Mode: SyncModePeriodic, Interval: time.Second, Clock: c},
+		{Mode: SyncModeODSync},
+		{Mode: SyncModeNone},
+	}
+	for i, policy := range policies {
+		if err := lm.SetSyncPolicy(policy); err != nil {
+			t.Fatalf("policy %d: %v", i, err)
+		}
+		if lm.SyncPolicy().Mode != policy.Mode {
+			t.Errorf("policy %d: expected mode %v, got %v", i, policy.Mode, lm.SyncPolicy().Mode)
+		}
+		if err := lm.AppendLog(&LogRecord{Type: LogRecordTypeBegin, TxnID: TransactionID(i)}); err != nil {
+			t.Fatalf("policy %d: %v", i, err)
+		}
+		// Drives the background sync of the periodic policy
+		c.Advance(time.Second)
+	}
+
+	records, err := lm.ReadLog()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(records) != len(policies) {
+		t.Fatalf("expected %d records, got %d", len(policies), len(records))
+	}
+	for i, record := range records {
+		if record.TxnID != TransactionID(i) || record.LSN != uint64(i+1) {
+			t.Errorf("record %d: unexpected %+v", i, record)
+		}
+	}
+}
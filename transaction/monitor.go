@@ -0,0 +1,79 @@
+package transaction
+
+import (
+	"sort"
+	"time"
+
+	"github.com/Johniel/gorelly/histogram"
+)
+
+// LockWait describes a lock request that has not been granted yet.
+type LockWait struct {
+	TxnID      TransactionID   // The waiting transaction
+	Mode       LockMode        // The requested lock mode
+	Target     any             // The RID or KeyLock waited for
+	WaitingFor []TransactionID // Transactions holding or queued before the request, in ID order
+}
+
+// TransactionInfo is a snapshot of the state of a transaction.
+type TransactionInfo struct {
+	ID        TransactionID
+	State     TransactionState
+	StartTime time.Time
+	Isolation IsolationLevel
+}
+
+// ActiveTransactions returns the transactions that have begun and not yet ended, in ID order.
+func (tm *TransactionManager) ActiveTransactions() []TransactionInfo {
+	tm.mu.RLock()
+	defer tm.mu.RUnlock()
+	txns := make([]TransactionInfo, 0, len(tm.activeTxns))
+	for _, txn := range tm.activeTxns {
+		txn.mu.RLock()
+		txns = append(txns, TransactionInfo{ID: txn.ID, State: txn.State, StartTime: txn.StartTime, Isolation: txn.Isolation})
+		txn.mu.RUnlock()
+	}
+	sort.Slice(txns, func(i, j int) bool { return txns[i].ID < txns[j].ID })
+	return txns
+}
+
+// Waits returns the lock requests that are currently waiting, ordered by transaction ID.
+func (lm *LockManager) Waits() []LockWait {
+	lm.mu.RLock()
+	defer lm.mu.RUnlock()
+	var waits []LockWait
+	for target, requests := range lm.lockTable {
+		for _, req := range requests {
+			if req.Granted {
+				continue
+			}
+			wait := LockWait{TxnID: req.TxnID, Mode: req.Mode, Target: target}
+			for txnID := range lm.waitFor[req.TxnID] {
+				wait.WaitingFor = append(wait.WaitingFor, txnID)
+			}
+			sort.Slice(wait.WaitingFor, func(i, j int) bool { return wait.WaitingFor[i] < wait.WaitingFor[j] })
+			waits = append(waits, wait)
+		}
+	}
+	sort.Slice(waits, func(i, j int) bool { return waits[i].TxnID < waits[j].TxnID })
+	return waits
+}
+
+// LastLSN returns the LSN of the last record appended to the log, or 0 if the log is empty.
+func (lm *LogManager) LastLSN() uint64 {
+	lm.mu.Lock()
+	defer lm.mu.Unlock()
+	return lm.nextLSN - 1
+}
+
+// CommitLatency returns the distribution of the time taken to append commit records to the
+// log and make them durable according to its sync policy.
+func (tm *TransactionManager) CommitLatency() histogram.Snapshot {
+	return tm.commitLatency.Snapshot()
+}
+
+// WaitLatency returns the distribution of the time from requesting a lock to being granted it,
+// including locks granted without waiting. Requests that failed are not counted.
+func (lm *LockManager) WaitLatency() histogram.Snapshot {
+	return lm.waitLatency.Snapshot()
+}
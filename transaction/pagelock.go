@@ -0,0 +1,49 @@
+package transaction
+
+import (
+	"github.com/Johniel/gorelly/disk"
+	"github.com/Johniel/gorelly/errcode"
+)
+
+// ErrPageLocked is returned by LockPage when another active transaction holds the lock on the
+// page.
+var ErrPageLocked = errcode.New(errcode.SerializationFailure, "page is being changed by another transaction")
+
+// PageLock identifies a page for page locking.
+type PageLock struct {
+	PageID disk.PageID
+}
+
+// LockPage acquires an exclusive lock on a page for the transaction, held until it ends, so
+// that the page images and deltas Rollback restores never cover changes of other transactions.
+// It does not wait: writers lock the pages they change while latching them, and waiting there
+// could deadlock on latches the lock manager does not see. It returns ErrPageLocked if another
+// transaction holds the lock; the caller then abandons the change, releases its latches and
+// waits for the lock with WaitPage.
+func (lm *LockManager) LockPage(txn *Transaction, pageID disk.PageID) error {
+	if !txn.IsActive() {
+		return ErrTransactionNotActive
+	}
+	target := PageLock{PageID: pageID}
+
+	lm.mu.Lock()
+	defer lm.mu.Unlock()
+	for _, req := range lm.lockTable[target] {
+		if req.TxnID == txn.ID && req.Granted {
+			return nil
+		}
+	}
+	if !lm.canGrantLock(target, txn.ID, LockModeExclusive) {
+		return ErrPageLocked
+	}
+	lm.grantLock(target, txn.ID, LockModeExclusive)
+	return nil
+}
+
+// WaitPage acquires the exclusive lock on a page for the transaction like LockPage, but waits
+// for the transactions holding it to end. It returns ErrDeadlock if waiting would deadlock. The
+// caller must not hold any latch, so that the transaction it waits for can make progress.
+func (lm *LockManager) WaitPage(txn *Transaction, pageID disk.PageID) error {
+	_, err := lm.lock(txn, PageLock{PageID: pageID}, LockModeExclusive)
+	return err
+}
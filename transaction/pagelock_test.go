@@ -0,0 +1,51 @@
+package transaction
+
+import (
+	"testing"
+	"time"
+
+	"github.com/Johniel/gorelly/disk"
+)
+
+func TestPageLocks(t *testing.T) {
+	lm := NewLockManager()
+	tm := NewTransactionManager()
+	page1, page2 := disk.PageID(1), disk.PageID(2)
+
+	txn1 := tm.Begin()
+	txn2 := tm.Begin()
+	if err := lm.LockPage(txn1, page1); err != nil {
+		t.Fatal(err)
+	}
+	if err := lm.LockPage(txn1, page1); err != nil {
+		t.Errorf("expected the holder to lock the page again, got %v", err)
+	}
+	if err := lm.LockPage(txn2, page1); err != ErrPageLocked {
+		t.Errorf("expected ErrPageLocked, got %v", err)
+	}
+
+	// WaitPage blocks until the holder ends
+	waited := make(chan error, 1)
+	go func() { waited <- lm.WaitPage(txn2, page1) }()
+	select {
+	case err := <-waited:
+		t.Fatalf("expected WaitPage to wait for the holder, got %v", err)
+	case <-time.After(50 * time.Millisecond):
+	}
+	if err := lm.LockPage(txn2, page2); err != nil {
+		t.Fatal(err)
+	}
+
+	// Waiting for a page of a transaction that waits for ours is a deadlock
+	if err := lm.WaitPage(txn1, page2); err != ErrDeadlock {
+		t.Errorf("expected ErrDeadlock, got %v", err)
+	}
+	lm.UnlockAll(txn1)
+	if err := <-waited; err != nil {
+		t.Fatal(err)
+	}
+	if err := lm.LockPage(txn2, page1); err != nil {
+		t.Errorf("expected the waiter to hold the page, got %v", err)
+	}
+	lm.UnlockAll(txn2)
+}
@@ -0,0 +1,111 @@
+package transaction
+
+import (
+	"github.com/Johniel/gorelly/disk"
+)
+
+// PageLog appends the page changes of a transaction to the write-ahead log as update records,
+// so that Recover redoes them once the transaction commits and undoes them otherwise, and
+// Rollback undoes them on abort. It is a btree.PageLog: set it as the Log of the trees or
+// tables the transaction modifies, and set the LogManager as the WAL of the buffer pool
+// manager so that no page is written back before its changes are logged durably.
+//
+// The pages the transaction allocates are tagged as created by it (see Transaction.TagCreatedPage),
+// so that an abort frees them without writing them back.
+//
+// Rollback restores the logged pages as a whole, so it is only correct if no other transaction
+// changed them in the meantime. A PageLog created by NewLockingPageLog guarantees it: it locks
+// every page it logs until the transaction ends (see LockManager.LockPage), and keeps the pages
+// the transaction frees allocated until it commits.
+type PageLog struct {
+	log   *LogManager
+	locks *LockManager // Locks the logged pages for txn if set
+	txn   *Transaction
+}
+
+// NewPageLog returns a log of the page changes of txn.
+func NewPageLog(log *LogManager, txn *Transaction) *PageLog {
+	return &PageLog{log: log, txn: txn}
+}
+
+// NewLockingPageLog returns a log of the page changes of txn that locks the changed pages in locks.
+func NewLockingPageLog(log *LogManager, locks *LockManager, txn *Transaction) *PageLog {
+	return &PageLog{log: log, locks: locks, txn: txn}
+}
+
+// LockPage locks a page the transaction is about to change, if the log locks pages.
+// It is a btree.PageLocker.
+func (pl *PageLog) LockPage(pageID disk.PageID) error {
+	if pl.locks == nil {
+		return nil
+	}
+	return pl.locks.LockPage(pl.txn, pageID)
+}
+
+// WaitPage waits for the lock on a page LockPage could not lock, if the log locks pages.
+// It is a btree.PageLocker.
+func (pl *PageLog) WaitPage(pageID disk.PageID) error {
+	if pl.locks == nil {
+		return nil
+	}
+	return pl.locks.WaitPage(pl.txn, pageID)
+}
+
+// DefersFree reports whether LogFree keeps the freed pages allocated until the transaction
+// commits, which the log does if it locks pages: until then, Rollback may bring them back.
+func (pl *PageLog) DefersFree() bool {
+	return pl.locks != nil
+}
+
+// LogPage appends an update record of the change, delta-encoded when only a few bytes changed
+// (see NewUpdateRecord). A page allocated by the change is logged with its whole content and
+// no before image, since the page may hold anything on disk.
+func (pl *PageLog) LogPage(pageID disk.PageID, before []byte, after []byte) (uint64, error) {
+	if err := pl.LockPage(pageID); err != nil {
+		return 0, err
+	}
+	var record *LogRecord
+	if before == nil {
+		pl.txn.TagCreatedPage(pageID)
+		record = &LogRecord{
+			Type:     LogRecordTypeUpdate,
+			TxnID:    pl.txn.ID,
+			PageID:   pageID,
+			OldValue: []byte{},
+			NewValue: append([]byte{}, after...),
+		}
+	} else {
+		record = NewUpdateRecord(pl.txn.ID, pageID, 0, before, after)
+	}
+	if err := pl.log.AppendLog(record); err != nil {
+		return 0, err
+	}
+	return record.LSN, nil
+}
+
+// LogCreate appends a create tree record of a new tree and tags its pages as created by the
+// transaction, so that they are freed if it is rolled back.
+func (pl *PageLog) LogCreate(metaPageID disk.PageID, rootPageID disk.PageID) (uint64, error) {
+	record := &LogRecord{
+		Type:       LogRecordTypeCreateTree,
+		TxnID:      pl.txn.ID,
+		PageID:     metaPageID,
+		RootPageID: rootPageID,
+	}
+	if err := pl.log.AppendLog(record); err != nil {
+		return 0, err
+	}
+	pl.txn.TagCreatedPage(metaPageID)
+	pl.txn.TagCreatedPage(rootPageID)
+	return record.LSN, nil
+}
+
+// LogFree untags a page the transaction freed: it may be reused by others from now on.
+// If the log defers frees (see DefersFree), the page is instead freed when the transaction commits.
+func (pl *PageLog) LogFree(pageID disk.PageID) {
+	if pl.DefersFree() {
+		pl.txn.deferFree(pageID)
+		return
+	}
+	pl.txn.untagCreatedPage(pageID)
+}
@@ -0,0 +1,332 @@
+package transaction
+
+import (
+	"fmt"
+	"os"
+	"testing"
+
+	"github.com/Johniel/gorelly/btree"
+	"github.com/Johniel/gorelly/buffer"
+	"github.com/Johniel/gorelly/disk"
+	"github.com/Johniel/gorelly/table"
+)
+
+func TestPageLogRecovery(t *testing.T) {
+	tmpfile, err := os.CreateTemp("", "test_page_log_*.db")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(tmpfile.Name())
+
+	logFile, err := os.CreateTemp("", "test_page_log_*.log")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(logFile.Name())
+	logFile.Close()
+
+	dm, err := disk.NewDiskManager(tmpfile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer dm.Close()
+
+	lm, err := OpenLogManager(logFile.Name(), SyncPolicy{Mode: SyncModeNone})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer lm.Close()
+
+	// A small pool, so that pages holding logged changes are written back during the test
+	bufmgr := buffer.NewBufferPoolManager(dm, buffer.NewBufferPool(8))
+	bufmgr.SetWAL(lm)
+	users := &table.Table{NumKeyElems: 1, UniqueIndices: []*table.UniqueIndex{{Skey: []int{1}}}}
+	if err := users.Create(bufmgr); err != nil {
+		t.Fatal(err)
+	}
+	if err := bufmgr.Flush(); err != nil {
+		t.Fatal(err)
+	}
+
+	tm := NewTransactionManagerWithManagers(lm, nil, nil)
+	row := func(i int, suffix string) [][]byte {
+		return [][]byte{[]byte(fmt.Sprintf("user%04d", i)), []byte(fmt.Sprintf("user%04d@example.com%s", i, suffix))}
+	}
+
+	committed := tm.Begin()
+	tbl := *users
+	tbl.Log = NewPageLog(lm, committed)
+	for i := 0; i < 300; i++ {
+		if err := tbl.Insert(bufmgr, row(i, "")); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := tm.Commit(committed); err != nil {
+		t.Fatal(err)
+	}
+	if bufmgr.IOStats().PagesWritten == 0 {
+		t.Fatal("expected pages to be written back during the test")
+	}
+
+	uncommitted := tm.Begin()
+	tbl.Log = NewPageLog(lm, uncommitted)
+	for i := 300; i < 350; i++ {
+		if err := tbl.Insert(bufmgr, row(i, "")); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := tbl.Update(bufmgr, row(0, ".invalid")); err != nil {
+		t.Fatal(err)
+	}
+	if err := tbl.Delete(bufmgr, row(1, "")); err != nil {
+		t.Fatal(err)
+	}
+
+	// Crash: the cached pages are lost, only the pages written back so far are on disk
+	heapFile, err := os.OpenFile(tmpfile.Name(), os.O_RDWR, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	dm2, err := disk.NewDiskManager(heapFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer dm2.Close()
+	bufmgr2 := buffer.NewBufferPoolManager(dm2, buffer.NewBufferPool(8))
+	bufmgr2.SetWAL(lm)
+	if err := NewRecoveryManager(lm, bufmgr2).Recover(); err != nil {
+		t.Fatal(err)
+	}
+
+	// The committed rows are back and the uncommitted changes are gone, in the table and its index
+	for i := 0; i < 350; i++ {
+		tup, err := users.Get(bufmgr2, row(i, "")[:1])
+		if 300 <= i {
+			if err != btree.ErrKeyNotFound {
+				t.Errorf("expected uncommitted row %d to be absent, got %q (%v)", i, tup, err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatalf("row %d: %v", i, err)
+		}
+		if string(tup[1]) != string(row(i, "")[1]) {
+			t.Errorf("expected %q, got %q", row(i, "")[1], tup[1])
+		}
+	}
+	iter, err := btree.NewBTree(users.UniqueIndices[0].MetaPageID).Search(bufmgr2, btree.NewSearchModeStart())
+	if err != nil {
+		t.Fatal(err)
+	}
+	entries := 0
+	for {
+		_, _, ok, err := iter.Next(bufmgr2)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !ok {
+			break
+		}
+		entries++
+	}
+	if entries != 300 {
+		t.Errorf("expected 300 index entries, got %d", entries)
+	}
+}
+
+func TestAbortFreesCreatedPages(t *testing.T) {
+	tmpfile, err := os.CreateTemp("", "test_abort_created_*.db")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(tmpfile.Name())
+
+	logFile, err := os.CreateTemp("", "test_abort_created_*.log")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(logFile.Name())
+	logFile.Close()
+
+	dm, err := disk.NewDiskManager(tmpfile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer dm.Close()
+
+	lm, err := OpenLogManager(logFile.Name(), SyncPolicy{Mode: SyncModeNone})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer lm.Close()
+
+	bufmgr := buffer.NewBufferPoolManager(dm, buffer.NewBufferPool(64))
+	bufmgr.SetWAL(lm)
+	users := &table.Table{NumKeyElems: 1}
+	if err := users.Create(bufmgr); err != nil {
+		t.Fatal(err)
+	}
+	if err := users.Insert(bufmgr, [][]byte{[]byte("alice"), []byte("alice@example.com")}); err != nil {
+		t.Fatal(err)
+	}
+
+	tm := NewTransactionManagerWithManagers(lm, nil, NewRecoveryManager(lm, bufmgr))
+	txn := tm.Begin()
+	bulk := *users
+	bulk.Log = NewPageLog(lm, txn)
+	for i := 0; i < 300; i++ {
+		if err := bulk.Insert(bufmgr, [][]byte{[]byte(fmt.Sprintf("user%04d", i)), []byte("user@example.com")}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	created := txn.createdPages()
+	if len(created) == 0 {
+		t.Fatal("expected the bulk insert to create pages")
+	}
+
+	if err := tm.Abort(txn); err != nil {
+		t.Fatal(err)
+	}
+	if n := dm.NumFreePages(); n != len(created) {
+		t.Errorf("expected the %d created pages to be freed, got %d free pages", len(created), n)
+	}
+	for pageID := range bufmgr.DirtyPages() {
+		if created[pageID] {
+			t.Errorf("expected created page %d to be dropped from the buffer pool", pageID)
+		} else {
+			t.Errorf("expected undone page %d to be written back", pageID)
+		}
+	}
+	iter, err := btree.NewBTree(users.MetaPageID).Search(bufmgr, btree.NewSearchModeStart())
+	if err != nil {
+		t.Fatal(err)
+	}
+	rows := 0
+	for {
+		_, _, ok, err := iter.Next(bufmgr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !ok {
+			break
+		}
+		rows++
+	}
+	if rows != 1 {
+		t.Errorf("expected only the row inserted before the transaction, got %d rows", rows)
+	}
+}
+
+func TestCreateTreeRecovery(t *testing.T) {
+	tmpfile, err := os.CreateTemp("", "test_create_tree_*.db")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(tmpfile.Name())
+
+	logFile, err := os.CreateTemp("", "test_create_tree_*.log")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(logFile.Name())
+	logFile.Close()
+
+	dm, err := disk.NewDiskManager(tmpfile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer dm.Close()
+
+	lm, err := OpenLogManager(logFile.Name(), SyncPolicy{Mode: SyncModeNone})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer lm.Close()
+
+	bufmgr := buffer.NewBufferPoolManager(dm, buffer.NewBufferPool(64))
+	bufmgr.SetWAL(lm)
+	tm := NewTransactionManagerWithManagers(lm, nil, nil)
+
+	committed := tm.Begin()
+	users := &table.Table{NumKeyElems: 1, UniqueIndices: []*table.UniqueIndex{{Skey: []int{1}}}}
+	users.Log = NewPageLog(lm, committed)
+	if err := users.Create(bufmgr); err != nil {
+		t.Fatal(err)
+	}
+	if err := users.Insert(bufmgr, [][]byte{[]byte("alice"), []byte("alice@example.com")}); err != nil {
+		t.Fatal(err)
+	}
+	committedPages := committed.createdPages()
+	if err := tm.Commit(committed); err != nil {
+		t.Fatal(err)
+	}
+
+	uncommitted := tm.Begin()
+	orders := &table.Table{NumKeyElems: 1}
+	orders.Log = NewPageLog(lm, uncommitted)
+	if err := orders.Create(bufmgr); err != nil {
+		t.Fatal(err)
+	}
+	uncommittedPages := uncommitted.createdPages()
+
+	// Crash before any page is written back: neither the pages nor their allocation are on disk
+	heapFile, err := os.OpenFile(tmpfile.Name(), os.O_RDWR, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	dm2, err := disk.NewDiskManager(heapFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer dm2.Close()
+	if n := dm2.NumPages(); n != 0 {
+		t.Fatalf("expected no page on disk, got %d", n)
+	}
+	bufmgr2 := buffer.NewBufferPoolManager(dm2, buffer.NewBufferPool(64))
+	bufmgr2.SetWAL(lm)
+	if err := NewRecoveryManager(lm, bufmgr2).Recover(); err != nil {
+		t.Fatal(err)
+	}
+
+	// The committed tree is back, with its index
+	tup, err := users.Get(bufmgr2, [][]byte{[]byte("alice")})
+	if err != nil || string(tup[1]) != "alice@example.com" {
+		t.Errorf("expected alice, got %q (%v)", tup, err)
+	}
+	iter, err := btree.NewBTree(users.UniqueIndices[0].MetaPageID).Search(bufmgr2, btree.NewSearchModeStart())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, pkey, ok, err := iter.Next(bufmgr2); err != nil || !ok {
+		t.Errorf("expected the index entry, got %q (%v)", pkey, err)
+	}
+
+	// The pages of the uncommitted tree are freed, and the pages of the committed one are not reused
+	free := make(map[disk.PageID]bool)
+	for range dm2.NumFreePages() {
+		buf, err := bufmgr2.CreateBuffer()
+		if err != nil {
+			t.Fatal(err)
+		}
+		free[buf.PageID] = true
+		buf.Unpin()
+	}
+	for pageID := range uncommittedPages {
+		if !free[pageID] {
+			t.Errorf("expected uncommitted page %d to be freed", pageID)
+		}
+	}
+	for pageID := range committedPages {
+		if free[pageID] {
+			t.Errorf("expected committed page %d to stay allocated", pageID)
+		}
+	}
+	buf, err := bufmgr2.CreateBuffer()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer buf.Unpin()
+	if committedPages[buf.PageID] {
+		t.Errorf("expected a new page, got committed page %d", buf.PageID)
+	}
+}
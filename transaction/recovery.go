@@ -2,10 +2,15 @@
 package transaction
 
 import (
+	"github.com/Johniel/gorelly/btree"
 	"github.com/Johniel/gorelly/buffer"
 	"github.com/Johniel/gorelly/disk"
 )
 
+// recoveryRingFrames is the number of buffer frames Recover reads pages into (see buffer.Ring),
+// so that replaying a long log does not evict the pages cached for queries.
+const recoveryRingFrames = 8
+
 type RecoveryManager struct {
 	logManager *LogManager
 	bufmgr     *buffer.BufferPoolManager
@@ -19,11 +24,19 @@ func NewRecoveryManager(logManager *LogManager, bufmgr *buffer.BufferPoolManager
 	}
 }
 
+// Rollback undoes the logged changes of txn. The pages the transaction created
+// (see Transaction.TagCreatedPage) are not undone but freed without being written back.
+// The undone pages are written back before it returns: the undo is not logged, and Recover
+// does not undo a transaction whose Abort record is in the log.
+//
+// The pages are restored as a whole, which undoes the changes other transactions made to them
+// too unless they were kept off the pages: log the changes with NewLockingPageLog.
 func (rm *RecoveryManager) Rollback(txn *Transaction) error {
 	records, err := rm.logManager.ReadLog()
 	if err != nil {
 		return err
 	}
+	created := txn.createdPages()
 
 	var txnRecords []*LogRecord
 	for i := len(records) - 1; 0 <= i; i-- {
@@ -37,48 +50,100 @@ func (rm *RecoveryManager) Rollback(txn *Transaction) error {
 		}
 	}
 
+	var undone []disk.PageID
 	for _, record := range txnRecords {
-		if err := rm.undoUpdate(record); err != nil {
+		if created[record.PageID] {
+			continue
+		}
+		if err := rm.undoUpdate(rm.bufmgr.FetchBuffer, record); err != nil {
 			return err
 		}
+		undone = append(undone, record.PageID)
 	}
-	return nil
+	// Nothing references the created pages once the changes are undone
+	for pageID := range created {
+		rm.bufmgr.FreeBuffer(pageID)
+	}
+	return rm.bufmgr.FlushPages(undone)
 }
 
-func (rm *RecoveryManager) undoUpdate(record *LogRecord) error {
-	buf, err := rm.bufmgr.FetchBuffer(record.PageID)
+func (rm *RecoveryManager) undoUpdate(fetch func(disk.PageID) (*buffer.Buffer, error), record *LogRecord) error {
+	buf, err := fetch(record.PageID)
 	if err != nil {
 		return err
 	}
 
-	copy(buf.Page[record.Offset:record.Offset+len(record.OldValue)], record.OldValue)
-	buf.IsDirty = true
+	defer buf.Unpin()
+
+	record.Undo(buf.Page[:])
+	buf.MarkDirty()
 
 	return nil
 }
 
-// redoUpdate redoes a single update operation.
-func (rm *RecoveryManager) redoUpdate(record *LogRecord) error {
-	buf, err := rm.bufmgr.FetchBuffer(record.PageID)
+// redoUpdate redoes a single update operation. The allocation of a page the update created is
+// redone as well, since the page may have been lost along with the allocation.
+func (rm *RecoveryManager) redoUpdate(fetch func(disk.PageID) (*buffer.Buffer, error), record *LogRecord) error {
+	if record.createsPage() {
+		if err := rm.bufmgr.ClaimPage(record.PageID); err != nil {
+			return err
+		}
+	}
+	buf, err := fetch(record.PageID)
 	if err != nil {
 		return err
 	}
 
-	// Apply new value
-	copy(buf.Page[record.Offset:record.Offset+len(record.NewValue)], record.NewValue)
-	buf.IsDirty = true
+	defer buf.Unpin()
+
+	record.Redo(buf.Page[:])
+	buf.MarkDirty()
 
 	return nil
 }
 
+// redoCreateTree redoes the allocation and initialization of the pages of a created tree.
+func (rm *RecoveryManager) redoCreateTree(fetch func(disk.PageID) (*buffer.Buffer, error), record *LogRecord) error {
+	var bufs [2]*buffer.Buffer
+	for i, pageID := range record.createdPages() {
+		if err := rm.bufmgr.ClaimPage(pageID); err != nil {
+			return err
+		}
+		buf, err := fetch(pageID)
+		if err != nil {
+			return err
+		}
+		defer buf.Unpin()
+		bufs[i] = buf
+	}
+	btree.InitializeTree(bufs[0].Page[:], bufs[1].Page[:], record.RootPageID)
+	bufs[0].MarkDirty()
+	bufs[1].MarkDirty()
+	return nil
+}
+
+// Recover redoes the logged changes of committed transactions and undoes those of transactions
+// that never ended, appending an Abort record for each of them. It reads the log only from the
+// last checkpoint on (see LogManager.Checkpoint), and redoes only the changes made since the
+// checkpoint started.
 func (rm *RecoveryManager) Recover() error {
-	records, err := rm.logManager.ReadLog()
+	records, err := rm.logManager.readRecoveryLog()
 	if err != nil {
 		return err
 	}
 
+	fetch := rm.bufmgr.FetchBuffer
+	ring, err := rm.bufmgr.NewRing(recoveryRingFrames)
+	if err == nil {
+		defer ring.Release()
+		fetch = ring.FetchBuffer
+	} else if err != buffer.ErrNoFreeBuffer {
+		return err
+	}
+
 	activeTxns := make(map[TransactionID]bool)
 	committedTxns := make(map[TransactionID]bool)
+	var redoLSN uint64
 
 	for _, record := range records {
 		switch record.Type {
@@ -89,6 +154,10 @@ func (rm *RecoveryManager) Recover() error {
 			delete(activeTxns, record.TxnID)
 		case LogRecordTypeAbort:
 			delete(activeTxns, record.TxnID)
+		case LogRecordTypeCheckpoint:
+			if record.Checkpoint != nil {
+				redoLSN = record.Checkpoint.RedoLSN
+			}
 		}
 	}
 
@@ -102,40 +171,99 @@ func (rm *RecoveryManager) Recover() error {
 	}
 
 	// Phase 2: Redo Phase
-	// Redo all committed transactions
+	// Redo all committed transactions, except for changes written back by the checkpoint
 	for _, record := range records {
-		if record.Type == LogRecordTypeUpdate {
-			if committedTxns[record.TxnID] {
-				if err := rm.redoUpdate(record); err != nil {
-					return err
-				}
+		if redoLSN <= record.LSN && committedTxns[record.TxnID] {
+			var err error
+			switch record.Type {
+			case LogRecordTypeUpdate:
+				err = rm.redoUpdate(fetch, record)
+			case LogRecordTypeCreateTree:
+				err = rm.redoCreateTree(fetch, record)
+			}
+			if err != nil {
+				return err
 			}
 		}
 	}
 
 	// Phase 3: Undo Phase
-	// Undo all uncommitted transactions
+	// Undo all uncommitted transactions. A page a transaction created and freed may have been
+	// created again by another one, which then owns it.
+	lastCreator := make(map[disk.PageID]TransactionID)
+	for _, record := range records {
+		switch {
+		case record.createsPage():
+			lastCreator[record.PageID] = record.TxnID
+		case record.Type == LogRecordTypeCreateTree:
+			for _, pageID := range record.createdPages() {
+				lastCreator[pageID] = record.TxnID
+			}
+		}
+	}
 	for txnID := range activeTxns {
 		// Find all records for this transaction in reverse order
 		var txnRecords []*LogRecord
+		created := make(map[disk.PageID]bool)
 		for i := len(records) - 1; i >= 0; i-- {
 			if records[i].TxnID == txnID {
 				if records[i].Type == LogRecordTypeBegin {
 					break
 				}
-				if records[i].Type == LogRecordTypeUpdate {
+				switch records[i].Type {
+				case LogRecordTypeUpdate:
 					txnRecords = append(txnRecords, records[i])
+					if records[i].createsPage() {
+						created[records[i].PageID] = true
+					}
+				case LogRecordTypeCreateTree:
+					for _, pageID := range records[i].createdPages() {
+						created[pageID] = true
+					}
 				}
 			}
 		}
 
-		// Undo changes
+		// Undo changes, except on the pages the transaction created, which are freed as Rollback does
 		for _, record := range txnRecords {
-			if err := rm.undoUpdate(record); err != nil {
+			if created[record.PageID] {
+				continue
+			}
+			if err := rm.undoUpdate(fetch, record); err != nil {
+				return err
+			}
+		}
+		for pageID := range created {
+			if lastCreator[pageID] != txnID {
+				continue
+			}
+			// The allocation may not have reached the disk; claim the page so that it is freed once
+			if err := rm.bufmgr.ClaimPage(pageID); err != nil {
 				return err
 			}
+			rm.bufmgr.FreeBuffer(pageID)
 		}
 	}
 
-	return rm.bufmgr.Flush()
+	if err := rm.bufmgr.Flush(); err != nil {
+		return err
+	}
+	// The undone transactions end here, so that later recoveries and checkpoints skip them
+	for txnID := range activeTxns {
+		if err := rm.logManager.AppendLog(&LogRecord{Type: LogRecordTypeAbort, TxnID: txnID}); err != nil {
+			return err
+		}
+	}
+	return rm.logManager.Flush()
+}
+
+// createsPage reports whether the record is the full image of a page allocated by the change,
+// as a PageLog logs it.
+func (r *LogRecord) createsPage() bool {
+	return r.Type == LogRecordTypeUpdate && r.Delta == nil && len(r.OldValue) == 0 && len(r.NewValue) == disk.PageSize
+}
+
+// createdPages returns the meta and root pages of a tree creation record.
+func (r *LogRecord) createdPages() [2]disk.PageID {
+	return [2]disk.PageID{r.PageID, r.RootPageID}
 }
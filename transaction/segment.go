@@ -0,0 +1,315 @@
+package transaction
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/Johniel/gorelly/errcode"
+)
+
+// DefaultSegmentSize is a segment size suitable for most segmented logs (16MB).
+const DefaultSegmentSize = 16 << 20
+
+var (
+	// ErrInvalidSegmentSize is returned for a segmented log without a positive segment size.
+	ErrInvalidSegmentSize = errcode.New(errcode.InvalidParameter, "log segment size must be positive")
+)
+
+// NewSegmentedLogManager opens a log split into segment files of at most segmentSize bytes,
+// e.g. DefaultSegmentSize. A record that does not fit into the last segment starts a new one,
+// so a long-running log does not grow a single file without bound, and Truncate removes whole
+// segments instead of rewriting the log. The segments are named after logPath and the offset
+// of their first record, e.g. "wal.log.00000000000016777216.seg"; logPath itself is not created.
+func NewSegmentedLogManager(logPath string, segmentSize int64) (*LogManager, error) {
+	if segmentSize <= 0 {
+		return nil, ErrInvalidSegmentSize
+	}
+	return newLogManager(logPath, segmentSize)
+}
+
+// logSegment is a file of the log holding size bytes of records from offset start on.
+type logSegment struct {
+	start int64
+	size  int64
+	path  string
+	file  *os.File
+}
+
+// logFiles holds the files of a log and reads them as one sequence of records, addressed by
+// offsets that count from the beginning of the log. A log that is not segmented is a single
+// segment at the log's path. Records are only appended to the last segment.
+type logFiles struct {
+	path        string
+	segmentSize int64 // Maximum size of a segment (0 if the log is not segmented)
+	dsync       bool  // Whether the last segment is opened with O_DSYNC
+	segments    []*logSegment
+}
+
+func openLogFiles(path string, segmentSize int64) (*logFiles, error) {
+	lf := &logFiles{path: path, segmentSize: segmentSize}
+	if segmentSize == 0 {
+		if err := lf.openSegment(0, path, true); err != nil {
+			return nil, err
+		}
+		return lf, nil
+	}
+
+	starts, err := lf.listSegments()
+	if err != nil {
+		return nil, err
+	}
+	if len(starts) == 0 {
+		starts = []int64{0}
+	}
+	for i, start := range starts {
+		if err := lf.openSegment(start, lf.segmentPath(start), i == len(starts)-1); err != nil {
+			lf.close()
+			return nil, err
+		}
+		if 0 < i {
+			prev := lf.segments[i-1]
+			if prev.start+prev.size != start {
+				// A segment is missing or was cut short
+				lf.close()
+				return nil, ErrLogCorrupted
+			}
+		}
+	}
+	return lf, nil
+}
+
+// segmentPath returns the path of the segment starting at offset start.
+func (lf *logFiles) segmentPath(start int64) string {
+	return fmt.Sprintf("%s.%020d.seg", lf.path, start)
+}
+
+// listSegments returns the start offsets of the segment files of the log in ascending order.
+func (lf *logFiles) listSegments() ([]int64, error) {
+	entries, err := os.ReadDir(filepath.Dir(lf.path))
+	if err != nil {
+		return nil, err
+	}
+	prefix := filepath.Base(lf.path) + "."
+	var starts []int64
+	for _, entry := range entries {
+		name := entry.Name()
+		if !strings.HasPrefix(name, prefix) || !strings.HasSuffix(name, ".seg") {
+			continue
+		}
+		start, err := strconv.ParseInt(strings.TrimSuffix(strings.TrimPrefix(name, prefix), ".seg"), 10, 64)
+		if err != nil {
+			continue
+		}
+		starts = append(starts, start)
+	}
+	sort.Slice(starts, func(i, j int) bool { return starts[i] < starts[j] })
+	return starts, nil
+}
+
+// openSegment opens the segment at path and appends it to the segments.
+// Only the last segment is opened for writing.
+func (lf *logFiles) openSegment(start int64, path string, last bool) error {
+	file, err := lf.openFile(path, last)
+	if err != nil {
+		return err
+	}
+	stat, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return err
+	}
+	lf.segments = append(lf.segments, &logSegment{start: start, size: stat.Size(), path: path, file: file})
+	return nil
+}
+
+func (lf *logFiles) openFile(path string, writable bool) (*os.File, error) {
+	if !writable {
+		return os.Open(path)
+	}
+	flags := os.O_CREATE | os.O_RDWR | os.O_APPEND
+	if lf.dsync {
+		flags |= oDSync
+	}
+	return os.OpenFile(path, flags, 0644)
+}
+
+func (lf *logFiles) last() *logSegment {
+	return lf.segments[len(lf.segments)-1]
+}
+
+// start returns the offset of the first record kept in the log.
+func (lf *logFiles) start() int64 {
+	return lf.segments[0].start
+}
+
+// end returns the offset of the next record, i.e. the size of the log.
+func (lf *logFiles) end() int64 {
+	last := lf.last()
+	return last.start + last.size
+}
+
+// write appends a record, starting a new segment first if it does not fit into the last one.
+func (lf *logFiles) write(data []byte) error {
+	last := lf.last()
+	if 0 < lf.segmentSize && 0 < last.size && lf.segmentSize < last.size+int64(len(data)) {
+		if err := lf.rotate(); err != nil {
+			return err
+		}
+		last = lf.last()
+	}
+	n, err := last.file.Write(data)
+	last.size += int64(n)
+	return err
+}
+
+// rotate syncs the last segment and starts a new one after it. The directory is synced too,
+// so that the new segment is not lost in a crash after records in it were synced.
+func (lf *logFiles) rotate() error {
+	last := lf.last()
+	if err := last.file.Sync(); err != nil {
+		return err
+	}
+	start := last.start + last.size
+	if err := lf.openSegment(start, lf.segmentPath(start), true); err != nil {
+		return err
+	}
+	if err := syncDir(filepath.Dir(lf.path)); err != nil {
+		return err
+	}
+	// The previous segment is only read from now on
+	file, err := lf.openFile(last.path, false)
+	if err != nil {
+		return err
+	}
+	last.file.Close()
+	last.file = file
+	return nil
+}
+
+func syncDir(dir string) error {
+	file, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	return file.Sync()
+}
+
+// ReadAt reads the log at offset off, across segments if needed. It implements io.ReaderAt.
+func (lf *logFiles) ReadAt(p []byte, off int64) (int, error) {
+	n := 0
+	for n < len(p) {
+		pos := off + int64(n)
+		i := sort.Search(len(lf.segments), func(i int) bool {
+			return pos < lf.segments[i].start+lf.segments[i].size
+		})
+		if i == len(lf.segments) || pos < lf.segments[i].start {
+			return n, io.EOF
+		}
+		seg := lf.segments[i]
+		chunk := p[n:min(len(p), n+int(seg.start+seg.size-pos))]
+		m, err := seg.file.ReadAt(chunk, pos-seg.start)
+		n += m
+		if m < len(chunk) {
+			if err == nil {
+				err = io.EOF
+			}
+			return n, err
+		}
+	}
+	return n, nil
+}
+
+// sync syncs the last segment; the previous ones were synced when the next one was started.
+func (lf *logFiles) sync() error {
+	return lf.last().file.Sync()
+}
+
+// datasync is like sync but uses fdatasync.
+func (lf *logFiles) datasync() error {
+	return fdatasync(lf.last().file)
+}
+
+// reopen syncs and closes the last segment and opens it again, with or without O_DSYNC.
+// Its size is read again, since the file may have been replaced (see LogManager.rewriteFrom).
+func (lf *logFiles) reopen(dsync bool) error {
+	last := lf.last()
+	if err := last.file.Sync(); err != nil {
+		return err
+	}
+	if err := last.file.Close(); err != nil {
+		return err
+	}
+	lf.dsync = dsync
+	lf.segments = lf.segments[:len(lf.segments)-1]
+	return lf.openSegment(last.start, last.path, true)
+}
+
+// segmentStart returns the start of the segment holding offset, or the end of the log if
+// offset is at or past it. Removing the segments before it removes only records before offset.
+func (lf *logFiles) segmentStart(offset int64) int64 {
+	start := lf.start()
+	for _, seg := range lf.segments {
+		if offset < seg.start+seg.size {
+			break
+		}
+		start = seg.start + seg.size
+	}
+	return min(start, lf.last().start)
+}
+
+// removeBefore deletes the segments that end at or before offset, except the last one.
+func (lf *logFiles) removeBefore(offset int64) error {
+	for 1 < len(lf.segments) && lf.segments[0].start+lf.segments[0].size <= offset {
+		seg := lf.segments[0]
+		if err := os.Remove(seg.path); err != nil {
+			return err
+		}
+		seg.file.Close()
+		lf.segments = lf.segments[1:]
+	}
+	return nil
+}
+
+// truncate drops the end of the log from offset on, e.g. a record torn by a crash while it
+// was written, removing the segments that start at or after offset except the first one.
+func (lf *logFiles) truncate(offset int64) error {
+	for 1 < len(lf.segments) && offset <= lf.last().start {
+		last := lf.last()
+		last.file.Close()
+		if err := os.Remove(last.path); err != nil {
+			return err
+		}
+		lf.segments = lf.segments[:len(lf.segments)-1]
+	}
+	last := lf.last()
+	if err := os.Truncate(last.path, max(offset-last.start, 0)); err != nil {
+		return err
+	}
+	if 0 < lf.segmentSize {
+		if err := syncDir(filepath.Dir(lf.path)); err != nil {
+			return err
+		}
+	}
+	// The new last segment may have been opened for reading only
+	return lf.reopen(lf.dsync)
+}
+
+// close syncs the last segment and closes every segment.
+func (lf *logFiles) close() error {
+	var err error
+	if len(lf.segments) != 0 {
+		err = lf.sync()
+	}
+	for _, seg := range lf.segments {
+		if closeErr := seg.file.Close(); err == nil {
+			err = closeErr
+		}
+	}
+	return err
+}
@@ -0,0 +1,140 @@
+package transaction
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/Johniel/gorelly/buffer"
+	"github.com/Johniel/gorelly/disk"
+)
+
+func TestSegmentedLog(t *testing.T) {
+	tmpfile, err := os.CreateTemp("", "test_segmented_log_*.db")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(tmpfile.Name())
+	dm, err := disk.NewDiskManager(tmpfile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer dm.Close()
+	bufmgr := buffer.NewBufferPoolManager(dm, buffer.NewBufferPool(4))
+
+	dir := t.TempDir()
+	logPath := filepath.Join(dir, "wal.log")
+	if _, err := NewSegmentedLogManager(logPath, 0); err != ErrInvalidSegmentSize {
+		t.Fatalf("expected ErrInvalidSegmentSize, got %v", err)
+	}
+	const segmentSize = 256
+	lm, err := NewSegmentedLogManager(logPath, segmentSize)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := lm.SetSyncPolicy(SyncPolicy{Mode: SyncModeNone}); err != nil {
+		t.Fatal(err)
+	}
+	appendRecords := func(n int, valueSize int) {
+		t.Helper()
+		for i := 0; i < n; i++ {
+			record := &LogRecord{Type: LogRecordTypeUpdate, TxnID: 1, NewValue: make([]byte, valueSize)}
+			if err := lm.AppendLog(record); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	checkLSNs := func(first uint64, last uint64) {
+		t.Helper()
+		records, err := lm.ReadLog()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(records) != int(last-first+1) {
+			t.Fatalf("expected LSNs %d to %d, got %d records", first, last, len(records))
+		}
+		for i, record := range records {
+			if record.LSN != first+uint64(i) {
+				t.Fatalf("record %d: expected LSN %d, got %d", i, first+uint64(i), record.LSN)
+			}
+		}
+	}
+
+	appendRecords(20, 40)
+	// A record larger than a segment gets a segment of its own
+	appendRecords(1, 2*segmentSize)
+	appendRecords(10, 40)
+	segments, err := filepath.Glob(logPath + ".*.seg")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(segments) < 4 {
+		t.Fatalf("expected the log to be split into segments, got %v", segments)
+	}
+	if _, err := os.Stat(logPath); !os.IsNotExist(err) {
+		t.Errorf("expected no single log file, got %v", err)
+	}
+	oversized := 0
+	for _, segment := range segments {
+		stat, err := os.Stat(segment)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if segmentSize < stat.Size() {
+			oversized++
+		}
+	}
+	if oversized != 1 {
+		t.Errorf("expected only the segment of the large record to exceed the segment size, got %d", oversized)
+	}
+	// The segments are read in order as one log
+	checkLSNs(1, 31)
+
+	// Truncating removes only whole segments
+	if err := lm.Checkpoint(bufmgr); err != nil {
+		t.Fatal(err)
+	}
+	appendRecords(10, 40)
+	if err := lm.Truncate(25); err != nil {
+		t.Fatal(err)
+	}
+	records, err := lm.ReadLog()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if first := records[0].LSN; first == 1 || 25 < first {
+		t.Fatalf("expected the log to start at a segment boundary up to LSN 25, got %d", first)
+	}
+	first := records[0].LSN
+	checkLSNs(first, 42)
+	recoverFrom := lm.recoverFrom
+
+	// Reopening stitches the remaining segments and continues the log
+	if err := lm.Close(); err != nil {
+		t.Fatal(err)
+	}
+	lm, err = NewSegmentedLogManager(logPath, segmentSize)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if lm.recoverFrom != recoverFrom {
+		t.Errorf("expected recovery to start at offset %d, got %d", recoverFrom, lm.recoverFrom)
+	}
+	appendRecords(1, 40)
+	checkLSNs(first, 43)
+	if err := lm.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// A missing segment is detected
+	segments, err = filepath.Glob(logPath + ".*.seg")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Remove(segments[1]); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := NewSegmentedLogManager(logPath, segmentSize); err != ErrLogCorrupted {
+		t.Errorf("expected ErrLogCorrupted, got %v", err)
+	}
+}
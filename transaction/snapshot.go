@@ -0,0 +1,129 @@
+package transaction
+
+import "encoding/binary"
+
+// IsolationLevel determines which committed changes a transaction's statements see.
+type IsolationLevel int
+
+const (
+	// IsolationReadCommitted gives every statement a fresh snapshot, so it sees all
+	// transactions committed before the statement started.
+	IsolationReadCommitted IsolationLevel = iota
+	// IsolationRepeatableRead takes one snapshot at the first statement and keeps it
+	// for the whole transaction.
+	IsolationRepeatableRead
+)
+
+// Snapshot captures which transactions had committed at a point in time.
+// Rows are versioned by the IDs of the transactions that wrote (xmin) and deleted (xmax) them;
+// a row is visible if its writer is visible and its deleter, if any, is not.
+type Snapshot struct {
+	Owner  TransactionID          // Transaction the snapshot belongs to; its own changes are visible
+	Xmax   TransactionID          // First transaction ID not yet assigned when the snapshot was taken
+	Active map[TransactionID]bool // Transactions in progress when the snapshot was taken
+}
+
+// Visible reports whether the changes of transaction id are visible in the snapshot.
+func (s *Snapshot) Visible(id TransactionID) bool {
+	if id == s.Owner {
+		return true
+	}
+	return 0 < id && id < s.Xmax && !s.Active[id]
+}
+
+// RowVisible reports whether a row with the given encoded writer and deleter transaction IDs
+// is visible in the snapshot. An empty xmax means the row is not deleted.
+func (s *Snapshot) RowVisible(xmin []byte, xmax []byte) bool {
+	if !s.Visible(TransactionIDFromBytes(xmin)) {
+		return false
+	}
+	return len(xmax) == 0 || !s.Visible(TransactionIDFromBytes(xmax))
+}
+
+// Bytes encodes a transaction ID for storage in a row's xmin or xmax column.
+func (id TransactionID) Bytes() []byte {
+	b := make([]byte, 8)
+	binary.BigEndian.PutUint64(b, uint64(id))
+	return b
+}
+
+// TransactionIDFromBytes decodes a transaction ID encoded with TransactionID.Bytes.
+// It returns 0 (never a valid ID) for malformed input.
+func TransactionIDFromBytes(b []byte) TransactionID {
+	if len(b) != 8 {
+		return 0
+	}
+	return TransactionID(binary.BigEndian.Uint64(b))
+}
+
+// BeginWithIsolation starts a new transaction with the given isolation level.
+func (tm *TransactionManager) BeginWithIsolation(level IsolationLevel) *Transaction {
+	txn := tm.Begin()
+	txn.mu.Lock()
+	txn.Isolation = level
+	txn.mu.Unlock()
+	return txn
+}
+
+// StatementSnapshot returns the snapshot a statement of txn starting now must read with:
+// a fresh one under ReadCommitted, and the one taken at the transaction's first statement
+// under RepeatableRead.
+func (tm *TransactionManager) StatementSnapshot(txn *Transaction) *Snapshot {
+	txn.mu.Lock()
+	defer txn.mu.Unlock()
+	if txn.Isolation == IsolationRepeatableRead && txn.snapshot != nil {
+		return txn.snapshot
+	}
+	// Under ReadCommitted the snapshot is kept until the next statement, for Horizon
+	txn.snapshot = tm.takeSnapshot(txn.ID)
+	return txn.snapshot
+}
+
+// oldest returns the oldest transaction whose changes may be invisible in the snapshot.
+func (s *Snapshot) oldest() TransactionID {
+	oldest := s.Xmax
+	for id := range s.Active {
+		if id < oldest {
+			oldest = id
+		}
+	}
+	return oldest
+}
+
+// Horizon returns the oldest transaction whose changes may be invisible to an active
+// transaction, in the snapshot of its current statement or in any snapshot it takes later.
+// A row version superseded by a transaction older than the horizon is visible to no one
+// anymore (see table.VersionStore.Prune).
+func (tm *TransactionManager) Horizon() TransactionID {
+	tm.mu.RLock()
+	horizon := tm.nextTxnID
+	txns := make([]*Transaction, 0, len(tm.activeTxns))
+	for id, txn := range tm.activeTxns {
+		horizon = min(horizon, id)
+		txns = append(txns, txn)
+	}
+	tm.mu.RUnlock()
+
+	// A snapshot being taken is waited for, as StatementSnapshot holds txn.mu while taking it.
+	// Transactions that began meanwhile only see transactions at or after the horizon as active.
+	for _, txn := range txns {
+		txn.mu.RLock()
+		if txn.snapshot != nil {
+			horizon = min(horizon, txn.snapshot.oldest())
+		}
+		txn.mu.RUnlock()
+	}
+	return horizon
+}
+
+func (tm *TransactionManager) takeSnapshot(owner TransactionID) *Snapshot {
+	tm.mu.RLock()
+	defer tm.mu.RUnlock()
+	active := make(map[TransactionID]bool, len(tm.activeTxns))
+	for id := range tm.activeTxns {
+		if id != owner {
+			active[id] = true
+		}
+	}
+	return &Snapshot{Owner: owner, Xmax: tm.nextTxnID, Active: active}
+}
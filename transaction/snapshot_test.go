@@ -0,0 +1,78 @@
+package transaction
+
+import "testing"
+
+func TestStatementSnapshot(t *testing.T) {
+	tm := NewTransactionManager()
+
+	writer := tm.Begin()
+	readCommitted := tm.BeginWithIsolation(IsolationReadCommitted)
+	repeatableRead := tm.BeginWithIsolation(IsolationRepeatableRead)
+
+	rc := tm.StatementSnapshot(readCommitted)
+	rr := tm.StatementSnapshot(repeatableRead)
+	if rc.Visible(writer.ID) || rr.Visible(writer.ID) {
+		t.Error("expected an active transaction to be invisible")
+	}
+	if !rc.Visible(readCommitted.ID) {
+		t.Error("expected a transaction to see its own changes")
+	}
+
+	if err := tm.Commit(writer); err != nil {
+		t.Fatal(err)
+	}
+	later := tm.Begin()
+
+	rc = tm.StatementSnapshot(readCommitted)
+	rr = tm.StatementSnapshot(repeatableRead)
+	if !rc.Visible(writer.ID) {
+		t.Error("expected ReadCommitted to see a transaction committed before the statement")
+	}
+	if rr.Visible(writer.ID) {
+		t.Error("expected RepeatableRead to keep its first snapshot")
+	}
+	if rc.Visible(later.ID) || rr.Visible(later.ID) {
+		t.Error("expected a transaction started after the snapshot to be invisible")
+	}
+
+	t.Run("RowVisible", func(t *testing.T) {
+		if !rc.RowVisible(writer.ID.Bytes(), nil) {
+			t.Error("expected a committed row to be visible")
+		}
+		if rc.RowVisible(writer.ID.Bytes(), readCommitted.ID.Bytes()) {
+			t.Error("expected a row deleted by the owner to be invisible")
+		}
+		if !rc.RowVisible(writer.ID.Bytes(), later.ID.Bytes()) {
+			t.Error("expected a row deleted by an invisible transaction to be visible")
+		}
+		if rc.RowVisible([]byte{1}, nil) {
+			t.Error("expected a malformed xmin to be invisible")
+		}
+	})
+}
+
+func TestHorizon(t *testing.T) {
+	tm := NewTransactionManager()
+	if got := tm.Horizon(); got != 1 {
+		t.Errorf("expected the horizon to be the next transaction ID without active transactions, got %d", got)
+	}
+
+	writer := tm.Begin()
+	reader := tm.BeginWithIsolation(IsolationRepeatableRead)
+	tm.StatementSnapshot(reader)
+	if err := tm.Commit(writer); err != nil {
+		t.Fatal(err)
+	}
+	// The reader's snapshot does not see the writer, which is older than the reader itself
+	if got := tm.Horizon(); got != writer.ID {
+		t.Errorf("expected the horizon to be the writer %d, got %d", writer.ID, got)
+	}
+
+	if err := tm.Commit(reader); err != nil {
+		t.Fatal(err)
+	}
+	later := tm.Begin()
+	if got := tm.Horizon(); got != later.ID {
+		t.Errorf("expected the horizon to be the active transaction %d, got %d", later.ID, got)
+	}
+}
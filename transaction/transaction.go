@@ -3,20 +3,24 @@
 package transaction
 
 import (
-	"errors"
+	"maps"
 	"sync"
 	"time"
 
+	"github.com/Johniel/gorelly/buffer"
+	"github.com/Johniel/gorelly/clock"
 	"github.com/Johniel/gorelly/disk"
+	"github.com/Johniel/gorelly/errcode"
+	"github.com/Johniel/gorelly/histogram"
 )
 
 var (
 	// ErrTransactionNotActive is returned when an operation is attempted on a non-active transaction.
-	ErrTransactionNotActive = errors.New("transaction is not active")
+	ErrTransactionNotActive = errcode.New(errcode.InvalidTransactionState, "transaction is not active")
 	// ErrTransactionAlreadyCommitted is returned when attempting to commit an already committed transaction.
-	ErrTransactionAlreadyCommitted = errors.New("transaction already committed")
+	ErrTransactionAlreadyCommitted = errcode.New(errcode.InvalidTransactionState, "transaction already committed")
 	// ErrTransactionAlreadyAborted is returned when attempting to abort an already aborted transaction.
-	ErrTransactionAlreadyAborted = errors.New("transaction already aborted")
+	ErrTransactionAlreadyAborted = errcode.New(errcode.InvalidTransactionState, "transaction already aborted")
 )
 
 // TransactionState represents the state of a transaction.
@@ -44,9 +48,23 @@ type Transaction struct {
 	ID        TransactionID
 	State     TransactionState
 	StartTime time.Time
+	Isolation IsolationLevel // Which committed changes the statements see
+	snapshot  *Snapshot      // Snapshot of the current statement, kept for the whole transaction under RepeatableRead (nil until the first statement)
+	io        IOStats        // I/O attributed to the transaction, set when it ends
+	ioStart   buffer.IOStats // Buffer pool counters when the transaction began
+	commitTS  clock.Timestamp
+	created   map[disk.PageID]bool // Pages created by the transaction (see TagCreatedPage)
+	freed     []disk.PageID        // Pages to free when the transaction commits (see PageLog.DefersFree)
 	mu        sync.RWMutex
 }
 
+// IOStats is the I/O cost attributed to a transaction.
+type IOStats struct {
+	PagesRead    uint64 // Pages read from disk
+	PagesDirtied uint64 // Pages that became dirty (write amplification)
+	WALBytes     uint64 // Bytes appended to the write-ahead log
+}
+
 // NewTransaction creates a new transaction with the given ID.
 func NewTransaction(id TransactionID) *Transaction {
 	return &Transaction{
@@ -102,6 +120,57 @@ func (txn *Transaction) Abort() error {
 	return nil
 }
 
+// IOStats returns the I/O attributed to the transaction by the TransactionManager.
+// It is filled in when the transaction commits or aborts.
+func (txn *Transaction) IOStats() IOStats {
+	txn.mu.RLock()
+	defer txn.mu.RUnlock()
+	return txn.io
+}
+
+// CommitTS returns the hybrid logical timestamp the TransactionManager assigned to the
+// transaction when it committed, or 0 if it has not committed. Commit timestamps are unique and
+// increase in commit order, also across restarts when the transaction manager has a log.
+func (txn *Transaction) CommitTS() clock.Timestamp {
+	txn.mu.RLock()
+	defer txn.mu.RUnlock()
+	return txn.commitTS
+}
+
+// TagCreatedPage marks a page as created by the transaction and referenced only by its changes,
+// e.g. a page allocated by a bulk insert. When the transaction is rolled back the page is
+// dropped from the buffer pool and freed without being written back (see RecoveryManager.Rollback).
+// PageLog tags the pages it sees allocated.
+func (txn *Transaction) TagCreatedPage(pageID disk.PageID) {
+	txn.mu.Lock()
+	defer txn.mu.Unlock()
+	if txn.created == nil {
+		txn.created = make(map[disk.PageID]bool)
+	}
+	txn.created[pageID] = true
+}
+
+// untagCreatedPage removes the tag of a page the transaction freed itself.
+func (txn *Transaction) untagCreatedPage(pageID disk.PageID) {
+	txn.mu.Lock()
+	defer txn.mu.Unlock()
+	delete(txn.created, pageID)
+}
+
+// deferFree records a page the transaction freed, to be freed when it commits.
+func (txn *Transaction) deferFree(pageID disk.PageID) {
+	txn.mu.Lock()
+	defer txn.mu.Unlock()
+	txn.freed = append(txn.freed, pageID)
+}
+
+// createdPages returns the pages tagged by TagCreatedPage.
+func (txn *Transaction) createdPages() map[disk.PageID]bool {
+	txn.mu.RLock()
+	defer txn.mu.RUnlock()
+	return maps.Clone(txn.created)
+}
+
 // IsActive returns true if the transaction is currently active.
 func (txn *Transaction) IsActive() bool {
 	txn.mu.RLock()
@@ -129,9 +198,14 @@ func (txn *Transaction) IsAborted() bool {
 type TransactionManager struct {
 	nextTxnID       TransactionID
 	activeTxns      map[TransactionID]*Transaction
-	logManager      *LogManager      // Optional: for WAL logging
-	lockManager     *LockManager     // Optional: for lock management
-	recoveryManager *RecoveryManager // Optional: for rollback operations
+	logManager      *LogManager                           // Optional: for WAL logging
+	lockManager     *LockManager                          // Optional: for lock management
+	recoveryManager *RecoveryManager                      // Optional: for rollback operations
+	bufmgr          *buffer.BufferPoolManager             // Optional: for page I/O accounting and deferred frees
+	reportIO        func(txn *Transaction, stats IOStats) // Optional: called with the I/O of every committed transaction
+	clock           clock.Clock                           // Source of transaction start times
+	hlc             *clock.HLC                            // Source of commit timestamps
+	commitLatency   histogram.Histogram                   // Time to append and sync commit records
 	mu              sync.RWMutex
 }
 
@@ -141,6 +215,8 @@ func NewTransactionManager() *TransactionManager {
 	return &TransactionManager{
 		nextTxnID:  1,
 		activeTxns: make(map[TransactionID]*Transaction),
+		clock:      clock.System,
+		hlc:        clock.NewHLC(clock.System),
 	}
 }
 
@@ -149,12 +225,25 @@ func NewTransactionManager() *TransactionManager {
 // lockManager: Required for concurrency control (can be nil to disable locking)
 // recoveryManager: Optional, used for rollback operations (can be nil)
 func NewTransactionManagerWithManagers(logManager *LogManager, lockManager *LockManager, recoveryManager *RecoveryManager) *TransactionManager {
-	return &TransactionManager{
+	tm := &TransactionManager{
 		nextTxnID:       1,
 		activeTxns:      make(map[TransactionID]*Transaction),
 		logManager:      logManager,
 		lockManager:     lockManager,
 		recoveryManager: recoveryManager,
+		clock:           clock.System,
+		hlc:             clock.NewHLC(clock.System),
+	}
+	tm.observeLog()
+	return tm
+}
+
+// observeLog makes the commit timestamps and transaction IDs continue after the last ones in
+// the log, so that IDs stored in rows (see table.Table.Writer) stay ordered across restarts.
+func (tm *TransactionManager) observeLog() {
+	if tm.logManager != nil {
+		tm.hlc.Update(tm.logManager.LastCommitTS())
+		tm.nextTxnID = max(tm.nextTxnID, tm.logManager.LastTxnID()+1)
 	}
 }
 
@@ -166,6 +255,62 @@ func (tm *TransactionManager) SetManagers(logManager *LogManager, lockManager *L
 	tm.logManager = logManager
 	tm.lockManager = lockManager
 	tm.recoveryManager = recoveryManager
+	tm.observeLog()
+}
+
+// SetClock sets the clock transaction start times and the wall time of commit timestamps
+// are taken from. Commit timestamps keep increasing even if c is behind the previous clock.
+func (tm *TransactionManager) SetClock(c clock.Clock) {
+	tm.mu.Lock()
+	defer tm.mu.Unlock()
+	tm.clock = c
+	last := tm.hlc.Last()
+	tm.hlc = clock.NewHLC(c)
+	tm.hlc.Update(last)
+}
+
+// ObserveTimestamp makes every later commit timestamp greater than ts, e.g. a commit
+// timestamp received from a replication stream.
+func (tm *TransactionManager) ObserveTimestamp(ts clock.Timestamp) {
+	tm.mu.RLock()
+	defer tm.mu.RUnlock()
+	tm.hlc.Update(ts)
+}
+
+// SetBufferPoolManager enables page I/O accounting: the pages read and dirtied through bufmgr
+// while a transaction is active are attributed to it. The counters are shared by the whole
+// buffer pool, so the attribution is exact only while transactions run one at a time;
+// WAL bytes are always attributed exactly. It is also where the pages a transaction freed
+// through a locking PageLog are freed when it commits (see PageLog.DefersFree).
+func (tm *TransactionManager) SetBufferPoolManager(bufmgr *buffer.BufferPoolManager) {
+	tm.mu.Lock()
+	defer tm.mu.Unlock()
+	tm.bufmgr = bufmgr
+}
+
+// SetIOReporter registers a function called with the I/O statistics of every committed transaction,
+// e.g. to feed a statistics view. A nil function disables reporting.
+func (tm *TransactionManager) SetIOReporter(report func(txn *Transaction, stats IOStats)) {
+	tm.mu.Lock()
+	defer tm.mu.Unlock()
+	tm.reportIO = report
+}
+
+// finishIO computes the I/O attributed to a transaction that is ending.
+func (tm *TransactionManager) finishIO(txn *Transaction) IOStats {
+	var stats IOStats
+	if tm.bufmgr != nil {
+		delta := tm.bufmgr.IOStats().Sub(txn.ioStart)
+		stats.PagesRead = delta.PagesRead
+		stats.PagesDirtied = delta.PagesDirtied
+	}
+	if tm.logManager != nil {
+		stats.WALBytes = tm.logManager.takeWALBytes(txn.ID)
+	}
+	txn.mu.Lock()
+	txn.io = stats
+	txn.mu.Unlock()
+	return stats
 }
 
 // Begin starts a new transaction and returns it.
@@ -178,6 +323,10 @@ func (tm *TransactionManager) Begin() *Transaction {
 	tm.nextTxnID++
 
 	txn := NewTransaction(txnID)
+	txn.StartTime = tm.clock.Now()
+	if tm.bufmgr != nil {
+		txn.ioStart = tm.bufmgr.IOStats()
+	}
 	tm.activeTxns[txnID] = txn
 
 	// Write Begin log record if LogManager is configured
@@ -194,7 +343,8 @@ func (tm *TransactionManager) Begin() *Transaction {
 }
 
 // Commit commits a transaction.
-// It writes a Commit log record, flushes the log, releases all locks, and transitions to Terminated state.
+// It assigns the commit timestamp (see Transaction.CommitTS), writes a Commit log record carrying it
+// (synced according to the log's SyncPolicy), releases all locks, and transitions to Terminated state.
 func (tm *TransactionManager) Commit(txn *Transaction) error {
 	tm.mu.Lock()
 	defer tm.mu.Unlock()
@@ -204,22 +354,23 @@ func (tm *TransactionManager) Commit(txn *Transaction) error {
 	if err := txn.Commit(); err != nil {
 		return err
 	}
+	commitTS := tm.hlc.Now()
 
 	// Write Commit log record if LogManager is configured
 	if tm.logManager != nil {
 		commitRecord := &LogRecord{
-			Type:  LogRecordTypeCommit,
-			TxnID: txn.ID,
+			Type:     LogRecordTypeCommit,
+			TxnID:    txn.ID,
+			CommitTS: commitTS,
 		}
+		// The commit record is made durable according to the log's sync policy
+		start := time.Now()
 		if err := tm.logManager.AppendLog(commitRecord); err != nil {
 			// If log write fails, we should rollback the transaction state
 			// For now, we'll return the error and let the caller handle it
 			return err
 		}
-		// Flush log to ensure durability
-		if err := tm.logManager.Flush(); err != nil {
-			return err
-		}
+		tm.commitLatency.Since(start)
 	}
 
 	// Release all locks if LockManager is configured
@@ -227,6 +378,25 @@ func (tm *TransactionManager) Commit(txn *Transaction) error {
 		tm.lockManager.UnlockAll(txn)
 	}
 
+	txn.mu.Lock()
+	txn.commitTS = commitTS
+	freed := txn.freed
+	txn.freed = nil
+	txn.mu.Unlock()
+
+	// The pages freed by the transaction can no longer be brought back by a rollback. They are
+	// freed once their page locks are released, so whoever reuses them can lock them.
+	if tm.bufmgr != nil {
+		for _, pageID := range freed {
+			tm.bufmgr.FreeBuffer(pageID)
+		}
+	}
+
+	stats := tm.finishIO(txn)
+	if tm.reportIO != nil {
+		tm.reportIO(txn, stats)
+	}
+
 	// Remove from active transactions and transition to terminated
 	delete(tm.activeTxns, txn.ID)
 	txn.State = TransactionStateTerminated
@@ -274,6 +444,8 @@ func (tm *TransactionManager) Abort(txn *Transaction) error {
 		tm.lockManager.UnlockAll(txn)
 	}
 
+	tm.finishIO(txn)
+
 	// Remove from active transactions and transition to terminated
 	delete(tm.activeTxns, txn.ID)
 	txn.State = TransactionStateTerminated
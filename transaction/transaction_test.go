@@ -0,0 +1,188 @@
+package transaction
+
+import (
+	"os"
+	"testing"
+	"time"
+
+	"github.com/Johniel/gorelly/btree"
+	"github.com/Johniel/gorelly/buffer"
+	"github.com/Johniel/gorelly/clock"
+	"github.com/Johniel/gorelly/disk"
+)
+
+func TestTransactionIOStats(t *testing.T) {
+	tmpfile, err := os.CreateTemp("", "test_txn_io_*.db")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(tmpfile.Name())
+
+	dm, err := disk.NewDiskManager(tmpfile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer dm.Close()
+
+	pool := buffer.NewBufferPool(10)
+	bufmgr := buffer.NewBufferPoolManager(dm, pool)
+
+	logfile, err := os.CreateTemp("", "test_txn_io_*.log")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(logfile.Name())
+	logfile.Close()
+
+	lm, err := NewLogManager(logfile.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer lm.Close()
+
+	bt, err := btree.CreateBTree(bufmgr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := bufmgr.Flush(); err != nil {
+		t.Fatal(err)
+	}
+
+	tm := NewTransactionManagerWithManagers(lm, nil, nil)
+	tm.SetBufferPoolManager(bufmgr)
+	reported := make(map[TransactionID]IOStats)
+	tm.SetIOReporter(func(txn *Transaction, stats IOStats) {
+		reported[txn.ID] = stats
+	})
+
+	txn := tm.Begin()
+	if err := bt.Insert(bufmgr, []byte("key"), []byte("value")); err != nil {
+		t.Fatal(err)
+	}
+	update := &LogRecord{Type: LogRecordTypeUpdate, TxnID: txn.ID, NewValue: []byte("value")}
+	if err := lm.AppendLog(update); err != nil {
+		t.Fatal(err)
+	}
+	if err := tm.Commit(txn); err != nil {
+		t.Fatal(err)
+	}
+
+	stats := txn.IOStats()
+	if stats.PagesDirtied != 1 {
+		t.Errorf("expected 1 dirtied page (the root leaf), got %d", stats.PagesDirtied)
+	}
+	begin := serializeRecord(&LogRecord{Type: LogRecordTypeBegin, TxnID: txn.ID})
+	commit := serializeRecord(&LogRecord{Type: LogRecordTypeCommit, TxnID: txn.ID, CommitTS: txn.CommitTS()})
+	expectedWAL := uint64(len(begin) + len(serializeRecord(update)) + len(commit))
+	if stats.WALBytes != expectedWAL {
+		t.Errorf("expected %d WAL bytes, got %d", expectedWAL, stats.WALBytes)
+	}
+	if reported[txn.ID] != stats {
+		t.Errorf("expected the reporter to receive %+v, got %+v", stats, reported[txn.ID])
+	}
+
+	// An aborted transaction has its statistics filled in but is not reported
+	aborted := tm.Begin()
+	if err := tm.Abort(aborted); err != nil {
+		t.Fatal(err)
+	}
+	if aborted.IOStats().WALBytes == 0 {
+		t.Error("expected the aborted transaction's WAL bytes to be counted")
+	}
+	if _, ok := reported[aborted.ID]; ok {
+		t.Error("expected aborted transactions not to be reported")
+	}
+}
+
+func TestTransactionManagerClock(t *testing.T) {
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	c := clock.NewLogical(start)
+	tm := NewTransactionManager()
+	tm.SetClock(c)
+
+	first := tm.Begin()
+	c.Advance(time.Second)
+	second := tm.Begin()
+	if !first.StartTime.Equal(start) || !second.StartTime.Equal(start.Add(time.Second)) {
+		t.Errorf("expected start times from the logical clock, got %v and %v", first.StartTime, second.StartTime)
+	}
+}
+
+func TestTransactionCommitTS(t *testing.T) {
+	logfile, err := os.CreateTemp("", "test_commit_ts_*.log")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(logfile.Name())
+	logfile.Close()
+
+	lm, err := NewLogManager(logfile.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	tm := NewTransactionManagerWithManagers(lm, nil, nil)
+	tm.SetClock(clock.NewLogical(start.Add(time.Hour)))
+
+	var last clock.Timestamp
+	var lastID TransactionID
+	for i := 0; i < 3; i++ {
+		txn := tm.Begin()
+		lastID = txn.ID
+		if txn.CommitTS() != 0 {
+			t.Errorf("expected no commit timestamp before commit, got %d", txn.CommitTS())
+		}
+		if err := tm.Commit(txn); err != nil {
+			t.Fatal(err)
+		}
+		if txn.CommitTS() <= last {
+			t.Errorf("commit timestamp %d not after %d", txn.CommitTS(), last)
+		}
+		last = txn.CommitTS()
+	}
+
+	records, err := lm.ReadLog()
+	if err != nil {
+		t.Fatal(err)
+	}
+	commit := records[len(records)-1]
+	if commit.Type != LogRecordTypeCommit || commit.CommitTS != last {
+		t.Errorf("expected the commit record to carry %d, got %+v", last, commit)
+	}
+	if err := lm.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// After a restart with the wall clock an hour behind, timestamps still increase
+	lm, err = NewLogManager(logfile.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer lm.Close()
+	if lm.LastCommitTS() != last {
+		t.Errorf("expected the recovered last commit timestamp %d, got %d", last, lm.LastCommitTS())
+	}
+	tm = NewTransactionManagerWithManagers(lm, nil, nil)
+	tm.SetClock(clock.NewLogical(start))
+	txn := tm.Begin()
+	if err := tm.Commit(txn); err != nil {
+		t.Fatal(err)
+	}
+	if txn.CommitTS() <= last {
+		t.Errorf("commit timestamp %d after restart not after %d", txn.CommitTS(), last)
+	}
+	if txn.ID <= lastID {
+		t.Errorf("transaction ID %d after restart not after %d", txn.ID, lastID)
+	}
+
+	// Observed timestamps, e.g. from a replication stream, are passed too
+	remote := clock.NewTimestamp(start.Add(24*time.Hour), 0)
+	tm.ObserveTimestamp(remote)
+	txn = tm.Begin()
+	if err := tm.Commit(txn); err != nil {
+		t.Fatal(err)
+	}
+	if txn.CommitTS() <= remote {
+		t.Errorf("commit timestamp %d not after the observed %d", txn.CommitTS(), remote)
+	}
+}
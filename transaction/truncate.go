@@ -0,0 +1,161 @@
+package transaction
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+
+	"github.com/Johniel/gorelly/errcode"
+)
+
+var (
+	// ErrLogStillNeeded is returned when truncating log records that recovery still needs.
+	ErrLogStillNeeded = errcode.New(errcode.ObjectNotInPrerequisiteState, "log records are still needed for recovery")
+)
+
+// SetArchiveDir makes Truncate move the removed records into a file in dir instead of
+// discarding them. An empty dir discards them again.
+func (lm *LogManager) SetArchiveDir(dir string) {
+	lm.mu.Lock()
+	defer lm.mu.Unlock()
+	lm.archiveDir = dir
+}
+
+// Truncate removes the records with LSNs below upToLSN from the start of the log. With an
+// archive directory (see SetArchiveDir) they are written to a file named after the log and the
+// LSN of the first removed record, e.g. "wal.log.00000000000000000001", in the log format.
+//
+// Only records before the point the last checkpoint starts recovery from can be removed
+// (see Checkpoint); truncating further returns ErrLogStillNeeded without removing anything.
+//
+// A segmented log (see NewSegmentedLogManager) is truncated by removing the segments whose
+// records are all below upToLSN; the records of the segment holding upToLSN are kept.
+func (lm *LogManager) Truncate(upToLSN uint64) error {
+	lm.mu.Lock()
+	defer lm.mu.Unlock()
+
+	start := lm.files.start()
+	cut := start
+	var firstLSN uint64
+	header := make([]byte, 12)
+	for cut < lm.files.end() {
+		if _, err := lm.files.ReadAt(header, cut); err != nil {
+			return ErrLogCorrupted
+		}
+		lsn := binary.BigEndian.Uint64(header)
+		if upToLSN <= lsn {
+			break
+		}
+		if cut == start {
+			firstLSN = lsn
+		}
+		cut += 12 + int64(binary.BigEndian.Uint32(header[8:]))
+	}
+	if cut == start {
+		return nil
+	}
+	if lm.recoverFrom < cut {
+		return ErrLogStillNeeded
+	}
+	if lm.files.segmentSize != 0 {
+		cut = lm.files.segmentStart(cut)
+		if cut == start {
+			return nil
+		}
+	}
+
+	if lm.archiveDir != "" {
+		archivePath := filepath.Join(lm.archiveDir, fmt.Sprintf("%s.%020d", filepath.Base(lm.files.path), firstLSN))
+		if err := lm.copyRange(archivePath, start, cut); err != nil {
+			return err
+		}
+	}
+	if lm.files.segmentSize != 0 {
+		// Offsets count from the beginning of the log, so the kept segments need no rewriting
+		return lm.files.removeBefore(cut)
+	}
+	return lm.rewriteFrom(cut)
+}
+
+// copyRange writes the log between offsets from and to to a new file at path.
+func (lm *LogManager) copyRange(path string, from int64, to int64) error {
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_EXCL, 0644)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(file, io.NewSectionReader(lm.files, from, to-from)); err != nil {
+		file.Close()
+		return err
+	}
+	if err := file.Sync(); err != nil {
+		file.Close()
+		return err
+	}
+	return file.Close()
+}
+
+// rewriteFrom replaces the log with its records from offset cut on. Checkpoint records refer to
+// offsets in the log, so they are rewritten to the new offsets, and so are the offsets kept in
+// memory. The caller must hold lm.mu.
+func (lm *LogManager) rewriteFrom(cut int64) error {
+	tmpPath := lm.files.path + ".truncate"
+	tmp, err := os.OpenFile(tmpPath, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0644)
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmpPath)
+
+	newOffsets := make(map[int64]int64) // Offsets of the kept records in the old log to the new one
+	var size int64
+	header := make([]byte, 12)
+	for offset := cut; offset < lm.files.end(); {
+		if _, err := lm.files.ReadAt(header, offset); err != nil {
+			tmp.Close()
+			return ErrLogCorrupted
+		}
+		data := make([]byte, 12+int(binary.BigEndian.Uint32(header[8:])))
+		if _, err := lm.files.ReadAt(data, offset); err != nil {
+			tmp.Close()
+			return ErrLogCorrupted
+		}
+		record, err := deserializeRecord(binary.BigEndian.Uint64(header), data[12:])
+		if err != nil {
+			tmp.Close()
+			return err
+		}
+		if record.Type == LogRecordTypeCheckpoint && record.Checkpoint != nil {
+			// Checkpoints older than the cut may start before it; their recovery starts at the cut
+			record.Checkpoint.StartOffset = newOffsets[record.Checkpoint.StartOffset]
+			data = serializeRecord(record)
+		}
+		if _, err := tmp.Write(data); err != nil {
+			tmp.Close()
+			return err
+		}
+		newOffsets[offset] = size
+		size += int64(len(data))
+		offset += 12 + int64(binary.BigEndian.Uint32(header[8:]))
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(tmpPath, lm.files.path); err != nil {
+		return err
+	}
+	if err := lm.reopen(lm.syncPolicy.Mode == SyncModeODSync); err != nil {
+		return err
+	}
+
+	lm.recoverFrom = newOffsets[lm.recoverFrom]
+	for txnID, txn := range lm.active {
+		txn.beginOffset = newOffsets[txn.beginOffset]
+		lm.active[txnID] = txn
+	}
+	return nil
+}
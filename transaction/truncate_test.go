@@ -0,0 +1,191 @@
+package transaction
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/Johniel/gorelly/buffer"
+	"github.com/Johniel/gorelly/disk"
+	"github.com/Johniel/gorelly/table"
+)
+
+func TestTruncate(t *testing.T) {
+	tmpfile, err := os.CreateTemp("", "test_truncate_*.db")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(tmpfile.Name())
+
+	dir := t.TempDir()
+	logPath := filepath.Join(dir, "wal.log")
+	archiveDir := filepath.Join(dir, "archive")
+	if err := os.Mkdir(archiveDir, 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	dm, err := disk.NewDiskManager(tmpfile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer dm.Close()
+
+	lm, err := OpenLogManager(logPath, SyncPolicy{Mode: SyncModeNone})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer lm.Close()
+	lm.SetArchiveDir(archiveDir)
+
+	bufmgr := buffer.NewBufferPoolManager(dm, buffer.NewBufferPool(16))
+	bufmgr.SetWAL(lm)
+	users := &table.Table{NumKeyElems: 1}
+	if err := users.Create(bufmgr); err != nil {
+		t.Fatal(err)
+	}
+	if err := bufmgr.Flush(); err != nil {
+		t.Fatal(err)
+	}
+
+	tm := NewTransactionManagerWithManagers(lm, nil, nil)
+	key := func(i int) [][]byte {
+		return [][]byte{[]byte(fmt.Sprintf("key%04d", i)), []byte("value")}
+	}
+	insert := func(from int, to int) {
+		t.Helper()
+		txn := tm.Begin()
+		logged := *users
+		logged.Log = NewPageLog(lm, txn)
+		for i := from; i < to; i++ {
+			if err := logged.Insert(bufmgr, key(i)); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if err := tm.Commit(txn); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	insert(0, 100)
+	lastLSN := lm.nextLSN - 1
+	if err := lm.Truncate(lastLSN); err != ErrLogStillNeeded {
+		t.Fatalf("expected ErrLogStillNeeded without a checkpoint, got %v", err)
+	}
+	if err := lm.Checkpoint(bufmgr); err != nil {
+		t.Fatal(err)
+	}
+	checkpointLSN := lm.nextLSN - 1
+	insert(100, 200)
+	if err := lm.Truncate(checkpointLSN + 1); err != ErrLogStillNeeded {
+		t.Fatalf("expected ErrLogStillNeeded for the checkpoint itself, got %v", err)
+	}
+
+	if err := lm.Truncate(checkpointLSN); err != nil {
+		t.Fatal(err)
+	}
+	records, err := lm.ReadLog()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if records[0].LSN != checkpointLSN || records[0].Type != LogRecordTypeCheckpoint {
+		t.Fatalf("expected the log to start at the checkpoint, got LSN %d", records[0].LSN)
+	}
+
+	// The removed records are in the archive
+	archive, err := NewLogManager(filepath.Join(archiveDir, fmt.Sprintf("wal.log.%020d", 1)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	archived, err := archive.ReadLog()
+	archive.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(archived) != int(checkpointLSN-1) || archived[len(archived)-1].LSN != checkpointLSN-1 {
+		t.Errorf("expected LSNs 1 to %d in the archive, got %d records", checkpointLSN-1, len(archived))
+	}
+
+	// The truncated log still recovers a crash, also once reopened
+	if err := lm.Close(); err != nil {
+		t.Fatal(err)
+	}
+	lm, err = OpenLogManager(logPath, SyncPolicy{Mode: SyncModeNone})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer lm.Close()
+	heapFile, err := os.OpenFile(tmpfile.Name(), os.O_RDWR, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	dm2, err := disk.NewDiskManager(heapFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer dm2.Close()
+	bufmgr2 := buffer.NewBufferPoolManager(dm2, buffer.NewBufferPool(16))
+	bufmgr2.SetWAL(lm)
+	if err := NewRecoveryManager(lm, bufmgr2).Recover(); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 200; i++ {
+		if _, err := users.Get(bufmgr2, key(i)[:1]); err != nil {
+			t.Fatalf("row %d: %v", i, err)
+		}
+	}
+	if next := lm.nextLSN; next <= lastLSN {
+		t.Errorf("expected LSNs to continue after the truncation, got %d", next)
+	}
+}
+
+func TestTruncateKeepsTxnIDs(t *testing.T) {
+	tmpfile, err := os.CreateTemp("", "test_truncate_*.db")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(tmpfile.Name())
+	logPath := filepath.Join(t.TempDir(), "wal.log")
+
+	dm, err := disk.NewDiskManager(tmpfile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer dm.Close()
+	lm, err := OpenLogManager(logPath, SyncPolicy{Mode: SyncModeNone})
+	if err != nil {
+		t.Fatal(err)
+	}
+	bufmgr := buffer.NewBufferPoolManager(dm, buffer.NewBufferPool(16))
+	bufmgr.SetWAL(lm)
+
+	tm := NewTransactionManagerWithManagers(lm, nil, nil)
+	var lastID TransactionID
+	for i := 0; i < 3; i++ {
+		txn := tm.Begin()
+		lastID = txn.ID
+		if err := tm.Commit(txn); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := lm.Checkpoint(bufmgr); err != nil {
+		t.Fatal(err)
+	}
+	// Only the checkpoint is left, and it carries the next transaction ID
+	if err := lm.Truncate(lm.nextLSN - 1); err != nil {
+		t.Fatal(err)
+	}
+	if err := lm.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	lm, err = OpenLogManager(logPath, SyncPolicy{Mode: SyncModeNone})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer lm.Close()
+	txn := NewTransactionManagerWithManagers(lm, nil, nil).Begin()
+	if txn.ID <= lastID {
+		t.Errorf("expected transaction IDs to continue after %d, got %d", lastID, txn.ID)
+	}
+}
@@ -0,0 +1,55 @@
+package transaction
+
+import (
+	"github.com/Johniel/gorelly/btree"
+	"github.com/Johniel/gorelly/disk"
+)
+
+// WriteBufferLog logs the pairs of a btree.WriteBuffer to the write-ahead log, so that
+// pairs not yet flushed into the tree survive a crash.
+type WriteBufferLog struct {
+	log  *LogManager
+	tree disk.PageID // Meta page ID of the tree the buffer belongs to
+}
+
+// NewWriteBufferLog returns a log for the write buffer of the tree whose meta page is treeMetaPageID.
+func NewWriteBufferLog(log *LogManager, treeMetaPageID disk.PageID) *WriteBufferLog {
+	return &WriteBufferLog{log: log, tree: treeMetaPageID}
+}
+
+func (wbl *WriteBufferLog) LogInsert(key []byte, value []byte) error {
+	return wbl.log.AppendLog(&LogRecord{
+		Type:     LogRecordTypeBufferedInsert,
+		PageID:   wbl.tree,
+		Key:      key,
+		NewValue: value,
+	})
+}
+
+func (wbl *WriteBufferLog) LogFlush() error {
+	return wbl.log.AppendLog(&LogRecord{Type: LogRecordTypeBufferFlush, PageID: wbl.tree})
+}
+
+// Restore refills wb with the pairs logged after the last flush of the buffer, e.g. after a restart.
+func (wbl *WriteBufferLog) Restore(wb *btree.WriteBuffer) error {
+	records, err := wbl.log.ReadLog()
+	if err != nil {
+		return err
+	}
+	var pending []*LogRecord
+	for _, record := range records {
+		if record.PageID != wbl.tree {
+			continue
+		}
+		switch record.Type {
+		case LogRecordTypeBufferedInsert:
+			pending = append(pending, record)
+		case LogRecordTypeBufferFlush:
+			pending = nil
+		}
+	}
+	for _, record := range pending {
+		wb.Restore(record.Key, record.NewValue)
+	}
+	return nil
+}
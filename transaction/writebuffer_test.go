@@ -0,0 +1,91 @@
+package transaction
+
+import (
+	"os"
+	"testing"
+
+	"github.com/Johniel/gorelly/btree"
+	"github.com/Johniel/gorelly/buffer"
+	"github.com/Johniel/gorelly/disk"
+)
+
+func TestWriteBufferLogRestore(t *testing.T) {
+	tmpfile, err := os.CreateTemp("", "test_write_buffer_log_*.db")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(tmpfile.Name())
+
+	dm, err := disk.NewDiskManager(tmpfile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer dm.Close()
+
+	pool := buffer.NewBufferPool(10)
+	bufmgr := buffer.NewBufferPoolManager(dm, pool)
+
+	logfile, err := os.CreateTemp("", "test_write_buffer_log_*.log")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(logfile.Name())
+	logfile.Close()
+
+	lm, err := NewLogManager(logfile.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer lm.Close()
+
+	bt, err := btree.CreateBTree(bufmgr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	other, err := btree.CreateBTree(bufmgr)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	wb := btree.NewWriteBuffer(bt, NewWriteBufferLog(lm, bt.MetaPageID))
+	if err := wb.Insert(bufmgr, []byte("a"), []byte("flushed")); err != nil {
+		t.Fatal(err)
+	}
+	if err := wb.Flush(bufmgr); err != nil {
+		t.Fatal(err)
+	}
+	for _, key := range []string{"c", "b"} {
+		if err := wb.Insert(bufmgr, []byte(key), []byte("buffered")); err != nil {
+			t.Fatal(err)
+		}
+	}
+	otherWb := btree.NewWriteBuffer(other, NewWriteBufferLog(lm, other.MetaPageID))
+	if err := otherWb.Insert(bufmgr, []byte("z"), []byte("other tree")); err != nil {
+		t.Fatal(err)
+	}
+
+	// After a crash, only the pairs buffered since the last flush of the tree come back
+	restored := btree.NewWriteBuffer(bt, NewWriteBufferLog(lm, bt.MetaPageID))
+	if err := NewWriteBufferLog(lm, bt.MetaPageID).Restore(restored); err != nil {
+		t.Fatal(err)
+	}
+	if restored.Len() != 2 {
+		t.Fatalf("expected 2 restored pairs, got %d", restored.Len())
+	}
+	iter, err := restored.Search(bufmgr, btree.NewSearchModeStart())
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, expected := range []string{"a", "b", "c"} {
+		key, _, ok, err := iter.Next(bufmgr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !ok || string(key) != expected {
+			t.Errorf("expected key %q, got %q", expected, key)
+		}
+	}
+	if _, _, ok, _ := iter.Next(bufmgr); ok {
+		t.Error("expected no more keys")
+	}
+}
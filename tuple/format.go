@@ -0,0 +1,113 @@
+package tuple
+
+import (
+	"encoding/binary"
+
+	"github.com/Johniel/gorelly/btree/memcmpable"
+)
+
+// Format identifies how the non-key part of a row is encoded.
+// Keys are always encoded with Encode so that they stay memcmpable and prefix-comparable.
+type Format uint8
+
+const (
+	// FormatV1 concatenates memcmpable-encoded elements; reaching column N decodes the N columns before it.
+	FormatV1 Format = iota
+	// FormatV2 prefixes the raw elements with a header holding the column count and
+	// the end offset of every column, so any column can be read without decoding the others.
+	FormatV2
+)
+
+const (
+	v2CountSize  = 2
+	v2OffsetSize = 4
+)
+
+// EncodeValue encodes the non-key elements of a row in format f.
+func (f Format) EncodeValue(elems [][]byte, bytes *[]byte) {
+	if f == FormatV2 {
+		EncodeV2(elems, bytes)
+		return
+	}
+	Encode(elems, bytes)
+}
+
+// DecodeValue decodes a row value encoded in format f, appending its elements to elems.
+func (f Format) DecodeValue(bytes []byte, elems *[][]byte) {
+	if f == FormatV2 {
+		DecodeV2(bytes, elems)
+		return
+	}
+	Decode(bytes, elems)
+}
+
+// Column returns the i-th element of a row value encoded in format f.
+// It returns false if the value has no such column.
+func (f Format) Column(bytes []byte, i int) ([]byte, bool) {
+	if f == FormatV2 {
+		return ColumnV2(bytes, i)
+	}
+	rest := bytes
+	for j := 0; len(rest) > 0; j++ {
+		var elem []byte
+		memcmpable.Decode(&rest, &elem)
+		if j == i {
+			return elem, true
+		}
+	}
+	return nil, false
+}
+
+// EncodeV2 encodes a tuple in the v2 row format:
+// a 2-byte column count, a 4-byte end offset per column (relative to the start of the data),
+// followed by the raw elements.
+func EncodeV2(elems [][]byte, bytes *[]byte) {
+	header := make([]byte, v2CountSize+v2OffsetSize*len(elems))
+	binary.BigEndian.PutUint16(header, uint16(len(elems)))
+	end := 0
+	for i, elem := range elems {
+		end += len(elem)
+		binary.BigEndian.PutUint32(header[v2CountSize+v2OffsetSize*i:], uint32(end))
+	}
+	*bytes = append(*bytes, header...)
+	for _, elem := range elems {
+		*bytes = append(*bytes, elem...)
+	}
+}
+
+// DecodeV2 decodes a v2 row, appending its elements to elems.
+func DecodeV2(bytes []byte, elems *[][]byte) {
+	for i := 0; i < NumColumnsV2(bytes); i++ {
+		elem, _ := ColumnV2(bytes, i)
+		*elems = append(*elems, elem)
+	}
+}
+
+// NumColumnsV2 returns the number of columns of a v2 row.
+func NumColumnsV2(bytes []byte) int {
+	if len(bytes) < v2CountSize {
+		return 0
+	}
+	return int(binary.BigEndian.Uint16(bytes))
+}
+
+// ColumnV2 returns a copy of the i-th column of a v2 row in constant time.
+// It returns false if the row has no such column.
+func ColumnV2(bytes []byte, i int) ([]byte, bool) {
+	n := NumColumnsV2(bytes)
+	if i < 0 || n <= i {
+		return nil, false
+	}
+	dataStart := v2CountSize + v2OffsetSize*n
+	start := 0
+	if 0 < i {
+		start = int(binary.BigEndian.Uint32(bytes[v2CountSize+v2OffsetSize*(i-1):]))
+	}
+	end := int(binary.BigEndian.Uint32(bytes[v2CountSize+v2OffsetSize*i:]))
+	if end < start || len(bytes) < dataStart+end {
+		return nil, false
+	}
+	elem := make([]byte, end-start)
+	copy(elem, bytes[dataStart+start:dataStart+end])
+	return elem, true
+}
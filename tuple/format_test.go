@@ -0,0 +1,65 @@
+package tuple
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+)
+
+func equalElems(a, b [][]byte) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if !bytes.Equal(a[i], b[i]) {
+			return false
+		}
+	}
+	return true
+}
+
+func TestFormatV2(t *testing.T) {
+	elems := [][]byte{[]byte("Alice"), {}, []byte("30"), {0x00, 0xff}}
+
+	var encoded []byte
+	FormatV2.EncodeValue(elems, &encoded)
+	if n := NumColumnsV2(encoded); n != len(elems) {
+		t.Fatalf("expected %d columns, got %d", len(elems), n)
+	}
+
+	t.Run("Decode", func(t *testing.T) {
+		var decoded [][]byte
+		FormatV2.DecodeValue(encoded, &decoded)
+		if !reflect.DeepEqual(decoded, elems) {
+			t.Errorf("expected %q, got %q", elems, decoded)
+		}
+	})
+
+	t.Run("Column", func(t *testing.T) {
+		for i, expected := range elems {
+			col, ok := FormatV2.Column(encoded, i)
+			if !ok || !reflect.DeepEqual(col, expected) {
+				t.Errorf("column %d: expected %q, got %q (%v)", i, expected, col, ok)
+			}
+		}
+		if _, ok := FormatV2.Column(encoded, len(elems)); ok {
+			t.Error("expected no column past the end")
+		}
+		if _, ok := FormatV2.Column(encoded[:len(encoded)-1], 3); ok {
+			t.Error("expected a truncated row to be rejected")
+		}
+	})
+
+	t.Run("V1", func(t *testing.T) {
+		var v1 []byte
+		FormatV1.EncodeValue(elems, &v1)
+		var decoded [][]byte
+		FormatV1.DecodeValue(v1, &decoded)
+		if !equalElems(decoded, elems) {
+			t.Errorf("expected %q, got %q", elems, decoded)
+		}
+		if col, ok := FormatV1.Column(v1, 2); !ok || string(col) != "30" {
+			t.Errorf("expected column 2 to be \"30\", got %q (%v)", col, ok)
+		}
+	})
+}
@@ -0,0 +1,134 @@
+package types
+
+import (
+	"cmp"
+
+	"github.com/Johniel/gorelly/errcode"
+)
+
+var (
+	// ErrKindMismatch is returned when encoding a value that is not of the kind of its column.
+	ErrKindMismatch = errcode.New(errcode.DataException, "value does not match the column kind")
+	// ErrColumnCount is returned when a row or tuple has more or fewer values than the schema has columns.
+	ErrColumnCount = errcode.New(errcode.InvalidParameter, "wrong number of values for the schema")
+)
+
+// Column is a named, typed column of a Schema.
+type Column struct {
+	Name string
+	Kind Kind
+}
+
+// Schema gives the names and kinds of the elements of tuples, so that rows are read and built
+// as typed Values rather than as raw bytes that only compare lexically.
+type Schema struct {
+	Columns []Column
+}
+
+func NewSchema(columns ...Column) *Schema {
+	return &Schema{Columns: columns}
+}
+
+// ColumnIndex returns the position of the named column, or -1 if there is none.
+func (s *Schema) ColumnIndex(name string) int {
+	for i, column := range s.Columns {
+		if column.Name == name {
+			return i
+		}
+	}
+	return -1
+}
+
+// Decode decodes the elements of a tuple, one per column. Elements missing at the end of the
+// tuple, e.g. of columns added after it was written, are NULL.
+func (s *Schema) Decode(tup [][]byte) (Row, error) {
+	if len(s.Columns) < len(tup) {
+		return Row{}, ErrColumnCount
+	}
+	values := make([]Value, len(s.Columns))
+	for i, elem := range tup {
+		v, err := DecodeValue(s.Columns[i].Kind, elem)
+		if err != nil {
+			return Row{}, err
+		}
+		values[i] = v
+	}
+	return Row{schema: s, values: values}, nil
+}
+
+// Encode encodes one value per column into a tuple. Each value must be NULL or of the kind
+// of its column.
+func (s *Schema) Encode(values ...Value) ([][]byte, error) {
+	if len(values) != len(s.Columns) {
+		return nil, ErrColumnCount
+	}
+	tup := make([][]byte, len(values))
+	for i, v := range values {
+		if !v.IsNull() && v.kind != s.Columns[i].Kind {
+			return nil, ErrKindMismatch
+		}
+		tup[i] = v.Encode()
+	}
+	return tup, nil
+}
+
+// NewRow returns the row of the values, checked as by Encode.
+func (s *Schema) NewRow(values ...Value) (Row, error) {
+	if _, err := s.Encode(values...); err != nil {
+		return Row{}, err
+	}
+	return Row{schema: s, values: append([]Value(nil), values...)}, nil
+}
+
+// Row is a tuple whose elements are typed by a Schema.
+type Row struct {
+	schema *Schema
+	values []Value
+}
+
+func (r Row) Schema() *Schema {
+	return r.schema
+}
+
+func (r Row) Len() int {
+	return len(r.values)
+}
+
+// At returns the value of the i-th column.
+func (r Row) At(i int) Value {
+	return r.values[i]
+}
+
+// Get returns the value of the named column, or NULL if there is no such column.
+func (r Row) Get(name string) Value {
+	if r.schema == nil {
+		return Null
+	}
+	if i := r.schema.ColumnIndex(name); 0 <= i {
+		return r.values[i]
+	}
+	return Null
+}
+
+func (r Row) Values() []Value {
+	return append([]Value(nil), r.values...)
+}
+
+// Tuple encodes the row back into a tuple.
+func (r Row) Tuple() [][]byte {
+	tup := make([][]byte, len(r.values))
+	for i, v := range r.values {
+		tup[i] = v.Encode()
+	}
+	return tup
+}
+
+// CompareRows compares two rows column by column with Compare; a row sorts after its prefixes.
+func CompareRows(a Row, b Row) int {
+	for i := 0; i < len(a.values) && i < len(b.values); i++ {
+		if c := Compare(a.values[i], b.values[i]); c != 0 {
+			return c
+		}
+	}
+	return cmp.Compare(len(a.values), len(b.values))
+}
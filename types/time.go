@@ -0,0 +1,68 @@
+package types
+
+import (
+	"time"
+
+	"github.com/Johniel/gorelly/clock"
+)
+
+// EncodeTimestamp encodes t as a TIMESTAMP value: microseconds since the Unix epoch in UTC.
+// Timestamps before 1970 sort before later ones.
+func EncodeTimestamp(t time.Time) []byte {
+	return encodeInt64(t.UnixMicro())
+}
+
+// DecodeTimestamp decodes a TIMESTAMP value. The result is in UTC.
+func DecodeTimestamp(b []byte) (time.Time, error) {
+	us, err := decodeInt64(b)
+	if err != nil {
+		return time.Time{}, err
+	}
+	return time.UnixMicro(us).UTC(), nil
+}
+
+// EncodeInterval encodes d as an INTERVAL value with microsecond precision.
+// Negative intervals sort before positive ones.
+func EncodeInterval(d time.Duration) []byte {
+	return encodeInt64(d.Microseconds())
+}
+
+// DecodeInterval decodes an INTERVAL value.
+func DecodeInterval(b []byte) (time.Duration, error) {
+	us, err := decodeInt64(b)
+	if err != nil {
+		return 0, err
+	}
+	return time.Duration(us) * time.Microsecond, nil
+}
+
+// Now returns the current time of c as an encoded TIMESTAMP value (now()).
+func Now(c clock.Clock) []byte {
+	return EncodeTimestamp(c.Now())
+}
+
+// AddInterval returns the encoded TIMESTAMP ts + interval.
+func AddInterval(ts []byte, interval []byte) ([]byte, error) {
+	t, err := decodeInt64(ts)
+	if err != nil {
+		return nil, err
+	}
+	d, err := decodeInt64(interval)
+	if err != nil {
+		return nil, err
+	}
+	return encodeInt64(t + d), nil
+}
+
+// SubtractTimestamps returns the encoded INTERVAL a - b.
+func SubtractTimestamps(a []byte, b []byte) ([]byte, error) {
+	ta, err := decodeInt64(a)
+	if err != nil {
+		return nil, err
+	}
+	tb, err := decodeInt64(b)
+	if err != nil {
+		return nil, err
+	}
+	return encodeInt64(ta - tb), nil
+}
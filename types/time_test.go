@@ -0,0 +1,90 @@
+package types
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"github.com/Johniel/gorelly/clock"
+)
+
+func TestTimestampEncoding(t *testing.T) {
+	times := []time.Time{
+		time.Date(1900, 1, 1, 0, 0, 0, 0, time.UTC),
+		time.Date(1969, 12, 31, 23, 59, 59, 999999000, time.UTC),
+		time.Unix(0, 0).UTC(),
+		time.Date(2024, 2, 29, 12, 30, 0, 123456000, time.UTC),
+		time.Date(2262, 1, 1, 0, 0, 0, 0, time.UTC),
+	}
+	for i, tm := range times {
+		encoded := EncodeTimestamp(tm)
+		decoded, err := DecodeTimestamp(encoded)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !decoded.Equal(tm) {
+			t.Errorf("expected %v, got %v", tm, decoded)
+		}
+		if 0 < i && bytes.Compare(EncodeTimestamp(times[i-1]), encoded) >= 0 {
+			t.Errorf("encoding of %v does not sort after %v", tm, times[i-1])
+		}
+	}
+
+	if _, err := DecodeTimestamp([]byte{1, 2, 3}); err != ErrInvalidEncoding {
+		t.Errorf("expected ErrInvalidEncoding, got %v", err)
+	}
+}
+
+func TestIntervalEncoding(t *testing.T) {
+	intervals := []time.Duration{-48 * time.Hour, -time.Microsecond, 0, time.Microsecond, 90 * time.Minute}
+	for i, d := range intervals {
+		encoded := EncodeInterval(d)
+		decoded, err := DecodeInterval(encoded)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if decoded != d {
+			t.Errorf("expected %v, got %v", d, decoded)
+		}
+		if 0 < i && bytes.Compare(EncodeInterval(intervals[i-1]), encoded) >= 0 {
+			t.Errorf("encoding of %v does not sort after %v", d, intervals[i-1])
+		}
+	}
+}
+
+func TestIntervalArithmetic(t *testing.T) {
+	start := time.Date(2024, 1, 31, 22, 0, 0, 0, time.UTC)
+	ts := EncodeTimestamp(start)
+
+	later, err := AddInterval(ts, EncodeInterval(3*time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+	decoded, err := DecodeTimestamp(later)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected := start.Add(3 * time.Hour); !decoded.Equal(expected) {
+		t.Errorf("expected %v, got %v", expected, decoded)
+	}
+
+	diff, err := SubtractTimestamps(ts, later)
+	if err != nil {
+		t.Fatal(err)
+	}
+	d, err := DecodeInterval(diff)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if d != -3*time.Hour {
+		t.Errorf("expected -3h, got %v", d)
+	}
+
+	now, err := DecodeTimestamp(Now(clock.NewLogical(start)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !now.Equal(start) {
+		t.Errorf("expected Now() to read the clock, got %v", now)
+	}
+}
@@ -0,0 +1,92 @@
+// Package types provides order-preserving encodings for typed column values.
+// Encoded values compare byte-by-byte in the same order as the values they represent,
+// so they can be used directly as B+ tree keys and in range scans. Value and Schema read and
+// build tuples of such encodings as typed values.
+package types
+
+import (
+	"encoding/binary"
+	"math"
+
+	"github.com/Johniel/gorelly/errcode"
+)
+
+var (
+	// ErrInvalidEncoding is returned when decoding bytes that were not produced by the matching encoder.
+	ErrInvalidEncoding = errcode.New(errcode.DataException, "invalid value encoding")
+)
+
+// EncodeInt encodes an INT value. Encoded values sort in numeric order.
+func EncodeInt(v int64) []byte {
+	return encodeInt64(v)
+}
+
+// DecodeInt decodes an INT value.
+func DecodeInt(b []byte) (int64, error) {
+	return decodeInt64(b)
+}
+
+// EncodeFloat encodes a FLOAT value. Encoded values sort in numeric order, with -0 equal to 0
+// and NaN after +Inf.
+func EncodeFloat(v float64) []byte {
+	switch {
+	case v == 0:
+		v = 0 // -0
+	case math.IsNaN(v):
+		v = math.NaN() // NaNs with the sign bit set
+	}
+	bits := math.Float64bits(v)
+	if bits&(1<<63) != 0 {
+		bits = ^bits
+	} else {
+		bits |= 1 << 63
+	}
+	b := make([]byte, 8)
+	binary.BigEndian.PutUint64(b, bits)
+	return b
+}
+
+// DecodeFloat decodes a FLOAT value.
+func DecodeFloat(b []byte) (float64, error) {
+	if len(b) != 8 {
+		return 0, ErrInvalidEncoding
+	}
+	bits := binary.BigEndian.Uint64(b)
+	if bits&(1<<63) != 0 {
+		bits &^= 1 << 63
+	} else {
+		bits = ^bits
+	}
+	return math.Float64frombits(bits), nil
+}
+
+// EncodeBool encodes a BOOL value as a single byte; false sorts before true.
+func EncodeBool(v bool) []byte {
+	if v {
+		return []byte{1}
+	}
+	return []byte{0}
+}
+
+// DecodeBool decodes a BOOL value.
+func DecodeBool(b []byte) (bool, error) {
+	if len(b) != 1 || 1 < b[0] {
+		return false, ErrInvalidEncoding
+	}
+	return b[0] == 1, nil
+}
+
+// encodeInt64 encodes v as 8 big-endian bytes with the sign bit flipped,
+// so that negative values sort before positive ones.
+func encodeInt64(v int64) []byte {
+	b := make([]byte, 8)
+	binary.BigEndian.PutUint64(b, uint64(v)^(1<<63))
+	return b
+}
+
+func decodeInt64(b []byte) (int64, error) {
+	if len(b) != 8 {
+		return 0, ErrInvalidEncoding
+	}
+	return int64(binary.BigEndian.Uint64(b) ^ (1 << 63)), nil
+}
@@ -0,0 +1,257 @@
+package types
+
+import (
+	"bytes"
+	"cmp"
+	"fmt"
+	"math"
+	"strconv"
+	"time"
+)
+
+// Kind is the type of a Value.
+type Kind int
+
+const (
+	KindNull Kind = iota
+	KindInt64
+	KindFloat64
+	KindVarchar
+	KindBlob
+	KindBool
+	KindTimestamp
+	KindInterval
+)
+
+func (k Kind) String() string {
+	switch k {
+	case KindNull:
+		return "NULL"
+	case KindInt64:
+		return "INT"
+	case KindFloat64:
+		return "FLOAT"
+	case KindVarchar:
+		return "VARCHAR"
+	case KindBlob:
+		return "BLOB"
+	case KindBool:
+		return "BOOL"
+	case KindTimestamp:
+		return "TIMESTAMP"
+	case KindInterval:
+		return "INTERVAL"
+	default:
+		return "UNKNOWN"
+	}
+}
+
+// Value is a typed column value, or NULL. The zero Value is NULL.
+// Its accessors return the zero value of their Go type for a Value of another kind.
+type Value struct {
+	kind Kind
+	num  int64   // INT, BOOL (0 or 1), and microseconds of TIMESTAMP and INTERVAL
+	flt  float64 // FLOAT
+	str  string  // VARCHAR and BLOB
+}
+
+// Null is the NULL value.
+var Null = Value{}
+
+func Int64(v int64) Value {
+	return Value{kind: KindInt64, num: v}
+}
+
+func Float64(v float64) Value {
+	return Value{kind: KindFloat64, flt: v}
+}
+
+func Varchar(v string) Value {
+	return Value{kind: KindVarchar, str: v}
+}
+
+func Blob(v []byte) Value {
+	return Value{kind: KindBlob, str: string(v)}
+}
+
+func Bool(v bool) Value {
+	if v {
+		return Value{kind: KindBool, num: 1}
+	}
+	return Value{kind: KindBool}
+}
+
+// Timestamp returns a TIMESTAMP value, with the microsecond precision of its encoding.
+func Timestamp(t time.Time) Value {
+	return Value{kind: KindTimestamp, num: t.UnixMicro()}
+}
+
+// Interval returns an INTERVAL value, with the microsecond precision of its encoding.
+func Interval(d time.Duration) Value {
+	return Value{kind: KindInterval, num: d.Microseconds()}
+}
+
+func (v Value) Kind() Kind {
+	return v.kind
+}
+
+func (v Value) IsNull() bool {
+	return v.kind == KindNull
+}
+
+func (v Value) Int64() int64 {
+	if v.kind != KindInt64 {
+		return 0
+	}
+	return v.num
+}
+
+func (v Value) Float64() float64 {
+	if v.kind != KindFloat64 {
+		return 0
+	}
+	return v.flt
+}
+
+func (v Value) Varchar() string {
+	if v.kind != KindVarchar {
+		return ""
+	}
+	return v.str
+}
+
+func (v Value) Blob() []byte {
+	if v.kind != KindBlob {
+		return nil
+	}
+	return []byte(v.str)
+}
+
+func (v Value) Bool() bool {
+	return v.kind == KindBool && v.num == 1
+}
+
+// Timestamp returns the time of a TIMESTAMP value, in UTC.
+func (v Value) Timestamp() time.Time {
+	if v.kind != KindTimestamp {
+		return time.Time{}
+	}
+	return time.UnixMicro(v.num).UTC()
+}
+
+func (v Value) Interval() time.Duration {
+	if v.kind != KindInterval {
+		return 0
+	}
+	return time.Duration(v.num) * time.Microsecond
+}
+
+// String formats the value for display, e.g. "NULL", "42" or "2024-02-29T12:30:00Z".
+func (v Value) String() string {
+	switch v.kind {
+	case KindInt64:
+		return strconv.FormatInt(v.num, 10)
+	case KindFloat64:
+		return strconv.FormatFloat(v.flt, 'g', -1, 64)
+	case KindVarchar:
+		return v.str
+	case KindBlob:
+		return fmt.Sprintf("%x", v.str)
+	case KindBool:
+		return strconv.FormatBool(v.num == 1)
+	case KindTimestamp:
+		return v.Timestamp().Format(time.RFC3339Nano)
+	case KindInterval:
+		return v.Interval().String()
+	default:
+		return "NULL"
+	}
+}
+
+// Encode returns the order-preserving encoding of the value (see the Encode functions of the
+// package). NULL is encoded as nil, which tuples store as an empty element.
+func (v Value) Encode() []byte {
+	switch v.kind {
+	case KindInt64:
+		return EncodeInt(v.num)
+	case KindFloat64:
+		return EncodeFloat(v.flt)
+	case KindVarchar, KindBlob:
+		return []byte(v.str)
+	case KindBool:
+		return EncodeBool(v.num == 1)
+	case KindTimestamp, KindInterval:
+		return encodeInt64(v.num)
+	default:
+		return nil
+	}
+}
+
+// DecodeValue decodes a value of the given kind. An empty b is NULL, whatever the kind: tuples
+// do not tell an empty element from a NULL one, so an empty VARCHAR or BLOB also reads as NULL.
+func DecodeValue(kind Kind, b []byte) (Value, error) {
+	if len(b) == 0 {
+		return Null, nil
+	}
+	switch kind {
+	case KindInt64:
+		n, err := DecodeInt(b)
+		return Int64(n), err
+	case KindFloat64:
+		f, err := DecodeFloat(b)
+		return Float64(f), err
+	case KindVarchar:
+		return Varchar(string(b)), nil
+	case KindBlob:
+		return Blob(b), nil
+	case KindBool:
+		t, err := DecodeBool(b)
+		return Bool(t), err
+	case KindTimestamp, KindInterval:
+		n, err := decodeInt64(b)
+		return Value{kind: kind, num: n}, err
+	default:
+		return Null, ErrInvalidEncoding
+	}
+}
+
+// Compare returns -1, 0 or 1 as a sorts before, with or after b. Values of a kind sort as
+// their encodings do; INT and FLOAT values are compared numerically with each other. NULL sorts
+// first, and values of other different kinds sort by kind.
+func Compare(a Value, b Value) int {
+	switch {
+	case a.kind == b.kind:
+		switch a.kind {
+		case KindNull:
+			return 0
+		case KindFloat64:
+			return bytes.Compare(EncodeFloat(a.flt), EncodeFloat(b.flt))
+		case KindVarchar, KindBlob:
+			return cmp.Compare(a.str, b.str)
+		default:
+			return cmp.Compare(a.num, b.num)
+		}
+	case a.kind == KindInt64 && b.kind == KindFloat64:
+		return compareIntFloat(a.num, b.flt)
+	case a.kind == KindFloat64 && b.kind == KindInt64:
+		return -compareIntFloat(b.num, a.flt)
+	default:
+		return cmp.Compare(a.kind, b.kind)
+	}
+}
+
+// compareIntFloat compares an INT with a FLOAT exactly, with NaN after every number.
+func compareIntFloat(n int64, f float64) int {
+	switch {
+	case math.IsNaN(f) || 1<<63 <= f:
+		return -1
+	case f < -(1 << 63):
+		return 1
+	}
+	// f is in the range of int64: compare the integer parts, then the fraction
+	i := int64(f)
+	if c := cmp.Compare(n, i); c != 0 {
+		return c
+	}
+	return cmp.Compare(0, f-float64(i))
+}
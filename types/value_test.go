@@ -0,0 +1,155 @@
+package types
+
+import (
+	"bytes"
+	"math"
+	"testing"
+	"time"
+)
+
+func TestFloatEncoding(t *testing.T) {
+	floats := []float64{math.Inf(-1), -math.MaxFloat64, -1.5, -math.SmallestNonzeroFloat64, 0, math.SmallestNonzeroFloat64, 0.25, 1, 1e300, math.Inf(1), math.NaN()}
+	for i, f := range floats {
+		encoded := EncodeFloat(f)
+		decoded, err := DecodeFloat(encoded)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if decoded != f && !(math.IsNaN(f) && math.IsNaN(decoded)) {
+			t.Errorf("expected %v, got %v", f, decoded)
+		}
+		if 0 < i && bytes.Compare(EncodeFloat(floats[i-1]), encoded) >= 0 {
+			t.Errorf("encoding of %v does not sort after %v", f, floats[i-1])
+		}
+	}
+	if !bytes.Equal(EncodeFloat(math.Copysign(0, -1)), EncodeFloat(0)) {
+		t.Error("expected -0 to encode as 0")
+	}
+	if !bytes.Equal(EncodeFloat(-math.NaN()), EncodeFloat(math.NaN())) {
+		t.Error("expected every NaN to encode the same")
+	}
+	if _, err := DecodeFloat([]byte{1, 2, 3}); err != ErrInvalidEncoding {
+		t.Errorf("expected ErrInvalidEncoding, got %v", err)
+	}
+}
+
+func TestBoolEncoding(t *testing.T) {
+	if bytes.Compare(EncodeBool(false), EncodeBool(true)) >= 0 {
+		t.Error("expected false to sort before true")
+	}
+	for _, v := range []bool{false, true} {
+		if decoded, err := DecodeBool(EncodeBool(v)); err != nil || decoded != v {
+			t.Errorf("expected %v, got %v (%v)", v, decoded, err)
+		}
+	}
+	if _, err := DecodeBool([]byte{2}); err != ErrInvalidEncoding {
+		t.Errorf("expected ErrInvalidEncoding, got %v", err)
+	}
+}
+
+func TestValue(t *testing.T) {
+	created := time.Date(2024, 2, 29, 12, 30, 0, 123456000, time.UTC)
+	values := []Value{
+		Int64(-7), Float64(2.5), Varchar("alice"), Blob([]byte{0, 1}), Bool(true),
+		Timestamp(created), Interval(90 * time.Minute),
+	}
+	for _, v := range values {
+		decoded, err := DecodeValue(v.Kind(), v.Encode())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if Compare(v, decoded) != 0 {
+			t.Errorf("expected %v, got %v", v, decoded)
+		}
+	}
+	if !values[5].Timestamp().Equal(created) || values[6].Interval() != 90*time.Minute {
+		t.Errorf("unexpected time values %v and %v", values[5], values[6])
+	}
+	if values[0].Float64() != 0 || values[1].Int64() != 0 {
+		t.Error("expected the accessors of another kind to return zero")
+	}
+
+	if v, err := DecodeValue(KindInt64, nil); err != nil || !v.IsNull() {
+		t.Errorf("expected an empty element to decode as NULL, got %v (%v)", v, err)
+	}
+	if Null.Encode() != nil || Null.String() != "NULL" {
+		t.Error("expected NULL to encode as nil")
+	}
+	if _, err := DecodeValue(KindInt64, []byte("30")); err != ErrInvalidEncoding {
+		t.Errorf("expected ErrInvalidEncoding, got %v", err)
+	}
+}
+
+func TestCompare(t *testing.T) {
+	tests := []struct {
+		a, b     Value
+		expected int
+	}{
+		{Int64(9), Int64(30), -1},
+		{Varchar("9"), Varchar("30"), 1},
+		{Int64(30), Float64(30), 0},
+		{Int64(30), Float64(29.5), 1},
+		{Float64(-0.5), Int64(0), -1},
+		{Int64(-1), Float64(-1.5), 1},
+		{Int64(math.MaxInt64), Float64(math.Inf(1)), -1},
+		{Float64(math.NaN()), Int64(math.MaxInt64), 1},
+		{Null, Int64(math.MinInt64), -1},
+		{Null, Null, 0},
+		{Bool(false), Bool(true), -1},
+		{Timestamp(time.Unix(0, 0)), Timestamp(time.Unix(-1, 0)), 1},
+	}
+	for _, tt := range tests {
+		if got := Compare(tt.a, tt.b); got != tt.expected {
+			t.Errorf("Compare(%v, %v): expected %d, got %d", tt.a, tt.b, tt.expected, got)
+		}
+		if got := Compare(tt.b, tt.a); got != -tt.expected {
+			t.Errorf("Compare(%v, %v): expected %d, got %d", tt.b, tt.a, -tt.expected, got)
+		}
+	}
+}
+
+func TestSchema(t *testing.T) {
+	schema := NewSchema(
+		Column{Name: "id", Kind: KindInt64},
+		Column{Name: "name", Kind: KindVarchar},
+		Column{Name: "score", Kind: KindFloat64},
+		Column{Name: "active", Kind: KindBool},
+	)
+	tup, err := schema.Encode(Int64(1), Varchar("alice"), Null, Bool(true))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if tup[2] != nil {
+		t.Errorf("expected NULL to encode as nil, got %x", tup[2])
+	}
+	if _, err := schema.Encode(Int64(1), Varchar("alice"), Int64(3), Bool(true)); err != ErrKindMismatch {
+		t.Errorf("expected ErrKindMismatch, got %v", err)
+	}
+	if _, err := schema.Encode(Int64(1)); err != ErrColumnCount {
+		t.Errorf("expected ErrColumnCount, got %v", err)
+	}
+
+	// The last element is missing, as in a row written before the column was added
+	row, err := schema.Decode(tup[:3])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if row.Len() != 4 || row.Get("id").Int64() != 1 || row.Get("name").Varchar() != "alice" {
+		t.Errorf("unexpected row %v", row.Values())
+	}
+	if !row.At(2).IsNull() || !row.Get("active").IsNull() || !row.Get("missing").IsNull() {
+		t.Errorf("expected NULL values, got %v", row.Values())
+	}
+	if _, err := schema.Decode(append(tup, nil)); err != ErrColumnCount {
+		t.Errorf("expected ErrColumnCount, got %v", err)
+	}
+
+	a, _ := schema.NewRow(Int64(9), Varchar("b"), Float64(1), Bool(false))
+	b, _ := schema.NewRow(Int64(30), Varchar("a"), Float64(1), Bool(false))
+	if CompareRows(a, b) != -1 {
+		t.Error("expected the row with id 9 to sort before the row with id 30")
+	}
+	if !bytes.Equal(a.Tuple()[0], EncodeInt(9)) {
+		t.Errorf("unexpected tuple %x", a.Tuple())
+	}
+}
@@ -0,0 +1,289 @@
+// Package vacuum schedules background maintenance (vacuum/compaction) of tables.
+// The scheduler watches per-table DML counters and runs a table's maintenance work
+// once it has accumulated enough dead tuples or fragmentation, limiting how much
+// maintenance runs at a time so that foreground work is not stalled.
+//
+// Maintenance can also run incrementally: a target with a Step does a pass in steps limited
+// by the scheduler's quota, one step per check, and the scheduler persists where the pass
+// stopped so that it resumes there, even after a restart.
+package vacuum
+
+import (
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/Johniel/gorelly/clock"
+	"github.com/Johniel/gorelly/errcode"
+	"github.com/Johniel/gorelly/table"
+)
+
+var (
+	// ErrTargetExists is returned when registering a target with a name that is already registered.
+	ErrTargetExists = errcode.New(errcode.AlreadyExists, "vacuum target already registered")
+	// ErrTargetNotFound is returned when no target is registered under the given name.
+	ErrTargetNotFound = errcode.New(errcode.NotFound, "vacuum target not found")
+)
+
+// Thresholds specifies when a table needs maintenance.
+// A zero field disables the corresponding check.
+type Thresholds struct {
+	DeadTuples    uint64  // Rows updated or deleted since the last run
+	Fragmentation float64 // Fraction of wasted space reported by Target.Fragmentation (0 to 1)
+}
+
+// Quota limits the work of one step of an incremental pass. A zero field means no limit.
+type Quota struct {
+	Pages    uint64        // Pages a step may visit
+	Duration time.Duration // Time a step may take
+}
+
+// Budget tracks the work of a step against the quota of the scheduler.
+type Budget struct {
+	quota Quota
+	clock clock.Clock
+	start time.Time
+}
+
+// Exhausted reports whether a step that visited pages so far has used up its quota,
+// e.g. as the stop function of table.VersionStore.PruneFrom.
+func (b *Budget) Exhausted(pages uint64) bool {
+	if 0 < b.quota.Pages && b.quota.Pages <= pages {
+		return true
+	}
+	return 0 < b.quota.Duration && b.quota.Duration <= b.clock.Now().Sub(b.start)
+}
+
+// ProgressStore persists the progress of incremental passes, e.g. catalog.CatalogManager.
+type ProgressStore interface {
+	// VacuumProgress returns the saved progress of a target, nil if it has none.
+	VacuumProgress(name string) ([]byte, error)
+	// SetVacuumProgress saves the progress of a target; nil removes it.
+	SetVacuumProgress(name string, progress []byte) error
+}
+
+// Target is a table maintained by the scheduler.
+type Target struct {
+	Name          string                  // Unique name of the target (usually the table name)
+	Counters      *table.DMLCounters      // DML counters of the table
+	Thresholds    Thresholds              // When to run Vacuum
+	Fragmentation func() (float64, error) // Optional: measures the fraction of wasted space
+	Vacuum        func() error            // Maintenance work for the table
+	// Optional: does the maintenance incrementally instead of Vacuum. A step continues the pass
+	// from progress (nil to start one), works until budget is exhausted, and returns the progress
+	// to continue from, nil once the pass is complete.
+	Step     func(budget *Budget, progress []byte) ([]byte, error)
+	progress []byte // Progress of the pass in progress (nil if none)
+	passDead uint64 // Dead tuple count observed when the pass in progress started
+	lastDead uint64 // Dead tuple count observed at the last successful run or completed pass
+}
+
+func (t *Target) deadTuples() uint64 {
+	return t.Counters.Updates.Load() + t.Counters.Deletes.Load()
+}
+
+// due reports whether the target crossed one of its thresholds, and how many dead tuples it has.
+// A pass in progress is always due.
+func (t *Target) due() (bool, uint64, error) {
+	dead := t.deadTuples() - t.lastDead
+	if t.progress != nil {
+		return true, dead, nil
+	}
+	if 0 < t.Thresholds.DeadTuples && t.Thresholds.DeadTuples <= dead {
+		return true, dead, nil
+	}
+	if 0 < t.Thresholds.Fragmentation && t.Fragmentation != nil {
+		fragmentation, err := t.Fragmentation()
+		if err != nil {
+			return false, dead, err
+		}
+		if t.Thresholds.Fragmentation <= fragmentation {
+			return true, dead, nil
+		}
+	}
+	return false, dead, nil
+}
+
+// Scheduler periodically checks the registered targets and runs the maintenance of those that are due.
+type Scheduler struct {
+	interval           time.Duration
+	maxRunsPerInterval int
+	onError            func(name string, err error)
+	quota              Quota
+	progress           ProgressStore // Persists the progress of incremental passes (nil if none)
+	targets            map[string]*Target
+	clock              clock.Clock
+	stop               func() // Stops the periodic checks (nil when not started)
+	mu                 sync.Mutex
+}
+
+// NewScheduler creates a scheduler that checks its targets every interval and runs at most
+// maxRunsPerInterval maintenance jobs per check (0 means no limit).
+// onError is called for failed checks and jobs; it may be nil.
+func NewScheduler(interval time.Duration, maxRunsPerInterval int, onError func(name string, err error)) *Scheduler {
+	return &Scheduler{
+		interval:           interval,
+		maxRunsPerInterval: maxRunsPerInterval,
+		onError:            onError,
+		targets:            make(map[string]*Target),
+		clock:              clock.System,
+	}
+}
+
+func (s *Scheduler) Register(target *Target) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.targets[target.Name]; ok {
+		return ErrTargetExists
+	}
+	target.lastDead = target.deadTuples()
+	target.passDead = target.lastDead
+	if target.Step != nil && s.progress != nil {
+		progress, err := s.progress.VacuumProgress(target.Name)
+		if err != nil {
+			return err
+		}
+		if len(progress) != 0 {
+			target.progress = progress
+		}
+	}
+	s.targets[target.Name] = target
+	return nil
+}
+
+func (s *Scheduler) Unregister(name string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.targets[name]; !ok {
+		return ErrTargetNotFound
+	}
+	delete(s.targets, name)
+	return nil
+}
+
+// RunOnce checks every target and runs the maintenance of the due ones,
+// most dead tuples first, up to the per-interval limit. Incremental targets run one step.
+// It returns the names of the targets whose maintenance or step ran successfully.
+func (s *Scheduler) RunOnce() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	type candidate struct {
+		target *Target
+		dead   uint64
+	}
+	var candidates []candidate
+	for name, target := range s.targets {
+		due, dead, err := target.due()
+		if err != nil {
+			s.reportError(name, err)
+			continue
+		}
+		if due {
+			candidates = append(candidates, candidate{target: target, dead: dead})
+		}
+	}
+	sort.Slice(candidates, func(i, j int) bool {
+		if candidates[i].dead != candidates[j].dead {
+			return candidates[i].dead > candidates[j].dead
+		}
+		return candidates[i].target.Name < candidates[j].target.Name
+	})
+	if 0 < s.maxRunsPerInterval && s.maxRunsPerInterval < len(candidates) {
+		candidates = candidates[:s.maxRunsPerInterval]
+	}
+
+	var ran []string
+	for _, c := range candidates {
+		// Read the counters before running so that changes made during the run count towards the next one
+		dead := c.target.deadTuples()
+		if c.target.Step != nil {
+			if err := s.step(c.target, dead); err != nil {
+				s.reportError(c.target.Name, err)
+				continue
+			}
+		} else {
+			if err := c.target.Vacuum(); err != nil {
+				s.reportError(c.target.Name, err)
+				continue
+			}
+			c.target.lastDead = dead
+		}
+		ran = append(ran, c.target.Name)
+	}
+	return ran
+}
+
+// step runs one step of the pass of an incremental target, starting a pass if there is none,
+// and saves where it stopped. dead is the dead tuple count of the target before the step.
+func (s *Scheduler) step(target *Target, dead uint64) error {
+	if target.progress == nil {
+		target.passDead = dead
+	}
+	budget := &Budget{quota: s.quota, clock: s.clock, start: s.clock.Now()}
+	progress, err := target.Step(budget, target.progress)
+	if err != nil {
+		return err
+	}
+	if len(progress) == 0 {
+		progress = nil
+		target.lastDead = target.passDead
+	}
+	target.progress = progress
+	if s.progress != nil {
+		return s.progress.SetVacuumProgress(target.Name, progress)
+	}
+	return nil
+}
+
+// SetQuota sets the quota of every step of incremental targets. The zero Quota, the default,
+// lets a step run the whole pass.
+func (s *Scheduler) SetQuota(q Quota) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.quota = q
+}
+
+// SetProgressStore sets where the progress of incremental passes is persisted. Targets registered
+// afterwards resume the pass saved there. It must be called before Register.
+func (s *Scheduler) SetProgressStore(store ProgressStore) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.progress = store
+}
+
+// SetClock sets the clock driving the periodic checks. With a clock.Logical the checks run
+// synchronously inside Advance, which makes the scheduler deterministic in tests.
+// It must be called before Start.
+func (s *Scheduler) SetClock(c clock.Clock) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.clock = c
+}
+
+// Start runs RunOnce every interval until Stop is called.
+func (s *Scheduler) Start() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.stop != nil {
+		return
+	}
+	s.stop = s.clock.Every(s.interval, func() { s.RunOnce() })
+}
+
+// Stop stops the periodic checks and waits for a running check to finish.
+func (s *Scheduler) Stop() {
+	s.mu.Lock()
+	stop := s.stop
+	s.stop = nil
+	s.mu.Unlock()
+	if stop != nil {
+		stop()
+	}
+}
+
+func (s *Scheduler) reportError(name string, err error) {
+	if s.onError != nil {
+		s.onError(name, err)
+	}
+}
@@ -0,0 +1,289 @@
+package vacuum
+
+import (
+	"errors"
+	"reflect"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/Johniel/gorelly/clock"
+	"github.com/Johniel/gorelly/table"
+)
+
+func TestSchedulerThresholds(t *testing.T) {
+	s := NewScheduler(time.Hour, 0, nil)
+
+	var runs int
+	counters := &table.DMLCounters{}
+	counters.Deletes.Add(100) // Changes made before registration do not count
+	target := &Target{
+		Name:       "users",
+		Counters:   counters,
+		Thresholds: Thresholds{DeadTuples: 10},
+		Vacuum: func() error {
+			runs++
+			return nil
+		},
+	}
+	if err := s.Register(target); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Register(target); err != ErrTargetExists {
+		t.Errorf("expected ErrTargetExists, got %v", err)
+	}
+
+	if ran := s.RunOnce(); len(ran) != 0 {
+		t.Errorf("expected nothing to run, got %v", ran)
+	}
+
+	counters.Updates.Add(6)
+	counters.Deletes.Add(3)
+	counters.Inserts.Add(50) // Inserts leave no dead tuples
+	if ran := s.RunOnce(); len(ran) != 0 {
+		t.Errorf("expected nothing to run below threshold, got %v", ran)
+	}
+
+	counters.Deletes.Add(1)
+	if ran := s.RunOnce(); !reflect.DeepEqual(ran, []string{"users"}) {
+		t.Errorf("expected users to run, got %v", ran)
+	}
+	if ran := s.RunOnce(); len(ran) != 0 {
+		t.Errorf("expected counters to be consumed by the run, got %v", ran)
+	}
+	if runs != 1 {
+		t.Errorf("expected 1 run, got %d", runs)
+	}
+}
+
+func TestSchedulerFragmentation(t *testing.T) {
+	s := NewScheduler(time.Hour, 0, nil)
+
+	fragmentation := 0.1
+	target := &Target{
+		Name:          "events",
+		Counters:      &table.DMLCounters{},
+		Thresholds:    Thresholds{Fragmentation: 0.5},
+		Fragmentation: func() (float64, error) { return fragmentation, nil },
+		Vacuum: func() error {
+			fragmentation = 0
+			return nil
+		},
+	}
+	if err := s.Register(target); err != nil {
+		t.Fatal(err)
+	}
+	if ran := s.RunOnce(); len(ran) != 0 {
+		t.Errorf("expected nothing to run, got %v", ran)
+	}
+	fragmentation = 0.6
+	if ran := s.RunOnce(); !reflect.DeepEqual(ran, []string{"events"}) {
+		t.Errorf("expected events to run, got %v", ran)
+	}
+}
+
+func TestSchedulerRateLimit(t *testing.T) {
+	var errs []string
+	s := NewScheduler(time.Hour, 2, func(name string, err error) {
+		errs = append(errs, name)
+	})
+
+	newTarget := func(name string, dead uint64, vacuumErr error) *Target {
+		target := &Target{
+			Name:       name,
+			Counters:   &table.DMLCounters{},
+			Thresholds: Thresholds{DeadTuples: 1},
+			Vacuum:     func() error { return vacuumErr },
+		}
+		if err := s.Register(target); err != nil {
+			t.Fatal(err)
+		}
+		target.Counters.Deletes.Add(dead)
+		return target
+	}
+	newTarget("a", 5, nil)
+	newTarget("b", 20, nil)
+	newTarget("c", 10, errors.New("disk full"))
+
+	// Only the two targets with the most dead tuples run; c fails
+	if ran := s.RunOnce(); !reflect.DeepEqual(ran, []string{"b"}) {
+		t.Errorf("expected b to run, got %v", ran)
+	}
+	if !reflect.DeepEqual(errs, []string{"c"}) {
+		t.Errorf("expected an error for c, got %v", errs)
+	}
+	// c is retried and a gets its turn
+	if ran := s.RunOnce(); !reflect.DeepEqual(ran, []string{"a"}) {
+		t.Errorf("expected a to run, got %v", ran)
+	}
+
+	if err := s.Unregister("c"); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Unregister("c"); err != ErrTargetNotFound {
+		t.Errorf("expected ErrTargetNotFound, got %v", err)
+	}
+}
+
+func TestSchedulerBackground(t *testing.T) {
+	s := NewScheduler(time.Millisecond, 0, nil)
+
+	var runs atomic.Int32
+	target := &Target{
+		Name:       "logs",
+		Counters:   &table.DMLCounters{},
+		Thresholds: Thresholds{DeadTuples: 1},
+		Vacuum: func() error {
+			runs.Add(1)
+			return nil
+		},
+	}
+	if err := s.Register(target); err != nil {
+		t.Fatal(err)
+	}
+	target.Counters.Updates.Add(1)
+
+	s.Start()
+	deadline := time.Now().Add(time.Second)
+	for runs.Load() == 0 && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+	s.Stop()
+	if runs.Load() != 1 {
+		t.Errorf("expected exactly one background run, got %d", runs.Load())
+	}
+}
+
+func TestSchedulerLogicalClock(t *testing.T) {
+	c := clock.NewLogical(time.Unix(0, 0))
+	s := NewScheduler(time.Minute, 0, nil)
+	s.SetClock(c)
+
+	runs := 0
+	target := &Target{
+		Name:       "logs",
+		Counters:   &table.DMLCounters{},
+		Thresholds: Thresholds{DeadTuples: 1},
+		Vacuum: func() error {
+			runs++
+			return nil
+		},
+	}
+	if err := s.Register(target); err != nil {
+		t.Fatal(err)
+	}
+	s.Start()
+	defer s.Stop()
+
+	target.Counters.Deletes.Add(1)
+	c.Advance(59 * time.Second)
+	if runs != 0 {
+		t.Errorf("expected no run before the interval elapsed, got %d", runs)
+	}
+	// Checks run synchronously inside Advance
+	c.Advance(time.Second)
+	if runs != 1 {
+		t.Errorf("expected one run after the interval, got %d", runs)
+	}
+	target.Counters.Deletes.Add(1)
+	s.Stop()
+	c.Advance(time.Hour)
+	if runs != 1 {
+		t.Errorf("expected no run after Stop, got %d", runs)
+	}
+}
+
+type memoryProgressStore map[string][]byte
+
+func (m memoryProgressStore) VacuumProgress(name string) ([]byte, error) {
+	return m[name], nil
+}
+
+func (m memoryProgressStore) SetVacuumProgress(name string, progress []byte) error {
+	if progress == nil {
+		delete(m, name)
+	} else {
+		m[name] = progress
+	}
+	return nil
+}
+
+func TestSchedulerIncremental(t *testing.T) {
+	const numPages = 10
+	store := memoryProgressStore{}
+	c := clock.NewLogical(time.Unix(0, 0))
+	var visited []int
+	// newTarget registers a target that visits numPages pages, with a new scheduler
+	newTarget := func() (*Scheduler, *Target) {
+		s := NewScheduler(time.Minute, 0, nil)
+		s.SetClock(c)
+		s.SetQuota(Quota{Pages: 4})
+		s.SetProgressStore(store)
+		target := &Target{
+			Name:       "events",
+			Counters:   &table.DMLCounters{},
+			Thresholds: Thresholds{DeadTuples: 10},
+			Step: func(budget *Budget, progress []byte) ([]byte, error) {
+				page := 0
+				if progress != nil {
+					page = int(progress[0])
+				}
+				for pages := uint64(0); page < numPages && !budget.Exhausted(pages); pages++ {
+					visited = append(visited, page)
+					page++
+				}
+				if page == numPages {
+					return nil, nil
+				}
+				return []byte{byte(page)}, nil
+			},
+		}
+		if err := s.Register(target); err != nil {
+			t.Fatal(err)
+		}
+		return s, target
+	}
+
+	s, target := newTarget()
+	target.Counters.Deletes.Add(10)
+	if ran := s.RunOnce(); !reflect.DeepEqual(ran, []string{"events"}) {
+		t.Errorf("expected events to run, got %v", ran)
+	}
+	if !reflect.DeepEqual(visited, []int{0, 1, 2, 3}) || !reflect.DeepEqual(store["events"], []byte{4}) {
+		t.Errorf("expected the first step to stop after 4 pages, visited %v, saved %v", visited, store["events"])
+	}
+
+	// A restarted scheduler resumes the pass, which stays due below the thresholds
+	s, _ = newTarget()
+	visited = nil
+	if ran := s.RunOnce(); !reflect.DeepEqual(ran, []string{"events"}) {
+		t.Errorf("expected the pass to resume, got %v", ran)
+	}
+	if ran := s.RunOnce(); !reflect.DeepEqual(ran, []string{"events"}) {
+		t.Errorf("expected the pass to go on, got %v", ran)
+	}
+	if !reflect.DeepEqual(visited, []int{4, 5, 6, 7, 8, 9}) {
+		t.Errorf("expected the rest of the pages, got %v", visited)
+	}
+	if _, ok := store["events"]; ok {
+		t.Errorf("expected the progress of the completed pass to be removed, got %v", store["events"])
+	}
+	if ran := s.RunOnce(); len(ran) != 0 {
+		t.Errorf("expected nothing to run after the pass, got %v", ran)
+	}
+}
+
+func TestBudgetDuration(t *testing.T) {
+	c := clock.NewLogical(time.Unix(0, 0))
+	budget := &Budget{quota: Quota{Duration: time.Second}, clock: c, start: c.Now()}
+	if budget.Exhausted(1000) {
+		t.Error("expected the budget to last for a second")
+	}
+	c.Advance(time.Second)
+	if !budget.Exhausted(0) {
+		t.Error("expected the budget to be exhausted after a second")
+	}
+	if (&Budget{clock: c, start: c.Now()}).Exhausted(1 << 40) {
+		t.Error("expected the zero quota not to limit a step")
+	}
+}